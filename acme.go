@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// --- ACME (Let's Encrypt) certificates ---
+//
+// golang.org/x/crypto/acme/autocert obtains a certificate per host on the
+// first TLS handshake that asks for it, answering the tls-alpn-01 challenge
+// (RFC 8737) on the HTTPS listener itself, so no port 80 is needed. Keys and
+// certificates are cached in a directory and renewed in the background 30
+// days before they expire.
+
+const renewBefore = 30 * 24 * time.Hour
+
+// NewACMEManager returns a manager of certificates for hosts from the ACME
+// CA at directoryURL (Let's Encrypt if empty), cached in cacheDir.
+func NewACMEManager(hosts []string, email, cacheDir, directoryURL string) (*autocert.Manager, error) {
+	if directoryURL == "" {
+		directoryURL = autocert.DefaultACMEDirectory
+	}
+	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+		return nil, err
+	}
+	lower := make([]string, len(hosts))
+	for i, h := range hosts {
+		lower[i] = strings.ToLower(h)
+	}
+	return &autocert.Manager{
+		Prompt:      autocert.AcceptTOS,
+		Cache:       autocert.DirCache(cacheDir),
+		HostPolicy:  autocert.HostWhitelist(lower...),
+		RenewBefore: renewBefore,
+		Email:       email,
+		Client:      &acme.Client{DirectoryURL: directoryURL},
+	}, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestACMEManager(t *testing.T) {
+	ca := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("the CA was asked for %s", r.URL)
+		http.NotFound(w, r)
+	}))
+	defer ca.Close()
+
+	cacheDir := filepath.Join(t.TempDir(), "certs")
+	m, err := NewACMEManager([]string{"API.example.com"}, "ops@example.com", cacheDir, ca.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(cacheDir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("cache directory: %v, %v", info, err)
+	}
+
+	ctx := context.Background()
+	if err := m.HostPolicy(ctx, "api.example.com"); err != nil {
+		t.Errorf("listed host refused: %v", err)
+	}
+	if err := m.HostPolicy(ctx, "other.example.com"); err == nil {
+		t.Error("other host allowed")
+	}
+
+	c := m.TLSConfig()
+	if !slices.Contains(c.NextProtos, acme.ALPNProto) {
+		t.Errorf("NextProtos %q lack %q, so tls-alpn-01 challenges fail", c.NextProtos, acme.ALPNProto)
+	}
+	_, err = c.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	if err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("certificate for another host: %v", err)
+	}
+}
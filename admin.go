@@ -0,0 +1,419 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// --- Runtime flags ---
+//
+// Maintenance mode and the feature flags below can be flipped at runtime
+// from the admin dashboard (/admin) or PUT /admin/flags. They start from
+// their environment variables and are not persisted.
+
+// featureEnv maps each feature flag to the env var that sets its default.
+var featureEnv = map[string]string{
+	"debug_echo":      "DEBUG_ECHO",
+	"local_fallback":  "LOCAL_FALLBACK",
+	"plain_medical":   "ENABLE_PLAIN_MEDICAL",
+	"privacy_mode":    "PRIVACY_MODE",
+	"redact_pii":      "REDACT_PII",
+	"semantic_search": "SEMANTIC_SEARCH",
+}
+
+var features = &Features{enabled: map[string]bool{}}
+
+type Features struct {
+	mu          sync.RWMutex
+	maintenance bool
+	enabled     map[string]bool
+}
+
+// FeatureState is the body of GET and PUT /admin/flags. On PUT, omitted
+// fields are left unchanged.
+type FeatureState struct {
+	Maintenance *bool           `json:"maintenance,omitempty"`
+	Flags       map[string]bool `json:"flags,omitempty"`
+}
+
+// LoadEnv sets every flag from its environment variable.
+func (f *Features) LoadEnv() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, env := range featureEnv {
+		f.enabled[name] = envBool(env)
+	}
+}
+
+func (f *Features) Enabled(name string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.enabled[name]
+}
+
+func (f *Features) Maintenance() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.maintenance
+}
+
+func (f *Features) State() FeatureState {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	m := f.maintenance
+	s := FeatureState{Maintenance: &m, Flags: map[string]bool{}}
+	for name, on := range f.enabled {
+		s.Flags[name] = on
+	}
+	return s
+}
+
+// Update applies the fields set in s, rejecting unknown flags.
+func (f *Features) Update(s FeatureState) error {
+	for name := range s.Flags {
+		if _, ok := featureEnv[name]; !ok {
+			return fmt.Errorf("unknown flag %q", name)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s.Maintenance != nil {
+		f.maintenance = *s.Maintenance
+	}
+	for name, on := range s.Flags {
+		f.enabled[name] = on
+	}
+	return nil
+}
+
+// withMaintenance answers 503 while maintenance mode is on, except for
+// probes, the spec and the admin endpoints needed to turn it off again.
+func withMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		exempt := p == "/health" || p == "/readyz" || p == "/openapi.json" || p == "/prompts" || strings.HasPrefix(p, "/admin/")
+		if features.Maintenance() && !exempt {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "down for maintenance, try again later", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withFeature serves h only while the flag is on, and 404 otherwise.
+func withFeature(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !features.Enabled(name) {
+			http.NotFound(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// --- Admin handlers ---
+
+// AdminStats is everything the dashboard shows.
+type AdminStats struct {
+	MetricsSnapshot
+	Jobs      JobStats       `json:"jobs"`
+	Features  FeatureState   `json:"features"`
+	Admission AdmissionStats `json:"admission"`
+}
+
+// adminStatsHandler serves GET /admin/stats; ?aggregate=private (or
+// USAGE_AGGREGATION=private) aggregates the metrics privately.
+func adminStatsHandler(jobs *JobStore, policies *Policies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snap := metrics.Snapshot()
+		if metrics.PrivateOnly() || r.URL.Query().Get("aggregate") == "private" {
+			snap = metrics.Private(snap)
+		}
+		writeJSON(w, http.StatusOK, AdminStats{
+			MetricsSnapshot: snap,
+			Jobs:            jobs.Stats(),
+			Features:        features.State(),
+			Admission:       policies.Status(),
+		})
+	}
+}
+
+// adminFlagsHandler serves GET and PUT /admin/flags.
+func adminFlagsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var s FeatureState
+		if !decodeJSON(w, r, &s) {
+			return
+		}
+		if err := features.Update(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, features.State())
+}
+
+// --- Admin dashboard (vanilla, no frameworks) ---
+
+// adminPageHandler serves the dashboard page. The page itself is public
+// (unless SAML is on); it asks for the admin token, if the caller isn't
+// signed in as an admin, and sends it with every API call, so the data
+// stays behind withAdmin.
+func adminPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(adminHTML))
+}
+
+const adminHTML = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <title>AI Text Tools – Admin</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1" />
+  <style>
+    body { font-family: system-ui, -apple-system, BlinkMacSystemFont, sans-serif; margin: 0; padding: 2rem; background: #f5f5f5; }
+    .container { max-width: 1000px; margin: 0 auto; }
+    h1 { margin-top: 0; }
+    .grid { display: grid; grid-template-columns: repeat(auto-fit, minmax(220px, 1fr)); gap: 1rem; margin-bottom: 1rem; }
+    .card { background: #fff; padding: 1rem 1.25rem; border-radius: 8px; box-shadow: 0 2px 8px rgba(0,0,0,0.05); margin-bottom: 1rem; }
+    .card h2 { font-size: 1rem; margin: 0 0 0.5rem; color: #555; }
+    .big { font-size: 1.6rem; font-weight: 600; }
+    table { width: 100%; border-collapse: collapse; font-size: 0.9rem; }
+    th, td { text-align: left; padding: 0.3rem 0.5rem; border-bottom: 1px solid #eee; }
+    td.num, th.num { text-align: right; }
+    .bar { height: 8px; background: #eee; border-radius: 4px; overflow: hidden; margin-top: 0.5rem; }
+    .bar div { height: 100%; background: #2563eb; }
+    .bar div.over { background: #b91c1c; }
+    .err { color: #b91c1c; }
+    .muted { color: #777; font-size: 0.85rem; }
+    label { display: block; margin: 0.3rem 0; }
+    #maintenance-banner { display: none; background: #fef3c7; padding: 0.5rem 1rem; border-radius: 6px; margin-bottom: 1rem; }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <h1>Admin</h1>
+    <p class="muted">Up <span id="uptime">–</span> · refreshes every 5s · <a href="/">back to the tools</a> · <a href="#" id="logout">forget token</a></p>
+    <div id="maintenance-banner">Maintenance mode is on: the API answers 503.</div>
+    <p id="error" class="err"></p>
+
+    <div class="grid">
+      <div class="card"><h2>LLM calls</h2><div class="big" id="llm-calls">–</div><div class="muted" id="llm-errors"></div></div>
+      <div class="card"><h2>Tokens today</h2><div class="big" id="budget-used">–</div><div class="muted" id="budget-limit"></div><div class="bar"><div id="budget-bar" style="width:0"></div></div></div>
+      <div class="card"><h2>Job queue</h2><div class="big" id="queue-depth">–</div><div class="muted" id="job-counts"></div></div>
+    </div>
+
+    <div class="card">
+      <h2>Maintenance &amp; feature flags</h2>
+      <label><input type="checkbox" id="maintenance" /> Maintenance mode</label>
+      <div id="flags"></div>
+    </div>
+
+    <div class="card">
+      <h2>Requests</h2>
+      <label><input type="checkbox" id="private" /> Private view (for sharing org-wide)</label>
+      <p class="muted" id="privacy"></p>
+      <table>
+        <thead><tr><th>Route</th><th class="num">Requests</th><th class="num">4xx</th><th class="num">5xx</th><th class="num">Users</th><th class="num">Avg ms</th></tr></thead>
+        <tbody id="routes"></tbody>
+      </table>
+    </div>
+
+    <div class="card">
+      <h2>Output quality</h2>
+      <p class="muted">Average scores (1–5) of results evaluated with ?evaluate=true.</p>
+      <table>
+        <thead><tr><th>Endpoint</th><th class="num">Evaluations</th><th class="num">Faithfulness</th><th class="num">Completeness</th><th class="num">Fluency</th><th class="num">Overall</th></tr></thead>
+        <tbody id="quality"></tbody>
+      </table>
+    </div>
+
+    <div class="card">
+      <h2>Recent errors</h2>
+      <table>
+        <thead><tr><th>Time</th><th>Source</th><th>Status</th><th>Message</th></tr></thead>
+        <tbody id="errors"></tbody>
+      </table>
+    </div>
+  </div>
+
+  <script>
+    const API = '/api/v1';
+
+    let ssoAdmin = false; // signed in with SAML as an admin: no token needed
+
+    function token() {
+      if (ssoAdmin) return '';
+      let t = sessionStorage.getItem('adminToken');
+      if (t === null) {
+        t = prompt('Admin token (leave empty if ADMIN_TOKEN is not set):') || '';
+        sessionStorage.setItem('adminToken', t);
+      }
+      return t;
+    }
+
+    async function call(method, path, body) {
+      const headers = { 'Authorization': 'Bearer ' + token() };
+      if (body) headers['Content-Type'] = 'application/json';
+      const res = await fetch(API + path, { method, headers, body: body ? JSON.stringify(body) : undefined });
+      if (res.status === 401) {
+        sessionStorage.removeItem('adminToken');
+        throw new Error('Unauthorized – reload the page to enter the token again.');
+      }
+      const data = await res.json();
+      if (!res.ok) throw new Error(data.error ? data.error.message : 'HTTP ' + res.status);
+      return data;
+    }
+
+    function cell(row, text, cls) {
+      const td = document.createElement('td');
+      td.textContent = text;
+      if (cls) td.className = cls;
+      row.appendChild(td);
+    }
+
+    function renderFlags(f) {
+      document.getElementById('maintenance').checked = f.maintenance;
+      document.getElementById('maintenance-banner').style.display = f.maintenance ? 'block' : 'none';
+      const box = document.getElementById('flags');
+      for (const name of Object.keys(f.flags).sort()) {
+        let input = document.getElementById('flag-' + name);
+        if (!input) {
+          const label = document.createElement('label');
+          input = document.createElement('input');
+          input.type = 'checkbox';
+          input.id = 'flag-' + name;
+          input.addEventListener('change', () => setFlags({ flags: { [name]: input.checked } }));
+          label.appendChild(input);
+          label.appendChild(document.createTextNode(' ' + name));
+          box.appendChild(label);
+        }
+        input.checked = f.flags[name];
+      }
+    }
+
+    function render(s) {
+      document.getElementById('uptime').textContent = s.uptime;
+      document.getElementById('llm-calls').textContent = s.llm.calls;
+      document.getElementById('llm-errors').textContent = s.llm.errors + ' errors · ' +
+        s.llm.prompt_tokens + ' prompt / ' + s.llm.completion_tokens + ' completion tokens';
+
+      document.getElementById('budget-used').textContent = s.budget.used;
+      const bar = document.getElementById('budget-bar');
+      if (s.budget.limit) {
+        const pct = Math.round((s.budget.used_ratio || 0) * 100);
+        document.getElementById('budget-limit').textContent = pct + '% of ' + s.budget.limit + ' (' + s.budget.day + ' UTC)';
+        bar.style.width = Math.min(pct, 100) + '%';
+        bar.className = pct >= 100 ? 'over' : '';
+      } else {
+        document.getElementById('budget-limit').textContent = 'No DAILY_TOKEN_BUDGET set';
+        bar.style.width = '0';
+      }
+
+      document.getElementById('queue-depth').textContent = s.jobs.queue_depth + ' / ' + s.jobs.queue_capacity;
+      document.getElementById('job-counts').textContent = s.jobs.queued + ' queued · ' + s.jobs.running + ' running · ' +
+        s.jobs.done + ' done · ' + s.jobs.failed + ' failed';
+
+      const routes = document.getElementById('routes');
+      routes.innerHTML = '';
+      for (const r of s.routes) {
+        const tr = document.createElement('tr');
+        cell(tr, r.route);
+        cell(tr, r.requests, 'num');
+        cell(tr, r.client_errors, 'num');
+        cell(tr, r.server_errors, 'num');
+        cell(tr, r.users, 'num');
+        cell(tr, r.avg_ms.toFixed(1), 'num');
+        routes.appendChild(tr);
+      }
+
+      const quality = document.getElementById('quality');
+      quality.innerHTML = '';
+      for (const q of s.quality) {
+        const tr = document.createElement('tr');
+        cell(tr, q.endpoint);
+        cell(tr, q.evaluations, 'num');
+        cell(tr, q.faithfulness.toFixed(2), 'num');
+        cell(tr, q.completeness.toFixed(2), 'num');
+        cell(tr, q.fluency.toFixed(2), 'num');
+        cell(tr, q.overall.toFixed(2), 'num');
+        quality.appendChild(tr);
+      }
+      if (!s.quality.length) {
+        const tr = document.createElement('tr');
+        cell(tr, 'None yet', 'muted');
+        quality.appendChild(tr);
+      }
+
+      const errors = document.getElementById('errors');
+      errors.innerHTML = '';
+      for (const e of s.recent_errors) {
+        const tr = document.createElement('tr');
+        cell(tr, new Date(e.time).toLocaleTimeString());
+        cell(tr, e.source);
+        cell(tr, e.status || '');
+        cell(tr, e.message, 'err');
+        errors.appendChild(tr);
+      }
+      if (!s.recent_errors.length) {
+        const tr = document.createElement('tr');
+        cell(tr, 'None', 'muted');
+        errors.appendChild(tr);
+      }
+
+      const privacy = document.getElementById('privacy');
+      document.getElementById('private').checked = !!s.private;
+      privacy.textContent = s.private
+        ? 'Privately aggregated: routes with fewer than ' + s.private.min_users + ' users are hidden and counts are noised (ε = ' + s.private.epsilon + ').'
+        : '';
+
+      renderFlags(s.features);
+    }
+
+    async function refresh() {
+      try {
+        render(await call('GET', '/admin/stats' + (document.getElementById('private').checked ? '?aggregate=private' : '')));
+        document.getElementById('error').textContent = '';
+      } catch (err) {
+        document.getElementById('error').textContent = err.message;
+      }
+    }
+
+    async function setFlags(update) {
+      try {
+        renderFlags(await call('PUT', '/admin/flags', update));
+      } catch (err) {
+        document.getElementById('error').textContent = err.message;
+      }
+      refresh();
+    }
+
+    document.getElementById('maintenance').addEventListener('change', e => setFlags({ maintenance: e.target.checked }));
+    document.getElementById('private').addEventListener('change', refresh);
+    document.getElementById('logout').addEventListener('click', e => {
+      e.preventDefault();
+      sessionStorage.removeItem('adminToken');
+      location.reload();
+    });
+
+    fetch(API + '/me')
+      .then(res => res.json())
+      .then(me => { ssoAdmin = me.role === 'admin'; })
+      .catch(() => {})
+      .finally(() => {
+        refresh();
+        setInterval(refresh, 5000);
+      });
+  </script>
+</body>
+</html>
+`
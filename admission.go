@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Admission control ---
+//
+// LLM calls wait for a slot of their operation (the policy's concurrency)
+// and then for one of the LLM_GLOBAL_CONCURRENCY slots shared by all
+// operations. Only so many calls may wait (the policy's queue, and
+// LLM_GLOBAL_QUEUE): beyond that, and for calls that time out waiting,
+// requests get a 503 with Retry-After at once rather than piling up in
+// memory and bursting into the provider's rate limit.
+//
+// Calls of batch work (jobs, schedules, feeds, requests marked
+// "X-Priority: batch", see priority.go) only get a slot when no
+// interactive call is waiting for it, and only up to the batch
+// concurrency, so that the rest of the slots stay free for interactive
+// calls. They wait as long as it takes, outside the queue.
+
+// overloadRetryAfter is the Retry-After of requests turned away for
+// capacity.
+const overloadRetryAfter = 2 * time.Second
+
+var errWaitQueueFull = errors.New("wait queue full")
+
+// semaphore limits the calls running at once, and the batch calls among
+// them, with a bounded number of interactive calls waiting for a slot.
+// Freed slots go to waiting interactive calls first, in order.
+type semaphore struct {
+	concurrency int // unlimited if 0
+	batch       int // batch calls at once; unlimited if 0
+	queue       int // interactive calls waiting; unbounded if 0
+
+	mu      sync.Mutex
+	running [2]int             // by Priority
+	waiting [2][]chan struct{} // by Priority, closed when given a slot
+}
+
+func newSemaphore(concurrency, batch, queue int) *semaphore {
+	return &semaphore{concurrency: concurrency, batch: batch, queue: queue}
+}
+
+// acquire takes a slot, waiting until ctx is done, and returns the func
+// releasing it.
+func (s *semaphore) acquire(ctx context.Context, prio Priority) (func(), error) {
+	release := func() { s.release(prio) }
+	s.mu.Lock()
+	if len(s.waiting[priorityInteractive]) == 0 && len(s.waiting[prio]) == 0 && s.free(prio) {
+		s.running[prio]++
+		s.mu.Unlock()
+		return release, nil
+	}
+	if prio == priorityInteractive && s.queue > 0 && len(s.waiting[prio]) >= s.queue {
+		s.mu.Unlock()
+		return nil, errWaitQueueFull
+	}
+	ready := make(chan struct{})
+	s.waiting[prio] = append(s.waiting[prio], ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.waiting[prio] {
+		if c == ready {
+			s.waiting[prio] = append(s.waiting[prio][:i], s.waiting[prio][i+1:]...)
+			return nil, ctx.Err()
+		}
+	}
+	// given a slot just as ctx was done
+	s.running[prio]--
+	s.dispatchLocked()
+	return nil, ctx.Err()
+}
+
+func (s *semaphore) release(prio Priority) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running[prio]--
+	s.dispatchLocked()
+}
+
+// free reports whether a call of prio may run now.
+func (s *semaphore) free(prio Priority) bool {
+	if s.concurrency > 0 && s.running[priorityInteractive]+s.running[priorityBatch] >= s.concurrency {
+		return false
+	}
+	return prio != priorityBatch || s.batch == 0 || s.running[priorityBatch] < s.batch
+}
+
+// dispatchLocked hands free slots to the waiting calls, interactive ones
+// first.
+func (s *semaphore) dispatchLocked() {
+	for _, prio := range []Priority{priorityInteractive, priorityBatch} {
+		for len(s.waiting[prio]) > 0 && s.free(prio) {
+			close(s.waiting[prio][0])
+			s.waiting[prio] = s.waiting[prio][1:]
+			s.running[prio]++
+		}
+	}
+}
+
+// SemaphoreStatus is the load of a semaphore.
+type SemaphoreStatus struct {
+	Running          int `json:"running"`
+	Waiting          int `json:"waiting"`
+	Concurrency      int `json:"concurrency,omitempty"` // unlimited if 0
+	Queue            int `json:"queue,omitempty"`       // unbounded if 0
+	BatchRunning     int `json:"batch_running"`
+	BatchWaiting     int `json:"batch_waiting"`
+	BatchConcurrency int `json:"batch_concurrency,omitempty"` // unlimited if 0
+}
+
+// AdmissionStats is the load of the concurrency slots, on /admin/stats.
+type AdmissionStats struct {
+	Global     *SemaphoreStatus           `json:"global,omitempty"` // if LLM_GLOBAL_CONCURRENCY or LLM_GLOBAL_BATCH_CONCURRENCY is set
+	Operations map[string]SemaphoreStatus `json:"operations"`       // those with a concurrency limit that were called
+}
+
+func (s *semaphore) Status() SemaphoreStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SemaphoreStatus{
+		Running:          s.running[priorityInteractive] + s.running[priorityBatch],
+		Waiting:          len(s.waiting[priorityInteractive]) + len(s.waiting[priorityBatch]),
+		Concurrency:      s.concurrency,
+		Queue:            s.queue,
+		BatchRunning:     s.running[priorityBatch],
+		BatchWaiting:     len(s.waiting[priorityBatch]),
+		BatchConcurrency: s.batch,
+	}
+}
+
+// --- 503 responses ---
+
+// rejectedError is an LLM call turned away without reaching the provider:
+// the circuit breaker is open or the server is at capacity. It counts as
+// the provider being unavailable, so local fallbacks apply.
+type rejectedError struct {
+	Reason     string // e.g. "the LLM provider is failing"
+	RetryAfter time.Duration
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("LLM call rejected: %s, retry in %v", e.Reason, e.RetryAfter.Round(time.Second))
+}
+
+func (e *rejectedError) Unwrap() error {
+	return &texttools.StatusError{Status: http.StatusServiceUnavailable, Body: e.Reason}
+}
+
+// reject returns a rejectedError, and hands it to withRejection for the
+// 503 if ctx is that of an HTTP request.
+func reject(ctx context.Context, reason string, retryAfter time.Duration) error {
+	err := &rejectedError{Reason: reason, RetryAfter: retryAfter}
+	if slot, ok := ctx.Value(rejectionKey{}).(*rejectionSlot); ok {
+		slot.set(err)
+	}
+	return err
+}
+
+type rejectionKey struct{}
+
+// rejectionSlot receives the rejection of a call made while serving a
+// request.
+type rejectionSlot struct {
+	mu  sync.Mutex
+	err *rejectedError
+}
+
+func (s *rejectionSlot) set(err *rejectedError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *rejectionSlot) get() *rejectedError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// withRejection turns the error response of a request whose LLM call was
+// rejected into a 503 with Retry-After, like withModeration does for
+// flagged content.
+func withRejection(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slot := &rejectionSlot{}
+		rw := &rejectionWriter{ResponseWriter: w, slot: slot}
+		next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), rejectionKey{}, slot)))
+		if !rw.replaced {
+			return
+		}
+		rejected := slot.get()
+		w.Header().Del("X-Content-Type-Options")
+		w.Header().Set("Retry-After", retryAfterSeconds(rejected.RetryAfter))
+		writeJSON(w, http.StatusServiceUnavailable, APIError{Error: APIErrorBody{
+			Code:      "unavailable",
+			Message:   fmt.Sprintf("%s; retry in %s seconds", rejected.Reason, retryAfterSeconds(rejected.RetryAfter)),
+			RequestID: r.Header.Get("X-Request-ID"),
+		}})
+	})
+}
+
+// rejectionWriter drops the handler's error response once a call was
+// rejected, so that withRejection can write the 503 instead.
+type rejectionWriter struct {
+	http.ResponseWriter
+	slot     *rejectionSlot
+	replaced bool
+}
+
+func (w *rejectionWriter) WriteHeader(status int) {
+	if status >= 400 && w.slot.get() != nil {
+		w.replaced = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *rejectionWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *rejectionWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.replaced {
+		f.Flush()
+	}
+}
+
+func (w *rejectionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// --- API versioning ---
+//
+// Endpoints live under /api/v1/, where errors use a JSON envelope. The
+// original unprefixed paths remain as deprecated aliases with plain-text
+// errors, so existing integrations keep working.
+
+const apiPrefix = "/api/v1"
+
+// APIError is the error envelope of versioned endpoints.
+type APIError struct {
+	Error APIErrorBody `json:"error"`
+}
+
+type APIErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// errorCodes maps HTTP statuses to stable error codes. They share the
+// taxonomy of failed LLM calls (llmerrors.go): an invalid request is
+// invalid_input and a too long one context_length_exceeded, whether the
+// server or the provider turned it away.
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_input",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "context_length_exceeded",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "invalid_input",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "upstream_error",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+func errorCode(status int) string {
+	if c, ok := errorCodes[status]; ok {
+		return c
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// withRequestID tags every request with an ID (the client's X-Request-ID if
+// it sent a sane one) and echoes it in the response headers.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			id = newID()
+			r.Header.Set("X-Request-ID", id)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withErrorEnvelope rewrites the plain-text errors written by http.Error
+// into the APIError envelope.
+func withErrorEnvelope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &envelopeWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if !ew.intercepted {
+			return
+		}
+
+		var e APIError
+		e.Error.Code = errorCode(ew.status)
+		if code := w.Header().Get("X-Error-Code"); code != "" {
+			e.Error.Code = code // e.g. of a failed LLM call, see llmerrors.go
+		}
+		e.Error.Message = strings.TrimSpace(ew.body.String())
+		e.Error.RequestID = r.Header.Get("X-Request-ID")
+		w.Header().Del("X-Content-Type-Options")
+		writeJSON(w, ew.status, e)
+	})
+}
+
+// envelopeWriter buffers non-JSON error responses so withErrorEnvelope can
+// replace them; everything else passes straight through.
+type envelopeWriter struct {
+	http.ResponseWriter
+	status      int
+	intercepted bool
+	body        bytes.Buffer
+}
+
+func (w *envelopeWriter) WriteHeader(status int) {
+	if status >= 400 && !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.status, w.intercepted = status, true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *envelopeWriter) Write(b []byte) (int, error) {
+	if w.intercepted {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *envelopeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.intercepted {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to
+// hijack it for WebSockets.
+func (w *envelopeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// legacyHandler serves the web UI at "/" and every other unprefixed path as
+// a deprecated alias of the versioned API.
+func legacyHandler(api http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			sso.RequireLogin(uiHandler)(w, r)
+			return
+		}
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+apiPrefix+r.URL.Path+`>; rel="successor-version"`)
+		api.ServeHTTP(w, r)
+	})
+}
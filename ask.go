@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Question answering ---
+//
+// POST /ask answers a question from the submitted text alone, the companion
+// to /questions. The model quotes the passages its answer rests on; the
+// quotes are located in the text here, and those that can't be found are
+// dropped rather than passed on as evidence. A question the text doesn't
+// answer gets "answerable": false instead of an answer from the model's own
+// knowledge.
+
+const maxQuestionChars = 1000
+
+type AskRequest struct {
+	Text     string `json:"text"`
+	Question string `json:"question"`
+	Options
+}
+
+type AskResponse struct {
+	Answer     string  `json:"answer"`
+	Answerable bool    `json:"answerable"` // false if the text doesn't answer the question
+	Quotes     []Quote `json:"quotes"`
+	ResultMeta
+}
+
+// Quote is a passage of the text supporting an answer; Start and End are
+// offsets in Unicode characters into the text, End exclusive.
+type Quote struct {
+	Text  string `json:"text"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// askPromptInput is the data of the ask template.
+type askPromptInput struct {
+	texttools.Input
+	Question string
+}
+
+func askHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AskRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		req.Question = strings.TrimSpace(req.Question)
+		if req.Text == "" || req.Question == "" {
+			http.Error(w, "`text` and `question` are required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if utf8.RuneCountInString(req.Question) > maxQuestionChars {
+			http.Error(w, "`question` is too long", http.StatusBadRequest)
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey(req.Question, req.Options)
+		if prior, ok := history.Reusable(r, "ask", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		// As for /entities, the text goes to the model as submitted, so
+		// that its quotes can be found in it.
+		in := askPromptInput{
+			Input:    texttools.Input{Text: req.Text, Options: req.Options.Options},
+			Question: req.Question,
+		}
+		out, err := tools.Prompt(r.Context(), "ask", in, in.Options)
+		if err != nil {
+			log.Println("ask error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var answer struct {
+			Answer     string   `json:"answer"`
+			Answerable bool     `json:"answerable"`
+			Quotes     []string `json:"quotes"`
+		}
+		if err := json.Unmarshal([]byte(out), &answer); err != nil {
+			// fallback – the whole output is the answer, without quotes
+			answer.Answer, answer.Answerable = out, true
+		}
+		resp := AskResponse{
+			Answer:     texttools.FormatOutput(tools.FitWords(r.Context(), strings.TrimSpace(answer.Answer), req.Options.Options), req.OutputFormat),
+			Answerable: answer.Answerable,
+			Quotes:     locateQuotes(req.Text, answer.Quotes),
+		}
+		if !resp.Answerable {
+			resp.Quotes = []Quote{}
+		}
+
+		resp.ResultMeta = history.Record(r, "ask", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// locateQuotes finds each quote in text, first verbatim, then ignoring
+// differences in whitespace, and returns those found in text order, with
+// their text as it is in the text.
+func locateQuotes(text string, quotes []string) []Quote {
+	runes := []rune(text)
+
+	// text without whitespace, and where each of its runes is in text
+	var squeezed []rune
+	var pos []int
+	for i, r := range runes {
+		if !unicode.IsSpace(r) {
+			squeezed = append(squeezed, r)
+			pos = append(pos, i)
+		}
+	}
+
+	found := []Quote{}
+	for _, q := range quotes {
+		q = strings.Trim(strings.TrimSpace(q), `"“”`)
+		if q == "" {
+			continue
+		}
+		needle := []rune(q)
+		start, end := indexRunes(runes, needle), 0
+		if start >= 0 {
+			end = start + len(needle)
+		} else {
+			needle = slices.DeleteFunc(needle, unicode.IsSpace)
+			i := indexRunes(squeezed, needle)
+			if i < 0 {
+				continue
+			}
+			start, end = pos[i], pos[i+len(needle)-1]+1
+		}
+		if slices.ContainsFunc(found, func(f Quote) bool { return f.Start == start && f.End == end }) {
+			continue
+		}
+		found = append(found, Quote{Text: string(runes[start:end]), Start: start, End: end})
+	}
+	slices.SortFunc(found, func(a, b Quote) int { return a.Start - b.Start })
+	return found
+}
+
+// indexRunes returns the index of the first needle in s, or -1.
+func indexRunes(s, needle []rune) int {
+	if len(needle) == 0 {
+		return -1
+	}
+	for i := 0; i+len(needle) <= len(s); i++ {
+		if slices.Equal(s[i:i+len(needle)], needle) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Audit log ---
+//
+// With AUDIT_LOG set, every API request that changes something or runs an
+// operation (anything but GET, HEAD and OPTIONS) is recorded as a line of
+// JSON: who sent it, to which endpoint, a SHA-256 of its body, the tokens
+// of its LLM calls and how it ended. Records go to a file that is rotated
+// daily and at AUDIT_MAX_SIZE, keeping rotated files for AUDIT_RETENTION;
+// to stdout; or, for an http(s) URL, to an external collector, POSTed in
+// batches as JSON lines. The input itself is only recorded with
+// AUDIT_INPUT=redacted (PII replaced by placeholders, as by /redact's
+// regex pass) or full.
+
+const (
+	auditInputHash     = "hash"
+	auditInputRedacted = "redacted"
+	auditInputFull     = "full"
+)
+
+const (
+	defaultAuditRetention = 30 * 24 * time.Hour
+	defaultAuditMaxSize   = 100 << 20
+	auditBatchSize        = 100
+	auditBatchDelay       = time.Second
+)
+
+// AuditRecord is a line of the audit log.
+type AuditRecord struct {
+	Time             time.Time `json:"time"`
+	RequestID        string    `json:"request_id,omitempty"`
+	User             string    `json:"user"`
+	Tenant           string    `json:"tenant,omitempty"`
+	KeyHash          string    `json:"key_sha256,omitempty"` // first 16 hex digits, of the API key sent
+	Method           string    `json:"method"`
+	Endpoint         string    `json:"endpoint"`
+	InputSHA256      string    `json:"input_sha256,omitempty"` // of the request body
+	InputBytes       int       `json:"input_bytes"`
+	Input            string    `json:"input,omitempty"` // with AUDIT_INPUT=redacted or full
+	Status           int       `json:"status"`
+	Outcome          string    `json:"outcome"` // ok, client_error or server_error
+	LLMCalls         int       `json:"llm_calls"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Model            string    `json:"model,omitempty"`
+	LatencyMS        int64     `json:"latency_ms"`
+}
+
+// AuditConfig configures the audit log.
+type AuditConfig struct {
+	Sink      string // file path, "stdout" or an http(s) URL; "" for none
+	Input     string // hash, redacted or full
+	Retention time.Duration
+	MaxSize   int64 // bytes a file may grow to before it is rotated
+}
+
+// AuditConfigFromEnv reads AUDIT_LOG, AUDIT_INPUT, AUDIT_RETENTION and
+// AUDIT_MAX_SIZE.
+func AuditConfigFromEnv() (AuditConfig, error) {
+	c := AuditConfig{
+		Sink:      os.Getenv("AUDIT_LOG"),
+		Input:     os.Getenv("AUDIT_INPUT"),
+		Retention: defaultAuditRetention,
+		MaxSize:   defaultAuditMaxSize,
+	}
+	switch c.Input {
+	case "":
+		c.Input = auditInputHash
+	case auditInputHash, auditInputRedacted, auditInputFull:
+	default:
+		return c, fmt.Errorf("unknown AUDIT_INPUT %q (want hash, redacted or full)", c.Input)
+	}
+	if v := os.Getenv("AUDIT_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return c, fmt.Errorf("AUDIT_RETENTION: invalid duration %q", v)
+		}
+		c.Retention = d
+	}
+	if v := os.Getenv("AUDIT_MAX_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return c, fmt.Errorf("AUDIT_MAX_SIZE: invalid size %q (want bytes)", v)
+		}
+		c.MaxSize = n
+	}
+	return c, nil
+}
+
+// auditSink is where audit records are written to.
+type auditSink interface {
+	write(lines [][]byte) error
+	close() error
+}
+
+// AuditLog writes audit records to its sink in the background.
+type AuditLog struct {
+	inputMode string
+	tenants   *TenantStore
+	records   chan []byte
+	done      chan struct{}
+}
+
+// NewAuditLog opens the sink of c; with none configured it returns nil,
+// which audits nothing.
+func NewAuditLog(c AuditConfig, tenants *TenantStore) (*AuditLog, error) {
+	var sink auditSink
+	switch {
+	case c.Sink == "":
+		return nil, nil
+	case c.Sink == "stdout":
+		sink = writerSink{os.Stdout}
+	case strings.HasPrefix(c.Sink, "http://") || strings.HasPrefix(c.Sink, "https://"):
+		sink = &httpSink{url: c.Sink, client: &http.Client{Timeout: 10 * time.Second}}
+	default:
+		fs, err := openFileSink(c.Sink, c.MaxSize, c.Retention)
+		if err != nil {
+			return nil, err
+		}
+		sink = fs
+	}
+	a := &AuditLog{inputMode: c.Input, tenants: tenants, records: make(chan []byte, 1024), done: make(chan struct{})}
+	go a.run(sink)
+	return a, nil
+}
+
+// run writes the records in batches: whatever has queued up, at most
+// auditBatchSize, waiting up to auditBatchDelay for more.
+func (a *AuditLog) run(sink auditSink) {
+	defer close(a.done)
+	defer sink.close()
+	var batch [][]byte
+	timer := time.NewTimer(auditBatchDelay)
+	timer.Stop()
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := sink.write(batch); err != nil {
+			log.Printf("audit error: %v (%d records lost)", err, len(batch))
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case line, ok := <-a.records:
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(auditBatchDelay)
+			}
+			batch = append(batch, line)
+			if len(batch) >= auditBatchSize {
+				timer.Stop()
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// Record queues rec for writing. It blocks while the queue is full rather
+// than lose records.
+func (a *AuditLog) Record(rec AuditRecord) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		log.Println("audit error:", err)
+		return
+	}
+	a.records <- b
+}
+
+// Close writes the queued records and closes the sink.
+func (a *AuditLog) Close() {
+	if a == nil {
+		return
+	}
+	close(a.records)
+	<-a.done
+}
+
+type auditKey struct{}
+
+// auditSlot collects the LLM calls of an audited request.
+type auditSlot struct {
+	mu                             sync.Mutex
+	calls                          int
+	promptTokens, completionTokens int
+	model                          string
+}
+
+// recordAuditCall adds an LLM call to the audit record of the request of
+// ctx, if it is audited.
+func recordAuditCall(ctx context.Context, model string, usage texttools.Usage) {
+	slot, ok := ctx.Value(auditKey{}).(*auditSlot)
+	if !ok {
+		return
+	}
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	slot.calls++
+	slot.promptTokens += usage.PromptTokens
+	slot.completionTokens += usage.CompletionTokens
+	if model != "" {
+		slot.model = model
+	}
+}
+
+// withAudit records the requests that go through it in audit (if not nil).
+func withAudit(audit *AuditLog, next http.Handler) http.Handler {
+	if audit == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
+		if err != nil {
+			writeInputError(w, errTooLarge("request body too large (max %d bytes)", int64(maxUploadSize)))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		slot := &auditSlot{}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditKey{}, slot)))
+		if status := grpcHTTPStatus(rec.Header()); status != 0 {
+			rec.status = status // gRPC errors are HTTP 200s
+		}
+
+		e := AuditRecord{
+			Time:       start.UTC(),
+			RequestID:  r.Header.Get("X-Request-ID"),
+			User:       userID(r),
+			Method:     r.Method,
+			Endpoint:   r.URL.Path,
+			InputBytes: len(body),
+			Status:     rec.status,
+			LatencyMS:  time.Since(start).Milliseconds(),
+		}
+		if key := apiKey(r); key != "" {
+			e.KeyHash = hashTenantKey(key)[:16]
+			if audit.tenants != nil {
+				e.Tenant, _ = audit.tenants.Authenticate(key)
+			}
+		}
+		if len(body) > 0 && !privacyMode(r.Context()) {
+			sum := sha256.Sum256(body)
+			e.InputSHA256 = hex.EncodeToString(sum[:])
+			e.Input = audit.input(r, body)
+		}
+		switch {
+		case rec.status >= 500:
+			e.Outcome = "server_error"
+		case rec.status >= 400:
+			e.Outcome = "client_error"
+		default:
+			e.Outcome = "ok"
+		}
+		slot.mu.Lock()
+		e.LLMCalls, e.PromptTokens, e.CompletionTokens, e.Model = slot.calls, slot.promptTokens, slot.completionTokens, slot.model
+		slot.mu.Unlock()
+		audit.Record(e)
+	})
+}
+
+// input is the input to record of a request with the given body: none,
+// unless AUDIT_INPUT asks for it and the body is text (not an upload).
+func (a *AuditLog) input(r *http.Request, body []byte) string {
+	if a.inputMode == auditInputHash {
+		return ""
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(mt, "multipart/") || !utf8.Valid(body) {
+		return ""
+	}
+	text := string(body)
+	if a.inputMode == auditInputRedacted {
+		text = redactSpans(text, regexPII(text, piiTypes)).Text
+	}
+	return text
+}
+
+// --- Sinks ---
+
+// writerSink writes records to w, one per line.
+type writerSink struct{ w io.Writer }
+
+func (s writerSink) write(lines [][]byte) error {
+	_, err := s.w.Write(append(bytes.Join(lines, []byte("\n")), '\n'))
+	return err
+}
+
+func (s writerSink) close() error { return nil }
+
+// httpSink POSTs each batch of records to an external collector as JSON
+// lines, retrying once.
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) write(lines [][]byte) error {
+	body := append(bytes.Join(lines, []byte("\n")), '\n')
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var resp *http.Response
+		resp, err = s.client.Post(s.url, "application/x-ndjson", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 300 {
+				return nil
+			}
+			err = fmt.Errorf("%s: %s", s.url, resp.Status)
+		}
+		time.Sleep(time.Second)
+	}
+	return err
+}
+
+func (s *httpSink) close() error { return nil }
+
+// fileSink appends records to a file, which it renames to
+// <name>-<time>.<ext> and starts afresh at midnight UTC or when it reaches
+// maxSize. Rotated files older than retention are deleted.
+type fileSink struct {
+	path      string
+	maxSize   int64
+	retention time.Duration
+
+	f      *os.File
+	size   int64
+	opened string // UTC day the file was started
+}
+
+func openFileSink(path string, maxSize int64, retention time.Duration) (*fileSink, error) {
+	s := &fileSink{path: path, maxSize: maxSize, retention: retention}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	s.prune()
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit log: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("audit log: %w", err)
+	}
+	s.f, s.size = f, fi.Size()
+	s.opened = fi.ModTime().UTC().Format("2006-01-02")
+	if fi.Size() == 0 {
+		s.opened = time.Now().UTC().Format("2006-01-02")
+	}
+	return nil
+}
+
+func (s *fileSink) write(lines [][]byte) error {
+	b := append(bytes.Join(lines, []byte("\n")), '\n')
+	if s.size > 0 && (s.size+int64(len(b)) > s.maxSize || time.Now().UTC().Format("2006-01-02") != s.opened) {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.f.Write(b)
+	s.size += int64(n)
+	return err
+}
+
+// rotate moves the current file aside and starts a new one.
+func (s *fileSink) rotate() error {
+	s.f.Close()
+	ext := filepath.Ext(s.path)
+	rotated := strings.TrimSuffix(s.path, ext) + "-" + time.Now().UTC().Format("20060102T150405") + ext
+	if err := os.Rename(s.path, rotated); err != nil {
+		log.Println("audit error:", err)
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	s.prune()
+	return nil
+}
+
+// prune deletes the rotated files last written more than retention ago.
+func (s *fileSink) prune() {
+	ext := filepath.Ext(s.path)
+	matches, err := filepath.Glob(strings.TrimSuffix(s.path, ext) + "-[0-9]*" + ext)
+	if err != nil {
+		return
+	}
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil || time.Since(fi.ModTime()) <= s.retention {
+			continue
+		}
+		if err := os.Remove(m); err != nil {
+			log.Println("audit error:", err)
+		}
+	}
+}
+
+func (s *fileSink) close() error {
+	return s.f.Close()
+}
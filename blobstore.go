@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// --- Blob storage ---
+//
+// Files the server keeps — the originals of documents uploaded to
+// /documents and exports stored with "store": true — go to a blob store
+// named by BLOB_STORE, so that any instance can serve them and none needs
+// a disk of its own:
+//
+//	s3://bucket/prefix    Amazon S3, or any S3-compatible store with S3_ENDPOINT (s3.go)
+//	gs://bucket/prefix    Google Cloud Storage (gcs.go)
+//	file:///var/lib/blobs a local directory, for development
+//
+// Without BLOB_STORE uploaded files are not kept and exports can only be
+// downloaded as they are made.
+
+var errBlobNotFound = errors.New("blob not found")
+
+// Blob is a stored file.
+type Blob struct {
+	Data        []byte
+	ContentType string
+	Filename    string // suggested to downloads
+}
+
+// BlobStore keeps blobs by key, a slash-separated path.
+type BlobStore interface {
+	Put(ctx context.Context, key string, b Blob) error
+	Get(ctx context.Context, key string) (Blob, error) // errBlobNotFound if there is none
+	Delete(ctx context.Context, key string) error      // no error if there is none
+}
+
+// blobClient talks to S3 and GCS.
+var blobClient = &http.Client{Timeout: 2 * time.Minute}
+
+// NewBlobStoreFromEnv returns the store BLOB_STORE names, or nil if it is
+// not set.
+func NewBlobStoreFromEnv() (BlobStore, error) {
+	v := os.Getenv("BLOB_STORE")
+	if v == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("BLOB_STORE: %w", err)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, errors.New("BLOB_STORE: s3:// needs a bucket")
+		}
+		s, err := NewS3StoreFromEnv(u.Host, prefix)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "gs":
+		if u.Host == "" {
+			return nil, errors.New("BLOB_STORE: gs:// needs a bucket")
+		}
+		s, err := NewGCSStoreFromEnv(u.Host, prefix)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case "file":
+		if u.Path == "" {
+			return nil, errors.New("BLOB_STORE: file:// needs a directory")
+		}
+		if err := os.MkdirAll(u.Path, 0o755); err != nil {
+			return nil, fmt.Errorf("BLOB_STORE: %w", err)
+		}
+		return dirBlobStore(u.Path), nil
+	}
+	return nil, fmt.Errorf("BLOB_STORE: unknown scheme %q (want s3, gs or file)", u.Scheme)
+}
+
+// blobKey joins prefix and key.
+func blobKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+// userBlobPrefix is the key prefix of user's blobs that aren't tracked in
+// a store of their own (exports): a hash, so keys don't reveal user IDs.
+func userBlobPrefix(user string) string {
+	sum := sha256.Sum256([]byte(user))
+	return hex.EncodeToString(sum[:8])
+}
+
+// contentDisposition is the attachment header of a download of filename.
+func contentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+// dispositionFilename is the file name in a Content-Disposition header.
+func dispositionFilename(header string) string {
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return params["filename"]
+}
+
+// writeBlob sends b as a download.
+func writeBlob(w http.ResponseWriter, b Blob) {
+	if b.ContentType != "" {
+		w.Header().Set("Content-Type", b.ContentType)
+	}
+	if b.Filename != "" {
+		w.Header().Set("Content-Disposition", contentDisposition(b.Filename))
+	}
+	w.Write(b.Data)
+}
+
+// dirBlobStore keeps blobs as files in a directory, each with a
+// "<name>.meta" file holding its content type and file name.
+type dirBlobStore string
+
+type blobMeta struct {
+	ContentType string `json:"content_type,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+}
+
+func (d dirBlobStore) path(key string) (string, error) {
+	p := filepath.Join(string(d), filepath.FromSlash(key))
+	if !strings.HasPrefix(p, filepath.Clean(string(d))+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return p, nil
+}
+
+func (d dirBlobStore) Put(_ context.Context, key string, b Blob) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p, b.Data); err != nil {
+		return err
+	}
+	meta, _ := json.Marshal(blobMeta{b.ContentType, b.Filename})
+	return writeFileAtomic(p+".meta", meta)
+}
+
+func (d dirBlobStore) Get(_ context.Context, key string) (Blob, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return Blob{}, err
+	}
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return Blob{}, errBlobNotFound
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+	var meta blobMeta
+	if b, err := os.ReadFile(p + ".meta"); err == nil {
+		_ = json.Unmarshal(b, &meta)
+	}
+	return Blob{Data: data, ContentType: meta.ContentType, Filename: meta.Filename}, nil
+}
+
+func (d dirBlobStore) Delete(_ context.Context, key string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	for _, f := range []string{p, p + ".meta"} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// blobResponseError turns an error response of S3 or GCS into an error.
+func blobResponseError(op, key string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return errBlobNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: status %d: %s", op, key, resp.StatusCode, strings.TrimSpace(string(body)))
+}
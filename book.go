@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Books ---
+//
+// POST /books takes an EPUB upload and keeps its chapters in memory, so
+// operations can run on one chapter at a time
+// (POST /books/<id>/chapters/<n>/<operation>) and a "book_summary" job can
+// summarize the whole book: each chapter first (map), then the chapter
+// summaries into one (reduce), returned with the chapter summaries as a
+// table of contents.
+
+const (
+	maxBooks = 50 // per instance; the oldest is dropped
+
+	// maxSummaryWords is how much text goes into one summarize call; longer
+	// chapters are summarized in parts first, and many chapter summaries
+	// are reduced in groups.
+	maxSummaryWords = 6000
+)
+
+type StoredBook struct {
+	ID        string        `json:"id"`
+	Title     string        `json:"title"`
+	Author    string        `json:"author"`
+	Filename  string        `json:"filename"`
+	Chapters  []BookChapter `json:"chapters"`
+	CreatedAt time.Time     `json:"created_at"`
+
+	user string
+	book *Book
+}
+
+type BookChapter struct {
+	Index int    `json:"index"` // 1-based
+	Title string `json:"title"`
+	Words int    `json:"words"`
+}
+
+// BookStore keeps uploaded books in memory.
+type BookStore struct {
+	mu    sync.RWMutex
+	books map[string]*StoredBook
+}
+
+func NewBookStore() *BookStore {
+	return &BookStore{books: map[string]*StoredBook{}}
+}
+
+func (s *BookStore) Add(user, filename string, b *Book) *StoredBook {
+	sb := &StoredBook{
+		ID:        newID(),
+		Title:     b.Title,
+		Author:    b.Author,
+		Filename:  filename,
+		CreatedAt: time.Now().UTC(),
+		user:      user,
+		book:      b,
+	}
+	for i, c := range b.Chapters {
+		sb.Chapters = append(sb.Chapters, BookChapter{Index: i + 1, Title: c.Title, Words: len(strings.Fields(c.Text))})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.books[sb.ID] = sb
+	if len(s.books) > maxBooks {
+		var oldest *StoredBook
+		for _, b := range s.books {
+			if oldest == nil || b.CreatedAt.Before(oldest.CreatedAt) {
+				oldest = b
+			}
+		}
+		delete(s.books, oldest.ID)
+	}
+	return sb
+}
+
+// Get returns the user's book with the given ID.
+func (s *BookStore) Get(user, id string) (*StoredBook, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.books[id]
+	if !ok || b.user != user {
+		return nil, false
+	}
+	return b, true
+}
+
+// List returns the user's books, newest first.
+func (s *BookStore) List(user string) []*StoredBook {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := []*StoredBook{}
+	for _, b := range s.books {
+		if b.user == user {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+func (s *BookStore) Delete(user, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.books[id]
+	if !ok || b.user != user {
+		return false
+	}
+	delete(s.books, id)
+	return true
+}
+
+// --- Handlers ---
+
+type ChapterResponse struct {
+	BookChapter
+	Text string `json:"text"`
+}
+
+type ChapterOperationRequest struct {
+	Tone string `json:"tone"`
+	Options
+}
+
+type ChapterOperationResponse struct {
+	Chapter   BookChapter `json:"chapter"`
+	Operation string      `json:"operation"`
+	Result    interface{} `json:"result"`
+}
+
+// booksHandler serves POST /books (upload), GET /books (the caller's books),
+// GET and DELETE /books/<id>, GET /books/<id>/chapters/<n> and
+// POST /books/<id>/chapters/<n>/<operation>.
+func booksHandler(tools *texttools.Tools, prefs *PreferenceStore, books *BookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/books"), "/"), "/")
+		user := userID(r)
+
+		switch {
+		case parts[0] == "" && r.Method == http.MethodPost:
+			uploadBook(w, r, books)
+			return
+		case parts[0] == "" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"books": books.List(user)})
+			return
+		case parts[0] == "":
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if len(parts) == 1 && r.Method == http.MethodDelete {
+			if !books.Delete(user, parts[0]) {
+				http.Error(w, "book not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		b, ok := books.Get(user, parts[0])
+		if !ok {
+			http.Error(w, "book not found", http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, b)
+		case len(parts) == 3 && parts[1] == "chapters" && r.Method == http.MethodGet:
+			n, ok := chapterIndex(b, parts[2])
+			if !ok {
+				http.Error(w, "chapter not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, ChapterResponse{BookChapter: b.Chapters[n], Text: b.book.Chapters[n].Text})
+		case len(parts) == 4 && parts[1] == "chapters" && r.Method == http.MethodPost:
+			chapterOperation(w, r, tools, prefs, b, parts[2], parts[3])
+		case len(parts) <= 4 && parts[1] == "chapters":
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}
+}
+
+func uploadBook(w http.ResponseWriter, r *http.Request, books *BookStore) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid multipart body", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "`file` is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "failed to read file", http.StatusBadRequest)
+		return
+	}
+	if !isEPUB(data) && !strings.HasSuffix(strings.ToLower(header.Filename), ".epub") {
+		http.Error(w, "expected an EPUB file", http.StatusUnsupportedMediaType)
+		return
+	}
+	book, err := extractEPUB(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusCreated, books.Add(userID(r), header.Filename, book))
+}
+
+// chapterIndex parses a 1-based chapter number into an index.
+func chapterIndex(b *StoredBook, s string) (int, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > len(b.Chapters) {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+func chapterOperation(w http.ResponseWriter, r *http.Request, tools *texttools.Tools, prefs *PreferenceStore, b *StoredBook, chapter, op string) {
+	n, ok := chapterIndex(b, chapter)
+	if !ok {
+		http.Error(w, "chapter not found", http.StatusNotFound)
+		return
+	}
+	if !isBuiltinOperation(op) {
+		http.Error(w, "unknown operation", http.StatusBadRequest)
+		return
+	}
+	var req ChapterOperationRequest
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	if err := req.Options.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p := prefs.For(r)
+	p.apply(&req.Options)
+	if req.Tone == "" {
+		req.Tone = p.Tone
+	}
+
+	if !checkText(w, b.book.Chapters[n].Text) {
+		return
+	}
+	result, err := runOperation(r.Context(), tools, op, RewriteRequest{Text: b.book.Chapters[n].Text, Tone: req.Tone, Options: req.Options})
+	if err != nil {
+		log.Println("book chapter error:", err)
+		writeLLMError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ChapterOperationResponse{Chapter: b.Chapters[n], Operation: op, Result: result})
+}
+
+// --- Book summary jobs ---
+
+type BookSummaryJobRequest struct {
+	Book string `json:"book"` // ID from POST /books
+	Options
+}
+
+type BookSummary struct {
+	BookID   string           `json:"book_id"`
+	Title    string           `json:"title"`
+	Author   string           `json:"author"`
+	Summary  string           `json:"summary"`
+	Contents []ChapterSummary `json:"contents"` // the table of contents, with each chapter summarized
+}
+
+type ChapterSummary struct {
+	Index   int    `json:"index"`
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+}
+
+// bookPromptInput is the data of the summarize-book template; Text holds
+// the chapter summaries.
+type bookPromptInput struct {
+	texttools.Input
+	Title  string
+	Author string
+}
+
+// bookSummaryJob is the payload of a book_summary job. It carries the
+// book, which is only in the memory of the instance it was uploaded to.
+type bookSummaryJob struct {
+	BookID  string            `json:"book_id"`
+	Book    *Book             `json:"book"`
+	Options texttools.Options `json:"options"`
+}
+
+// parseBookSummaryJob validates the request body and returns the job's
+// payload, with the user's preferences applied.
+func parseBookSummaryJob(prefs Preferences, books *BookStore, user string, body []byte) (bookSummaryJob, error) {
+	var req BookSummaryJobRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return bookSummaryJob{}, errors.New("invalid JSON body")
+	}
+	if req.Book == "" {
+		return bookSummaryJob{}, errors.New("`book` is required")
+	}
+	if err := req.Options.validate(); err != nil {
+		return bookSummaryJob{}, err
+	}
+	b, ok := books.Get(user, req.Book)
+	if !ok {
+		return bookSummaryJob{}, errors.New("book not found")
+	}
+	prefs.apply(&req.Options)
+	return bookSummaryJob{BookID: b.ID, Book: b.book, Options: req.Options.Options}, nil
+}
+
+// bookSummaryJobRunner runs book_summary jobs.
+func bookSummaryJobRunner(tools *texttools.Tools) JobRunner {
+	return func(_ string, payload json.RawMessage) (func(p *JobProgress) (interface{}, error), error) {
+		var job bookSummaryJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return nil, err
+		}
+		if job.Book == nil {
+			return nil, errors.New("the job has no book")
+		}
+		return bookSummary(tools, job), nil
+	}
+}
+
+func bookSummary(tools *texttools.Tools, job bookSummaryJob) func(p *JobProgress) (interface{}, error) {
+	b := &StoredBook{ID: job.BookID, Title: job.Book.Title, Author: job.Book.Author, book: job.Book}
+	return func(p *JobProgress) (interface{}, error) {
+		ctx := batchContext(context.Background())
+		opts := job.Options
+		p.SetTotal(len(b.book.Chapters) + 1)
+
+		out := &BookSummary{BookID: b.ID, Title: b.Title, Author: b.Author, Contents: []ChapterSummary{}}
+		for i, c := range b.book.Chapters {
+			s, err := summarizeLong(ctx, tools, c.Text, opts)
+			if err != nil {
+				return nil, fmt.Errorf("chapter %d: %w", i+1, err)
+			}
+			out.Contents = append(out.Contents, ChapterSummary{Index: i + 1, Title: c.Title, Summary: s})
+			p.Step()
+		}
+
+		summary, err := reduceChapters(ctx, tools, b, out.Contents, opts)
+		if err != nil {
+			return nil, err
+		}
+		out.Summary = texttools.FormatOutput(tools.FitWords(ctx, summary, opts), opts.OutputFormat)
+		p.Step()
+		return out, nil
+	}
+}
+
+// summarizeLong summarizes text in parts of at most maxSummaryWords, then
+// summarizes the joined part summaries.
+func summarizeLong(ctx context.Context, tools *texttools.Tools, text string, opts texttools.Options) (string, error) {
+	parts := splitWords(text, maxSummaryWords)
+	if len(parts) == 1 {
+		return tools.Summarize(ctx, text, opts)
+	}
+	summaries := make([]string, 0, len(parts))
+	for _, part := range parts {
+		s, err := tools.Summarize(ctx, part, opts)
+		if err != nil {
+			return "", err
+		}
+		summaries = append(summaries, s)
+	}
+	return summarizeLong(ctx, tools, strings.Join(summaries, "\n\n"), opts)
+}
+
+// reduceChapters combines chapter summaries into a summary of the book.
+// Too many to fit one prompt are first reduced in groups.
+func reduceChapters(ctx context.Context, tools *texttools.Tools, b *StoredBook, contents []ChapterSummary, opts texttools.Options) (string, error) {
+	sections := make([]string, len(contents))
+	for i, c := range contents {
+		sections[i] = fmt.Sprintf("## %s\n%s", c.Title, c.Summary)
+	}
+	for {
+		groups := groupWords(sections, maxSummaryWords)
+		reduced := make([]string, 0, len(groups))
+		for _, g := range groups {
+			in := bookPromptInput{Input: texttools.Input{Text: g, Options: opts}, Title: b.Title, Author: b.Author}
+			s, err := tools.Prompt(ctx, "summarize-book", in, opts)
+			if err != nil {
+				return "", err
+			}
+			reduced = append(reduced, s)
+		}
+		if len(reduced) == 1 {
+			return reduced[0], nil
+		}
+		sections = reduced
+	}
+}
+
+// splitWords splits text at paragraph boundaries into parts of at most max
+// words (a single longer paragraph becomes its own part).
+func splitWords(text string, max int) []string {
+	return groupWords(strings.Split(text, "\n\n"), max)
+}
+
+// groupWords joins consecutive sections into groups of at most max words,
+// always putting at least two sections into a group so that repeated
+// grouping converges.
+func groupWords(sections []string, max int) []string {
+	var groups []string
+	var cur []string
+	words := 0
+	for _, s := range sections {
+		n := len(strings.Fields(s))
+		if len(cur) > 1 && words+n > max {
+			groups = append(groups, strings.Join(cur, "\n\n"))
+			cur, words = nil, 0
+		}
+		cur = append(cur, s)
+		words += n
+	}
+	if len(cur) > 0 {
+		groups = append(groups, strings.Join(cur, "\n\n"))
+	}
+	return groups
+}
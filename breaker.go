@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Circuit breaker ---
+//
+// When the LLM provider fails, every request would otherwise wait out its
+// timeout and retries against it. After BREAKER_THRESHOLD (default 5)
+// consecutive calls failed with the provider unavailable, the breaker
+// opens: calls fail at once, and requests get a 503 with Retry-After (see
+// withRejection), for BREAKER_COOLDOWN (default 30s). Then one call is let
+// through as a probe; if it succeeds the breaker closes, otherwise it opens
+// for another cooldown. BREAKER_THRESHOLD=0 disables the breaker. The state
+// is shown on /health.
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// CircuitBreaker guards the calls to the provider.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int       // consecutive
+	until    time.Time // while open
+	probing  bool      // a half-open probe is in flight
+	openedAt time.Time
+}
+
+// BreakerStatus is the breaker's state as shown on /health.
+type BreakerStatus struct {
+	State      string     `json:"state"`                 // closed, open or half-open
+	Failures   int        `json:"failures"`              // consecutive failed calls
+	OpenedAt   *time.Time `json:"opened_at,omitempty"`   // when it last opened, while not closed
+	RetryAfter int        `json:"retry_after,omitempty"` // seconds until the next probe, while open
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, state: breakerClosed}
+}
+
+// NewCircuitBreakerFromEnv configures the breaker from BREAKER_THRESHOLD
+// and BREAKER_COOLDOWN.
+func NewCircuitBreakerFromEnv() (*CircuitBreaker, error) {
+	cooldown := 30 * time.Second
+	if v := os.Getenv("BREAKER_COOLDOWN"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("BREAKER_COOLDOWN: invalid duration %q", v)
+		}
+		cooldown = d
+	}
+	return NewCircuitBreaker(max(envInt("BREAKER_THRESHOLD", 5), 0), cooldown), nil
+}
+
+// allow reports whether a call may go ahead and, if not, how long until
+// the breaker lets one through. A call allowed must be followed by done.
+func (b *CircuitBreaker) allow() (time.Duration, bool) {
+	if b.threshold == 0 {
+		return 0, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen {
+		if wait := time.Until(b.until); wait > 0 {
+			return wait, false
+		}
+		b.state = breakerHalfOpen
+		log.Printf("circuit breaker half-open: probing the LLM provider")
+	}
+	if b.state == breakerHalfOpen {
+		if b.probing {
+			return time.Second, false
+		}
+		b.probing = true
+	}
+	return 0, true
+}
+
+// done records the outcome of an allowed call. Only the provider being
+// unavailable counts as a failure; a call abandoned by its caller counts
+// as nothing.
+func (b *CircuitBreaker) done(ctx context.Context, err error) {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var rejected *rejectedError
+	probe := b.state == breakerHalfOpen && b.probing
+	if probe {
+		b.probing = false
+	}
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// the caller went away (or timed out) before the provider answered
+	case errors.As(err, &rejected):
+		// turned away by admission control, never reached the provider
+	case err != nil && texttools.Unavailable(err):
+		b.failures++
+		if probe || (b.state == breakerClosed && b.failures >= b.threshold) {
+			b.state, b.until, b.openedAt = breakerOpen, time.Now().Add(b.cooldown), time.Now().UTC()
+			log.Printf("circuit breaker open for %v after %d consecutive failures: %v", b.cooldown, b.failures, err)
+		}
+	default:
+		if b.state != breakerClosed {
+			log.Printf("circuit breaker closed: the LLM provider answered")
+		}
+		b.state, b.failures = breakerClosed, 0
+	}
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+	if b.threshold == 0 {
+		return BreakerStatus{State: breakerClosed}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := BreakerStatus{State: b.state, Failures: b.failures}
+	if b.state != breakerClosed {
+		openedAt := b.openedAt
+		s.OpenedAt = &openedAt
+	}
+	if b.state == breakerOpen {
+		// past the cooldown, the next call is the probe
+		if wait := time.Until(b.until); wait > 0 {
+			s.RetryAfter = int(math.Ceil(wait.Seconds()))
+		} else {
+			s.State = breakerHalfOpen
+		}
+	}
+	return s
+}
+
+// retryAfterSeconds is the Retry-After value for a wait, at least 1.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(max(int(math.Ceil(d.Seconds())), 1))
+}
+
+// breakerProvider fails calls fast while the breaker is open.
+type breakerProvider struct {
+	texttools.StreamProvider
+	breaker *CircuitBreaker
+}
+
+func (p breakerProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	if wait, ok := p.breaker.allow(); !ok {
+		return texttools.Completion{}, reject(ctx, "the LLM provider is failing", wait)
+	}
+	c, err := p.StreamProvider.Complete(ctx, req)
+	p.breaker.done(ctx, err)
+	return c, err
+}
+
+func (p breakerProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	if wait, ok := p.breaker.allow(); !ok {
+		return texttools.Completion{}, reject(ctx, "the LLM provider is failing", wait)
+	}
+	c, err := p.StreamProvider.Stream(ctx, req, onDelta)
+	p.breaker.done(ctx, err)
+	return c, err
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Chat export summaries ---
+//
+// POST /summarize/chat takes an exported conversation, parses it locally
+// into messages and sends the model a compact "Author: text" transcript.
+
+// Chat export formats.
+const (
+	chatAuto     = "auto"
+	chatWhatsApp = "whatsapp"
+	chatSlack    = "slack"
+	chatPlain    = "plain"
+)
+
+var chatFormats = []string{chatAuto, chatWhatsApp, chatSlack, chatPlain}
+
+type ChatSummaryRequest struct {
+	Text   string `json:"text"`
+	Format string `json:"format"` // see chatFormats; auto-detected by default
+	Options
+}
+
+type ChatSummaryResponse struct {
+	Summary             string   `json:"summary"`
+	Decisions           []string `json:"decisions"`
+	UnansweredQuestions []string `json:"unanswered_questions"`
+	Format              string   `json:"format"` // the format the chat was parsed as
+	Messages            int      `json:"messages"`
+	Participants        []string `json:"participants"`
+	ResultMeta
+}
+
+type chatMessage struct {
+	Author string
+	Text   string
+}
+
+// chatPromptInput is the data of the summarize-chat template.
+type chatPromptInput struct {
+	texttools.Input
+	Messages     int
+	Participants string
+}
+
+func summarizeChatHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ChatSummaryRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if req.Format == "" {
+			req.Format = chatAuto
+		}
+		if !slices.Contains(chatFormats, req.Format) {
+			http.Error(w, "`format` must be one of "+strings.Join(chatFormats, ", "), http.StatusBadRequest)
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		format, msgs, err := parseChat(req.Text, req.Format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		key := historyKey(req.Format, req.Options)
+		if prior, ok := history.Reusable(r, "summarize-chat", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		participants := chatParticipants(msgs)
+		in := chatPromptInput{
+			Input:        texttools.Input{Text: chatTranscript(msgs), Options: req.Options.Options},
+			Messages:     len(msgs),
+			Participants: strings.Join(participants, ", "),
+		}
+		out, err := tools.Prompt(r.Context(), "summarize-chat", in, in.Options)
+		if err != nil {
+			log.Println("summarize-chat error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var resp ChatSummaryResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			// fallback – treat the whole output as the summary
+			resp = ChatSummaryResponse{Summary: out}
+		}
+		resp.Summary = texttools.FormatOutput(tools.FitWords(r.Context(), resp.Summary, req.Options.Options), req.OutputFormat)
+		if resp.Decisions == nil {
+			resp.Decisions = []string{}
+		}
+		if resp.UnansweredQuestions == nil {
+			resp.UnansweredQuestions = []string{}
+		}
+		resp.Format, resp.Messages, resp.Participants = format, len(msgs), participants
+
+		resp.Summary = outputWrappers.Wrap("summarize-chat", resp.Summary, req.Options)
+		resp.ResultMeta = history.Record(r, "summarize-chat", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// --- Parsing ---
+
+// parseChat parses text in the given format, detecting it for "auto", and
+// returns the format used.
+func parseChat(text, format string) (string, []chatMessage, error) {
+	// WhatsApp puts narrow no-break spaces before AM/PM and direction marks
+	// before lines.
+	text = strings.NewReplacer("\r\n", "\n", "\u202f", " ", "\u00a0", " ", "\u200e", "", "\u200f", "").Replace(text)
+	if format == chatAuto {
+		format = detectChatFormat(text)
+	}
+
+	var msgs []chatMessage
+	var err error
+	switch format {
+	case chatSlack:
+		msgs, err = parseSlackChat(text)
+	case chatWhatsApp:
+		msgs = parseLineChat(text, parseWhatsAppLine)
+	default:
+		msgs = parseLineChat(text, parsePlainLine)
+	}
+	if err != nil {
+		return format, nil, err
+	}
+	if len(msgs) == 0 {
+		return format, nil, fmt.Errorf("no chat messages found (parsed as %s)", format)
+	}
+	return format, msgs, nil
+}
+
+func detectChatFormat(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if strings.HasPrefix(trimmed, "[{") || strings.HasPrefix(trimmed, "[\n") || strings.HasPrefix(trimmed, "{") {
+		if json.Valid([]byte(trimmed)) {
+			return chatSlack
+		}
+	}
+	for _, line := range strings.SplitN(trimmed, "\n", 5) {
+		if whatsAppLineRe.MatchString(line) {
+			return chatWhatsApp
+		}
+	}
+	return chatPlain
+}
+
+// parseLineChat parses line-based exports. parseLine reports whether a line
+// starts a new message; other lines continue the previous one. Messages
+// without an author (WhatsApp system messages) or with only an attachment
+// are dropped.
+func parseLineChat(text string, parseLine func(string) (chatMessage, bool)) []chatMessage {
+	var msgs []chatMessage
+	for _, line := range strings.Split(text, "\n") {
+		if m, ok := parseLine(line); ok {
+			msgs = append(msgs, m)
+			continue
+		}
+		if len(msgs) > 0 && strings.TrimSpace(line) != "" {
+			last := &msgs[len(msgs)-1]
+			last.Text += "\n" + strings.TrimSpace(line)
+		}
+	}
+
+	out := msgs[:0]
+	for _, m := range msgs {
+		m.Text = strings.TrimSpace(m.Text)
+		if m.Author == "" || m.Text == "" || chatNoiseRe.MatchString(m.Text) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+var (
+	// "12/31/23, 9:41 PM - Alice: Hi" (Android) or
+	// "[31.12.23, 21:41:05] Alice: Hi" (iOS)
+	whatsAppLineRe = regexp.MustCompile(`^\[?\d{1,4}[./-]\d{1,2}[./-]\d{1,4},? \d{1,2}[:.]\d{2}(?:[:.]\d{2})?(?: ?[AaPp]\.? ?[Mm]\.?)?\]?(?: -)? (.*)$`)
+
+	// "Alice: Hi", optionally after a timestamp like "[10:32]" or
+	// "2024-01-02 10:32"
+	plainLineRe = regexp.MustCompile(`^(?:\[?[\d:/.,\- ]*\d(?: ?[AaPp][Mm])?\]?\s+)?([\p{L}\p{N}@][\p{L}\p{N} ._'@-]{0,39}):\s+(.*)$`)
+
+	// attachments and deleted messages, as exported
+	chatNoiseRe = regexp.MustCompile(`(?i)^(?:<media omitted>|<attached: [^>]*>|(?:image|video|audio|sticker|gif|document) omitted|this message was deleted|you deleted this message)$`)
+)
+
+func parseWhatsAppLine(line string) (chatMessage, bool) {
+	m := whatsAppLineRe.FindStringSubmatch(line)
+	if m == nil {
+		return chatMessage{}, false
+	}
+	author, text, ok := strings.Cut(m[1], ": ")
+	if !ok {
+		// system message, e.g. "Alice added Bob"
+		return chatMessage{}, true
+	}
+	return chatMessage{Author: strings.TrimSpace(author), Text: text}, true
+}
+
+func parsePlainLine(line string) (chatMessage, bool) {
+	m := plainLineRe.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil || strings.HasPrefix(strings.ToLower(m[1]), "http") {
+		return chatMessage{}, false
+	}
+	return chatMessage{Author: strings.TrimSpace(m[1]), Text: m[2]}, true
+}
+
+// slackMessage is a message of a Slack channel export (one day's JSON file).
+type slackMessage struct {
+	Type        string `json:"type"`
+	Subtype     string `json:"subtype"`
+	User        string `json:"user"`
+	Username    string `json:"username"` // bots
+	Text        string `json:"text"`
+	UserProfile struct {
+		RealName    string `json:"real_name"`
+		DisplayName string `json:"display_name"`
+	} `json:"user_profile"`
+}
+
+var (
+	slackMentionRe = regexp.MustCompile(`<@([A-Z0-9]+)(?:\|([^>]+))?>`)
+	slackLinkRe    = regexp.MustCompile(`<([^@!#][^|>]*)(?:\|([^>]+))?>`)
+)
+
+func parseSlackChat(text string) ([]chatMessage, error) {
+	var raw []slackMessage
+	if err := json.Unmarshal([]byte(text), &raw); err != nil {
+		var single slackMessage
+		if err2 := json.Unmarshal([]byte(text), &single); err2 != nil {
+			return nil, errors.New("invalid Slack export: expected a JSON array of messages")
+		}
+		raw = []slackMessage{single}
+	}
+
+	names := map[string]string{}
+	for _, m := range raw {
+		if name := firstNonEmpty(m.UserProfile.DisplayName, m.UserProfile.RealName); name != "" && m.User != "" {
+			names[m.User] = name
+		}
+	}
+
+	var msgs []chatMessage
+	for _, m := range raw {
+		if m.Type != "message" || (m.Subtype != "" && m.Subtype != "bot_message" && m.Subtype != "thread_broadcast") {
+			continue // joins, topic changes, ...
+		}
+		body := slackMentionRe.ReplaceAllStringFunc(m.Text, func(s string) string {
+			sm := slackMentionRe.FindStringSubmatch(s)
+			return "@" + firstNonEmpty(names[sm[1]], sm[2], sm[1])
+		})
+		body = slackLinkRe.ReplaceAllStringFunc(body, func(s string) string {
+			sm := slackLinkRe.FindStringSubmatch(s)
+			return firstNonEmpty(sm[2], sm[1])
+		})
+		body = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(strings.TrimSpace(body))
+		author := firstNonEmpty(names[m.User], m.Username, m.User)
+		if body == "" || author == "" {
+			continue
+		}
+		msgs = append(msgs, chatMessage{Author: author, Text: body})
+	}
+	return msgs, nil
+}
+
+// --- Normalization ---
+
+// chatTranscript renders messages as "Author: text" lines, indenting
+// continuation lines.
+func chatTranscript(msgs []chatMessage) string {
+	var sb strings.Builder
+	for _, m := range msgs {
+		sb.WriteString(m.Author)
+		sb.WriteString(": ")
+		sb.WriteString(strings.ReplaceAll(m.Text, "\n", "\n  "))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// chatParticipants returns the authors in order of their first message.
+func chatParticipants(msgs []chatMessage) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, m := range msgs {
+		if !seen[m.Author] {
+			seen[m.Author] = true
+			out = append(out, m.Author)
+		}
+	}
+	return out
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
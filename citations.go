@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Citations ---
+//
+// POST /citations finds the factual claims in a text, for fact-checking:
+// each claim with its character offsets, its kind, whether the text already
+// attributes it to a source, whether it needs one, and search queries to
+// verify it with. As with /ask, the model quotes the claims and they are
+// located in the text here; claims that can't be found are dropped.
+
+// Claim kinds.
+var claimKinds = []string{"statistic", "quote", "event", "scientific", "other"}
+
+// maxClaimQueries caps the search queries suggested per claim.
+const maxClaimQueries = 3
+
+type CitationsRequest struct {
+	Text string `json:"text"`
+	Options
+}
+
+type CitationsResponse struct {
+	Claims        []Claim `json:"claims"` // in text order
+	NeedsSourcing int     `json:"needs_sourcing"`
+	ResultMeta
+}
+
+// Claim is a factual claim; Start and End are offsets in Unicode characters
+// into the text, End exclusive.
+type Claim struct {
+	Text        string   `json:"text"`
+	Start       int      `json:"start"`
+	End         int      `json:"end"`
+	Kind        string   `json:"kind"`
+	Cited       bool     `json:"cited"`        // the text attributes it to a source
+	NeedsSource bool     `json:"needs_source"` // a reader would want a source for it
+	Reason      string   `json:"reason"`       // why it needs one, or doesn't
+	Queries     []string `json:"queries"`      // search queries to verify it
+}
+
+func citationsHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CitationsRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey("", req.Options)
+		if prior, ok := history.Reusable(r, "citations", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		// The text goes to the model as submitted (no input_format
+		// conversion), so that what it quotes can be found in it.
+		in := texttools.Input{Text: req.Text, Options: req.Options.Options}
+		out, err := tools.Prompt(r.Context(), "citations", in, in.Options)
+		if err != nil {
+			log.Println("citations error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var found struct {
+			Claims []Claim `json:"claims"`
+		}
+		if err := json.Unmarshal([]byte(out), &found); err != nil {
+			logUnparseable(r.Context(), "citations", out)
+		}
+		resp := CitationsResponse{Claims: locateClaims(req.Text, found.Claims)}
+		for _, c := range resp.Claims {
+			if c.NeedsSource {
+				resp.NeedsSourcing++
+			}
+		}
+
+		resp.ResultMeta = history.Record(r, "citations", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// locateClaims finds the claims in text, with locateQuotes, and returns
+// them in text order, each once.
+func locateClaims(text string, claims []Claim) []Claim {
+	out := []Claim{}
+	for _, c := range claims {
+		q := locateQuotes(text, []string{c.Text})
+		if len(q) == 0 || slices.ContainsFunc(out, func(o Claim) bool { return o.Start == q[0].Start && o.End == q[0].End }) {
+			continue
+		}
+		c.Text, c.Start, c.End = q[0].Text, q[0].Start, q[0].End
+		if c.Kind = strings.ToLower(strings.TrimSpace(c.Kind)); !slices.Contains(claimKinds, c.Kind) {
+			c.Kind = "other"
+		}
+		c.Reason = strings.TrimSpace(c.Reason)
+		queries := []string{}
+		for _, query := range c.Queries {
+			if query = strings.TrimSpace(query); query != "" && len(queries) < maxClaimQueries {
+				queries = append(queries, query)
+			}
+		}
+		c.Queries = queries
+		out = append(out, c)
+	}
+	slices.SortFunc(out, func(a, b Claim) int { return a.Start - b.Start })
+	return out
+}
@@ -0,0 +1,250 @@
+// Command ai-text runs the text operations from the terminal, using the same
+// texttools package as the server:
+//
+//	ai-text summarize notes.txt
+//	cat report.md | ai-text -length short -json summarize
+//	ai-text -tone friendly rewrite - < draft.txt
+//
+// The input is the named file, or stdin when it is omitted or "-". Results
+// go to stdout: text (list results one per line) or, with -json, the same
+// JSON the HTTP API returns. OPENAI_API_KEY must be set, unless PROVIDER=mock
+// selects the offline mock provider.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+var operations = []string{"summarize", "keywords", "rewrite", "questions", "titles", "expand", "to-bullets", "to-prose"}
+
+// resultKeys are the JSON keys the HTTP API uses for each operation.
+var resultKeys = map[string]string{
+	"summarize":  "summary",
+	"keywords":   "keywords",
+	"rewrite":    "text",
+	"questions":  "questions",
+	"titles":     "titles",
+	"expand":     "text",
+	"to-bullets": "text",
+	"to-prose":   "text",
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "ai-text:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	var (
+		opts       texttools.Options
+		tone       string
+		styleGuide string
+		asJSON     bool
+		stream     bool
+		maxBytes   int64
+	)
+	fs := flag.NewFlagSet("ai-text", flag.ContinueOnError)
+	fs.StringVar(&opts.Length, "length", "", "summary length: short, medium or long")
+	fs.StringVar(&opts.Language, "language", "", "output language, e.g. Spanish")
+	fs.StringVar(&opts.Model, "model", "", "model name (default "+texttools.DefaultModel+")")
+	fs.StringVar(&opts.InputFormat, "input-format", "", "plain, markdown or html (default: from the file extension)")
+	fs.StringVar(&opts.OutputFormat, "output-format", "", "plain, markdown or html")
+	fs.StringVar(&tone, "tone", "", "tone for rewrite (default neutral)")
+	fs.StringVar(&styleGuide, "style-guide", "", "style guide `file` to follow when rewriting")
+	fs.Func("temperature", "sampling temperature, 0–2 (0 for deterministic output)", floatFlag(&opts.Temperature))
+	fs.Func("top-p", "nucleus sampling probability mass, 0–1", floatFlag(&opts.TopP))
+	fs.IntVar(&opts.MaxTokens, "max-tokens", 0, "cap on the output length in tokens")
+	fs.IntVar(&opts.MaxWords, "max-words", 0, "cap on the output length in words, enforced after generation")
+	fs.Func("seed", "seed for best-effort reproducible output", func(s string) error {
+		v, err := strconv.ParseInt(s, 10, 64)
+		opts.Seed = &v
+		return err
+	})
+	fs.BoolVar(&asJSON, "json", false, "write the result as JSON")
+	fs.BoolVar(&stream, "stream", false, "print summarize, rewrite, expand, to-bullets and to-prose output as it arrives")
+	fs.Int64Var(&maxBytes, "max-bytes", 1<<20, "refuse inputs larger than `n` bytes")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ai-text [flags] <%s> [file|-]\n", strings.Join(operations, "|"))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errors.New("expected an operation and at most one file")
+	}
+	op, file := fs.Arg(0), fs.Arg(1)
+	if !slices.Contains(operations, op) {
+		return fmt.Errorf("unknown operation %q", op)
+	}
+	if stream && asJSON {
+		return errors.New("-stream and -json can't be combined")
+	}
+	if stream && !slices.Contains(texttools.StreamOperations, op) {
+		return fmt.Errorf("%s can't be streamed", op)
+	}
+	if opts.InputFormat == "" {
+		opts.InputFormat = formatFromExt(file)
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	text, err := readInput(file, stdin, maxBytes)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(text) == "" {
+		return errors.New("input is empty")
+	}
+
+	var provider texttools.Provider = &texttools.Mock{}
+	switch os.Getenv("PROVIDER") {
+	case "mock":
+	case "azure":
+		apiKey := os.Getenv("AZURE_OPENAI_API_KEY")
+		azure := &texttools.AzureConfig{
+			Endpoint:   os.Getenv("AZURE_OPENAI_ENDPOINT"),
+			Deployment: os.Getenv("AZURE_OPENAI_DEPLOYMENT"),
+			APIVersion: os.Getenv("AZURE_OPENAI_API_VERSION"),
+		}
+		if apiKey == "" || azure.Endpoint == "" || azure.Deployment == "" {
+			return errors.New("AZURE_OPENAI_API_KEY, AZURE_OPENAI_ENDPOINT and AZURE_OPENAI_DEPLOYMENT env vars are required")
+		}
+		provider = &texttools.OpenAI{APIKey: apiKey, Azure: azure}
+	default:
+		apiKey, baseURL := os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_BASE_URL")
+		if apiKey == "" && baseURL == "" {
+			return errors.New("OPENAI_API_KEY env var is required")
+		}
+		o := &texttools.OpenAI{APIKey: apiKey, Model: os.Getenv("OPENAI_MODEL")}
+		if baseURL != "" {
+			o.URL = strings.TrimSuffix(baseURL, "/") + "/chat/completions"
+		}
+		provider = o
+	}
+	tools := texttools.New(provider)
+	if styleGuide != "" {
+		b, err := os.ReadFile(styleGuide)
+		if err != nil {
+			return err
+		}
+		tools.StyleGuides = map[string]string{"rewrite": strings.TrimSpace(string(b))}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if stream {
+		_, err := tools.Stream(ctx, op, texttools.Input{Text: text, Tone: tone, Options: opts}, func(delta string) error {
+			_, err := io.WriteString(stdout, delta)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdout)
+		return err
+	}
+
+	result, err := runOperation(ctx, tools, op, text, tone, opts)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{resultKeys[op]: result})
+	}
+	switch r := result.(type) {
+	case []string:
+		for _, item := range r {
+			if _, err := fmt.Fprintln(stdout, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		_, err := fmt.Fprintln(stdout, strings.TrimRight(fmt.Sprint(r), "\n"))
+		return err
+	}
+}
+
+func runOperation(ctx context.Context, tools *texttools.Tools, op, text, tone string, opts texttools.Options) (interface{}, error) {
+	switch op {
+	case "summarize":
+		return tools.Summarize(ctx, text, opts)
+	case "keywords":
+		return tools.Keywords(ctx, text, opts)
+	case "rewrite":
+		return tools.Rewrite(ctx, text, tone, opts)
+	case "questions":
+		return tools.Questions(ctx, text, opts)
+	case "titles":
+		return tools.Titles(ctx, text, opts)
+	case "to-bullets":
+		return tools.ToBullets(ctx, text, opts)
+	case "to-prose":
+		return tools.ToProse(ctx, text, opts)
+	default:
+		return tools.Expand(ctx, text, opts)
+	}
+}
+
+// floatFlag sets *p only when the flag is given, so 0 differs from unset.
+func floatFlag(p **float64) func(string) error {
+	return func(s string) error {
+		v, err := strconv.ParseFloat(s, 64)
+		*p = &v
+		return err
+	}
+}
+
+// readInput reads file, or stdin if file is "" or "-".
+func readInput(file string, stdin io.Reader, maxBytes int64) (string, error) {
+	r := stdin
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(b)) > maxBytes {
+		return "", fmt.Errorf("input is larger than %d bytes", maxBytes)
+	}
+	return string(b), nil
+}
+
+// formatFromExt guesses the input format from a file name.
+func formatFromExt(file string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".md", ".markdown":
+		return texttools.FormatMarkdown
+	case ".html", ".htm":
+		return texttools.FormatHTML
+	}
+	return ""
+}
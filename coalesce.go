@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Request coalescing ---
+//
+// Identical LLM calls that run at the same time — the same operation,
+// model, prompt and parameters, e.g. several teammates pasting the same
+// document — are made once: the first one goes to the provider and the
+// others wait for its completion, which all of them get. Only calls of the
+// same tenant are shared. The call runs under its policy and counts (in
+// metrics, tenant usage and the audit log) once, for the request that made
+// it; it is cancelled only when every request waiting for it is gone.
+// Streams aren't coalesced. LLM_DISABLE_COALESCING=true turns this off.
+
+// coalescingProvider shares the completions of identical concurrent calls
+// of the wrapped provider.
+type coalescingProvider struct {
+	texttools.StreamProvider
+
+	mu    sync.Mutex
+	calls map[string]*sharedCall
+}
+
+// sharedCall is a call in flight and the requests waiting for it.
+type sharedCall struct {
+	done    chan struct{} // closed when c and err are set
+	c       texttools.Completion
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newCoalescingProvider(p texttools.StreamProvider) *coalescingProvider {
+	return &coalescingProvider{StreamProvider: p, calls: map[string]*sharedCall{}}
+}
+
+func (p *coalescingProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	key, err := coalesceKey(ctx, req)
+	if err != nil {
+		return p.StreamProvider.Complete(ctx, req)
+	}
+
+	p.mu.Lock()
+	call, shared := p.calls[key]
+	if !shared {
+		// The call keeps the values of ctx (tenant, priority, ...) but
+		// outlives it while others wait.
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &sharedCall{done: make(chan struct{}), cancel: cancel}
+		p.calls[key] = call
+		go func() {
+			call.c, call.err = p.StreamProvider.Complete(callCtx, req)
+			p.mu.Lock()
+			if p.calls[key] == call {
+				delete(p.calls, key)
+			}
+			p.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	p.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		p.mu.Lock()
+		if call.waiters--; call.waiters == 0 {
+			call.cancel()
+			if p.calls[key] == call {
+				delete(p.calls, key)
+			}
+		}
+		p.mu.Unlock()
+		return texttools.Completion{}, ctx.Err()
+	}
+	if shared {
+		metrics.RecordCoalesced()
+		// a rejection answers 503 to everyone waiting
+		var rejected *rejectedError
+		if errors.As(call.err, &rejected) {
+			return texttools.Completion{}, reject(ctx, rejected.Reason, rejected.RetryAfter)
+		}
+	}
+	return call.c, call.err
+}
+
+// coalesceKey identifies the calls that get the same completion: those of
+// the same request of the same tenant, at the same priority and privacy
+// mode. The call runs with the values of the first request's context and
+// is charged to its tenant, so that must be the tenant of every request
+// sharing it; and an interactive call mustn't wait on a batch one.
+func coalesceKey(ctx context.Context, req texttools.Request) (string, error) {
+	tenant := ""
+	if slot, ok := tenantFrom(ctx); ok {
+		tenant = slot.name
+	}
+	b, err := json.Marshal(struct {
+		Tenant   string
+		Priority Priority
+		Private  bool
+		Request  texttools.Request
+	}{tenant, priorityOf(ctx), privacyMode(ctx), req})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
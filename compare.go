@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Text comparison ---
+//
+// POST /compare takes two texts, e.g. two versions of a contract or two
+// drafts of an article, and returns how they differ, what they share and
+// one summary covering both.
+
+type CompareRequest struct {
+	TextA  string `json:"text_a"`
+	TextB  string `json:"text_b"`
+	LabelA string `json:"label_a"` // how to refer to each text, "Text A" and "Text B" by default
+	LabelB string `json:"label_b"`
+	Options
+}
+
+type CompareResponse struct {
+	Summary      string       `json:"summary"`
+	Similarities []string     `json:"similarities"`
+	Differences  []Difference `json:"differences"`
+	ResultMeta
+}
+
+// Difference is one point on which the texts differ.
+type Difference struct {
+	Aspect string `json:"aspect"`
+	A      string `json:"a"` // what text A says
+	B      string `json:"b"` // what text B says
+}
+
+// comparePromptInput is the data of the compare template.
+type comparePromptInput struct {
+	texttools.Options
+	TextA, TextB   string
+	LabelA, LabelB string
+}
+
+func compareHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CompareRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.TextA == "" || req.TextB == "" {
+			http.Error(w, "`text_a` and `text_b` are required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.TextA) || !checkText(w, req.TextB) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+		if req.LabelA == "" {
+			req.LabelA = "Text A"
+		}
+		if req.LabelB == "" {
+			req.LabelB = "Text B"
+		}
+
+		input := req.TextA + "\n\n---\n\n" + req.TextB
+		key := historyKey(req.LabelA+"|"+req.LabelB, req.Options)
+		if prior, ok := history.Reusable(r, "compare", key, input, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		in := comparePromptInput{
+			Options: req.Options.Options,
+			TextA:   texttools.PrepareInput(req.TextA, req.InputFormat),
+			TextB:   texttools.PrepareInput(req.TextB, req.InputFormat),
+			LabelA:  req.LabelA,
+			LabelB:  req.LabelB,
+		}
+		out, err := tools.Prompt(r.Context(), "compare", in, in.Options)
+		if err != nil {
+			log.Println("compare error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var resp CompareResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			// fallback – treat the whole output as the summary
+			resp = CompareResponse{Summary: out}
+		}
+		resp.Summary = texttools.FormatOutput(tools.FitWords(r.Context(), resp.Summary, req.Options.Options), req.OutputFormat)
+		if resp.Similarities == nil {
+			resp.Similarities = []string{}
+		}
+		if resp.Differences == nil {
+			resp.Differences = []Difference{}
+		}
+
+		resp.Summary = outputWrappers.Wrap("compare", resp.Summary, req.Options)
+		resp.ResultMeta = history.Record(r, "compare", key, input, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
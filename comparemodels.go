@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Model comparison ---
+//
+// POST /compare-models runs a built-in operation on the same text with
+// 2–4 models at once and returns each model's result side by side, with
+// its latency, tokens and cost (at the prices of MODEL_PRICES, see
+// dryrun.go), to judge whether a bigger model is worth it. The models are
+// the request's "models", or COMPARE_MODELS. A model that fails gets an
+// error in its place; the request only fails if they all do.
+
+const (
+	minCompareModels = 2
+	maxCompareModels = 4
+)
+
+type CompareModelsRequest struct {
+	Operation string   `json:"operation"` // a built-in operation, summarize by default
+	Text      string   `json:"text"`
+	Tone      string   `json:"tone"`   // for rewrite
+	Models    []string `json:"models"` // 2–4; COMPARE_MODELS by default
+	Options
+}
+
+type CompareModelsResponse struct {
+	Operation string            `json:"operation"`
+	Results   []ModelComparison `json:"results"` // in the order of the models
+}
+
+// ModelComparison is the outcome of the operation with one model.
+type ModelComparison struct {
+	Model            string      `json:"model"`
+	Result           interface{} `json:"result,omitempty"` // the operation's response
+	Error            string      `json:"error,omitempty"`
+	ErrorCode        string      `json:"error_code,omitempty"` // e.g. context_length_exceeded, see llmerrors.go
+	LatencyMS        int64       `json:"latency_ms"`
+	LLMCalls         int         `json:"llm_calls"`
+	PromptTokens     int         `json:"prompt_tokens"`
+	CompletionTokens int         `json:"completion_tokens"`
+	TotalTokens      int         `json:"total_tokens"`
+	UsageEstimated   bool        `json:"usage_estimated,omitempty"`
+	CostUSD          *float64    `json:"cost_usd,omitempty"` // when the model has a price
+}
+
+// compareModelsFromEnv returns the models of COMPARE_MODELS.
+func compareModelsFromEnv() []string {
+	var models []string
+	for _, m := range strings.Split(os.Getenv("COMPARE_MODELS"), ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+func compareModelsHandler(tools *texttools.Tools, prefs *PreferenceStore, prices ModelPrices, defaults []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CompareModelsRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Operation == "" {
+			req.Operation = "summarize"
+		}
+		if !isBuiltinOperation(req.Operation) {
+			http.Error(w, fmt.Sprintf("unknown `operation` %q (one of %s)", req.Operation, strings.Join(builtinOperations, ", ")), http.StatusBadRequest)
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if len(req.Models) == 0 {
+			req.Models = defaults
+		}
+		if err := checkCompareModels(req.Models); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		resp := CompareModelsResponse{Operation: req.Operation, Results: make([]ModelComparison, len(req.Models))}
+		errs := make([]error, len(req.Models))
+		var wg sync.WaitGroup
+		for i, model := range req.Models {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				opts := req.Options
+				opts.Model = model
+				ctx, slot := withMetaSlot(r.Context())
+				start := time.Now()
+				result, err := runOperation(ctx, tools, req.Operation, RewriteRequest{Text: req.Text, Tone: req.Tone, Options: opts})
+				c := ModelComparison{Model: model, Result: result, LatencyMS: time.Since(start).Milliseconds()}
+				if err != nil {
+					log.Printf("compare-models error (%s): %v", model, err)
+					f := classifyLLMError(err)
+					c.Result, c.Error, c.ErrorCode = nil, f.Message, f.Code
+					errs[i] = err
+				}
+				slot.mu.Lock()
+				m := slot.meta
+				slot.mu.Unlock()
+				c.LLMCalls, c.PromptTokens, c.CompletionTokens = m.LLMCalls, m.PromptTokens, m.CompletionTokens
+				c.TotalTokens, c.UsageEstimated = m.PromptTokens+m.CompletionTokens, m.UsageEstimated
+				if price, ok := prices.For(model); ok && m.LLMCalls > 0 {
+					cost := roundCost((float64(m.PromptTokens)*price.Prompt + float64(m.CompletionTokens)*price.Completion) / 1e6)
+					c.CostUSD = &cost
+				}
+				resp.Results[i] = c
+			}()
+		}
+		wg.Wait()
+
+		if !slices.ContainsFunc(resp.Results, func(c ModelComparison) bool { return c.Error == "" }) {
+			writeLLMError(w, errs[0])
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// checkCompareModels checks that models are 2–4 distinct model names.
+func checkCompareModels(models []string) error {
+	if len(models) < minCompareModels || len(models) > maxCompareModels {
+		return fmt.Errorf("`models` must list %d to %d models (got %d)", minCompareModels, maxCompareModels, len(models))
+	}
+	seen := map[string]bool{}
+	for _, m := range models {
+		if strings.TrimSpace(m) == "" {
+			return fmt.Errorf("`models` must not contain empty names")
+		}
+		if seen[m] {
+			return fmt.Errorf("`models` lists %q twice", m)
+		}
+		seen[m] = true
+	}
+	return nil
+}
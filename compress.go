@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --- Response compression ---
+//
+// Responses are gzip- or deflate-compressed when the client accepts it
+// (Accept-Encoding; gzip is preferred) and they are worth it: at least
+// compressMinSize bytes of a text type. Expanded texts, history listings
+// and the UI page shrink to a fraction of their size. WebSocket upgrades,
+// range requests and bodies that are already encoded pass through as they
+// are.
+
+const compressMinSize = 1024
+
+// compressibleTypes are the media types worth compressing.
+var compressibleTypes = []string{
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+	"text/",
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	zlibWriters = sync.Pool{New: func() interface{} { return zlib.NewWriter(io.Discard) }}
+)
+
+// acceptedEncoding returns the encoding to compress a response in, gzip or
+// deflate, or "" if the client accepts neither.
+func acceptedEncoding(r *http.Request) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[strings.ToLower(strings.TrimSpace(name))] = weight
+	}
+	for _, enc := range []string{"gzip", "deflate"} {
+		w, ok := q[enc]
+		if !ok {
+			w, ok = q["*"]
+		}
+		if ok && w > 0 {
+			return enc
+		}
+	}
+	return ""
+}
+
+// compressible reports whether a response with these headers is worth
+// compressing.
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	ct := strings.ToLower(h.Get("Content-Type"))
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(ct, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// withCompression compresses responses for clients that accept it.
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := acceptedEncoding(r)
+		if enc == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" ||
+			strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: enc}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter holds the start of a response back until it knows
+// whether to compress it: once it has compressMinSize bytes, is flushed,
+// or ends.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	status      int
+	wroteHeader bool // by the handler
+	decided     bool
+	buf         bytes.Buffer
+	zw          interface {
+		io.Writer
+		Flush() error
+		Close() error
+	}
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status < 200 { // informational: pass through, the real one follows
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status, w.wroteHeader = status, true
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		if w.zw != nil {
+			return w.zw.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", http.DetectContentType(b))
+	}
+	w.buf.Write(b)
+	if w.buf.Len() >= compressMinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide writes the header, compressed if big is set and the response is
+// compressible, and the bytes held back.
+func (w *compressWriter) decide(big bool) error {
+	if w.decided {
+		return nil
+	}
+	w.decided = true
+	h := w.Header()
+	if compressible(h) {
+		h.Add("Vary", "Accept-Encoding")
+		if big {
+			h.Set("Content-Encoding", w.encoding)
+			h.Del("Content-Length")
+			if w.encoding == "gzip" {
+				gz := gzipWriters.Get().(*gzip.Writer)
+				gz.Reset(w.ResponseWriter)
+				w.zw = gz
+			} else {
+				zl := zlibWriters.Get().(*zlib.Writer)
+				zl.Reset(w.ResponseWriter)
+				w.zw = zl
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.zw != nil {
+		_, err = w.zw.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+// Flush sends what was written so far; a flushed response is compressed
+// whatever its size, since more is to come.
+func (w *compressWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if err := w.decide(true); err != nil {
+		return
+	}
+	if w.zw != nil {
+		_ = w.zw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close ends the response: a short one goes out uncompressed.
+func (w *compressWriter) close() {
+	if !w.wroteHeader {
+		return // nothing written, or the connection was hijacked
+	}
+	_ = w.decide(false)
+	if w.zw == nil {
+		return
+	}
+	_ = w.zw.Close()
+	switch zw := w.zw.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(zw)
+	case *zlib.Writer:
+		zlibWriters.Put(zw)
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Runtime configuration ---
+//
+// Some settings can be changed through the admin API without a restart:
+// the default model and temperature, the per-user rate limit of the LLM
+// endpoints, the prompt templates and prompt experiments (experiments.go).
+//
+//	GET    /admin/config                     the settings
+//	PATCH  /admin/config                     change some: {"temperature": 0.3, "rate_limit": 30}
+//	PUT    /admin/config/prompts/<name>      override a template: {"source": "..."}
+//	DELETE /admin/config/prompts/<name>      drop the override
+//	PUT    /admin/config/experiments/<op>    start an experiment: {"variants": {"control": 50, "concise": 50}}
+//	DELETE /admin/config/experiments/<op>    end it
+//	GET    /admin/config/audit               who changed what, newest first
+//
+// Settings and experiments are saved to CONFIG_FILE, which is loaded at
+// startup over the env defaults (LLM_TEMPERATURE, RATE_LIMIT, RATE_BURST);
+// templates are saved as files in the prompts dir. Every change is
+// recorded in the audit log, kept in CONFIG_FILE too.
+
+// maxAuditEntries is how many changes the audit log keeps.
+const maxAuditEntries = 1000
+
+// RuntimeSettings are the settings that can change at runtime.
+type RuntimeSettings struct {
+	Model       string   `json:"model,omitempty"`       // for requests that name none; the provider's default if empty
+	Temperature *float64 `json:"temperature,omitempty"` // for requests that set none; the model's default if null
+	RateLimit   int      `json:"rate_limit"`            // LLM requests per minute per user; unlimited if 0
+	RateBurst   int      `json:"rate_burst,omitempty"`  // requests allowed at once; rate_limit if 0
+}
+
+// ConfigChange is an entry of the audit log.
+type ConfigChange struct {
+	Time       time.Time       `json:"time"`
+	Actor      string          `json:"actor"` // the SSO user, else X-User-ID, else "default"
+	Auth       string          `json:"auth"`  // how the admin signed in: sso or token
+	RemoteAddr string          `json:"remote_addr"`
+	Setting    string          `json:"setting"` // e.g. temperature, or prompt:summarize
+	Old        json.RawMessage `json:"old"`
+	New        json.RawMessage `json:"new"`
+}
+
+// ConfigStore holds the runtime settings and their audit log.
+type ConfigStore struct {
+	file string
+
+	mu          sync.RWMutex
+	settings    RuntimeSettings
+	experiments map[string]PromptExperiment // by operation
+	audit       []ConfigChange              // oldest first
+
+	limiter limiter
+}
+
+// configFile is the CONFIG_FILE format.
+type configFile struct {
+	Settings    RuntimeSettings             `json:"settings"`
+	Experiments map[string]PromptExperiment `json:"experiments,omitempty"`
+	Audit       []ConfigChange              `json:"audit"`
+}
+
+var runtimeConfig = &ConfigStore{limiter: &rateLimiter{}}
+
+// NewConfigStoreFromEnv starts from the env defaults and loads CONFIG_FILE
+// over them.
+func NewConfigStoreFromEnv() (*ConfigStore, error) {
+	c := &ConfigStore{file: os.Getenv("CONFIG_FILE"), limiter: &rateLimiter{}}
+	c.settings = RuntimeSettings{
+		RateLimit: max(envInt("RATE_LIMIT", 0), 0),
+		RateBurst: max(envInt("RATE_BURST", 0), 0),
+	}
+	if v := os.Getenv("LLM_TEMPERATURE"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 || t > 2 {
+			return nil, fmt.Errorf("LLM_TEMPERATURE: must be a number between 0 and 2, got %q", v)
+		}
+		c.settings.Temperature = &t
+	}
+	if c.file == "" {
+		return c, nil
+	}
+	b, err := os.ReadFile(c.file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f configFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.file, err)
+	}
+	c.settings, c.experiments, c.audit = f.Settings, f.Experiments, f.Audit
+	return c, nil
+}
+
+// Reload loads the settings and experiments again, like at startup: from
+// the env defaults and CONFIG_FILE. Changes made through the admin API are
+// kept if they were saved to CONFIG_FILE.
+func (c *ConfigStore) Reload() error {
+	fresh, err := NewConfigStoreFromEnv()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.file, c.settings, c.experiments, c.audit = fresh.file, fresh.settings, fresh.experiments, fresh.audit
+	return nil
+}
+
+func (c *ConfigStore) Settings() RuntimeSettings {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.settings
+}
+
+// Model returns the default model set at runtime, if any.
+func (c *ConfigStore) Model() string {
+	return c.Settings().Model
+}
+
+// Audit returns the audit log, newest first.
+func (c *ConfigStore) Audit() []ConfigChange {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make([]ConfigChange, len(c.audit))
+	for i, e := range c.audit {
+		out[len(out)-1-i] = e
+	}
+	return out
+}
+
+// Update applies a PATCH body to the settings, records the changes made by
+// the admin of r and saves.
+func (c *ConfigStore) Update(r *http.Request, patch map[string]json.RawMessage) (RuntimeSettings, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.settings
+	for key, raw := range patch {
+		var err error
+		switch key {
+		case "model":
+			err = json.Unmarshal(raw, &s.Model)
+			s.Model = strings.TrimSpace(s.Model)
+		case "temperature":
+			s.Temperature = nil
+			if err = json.Unmarshal(raw, &s.Temperature); err == nil && s.Temperature != nil && (*s.Temperature < 0 || *s.Temperature > 2) {
+				err = errors.New("must be between 0 and 2")
+			}
+		case "rate_limit":
+			if err = json.Unmarshal(raw, &s.RateLimit); err == nil && s.RateLimit < 0 {
+				err = errors.New("must not be negative")
+			}
+		case "rate_burst":
+			if err = json.Unmarshal(raw, &s.RateBurst); err == nil && s.RateBurst < 0 {
+				err = errors.New("must not be negative")
+			}
+		default:
+			return c.settings, &inputError{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown setting %q", key)}
+		}
+		if err != nil {
+			return c.settings, &inputError{Status: http.StatusBadRequest, Message: fmt.Sprintf("%s: %v", key, err)}
+		}
+	}
+
+	old, _ := json.Marshal(c.settings)
+	updated, _ := json.Marshal(s)
+	var oldFields, newFields map[string]json.RawMessage
+	json.Unmarshal(old, &oldFields)
+	json.Unmarshal(updated, &newFields)
+	changed := false
+	for _, key := range []string{"model", "temperature", "rate_limit", "rate_burst"} {
+		if !bytes.Equal(oldFields[key], newFields[key]) {
+			c.recordLocked(r, key, oldFields[key], newFields[key])
+			changed = true
+		}
+	}
+	if !changed {
+		return s, nil
+	}
+	c.settings = s
+	return s, c.saveLocked()
+}
+
+// RecordPrompt records a change of the template name, from version old to
+// version updated ("" if there was none).
+func (c *ConfigStore) RecordPrompt(r *http.Request, name, old, updated string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recordLocked(r, "prompt:"+name, jsonVersion(old), jsonVersion(updated))
+	return c.saveLocked()
+}
+
+func jsonVersion(v string) json.RawMessage {
+	if v == "" {
+		return nil
+	}
+	b, _ := json.Marshal(v)
+	return b
+}
+
+func (c *ConfigStore) recordLocked(r *http.Request, setting string, old, updated json.RawMessage) {
+	auth := "token"
+	if l, ok := sso.Login(r); ok && l.Role == roleAdmin {
+		auth = "sso"
+	}
+	if old == nil {
+		old = json.RawMessage("null")
+	}
+	if updated == nil {
+		updated = json.RawMessage("null")
+	}
+	e := ConfigChange{
+		Time:       time.Now().UTC(),
+		Actor:      userID(r),
+		Auth:       auth,
+		RemoteAddr: r.RemoteAddr,
+		Setting:    setting,
+		Old:        old,
+		New:        updated,
+	}
+	log.Printf("config: %s changed %s from %s to %s", e.Actor, setting, old, updated)
+	c.audit = append(c.audit, e)
+	if len(c.audit) > maxAuditEntries {
+		c.audit = append([]ConfigChange(nil), c.audit[len(c.audit)-maxAuditEntries:]...)
+	}
+}
+
+func (c *ConfigStore) saveLocked() error {
+	if c.file == "" {
+		return nil
+	}
+	return writeJSONFile(c.file, configFile{Settings: c.settings, Experiments: c.experiments, Audit: c.audit})
+}
+
+// configProvider applies the runtime model and temperature to requests
+// that set neither.
+type configProvider struct {
+	texttools.StreamProvider
+}
+
+func (p configProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	return p.StreamProvider.Complete(ctx, runtimeConfig.apply(req))
+}
+
+func (p configProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	return p.StreamProvider.Stream(ctx, runtimeConfig.apply(req), onDelta)
+}
+
+func (c *ConfigStore) apply(req texttools.Request) texttools.Request {
+	s := c.Settings()
+	if req.Model == "" {
+		req.Model = s.Model
+	}
+	if req.Temperature == nil && s.Temperature != nil {
+		t := *s.Temperature
+		req.Temperature = &t
+	}
+	return req
+}
+
+// --- Rate limit ---
+
+// limiter enforces the rate limit: rateLimiter in memory, or redisLimiter
+// for all replicas.
+type limiter interface {
+	allow(user string, perMinute, burst int) (remaining int, wait time.Duration, ok bool)
+}
+
+// rateLimiter is a token bucket per user.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token of user's bucket, which holds burst tokens and
+// refills at perMinute; if there is none, it returns how long until there
+// is.
+func (l *rateLimiter) allow(user string, perMinute, burst int) (remaining int, wait time.Duration, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	rate := float64(perMinute) / 60 // per second
+	if l.buckets == nil {
+		l.buckets = map[string]*tokenBucket{}
+	}
+	if len(l.buckets) > 10000 {
+		// forget the users whose buckets have refilled
+		for u, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*rate >= float64(burst) {
+				delete(l.buckets, u)
+			}
+		}
+	}
+	b, found := l.buckets[user]
+	if !found {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[user] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return 0, time.Duration((1 - b.tokens) / rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return int(b.tokens), 0, true
+}
+
+// llmRoutes are the endpoints that call the LLM, as "POST /summarize",
+// which the rate limit and tenant quotas apply to.
+var llmRoutes = func() map[string]bool {
+	routes := map[string]bool{}
+	for _, rt := range apiRoutes(true) {
+		if rt.Echo || rt.LLM {
+			routes[rt.Method+" "+rt.Path] = true
+		}
+	}
+	return routes
+}()
+
+// isLLMRequest returns whether r is to an endpoint that calls the LLM. All
+// the gRPC methods do.
+func isLLMRequest(r *http.Request) bool {
+	return llmRoutes[r.Method+" "+r.URL.Path] || isGRPCRequest(r)
+}
+
+// withRateLimit answers 429 to users over the rate limit of the LLM
+// endpoints.
+func withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := runtimeConfig.Settings()
+		if s.RateLimit == 0 || !isLLMRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		burst := s.RateBurst
+		if burst == 0 {
+			burst = s.RateLimit
+		}
+		remaining, wait, ok := runtimeConfig.limiter.allow(userID(r), s.RateLimit, burst)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.RateLimit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !ok {
+			w.Header().Set("Retry-After", retryAfterSeconds(wait))
+			http.Error(w, fmt.Sprintf("rate limit of %d requests per minute exceeded; retry in %s seconds", s.RateLimit, retryAfterSeconds(wait)), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- Admin handlers ---
+
+// adminConfigHandler serves GET and PATCH /admin/config.
+func adminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, runtimeConfig.Settings())
+	case http.MethodPatch:
+		var patch map[string]json.RawMessage
+		if !decodeJSON(w, r, &patch) {
+			return
+		}
+		s, err := runtimeConfig.Update(r, patch)
+		var ie *inputError
+		if errors.As(err, &ie) {
+			http.Error(w, ie.Message, ie.Status)
+			return
+		}
+		if err != nil {
+			log.Println("config error:", err)
+			http.Error(w, "failed to save the configuration", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// PromptUpdate is the body of PUT /admin/config/prompts/<name>.
+type PromptUpdate struct {
+	Source string `json:"source"`
+}
+
+// promptNameRe matches template names, including the variants of
+// experiments ("summarize@concise").
+var promptNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}(@[a-z0-9][a-z0-9_-]{0,31})?$`)
+
+// adminPromptHandler serves PUT and DELETE /admin/config/prompts/<name>.
+func adminPromptHandler(prompts *PromptRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/admin/config/prompts/")
+		if !promptNameRe.MatchString(name) {
+			http.Error(w, "invalid template name", http.StatusBadRequest)
+			return
+		}
+		old, _ := prompts.Get(name)
+		switch r.Method {
+		case http.MethodPut:
+			var req PromptUpdate
+			if !decodeJSON(w, r, &req) {
+				return
+			}
+			if strings.TrimSpace(req.Source) == "" {
+				http.Error(w, "`source` is required", http.StatusBadRequest)
+				return
+			}
+			if _, err := texttools.ParseTemplate(name, req.Source); err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			pt, err := prompts.Save(name, req.Source)
+			if err != nil {
+				log.Println("config error:", err)
+				http.Error(w, "failed to save the template", http.StatusInternalServerError)
+				return
+			}
+			if err := runtimeConfig.RecordPrompt(r, name, old.Version, pt.Version); err != nil {
+				log.Println("config error:", err)
+			}
+			writeJSON(w, http.StatusOK, pt)
+		case http.MethodDelete:
+			if err := prompts.Remove(name); errors.Is(err, errNoPromptOverride) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if err != nil {
+				log.Println("config error:", err)
+				http.Error(w, "failed to remove the template", http.StatusInternalServerError)
+				return
+			}
+			pt, _ := prompts.Get(name)
+			if err := runtimeConfig.RecordPrompt(r, name, old.Version, pt.Version); err != nil {
+				log.Println("config error:", err)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// adminAuditHandler serves GET /admin/config/audit.
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"changes": runtimeConfig.Audit()})
+}
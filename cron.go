@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Cron expressions ---
+//
+// Schedules use the five standard cron fields, minute hour day-of-month
+// month day-of-week, each "*", a number, a range "a-b", a step "*/n" or
+// "a-b/n", or a comma-separated list of those; day-of-week runs 0–6 from
+// Sunday (7 is Sunday too). As in cron, when both day fields are
+// restricted, a day matching either one matches. @hourly, @daily (or
+// @midnight), @weekly, @monthly and @yearly (or @annually) are shorthands.
+//
+// Times are wall-clock times of the schedule's timezone. As in cron, runs
+// at fixed hours don't get lost or doubled by daylight saving time: one
+// that falls in the hour skipped in spring happens at the end of it, and
+// one in the hour repeated in autumn happens the first time only. Runs of
+// every hour keep to real time instead.
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// cronSpec is a parsed cron expression: the allowed values of each field.
+type cronSpec struct {
+	minute, hour, dom, month, dow [64]bool
+	domAny, dowAny                bool
+	everyHour                     bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCron(expr string) (*cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if m, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression needs 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+	s := &cronSpec{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	sets := []*[64]bool{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, f := range fields {
+		if err := parseCronField(f, cronFields[i].min, cronFields[i].max, sets[i]); err != nil {
+			return nil, fmt.Errorf("cron %s: %w", cronFields[i].name, err)
+		}
+	}
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.everyHour = true
+	for h := 0; h < 24; h++ {
+		s.everyHour = s.everyHour && s.hour[h]
+	}
+	return s, nil
+}
+
+func parseCronField(f string, min, max int, set *[64]bool) error {
+	for _, part := range strings.Split(f, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(a)
+			hi, err2 = strconv.Atoi(b)
+			if err1 != nil || err2 != nil || lo > hi {
+				return fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return fmt.Errorf("invalid value %q", rng)
+			}
+			lo = n
+			if !hasStep {
+				hi = n
+			}
+		}
+		if lo < min || hi > max {
+			return fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return nil
+}
+
+// Next returns the first time after t that matches, in t's location, or
+// the zero time if none does within five years (e.g. "0 0 30 2 *").
+func (s *cronSpec) Next(t time.Time) time.Time {
+	from := t
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.month[t.Month()] {
+			t = afterGap(time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()), t)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = afterGap(time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()), t)
+			continue
+		}
+		if !s.everyHour && s.skippedHour(t) {
+			return t
+		}
+		if !s.hour[t.Hour()] {
+			// in real time: time.Date may pick either of a repeated hour
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if !s.minute[t.Minute()] || !s.everyHour && !wallClockAfter(t, from) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// afterGap returns next, the start of a later day or month than t,
+// unless it falls in a daylight saving time gap and time.Date put it back
+// before it: then the end of the gap, an hour later.
+func afterGap(next, t time.Time) time.Time {
+	if !next.After(t) {
+		return next.Add(time.Hour)
+	}
+	return next
+}
+
+// skippedHour reports whether t is the end of a daylight saving time gap
+// that skipped one of the hours of s.
+func (s *cronSpec) skippedHour(t time.Time) bool {
+	if t.Minute() != 0 {
+		return false
+	}
+	prev, from := t.Add(-time.Minute), 0
+	if prev.Day() == t.Day() {
+		from = prev.Hour() + 1
+	}
+	for h := from; h < t.Hour(); h++ {
+		if s.hour[h] {
+			return true
+		}
+	}
+	return false
+}
+
+// wallClockAfter reports whether the wall clock shows a later time at t
+// than at u, which it doesn't in the hour repeated when clocks go back.
+func wallClockAfter(t, u time.Time) bool {
+	wall := func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	}
+	return wall(t).After(wall(u))
+}
+
+func (s *cronSpec) dayMatches(t time.Time) bool {
+	dom, dow := s.dom[t.Day()], s.dow[t.Weekday()]
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct{ expr, wantErr string }{
+		{"", "needs 5 fields"},
+		{"* * * *", "needs 5 fields"},
+		{"* * * * * *", "needs 5 fields"},
+		{"@fortnightly", "needs 5 fields"},
+		{"60 * * * *", "minute"},
+		{"* 24 * * *", "hour"},
+		{"* * 0 * *", "day of month"},
+		{"* * 32 * *", "day of month"},
+		{"* * * 13 *", "month"},
+		{"* * * * 8", "day of week"},
+		{"*/0 * * * *", "invalid step"},
+		{"*/x * * * *", "invalid step"},
+		{"5-1 * * * *", "invalid range"},
+		{"1-x * * * *", "invalid range"},
+		{"x * * * *", "invalid value"},
+		{"1,,2 * * * *", "invalid value"},
+		{"0-60 * * * *", "out of range"},
+	}
+	for _, tt := range tests {
+		if _, err := parseCron(tt.expr); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseCron(%q): %v, want %q", tt.expr, err, tt.wantErr)
+		}
+	}
+	for _, expr := range []string{"@DAILY", " @hourly ", "*/15 9-17 * * 1-5", "0 0 * * 7", "0,30 8-18/2 1,15 */3 *"} {
+		if _, err := parseCron(expr); err != nil {
+			t.Errorf("parseCron(%q): %v", expr, err)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	santiago, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		t.Skip(err)
+	}
+	utc := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	in := func(loc *time.Location, s string) time.Time { return utc(s).In(loc) }
+
+	tests := []struct {
+		name, expr string
+		from, want time.Time // zero want: never
+	}{
+		{"next quarter hour", "*/15 * * * *", utc("2026-10-15 10:07:30"), utc("2026-10-15 10:15:00")},
+		{"not the same minute", "* * * * *", utc("2026-10-15 10:07:00"), utc("2026-10-15 10:08:00")},
+		{"weekdays, over a weekend", "0 9 * * 1-5", utc("2026-10-16 10:00:00"), utc("2026-10-19 09:00:00")},
+		{"sunday as 7", "0 0 * * 7", utc("2026-10-15 00:00:00"), utc("2026-10-18 00:00:00")},
+		{"either day field", "0 0 13 * 5", utc("2026-10-14 12:00:00"), utc("2026-10-16 00:00:00")},
+		{"into the next month", "@monthly", utc("2026-01-31 12:00:00"), utc("2026-02-01 00:00:00")},
+		{"past a short month", "0 0 31 * *", utc("2026-04-15 00:00:00"), utc("2026-05-31 00:00:00")},
+		{"leap day", "0 0 29 2 *", utc("2026-03-01 00:00:00"), utc("2028-02-29 00:00:00")},
+		{"never", "0 0 30 2 *", utc("2026-01-01 00:00:00"), time.Time{}},
+		{"into the next year", "@yearly", utc("2026-12-31 23:59:00"), utc("2027-01-01 00:00:00")},
+		{"last minute of the day", "59 23 * * *", utc("2026-02-28 23:59:00"), utc("2026-03-01 23:59:00")},
+
+		// Berlin: clocks go from 2:00 to 3:00 on 2026-03-29, and back from
+		// 3:00 to 2:00 on 2026-10-25
+		{"timezone", "0 7 * * *", in(berlin, "2026-07-01 06:00:00"), in(berlin, "2026-07-02 05:00:00")},
+		{"fixed hour in the spring gap", "30 2 * * *", in(berlin, "2026-03-28 02:00:00"), in(berlin, "2026-03-29 01:00:00")},
+		{"after the spring gap", "30 2 * * *", in(berlin, "2026-03-29 01:00:00"), in(berlin, "2026-03-30 00:30:00")},
+		{"every hour over the spring gap", "30 * * * *", in(berlin, "2026-03-29 00:30:00"), in(berlin, "2026-03-29 01:30:00")},
+		{"fixed hour before the autumn repeat", "30 2 * * *", in(berlin, "2026-10-24 12:00:00"), in(berlin, "2026-10-25 00:30:00")},
+		{"fixed hour not repeated in autumn", "30 2 * * *", in(berlin, "2026-10-25 00:30:00"), in(berlin, "2026-10-26 01:30:00")},
+		{"every hour in the autumn repeat", "30 * * * *", in(berlin, "2026-10-25 00:30:00"), in(berlin, "2026-10-25 01:30:00")},
+		{"daily over spring forward", "0 9 * * *", in(newYork, "2026-03-07 14:00:00"), in(newYork, "2026-03-08 13:00:00")},
+		{"daily over fall back", "0 9 * * *", in(newYork, "2026-10-31 13:00:00"), in(newYork, "2026-11-01 14:00:00")},
+		// Santiago: clocks go from 0:00 to 1:00 on 2026-09-06
+		{"midnight in the gap", "@daily", in(santiago, "2026-09-05 12:00:00"), in(santiago, "2026-09-06 04:00:00")},
+		{"day after a midnight gap", "0 12 * * *", in(santiago, "2026-09-05 17:00:00"), in(santiago, "2026-09-06 15:00:00")},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := c.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%s: %q from %s: %s, want %s", tt.name, tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestCronMinGap(t *testing.T) {
+	for expr, want := range map[string]time.Duration{
+		"*/5 * * * *":  5 * time.Minute,
+		"0,10 * * * *": 10 * time.Minute,
+		"@daily":       24 * time.Hour,
+		"0 9 * * 1-5":  24 * time.Hour,
+	} {
+		c, _ := parseCron(expr)
+		if got := cronMinGap(c, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)); got != want {
+			t.Errorf("cronMinGap(%q) = %s, want %s", expr, got, want)
+		}
+	}
+}
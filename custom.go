@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Custom operations ---
+
+// Output schemas a custom operation can declare.
+const (
+	outputText = "text" // plain text, returned as-is
+	outputList = "list" // JSON array of strings
+	outputJSON = "json" // arbitrary JSON value
+)
+
+var operationNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+type CustomOperation struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Prompt      string `json:"prompt"`
+	Output      string `json:"output"`
+
+	tmpl *template.Template
+}
+
+type CustomRequest struct {
+	Operation string            `json:"operation"` // a registered custom operation
+	Template  string            `json:"template"`  // or a template allowed by customTemplate
+	Output    string            `json:"output"`    // output schema for `template`, default text
+	Text      string            `json:"text"`
+	Vars      map[string]string `json:"vars"`
+	Options
+}
+
+type CustomResponse struct {
+	Operation string      `json:"operation"`
+	Result    interface{} `json:"result"`
+}
+
+// CustomOperations stores user-defined operations, optionally persisted to a
+// JSON file so they survive restarts.
+type CustomOperations struct {
+	file string
+
+	mu  sync.RWMutex
+	ops map[string]*CustomOperation
+}
+
+func NewCustomOperations(file string) (*CustomOperations, error) {
+	c := &CustomOperations{file: file, ops: map[string]*CustomOperation{}}
+	if file == "" {
+		return c, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ops []*CustomOperation
+	if err := json.Unmarshal(b, &ops); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for _, op := range ops {
+		if err := op.compile(); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		c.ops[op.Name] = op
+	}
+	return c, nil
+}
+
+func (op *CustomOperation) compile() error {
+	if !operationNameRe.MatchString(op.Name) {
+		return fmt.Errorf("invalid operation name %q (use a-z, 0-9, '-' and '_')", op.Name)
+	}
+	if strings.TrimSpace(op.Prompt) == "" {
+		return fmt.Errorf("operation %q: `prompt` is required", op.Name)
+	}
+	switch op.Output {
+	case "":
+		op.Output = outputText
+	case outputText, outputList, outputJSON:
+	default:
+		return fmt.Errorf("operation %q: output must be one of text, list, json", op.Name)
+	}
+	t, err := texttools.ParseTemplate(op.Name, op.Prompt)
+	if err != nil {
+		return err
+	}
+	op.tmpl = t
+	return nil
+}
+
+func (c *CustomOperations) Get(name string) (*CustomOperation, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	op, ok := c.ops[name]
+	return op, ok
+}
+
+func (c *CustomOperations) List() []*CustomOperation {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	out := make([]*CustomOperation, 0, len(c.ops))
+	for _, op := range c.ops {
+		out = append(out, op)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (c *CustomOperations) Put(op *CustomOperation) error {
+	if err := op.compile(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ops[op.Name] = op
+	return c.saveLocked()
+}
+
+func (c *CustomOperations) Delete(name string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.ops[name]; !ok {
+		return false, nil
+	}
+	delete(c.ops, name)
+	return true, c.saveLocked()
+}
+
+func (c *CustomOperations) saveLocked() error {
+	if c.file == "" {
+		return nil
+	}
+	ops := make([]*CustomOperation, 0, len(c.ops))
+	for _, op := range c.ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Name < ops[j].Name })
+	return writeJSONFile(c.file, ops)
+}
+
+// --- Custom operation handlers ---
+
+// customHandler serves both POST /custom (operation or template named in the
+// body) and POST /custom/<name>.
+func customHandler(tools *texttools.Tools, prefs *PreferenceStore, ops *CustomOperations) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req CustomRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if name := strings.TrimPrefix(r.URL.Path, "/custom/"); name != r.URL.Path {
+			req.Operation = name
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		// template data: the variables plus the text as {{.Text}}
+		data := make(map[string]string, len(req.Vars)+1)
+		for k, v := range req.Vars {
+			data[k] = v
+		}
+		data["Text"] = texttools.PrepareInput(req.Text, req.InputFormat)
+
+		var (
+			name   string
+			output string
+			prompt string
+			err    error
+		)
+		switch {
+		case req.Operation != "":
+			op, ok := ops.Get(req.Operation)
+			if !ok {
+				http.Error(w, "unknown operation", http.StatusNotFound)
+				return
+			}
+			var buf bytes.Buffer
+			if err := op.tmpl.Execute(&buf, data); err != nil {
+				http.Error(w, "template error: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			name, output, prompt = op.Name, op.Output, buf.String()
+		case req.Template != "":
+			if !customTemplate(req.Template) {
+				http.Error(w, fmt.Sprintf("template %q can't be run by /custom", req.Template), http.StatusBadRequest)
+				return
+			}
+			prompt, err = tools.Prompts.Render(req.Template, data)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			name, output = req.Template, req.Output
+		default:
+			http.Error(w, "`operation` or `template` is required", http.StatusBadRequest)
+			return
+		}
+
+		out, err := tools.Complete(r.Context(), prompt, req.Options.Options)
+		if err != nil {
+			log.Println("custom error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		result := parseOutput(output, out)
+		if text, ok := result.(string); ok {
+			result = texttools.FormatOutput(tools.FitWords(r.Context(), text, req.Options.Options), req.OutputFormat)
+		}
+		resp := CustomResponse{Operation: name, Result: result}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// customTemplate reports whether POST /custom may run the registry template
+// name: the built-in operations' templates, and templates of PROMPTS_DIR
+// that are neither built in nor system prompts. The other built-in
+// templates (system, plain-medical, injection-check, ...) are internal to
+// their endpoints and their safety rails.
+func customTemplate(name string) bool {
+	if isBuiltinOperation(name) {
+		return true
+	}
+	if _, ok := defaultPrompts[name]; ok {
+		return false
+	}
+	return name != "system" && !strings.HasPrefix(name, "system.")
+}
+
+// parseOutput shapes the raw LLM output according to an output schema,
+// falling back to the raw text when the model didn't return valid JSON.
+func parseOutput(schema, out string) interface{} {
+	switch schema {
+	case outputList:
+		var items []string
+		if err := json.Unmarshal([]byte(out), &items); err != nil {
+			return []string{out}
+		}
+		return items
+	case outputJSON:
+		var v interface{}
+		if err := json.Unmarshal([]byte(out), &v); err != nil {
+			return out
+		}
+		return v
+	default:
+		return out
+	}
+}
+
+// operationsHandler lists the registered custom operations (without their
+// prompts) so clients such as the UI can offer them.
+func operationsHandler(ops *CustomOperations) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type summary struct {
+			Name        string `json:"name"`
+			Description string `json:"description,omitempty"`
+			Output      string `json:"output"`
+		}
+		list := []summary{}
+		for _, op := range ops.List() {
+			list = append(list, summary{Name: op.Name, Description: op.Description, Output: op.Output})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"operations": list})
+	}
+}
+
+// adminOperationsHandler manages custom operations:
+// GET lists them with prompts, POST registers or replaces one, DELETE ?name=
+// removes one.
+func adminOperationsHandler(ops *CustomOperations) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"operations": ops.List()})
+		case http.MethodPost:
+			var op CustomOperation
+			if !decodeJSON(w, r, &op) {
+				return
+			}
+			if err := ops.Put(&op); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusCreated, op)
+		case http.MethodDelete:
+			ok, err := ops.Delete(r.URL.Query().Get("name"))
+			if err != nil {
+				log.Println("delete operation error:", err)
+				http.Error(w, "failed to save operations", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "unknown operation", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
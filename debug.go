@@ -0,0 +1,59 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+// --- Runtime diagnostics ---
+//
+// The Go profiler (net/http/pprof) and expvar, for memory growth from
+// large documents and goroutines stuck on hung upstream calls, behind
+// admin auth. They are off unless DEBUG_ADDR starts a separate server for
+// them (e.g. 127.0.0.1:6060, kept off the public port) or
+// DEBUG_ENDPOINTS=true serves them on the main one, and only if there is
+// an admin credential (ADMIN_TOKEN or SAML):
+//
+//	GET /debug/pprof/                   index of the profiles
+//	GET /debug/pprof/heap               heap profile (also allocs, goroutine, block, mutex, threadcreate)
+//	GET /debug/pprof/goroutine?debug=2  stacks of all goroutines
+//	GET /debug/pprof/profile?seconds=30 CPU profile
+//	GET /debug/pprof/trace?seconds=5    execution trace
+//	GET /debug/vars                     memstats, goroutines, LLM calls in flight, load
+//
+// go tool pprof can't send the admin token: fetch a profile with curl and
+// open the file.
+
+// llmInFlight counts the provider calls running, on /debug/vars.
+var llmInFlight = expvar.NewInt("llm_in_flight")
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+}
+
+// publishDebugVars adds the load of the server to /debug/vars.
+func publishDebugVars(jobs *JobStore, policies *Policies) {
+	expvar.Publish("admission", expvar.Func(func() interface{} { return policies.Status() }))
+	expvar.Publish("jobs", expvar.Func(func() interface{} { return jobs.Stats() }))
+	expvar.Publish("llm", expvar.Func(func() interface{} {
+		s := metrics.Snapshot()
+		if metrics.PrivateOnly() {
+			s = metrics.Private(s)
+		}
+		return s.LLM
+	}))
+}
+
+// debugHandler serves pprof and expvar under /debug/, for admins only.
+func debugHandler(adminToken string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return withAdmin(adminToken, mux.ServeHTTP)
+}
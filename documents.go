@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// --- Document library ---
+//
+// Users store named documents once (POST /documents, as JSON text or an
+// uploaded file) and then run any operation on one by sending
+// {"document_id": "..."} instead of the text: withDocuments puts the text
+// into the request before the handler reads it. Documents are private to
+// their user, count against MAX_TEXT_CHARS like any text, and are persisted
+// to DOCUMENTS_FILE when it is set (or to PostgreSQL, see store.go). With a
+// blob store (BLOB_STORE), the original of an uploaded file is kept too, as
+// "documents/<id>", and served by GET /documents/<id>/file.
+
+const (
+	maxDocumentsPerUser = 1000
+	maxDocumentName     = 200 // characters
+)
+
+// StoredDocument is a document of the library. (Document is a parsed
+// upload, see extract.go.)
+type StoredDocument struct {
+	DocumentInfo
+	Text string `json:"text"`
+}
+
+// DocumentInfo describes a stored document, without its text.
+type DocumentInfo struct {
+	ID        string        `json:"id"`
+	User      string        `json:"user"`
+	Name      string        `json:"name"`
+	Format    string        `json:"format,omitempty"` // of the uploaded file
+	File      *DocumentFile `json:"file,omitempty"`   // the uploaded file, if kept
+	Chars     int           `json:"chars"`
+	Words     int           `json:"words"`
+	Labels                  // folder and tags (see labels.go)
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// DocumentFile is the original of an uploaded document, kept in the blob
+// store.
+type DocumentFile struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+}
+
+// documentBlobKey is the blob store key of the file of document id.
+func documentBlobKey(id string) string {
+	return "documents/" + id
+}
+
+// DocumentRequest creates or replaces a document. On a PUT, a field left
+// empty keeps its value; the labels are only set on creation (PATCH
+// changes them).
+type DocumentRequest struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+	Labels
+}
+
+// DocumentStore keeps the documents of all users in a DocumentBackend.
+type DocumentStore struct {
+	store DocumentBackend
+}
+
+func NewDocumentStore(store DocumentBackend) *DocumentStore {
+	return &DocumentStore{store: store}
+}
+
+// Get returns the document id of user.
+func (s *DocumentStore) Get(user, id string) (StoredDocument, bool) {
+	d, err := s.store.Document(id)
+	if err != nil {
+		log.Println("documents error:", err)
+	}
+	if d == nil || d.User != user {
+		return StoredDocument{}, false
+	}
+	return *d, true
+}
+
+// List returns the documents of user that match f, most recently updated
+// first.
+func (s *DocumentStore) List(user string, f LabelFilter) []DocumentInfo {
+	out, err := s.store.Documents(user, f)
+	if err != nil {
+		log.Println("documents error:", err)
+	}
+	if out == nil {
+		out = []DocumentInfo{}
+	}
+	return out
+}
+
+var errTooManyDocuments = fmt.Errorf("at most %d documents per user", maxDocumentsPerUser)
+
+// Put stores d, replacing the document with its ID.
+func (s *DocumentStore) Put(d StoredDocument) error {
+	return s.store.PutDocument(d, maxDocumentsPerUser)
+}
+
+// SetLabels files the document id of user with l, reporting whether it
+// exists.
+func (s *DocumentStore) SetLabels(user, id string, l Labels) (DocumentInfo, bool, error) {
+	return s.store.SetDocumentLabels(user, id, l)
+}
+
+// Delete removes the document id of user, reporting whether it existed.
+func (s *DocumentStore) Delete(user, id string) (bool, error) {
+	return s.store.DeleteDocument(user, id)
+}
+
+// memoryDocuments is the default DocumentBackend: documents in memory,
+// saved to a JSON file if it has one.
+type memoryDocuments struct {
+	file string
+
+	mu   sync.RWMutex
+	docs map[string]*StoredDocument // by ID
+}
+
+func newMemoryDocuments(file string) (*memoryDocuments, error) {
+	m := &memoryDocuments{file: file, docs: map[string]*StoredDocument{}}
+	if file == "" {
+		return m, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m.docs); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return m, nil
+}
+
+func (m *memoryDocuments) Document(id string) (*StoredDocument, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	d, ok := m.docs[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *d
+	return &c, nil
+}
+
+func (m *memoryDocuments) Documents(user string, f LabelFilter) ([]DocumentInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []DocumentInfo
+	for _, d := range m.docs {
+		if d.User == user && f.match(d.Labels) {
+			out = append(out, d.DocumentInfo)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UpdatedAt.After(out[j].UpdatedAt) })
+	return out, nil
+}
+
+func (m *memoryDocuments) PutDocument(d StoredDocument, maxPerUser int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.docs[d.ID]; !ok {
+		n := 0
+		for _, e := range m.docs {
+			if e.User == d.User {
+				n++
+			}
+		}
+		if n >= maxPerUser {
+			return errTooManyDocuments
+		}
+	}
+	m.docs[d.ID] = &d
+	return m.saveLocked()
+}
+
+func (m *memoryDocuments) SetDocumentLabels(user, id string, l Labels) (DocumentInfo, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.docs[id]
+	if !ok || d.User != user {
+		return DocumentInfo{}, false, nil
+	}
+	d.Labels = l
+	return d.DocumentInfo, true, m.saveLocked()
+}
+
+func (m *memoryDocuments) DeleteDocument(user, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.docs[id]
+	if !ok || d.User != user {
+		return false, nil
+	}
+	delete(m.docs, id)
+	return true, m.saveLocked()
+}
+
+func (m *memoryDocuments) saveLocked() error {
+	if m.file == "" {
+		return nil
+	}
+	return writeJSONFile(m.file, m.docs)
+}
+
+// setText sets the text of d and its counts.
+func (d *StoredDocument) setText(text string) {
+	d.Text = text
+	d.Chars = utf8.RuneCountInString(text)
+	d.Words = len(strings.Fields(text))
+}
+
+// readDocumentRequest reads a JSON DocumentRequest or, from a multipart
+// form, a `file` (extracted like POST /upload, and returned as upload), an
+// optional `name`, which defaults to the file name, `folder` and `tags`
+// (comma-separated).
+func readDocumentRequest(w http.ResponseWriter, r *http.Request) (req DocumentRequest, format string, upload *Blob, ok bool) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return req, "", nil, decodeJSON(w, r, &req)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+			return req, "", nil, false
+		}
+		http.Error(w, "invalid multipart body", http.StatusBadRequest)
+		return req, "", nil, false
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "`file` is required", http.StatusBadRequest)
+		return req, "", nil, false
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "failed to read file", http.StatusBadRequest)
+		return req, "", nil, false
+	}
+	doc, err := extractDocument(header.Filename, data)
+	if errors.Is(err, errUnsupportedFormat) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return req, "", nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return req, "", nil, false
+	}
+	req.Name, req.Text = r.FormValue("name"), doc.LabeledText()
+	req.Folder = r.FormValue("folder")
+	if tags := r.FormValue("tags"); tags != "" {
+		req.Tags = strings.Split(tags, ",")
+	}
+	if req.Name == "" {
+		req.Name = header.Filename
+	}
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+	return req, doc.Format, &Blob{Data: data, ContentType: contentType, Filename: header.Filename}, true
+}
+
+// validate checks the fields that are set.
+func (req *DocumentRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if n := utf8.RuneCountInString(req.Name); n > maxDocumentName {
+		return errTooLarge("`name` is too long: %d characters (max %d)", n, maxDocumentName)
+	}
+	if req.Text != "" {
+		if err := validateText(req.Text); err != nil {
+			return err
+		}
+	}
+	return req.Labels.normalize()
+}
+
+// documentsHandler serves /documents and /documents/<id>: POST stores a
+// document, GET lists the caller's documents (by ?folder= and ?tag=, see
+// labels.go) or returns one with its text, PUT replaces the name or text of
+// one, PATCH its folder or tags and DELETE removes it. GET
+// /documents/<id>/file downloads the uploaded file, if blobs keeps it
+// (blobs may be nil).
+func documentsHandler(documents *DocumentStore, blobs BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/documents"), "/")
+		id, sub, _ := strings.Cut(id, "/")
+		user := userID(r)
+
+		switch {
+		case sub == "file" && r.Method == http.MethodGet:
+			d, ok := documents.Get(user, id)
+			if !ok {
+				http.Error(w, "document not found", http.StatusNotFound)
+				return
+			}
+			if d.File == nil || blobs == nil {
+				http.Error(w, "the document has no file", http.StatusNotFound)
+				return
+			}
+			b, err := blobs.Get(r.Context(), documentBlobKey(d.ID))
+			if errors.Is(err, errBlobNotFound) {
+				http.Error(w, "the document has no file", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Println("documents error:", err)
+				http.Error(w, "failed to read the file", http.StatusBadGateway)
+				return
+			}
+			writeBlob(w, b)
+		case sub != "":
+			http.NotFound(w, r)
+		case r.Method == http.MethodGet && id == "":
+			f, err := labelFilter(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"documents": documents.List(user, f)})
+		case r.Method == http.MethodGet:
+			d, ok := documents.Get(user, id)
+			if !ok {
+				http.Error(w, "document not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, d)
+		case r.Method == http.MethodPost && id == "", r.Method == http.MethodPut && id != "":
+			if refusePrivate(w, r, "stored documents") {
+				return
+			}
+			d := StoredDocument{DocumentInfo: DocumentInfo{ID: newID(), User: user, CreatedAt: time.Now().UTC()}}
+			if r.Method == http.MethodPut {
+				var ok bool
+				if d, ok = documents.Get(user, id); !ok {
+					http.Error(w, "document not found", http.StatusNotFound)
+					return
+				}
+			}
+			req, format, upload, ok := readDocumentRequest(w, r)
+			if !ok {
+				return
+			}
+			if err := req.validate(); err != nil {
+				writeInputError(w, err)
+				return
+			}
+			if r.Method == http.MethodPost && (req.Name == "" || req.Text == "") {
+				http.Error(w, "`name` and `text` are required", http.StatusBadRequest)
+				return
+			}
+			if r.Method == http.MethodPost {
+				d.Labels = req.Labels
+			} else if req.Folder != "" || len(req.Tags) > 0 {
+				http.Error(w, "change `folder` and `tags` with PATCH", http.StatusBadRequest)
+				return
+			}
+			if req.Name != "" {
+				d.Name = req.Name
+			}
+			hadFile := d.File != nil
+			if req.Text != "" {
+				d.setText(req.Text)
+				d.Format = format
+				d.File = nil
+			}
+			if upload != nil && blobs != nil {
+				if err := blobs.Put(r.Context(), documentBlobKey(d.ID), *upload); err != nil {
+					log.Println("documents error:", err)
+					http.Error(w, "failed to store the file", http.StatusBadGateway)
+					return
+				}
+				d.File = &DocumentFile{Name: upload.Filename, ContentType: upload.ContentType, Size: len(upload.Data)}
+			}
+			d.UpdatedAt = time.Now().UTC()
+			if err := documents.Put(d); errors.Is(err, errTooManyDocuments) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			} else if err != nil {
+				log.Println("documents error:", err)
+				http.Error(w, "failed to save the document", http.StatusInternalServerError)
+				return
+			}
+			if hadFile && d.File == nil && blobs != nil {
+				// the text was replaced: the file no longer matches it
+				if err := blobs.Delete(r.Context(), documentBlobKey(d.ID)); err != nil {
+					log.Println("documents error:", err)
+				}
+			}
+			status := http.StatusOK
+			if r.Method == http.MethodPost {
+				status = http.StatusCreated
+			}
+			writeJSON(w, status, d.DocumentInfo)
+		case r.Method == http.MethodPatch && id != "":
+			var u LabelsUpdate
+			if !decodeJSON(w, r, &u) {
+				return
+			}
+			d, ok := documents.Get(user, id)
+			if !ok {
+				http.Error(w, "document not found", http.StatusNotFound)
+				return
+			}
+			l, err := u.apply(d.Labels)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			info, ok, err := documents.SetLabels(user, id, l)
+			if err != nil {
+				log.Println("documents error:", err)
+				http.Error(w, "failed to save documents", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "document not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, info)
+		case r.Method == http.MethodDelete && id != "":
+			d, _ := documents.Get(user, id)
+			ok, err := documents.Delete(user, id)
+			if err != nil {
+				log.Println("documents error:", err)
+				http.Error(w, "failed to save documents", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "document not found", http.StatusNotFound)
+				return
+			}
+			if d.File != nil && blobs != nil {
+				if err := blobs.Delete(r.Context(), documentBlobKey(id)); err != nil {
+					log.Println("documents error:", err)
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// withDocuments replaces the `document_id` of a JSON request with the
+// document's text, as `text`.
+func withDocuments(documents *DocumentStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || strings.HasPrefix(r.URL.Path, "/documents") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := readBody(w, r, maxUploadSize)
+		if err != nil {
+			writeInputError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		var fields map[string]json.RawMessage
+		if !bytes.Contains(body, []byte(`"document_id"`)) || json.Unmarshal(body, &fields) != nil || fields["document_id"] == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var id, text string
+		if json.Unmarshal(fields["document_id"], &id) != nil || id == "" {
+			http.Error(w, "`document_id` must be a document ID", http.StatusBadRequest)
+			return
+		}
+		if json.Unmarshal(fields["text"], &text); text != "" {
+			http.Error(w, "send either `text` or `document_id`", http.StatusBadRequest)
+			return
+		}
+		d, ok := documents.Get(userID(r), id)
+		if !ok {
+			http.Error(w, "document not found", http.StatusNotFound)
+			return
+		}
+		delete(fields, "document_id")
+		fields["text"], _ = json.Marshal(d.Text)
+		body, _ = json.Marshal(fields)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Dry runs ---
+//
+// A POST with ?dry_run=true renders the prompts and answers with them in
+// place of the result, without calling the model: per call, the model it
+// would go to, the system and user prompts, the token count of the prompt
+// and the most the completion may take (max_tokens, or MAX_OUTPUT_TOKENS),
+// with the cost those come to at the model's price. Operations that make
+// several calls get a stand-in output ("{}") for each, so later calls, such
+// as the reduce step of a long summary, show their prompts with it. Nothing
+// is stored, moderated or counted against token budgets. Dry runs need no
+// flag, but the prompts, which expose the templates, are only shown with
+// the debug_echo flag on, like ?debug=echo.
+
+// dryRunUnsupported are the paths whose model calls happen after the
+// response, which a dry run can't show.
+var dryRunUnsupported = []string{"/jobs", "/integrations/"}
+
+// ModelPrice is the price of a model in USD per million tokens.
+type ModelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// defaultModelPrices are OpenAI's list prices; MODEL_PRICES adds to and
+// overrides them. Models match by the longest prefix.
+var defaultModelPrices = map[string]ModelPrice{
+	"gpt-4o-mini":  {Prompt: 0.15, Completion: 0.60},
+	"gpt-4o":       {Prompt: 2.50, Completion: 10.00},
+	"gpt-4.1":      {Prompt: 2.00, Completion: 8.00},
+	"gpt-4.1-mini": {Prompt: 0.40, Completion: 1.60},
+	"gpt-4.1-nano": {Prompt: 0.10, Completion: 0.40},
+}
+
+// ModelPrices maps model name prefixes to prices.
+type ModelPrices map[string]ModelPrice
+
+// LoadModelPrices returns the default prices with those of the JSON file at
+// path (a model-to-price object) on top; path may be empty.
+func LoadModelPrices(path string) (ModelPrices, error) {
+	prices := ModelPrices{}
+	for m, p := range defaultModelPrices {
+		prices[m] = p
+	}
+	if path == "" {
+		return prices, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("MODEL_PRICES: %w", err)
+	}
+	var extra map[string]ModelPrice
+	if err := json.Unmarshal(b, &extra); err != nil {
+		return nil, fmt.Errorf("MODEL_PRICES: %w", err)
+	}
+	for m, p := range extra {
+		if p.Prompt < 0 || p.Completion < 0 {
+			return nil, fmt.Errorf("MODEL_PRICES: negative price for %q", m)
+		}
+		prices[m] = p
+	}
+	return prices, nil
+}
+
+// For returns the price of model, if known.
+func (p ModelPrices) For(model string) (ModelPrice, bool) {
+	best, found := "", false
+	for prefix := range p {
+		if strings.HasPrefix(model, prefix) && (!found || len(prefix) > len(best)) {
+			best, found = prefix, true
+		}
+	}
+	return p[best], found
+}
+
+// DryRunReport is the response to a dry run.
+type DryRunReport struct {
+	DryRun              bool         `json:"dry_run"`
+	Calls               []DryRunCall `json:"calls"`
+	PromptTokens        int          `json:"prompt_tokens"`
+	MaxCompletionTokens int          `json:"max_completion_tokens"`
+	Cost                *CostRange   `json:"cost_usd,omitempty"` // when every call's model has a price
+}
+
+// DryRunCall is a model call a request would make.
+type DryRunCall struct {
+	Operation           string              `json:"operation,omitempty"` // the prompt template
+	Model               string              `json:"model"`
+	System              string              `json:"system,omitempty"` // with the debug_echo flag on
+	Prompt              string              `json:"prompt,omitempty"` // likewise, and History
+	History             []texttools.Message `json:"history,omitempty"`
+	Images              int                 `json:"images,omitempty"` // not in the token count
+	Params              texttools.Params    `json:"params"`
+	PromptTokens        int                 `json:"prompt_tokens"`
+	MaxCompletionTokens int                 `json:"max_completion_tokens"`
+	Cost                *CostRange          `json:"cost_usd,omitempty"`
+}
+
+// CostRange is what a call costs: Min for the prompt alone, Max with a
+// completion of the most tokens allowed.
+type CostRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (c *CostRange) add(o *CostRange) {
+	c.Min = roundCost(c.Min + o.Min)
+	c.Max = roundCost(c.Max + o.Max)
+}
+
+type dryRunKey struct{}
+
+// dryRunSlot collects the calls of a dry run.
+type dryRunSlot struct {
+	mu    sync.Mutex
+	calls []DryRunCall
+}
+
+// dryRun reports whether the request of ctx is a dry run.
+func dryRun(ctx context.Context) bool {
+	_, ok := ctx.Value(dryRunKey{}).(*dryRunSlot)
+	return ok
+}
+
+// dryRunRequested reports whether r asks for a dry run.
+func dryRunRequested(r *http.Request) bool {
+	on, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	return on && r.Method == http.MethodPost
+}
+
+// withDryRun answers POST requests with ?dry_run=true with the calls they
+// would make. Requests that fail before making any (invalid input, say)
+// get their error as usual.
+func withDryRun(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !dryRunRequested(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, p := range dryRunUnsupported {
+			if strings.HasPrefix(r.URL.Path, p) {
+				http.Error(w, "dry_run is not supported for "+r.URL.Path, http.StatusBadRequest)
+				return
+			}
+		}
+		slot := &dryRunSlot{}
+		dw := &dryRunWriter{header: http.Header{}, status: http.StatusOK}
+		next.ServeHTTP(dw, r.WithContext(context.WithValue(r.Context(), dryRunKey{}, slot)))
+
+		slot.mu.Lock()
+		calls := slot.calls
+		slot.mu.Unlock()
+		w.Header().Set("X-Dry-Run", "true")
+		if len(calls) == 0 {
+			for k, v := range dw.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(dw.status)
+			w.Write(dw.body.Bytes())
+			return
+		}
+		report := DryRunReport{DryRun: true, Calls: calls, Cost: &CostRange{}}
+		for _, c := range calls {
+			report.PromptTokens += c.PromptTokens
+			report.MaxCompletionTokens += c.MaxCompletionTokens
+			if c.Cost == nil {
+				report.Cost = nil
+			} else if report.Cost != nil {
+				report.Cost.add(c.Cost)
+			}
+		}
+		writeJSON(w, http.StatusOK, report)
+	})
+}
+
+// dryRunWriter holds the handler's response back.
+type dryRunWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *dryRunWriter) Header() http.Header { return w.header }
+
+func (w *dryRunWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+}
+
+func (w *dryRunWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.body.Write(b)
+}
+
+// dryRunProvider records the calls of dry runs instead of making them, and
+// passes everything else to the wrapped provider.
+type dryRunProvider struct {
+	texttools.StreamProvider
+	defaultModel string
+	tokenizers   *TokenizerRegistry
+	prices       ModelPrices
+}
+
+func (p dryRunProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	if slot, ok := ctx.Value(dryRunKey{}).(*dryRunSlot); ok {
+		return p.record(slot, req), nil
+	}
+	return p.StreamProvider.Complete(ctx, req)
+}
+
+func (p dryRunProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	if slot, ok := ctx.Value(dryRunKey{}).(*dryRunSlot); ok {
+		c := p.record(slot, req)
+		return c, onDelta(c.Text)
+	}
+	return p.StreamProvider.Stream(ctx, req, onDelta)
+}
+
+// record adds req to the calls of the dry run and returns the stand-in
+// output.
+func (p dryRunProvider) record(slot *dryRunSlot, req texttools.Request) texttools.Completion {
+	model := req.Model
+	if model == "" {
+		model = p.defaultModel
+	}
+	prompt := req.System + "\n" + req.Prompt
+	for _, m := range req.History {
+		prompt += "\n" + m.Content
+	}
+	call := DryRunCall{
+		Operation:           req.Operation,
+		Model:               model,
+		Images:              len(req.Images),
+		Params:              req.Params,
+		PromptTokens:        p.tokenizers.Count(model, prompt),
+		MaxCompletionTokens: maxOutputTokens,
+	}
+	if features.Enabled("debug_echo") {
+		call.System, call.Prompt, call.History = req.System, req.Prompt, req.History
+	}
+	if req.MaxTokens > 0 {
+		call.MaxCompletionTokens = min(req.MaxTokens, maxOutputTokens)
+	}
+	if price, ok := p.prices.For(model); ok {
+		promptCost := float64(call.PromptTokens) * price.Prompt / 1e6
+		call.Cost = &CostRange{
+			Min: roundCost(promptCost),
+			Max: roundCost(promptCost + float64(call.MaxCompletionTokens)*price.Completion/1e6),
+		}
+	}
+	slot.mu.Lock()
+	slot.calls = append(slot.calls, call)
+	slot.mu.Unlock()
+	return texttools.Completion{Text: "{}", FinishReason: "stop"}
+}
+
+// roundCost rounds a cost in USD to a millionth of a dollar.
+func roundCost(usd float64) float64 {
+	return math.Round(usd*1e6) / 1e6
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Edit explanations ---
+//
+// POST /explain-edits takes the original and the edited version of a text
+// and describes what the edit did, e.g. "shortened the intro, changed
+// passive to active voice in §2": a one-line summary for a commit message
+// or review thread, and the individual changes. Paragraphs are numbered
+// (§1, §2, ...) before the texts go to the model, so that it can say where
+// a change is.
+
+// Kinds of edit.
+var editKinds = []string{"content", "structure", "wording", "tone", "correction", "formatting"}
+
+type ExplainEditsRequest struct {
+	Original string `json:"original"`
+	Edited   string `json:"edited"`
+	Options
+}
+
+type ExplainEditsResponse struct {
+	Summary string       `json:"summary"` // one line, e.g. for a commit message
+	Changes []EditChange `json:"changes"`
+	ResultMeta
+}
+
+// EditChange is one change the edit made.
+type EditChange struct {
+	Location string `json:"location"` // e.g. "§2" or "title"
+	Kind     string `json:"kind"`     // content, structure, wording, tone, correction or formatting
+	Change   string `json:"change"`
+}
+
+// explainEditsPromptInput is the data of the explain-edits template.
+type explainEditsPromptInput struct {
+	texttools.Options
+	Original, Edited string
+	Kinds            []string
+}
+
+func explainEditsHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ExplainEditsRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Original == "" || req.Edited == "" {
+			http.Error(w, "`original` and `edited` are required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Original) || !checkText(w, req.Edited) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		if strings.TrimSpace(req.Original) == strings.TrimSpace(req.Edited) {
+			writeJSON(w, http.StatusOK, ExplainEditsResponse{Summary: "No changes.", Changes: []EditChange{}})
+			return
+		}
+
+		input := req.Original + "\n\n---\n\n" + req.Edited
+		key := historyKey("", req.Options)
+		if prior, ok := history.Reusable(r, "explain-edits", key, input, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		in := explainEditsPromptInput{
+			Options:  req.Options.Options,
+			Original: numberParagraphs(texttools.PrepareInput(req.Original, req.InputFormat)),
+			Edited:   numberParagraphs(texttools.PrepareInput(req.Edited, req.InputFormat)),
+			Kinds:    editKinds,
+		}
+		out, err := tools.Prompt(r.Context(), "explain-edits", in, in.Options)
+		if err != nil {
+			log.Println("explain-edits error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var resp ExplainEditsResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			// fallback – treat the whole output as the summary
+			resp = ExplainEditsResponse{Summary: out}
+		}
+		resp.Summary = strings.Join(strings.Fields(resp.Summary), " ")
+		if resp.Changes == nil {
+			resp.Changes = []EditChange{}
+		}
+
+		resp.ResultMeta = history.Record(r, "explain-edits", key, input, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+var paragraphBreakRe = regexp.MustCompile(`\n\s*\n`)
+
+// numberParagraphs prefixes each paragraph of text with "§n".
+func numberParagraphs(text string) string {
+	var paras []string
+	for _, p := range paragraphBreakRe.Split(strings.TrimSpace(text), -1) {
+		paras = append(paras, fmt.Sprintf("§%d %s", len(paras)+1, p))
+	}
+	return strings.Join(paras, "\n\n")
+}
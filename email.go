@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Email drafting ---
+//
+// POST /email turns bullet points or a rough message into an email with a
+// subject line. recipient, tone and goal steer the draft; with reply_to,
+// the original email, it is written as a reply to it, and its subject gets
+// a single "Re: ".
+
+type EmailRequest struct {
+	Text      string `json:"text"`      // bullet points or a rough message
+	Recipient string `json:"recipient"` // who it's for, e.g. "my manager" or "a client, Ms. Chen"
+	Tone      string `json:"tone"`
+	Goal      string `json:"goal"`     // what the email should achieve
+	ReplyTo   string `json:"reply_to"` // the email being answered, if any
+	Options
+}
+
+type EmailResponse struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	ResultMeta
+}
+
+// emailPromptInput is the data of the email template.
+type emailPromptInput struct {
+	texttools.Input
+	Recipient string
+	Goal      string
+	ReplyTo   string
+}
+
+// replyPrefixRe matches the reply prefixes of a subject, stacked or not.
+var replyPrefixRe = regexp.MustCompile(`(?i)^((re|aw|sv|antw)\s*:\s*)+`)
+
+// subjectLineRe matches a "Subject:" line, for output that isn't JSON.
+var subjectLineRe = regexp.MustCompile(`(?im)^\s*subject\s*:\s*(.+)$`)
+
+func emailHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req EmailRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if req.ReplyTo != "" && !checkText(w, req.ReplyTo) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p := prefs.For(r)
+		p.apply(&req.Options)
+		if req.Tone == "" {
+			req.Tone = p.Tone
+		}
+
+		input := req.Text
+		if req.ReplyTo != "" {
+			input = req.ReplyTo + "\n\n---\n\n" + req.Text
+		}
+		key := historyKey(req.Recipient+"|"+req.Tone+"|"+req.Goal, req.Options)
+		if prior, ok := history.Reusable(r, "email", key, input, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		in := emailPromptInput{
+			Input:     texttools.Input{Text: texttools.PrepareInput(req.Text, req.InputFormat), Tone: req.Tone, Options: req.Options.Options},
+			Recipient: req.Recipient,
+			Goal:      req.Goal,
+			ReplyTo:   texttools.PrepareInput(req.ReplyTo, req.InputFormat),
+		}
+		out, err := tools.Prompt(r.Context(), "email", in, in.Options)
+		if err != nil {
+			log.Println("email error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var resp EmailResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			// fallback – a "Subject:" line, the rest is the body
+			resp = EmailResponse{Body: out}
+			if loc := subjectLineRe.FindStringSubmatchIndex(out); loc != nil {
+				resp.Subject = out[loc[2]:loc[3]]
+				resp.Body = out[:loc[0]] + out[loc[1]:]
+			}
+		}
+		resp.Subject = strings.TrimSpace(resp.Subject)
+		resp.Body = strings.TrimSpace(resp.Body)
+		if req.ReplyTo != "" {
+			resp.Subject = "Re: " + replyPrefixRe.ReplaceAllString(resp.Subject, "")
+		}
+
+		resp.ResultMeta = history.Record(r, "email", key, input, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Named entities ---
+//
+// POST /entities finds the people, organizations, places, dates and amounts
+// in a text. The model names them; their character offsets are found here,
+// in the text as submitted, since models can't count characters reliably.
+// Every mention gets its own entry, so clients can link or redact them in
+// place.
+
+// Entity types.
+var entityTypes = []string{"person", "organization", "place", "date", "amount"}
+
+type EntitiesRequest struct {
+	Text  string   `json:"text"`
+	Types []string `json:"types"` // only these entity types; all by default
+	Options
+}
+
+type EntitiesResponse struct {
+	Entities []Entity `json:"entities"`
+	ResultMeta
+}
+
+// Entity is one mention of an entity; Start and End are offsets in Unicode
+// characters (code points) into the text, End exclusive.
+type Entity struct {
+	Text  string `json:"text"`
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"` // normalized date (ISO 8601) or amount, if known
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// entitiesPromptInput is the data of the entities template.
+type entitiesPromptInput struct {
+	texttools.Input
+	Types []string
+}
+
+func entitiesHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req EntitiesRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		for _, t := range req.Types {
+			if !slices.Contains(entityTypes, t) {
+				http.Error(w, fmt.Sprintf("unknown entity type %q (want %s)", t, strings.Join(entityTypes, ", ")), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(req.Types) == 0 {
+			req.Types = entityTypes
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey(strings.Join(req.Types, ","), req.Options)
+		if prior, ok := history.Reusable(r, "entities", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		// The text goes to the model as submitted (no input_format
+		// conversion), so that what it names can be found in it.
+		in := entitiesPromptInput{
+			Input: texttools.Input{Text: req.Text, Options: req.Options.Options},
+			Types: req.Types,
+		}
+		out, err := tools.Prompt(r.Context(), "entities", in, in.Options)
+		if err != nil {
+			log.Println("entities error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var named struct {
+			Entities []Entity `json:"entities"`
+		}
+		if err := json.Unmarshal([]byte(out), &named); err != nil {
+			logUnparseable(r.Context(), "entities", out)
+		}
+		resp := EntitiesResponse{Entities: locateEntities(req.Text, named.Entities, req.Types)}
+
+		resp.ResultMeta = history.Record(r, "entities", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// locateEntities finds every mention of the named entities in text, as
+// whole words, and returns them in text order. Where mentions overlap the
+// longer one wins; entities of other types than types, or not found in the
+// text, are dropped.
+func locateEntities(text string, named []Entity, types []string) []Entity {
+	sort.SliceStable(named, func(i, j int) bool {
+		return utf8.RuneCountInString(named[i].Text) > utf8.RuneCountInString(named[j].Text)
+	})
+	runes := []rune(text)
+	taken := make([]bool, len(runes))
+	seen := map[string]bool{}
+	found := []Entity{}
+	for _, e := range named {
+		e.Text = strings.TrimSpace(e.Text)
+		e.Type = strings.ToLower(strings.TrimSpace(e.Type))
+		if e.Text == "" || !slices.Contains(types, e.Type) || seen[e.Text] {
+			continue
+		}
+		seen[e.Text] = true
+		needle := []rune(e.Text)
+		for start := 0; start+len(needle) <= len(runes); start++ {
+			end := start + len(needle)
+			if !slices.Equal(runes[start:end], needle) || !wordBoundary(runes, start, end) || slices.Contains(taken[start:end], true) {
+				continue
+			}
+			for i := start; i < end; i++ {
+				taken[i] = true
+			}
+			found = append(found, Entity{Text: e.Text, Type: e.Type, Value: e.Value, Start: start, End: end})
+			start = end - 1
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Start < found[j].Start })
+	return found
+}
+
+// wordBoundary reports whether runes[start:end] isn't part of a longer word.
+func wordBoundary(runes []rune, start, end int) bool {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	return (start == 0 || !isWord(runes[start-1]) || !isWord(runes[start])) &&
+		(end == len(runes) || !isWord(runes[end]) || !isWord(runes[end-1]))
+}
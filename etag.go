@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// --- ETags ---
+//
+// With the result cache on for a request ("reuse": true, see
+// History.Reusable), the response carries a weak ETag derived from the
+// operation, its parameters and the hash of the input, and from the stored
+// result it stands for. Sending the same request again with the ETag in
+// If-None-Match gets a 304 without a body, and without calling the model,
+// for as long as that result is stored. GET /history/{id} is tagged the
+// same way. Handlers set the ETag with setETag; withETag writes it.
+
+type etagKey struct{}
+
+type etagSlot struct {
+	mu   sync.Mutex
+	etag string
+}
+
+// ETag returns the weak entity tag of the stored result.
+func (e *HistoryEntry) ETag() string {
+	sum := sha256.Sum256([]byte(e.Operation + "|" + e.Key + "|" + e.fingerprint + "|" + e.ID))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// setETag tags the response to r.
+func setETag(r *http.Request, etag string) {
+	if slot, ok := r.Context().Value(etagKey{}).(*etagSlot); ok {
+		slot.mu.Lock()
+		slot.etag = etag
+		slot.mu.Unlock()
+	}
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, by
+// weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(t), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// withETag adds the ETag set by the handler to 200 responses, and turns
+// them into a 304 when the request's If-None-Match matches it.
+func withETag(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slot := &etagSlot{}
+		ew := &etagWriter{ResponseWriter: w, slot: slot, ifNoneMatch: r.Header.Get("If-None-Match")}
+		next.ServeHTTP(ew, r.WithContext(context.WithValue(r.Context(), etagKey{}, slot)))
+	})
+}
+
+// etagWriter drops the body of a response turned into a 304.
+type etagWriter struct {
+	http.ResponseWriter
+	slot        *etagSlot
+	ifNoneMatch string
+	wroteHeader bool
+	notModified bool
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.slot.mu.Lock()
+	etag := w.slot.etag
+	w.slot.mu.Unlock()
+	if status == http.StatusOK && etag != "" {
+		w.Header().Set("ETag", etag)
+		if w.ifNoneMatch != "" && etagMatches(w.ifNoneMatch, etag) {
+			w.notModified = true
+			w.Header().Del("Content-Type")
+			w.Header().Del("Content-Length")
+			status = http.StatusNotModified
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *etagWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.notModified {
+		f.Flush()
+	}
+}
+
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
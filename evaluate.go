@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Quality self-evaluation ---
+//
+// A POST with ?evaluate=true has its result scored by a second LLM pass:
+// faithfulness to the input text, completeness and fluency, each 1–5 with a
+// short rationale, added to the response as "evaluation". It works for any
+// request with a "text" field whose response is a JSON object; streamed
+// responses and degraded results (made without the LLM) aren't evaluated.
+// The judge is the request's model, or EVALUATION_MODEL. The scores also
+// go to the admin dashboard, averaged per endpoint, to watch the quality of
+// the pipeline over time. An evaluation that fails leaves the result as it
+// is, with an "evaluation_error" instead.
+
+// evaluationModel is the model that evaluates results (EVALUATION_MODEL);
+// the request's model if empty.
+var evaluationModel = os.Getenv("EVALUATION_MODEL")
+
+// Evaluation is the "evaluation" of a response.
+type Evaluation struct {
+	Faithfulness EvaluationScore `json:"faithfulness"` // says only what the input says
+	Completeness EvaluationScore `json:"completeness"` // covers what matters
+	Fluency      EvaluationScore `json:"fluency"`      // is well written
+	Overall      float64         `json:"overall"`      // the mean of the three scores
+}
+
+// EvaluationScore is a score from 1 (poor) to 5 (excellent).
+type EvaluationScore struct {
+	Score     int    `json:"score"`
+	Rationale string `json:"rationale"`
+}
+
+// evaluatePromptInput is the data of the evaluate template.
+type evaluatePromptInput struct {
+	texttools.Input
+	Operation string
+	Output    string
+}
+
+// evaluateRequested reports whether r asks for an evaluation.
+func evaluateRequested(r *http.Request) bool {
+	on, _ := strconv.ParseBool(r.URL.Query().Get("evaluate"))
+	return on && r.Method == http.MethodPost
+}
+
+// withEvaluation adds the evaluation to the responses of requests with
+// ?evaluate=true.
+func withEvaluation(tools *texttools.Tools, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !evaluateRequested(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
+		if err != nil {
+			writeInputError(w, errTooLarge("request body too large (max %d bytes)", int64(maxUploadSize)))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		var in struct {
+			Text  string `json:"text"`
+			Model string `json:"model"`
+		}
+		if json.Unmarshal(body, &in) != nil || strings.TrimSpace(in.Text) == "" {
+			http.Error(w, "evaluate needs a JSON request with `text`", http.StatusBadRequest)
+			return
+		}
+
+		mw := &metaWriter{ResponseWriter: w}
+		next.ServeHTTP(mw, r)
+		if !mw.buffered {
+			return
+		}
+		out := mw.body.Bytes()
+		defer func() {
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusOK)
+			w.Write(out)
+		}()
+
+		var result map[string]json.RawMessage
+		if json.Unmarshal(out, &result) != nil {
+			return
+		}
+		if degraded, _ := strconv.ParseBool(string(result["degraded"])); degraded {
+			return
+		}
+		for f := range resultMetaFields {
+			delete(result, f)
+		}
+		output, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return
+		}
+		model := evaluationModel
+		if model == "" {
+			model = in.Model
+		}
+		e, err := evaluate(r.Context(), tools, r.URL.Path, in.Text, string(output), model)
+		if dryRun(r.Context()) {
+			return // the call is in the report; there's nothing to score
+		}
+		if err != nil {
+			log.Println("evaluate error:", err)
+			out = withJSONField(out, "evaluation_error", "evaluation failed")
+			return
+		}
+		metrics.RecordEvaluation(r.URL.Path, e)
+		out = withJSONField(out, "evaluation", e)
+	})
+}
+
+// evaluate scores output, the result of operation for text.
+func evaluate(ctx context.Context, tools *texttools.Tools, operation, text, output, model string) (Evaluation, error) {
+	temp := 0.0
+	opts := texttools.Options{Model: model, Params: texttools.Params{Temperature: &temp}}
+	in := evaluatePromptInput{Input: texttools.Input{Text: text, Options: opts}, Operation: strings.TrimPrefix(operation, "/"), Output: output}
+	out, err := tools.Prompt(ctx, "evaluate", in, opts)
+	if err != nil || dryRun(ctx) {
+		return Evaluation{}, err
+	}
+	var e Evaluation
+	if err := json.Unmarshal([]byte(out), &e); err != nil {
+		logUnparseable(ctx, "evaluate", out)
+		return Evaluation{}, fmt.Errorf("unparseable evaluation: %w", err)
+	}
+	scores := []*EvaluationScore{&e.Faithfulness, &e.Completeness, &e.Fluency}
+	sum := 0
+	for _, s := range scores {
+		if s.Score < 1 || s.Score > 5 {
+			logUnparseable(ctx, "evaluate", out)
+			return Evaluation{}, fmt.Errorf("evaluation score %d out of range", s.Score)
+		}
+		s.Rationale = strings.TrimSpace(s.Rationale)
+		sum += s.Score
+	}
+	e.Overall = math.Round(float64(sum)/float64(len(scores))*100) / 100
+	return e, nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Prompt experiments ---
+//
+// An experiment A/B tests variants of an operation's prompt template: each
+// API request picks one variant by the traffic split, and uses it for all
+// of its calls of that operation. "control" is the template itself, any
+// other variant the template "<operation>@<variant>" (added like any other
+// template, e.g. with PUT /admin/config/prompts/summarize@concise). Experiments
+// are runtime configuration (see config.go). Responses name the variants
+// they used in the X-Prompt-Variant header ("summarize=concise"), and so
+// does the provenance of stored results, which the feedback on them keeps.
+// GET /admin/experiments compares the variants: requests, LLM calls,
+// errors and latency (counted in memory, since the experiment or the
+// server started) and feedback (see feedback.go).
+
+const controlVariant = "control"
+
+// PromptExperiment splits the requests of an operation between variants of
+// its prompt.
+type PromptExperiment struct {
+	Variants  map[string]int `json:"variants"` // variant → percentage of requests; they add up to 100
+	StartedAt time.Time      `json:"started_at"`
+}
+
+// variantTemplate is the template of a variant of op.
+func variantTemplate(op, variant string) string {
+	if variant == controlVariant {
+		return op
+	}
+	return op + "@" + variant
+}
+
+func (e PromptExperiment) validate(op string, prompts *PromptRegistry) error {
+	if !prompts.Has(op) {
+		return fmt.Errorf("no template %q", op)
+	}
+	if len(e.Variants) < 2 {
+		return errors.New("`variants` needs at least two variants")
+	}
+	total := 0
+	for v, weight := range e.Variants {
+		if !promptNameRe.MatchString(variantTemplate(op, v)) {
+			return fmt.Errorf("invalid variant name %q", v)
+		}
+		if !prompts.Has(variantTemplate(op, v)) {
+			return fmt.Errorf("variant %q has no template %q", v, variantTemplate(op, v))
+		}
+		if weight < 0 || weight > 100 {
+			return fmt.Errorf("the weight of %q must be between 0 and 100", v)
+		}
+		total += weight
+	}
+	if total != 100 {
+		return fmt.Errorf("the weights must add up to 100, not %d", total)
+	}
+	return nil
+}
+
+// pick draws a variant by the traffic split.
+func (e PromptExperiment) pick() string {
+	names := make([]string, 0, len(e.Variants))
+	for v := range e.Variants {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+	n := rand.IntN(100)
+	for _, v := range names {
+		if n -= e.Variants[v]; n < 0 {
+			return v
+		}
+	}
+	return controlVariant
+}
+
+// Experiment returns the running experiment on op, if any.
+func (c *ConfigStore) Experiment(op string) (PromptExperiment, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.experiments[op]
+	return e, ok
+}
+
+// Experiments returns the running experiments by operation.
+func (c *ConfigStore) Experiments() map[string]PromptExperiment {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]PromptExperiment, len(c.experiments))
+	for op, e := range c.experiments {
+		out[op] = e
+	}
+	return out
+}
+
+// SetExperiment starts (or, with e nil, ends) the experiment on op, records
+// the change made by the admin of r and saves.
+func (c *ConfigStore) SetExperiment(r *http.Request, op string, e *PromptExperiment) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var old, updated json.RawMessage
+	if prior, ok := c.experiments[op]; ok {
+		old, _ = json.Marshal(prior.Variants)
+	}
+	if e != nil {
+		updated, _ = json.Marshal(e.Variants)
+		if c.experiments == nil {
+			c.experiments = map[string]PromptExperiment{}
+		}
+		c.experiments[op] = *e
+	} else {
+		delete(c.experiments, op)
+	}
+	experimentStats.reset(op)
+	c.recordLocked(r, "experiment:"+op, old, updated)
+	return c.saveLocked()
+}
+
+type experimentKey struct{}
+
+// experimentSlot holds the variants a request uses.
+type experimentSlot struct {
+	mu      sync.Mutex
+	header  http.Header
+	chosen  map[string]string // operation → variant
+	counted map[string]bool   // the request was counted for the operation's variant
+}
+
+// withPromptExperiments lets requests take part in the running
+// experiments.
+func withPromptExperiments(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slot := &experimentSlot{header: w.Header(), chosen: map[string]string{}, counted: map[string]bool{}}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), experimentKey{}, slot)))
+	})
+}
+
+// variant returns the variant of op the request uses, picking one the
+// first time.
+func (s *experimentSlot) variant(op string, e PromptExperiment) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.chosen[op]
+	if !ok {
+		v = e.pick()
+		s.chosen[op] = v
+		s.header.Add("X-Prompt-Variant", op+"="+v)
+	}
+	return v
+}
+
+// promptVariant returns the variant of op the request of ctx used, or "".
+func promptVariant(ctx context.Context, op string) string {
+	slot, ok := ctx.Value(experimentKey{}).(*experimentSlot)
+	if !ok {
+		return ""
+	}
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	return slot.chosen[op]
+}
+
+// RenderContext renders the template name, or the variant of it the
+// request of ctx uses if the template is in an experiment. Requests outside
+// the API (no slot) and variants whose template is gone get the control.
+func (p *PromptRegistry) RenderContext(ctx context.Context, name string, data interface{}) (string, error) {
+	slot, ok := ctx.Value(experimentKey{}).(*experimentSlot)
+	if !ok {
+		return p.Render(name, data)
+	}
+	e, ok := runtimeConfig.Experiment(name)
+	if !ok {
+		return p.Render(name, data)
+	}
+	tmpl := variantTemplate(name, slot.variant(name, e))
+	if !p.Has(tmpl) {
+		tmpl = name
+	}
+	return p.Render(tmpl, data)
+}
+
+// variantStats count the calls made with a variant.
+type variantStats struct {
+	requests, calls, errors int
+	latency                 time.Duration
+}
+
+// experimentStats are the variant stats by operation and variant.
+var experimentStats = &variantCounters{ops: map[string]map[string]*variantStats{}}
+
+type variantCounters struct {
+	mu  sync.Mutex
+	ops map[string]map[string]*variantStats
+}
+
+func (c *variantCounters) reset(op string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.ops, op)
+}
+
+func (c *variantCounters) get(op, variant string) variantStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.ops[op][variant]; ok {
+		return *s
+	}
+	return variantStats{}
+}
+
+// recordVariantCall counts an LLM call of the operation op for the variant
+// the request of ctx uses, if any.
+func recordVariantCall(ctx context.Context, op string, latency time.Duration, err error) {
+	slot, ok := ctx.Value(experimentKey{}).(*experimentSlot)
+	if !ok {
+		return
+	}
+	slot.mu.Lock()
+	variant, chosen := slot.chosen[op]
+	first := chosen && !slot.counted[op]
+	if first {
+		slot.counted[op] = true
+	}
+	slot.mu.Unlock()
+	if !chosen {
+		return
+	}
+
+	c := experimentStats
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ops[op] == nil {
+		c.ops[op] = map[string]*variantStats{}
+	}
+	s, ok := c.ops[op][variant]
+	if !ok {
+		s = &variantStats{}
+		c.ops[op][variant] = s
+	}
+	if first {
+		s.requests++
+	}
+	s.calls++
+	s.latency += latency
+	if err != nil {
+		s.errors++
+	}
+}
+
+// ExperimentReport compares the variants of an experiment.
+type ExperimentReport struct {
+	Operation string          `json:"operation"`
+	StartedAt time.Time       `json:"started_at"`
+	Variants  []VariantReport `json:"variants"`
+}
+
+// VariantReport is how a variant did.
+type VariantReport struct {
+	Variant      string        `json:"variant"`
+	Template     string        `json:"template"`
+	Version      string        `json:"version,omitempty"` // of the template
+	Weight       int           `json:"weight"`            // percentage of requests
+	Requests     int           `json:"requests"`
+	LLMCalls     int           `json:"llm_calls"`
+	Errors       int           `json:"errors"`
+	AvgLatencyMS float64       `json:"avg_latency_ms"` // per LLM call
+	Feedback     FeedbackStats `json:"feedback"`
+}
+
+// adminExperimentsHandler serves GET /admin/experiments.
+func adminExperimentsHandler(prompts *PromptRegistry, feedback *FeedbackStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reports := []ExperimentReport{}
+		for op, e := range runtimeConfig.Experiments() {
+			rep := ExperimentReport{Operation: op, StartedAt: e.StartedAt, Variants: []VariantReport{}}
+			ratings := feedback.VariantStats(op, e.StartedAt)
+			for v, weight := range e.Variants {
+				s := experimentStats.get(op, v)
+				vr := VariantReport{
+					Variant:  v,
+					Template: variantTemplate(op, v),
+					Weight:   weight,
+					Requests: s.requests,
+					LLMCalls: s.calls,
+					Errors:   s.errors,
+					Feedback: ratings[v],
+				}
+				if pt, ok := prompts.Get(vr.Template); ok {
+					vr.Version = pt.Version
+				}
+				if s.calls > 0 {
+					vr.AvgLatencyMS = math.Round(float64(s.latency.Microseconds())/float64(s.calls)) / 1000
+				}
+				rep.Variants = append(rep.Variants, vr)
+			}
+			sort.Slice(rep.Variants, func(i, j int) bool { return rep.Variants[i].Variant < rep.Variants[j].Variant })
+			reports = append(reports, rep)
+		}
+		sort.Slice(reports, func(i, j int) bool { return reports[i].Operation < reports[j].Operation })
+		writeJSON(w, http.StatusOK, map[string]interface{}{"experiments": reports})
+	}
+}
+
+// adminExperimentHandler serves PUT and DELETE
+// /admin/config/experiments/<op>.
+func adminExperimentHandler(prompts *PromptRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op := strings.TrimPrefix(r.URL.Path, "/admin/config/experiments/")
+		if !promptNameRe.MatchString(op) || strings.Contains(op, "@") {
+			http.Error(w, "invalid operation", http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			var e PromptExperiment
+			if !decodeJSON(w, r, &e) {
+				return
+			}
+			if err := e.validate(op, prompts); err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			e.StartedAt = time.Now().UTC()
+			if err := runtimeConfig.SetExperiment(r, op, &e); err != nil {
+				log.Println("config error:", err)
+				http.Error(w, "failed to save the configuration", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, e)
+		case http.MethodDelete:
+			if _, ok := runtimeConfig.Experiment(op); !ok {
+				http.Error(w, "no experiment on "+op, http.StatusNotFound)
+				return
+			}
+			if err := runtimeConfig.SetExperiment(r, op, nil); err != nil {
+				log.Println("config error:", err)
+				http.Error(w, "failed to save the configuration", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Code explanations ---
+//
+// POST /explain-code explains a code snippet in plain English and suggests
+// a doc comment for it. Code isn't prose: it goes to the model verbatim
+// (no input_format conversion, whitespace kept), there is no tone, the
+// built-in "system.explain-code" prompt replaces the prose system prompt,
+// and the doc comment comes back as the model wrote it, not reformatted.
+
+type ExplainCodeRequest struct {
+	Code                string `json:"code"`
+	ProgrammingLanguage string `json:"programming_language"` // e.g. "go", "python"; detected if empty
+	Options
+}
+
+type ExplainCodeResponse struct {
+	ProgrammingLanguage string `json:"programming_language"` // as given, or as detected by the model
+	Explanation         string `json:"explanation"`
+	DocComment          string `json:"doc_comment"` // in the language's doc comment syntax, ready to paste
+	ResultMeta
+}
+
+// explainCodePromptInput is the data of the explain-code template.
+type explainCodePromptInput struct {
+	texttools.Input
+	ProgrammingLanguage string
+}
+
+// codeFenceRe matches a doc comment the model wrapped in a Markdown fence.
+var codeFenceRe = regexp.MustCompile("(?s)^```[\\w+#-]*\\n(.*?)\\n?```$")
+
+func explainCodeHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ExplainCodeRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if strings.TrimSpace(req.Code) == "" {
+			http.Error(w, "`code` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Code) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+		req.ProgrammingLanguage = strings.ToLower(strings.TrimSpace(req.ProgrammingLanguage))
+
+		// Reuse matches inputs ignoring punctuation and whitespace, which
+		// matter in code: only identical code may reuse a result.
+		sum := sha256.Sum256([]byte(req.Code))
+		key := historyKey(req.ProgrammingLanguage+"|"+hex.EncodeToString(sum[:]), req.Options)
+		if prior, ok := history.Reusable(r, "explain-code", key, req.Code, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		in := explainCodePromptInput{
+			Input:               texttools.Input{Text: req.Code, Options: req.Options.Options},
+			ProgrammingLanguage: req.ProgrammingLanguage,
+		}
+		out, err := tools.Prompt(r.Context(), "explain-code", in, in.Options)
+		if err != nil {
+			log.Println("explain-code error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var resp ExplainCodeResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			// fallback – treat the whole output as the explanation
+			resp = ExplainCodeResponse{Explanation: out}
+		}
+		resp.ProgrammingLanguage = strings.ToLower(firstNonEmpty(req.ProgrammingLanguage, resp.ProgrammingLanguage))
+		resp.Explanation = texttools.FormatOutput(tools.FitWords(r.Context(), strings.TrimSpace(resp.Explanation), req.Options.Options), req.OutputFormat)
+		resp.DocComment = strings.Trim(resp.DocComment, "\n")
+		if m := codeFenceRe.FindStringSubmatch(strings.TrimSpace(resp.DocComment)); m != nil {
+			resp.DocComment = m[1]
+		}
+
+		resp.ResultMeta = history.Record(r, "explain-code", key, req.Code, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
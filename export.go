@@ -0,0 +1,768 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// --- Export ---
+//
+// POST /export renders operation results as a document to download, in
+// Markdown, PDF or DOCX. Results are given as the JSON responses of the
+// operations (or as plain text) or by their history ids. Each result gets a
+// section: its text fields become paragraphs (list items in them, bullets),
+// its lists become bullet lists. The PDF and DOCX writers are implemented
+// here, to keep the build dependency-free.
+//
+// With "store": true and a blob store (BLOB_STORE), the document is kept
+// instead of downloaded, and any instance serves it from GET /exports/<id>
+// until it is deleted.
+
+const maxExportResults = 50
+
+type ExportRequest struct {
+	Format     string         `json:"format"` // markdown (the default), pdf or docx
+	Title      string         `json:"title"`  // of the document, "Results" by default
+	Results    []ExportResult `json:"results"`
+	HistoryIDs []string       `json:"history_ids"` // stored results to include after `results`
+	Store      bool           `json:"store,omitempty"`
+}
+
+// StoredExport is the response of a stored export.
+type StoredExport struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Format   string `json:"format"`
+	Size     int    `json:"size"`
+	URL      string `json:"url"` // where to download it
+}
+
+type ExportResult struct {
+	Title     string          `json:"title"`     // of the section, from the operation by default
+	Operation string          `json:"operation"` // e.g. "summarize"
+	Result    json.RawMessage `json:"result"`    // the operation's response, or a string
+}
+
+// exportFormat is a document format of /export.
+type exportFormat struct {
+	ext         string
+	contentType string
+	render      func(title string, blocks []exportBlock) ([]byte, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"markdown": {".md", "text/markdown; charset=utf-8", renderMarkdown},
+	"pdf":      {".pdf", "application/pdf", renderPDF},
+	"docx":     {".docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", renderDOCX},
+}
+
+// exportBlobKey is the blob store key of the export id of user.
+func exportBlobKey(user, id string) string {
+	return "exports/" + userBlobPrefix(user) + "/" + id
+}
+
+// exportHandler serves POST /export; blobs, which may be nil, keeps the
+// exports made with "store": true.
+func exportHandler(history *History, blobs BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ExportRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Store {
+			if blobs == nil {
+				http.Error(w, "stored exports are not configured (BLOB_STORE)", http.StatusNotImplemented)
+				return
+			}
+			if refusePrivate(w, r, "stored exports") {
+				return
+			}
+		}
+		if req.Format == "" || req.Format == "md" {
+			req.Format = "markdown"
+		}
+		format, ok := exportFormats[req.Format]
+		if !ok {
+			http.Error(w, "format must be one of markdown, pdf, docx", http.StatusBadRequest)
+			return
+		}
+		results := req.Results
+		for _, id := range req.HistoryIDs {
+			e, ok := history.Get(id)
+			if !ok || e.User != userID(r) {
+				http.Error(w, fmt.Sprintf("result %q not found", id), http.StatusNotFound)
+				return
+			}
+			title := humanize(e.Operation) + " (" + e.CreatedAt.Format("2006-01-02 15:04") + ")"
+			results = append(results, ExportResult{Title: title, Operation: e.Operation, Result: e.Result})
+		}
+		if len(results) == 0 {
+			http.Error(w, "`results` or `history_ids` is required", http.StatusBadRequest)
+			return
+		}
+		if len(results) > maxExportResults {
+			http.Error(w, fmt.Sprintf("at most %d results per export", maxExportResults), http.StatusBadRequest)
+			return
+		}
+		title := strings.TrimSpace(req.Title)
+		if title == "" {
+			title = "Results"
+		}
+
+		var blocks []exportBlock
+		for i, res := range results {
+			if len(res.Result) == 0 {
+				http.Error(w, fmt.Sprintf("results[%d]: `result` is required", i), http.StatusBadRequest)
+				return
+			}
+			dec := json.NewDecoder(bytes.NewReader(res.Result))
+			dec.UseNumber()
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("results[%d]: invalid `result`", i), http.StatusBadRequest)
+				return
+			}
+			heading := strings.TrimSpace(res.Title)
+			switch {
+			case heading != "":
+			case res.Operation != "":
+				heading = humanize(res.Operation)
+			default:
+				heading = fmt.Sprintf("Result %d", i+1)
+			}
+			blocks = append(blocks, exportBlock{kind: blockHeading, level: 1, text: heading})
+			blocks = append(blocks, resultBlocks(v)...)
+		}
+
+		body, err := format.render(title, blocks)
+		if err != nil {
+			log.Println("export error:", err)
+			http.Error(w, "export failed", http.StatusInternalServerError)
+			return
+		}
+		filename := exportFilename(title) + format.ext
+		if req.Store {
+			id := newID()
+			b := Blob{Data: body, ContentType: format.contentType, Filename: filename}
+			if err := blobs.Put(r.Context(), exportBlobKey(userID(r), id), b); err != nil {
+				log.Println("export error:", err)
+				http.Error(w, "failed to store the export", http.StatusBadGateway)
+				return
+			}
+			writeJSON(w, http.StatusCreated, StoredExport{ID: id, Filename: filename, Format: req.Format, Size: len(body), URL: apiPrefix + "/exports/" + id})
+			return
+		}
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", contentDisposition(filename))
+		w.Write(body)
+	}
+}
+
+// exportsHandler serves /exports/<id>: GET downloads a stored export of
+// the caller, DELETE removes it.
+func exportsHandler(blobs BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/exports/")
+		if id == "" || strings.Trim(id, "0123456789abcdef") != "" {
+			http.NotFound(w, r)
+			return
+		}
+		key := exportBlobKey(userID(r), id)
+		switch r.Method {
+		case http.MethodGet:
+			b, err := blobs.Get(r.Context(), key)
+			if errors.Is(err, errBlobNotFound) {
+				http.Error(w, "export not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Println("export error:", err)
+				http.Error(w, "failed to read the export", http.StatusBadGateway)
+				return
+			}
+			writeBlob(w, b)
+		case http.MethodDelete:
+			if _, err := blobs.Get(r.Context(), key); errors.Is(err, errBlobNotFound) {
+				http.Error(w, "export not found", http.StatusNotFound)
+				return
+			}
+			if err := blobs.Delete(r.Context(), key); err != nil {
+				log.Println("export error:", err)
+				http.Error(w, "failed to delete the export", http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// exportFilename makes a file name (without extension) of a title.
+func exportFilename(title string) string {
+	var sb strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(title) {
+		if c < 0x80 && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			sb.WriteRune(c)
+			dash = false
+		} else if !dash && sb.Len() > 0 {
+			sb.WriteByte('-')
+			dash = true
+		}
+		if sb.Len() >= 60 {
+			break
+		}
+	}
+	if name := strings.Trim(sb.String(), "-"); name != "" {
+		return name
+	}
+	return "results"
+}
+
+// humanize turns an operation or field name ("summarize-multi",
+// "duplicate_of") into a heading.
+func humanize(name string) string {
+	name = strings.NewReplacer("_", " ", "-", " ").Replace(name)
+	if name == "" {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// --- Documents ---
+
+// exportBlock is a block of an exported document.
+type exportBlock struct {
+	kind  int
+	level int // of headings: 1 for a result, 2 for a field of it
+	text  string
+}
+
+const (
+	blockParagraph = iota
+	blockHeading
+	blockBullet
+)
+
+// jsonObject is a JSON object with its fields in order.
+type jsonObject []jsonField
+
+type jsonField struct {
+	key   string
+	value interface{}
+}
+
+// decodeOrdered decodes a JSON value like json.Decoder.Decode into an
+// interface{}, except that objects keep their field order (as jsonObject).
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := jsonObject{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonField{key.(string), v})
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		_, err := dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// resultMetaFields are the ResultMeta fields, left out of exports (and of
+// the results to evaluate).
+var resultMetaFields = map[string]bool{"id": true, "reused": true, "duplicate_of": true, "degraded": true, "fallback": true,
+	"model": true, "model_fallback": true}
+
+// resultBlocks lays out a result. The fields of an object get a heading
+// each, unless there is only one (e.g. "summary").
+func resultBlocks(v interface{}) []exportBlock {
+	obj, ok := v.(jsonObject)
+	if !ok {
+		return valueBlocks(v)
+	}
+	var fields jsonObject
+	for _, f := range obj {
+		if !resultMetaFields[f.key] && !emptyValue(f.value) {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 1 {
+		return valueBlocks(fields[0].value)
+	}
+	var blocks []exportBlock
+	for _, f := range fields {
+		blocks = append(blocks, exportBlock{kind: blockHeading, level: 2, text: humanize(f.key)})
+		blocks = append(blocks, valueBlocks(f.value)...)
+	}
+	return blocks
+}
+
+func valueBlocks(v interface{}) []exportBlock {
+	switch v := v.(type) {
+	case string:
+		return textBlocks(v)
+	case []interface{}:
+		var blocks []exportBlock
+		for _, el := range v {
+			if !emptyValue(el) {
+				blocks = append(blocks, exportBlock{kind: blockBullet, text: inlineValue(el)})
+			}
+		}
+		return blocks
+	case jsonObject:
+		var blocks []exportBlock
+		for _, f := range v {
+			if !emptyValue(f.value) {
+				blocks = append(blocks, exportBlock{kind: blockBullet, text: humanize(f.key) + ": " + inlineValue(f.value)})
+			}
+		}
+		return blocks
+	}
+	return []exportBlock{{kind: blockParagraph, text: inlineValue(v)}}
+}
+
+// textBlocks splits text into paragraphs at blank lines; list items become
+// bullets and Markdown headings, headings.
+func textBlocks(text string) []exportBlock {
+	var blocks []exportBlock
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			blocks = append(blocks, exportBlock{kind: blockParagraph, text: strings.Join(para, " ")})
+			para = nil
+		}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "#"):
+			flush()
+			blocks = append(blocks, exportBlock{kind: blockHeading, level: 2, text: strings.TrimSpace(strings.TrimLeft(line, "#"))})
+		case listItem(line) != "":
+			flush()
+			blocks = append(blocks, exportBlock{kind: blockBullet, text: listItem(line)})
+		default:
+			para = append(para, line)
+		}
+	}
+	flush()
+	return blocks
+}
+
+// listItem returns the text of a "- ", "* ", "• " or "1. " list item line.
+func listItem(line string) string {
+	for _, marker := range []string{"- ", "* ", "• "} {
+		if strings.HasPrefix(line, marker) {
+			return strings.TrimSpace(line[len(marker):])
+		}
+	}
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	if i > 0 && i+1 < len(line) && (line[i] == '.' || line[i] == ')') && line[i+1] == ' ' {
+		return strings.TrimSpace(line[i+2:])
+	}
+	return ""
+}
+
+// inlineValue writes a value on one line: lists joined with commas, objects
+// as their first field with the others in parentheses.
+func inlineValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return strings.Join(strings.Fields(v), " ")
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		sep := ", "
+		for _, el := range v {
+			if _, ok := el.(jsonObject); ok {
+				sep = "; "
+			}
+			if !emptyValue(el) {
+				parts = append(parts, inlineValue(el))
+			}
+		}
+		return strings.Join(parts, sep)
+	case jsonObject:
+		var first string
+		var rest []string
+		for i, f := range v {
+			if emptyValue(f.value) {
+				continue
+			}
+			if _, scalar := f.value.(string); i == 0 && scalar {
+				first = inlineValue(f.value)
+				continue
+			}
+			rest = append(rest, f.key+": "+inlineValue(f.value))
+		}
+		switch {
+		case first == "":
+			return strings.Join(rest, "; ")
+		case len(rest) == 0:
+			return first
+		}
+		return first + " (" + strings.Join(rest, "; ") + ")"
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func emptyValue(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	case jsonObject:
+		return len(v) == 0
+	}
+	return false
+}
+
+// --- Markdown ---
+
+func renderMarkdown(title string, blocks []exportBlock) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s\n", title)
+	prev := blockHeading
+	for _, bl := range blocks {
+		if bl.kind != blockBullet || prev != blockBullet {
+			b.WriteString("\n")
+		}
+		switch bl.kind {
+		case blockHeading:
+			fmt.Fprintf(&b, "%s %s\n", strings.Repeat("#", bl.level+1), bl.text)
+		case blockBullet:
+			fmt.Fprintf(&b, "- %s\n", bl.text)
+		default:
+			fmt.Fprintf(&b, "%s\n", bl.text)
+		}
+		prev = bl.kind
+	}
+	return b.Bytes(), nil
+}
+
+// --- DOCX ---
+
+const (
+	docxMain = `http://schemas.openxmlformats.org/wordprocessingml/2006/main`
+
+	docxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+<Override PartName="/word/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.styles+xml"/>
+<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>
+</Types>`
+
+	docxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+</Relationships>`
+
+	docxDocumentRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/>
+</Relationships>`
+
+	docxStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:styles xmlns:w="` + docxMain + `">
+<w:docDefaults><w:rPrDefault><w:rPr><w:rFonts w:ascii="Calibri" w:hAnsi="Calibri" w:cs="Calibri"/><w:sz w:val="22"/></w:rPr></w:rPrDefault>
+<w:pPrDefault><w:pPr><w:spacing w:after="120" w:line="276" w:lineRule="auto"/></w:pPr></w:pPrDefault></w:docDefaults>
+<w:style w:type="paragraph" w:default="1" w:styleId="Normal"><w:name w:val="Normal"/></w:style>
+<w:style w:type="paragraph" w:styleId="Title"><w:name w:val="Title"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:spacing w:after="240"/></w:pPr><w:rPr><w:b/><w:sz w:val="40"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading1"><w:name w:val="heading 1"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="360" w:after="120"/><w:outlineLvl w:val="0"/></w:pPr><w:rPr><w:b/><w:sz w:val="30"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="Heading2"><w:name w:val="heading 2"/><w:basedOn w:val="Normal"/><w:next w:val="Normal"/><w:pPr><w:keepNext/><w:spacing w:before="240" w:after="80"/><w:outlineLvl w:val="1"/></w:pPr><w:rPr><w:b/><w:sz w:val="24"/></w:rPr></w:style>
+<w:style w:type="paragraph" w:styleId="ListParagraph"><w:name w:val="List Paragraph"/><w:basedOn w:val="Normal"/><w:pPr><w:spacing w:after="60"/><w:ind w:left="720"/></w:pPr></w:style>
+</w:styles>`
+
+	docxNumbering = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:numbering xmlns:w="` + docxMain + `">
+<w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0"><w:start w:val="1"/><w:numFmt w:val="bullet"/><w:lvlText w:val="•"/><w:lvlJc w:val="left"/><w:pPr><w:ind w:left="720" w:hanging="360"/></w:pPr></w:lvl></w:abstractNum>
+<w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num>
+</w:numbering>`
+)
+
+func renderDOCX(title string, blocks []exportBlock) ([]byte, error) {
+	var doc bytes.Buffer
+	doc.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	doc.WriteString(`<w:document xmlns:w="` + docxMain + `"><w:body>`)
+	para := func(props, text string) {
+		doc.WriteString("<w:p>")
+		if props != "" {
+			doc.WriteString("<w:pPr>" + props + "</w:pPr>")
+		}
+		doc.WriteString(`<w:r><w:t xml:space="preserve">`)
+		xml.EscapeText(&doc, []byte(text))
+		doc.WriteString("</w:t></w:r></w:p>")
+	}
+	para(`<w:pStyle w:val="Title"/>`, title)
+	for _, bl := range blocks {
+		switch bl.kind {
+		case blockHeading:
+			para(fmt.Sprintf(`<w:pStyle w:val="Heading%d"/>`, bl.level), bl.text)
+		case blockBullet:
+			para(`<w:pStyle w:val="ListParagraph"/><w:numPr><w:ilvl w:val="0"/><w:numId w:val="1"/></w:numPr>`, bl.text)
+		default:
+			para("", bl.text)
+		}
+	}
+	// A4, 2 cm margins
+	doc.WriteString(`<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1134" w:right="1134" w:bottom="1134" w:left="1134" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr>`)
+	doc.WriteString("</w:body></w:document>")
+
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, part := range []struct{ name, content string }{
+		{"[Content_Types].xml", docxContentTypes},
+		{"_rels/.rels", docxRels},
+		{"word/_rels/document.xml.rels", docxDocumentRels},
+		{"word/styles.xml", docxStyles},
+		{"word/numbering.xml", docxNumbering},
+		{"word/document.xml", doc.String()},
+	} {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// --- PDF ---
+//
+// The PDF uses the standard Helvetica fonts, so it embeds none: text is
+// encoded in WinAnsiEncoding (Windows-1252), and characters outside it
+// print as "?".
+
+const (
+	pdfPageWidth  = 595.28 // A4, in points
+	pdfPageHeight = 841.89
+	pdfMargin     = 56.0
+)
+
+// helveticaWidths are the widths of the printable ASCII characters of
+// Helvetica, in thousandths of the font size; the bold face is about 5%
+// wider.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+}
+
+// winAnsiSpecials are the characters of Windows-1252 in 0x80-0x9f.
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+func winAnsi(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, c := range s {
+		switch {
+		case c < 0x20:
+			b = append(b, ' ')
+		case c < 0x7f || (c >= 0xa0 && c <= 0xff):
+			b = append(b, byte(c))
+		case winAnsiSpecials[c] != 0:
+			b = append(b, winAnsiSpecials[c])
+		default:
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+func pdfTextWidth(s []byte, size float64, bold bool) float64 {
+	w := 0
+	for _, c := range s {
+		if c >= 0x20 && c < 0x7f {
+			w += helveticaWidths[c-0x20]
+		} else {
+			w += 556
+		}
+	}
+	if bold {
+		w = w * 105 / 100
+	}
+	return float64(w) * size / 1000
+}
+
+// pdfWrap breaks s into lines of at most width points, at spaces where it
+// can.
+func pdfWrap(s []byte, size, width float64, bold bool) [][]byte {
+	var lines [][]byte
+	var line []byte
+	for _, word := range bytes.Fields(s) {
+		candidate := word
+		if len(line) > 0 {
+			candidate = append(append(append([]byte{}, line...), ' '), word...)
+		}
+		if pdfTextWidth(candidate, size, bold) <= width {
+			line = candidate
+			continue
+		}
+		if len(line) > 0 {
+			lines = append(lines, line)
+		}
+		// a word wider than the line is broken anywhere
+		for pdfTextWidth(word, size, bold) > width {
+			n := len(word) - 1
+			for n > 1 && pdfTextWidth(word[:n], size, bold) > width {
+				n--
+			}
+			lines = append(lines, word[:n])
+			word = word[n:]
+		}
+		line = word
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func pdfString(s []byte) string {
+	var sb strings.Builder
+	sb.WriteByte('(')
+	for _, c := range s {
+		if c == '(' || c == ')' || c == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+func renderPDF(title string, blocks []exportBlock) ([]byte, error) {
+	var pages []*bytes.Buffer
+	var page *bytes.Buffer
+	y := 0.0
+	newPage := func() {
+		page = &bytes.Buffer{}
+		pages = append(pages, page)
+		y = pdfPageHeight - pdfMargin
+	}
+	newPage()
+	write := func(text string, size, before, indent float64, bold bool, bullet bool) {
+		font := "F1"
+		if bold {
+			font = "F2"
+		}
+		leading := size * 1.35
+		lines := pdfWrap(winAnsi(text), size, pdfPageWidth-2*pdfMargin-indent, bold)
+		if y < pdfPageHeight-pdfMargin {
+			y -= before
+		}
+		for i, line := range lines {
+			// keep a heading with the two lines after it
+			need := leading
+			if bold && i == 0 {
+				need = leading + 2*11*1.35
+			}
+			if y-need < pdfMargin {
+				newPage()
+			}
+			y -= leading
+			if bullet && i == 0 {
+				fmt.Fprintf(page, "BT /F1 %.1f Tf %.2f %.2f Td (\x95) Tj ET\n", size, pdfMargin+indent-10, y)
+			}
+			fmt.Fprintf(page, "BT /%s %.1f Tf %.2f %.2f Td %s Tj ET\n", font, size, pdfMargin+indent, y, pdfString(line))
+		}
+	}
+	write(title, 20, 0, 0, true, false)
+	for _, bl := range blocks {
+		switch {
+		case bl.kind == blockHeading && bl.level == 1:
+			write(bl.text, 15, 16, 0, true, false)
+		case bl.kind == blockHeading:
+			write(bl.text, 12, 10, 0, true, false)
+		case bl.kind == blockBullet:
+			write(bl.text, 11, 3, 18, false, true)
+		default:
+			write(bl.text, 11, 7, 0, false, false)
+		}
+	}
+
+	// objects: 1 catalog, 2 pages, 3 and 4 fonts, 5 info, then a page and
+	// its content stream per page
+	var b bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Title %s /Producer (AI Text Tools) >>", pdfString(winAnsi(title))))
+	for i, p := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", pdfPageWidth, pdfPageHeight, 7+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.Len(), p.String()))
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return b.Bytes(), nil
+}
@@ -0,0 +1,1496 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Document text extraction (stdlib only) ---
+
+var errUnsupportedFormat = errors.New("unsupported file type (expected PDF, DOCX, EPUB, HTML or TXT)")
+
+// Document is the text extracted from a file, with a "[Figure N]"
+// placeholder where each figure (an embedded image) was.
+type Document struct {
+	Format  string
+	Text    string
+	Figures []Figure
+}
+
+// extractDocument detects the document format from its extension and
+// content and extracts its text and figures.
+func extractDocument(filename string, data []byte) (*Document, error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	doc := &Document{}
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")) || ext == ".pdf":
+		doc.Format = "pdf"
+		doc.Text, doc.Figures, err = extractPDF(data)
+	case isEPUB(data) || ext == ".epub":
+		doc.Format = "epub"
+		var book *Book
+		if book, err = extractEPUB(data); err == nil {
+			doc.Text = book.Text()
+		}
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")) || ext == ".docx":
+		doc.Format = "docx"
+		doc.Text, doc.Figures, err = extractDOCX(data)
+	case ext == ".html" || ext == ".htm":
+		doc.Format = "html"
+		doc.Text, _, _ = texttools.HTMLText(data)
+	case ext == ".txt" || ext == ".md" || ext == "" || utf8.Valid(data):
+		doc.Format = "txt"
+		doc.Text, err = extractTXT(data)
+	default:
+		return nil, errUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func extractTXT(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // UTF-8 BOM
+	if !utf8.Valid(data) {
+		return "", errors.New("text file is not valid UTF-8")
+	}
+	return string(data), nil
+}
+
+// --- DOCX ---
+
+const maxDOCXDocument = 50 << 20 // uncompressed size limit of word/document.xml
+
+// extractDOCX reads word/document.xml and returns the text of its
+// paragraphs, one per line, and its pictures as figures.
+func extractDOCX(data []byte) (string, []Figure, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid DOCX: %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	doc, ok := files["word/document.xml"]
+	if !ok {
+		return "", nil, errors.New("invalid DOCX: word/document.xml not found")
+	}
+	rels := docxRelationships(files["word/_rels/document.xml.rels"])
+
+	rc, err := doc.Open()
+	if err != nil {
+		return "", nil, err
+	}
+	defer rc.Close()
+	lr := &io.LimitedReader{R: rc, N: maxDOCXDocument + 1}
+
+	var (
+		sb       strings.Builder
+		figures  figureList
+		pic      *docxPicture // the drawing being read
+		picDepth int          // depth of the drawing and pict elements open
+	)
+	dec := xml.NewDecoder(lr)
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if lr.N <= 0 {
+			return "", nil, errors.New("invalid DOCX: word/document.xml is too large")
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid DOCX: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteByte('\t')
+			case "br", "cr":
+				sb.WriteByte('\n')
+			case "drawing", "pict":
+				if picDepth == 0 {
+					pic = &docxPicture{}
+				}
+				picDepth++
+			}
+			if pic != nil {
+				pic.read(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				sb.WriteByte('\n')
+			case "drawing", "pict":
+				// a <w:drawing> can sit in a <w:pict> (and the other way
+				// round): only the outermost one is a figure
+				if picDepth--; picDepth > 0 || pic == nil {
+					break
+				}
+				if target, ok := rels[pic.rel]; ok && !pic.small() {
+					f := Figure{Alt: pic.alt, Width: pic.width, Height: pic.height}
+					f.Type, f.image = docxImage(files[target], target)
+					if s := sb.String(); s != "" && !strings.HasSuffix(s, "\n") && !strings.HasSuffix(s, " ") {
+						sb.WriteByte(' ')
+					}
+					sb.WriteString(figures.add(f))
+				}
+				pic = nil
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	return strings.TrimSpace(sb.String()), figures, nil
+}
+
+// docxPicture collects what a <w:drawing> (or legacy VML <w:pict>) says
+// about its image.
+type docxPicture struct {
+	rel           string // relationship ID of the image part
+	alt           string
+	width, height int // pixels, 0 if unknown
+}
+
+func (p *docxPicture) read(t xml.StartElement) {
+	attr := func(name string) string {
+		for _, a := range t.Attr {
+			if a.Name.Local == name {
+				return a.Value
+			}
+		}
+		return ""
+	}
+	switch t.Name.Local {
+	case "docPr": // alt text
+		p.alt = strings.TrimSpace(firstNonEmpty(attr("descr"), attr("title")))
+	case "extent": // size in EMU, 9525 per pixel
+		cx, _ := strconv.Atoi(attr("cx"))
+		cy, _ := strconv.Atoi(attr("cy"))
+		p.width, p.height = cx/9525, cy/9525
+	case "blip":
+		p.rel = attr("embed")
+	case "imagedata":
+		p.rel = attr("id")
+		if p.alt == "" {
+			p.alt = strings.TrimSpace(attr("title"))
+		}
+	}
+}
+
+func (p *docxPicture) small() bool {
+	return p.width > 0 && p.height > 0 && (p.width < minFigureSize || p.height < minFigureSize)
+}
+
+// docxRelationships maps the relationship IDs of word/document.xml to the
+// zip entries they point to.
+func docxRelationships(f *zip.File) map[string]string {
+	rels := map[string]string{}
+	if f == nil {
+		return rels
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return rels
+	}
+	defer rc.Close()
+	var doc struct {
+		Relationships []struct {
+			ID         string `xml:"Id,attr"`
+			Target     string `xml:"Target,attr"`
+			TargetMode string `xml:"TargetMode,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(rc, 10<<20)).Decode(&doc); err != nil {
+		return rels
+	}
+	for _, r := range doc.Relationships {
+		if r.TargetMode == "External" {
+			continue
+		}
+		if strings.HasPrefix(r.Target, "/") {
+			rels[r.ID] = strings.TrimPrefix(r.Target, "/")
+		} else {
+			rels[r.ID] = path.Join("word", r.Target)
+		}
+	}
+	return rels
+}
+
+// docxImage returns the media type of an embedded image and, if a vision
+// model can read it, its data.
+func docxImage(f *zip.File, name string) (string, *texttools.Image) {
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+	if ext == "jpg" {
+		ext = "jpeg"
+	}
+	mimeType := "image/" + ext
+	if f == nil || !visionImageTypes[mimeType] || f.UncompressedSize64 > maxFigureBytes {
+		return mimeType, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return mimeType, nil
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, maxFigureBytes))
+	if err != nil {
+		return mimeType, nil
+	}
+	return mimeType, &texttools.Image{MIMEType: mimeType, Data: b}
+}
+
+// --- Figures ---
+//
+// Images embedded in PDFs and DOCX files become numbered figures, marked in
+// the text by "[Figure N]" placeholders so summaries can refer to them.
+// LabeledText fills the placeholders in with the document's alt text or a
+// description from a vision model (see describeFigures).
+
+const (
+	minFigureSize  = 48      // pixels; smaller images are icons or decoration
+	maxFigureBytes = 4 << 20 // larger images aren't sent to the vision model
+)
+
+// visionImageTypes are the image types vision models accept.
+var visionImageTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true}
+
+type Figure struct {
+	Number      int    `json:"number"`
+	Page        int    `json:"page,omitempty"` // PDF only
+	Type        string `json:"type,omitempty"` // media type of the image, if known
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	Alt         string `json:"alt,omitempty"`         // the document's alt text
+	Description string `json:"description,omitempty"` // from the vision model
+
+	image *texttools.Image // nil if it can't be sent to a vision model
+}
+
+// figureList numbers figures in the order extraction finds them.
+type figureList []Figure
+
+// add numbers f and returns its placeholder.
+func (l *figureList) add(f Figure) string {
+	f.Number = len(*l) + 1
+	*l = append(*l, f)
+	return figurePlaceholder(f.Number)
+}
+
+func figurePlaceholder(n int) string {
+	return fmt.Sprintf("[Figure %d]", n)
+}
+
+// LabeledText returns the text with each placeholder expanded to
+// "[Figure N: label]", the label being the figure's description or alt
+// text.
+func (d *Document) LabeledText() string {
+	if len(d.Figures) == 0 {
+		return d.Text
+	}
+	var pairs []string
+	for _, f := range d.Figures {
+		if label := firstNonEmpty(f.Description, f.Alt); label != "" {
+			label = strings.Join(strings.Fields(label), " ")
+			pairs = append(pairs, figurePlaceholder(f.Number), fmt.Sprintf("[Figure %d: %s]", f.Number, label))
+		}
+	}
+	return strings.NewReplacer(pairs...).Replace(d.Text)
+}
+
+// --- EPUB ---
+//
+// An EPUB is a zip of XHTML documents. The OPF package file (found through
+// META-INF/container.xml) lists them in reading order (the spine); chapter
+// titles come from the EPUB 3 navigation document or the EPUB 2 NCX.
+
+const maxEPUBEntry = 50 << 20 // uncompressed size limit per zip entry
+
+type Book struct {
+	Title    string
+	Author   string
+	Chapters []Chapter
+}
+
+type Chapter struct {
+	Title string
+	Text  string
+}
+
+// Text returns the whole book as plain text with a heading per chapter.
+func (b *Book) Text() string {
+	var sb strings.Builder
+	for _, c := range b.Chapters {
+		fmt.Fprintf(&sb, "# %s\n\n%s\n\n", c.Title, c.Text)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// isEPUB checks for the uncompressed "mimetype" entry every EPUB starts with.
+func isEPUB(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("PK\x03\x04")) && bytes.Contains(data[:min(len(data), 100)], []byte("application/epub+zip"))
+}
+
+type epubPackage struct {
+	Title    []string `xml:"metadata>title"`
+	Creators []string `xml:"metadata>creator"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		Toc   string `xml:"toc,attr"`
+		Items []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+func extractEPUB(data []byte) (*Book, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EPUB: %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	read := func(name string) ([]byte, error) {
+		f, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid EPUB: %s not found", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		b, err := io.ReadAll(io.LimitReader(rc, maxEPUBEntry+1))
+		if err == nil && len(b) > maxEPUBEntry {
+			err = fmt.Errorf("invalid EPUB: %s is too large", name)
+		}
+		return b, err
+	}
+
+	b, err := read("META-INF/container.xml")
+	if err != nil {
+		return nil, err
+	}
+	var container struct {
+		Rootfiles []struct {
+			FullPath string `xml:"full-path,attr"`
+		} `xml:"rootfiles>rootfile"`
+	}
+	if err := xml.Unmarshal(b, &container); err != nil || len(container.Rootfiles) == 0 {
+		return nil, errors.New("invalid EPUB: no package file in META-INF/container.xml")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+	if b, err = read(opfPath); err != nil {
+		return nil, err
+	}
+	var pkg epubPackage
+	if err := xml.Unmarshal(b, &pkg); err != nil {
+		return nil, fmt.Errorf("invalid EPUB: %s: %w", opfPath, err)
+	}
+
+	book := &Book{}
+	if len(pkg.Title) > 0 {
+		book.Title = strings.TrimSpace(pkg.Title[0])
+	}
+	book.Author = strings.Join(trimAll(pkg.Creators), ", ")
+
+	// chapter titles by document, from the navigation document or the NCX
+	hrefs := map[string]string{}
+	titles := map[string]string{}
+	navDocs := map[string]bool{}
+	for _, item := range pkg.Manifest {
+		name := epubPath(opfPath, item.Href)
+		hrefs[item.ID] = name
+		var toc map[string]string
+		switch {
+		case strings.Contains(" "+item.Properties+" ", " nav "):
+			navDocs[name] = true
+			if b, err := read(name); err == nil {
+				toc = epubNavTitles(b, name)
+			}
+		case item.ID == pkg.Spine.Toc && len(titles) == 0:
+			if b, err := read(name); err == nil {
+				toc = epubNCXTitles(b, name)
+			}
+		}
+		for doc, title := range toc {
+			if _, ok := titles[doc]; !ok {
+				titles[doc] = title
+			}
+		}
+	}
+
+	for i, ref := range pkg.Spine.Items {
+		name, ok := hrefs[ref.IDRef]
+		if !ok || navDocs[name] {
+			continue // the table of contents itself isn't a chapter
+		}
+		b, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		text, docTitle, _ := texttools.HTMLText(b)
+		text = cleanExtractedText(text)
+		if text == "" {
+			continue
+		}
+		title, inTOC := titles[name]
+		if !inTOC && len(titles) > 0 && len(book.Chapters) > 0 {
+			// a chapter split over several documents
+			last := &book.Chapters[len(book.Chapters)-1]
+			last.Text += "\n\n" + text
+			continue
+		}
+		if title == "" && docTitle != "" && docTitle != book.Title {
+			title = docTitle
+		}
+		if title == "" {
+			title = fmt.Sprintf("Section %d", i+1)
+		}
+		book.Chapters = append(book.Chapters, Chapter{Title: title, Text: text})
+	}
+	if len(book.Chapters) == 0 {
+		return nil, errors.New("EPUB contains no text")
+	}
+	return book, nil
+}
+
+// epubPath resolves an href relative to the document it appears in to a zip
+// entry name.
+func epubPath(base, href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if u, err := url.PathUnescape(href); err == nil {
+		href = u
+	}
+	return path.Join(path.Dir(base), href)
+}
+
+// epubNavTitles reads the links of the EPUB 3 navigation document's
+// <nav epub:type="toc">.
+func epubNavTitles(data []byte, name string) map[string]string {
+	titles := map[string]string{}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	navDepth, inTOC := 0, false
+	var href string
+	var label strings.Builder
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "nav":
+				navDepth++
+				for _, a := range t.Attr {
+					if a.Name.Local == "type" && a.Value == "toc" {
+						inTOC = true
+					}
+				}
+			case "a":
+				if inTOC {
+					href = ""
+					label.Reset()
+					for _, a := range t.Attr {
+						if a.Name.Local == "href" {
+							href = a.Value
+						}
+					}
+				}
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "nav":
+				if navDepth--; navDepth == 0 {
+					inTOC = false
+				}
+			case "a":
+				if inTOC && href != "" {
+					doc := epubPath(name, href)
+					if _, ok := titles[doc]; !ok {
+						titles[doc] = strings.Join(strings.Fields(label.String()), " ")
+					}
+					href = ""
+				}
+			}
+		case xml.CharData:
+			if href != "" {
+				label.Write(t)
+			}
+		}
+	}
+	return titles
+}
+
+// epubNCXTitles reads the navPoints of an EPUB 2 toc.ncx.
+func epubNCXTitles(data []byte, name string) map[string]string {
+	titles := map[string]string{}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	var label strings.Builder
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "navLabel":
+				label.Reset()
+			case "text":
+				inText = true
+			case "content":
+				for _, a := range t.Attr {
+					if a.Name.Local == "src" {
+						doc := epubPath(name, a.Value)
+						if _, ok := titles[doc]; !ok {
+							titles[doc] = strings.Join(strings.Fields(label.String()), " ")
+						}
+					}
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "text" {
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				label.Write(t)
+			}
+		}
+	}
+	return titles
+}
+
+// --- PDF ---
+//
+// A best-effort extractor: it walks the page tree, decodes Flate content
+// streams and collects the strings shown by the text operators, using each
+// font's ToUnicode CMap when present. Scanned (image-only) PDFs and exotic
+// encodings yield little or no text.
+
+type pdfObject struct {
+	dict   string
+	stream []byte // raw (still encoded) stream data, nil if none
+}
+
+type pdfFont struct {
+	codeLen int // bytes per character code
+	cmap    map[int]string
+}
+
+type pdfDoc struct {
+	objects map[int]*pdfObject
+	fonts   map[int]*pdfFont // by font object number
+
+	page    int // number of the page being read
+	figures figureList
+	placed  map[int]bool // image objects already given a placeholder
+}
+
+var (
+	pdfObjRe   = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	pdfRefRe   = regexp.MustCompile(`^(\d+)\s+\d+\s+R`)
+	pdfRefsRe  = regexp.MustCompile(`(\d+)\s+\d+\s+R`)
+	pdfNamedRe = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s+(\d+)\s+\d+\s+R`)
+	pdfRootRe  = regexp.MustCompile(`/Root\s+(\d+)\s+\d+\s+R`)
+)
+
+func extractPDF(data []byte) (string, []Figure, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return "", nil, errors.New("invalid PDF: missing header")
+	}
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		return "", nil, errors.New("encrypted PDFs are not supported")
+	}
+
+	doc := &pdfDoc{objects: parsePDFObjects(data), fonts: map[int]*pdfFont{}, placed: map[int]bool{}}
+
+	var sb strings.Builder
+	for i, page := range doc.pages(data) {
+		content := doc.pageContent(page)
+		if len(content) == 0 {
+			continue
+		}
+		doc.page = i + 1
+		sb.WriteString(doc.pageText(page, content))
+		sb.WriteString("\n\n")
+	}
+
+	text := cleanExtractedText(sb.String())
+	if text == "" {
+		return "", nil, errors.New("no extractable text found in PDF (scanned or image-only?)")
+	}
+	return text, doc.figures, nil
+}
+
+// parsePDFObjects indexes every "N G obj ... endobj", including objects
+// packed inside object streams.
+func parsePDFObjects(data []byte) map[int]*pdfObject {
+	objects := map[int]*pdfObject{}
+	pos := 0
+	for {
+		loc := pdfObjRe.FindSubmatchIndex(data[pos:])
+		if loc == nil {
+			break
+		}
+		num, _ := strconv.Atoi(string(data[pos+loc[2] : pos+loc[3]]))
+		start := pos + loc[1]
+		rest := data[start:]
+
+		end := bytes.Index(rest, []byte("endobj"))
+		if end < 0 {
+			end = len(rest)
+		}
+		obj := &pdfObject{}
+		if s := bytes.Index(rest[:end], []byte("stream")); s >= 0 {
+			obj.dict = string(rest[:s])
+			body := rest[s+len("stream"):]
+			body = bytes.TrimPrefix(body, []byte("\r"))
+			body = bytes.TrimPrefix(body, []byte("\n"))
+			e := bytes.Index(body, []byte("endstream"))
+			if e < 0 {
+				e = len(body)
+			}
+			obj.stream = bytes.TrimRight(body[:e], "\r\n")
+			// the stream may itself contain "endobj"; resume after it
+			end = len(rest) - len(body) + e
+			if after := bytes.Index(rest[end:], []byte("endobj")); after >= 0 {
+				end += after
+			}
+		} else {
+			obj.dict = string(rest[:end])
+		}
+		objects[num] = obj
+		pos = start + end
+	}
+
+	// unpack object streams
+	for _, obj := range objects {
+		if !strings.Contains(obj.dict, "/ObjStm") {
+			continue
+		}
+		body, err := decodePDFStream(obj)
+		if err != nil {
+			continue
+		}
+		n, _ := strconv.Atoi(pdfDictValue(obj.dict, "/N"))
+		first, _ := strconv.Atoi(pdfDictValue(obj.dict, "/First"))
+		if first <= 0 || first > len(body) {
+			continue
+		}
+		header := strings.Fields(string(body[:first]))
+		for i := 0; i+1 < len(header) && i/2 < n; i += 2 {
+			num, err1 := strconv.Atoi(header[i])
+			off, err2 := strconv.Atoi(header[i+1])
+			if err1 != nil || err2 != nil || first+off > len(body) {
+				continue
+			}
+			end := len(body)
+			if i+3 < len(header) {
+				if next, err := strconv.Atoi(header[i+3]); err == nil && first+next <= len(body) && next >= off {
+					end = first + next
+				}
+			}
+			if _, exists := objects[num]; !exists {
+				objects[num] = &pdfObject{dict: string(body[first+off : end])}
+			}
+		}
+	}
+	return objects
+}
+
+// pages returns the page objects in document order, falling back to object
+// number order when the page tree can't be followed.
+func (d *pdfDoc) pages(data []byte) []*pdfObject {
+	var pages []*pdfObject
+	if m := pdfRootRe.FindAllSubmatch(data, -1); len(m) > 0 {
+		root, _ := strconv.Atoi(string(m[len(m)-1][1]))
+		if cat, ok := d.objects[root]; ok {
+			if ref, ok := pdfRef(pdfDictValue(cat.dict, "/Pages")); ok {
+				d.walkPages(ref, &pages, map[int]bool{})
+			}
+		}
+	}
+	if len(pages) > 0 {
+		return pages
+	}
+
+	nums := make([]int, 0, len(d.objects))
+	for n, obj := range d.objects {
+		if pdfIsType(obj.dict, "Page") {
+			nums = append(nums, n)
+		}
+	}
+	sort.Ints(nums)
+	for _, n := range nums {
+		pages = append(pages, d.objects[n])
+	}
+	return pages
+}
+
+func (d *pdfDoc) walkPages(num int, pages *[]*pdfObject, seen map[int]bool) {
+	obj, ok := d.objects[num]
+	if !ok || seen[num] {
+		return
+	}
+	seen[num] = true
+	if pdfIsType(obj.dict, "Page") {
+		*pages = append(*pages, obj)
+		return
+	}
+	for _, m := range pdfRefsRe.FindAllStringSubmatch(pdfDictValue(obj.dict, "/Kids"), -1) {
+		kid, _ := strconv.Atoi(m[1])
+		d.walkPages(kid, pages, seen)
+	}
+}
+
+func (d *pdfDoc) pageContent(page *pdfObject) []byte {
+	var out []byte
+	for _, m := range pdfRefsRe.FindAllStringSubmatch(pdfDictValue(page.dict, "/Contents"), -1) {
+		n, _ := strconv.Atoi(m[1])
+		obj, ok := d.objects[n]
+		if !ok {
+			continue
+		}
+		body, err := decodePDFStream(obj)
+		if err != nil {
+			continue
+		}
+		out = append(out, body...)
+		out = append(out, '\n')
+	}
+	return out
+}
+
+// resolve follows an indirect reference, returning v itself otherwise.
+func (d *pdfDoc) resolve(v string) string {
+	if n, ok := pdfRef(v); ok {
+		if obj, ok := d.objects[n]; ok {
+			return obj.dict
+		}
+		return ""
+	}
+	return v
+}
+
+// pageResources returns the page's resource dictionary, following /Parent
+// for inherited resources.
+func (d *pdfDoc) pageResources(page *pdfObject) string {
+	dict := page.dict
+	for i := 0; i < 32 && dict != ""; i++ {
+		if resources := d.resolve(pdfDictValue(dict, "/Resources")); resources != "" {
+			return resources
+		}
+		dict = d.resolve(pdfDictValue(dict, "/Parent"))
+	}
+	return ""
+}
+
+// pageFonts maps the page's font resource names (F1, ...) to fonts.
+func (d *pdfDoc) pageFonts(resources string) map[string]*pdfFont {
+	fonts := map[string]*pdfFont{}
+	for _, m := range pdfNamedRe.FindAllStringSubmatch(d.resolve(pdfDictValue(resources, "/Font")), -1) {
+		n, _ := strconv.Atoi(m[2])
+		fonts[m[1]] = d.font(n)
+	}
+	return fonts
+}
+
+// pageXObjects maps the page's XObject resource names (Im1, ...) to object
+// numbers.
+func (d *pdfDoc) pageXObjects(resources string) map[string]int {
+	xobjects := map[string]int{}
+	for _, m := range pdfNamedRe.FindAllStringSubmatch(d.resolve(pdfDictValue(resources, "/XObject")), -1) {
+		xobjects[m[1]], _ = strconv.Atoi(m[2])
+	}
+	return xobjects
+}
+
+// figure returns the placeholder for image XObject num the first time it's
+// drawn, and "" for repeats (logos on every page), icons and other
+// XObjects.
+func (d *pdfDoc) figure(num int) string {
+	obj, ok := d.objects[num]
+	if !ok || d.placed[num] || pdfDictValue(obj.dict, "/Subtype") != "/Image" {
+		return ""
+	}
+	d.placed[num] = true
+	w, _ := strconv.Atoi(pdfDictValue(obj.dict, "/Width"))
+	h, _ := strconv.Atoi(pdfDictValue(obj.dict, "/Height"))
+	if w < minFigureSize || h < minFigureSize {
+		return ""
+	}
+	f := Figure{Page: d.page, Width: w, Height: h}
+	f.Type, f.image = pdfImage(obj, w, h)
+	return d.figures.add(f)
+}
+
+func (d *pdfDoc) font(num int) *pdfFont {
+	if f, ok := d.fonts[num]; ok {
+		return f
+	}
+	f := &pdfFont{codeLen: 1}
+	if obj, ok := d.objects[num]; ok {
+		if strings.Contains(obj.dict, "/Type0") {
+			f.codeLen = 2
+		}
+		if ref, ok := pdfRef(pdfDictValue(obj.dict, "/ToUnicode")); ok {
+			if cm, ok := d.objects[ref]; ok {
+				if body, err := decodePDFStream(cm); err == nil {
+					f.cmap, f.codeLen = parseToUnicode(body, f.codeLen)
+				}
+			}
+		}
+	}
+	d.fonts[num] = f
+	return f
+}
+
+func (f *pdfFont) decode(b []byte) string {
+	var sb strings.Builder
+	if f == nil {
+		f = &pdfFont{codeLen: 1}
+	}
+	for i := 0; i+f.codeLen <= len(b); i += f.codeLen {
+		code := 0
+		for _, c := range b[i : i+f.codeLen] {
+			code = code<<8 | int(c)
+		}
+		if s, ok := f.cmap[code]; ok {
+			sb.WriteString(s)
+		} else if f.codeLen == 1 {
+			sb.WriteRune(rune(code)) // treat as Latin-1
+		}
+	}
+	return sb.String()
+}
+
+// pageText interprets the text operators of a content stream.
+func (d *pdfDoc) pageText(page *pdfObject, content []byte) string {
+	resources := d.pageResources(page)
+	fonts := d.pageFonts(resources)
+	xobjects := d.pageXObjects(resources)
+	var (
+		sb       strings.Builder
+		font     *pdfFont
+		operands []interface{} // string, []byte, float64 or []interface{}
+		lastY    float64
+	)
+	newline := func() {
+		if sb.Len() > 0 && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteByte('\n')
+		}
+	}
+	space := func() {
+		if s := sb.String(); s != "" && !strings.HasSuffix(s, " ") && !strings.HasSuffix(s, "\n") {
+			sb.WriteByte(' ')
+		}
+	}
+	num := func(i int) float64 {
+		if i >= 0 && i < len(operands) {
+			if f, ok := operands[i].(float64); ok {
+				return f
+			}
+		}
+		return 0
+	}
+
+	lex := &pdfLexer{data: content}
+	for {
+		tok, ok := lex.next()
+		if !ok {
+			break
+		}
+		op, isOp := tok.(pdfOperator)
+		if !isOp {
+			operands = append(operands, tok)
+			continue
+		}
+		switch op {
+		case "Tf":
+			if len(operands) >= 2 {
+				if name, ok := operands[0].(pdfName); ok {
+					font = fonts[string(name)]
+				}
+			}
+		case "Tj":
+			if len(operands) > 0 {
+				if b, ok := operands[len(operands)-1].([]byte); ok {
+					sb.WriteString(font.decode(b))
+				}
+			}
+		case "'", "\"":
+			newline()
+			if len(operands) > 0 {
+				if b, ok := operands[len(operands)-1].([]byte); ok {
+					sb.WriteString(font.decode(b))
+				}
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				if arr, ok := operands[len(operands)-1].([]interface{}); ok {
+					for _, el := range arr {
+						switch v := el.(type) {
+						case []byte:
+							sb.WriteString(font.decode(v))
+						case float64:
+							if v < -250 {
+								space()
+							}
+						}
+					}
+				}
+			}
+		case "Td", "TD":
+			if num(1) != 0 {
+				newline()
+			} else if num(0) != 0 {
+				space()
+			}
+		case "Tm":
+			if y := num(5); y != lastY {
+				newline()
+				lastY = y
+			} else {
+				space()
+			}
+		case "T*":
+			newline()
+		case "Do":
+			if len(operands) > 0 {
+				if name, ok := operands[0].(pdfName); ok {
+					if ph := d.figure(xobjects[string(name)]); ph != "" {
+						newline()
+						sb.WriteString(ph + "\n")
+					}
+				}
+			}
+		case "BI":
+			lex.skipInlineImage()
+		}
+		operands = operands[:0]
+	}
+	return sb.String()
+}
+
+// --- PDF lexer ---
+
+type (
+	pdfOperator string
+	pdfName     string
+)
+
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func isPDFWhitespace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+// next returns the next token: pdfOperator, pdfName, []byte (string),
+// float64, []interface{} (array) or nil for dictionaries it skips.
+func (l *pdfLexer) next() (interface{}, bool) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFWhitespace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			return l.literalString(), true
+		case c == '<':
+			if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+				l.pos += 2
+				return nil, true // dictionary start; its contents become ignored operands
+			}
+			return l.hexString(), true
+		case c == '>':
+			l.pos++
+			if l.pos < len(l.data) && l.data[l.pos] == '>' {
+				l.pos++
+			}
+		case c == '[':
+			l.pos++
+			var arr []interface{}
+			for {
+				if l.skipSpace(); l.pos >= len(l.data) {
+					return arr, true
+				}
+				if l.data[l.pos] == ']' {
+					l.pos++
+					return arr, true
+				}
+				tok, ok := l.next()
+				if !ok {
+					return arr, true
+				}
+				arr = append(arr, tok)
+			}
+		case c == ']' || c == '{' || c == '}' || c == ')':
+			l.pos++
+		case c == '/':
+			l.pos++
+			start := l.pos
+			for l.pos < len(l.data) && !isPDFWhitespace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+				l.pos++
+			}
+			return pdfName(l.data[start:l.pos]), true
+		default:
+			start := l.pos
+			for l.pos < len(l.data) && !isPDFWhitespace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+				l.pos++
+			}
+			word := string(l.data[start:l.pos])
+			if f, err := strconv.ParseFloat(word, 64); err == nil {
+				return f, true
+			}
+			return pdfOperator(word), true
+		}
+	}
+	return nil, false
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) && isPDFWhitespace(l.data[l.pos]) {
+		l.pos++
+	}
+}
+
+func (l *pdfLexer) literalString() []byte {
+	l.pos++ // (
+	var out []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+			out = append(out, c)
+		case ')':
+			if depth--; depth == 0 {
+				return out
+			}
+			out = append(out, c)
+		case '\\':
+			if l.pos >= len(l.data) {
+				return out
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (l *pdfLexer) hexString() []byte {
+	l.pos++ // <
+	start := l.pos
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		l.pos++
+	}
+	digits := strings.Map(func(r rune) rune {
+		if isPDFWhitespace(byte(r)) {
+			return -1
+		}
+		return r
+	}, string(l.data[start:l.pos]))
+	l.pos++ // >
+	if len(digits)%2 == 1 {
+		digits += "0"
+	}
+	b, _ := hex.DecodeString(digits)
+	return b
+}
+
+// skipInlineImage skips binary inline image data up to the EI operator.
+func (l *pdfLexer) skipInlineImage() {
+	i := bytes.Index(l.data[l.pos:], []byte("ID"))
+	if i < 0 {
+		l.pos = len(l.data)
+		return
+	}
+	l.pos += i + 2
+	for l.pos < len(l.data) {
+		j := bytes.Index(l.data[l.pos:], []byte("EI"))
+		if j < 0 {
+			l.pos = len(l.data)
+			return
+		}
+		l.pos += j + 2
+		if isPDFWhitespace(l.data[l.pos-3]) && (l.pos >= len(l.data) || isPDFWhitespace(l.data[l.pos])) {
+			return
+		}
+	}
+}
+
+// --- PDF helpers ---
+
+// pdfImage returns the media type of an image XObject and, if a vision
+// model can read it, the image: JPEG data as is, 8-bit gray or RGB pixels
+// as a PNG.
+func pdfImage(obj *pdfObject, w, h int) (string, *texttools.Image) {
+	filter := pdfDictValue(obj.dict, "/Filter")
+	switch {
+	case strings.Contains(filter, "DCTDecode") && strings.Count(filter, "Decode") == 1:
+		if len(obj.stream) > maxFigureBytes {
+			return "image/jpeg", nil
+		}
+		return "image/jpeg", &texttools.Image{MIMEType: "image/jpeg", Data: obj.stream}
+	case strings.Contains(filter, "JPXDecode"):
+		return "image/jp2", nil
+	}
+
+	var channels int
+	switch pdfDictValue(obj.dict, "/ColorSpace") {
+	case "/DeviceGray":
+		channels = 1
+	case "/DeviceRGB":
+		channels = 3
+	default:
+		return "", nil
+	}
+	if pdfDictValue(obj.dict, "/BitsPerComponent") != "8" || w*h*channels > 4*maxFigureBytes {
+		return "", nil
+	}
+	pixels, err := decodePDFStream(obj)
+	if err != nil {
+		return "", nil
+	}
+	if p, _ := strconv.Atoi(pdfDictValue(pdfDictValue(obj.dict, "/DecodeParms"), "/Predictor")); p >= 10 {
+		if pixels, err = pngUnfilter(pixels, channels, w*channels); err != nil {
+			return "", nil
+		}
+	}
+	if len(pixels) < w*h*channels {
+		return "", nil
+	}
+
+	var img image.Image
+	if channels == 1 {
+		img = &image.Gray{Pix: pixels, Stride: w, Rect: image.Rect(0, 0, w, h)}
+	} else {
+		rgba := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for i, j := 0, 0; i < w*h*3; i, j = i+3, j+4 {
+			copy(rgba.Pix[j:j+3], pixels[i:i+3])
+			rgba.Pix[j+3] = 0xff
+		}
+		img = rgba
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil || buf.Len() > maxFigureBytes {
+		return "image/png", nil
+	}
+	return "image/png", &texttools.Image{MIMEType: "image/png", Data: buf.Bytes()}
+}
+
+// pngUnfilter reverses the PNG row filters PDF streams use with /Predictor
+// 10-15: each row starts with a filter type byte.
+func pngUnfilter(data []byte, bpp, stride int) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	prev := make([]byte, stride)
+	for len(data) >= stride+1 {
+		typ, row := data[0], data[1:stride+1]
+		data = data[stride+1:]
+		cur := make([]byte, stride)
+		for i := range row {
+			var a, b, c int
+			if i >= bpp {
+				a, c = int(cur[i-bpp]), int(prev[i-bpp])
+			}
+			b = int(prev[i])
+			var pred int
+			switch typ {
+			case 0:
+			case 1:
+				pred = a
+			case 2:
+				pred = b
+			case 3:
+				pred = (a + b) / 2
+			case 4: // Paeth
+				p := a + b - c
+				pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+				switch {
+				case pa <= pb && pa <= pc:
+					pred = a
+				case pb <= pc:
+					pred = b
+				default:
+					pred = c
+				}
+			default:
+				return nil, fmt.Errorf("unknown PNG filter %d", typ)
+			}
+			cur[i] = row[i] + byte(pred)
+		}
+		out = append(out, cur...)
+		prev = cur
+	}
+	return out, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+const maxPDFStream = 50 << 20 // decoded size limit per stream
+
+func decodePDFStream(obj *pdfObject) ([]byte, error) {
+	filter := pdfDictValue(obj.dict, "/Filter")
+	switch {
+	case filter == "":
+		return obj.stream, nil
+	case strings.Contains(filter, "FlateDecode") && strings.Count(filter, "Decode") == 1:
+		zr, err := zlib.NewReader(bytes.NewReader(obj.stream))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		b, err := io.ReadAll(io.LimitReader(zr, maxPDFStream+1))
+		if len(b) > maxPDFStream {
+			return nil, errors.New("PDF stream is too large")
+		}
+		if len(b) > 0 {
+			return b, nil // keep whatever decoded before a truncated/corrupt tail
+		}
+		return nil, err
+	default:
+		return nil, fmt.Errorf("unsupported PDF filter %s", filter)
+	}
+}
+
+// pdfDictValue returns the raw value of key in a dictionary: a nested
+// dictionary, an array, a reference or a single token.
+func pdfDictValue(dict, key string) string {
+	idx := 0
+	for {
+		i := strings.Index(dict[idx:], key)
+		if i < 0 {
+			return ""
+		}
+		idx += i + len(key)
+		// make sure we matched the whole key, not a prefix of a longer one
+		if idx < len(dict) && !isPDFWhitespace(dict[idx]) && !isPDFDelimiter(dict[idx]) {
+			continue
+		}
+		break
+	}
+	rest := strings.TrimLeft(dict[idx:], " \r\n\t\f")
+	switch {
+	case strings.HasPrefix(rest, "<<"):
+		depth := 0
+		for i := 0; i+1 < len(rest); i++ {
+			switch rest[i : i+2] {
+			case "<<":
+				depth++
+				i++
+			case ">>":
+				depth--
+				i++
+				if depth == 0 {
+					return rest[:i+1]
+				}
+			}
+		}
+		return rest
+	case strings.HasPrefix(rest, "["):
+		if end := strings.IndexByte(rest, ']'); end >= 0 {
+			return rest[:end+1]
+		}
+		return rest
+	}
+	if m := pdfRefRe.FindString(rest); m != "" {
+		return m
+	}
+	end := 0
+	if strings.HasPrefix(rest, "/") {
+		end = 1
+	}
+	for end < len(rest) && !isPDFWhitespace(rest[end]) && !isPDFDelimiter(rest[end]) {
+		end++
+	}
+	return rest[:end]
+}
+
+func pdfRef(v string) (int, bool) {
+	m := pdfRefRe.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	return n, err == nil
+}
+
+func pdfIsType(dict, typ string) bool {
+	return pdfDictValue(dict, "/Type") == "/"+typ
+}
+
+var (
+	cmapBfcharRe  = regexp.MustCompile(`(?s)beginbfchar(.*?)endbfchar`)
+	cmapBfrangeRe = regexp.MustCompile(`(?s)beginbfrange(.*?)endbfrange`)
+	cmapPairRe    = regexp.MustCompile(`<([0-9A-Fa-f]+)>\s*<([0-9A-Fa-f]*)>`)
+	cmapRangeRe   = regexp.MustCompile(`<([0-9A-Fa-f]+)>\s*<([0-9A-Fa-f]+)>\s*(<[0-9A-Fa-f]*>|\[[^\]]*\])`)
+	cmapHexRe     = regexp.MustCompile(`<([0-9A-Fa-f]*)>`)
+)
+
+// parseToUnicode reads the bfchar/bfrange mappings of a ToUnicode CMap. The
+// code length is taken from the source codes when present.
+func parseToUnicode(body []byte, codeLen int) (map[int]string, int) {
+	cmap := map[int]string{}
+	s := string(body)
+	setLen := func(src string) {
+		if n := len(src) / 2; n > 0 {
+			codeLen = n
+		}
+	}
+
+	for _, block := range cmapBfcharRe.FindAllStringSubmatch(s, -1) {
+		for _, m := range cmapPairRe.FindAllStringSubmatch(block[1], -1) {
+			setLen(m[1])
+			code, _ := strconv.ParseInt(m[1], 16, 32)
+			cmap[int(code)] = utf16HexToString(m[2])
+		}
+	}
+	for _, block := range cmapBfrangeRe.FindAllStringSubmatch(s, -1) {
+		for _, m := range cmapRangeRe.FindAllStringSubmatch(block[1], -1) {
+			setLen(m[1])
+			lo, _ := strconv.ParseInt(m[1], 16, 32)
+			hi, _ := strconv.ParseInt(m[2], 16, 32)
+			if hi < lo || hi-lo > 0xFFFF {
+				continue
+			}
+			if strings.HasPrefix(m[3], "[") {
+				for i, dst := range cmapHexRe.FindAllStringSubmatch(m[3], -1) {
+					if lo+int64(i) > hi {
+						break
+					}
+					cmap[int(lo)+i] = utf16HexToString(dst[1])
+				}
+				continue
+			}
+			dst := strings.Trim(m[3], "<>")
+			base := []rune(utf16HexToString(dst))
+			if len(base) == 0 {
+				continue
+			}
+			for c := lo; c <= hi; c++ {
+				r := append([]rune{}, base...)
+				r[len(r)-1] += rune(c - lo)
+				cmap[int(c)] = string(r)
+			}
+		}
+	}
+	return cmap, codeLen
+}
+
+func utf16HexToString(h string) string {
+	b, err := hex.DecodeString(h)
+	if err != nil || len(b)%2 == 1 {
+		return ""
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return string(utf16.Decode(u))
+}
+
+var blankLinesRe = regexp.MustCompile(`\n{3,}`)
+
+// cleanExtractedText trims trailing spaces and collapses runs of blank lines.
+func cleanExtractedText(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimSpace(blankLinesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
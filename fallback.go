@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Model fallback ---
+//
+// LLM_FALLBACK lists the models to fall back on, in order, when the primary
+// one is rate limited or down. Each entry is a model of the primary
+// provider, a provider (openai, azure or mock) with its default model, or
+// provider:model (azure:<deployment> for Azure):
+//
+//	LLM_FALLBACK=gpt-4o-mini,azure:gpt-4o,mock
+//
+// A call whose model is unavailable (429, 5xx or a network error) goes to
+// the next entry at once; the policy's retries and the circuit breaker then
+// apply to the chain as a whole. Responses say which model served them
+// (model, and model_fallback when it wasn't the primary), as does the
+// provenance of their history entries.
+
+// fallbackModel is an entry of the chain.
+type fallbackModel struct {
+	provider texttools.StreamProvider
+	name     string // the provider's, as in provenance
+	model    string // the provider's default if empty
+}
+
+func (m fallbackModel) String() string {
+	if m.model == "" {
+		return m.name
+	}
+	return m.name + ":" + m.model
+}
+
+// fallbackProvider calls the primary provider and, while the model is
+// unavailable, the fallbacks in turn.
+type fallbackProvider struct {
+	primary   texttools.StreamProvider
+	fallbacks []fallbackModel
+}
+
+// newFallbackProviderFromEnv wraps primary with the chain of LLM_FALLBACK;
+// it returns primary itself if there is none.
+func newFallbackProviderFromEnv(primary texttools.StreamProvider, client *http.Client) (texttools.StreamProvider, error) {
+	v := os.Getenv("LLM_FALLBACK")
+	if strings.TrimSpace(v) == "" {
+		return primary, nil
+	}
+	primaryName := os.Getenv("PROVIDER")
+	if primaryName == "" {
+		primaryName = "openai"
+	}
+	providers := map[string]texttools.StreamProvider{primaryName: primary}
+	p := fallbackProvider{primary: primary}
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// model names may contain colons ("llama3:8b"): only a known
+		// provider name is a prefix
+		name, model := primaryName, entry
+		for _, known := range []string{"openai", "azure", "mock"} {
+			if entry == known {
+				name, model = known, ""
+			} else if m, ok := strings.CutPrefix(entry, known+":"); ok {
+				name, model = known, m
+			}
+		}
+		provider, ok := providers[name]
+		if !ok {
+			var err error
+			if provider, err = newProvider(name, client); err != nil {
+				return nil, fmt.Errorf("LLM_FALLBACK %q: %w", entry, err)
+			}
+			providers[name] = provider
+		}
+		m := fallbackModel{provider: provider, model: model}
+		m.name, _ = describeProvider(provider)
+		p.fallbacks = append(p.fallbacks, m)
+	}
+	chain := make([]string, len(p.fallbacks))
+	for i, m := range p.fallbacks {
+		chain[i] = m.String()
+	}
+	log.Printf("LLM fallback models: %s", strings.Join(chain, ", "))
+	return p, nil
+}
+
+func (p fallbackProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	return p.call(ctx, req, func(provider texttools.StreamProvider, req texttools.Request) (texttools.Completion, error) {
+		return provider.Complete(ctx, req)
+	}, func() bool { return true })
+}
+
+// Stream falls back only as long as nothing has been passed to onDelta yet.
+func (p fallbackProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	streamed := false
+	delta := func(s string) error {
+		streamed = true
+		return onDelta(s)
+	}
+	return p.call(ctx, req, func(provider texttools.StreamProvider, req texttools.Request) (texttools.Completion, error) {
+		return provider.Stream(ctx, req, delta)
+	}, func() bool { return !streamed })
+}
+
+func (p fallbackProvider) call(ctx context.Context, req texttools.Request, call func(texttools.StreamProvider, texttools.Request) (texttools.Completion, error), fallback func() bool) (texttools.Completion, error) {
+	c, err := call(p.primary, req)
+	name, model := describeProvider(p.primary)
+	if req.Model != "" {
+		model = req.Model
+	}
+	served, fellBack := fallbackModel{name: name, model: model}, false
+	for _, m := range p.fallbacks {
+		if err == nil || ctx.Err() != nil || !texttools.Unavailable(err) || !fallback() {
+			break
+		}
+		log.Printf("%s: falling back from %s to %s after: %v", operationName(req.Operation), served, m, err)
+		r := req
+		r.Model = m.model
+		c, err = call(m.provider, r)
+		served, fellBack = m, true
+		if served.model == "" {
+			_, served.model = describeProvider(m.provider)
+		}
+	}
+	if err == nil {
+		setServedModel(ctx, served, fellBack)
+	}
+	return c, err
+}
+
+// --- Served model of a request ---
+
+type servedKey struct{}
+
+// servedSlot receives the model that served the LLM calls of a request.
+type servedSlot struct {
+	mu       sync.Mutex
+	model    fallbackModel // of the last call
+	fallback bool          // any call was served by a fallback
+}
+
+// withServedModel lets the handlers of a request find out which model
+// served its calls (see servedModel).
+func withServedModel(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), servedKey{}, &servedSlot{})))
+	})
+}
+
+func setServedModel(ctx context.Context, m fallbackModel, fallback bool) {
+	slot, ok := ctx.Value(servedKey{}).(*servedSlot)
+	if !ok {
+		return
+	}
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	slot.model = m
+	slot.fallback = slot.fallback || fallback
+}
+
+// servedModel returns the provider and model that served the last LLM call
+// of the request of ctx, and whether any call fell back; ok is false if no
+// fallback chain is configured or no call was made.
+func servedModel(ctx context.Context) (provider, model string, fallback, ok bool) {
+	slot, found := ctx.Value(servedKey{}).(*servedSlot)
+	if !found {
+		return "", "", false, false
+	}
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	if slot.model.name == "" {
+		return "", "", false, false
+	}
+	return slot.model.name, slot.model.model, slot.fallback, true
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// --- Result feedback ---
+//
+// POST /feedback records a thumbs up or down, and optionally a comment, on
+// a result from the caller's history; sending it again for the same result
+// replaces it. Each piece of feedback keeps the operation, prompt version
+// and variant (see experiments.go) and model of the result (from its
+// provenance), so GET /feedback/stats (admin) can tell which operations,
+// prompts and models do well, even after the result itself has left the
+// history. FEEDBACK_FILE persists it.
+
+const (
+	ratingUp   = "up"
+	ratingDown = "down"
+
+	maxCommentChars    = 2000
+	maxRecentComments  = 20
+	maxFeedbackEntries = 100000
+)
+
+type FeedbackRequest struct {
+	ResultID string `json:"result_id"`
+	Rating   string `json:"rating"` // up or down
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Feedback is a caller's rating of a result.
+type Feedback struct {
+	ResultID      string    `json:"result_id"`
+	User          string    `json:"user"`
+	Operation     string    `json:"operation"`
+	PromptVersion string    `json:"prompt_version,omitempty"`
+	PromptVariant string    `json:"prompt_variant,omitempty"` // in a prompt experiment
+	Model         string    `json:"model,omitempty"`
+	Rating        string    `json:"rating"`
+	Comment       string    `json:"comment,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// FeedbackStats are the ratings of a group of results.
+type FeedbackStats struct {
+	Operation     string  `json:"operation,omitempty"`
+	PromptVersion string  `json:"prompt_version,omitempty"`
+	Model         string  `json:"model,omitempty"`
+	Up            int     `json:"up"`
+	Down          int     `json:"down"`
+	Comments      int     `json:"comments"`
+	UpRatio       float64 `json:"up_ratio"` // up / (up + down)
+}
+
+func (s *FeedbackStats) add(f Feedback) {
+	if f.Rating == ratingUp {
+		s.Up++
+	} else {
+		s.Down++
+	}
+	if f.Comment != "" {
+		s.Comments++
+	}
+	s.UpRatio = math.Round(float64(s.Up)/float64(s.Up+s.Down)*1000) / 1000
+}
+
+type FeedbackStatsResponse struct {
+	Total           FeedbackStats   `json:"total"`
+	ByOperation     []FeedbackStats `json:"by_operation"`
+	ByPromptVersion []FeedbackStats `json:"by_prompt_version"` // per operation
+	ByModel         []FeedbackStats `json:"by_model"`
+	RecentComments  []Feedback      `json:"recent_comments"` // newest first
+}
+
+// FeedbackStore keeps the feedback on results, optionally persisted to a
+// JSON file. Past maxFeedbackEntries, the oldest is dropped.
+type FeedbackStore struct {
+	file string
+
+	mu      sync.RWMutex
+	entries []Feedback // oldest first
+}
+
+func NewFeedbackStore(file string) (*FeedbackStore, error) {
+	s := &FeedbackStore{file: file}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return s, nil
+}
+
+// Add stores f, replacing the user's earlier feedback on the same result.
+func (s *FeedbackStore) Add(f Feedback) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if e.ResultID == f.ResultID && e.User == f.User {
+			s.entries = append(s.entries[:i:i], s.entries[i+1:]...)
+			break
+		}
+	}
+	s.entries = append(s.entries, f)
+	if len(s.entries) > maxFeedbackEntries {
+		s.entries = append([]Feedback(nil), s.entries[len(s.entries)-maxFeedbackEntries:]...)
+	}
+	return s.saveLocked()
+}
+
+// Stats aggregates the feedback, of one operation if op is set.
+func (s *FeedbackStore) Stats(op string) FeedbackStatsResponse {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byOp := map[string]*FeedbackStats{}
+	byPrompt := map[string]*FeedbackStats{}
+	byModel := map[string]*FeedbackStats{}
+	group := func(m map[string]*FeedbackStats, key string, init FeedbackStats) *FeedbackStats {
+		g, ok := m[key]
+		if !ok {
+			g = &init
+			m[key] = g
+		}
+		return g
+	}
+	resp := FeedbackStatsResponse{RecentComments: []Feedback{}}
+	for i := len(s.entries) - 1; i >= 0; i-- {
+		f := s.entries[i]
+		if op != "" && f.Operation != op {
+			continue
+		}
+		resp.Total.add(f)
+		group(byOp, f.Operation, FeedbackStats{Operation: f.Operation}).add(f)
+		group(byPrompt, f.Operation+" "+f.PromptVersion, FeedbackStats{Operation: f.Operation, PromptVersion: f.PromptVersion}).add(f)
+		group(byModel, f.Model, FeedbackStats{Model: f.Model}).add(f)
+		if f.Comment != "" && len(resp.RecentComments) < maxRecentComments {
+			resp.RecentComments = append(resp.RecentComments, f)
+		}
+	}
+	resp.ByOperation = sortedFeedbackStats(byOp)
+	resp.ByPromptVersion = sortedFeedbackStats(byPrompt)
+	resp.ByModel = sortedFeedbackStats(byModel)
+	return resp
+}
+
+// VariantStats aggregates the feedback given since on results of op, by
+// prompt variant (see experiments.go).
+func (s *FeedbackStore) VariantStats(op string, since time.Time) map[string]FeedbackStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := map[string]FeedbackStats{}
+	for _, f := range s.entries {
+		if f.Operation != op || f.PromptVariant == "" || f.CreatedAt.Before(since) {
+			continue
+		}
+		g := out[f.PromptVariant]
+		g.add(f)
+		out[f.PromptVariant] = g
+	}
+	return out
+}
+
+// sortedFeedbackStats lists the groups, most rated first.
+func sortedFeedbackStats(m map[string]*FeedbackStats) []FeedbackStats {
+	out := []FeedbackStats{}
+	for _, g := range m {
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].Up+out[i].Down, out[j].Up+out[j].Down
+		if a != b {
+			return a > b
+		}
+		return out[i].Operation+out[i].PromptVersion+out[i].Model < out[j].Operation+out[j].PromptVersion+out[j].Model
+	})
+	return out
+}
+
+func (s *FeedbackStore) saveLocked() error {
+	if s.file == "" {
+		return nil
+	}
+	return writeJSONFile(s.file, s.entries)
+}
+
+// feedbackHandler serves POST /feedback.
+func feedbackHandler(feedback *FeedbackStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req FeedbackRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.ResultID == "" {
+			http.Error(w, "`result_id` is required", http.StatusBadRequest)
+			return
+		}
+		if req.Rating != ratingUp && req.Rating != ratingDown {
+			http.Error(w, "`rating` must be up or down", http.StatusBadRequest)
+			return
+		}
+		req.Comment = strings.TrimSpace(req.Comment)
+		if n := utf8.RuneCountInString(req.Comment); n > maxCommentChars {
+			writeInputError(w, errTooLarge("`comment` is too long: %d characters (max %d)", n, maxCommentChars))
+			return
+		}
+		if req.Comment != "" && refusePrivate(w, r, "feedback comments") {
+			return
+		}
+		e, ok := history.Get(req.ResultID)
+		if !ok || e.User != userID(r) {
+			http.Error(w, "result not found", http.StatusNotFound)
+			return
+		}
+
+		f := Feedback{
+			ResultID:  e.ID,
+			User:      e.User,
+			Operation: e.Operation,
+			Rating:    req.Rating,
+			Comment:   req.Comment,
+			CreatedAt: time.Now().UTC(),
+		}
+		if e.Provenance != nil {
+			f.PromptVersion, f.PromptVariant, f.Model = e.Provenance.PromptVersion, e.Provenance.PromptVariant, e.Provenance.Model
+		}
+		if err := feedback.Add(f); err != nil {
+			log.Println("feedback error:", err)
+			http.Error(w, "failed to save feedback", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, f)
+	}
+}
+
+// feedbackStatsHandler serves GET /feedback/stats, optionally for one
+// ?operation.
+func feedbackStatsHandler(feedback *FeedbackStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, feedback.Stats(r.URL.Query().Get("operation")))
+	}
+}
@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Feeds ---
+//
+// Users subscribe to RSS and Atom feeds with POST /feeds. Every
+// FEED_POLL_INTERVAL each feed is fetched, as a "feed_refresh" job of its
+// user, and its new items are summarized (the item's description, or the
+// linked article with fetch_articles). GET /feeds/digest combines the
+// summaries of all the caller's feeds, newest first, as JSON or as an RSS
+// feed of its own. Feeds and their summaries are persisted to FEEDS_FILE.
+
+const (
+	maxFeedsPerUser = 50
+	maxFeedEntries  = 100  // summaries kept per feed
+	maxFeedSeen     = 1000 // item IDs remembered per feed
+	maxFeedNewItems = 20   // summarized per fetch, newest first
+	maxDigestItems  = 500
+)
+
+// feedPollInterval is how often feeds are fetched (FEED_POLL_INTERVAL).
+var feedPollInterval = 30 * time.Minute
+
+// FeedRequest is the POST /feeds body.
+type FeedRequest struct {
+	URL           string `json:"url"`
+	Name          string `json:"name,omitempty"`           // the feed's title if empty
+	FetchArticles bool   `json:"fetch_articles,omitempty"` // summarize the linked page, not the item's description
+	Options
+}
+
+// Feed is a subscription to an RSS or Atom feed.
+type Feed struct {
+	ID            string `json:"id"`
+	User          string `json:"user"`
+	URL           string `json:"url"`
+	Name          string `json:"name"`
+	FetchArticles bool   `json:"fetch_articles,omitempty"`
+	Options
+	CreatedAt   time.Time   `json:"created_at"`
+	LastFetched *time.Time  `json:"last_fetched,omitempty"`
+	LastError   string      `json:"last_error,omitempty"`
+	Entries     []FeedEntry `json:"entries,omitempty"` // newest first
+	Seen        []string    `json:"seen,omitempty"`    // IDs of the items already summarized
+
+	refreshing bool
+}
+
+// FeedEntry is a summarized item of a feed.
+type FeedEntry struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	Link      string     `json:"link,omitempty"`
+	Published *time.Time `json:"published,omitempty"`
+	Summary   string     `json:"summary"`
+	AddedAt   time.Time  `json:"added_at"`
+}
+
+// at is when e was published, or found if the feed doesn't say.
+func (e FeedEntry) at() time.Time {
+	if e.Published != nil {
+		return *e.Published
+	}
+	return e.AddedAt
+}
+
+// parsedFeed is a fetched RSS or Atom feed.
+type parsedFeed struct {
+	Title string
+	Items []parsedFeedItem
+}
+
+type parsedFeedItem struct {
+	ID        string // guid or id; the link if there is none
+	Title     string
+	Link      string
+	Summary   string // description, summary or content, as text
+	Published time.Time
+}
+
+// parseFeed reads an RSS 2.0 or Atom feed.
+func parseFeed(data []byte) (*parsedFeed, error) {
+	var doc struct {
+		XMLName xml.Name
+		// RSS
+		Channel struct {
+			Title string `xml:"title"`
+			Items []struct {
+				GUID        string `xml:"guid"`
+				Title       string `xml:"title"`
+				Link        string `xml:"link"`
+				Description string `xml:"description"`
+				Content     string `xml:"encoded"`
+				PubDate     string `xml:"pubDate"`
+			} `xml:"item"`
+		} `xml:"channel"`
+		// Atom
+		Title   string `xml:"title"`
+		Entries []struct {
+			ID    string `xml:"id"`
+			Title string `xml:"title"`
+			Links []struct {
+				Href string `xml:"href,attr"`
+				Rel  string `xml:"rel,attr"`
+			} `xml:"link"`
+			Summary   string `xml:"summary"`
+			Content   string `xml:"content"`
+			Published string `xml:"published"`
+			Updated   string `xml:"updated"`
+		} `xml:"entry"`
+	}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	dec.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("not a feed: %w", err)
+	}
+
+	f := &parsedFeed{}
+	switch doc.XMLName.Local {
+	case "rss":
+		f.Title = strings.TrimSpace(doc.Channel.Title)
+		for _, it := range doc.Channel.Items {
+			item := parsedFeedItem{ID: strings.TrimSpace(it.GUID), Title: strings.TrimSpace(it.Title), Link: strings.TrimSpace(it.Link),
+				Summary: feedItemText(cmp.Or(it.Description, it.Content)), Published: parseFeedTime(it.PubDate)}
+			item.ID = cmp.Or(item.ID, item.Link, item.Title)
+			f.Items = append(f.Items, item)
+		}
+	case "feed":
+		f.Title = strings.TrimSpace(doc.Title)
+		for _, e := range doc.Entries {
+			item := parsedFeedItem{ID: strings.TrimSpace(e.ID), Title: strings.TrimSpace(e.Title),
+				Summary: feedItemText(cmp.Or(e.Summary, e.Content)), Published: parseFeedTime(cmp.Or(e.Published, e.Updated))}
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					item.Link = strings.TrimSpace(l.Href)
+					break
+				}
+			}
+			item.ID = cmp.Or(item.ID, item.Link, item.Title)
+			f.Items = append(f.Items, item)
+		}
+	default:
+		return nil, fmt.Errorf("not a feed: <%s>", doc.XMLName.Local)
+	}
+	return f, nil
+}
+
+// feedItemText is the text of an item's description, which is often HTML.
+func feedItemText(s string) string {
+	if !strings.Contains(s, "<") {
+		return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+	}
+	text, _, _ := texttools.HTMLText([]byte("<html><body>" + s + "</body></html>"))
+	return strings.TrimSpace(text)
+}
+
+func parseFeedTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2 Jan 2006 15:04:05 -0700"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// feedText is a feed as text to work on: its items' titles and summaries.
+func feedText(data []byte) (string, error) {
+	f, err := parseFeed(data)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if f.Title != "" {
+		sb.WriteString(f.Title + "\n\n")
+	}
+	for _, it := range f.Items {
+		sb.WriteString("## " + it.Title + "\n")
+		if it.Summary != "" {
+			sb.WriteString(it.Summary + "\n")
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// FeedStore keeps the feeds and their summaries, optionally persisted to
+// a JSON file.
+type FeedStore struct {
+	file string
+
+	mu    sync.Mutex
+	feeds map[string]*Feed
+}
+
+func NewFeedStore(file string) (*FeedStore, error) {
+	s := &FeedStore{file: file, feeds: map[string]*Feed{}}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.feeds); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return s, nil
+}
+
+// List returns the feeds of user, oldest first, without their entries.
+func (s *FeedStore) List(user string) []Feed {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []Feed{}
+	for _, f := range s.feeds {
+		if f.User == user {
+			cp := *f
+			cp.Entries, cp.Seen = nil, nil
+			out = append(out, cp)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Get returns the feed id of user with its entries.
+func (s *FeedStore) Get(user, id string) (Feed, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.feeds[id]
+	if !ok || f.User != user {
+		return Feed{}, false
+	}
+	cp := *f
+	cp.Entries, cp.Seen = slices.Clone(f.Entries), nil
+	return cp, true
+}
+
+// Entries returns the entries of the feeds of user (or of the feed id, if
+// set) added or published since.
+func (s *FeedStore) Entries(user, id string, since time.Time) []FeedDigestItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []FeedDigestItem
+	for _, f := range s.feeds {
+		if f.User != user || id != "" && f.ID != id {
+			continue
+		}
+		for _, e := range f.Entries {
+			if !e.at().Before(since) {
+				out = append(out, FeedDigestItem{FeedID: f.ID, Feed: f.Name, FeedEntry: e})
+			}
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].at().After(out[j].at()) })
+	return out
+}
+
+var (
+	errTooManyFeeds = fmt.Errorf("at most %d feeds per user", maxFeedsPerUser)
+	errFeedExists   = errors.New("the feed is already added")
+)
+
+func (s *FeedStore) Add(f Feed) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, e := range s.feeds {
+		if e.User != f.User {
+			continue
+		}
+		if e.URL == f.URL {
+			return errFeedExists
+		}
+		n++
+	}
+	if n >= maxFeedsPerUser {
+		return errTooManyFeeds
+	}
+	s.feeds[f.ID] = &f
+	return s.saveLocked()
+}
+
+// Delete removes the feed id of user, reporting whether it existed.
+func (s *FeedStore) Delete(user, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.feeds[id]
+	if !ok || f.User != user {
+		return false, nil
+	}
+	delete(s.feeds, id)
+	return true, s.saveLocked()
+}
+
+// begin marks the feed id as being refreshed, unless it already is. The
+// copy returned has the IDs of the items seen but no entries.
+func (s *FeedStore) begin(id string) (Feed, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.feeds[id]
+	if !ok || f.refreshing {
+		return Feed{}, false
+	}
+	f.refreshing = true
+	cp := *f
+	cp.Entries, cp.Seen = nil, slices.Clone(f.Seen)
+	return cp, true
+}
+
+// finish records a refresh of the feed id: the entries added, newest
+// first, and the error that stopped it, if any.
+func (s *FeedStore) finish(id, title string, added []FeedEntry, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.feeds[id]
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	f.refreshing = false
+	f.LastFetched = &now
+	f.LastError = ""
+	if err != nil {
+		f.LastError = err.Error()
+	}
+	if f.Name == "" {
+		f.Name = cmp.Or(title, f.URL)
+	}
+	for _, e := range added {
+		f.Seen = append(f.Seen, e.ID)
+	}
+	if len(f.Seen) > maxFeedSeen {
+		f.Seen = f.Seen[len(f.Seen)-maxFeedSeen:]
+	}
+	f.Entries = append(added, f.Entries...)
+	sort.SliceStable(f.Entries, func(i, j int) bool { return f.Entries[i].at().After(f.Entries[j].at()) })
+	if len(f.Entries) > maxFeedEntries {
+		f.Entries = f.Entries[:maxFeedEntries]
+	}
+	if err := s.saveLocked(); err != nil {
+		log.Println("feeds error:", err)
+	}
+}
+
+// due returns the IDs of the feeds not fetched since before.
+func (s *FeedStore) due(before time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for id, f := range s.feeds {
+		if !f.refreshing && (f.LastFetched == nil || f.LastFetched.Before(before)) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (s *FeedStore) saveLocked() error {
+	if s.file == "" {
+		return nil
+	}
+	return writeJSONFile(s.file, s.feeds)
+}
+
+// FeedReader fetches and summarizes feeds as jobs.
+type FeedReader struct {
+	Store *FeedStore
+	Jobs  *JobStore
+	Tools *texttools.Tools
+}
+
+// Run refreshes the feeds every feedPollInterval.
+func (fr *FeedReader) Run() {
+	for {
+		for _, id := range fr.Store.due(time.Now().Add(-feedPollInterval)) {
+			if _, _, err := fr.Refresh(id); err != nil {
+				log.Printf("feed %s: %v", id, err)
+			}
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+// Refresh fetches the feed id now and summarizes its new items, as a job of
+// its user. It returns false if the feed is already being refreshed.
+func (fr *FeedReader) Refresh(id string) (*Job, bool, error) {
+	f, ok := fr.Store.begin(id)
+	if !ok {
+		return nil, false, nil
+	}
+	j, err := fr.Jobs.Submit("feed_refresh", f.User, func(p *JobProgress) (interface{}, error) {
+		title, added, err := fr.refresh(p, f)
+		if err != nil {
+			log.Printf("feed %s: %v", f.ID, err)
+		}
+		fr.Store.finish(f.ID, title, added, err)
+		return map[string]interface{}{"feed_id": f.ID, "added": len(added)}, err
+	})
+	if err != nil {
+		fr.Store.finish(f.ID, "", nil, err)
+		return nil, true, err
+	}
+	return j, true, nil
+}
+
+// refresh summarizes the items of f not seen before, newest first. It
+// returns the feed's title and the entries made until an error stopped it.
+func (fr *FeedReader) refresh(p *JobProgress, f Feed) (string, []FeedEntry, error) {
+	data, err := fetchURL(f.URL)
+	if err != nil {
+		return "", nil, err
+	}
+	parsed, err := parseFeed(data)
+	if err != nil {
+		return "", nil, err
+	}
+	var items []parsedFeedItem
+	for _, it := range parsed.Items {
+		if !slices.Contains(f.Seen, it.ID) {
+			items = append(items, it)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Published.After(items[j].Published) })
+	if len(items) > maxFeedNewItems {
+		items = items[:maxFeedNewItems]
+	}
+	p.SetTotal(len(items))
+
+	var added []FeedEntry
+	for _, it := range items {
+		e, err := fr.summarize(f, it)
+		if err != nil {
+			return parsed.Title, added, fmt.Errorf("%s: %w", cmp.Or(it.Title, it.ID), err)
+		}
+		added = append(added, e)
+		p.Step()
+	}
+	return parsed.Title, added, nil
+}
+
+// summarize summarizes an item of f: its title and description, or the
+// linked page with FetchArticles.
+func (fr *FeedReader) summarize(f Feed, it parsedFeedItem) (FeedEntry, error) {
+	e := FeedEntry{ID: it.ID, Title: it.Title, Link: it.Link, AddedAt: time.Now().UTC()}
+	if !it.Published.IsZero() {
+		e.Published = &it.Published
+	}
+	text := strings.TrimSpace(it.Title + "\n\n" + it.Summary)
+	if f.FetchArticles && isHTTPURL(it.Link) {
+		if data, err := fetchURL(it.Link); err == nil {
+			if article, _, _ := texttools.HTMLText(data); strings.TrimSpace(article) != "" {
+				text = article
+			}
+		}
+	}
+	if err := validateText(text); err != nil {
+		return e, err
+	}
+	ctx, cancel := context.WithTimeout(batchContext(context.Background()), 2*time.Minute)
+	defer cancel()
+	result, err := runOperation(ctx, fr.Tools, "summarize", RewriteRequest{Text: text, Options: f.Options})
+	if err != nil {
+		return e, fmt.Errorf("LLM error: %w", err)
+	}
+	e.Summary = plainResult(result)
+	return e, nil
+}
+
+// --- Feed handlers ---
+
+// FeedDigestItem is a summarized item in the digest, with its feed.
+type FeedDigestItem struct {
+	FeedID string `json:"feed_id"`
+	Feed   string `json:"feed"`
+	FeedEntry
+}
+
+type FeedDigest struct {
+	Since time.Time        `json:"since"`
+	Items []FeedDigestItem `json:"items"`
+}
+
+// digestSince reads ?since=, a duration back from now or a time (RFC 3339);
+// a day by default.
+func digestSince(r *http.Request) (time.Time, error) {
+	v := r.URL.Query().Get("since")
+	if v == "" {
+		return time.Now().Add(-24 * time.Hour), nil
+	}
+	if d, err := time.ParseDuration(v); err == nil && d > 0 {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("`since` must be a duration, e.g. 24h, or an RFC 3339 time")
+}
+
+// rssDigest is the digest as an RSS 2.0 feed.
+type rssDigest struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	Channel struct {
+		Title       string          `xml:"title"`
+		Link        string          `xml:"link"`
+		Description string          `xml:"description"`
+		Items       []rssDigestItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssDigestItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link,omitempty"`
+	Description string `xml:"description"`
+	GUID        struct {
+		IsPermaLink bool   `xml:"isPermaLink,attr"`
+		Value       string `xml:",chardata"`
+	} `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+}
+
+func writeRSSDigest(w http.ResponseWriter, r *http.Request, items []FeedDigestItem) {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	var doc rssDigest
+	doc.Version = "2.0"
+	doc.Channel.Title = "Feed digest"
+	doc.Channel.Link = scheme + "://" + r.Host + apiPrefix + "/feeds/digest"
+	doc.Channel.Description = "Summaries of the items of your feeds"
+	doc.Channel.Items = make([]rssDigestItem, len(items))
+	for i, it := range items {
+		out := &doc.Channel.Items[i]
+		out.Title = "[" + it.Feed + "] " + it.Title
+		out.Link = it.Link
+		out.Description = it.Summary
+		out.GUID.Value = it.FeedID + ":" + it.ID
+		out.PubDate = it.at().Format(time.RFC1123Z)
+	}
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		http.Error(w, "failed to encode the feed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	_, _ = w.Write([]byte(xml.Header))
+	_, _ = w.Write(b)
+}
+
+// feedsHandler serves /feeds (GET lists the caller's feeds, POST adds one
+// and fetches it), /feeds/<id> (GET with its summaries, DELETE), POST
+// /feeds/<id>/refresh, which fetches it now, and GET /feeds/digest, the
+// summaries of all the caller's feeds since ?since= (24h by default), of
+// one with ?feed=, as JSON or ?format=rss.
+func feedsHandler(fr *FeedReader, prefs *PreferenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/feeds"), "/")
+		id, action, _ := strings.Cut(id, "/")
+		user := userID(r)
+
+		switch {
+		case id == "digest" && action == "" && r.Method == http.MethodGet:
+			since, err := digestSince(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			limit := maxDigestItems
+			if v := r.URL.Query().Get("limit"); v != "" {
+				if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxDigestItems {
+					http.Error(w, "`limit` must be 1 to 500", http.StatusBadRequest)
+					return
+				}
+			}
+			items := fr.Store.Entries(user, r.URL.Query().Get("feed"), since)
+			if len(items) > limit {
+				items = items[:limit]
+			}
+			switch r.URL.Query().Get("format") {
+			case "", "json":
+				if items == nil {
+					items = []FeedDigestItem{}
+				}
+				writeJSON(w, http.StatusOK, FeedDigest{Since: since.UTC(), Items: items})
+			case "rss":
+				writeRSSDigest(w, r, items)
+			default:
+				http.Error(w, "`format` must be json or rss", http.StatusBadRequest)
+			}
+		case action == "refresh" && r.Method == http.MethodPost:
+			if _, ok := fr.Store.Get(user, id); !ok {
+				http.Error(w, "feed not found", http.StatusNotFound)
+				return
+			}
+			j, started, err := fr.Refresh(id)
+			if !started {
+				http.Error(w, "the feed is already being refreshed", http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, j)
+		case action != "":
+			http.NotFound(w, r)
+		case r.Method == http.MethodGet && id == "":
+			writeJSON(w, http.StatusOK, map[string]interface{}{"feeds": fr.Store.List(user)})
+		case r.Method == http.MethodGet:
+			f, ok := fr.Store.Get(user, id)
+			if !ok {
+				http.Error(w, "feed not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, f)
+		case r.Method == http.MethodPost && id == "":
+			if refusePrivate(w, r, "feeds") {
+				return
+			}
+			var req FeedRequest
+			if !decodeJSON(w, r, &req) {
+				return
+			}
+			req.URL = strings.TrimSpace(req.URL)
+			if !isHTTPURL(req.URL) {
+				http.Error(w, "`url` must be an http(s) URL", http.StatusBadRequest)
+				return
+			}
+			if err := req.Options.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			prefs.For(r).apply(&req.Options)
+			data, err := fetchURL(req.URL)
+			if err != nil {
+				http.Error(w, "failed to fetch the feed: "+err.Error(), http.StatusBadGateway)
+				return
+			}
+			parsed, err := parseFeed(data)
+			if err != nil {
+				http.Error(w, "`url` is not an RSS or Atom feed", http.StatusUnprocessableEntity)
+				return
+			}
+			f := Feed{ID: newID(), User: user, URL: req.URL, Name: cmp.Or(strings.TrimSpace(req.Name), parsed.Title, req.URL),
+				FetchArticles: req.FetchArticles, Options: req.Options, CreatedAt: time.Now().UTC()}
+			switch err := fr.Store.Add(f); {
+			case errors.Is(err, errTooManyFeeds), errors.Is(err, errFeedExists):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			case err != nil:
+				log.Println("feeds error:", err)
+				http.Error(w, "failed to save the feed", http.StatusInternalServerError)
+				return
+			}
+			if _, _, err := fr.Refresh(f.ID); err != nil {
+				log.Printf("feed %s: %v", f.ID, err)
+			}
+			writeJSON(w, http.StatusCreated, f)
+		case r.Method == http.MethodDelete && id != "":
+			ok, err := fr.Store.Delete(user, id)
+			if err != nil {
+				log.Println("feeds error:", err)
+				http.Error(w, "failed to save feeds", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "feed not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- Keyword search ---
+//
+// GET /search?mode=keyword finds the caller's stored documents and results
+// (their input and the text of their result: summaries, rewrites, ...) that
+// contain every word of the query, ranked by BM25, with a snippet around
+// the best match. Like semantic search it is a linear scan over what the
+// caller has stored, which the size of history and the document library
+// keep fast, so there is no index to maintain or persist. Words match
+// regardless of case and punctuation; there is no stemming.
+
+const (
+	maxQueryTerms = 20
+	snippetRunes  = 200
+	snippetLead   = 60 // runes of context before the first match
+
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Search result types of keyword search.
+const (
+	searchDocument = "document"
+	searchResult   = "result"
+)
+
+// searchItem is a document or result to search.
+type searchItem struct {
+	hit    SearchResult
+	fields []searchField
+}
+
+type searchField struct {
+	name, text string
+}
+
+// wordToken is a word of a text with its byte offsets.
+type wordToken struct {
+	word       string // lowercased
+	start, end int
+}
+
+// wordTokens splits s into words like normalizedWords, keeping where they
+// are.
+func wordTokens(s string) []wordToken {
+	var out []wordToken
+	start := -1
+	for i, r := range s {
+		word := unicode.IsLetter(r) || unicode.IsNumber(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			out = append(out, wordToken{strings.ToLower(s[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		out = append(out, wordToken{strings.ToLower(s[start:]), start, len(s)})
+	}
+	return out
+}
+
+// queryTerms returns the distinct words of q.
+func queryTerms(q string) []string {
+	var terms []string
+	seen := map[string]bool{}
+	for _, w := range normalizedWords(q) {
+		if !seen[w] {
+			seen[w] = true
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// resultFields returns the text of a stored result by field: its strings
+// and lists of strings, without the response metadata.
+func resultFields(result json.RawMessage) []searchField {
+	var m map[string]interface{}
+	if json.Unmarshal(result, &m) != nil {
+		return nil
+	}
+	var out []searchField
+	for name, v := range m {
+		if resultMetaFields[name] {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			out = append(out, searchField{name, v})
+		case []interface{}:
+			var lines []string
+			for _, e := range v {
+				if s, ok := e.(string); ok {
+					lines = append(lines, s)
+				}
+			}
+			if len(lines) > 0 {
+				out = append(out, searchField{name, strings.Join(lines, "\n")})
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].name < out[j].name })
+	return out
+}
+
+// keywordSearch ranks the items that contain all terms, best first.
+func keywordSearch(items []searchItem, terms []string) []SearchResult {
+	type candidate struct {
+		item  *searchItem
+		freq  map[string]int // term → occurrences in the item
+		count []int          // matches per field
+		words int
+	}
+	var candidates []candidate
+	df := map[string]int{}
+	totalWords := 0
+	for i := range items {
+		c := candidate{item: &items[i], freq: map[string]int{}, count: make([]int, len(items[i].fields))}
+		for f, field := range items[i].fields {
+			for _, t := range wordTokens(field.text) {
+				c.words++
+				if slices.Contains(terms, t.word) {
+					c.freq[t.word]++
+					c.count[f]++
+				}
+			}
+		}
+		totalWords += c.words
+		for t := range c.freq {
+			df[t]++
+		}
+		if len(c.freq) == len(terms) {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return []SearchResult{}
+	}
+
+	n := float64(len(items))
+	avg := float64(totalWords) / n
+	out := make([]SearchResult, 0, len(candidates))
+	for _, c := range candidates {
+		score := 0.0
+		for _, t := range terms {
+			tf := float64(c.freq[t])
+			idf := math.Log(1 + (n-float64(df[t])+0.5)/(float64(df[t])+0.5))
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(c.words)/avg))
+		}
+		best := 0
+		for f := range c.count {
+			if c.count[f] > c.count[best] {
+				best = f
+			}
+		}
+		hit := c.item.hit
+		hit.Score = math.Round(score*1000) / 1000
+		hit.Field = c.item.fields[best].name
+		hit.Snippet = highlightSnippet(c.item.fields[best].text, terms)
+		out = append(out, hit)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out
+}
+
+// highlightSnippet cuts about snippetRunes of text around the first match
+// of terms, HTML-escaped, with the matches in <mark>.
+func highlightSnippet(text string, terms []string) string {
+	tokens := wordTokens(text)
+	first := 0
+	for _, t := range tokens {
+		if slices.Contains(terms, t.word) {
+			first = t.start
+			break
+		}
+	}
+
+	// start snippetLead runes before the match, at a word
+	start := first
+	for n := 0; start > 0 && n < snippetLead; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	for _, t := range tokens {
+		if t.end > start {
+			if t.start < start {
+				start = t.start
+			}
+			break
+		}
+	}
+	end := start
+	for n := 0; end < len(text) && n < snippetRunes; n++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	for _, t := range tokens {
+		if t.start < end && t.end > end {
+			end = t.start // don't cut a word
+			break
+		}
+	}
+
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("…")
+	}
+	pos := start
+	for _, t := range tokens {
+		if t.start < start || t.end > end || !slices.Contains(terms, t.word) {
+			continue
+		}
+		sb.WriteString(html.EscapeString(text[pos:t.start]))
+		sb.WriteString("<mark>" + html.EscapeString(text[t.start:t.end]) + "</mark>")
+		pos = t.end
+	}
+	sb.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		sb.WriteString("…")
+	}
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// keywordSearchItems collects what user has stored that matches f, of one
+// type and operation if set.
+func keywordSearchItems(user, typ, op string, f LabelFilter, documents *DocumentStore, history *History) []searchItem {
+	var items []searchItem
+	if typ != searchResult && op == "" {
+		for _, info := range documents.List(user, f) {
+			d, ok := documents.Get(user, info.ID)
+			if !ok {
+				continue
+			}
+			items = append(items, searchItem{
+				hit:    SearchResult{Type: searchDocument, ID: d.ID, Name: d.Name, Labels: d.Labels, CreatedAt: d.UpdatedAt},
+				fields: []searchField{{"name", d.Name}, {"text", d.Text}},
+			})
+		}
+	}
+	if typ != searchDocument {
+		for _, e := range history.List(user, f) {
+			if op != "" && e.Operation != op {
+				continue
+			}
+			items = append(items, searchItem{
+				hit:    SearchResult{Type: searchResult, ID: e.ID, Operation: e.Operation, Result: e.Result, Labels: e.Labels, CreatedAt: e.CreatedAt},
+				fields: append([]searchField{{"input", e.Input}}, resultFields(e.Result)...),
+			})
+		}
+	}
+	return items
+}
+
+// keywordSearchHandler serves GET /search?mode=keyword&q=... (see
+// searchHandler), optionally by ?type= (document or result), ?operation=,
+// ?folder= and ?tag=.
+func keywordSearchHandler(documents *DocumentStore, history *History, q string, limit int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		terms := queryTerms(q)
+		if len(terms) == 0 {
+			http.Error(w, "`q` has no words to search for", http.StatusBadRequest)
+			return
+		}
+		if len(terms) > maxQueryTerms {
+			http.Error(w, "`q` has too many words (max 20)", http.StatusBadRequest)
+			return
+		}
+		typ := r.URL.Query().Get("type")
+		if typ != "" && typ != searchDocument && typ != searchResult {
+			http.Error(w, "`type` must be document or result", http.StatusBadRequest)
+			return
+		}
+		f, err := labelFilter(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		items := keywordSearchItems(userID(r), typ, r.URL.Query().Get("operation"), f, documents, history)
+		results := keywordSearch(items, terms)
+		if len(results) > limit {
+			results = results[:limit]
+		}
+		writeJSON(w, http.StatusOK, SearchResponse{Query: q, Mode: searchKeyword, Results: results})
+	}
+}
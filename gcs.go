@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Google Cloud Storage blob store ---
+//
+// Objects are read and written with the Cloud Storage XML API, which keeps
+// the Content-Type and Content-Disposition of an upload. Access tokens come
+// from the service account key in GOOGLE_APPLICATION_CREDENTIALS (a signed
+// JWT exchanged for a token) or, without it, from the metadata server of
+// GCE, GKE and Cloud Run. GCS_ENDPOINT points at another endpoint, e.g. an
+// emulator, which is sent no token unless credentials are set.
+
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+const gcsMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+type GCSStore struct {
+	bucket, prefix string
+	endpoint       string
+	tokens         *gcsTokenSource // nil sends no token
+}
+
+func NewGCSStoreFromEnv(bucket, prefix string) (*GCSStore, error) {
+	s := &GCSStore{bucket: bucket, prefix: prefix, endpoint: "https://storage.googleapis.com"}
+	ep := strings.TrimRight(os.Getenv("GCS_ENDPOINT"), "/")
+	if ep != "" {
+		if !isHTTPURL(ep) {
+			return nil, fmt.Errorf("GCS_ENDPOINT: invalid URL %q", ep)
+		}
+		s.endpoint = ep
+	}
+	if file := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); file != "" {
+		ts, err := newGCSServiceAccount(file)
+		if err != nil {
+			return nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+		}
+		s.tokens = ts
+	} else if ep == "" {
+		s.tokens = &gcsTokenSource{}
+	}
+	return s, nil
+}
+
+func (s *GCSStore) Put(ctx context.Context, key string, b Blob) error {
+	header := http.Header{}
+	if b.ContentType != "" {
+		header.Set("Content-Type", b.ContentType)
+	}
+	if b.Filename != "" {
+		header.Set("Content-Disposition", contentDisposition(b.Filename))
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, header, b.Data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return blobResponseError("gcs put", key, resp)
+	}
+	return nil
+}
+
+func (s *GCSStore) Get(ctx context.Context, key string) (Blob, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return Blob{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Blob{}, blobResponseError("gcs get", key, resp)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Blob{}, err
+	}
+	return Blob{Data: data, ContentType: resp.Header.Get("Content-Type"), Filename: dispositionFilename(resp.Header.Get("Content-Disposition"))}, nil
+}
+
+func (s *GCSStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return blobResponseError("gcs delete", key, resp)
+	}
+	return nil
+}
+
+func (s *GCSStore) do(ctx context.Context, method, key string, header http.Header, body []byte) (*http.Response, error) {
+	u := s.endpoint + "/" + s.bucket + "/" + s3EscapePath(blobKey(s.prefix, key))
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.tokens != nil {
+		token, err := s.tokens.Token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("gcs token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return blobClient.Do(req)
+}
+
+// gcsTokenSource gets and caches OAuth access tokens: from a service
+// account key if it has one, else from the metadata server.
+type gcsTokenSource struct {
+	email    string
+	key      *rsa.PrivateKey
+	tokenURI string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newGCSServiceAccount(file string) (*gcsTokenSource, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var sa struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(b, &sa); err != nil {
+		return nil, err
+	}
+	if sa.Type != "service_account" || sa.ClientEmail == "" {
+		return nil, errors.New("not a service account key")
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return nil, errors.New("invalid private_key")
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("private_key: %w", err)
+	}
+	key, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private_key is not an RSA key")
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &gcsTokenSource{email: sa.ClientEmail, key: key, tokenURI: sa.TokenURI}, nil
+}
+
+// Token returns a token valid for at least another minute.
+func (ts *gcsTokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != "" && time.Until(ts.expires) > time.Minute {
+		return ts.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if ts.key != nil {
+		assertion, err := ts.assertion(time.Now())
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"}, "assertion": {assertion}}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, ts.tokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, gcsMetadataTokenURL, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+	resp, err := blobClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", err
+	}
+	if t.AccessToken == "" {
+		return "", errors.New("no access_token in the response")
+	}
+	ts.token, ts.expires = t.AccessToken, time.Now().Add(time.Duration(t.ExpiresIn)*time.Second)
+	return ts.token, nil
+}
+
+// assertion is the RS256 JWT a service account exchanges for a token.
+func (ts *gcsTokenSource) assertion(now time.Time) (string, error) {
+	header := b64([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   ts.email,
+		"scope": gcsScope,
+		"aud":   ts.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + b64(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(nil, ts.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + b64(sig), nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
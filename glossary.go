@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Glossary ---
+//
+// POST /glossary extracts the domain-specific terms of a document with a
+// short definition of each, as used in the document: term/definition pairs
+// in alphabetical order, plus a Markdown rendering to append to a report.
+// Terms that don't occur in the document are dropped.
+
+const (
+	defaultGlossaryTerms = 20
+	maxGlossaryTerms     = 100
+)
+
+type GlossaryRequest struct {
+	Text     string `json:"text"`
+	MaxTerms int    `json:"max_terms"` // default 20
+	Options
+}
+
+type GlossaryResponse struct {
+	Terms    []GlossaryTerm `json:"terms"` // alphabetical
+	Markdown string         `json:"markdown"`
+	ResultMeta
+}
+
+type GlossaryTerm struct {
+	Term        string `json:"term"`
+	Definition  string `json:"definition"`
+	Occurrences int    `json:"occurrences"` // in the text, ignoring case
+}
+
+// glossaryPromptInput is the data of the glossary template.
+type glossaryPromptInput struct {
+	texttools.Input
+	MaxTerms int
+}
+
+func glossaryHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req GlossaryRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if req.MaxTerms == 0 {
+			req.MaxTerms = defaultGlossaryTerms
+		}
+		if req.MaxTerms < 1 || req.MaxTerms > maxGlossaryTerms {
+			http.Error(w, fmt.Sprintf("`max_terms` must be between 1 and %d", maxGlossaryTerms), http.StatusBadRequest)
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey(fmt.Sprint(req.MaxTerms), req.Options)
+		if prior, ok := history.Reusable(r, "glossary", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		text := texttools.PrepareInput(req.Text, req.InputFormat)
+		in := glossaryPromptInput{
+			Input:    texttools.Input{Text: text, Options: req.Options.Options},
+			MaxTerms: req.MaxTerms,
+		}
+		out, err := tools.Prompt(r.Context(), "glossary", in, in.Options)
+		if err != nil {
+			log.Println("glossary error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var found struct {
+			Terms []GlossaryTerm `json:"terms"`
+		}
+		if err := json.Unmarshal([]byte(out), &found); err != nil {
+			logUnparseable(r.Context(), "glossary", out)
+		}
+		resp := GlossaryResponse{Terms: glossaryTerms(text, found.Terms, req.MaxTerms)}
+		resp.Markdown = glossaryMarkdown(resp.Terms, req.OutputFormat)
+
+		resp.ResultMeta = history.Record(r, "glossary", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// glossaryTerms keeps the terms that occur in text, as whole words, once
+// each and at most max of them, and sorts them alphabetically.
+func glossaryTerms(text string, terms []GlossaryTerm, max int) []GlossaryTerm {
+	out := []GlossaryTerm{}
+	seen := map[string]bool{}
+	for _, t := range terms {
+		t.Term = strings.TrimSpace(t.Term)
+		t.Definition = strings.TrimSpace(t.Definition)
+		if t.Term == "" || t.Definition == "" || seen[strings.ToLower(t.Term)] || len(out) == max {
+			continue
+		}
+		re, err := regexp.Compile(`(?i)(^|[^\pL\pN])` + regexp.QuoteMeta(t.Term) + `($|[^\pL\pN])`)
+		if err != nil {
+			continue
+		}
+		if t.Occurrences = len(re.FindAllStringIndex(text, -1)); t.Occurrences == 0 {
+			continue
+		}
+		seen[strings.ToLower(t.Term)] = true
+		out = append(out, t)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return strings.ToLower(out[i].Term) < strings.ToLower(out[j].Term) })
+	return out
+}
+
+// glossaryMarkdown renders the terms as a glossary section, or as plain
+// "Term: definition" lines for plain output.
+func glossaryMarkdown(terms []GlossaryTerm, format string) string {
+	if len(terms) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	if format == texttools.FormatPlain {
+		sb.WriteString("Glossary\n\n")
+		for _, t := range terms {
+			fmt.Fprintf(&sb, "%s: %s\n", t.Term, t.Definition)
+		}
+		return strings.TrimSpace(sb.String())
+	}
+	sb.WriteString("## Glossary\n\n")
+	for _, t := range terms {
+		fmt.Fprintf(&sb, "**%s**: %s\n\n", t.Term, t.Definition)
+	}
+	return strings.TrimSpace(sb.String())
+}
@@ -1,3 +1,10 @@
 module ai-text-tools
 
-go 1.23.2
+go 1.24
+
+require golang.org/x/crypto v0.40.0
+
+require (
+	golang.org/x/net v0.41.0 // indirect
+	golang.org/x/text v0.27.0 // indirect
+)
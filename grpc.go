@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"ai-text-tools/texttools"
+)
+
+// --- gRPC ---
+//
+// With GRPC_ADDR set, the operations are also served over gRPC (see
+// proto/texttools.proto) using the same operation functions as the HTTP API.
+// gRPC is HTTP/2 with length-prefixed protobuf messages and the status in
+// trailers, which net/http supports directly; the handful of messages are
+// encoded by hand to keep the build dependency-free.
+
+const grpcServicePath = "/texttools.v1.TextTools/"
+
+// grpcOperation returns the operation a unary method runs: "ToBullets"
+// runs to-bullets.
+func grpcOperation(method string) string {
+	var b strings.Builder
+	for i, r := range method {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// gRPC status codes.
+const (
+	grpcOK                = 0
+	grpcUnknown           = 2
+	grpcInvalidArgument   = 3
+	grpcDeadlineExceeded  = 4
+	grpcNotFound          = 5
+	grpcPermissionDenied  = 7
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// isGRPCRequest reports whether r calls a method of the gRPC service.
+func isGRPCRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, grpcServicePath)
+}
+
+// grpcCodes maps the codes of failed LLM calls (llmerrors.go) to gRPC
+// status codes.
+var grpcCodes = map[string]int{
+	"upstream_rate_limited":   grpcResourceExhausted,
+	"context_length_exceeded": grpcInvalidArgument,
+	"content_filtered":        grpcInvalidArgument,
+	"content_flagged":         grpcInvalidArgument,
+	"invalid_input":           grpcInvalidArgument,
+	"timeout":                 grpcDeadlineExceeded,
+	"unavailable":             grpcUnavailable,
+	"upstream_error":          grpcUnavailable,
+}
+
+const maxGRPCMessage = 4 << 20 // 4 MB, the usual gRPC default
+
+// newGRPCServer returns a server that speaks HTTP/2 without TLS (h2c), as
+// gRPC clients expect for insecure channels.
+func newGRPCServer(addr string, h http.Handler) *http.Server {
+	srv := &http.Server{Addr: addr, Handler: h}
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return srv
+}
+
+type grpcRequest struct {
+	RewriteRequest
+	Operation string
+}
+
+func grpcHandler(tools *texttools.Tools, prefs *PreferenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		g := &grpcResponse{w: w}
+
+		method, ok := strings.CutPrefix(r.URL.Path, grpcServicePath)
+		if !ok {
+			g.finish(grpcUnimplemented, "unknown service")
+			return
+		}
+		msg, err := readGRPCMessage(r.Body)
+		if err != nil {
+			g.finish(grpcInvalidArgument, err.Error())
+			return
+		}
+		req, err := decodeGRPCRequest(msg)
+		if err != nil {
+			g.finish(grpcInvalidArgument, "invalid message: "+err.Error())
+			return
+		}
+		if req.Text == "" {
+			g.finish(grpcInvalidArgument, "`text` is required")
+			return
+		}
+		if err := validateText(req.Text); err != nil {
+			g.finish(grpcInvalidArgument, err.Error())
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			g.finish(grpcInvalidArgument, err.Error())
+			return
+		}
+		p := prefs.For(r)
+		p.apply(&req.Options)
+		if req.Tone == "" {
+			req.Tone = p.Tone
+		}
+
+		if method == "Stream" {
+			if !isStreamableOperation(req.Operation) {
+				g.finish(grpcInvalidArgument, "operation must be one of "+strings.Join(streamableOperations, ", "))
+				return
+			}
+			full, err := streamOperation(r.Context(), tools, req.Operation, req.RewriteRequest, func(delta string) error {
+				return g.send(encodeStreamChunk(delta, false, ""))
+			})
+			if err != nil {
+				g.llmError(r, req.Operation, err)
+				return
+			}
+			if err := g.send(encodeStreamChunk("", true, full)); err != nil {
+				return
+			}
+			g.finish(grpcOK, "")
+			return
+		}
+
+		op := grpcOperation(method)
+		if !isBuiltinOperation(op) {
+			g.finish(grpcUnimplemented, "unknown method "+method)
+			return
+		}
+		result, err := runOperation(r.Context(), tools, op, req.RewriteRequest)
+		if err != nil {
+			g.llmError(r, op, err)
+			return
+		}
+		if err := g.send(encodeGRPCResult(result)); err != nil {
+			return
+		}
+		g.finish(grpcOK, "")
+	}
+}
+
+// grpcResponse writes length-prefixed messages and the final status, which
+// goes in the headers when no message was sent ("trailers-only") and in the
+// trailers otherwise.
+type grpcResponse struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func (g *grpcResponse) send(msg []byte) error {
+	if !g.started {
+		g.w.WriteHeader(http.StatusOK)
+		g.started = true
+	}
+	var prefix [5]byte // compressed flag, then big-endian length
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	if _, err := g.w.Write(append(prefix[:], msg...)); err != nil {
+		return err
+	}
+	if f, ok := g.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (g *grpcResponse) finish(code int, msg string) {
+	prefix := ""
+	if g.started {
+		prefix = http.TrailerPrefix
+	}
+	g.w.Header().Set(prefix+"Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		g.w.Header().Set(prefix+"Grpc-Message", grpcPercentEncode(msg))
+	}
+	if !g.started {
+		g.w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (g *grpcResponse) llmError(r *http.Request, op string, err error) {
+	log.Printf("grpc %s error: %v", op, err)
+	var flagged *flaggedError
+	if errors.As(err, &flagged) {
+		g.finish(grpcInvalidArgument, flagged.Error())
+		return
+	}
+	if slot, ok := tenantFrom(r.Context()); ok && errors.Is(err, errTenantDenied) {
+		if denied := slot.get(); denied != nil {
+			g.finish(grpcStatusCode(denied.Status), denied.Message)
+			return
+		}
+	}
+	f := classifyLLMError(err)
+	code, ok := grpcCodes[f.Code]
+	if !ok {
+		code = grpcInternal
+	}
+	g.finish(code, f.Code+": "+f.Message)
+}
+
+// grpcStatusCode maps an HTTP error status to a gRPC status code.
+func grpcStatusCode(status int) int {
+	switch status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return grpcInvalidArgument
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusServiceUnavailable, http.StatusBadGateway:
+		return grpcUnavailable
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	case http.StatusInternalServerError:
+		return grpcInternal
+	}
+	return grpcUnknown
+}
+
+// grpcHTTPStatus returns the HTTP status matching the gRPC status of a
+// response with headers h (trailers included), or 0 if it isn't a failed
+// gRPC response.
+func grpcHTTPStatus(h http.Header) int {
+	v := h.Get("Grpc-Status")
+	if v == "" {
+		v = h.Get(http.TrailerPrefix + "Grpc-Status")
+	}
+	code, err := strconv.Atoi(v)
+	if err != nil || code == grpcOK {
+		return 0
+	}
+	switch code {
+	case grpcInvalidArgument:
+		return http.StatusBadRequest
+	case grpcUnauthenticated:
+		return http.StatusUnauthorized
+	case grpcPermissionDenied:
+		return http.StatusForbidden
+	case grpcNotFound, grpcUnimplemented:
+		return http.StatusNotFound
+	case grpcResourceExhausted:
+		return http.StatusTooManyRequests
+	case grpcDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case grpcUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// withGRPCStatus turns the HTTP errors of the middleware in front of the
+// gRPC handler (no API key, rate limit, maintenance, ...) into gRPC
+// statuses, which is what gRPC clients read.
+func withGRPCStatus(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &grpcErrorWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if ew.status == 0 {
+			return
+		}
+		msg := strings.TrimSpace(ew.body.String())
+		var e APIError
+		if json.Unmarshal(ew.body.Bytes(), &e) == nil && e.Error.Message != "" {
+			msg = e.Error.Message
+		}
+		h := w.Header()
+		h.Set("Content-Type", "application/grpc")
+		h.Del("Content-Length")
+		h.Del("X-Content-Type-Options")
+		(&grpcResponse{w: w}).finish(grpcStatusCode(ew.status), msg)
+	})
+}
+
+// grpcErrorWriter holds back an HTTP error response for withGRPCStatus.
+type grpcErrorWriter struct {
+	http.ResponseWriter
+	status int // of the error, 0 if none
+	body   bytes.Buffer
+}
+
+func (w *grpcErrorWriter) WriteHeader(status int) {
+	if status >= 400 {
+		w.status = status
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *grpcErrorWriter) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		if w.body.Len() < 1024 {
+			w.body.Write(b)
+		}
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *grpcErrorWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.status == 0 {
+		f.Flush()
+	}
+}
+
+// grpcPercentEncode encodes a grpc-message value: printable ASCII except
+// "%" is kept, everything else is percent-encoded.
+func grpcPercentEncode(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x20 && c <= 0x7e && c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
+// readGRPCMessage reads the single request message of a unary or
+// server-streaming call.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, errors.New("missing request message")
+	}
+	if prefix[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > maxGRPCMessage {
+		return nil, errors.New("message too large")
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errors.New("truncated request message")
+	}
+	return msg, nil
+}
+
+// --- Protobuf encoding ---
+
+func decodeGRPCRequest(b []byte) (grpcRequest, error) {
+	var req grpcRequest
+	err := protoFields(b, func(field int, data []byte) error {
+		switch field {
+		case 1:
+			req.Text = string(data)
+		case 2:
+			req.Tone = string(data)
+		case 3:
+			return decodeGRPCOptions(data, &req.Options)
+		case 4:
+			req.Operation = string(data)
+		}
+		return nil
+	})
+	return req, err
+}
+
+func decodeGRPCOptions(b []byte, opts *Options) error {
+	return protoFields(b, func(field int, data []byte) error {
+		switch field {
+		case 1:
+			opts.Language = string(data)
+		case 2:
+			opts.Length = string(data)
+		case 3:
+			opts.Model = string(data)
+		case 4:
+			opts.InputFormat = string(data)
+		case 5:
+			opts.OutputFormat = string(data)
+		case 6:
+			v, err := protoDouble(data)
+			opts.Temperature = &v
+			return err
+		case 7:
+			v, err := protoDouble(data)
+			opts.TopP = &v
+			return err
+		case 8:
+			v, err := protoVarint(data)
+			opts.MaxTokens = int(int32(v))
+			return err
+		case 9:
+			v, err := protoVarint(data)
+			seed := int64(v)
+			opts.Seed = &seed
+			return err
+		case 10:
+			v, err := protoVarint(data)
+			opts.MaxWords = int(int32(v))
+			return err
+		}
+		return nil
+	})
+}
+
+// protoDouble decodes a fixed64 double field.
+func protoDouble(data []byte) (float64, error) {
+	if len(data) != 8 {
+		return 0, errors.New("bad double field")
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(data)), nil
+}
+
+// protoVarint decodes a varint field.
+func protoVarint(data []byte) (uint64, error) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 || n != len(data) {
+		return 0, errors.New("bad varint field")
+	}
+	return v, nil
+}
+
+// protoFields calls fn for each field of a message with its raw data: the
+// payload of length-delimited fields, the encoded varint or the fixed-size
+// bytes of the others.
+func protoFields(b []byte, fn func(field int, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("bad field key")
+		}
+		b = b[n:]
+		field, wire := int(key>>3), key&7
+
+		switch wire {
+		case 0: // varint
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return errors.New("bad varint")
+			}
+			if err := fn(field, b[:n]); err != nil {
+				return err
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return io.ErrUnexpectedEOF
+			}
+			if err := fn(field, b[:8]); err != nil {
+				return err
+			}
+			b = b[8:]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return io.ErrUnexpectedEOF
+			}
+			if err := fn(field, b[:4]); err != nil {
+				return err
+			}
+			b = b[4:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return io.ErrUnexpectedEOF
+			}
+			data := b[n : n+int(l)]
+			b = b[n+int(l):]
+			if err := fn(field, data); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported wire type %d", wire)
+		}
+	}
+	return nil
+}
+
+func protoAppendString(b []byte, field int, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func protoAppendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return append(b, 1)
+}
+
+// encodeGRPCResult encodes an operation result as a TextResponse or
+// ListResponse.
+func encodeGRPCResult(result interface{}) []byte {
+	var b []byte
+	text := func(s string, meta ResultMeta) {
+		if s != "" {
+			b = protoAppendString(b, 1, s)
+		}
+		b = protoAppendBool(b, 2, meta.Degraded)
+	}
+	list := func(items []string, meta ResultMeta) {
+		for _, s := range items {
+			b = protoAppendString(b, 1, s)
+		}
+		b = protoAppendBool(b, 2, meta.Degraded)
+	}
+
+	switch res := result.(type) {
+	case SummarizeResponse:
+		text(res.Summary, res.ResultMeta)
+	case RewriteResponse:
+		text(res.Text, res.ResultMeta)
+	case ExpandResponse:
+		text(res.Text, res.ResultMeta)
+	case BulletsResponse:
+		text(res.Text, res.ResultMeta)
+	case ProseResponse:
+		text(res.Text, res.ResultMeta)
+	case KeywordsResponse:
+		list(res.Keywords, res.ResultMeta)
+	case QuestionsResponse:
+		list(res.Questions, res.ResultMeta)
+	case TitlesResponse:
+		list(res.Titles, res.ResultMeta)
+	}
+	return b
+}
+
+func encodeStreamChunk(delta string, done bool, text string) []byte {
+	var b []byte
+	if delta != "" {
+		b = protoAppendString(b, 1, delta)
+	}
+	b = protoAppendBool(b, 2, done)
+	if text != "" {
+		b = protoAppendString(b, 3, text)
+	}
+	return b
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ai-text-tools/texttools"
+)
+
+// protoAppendDouble and protoAppendVarint encode the fields a client sends
+// that the server never writes.
+func protoAppendDouble(b []byte, field int, v float64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|1)
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+}
+
+func protoAppendVarint(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+func TestDecodeGRPCRequest(t *testing.T) {
+	var opts []byte
+	opts = protoAppendString(opts, 1, "Spanish")
+	opts = protoAppendString(opts, 2, "short")
+	opts = protoAppendString(opts, 3, "gpt-4o-mini")
+	opts = protoAppendString(opts, 4, "markdown")
+	opts = protoAppendString(opts, 5, "html")
+	opts = protoAppendDouble(opts, 6, 0.25)
+	opts = protoAppendDouble(opts, 7, 0.9)
+	opts = protoAppendVarint(opts, 8, 256)
+	opts = protoAppendVarint(opts, 9, uint64(1<<40))
+	opts = protoAppendVarint(opts, 10, 50)
+	opts = protoAppendVarint(opts, 99, 7) // unknown fields are skipped
+	opts = binary.LittleEndian.AppendUint32(binary.AppendUvarint(opts, 98<<3|5), 1)
+
+	var msg []byte
+	msg = protoAppendString(msg, 1, "Héllo, wörld")
+	msg = protoAppendString(msg, 2, "friendly")
+	msg = protoAppendString(msg, 3, string(opts))
+	msg = protoAppendString(msg, 4, "to-bullets")
+
+	got, err := decodeGRPCRequest(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp, topP, seed := 0.25, 0.9, int64(1<<40)
+	want := grpcRequest{
+		RewriteRequest: RewriteRequest{Text: "Héllo, wörld", Tone: "friendly", Options: Options{Options: texttools.Options{
+			Language: "Spanish", Length: "short", Model: "gpt-4o-mini", InputFormat: "markdown", OutputFormat: "html", MaxWords: 50,
+			Params: texttools.Params{Temperature: &temp, TopP: &topP, MaxTokens: 256, Seed: &seed},
+		}}},
+		Operation: "to-bullets",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+
+	if got, err := decodeGRPCRequest(nil); err != nil || !reflect.DeepEqual(got, grpcRequest{}) {
+		t.Errorf("empty message: %+v, %v", got, err)
+	}
+}
+
+func TestDecodeGRPCRequestInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"bad key":                 {0x80},
+		"string past the end":     {1<<3 | 2, 10, 'a'},
+		"huge length":             append([]byte{1<<3 | 2}, binary.AppendUvarint(nil, math.MaxUint64)...),
+		"truncated varint":        {8<<3 | 0, 0x80},
+		"truncated double":        {6<<3 | 1, 0, 0},
+		"truncated fixed32":       {9<<3 | 5, 0},
+		"group wire type":         {1<<3 | 3},
+		"double as varint":        protoAppendString(nil, 3, string(protoAppendVarint(nil, 6, 1))),
+		"max_tokens as string":    protoAppendString(nil, 3, string(protoAppendString(nil, 8, "100"))),
+		"options past the end":    protoAppendString(nil, 3, string([]byte{1<<3 | 2, 5})),
+		"varint with extra bytes": protoAppendString(nil, 3, string(protoAppendString(nil, 9, string([]byte{1, 2})))),
+	}
+	for name, msg := range tests {
+		if got, err := decodeGRPCRequest(msg); err == nil {
+			t.Errorf("%s: decoded %+v", name, got)
+		}
+	}
+}
+
+// decodeStrings decodes the string fields and the field 2 flag of a result
+// message.
+func decodeStrings(t *testing.T, b []byte) (strs map[int][]string, flag bool) {
+	t.Helper()
+	strs = map[int][]string{}
+	err := protoFields(b, func(field int, data []byte) error {
+		if field == 2 {
+			v, err := protoVarint(data)
+			flag = v == 1
+			return err
+		}
+		strs[field] = append(strs[field], string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strs, flag
+}
+
+func TestEncodeGRPCResult(t *testing.T) {
+	long := strings.Repeat("long text ", 100)
+	tests := []struct {
+		name     string
+		result   interface{}
+		want     map[int][]string
+		degraded bool
+	}{
+		{"summary", SummarizeResponse{Summary: "In short."}, map[int][]string{1: {"In short."}}, false},
+		{"degraded rewrite", RewriteResponse{Text: long, ResultMeta: ResultMeta{Degraded: true}}, map[int][]string{1: {long}}, true},
+		{"empty text", ExpandResponse{}, map[int][]string{}, false},
+		{"keywords", KeywordsResponse{Keywords: []string{"go", "", "grpc"}}, map[int][]string{1: {"go", "", "grpc"}}, false},
+		{"degraded titles", TitlesResponse{Titles: []string{"A"}, ResultMeta: ResultMeta{Degraded: true}}, map[int][]string{1: {"A"}}, true},
+		{"no questions", QuestionsResponse{}, map[int][]string{}, false},
+	}
+	for _, tt := range tests {
+		got, degraded := decodeStrings(t, encodeGRPCResult(tt.result))
+		if !reflect.DeepEqual(got, tt.want) || degraded != tt.degraded {
+			t.Errorf("%s: got %q, degraded %v; want %q, %v", tt.name, got, degraded, tt.want, tt.degraded)
+		}
+	}
+}
+
+func TestEncodeStreamChunk(t *testing.T) {
+	got, done := decodeStrings(t, encodeStreamChunk("Hel", false, ""))
+	if !reflect.DeepEqual(got, map[int][]string{1: {"Hel"}}) || done {
+		t.Errorf("delta chunk: %q, done %v", got, done)
+	}
+	got, done = decodeStrings(t, encodeStreamChunk("", true, "Hello"))
+	if !reflect.DeepEqual(got, map[int][]string{3: {"Hello"}}) || !done {
+		t.Errorf("final chunk: %q, done %v", got, done)
+	}
+}
+
+func TestGRPCMessageRoundTrip(t *testing.T) {
+	w := httptest.NewRecorder()
+	g := &grpcResponse{w: w}
+	msgs := [][]byte{encodeStreamChunk("a", false, ""), {}, bytes.Repeat([]byte{0xff}, 70000)}
+	for _, m := range msgs {
+		if err := g.send(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g.finish(grpcOK, "")
+
+	r := bytes.NewReader(w.Body.Bytes())
+	for i, want := range msgs {
+		got, err := readGRPCMessage(r)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("message %d: %d bytes, %v; want %d bytes", i, len(got), err, len(want))
+		}
+	}
+	if _, err := readGRPCMessage(r); err == nil {
+		t.Error("read a message past the end")
+	}
+	if got := w.Header().Get(http.TrailerPrefix + "Grpc-Status"); got != "0" {
+		t.Errorf("trailer Grpc-Status %q", got)
+	}
+}
+
+func TestReadGRPCMessageInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":      nil,
+		"short":      {0, 0, 0},
+		"compressed": {1, 0, 0, 0, 1, 'x'},
+		"too large":  binary.BigEndian.AppendUint32([]byte{0}, maxGRPCMessage+1),
+		"truncated":  {0, 0, 0, 0, 5, 'a', 'b'},
+	}
+	for name, b := range tests {
+		if msg, err := readGRPCMessage(bytes.NewReader(b)); err == nil {
+			t.Errorf("%s: read %q", name, msg)
+		}
+	}
+}
+
+func TestGRPCTrailersOnly(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&grpcResponse{w: w}).finish(grpcInvalidArgument, "text is required: 100% ✓\n")
+	if w.Body.Len() != 0 {
+		t.Errorf("trailers-only response has a body: %q", w.Body)
+	}
+	if got := w.Header().Get("Grpc-Status"); got != "3" {
+		t.Errorf("Grpc-Status %q", got)
+	}
+	if got, want := w.Header().Get("Grpc-Message"), "text is required: 100%25 %E2%9C%93%0A"; got != want {
+		t.Errorf("Grpc-Message %q, want %q", got, want)
+	}
+}
+
+func TestGRPCOperation(t *testing.T) {
+	for method, want := range map[string]string{
+		"Summarize":       "summarize",
+		"ToBullets":       "to-bullets",
+		"ExtractKeywords": "extract-keywords",
+		"Run":             "run",
+	} {
+		if got := grpcOperation(method); got != want {
+			t.Errorf("grpcOperation(%q) = %q, want %q", method, got, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Prompt injection guard ---
+//
+// PROMPT_GUARD protects the prompts from instructions hidden in the user's
+// text: "sanitize" delimits the text and strips instruction-like phrases
+// (see texttools.SanitizeUserText); "classify" also has the model check
+// the text for injection attempts before each call, one more model call
+// each, and fails flagged requests with a 422 like moderation does, in the
+// prompt_injection category.
+
+const (
+	guardNone     = "none"
+	guardSanitize = "sanitize"
+	guardClassify = "classify"
+)
+
+// injectionCategory is the moderation category of injection attempts.
+const injectionCategory = "prompt_injection"
+
+// promptGuardFromEnv returns the PROMPT_GUARD mode.
+func promptGuardFromEnv() (string, error) {
+	switch mode := os.Getenv("PROMPT_GUARD"); mode {
+	case "", guardNone:
+		return guardNone, nil
+	case guardSanitize, guardClassify:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown PROMPT_GUARD %q (want none, sanitize or classify)", mode)
+	}
+}
+
+// InjectionClassifier is a Moderator that asks the model whether the user
+// texts of a guarded prompt try to instruct it. Prompts without delimited
+// text, and model outputs, pass.
+type InjectionClassifier struct {
+	Tools *texttools.Tools // unguarded, on a provider that isn't moderated
+}
+
+func (c *InjectionClassifier) Moderate(ctx context.Context, text string) (texttools.Moderation, error) {
+	res := texttools.Moderation{Categories: map[string]bool{}, Scores: map[string]float64{}}
+	texts := texttools.UserTexts(text)
+	if len(texts) == 0 {
+		return res, nil
+	}
+	in := texttools.Input{Text: strings.Join(texts, "\n\n")}
+	out, err := c.Tools.Prompt(ctx, "injection-check", in, in.Options)
+	if err != nil {
+		return res, err
+	}
+	var verdict struct {
+		Injection bool    `json:"injection"`
+		Score     float64 `json:"score"`
+	}
+	if err := json.Unmarshal([]byte(out), &verdict); err != nil {
+		logUnparseable(ctx, "injection-check", out)
+		return res, nil
+	}
+	res.Scores[injectionCategory] = min(max(verdict.Score, 0), 1)
+	if verdict.Injection {
+		res.Flagged = true
+		res.Categories[injectionCategory] = true
+	}
+	return res, nil
+}
+
+// moderators screens text with each of its moderators in turn; the text is
+// flagged if any of them flags it.
+type moderators []texttools.Moderator
+
+func (ms moderators) Moderate(ctx context.Context, text string) (texttools.Moderation, error) {
+	res := texttools.Moderation{Categories: map[string]bool{}, Scores: map[string]float64{}}
+	for _, m := range ms {
+		r, err := m.Moderate(ctx, text)
+		if err != nil {
+			return res, err
+		}
+		res.Flagged = res.Flagged || r.Flagged
+		for c, v := range r.Categories {
+			res.Categories[c] = res.Categories[c] || v
+		}
+		for c, v := range r.Scores {
+			res.Scores[c] = max(res.Scores[c], v)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Headlines ---
+//
+// POST /headlines extends /titles for marketing A/B tests: headline variants
+// in several styles, each returned as an object with its style, its length
+// class and an ID to track it by, rather than as a bare string. The length
+// class is measured here, not taken from the model.
+
+// Headline styles.
+var headlineStyles = []string{"clickbait", "neutral", "seo", "question"}
+
+const (
+	defaultHeadlineVariants = 3
+	maxHeadlineVariants     = 10
+	// Headlines up to shortHeadlineChars characters are short, up to
+	// mediumHeadlineChars medium (about what search results show in full)
+	// and long past it.
+	shortHeadlineChars  = 40
+	mediumHeadlineChars = 60
+)
+
+type HeadlinesRequest struct {
+	Text     string   `json:"text"`
+	Styles   []string `json:"styles"`   // only these styles; all by default
+	Variants int      `json:"variants"` // per style, default 3
+	Options
+}
+
+type HeadlinesResponse struct {
+	Headlines []Headline `json:"headlines"`
+	ResultMeta
+}
+
+// Headline is one variant. IDs (v1, v2, ...) are unique within a response.
+type Headline struct {
+	ID         string `json:"id"`
+	Text       string `json:"text"`
+	Style      string `json:"style"`
+	Length     string `json:"length"` // short, medium or long
+	Characters int    `json:"characters"`
+	Words      int    `json:"words"`
+}
+
+// headlinesPromptInput is the data of the headlines template.
+type headlinesPromptInput struct {
+	texttools.Input
+	Styles   []string
+	Variants int
+}
+
+// headlineLength is the length class of a headline of n characters.
+func headlineLength(n int) string {
+	switch {
+	case n <= shortHeadlineChars:
+		return "short"
+	case n <= mediumHeadlineChars:
+		return "medium"
+	}
+	return "long"
+}
+
+func headlinesHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req HeadlinesRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		for _, s := range req.Styles {
+			if !slices.Contains(headlineStyles, s) {
+				http.Error(w, fmt.Sprintf("unknown style %q (want %s)", s, strings.Join(headlineStyles, ", ")), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(req.Styles) == 0 {
+			req.Styles = headlineStyles
+		}
+		if req.Variants == 0 {
+			req.Variants = defaultHeadlineVariants
+		}
+		if req.Variants < 1 || req.Variants > maxHeadlineVariants {
+			http.Error(w, fmt.Sprintf("`variants` must be between 1 and %d", maxHeadlineVariants), http.StatusBadRequest)
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey(fmt.Sprintf("%s|%d", strings.Join(req.Styles, ","), req.Variants), req.Options)
+		if prior, ok := history.Reusable(r, "headlines", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		in := headlinesPromptInput{
+			Input:    texttools.Input{Text: texttools.PrepareInput(req.Text, req.InputFormat), Options: req.Options.Options},
+			Styles:   req.Styles,
+			Variants: req.Variants,
+		}
+		out, err := tools.Prompt(r.Context(), "headlines", in, in.Options)
+		if err != nil {
+			log.Println("headlines error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var generated struct {
+			Headlines []Headline `json:"headlines"`
+		}
+		if err := json.Unmarshal([]byte(out), &generated); err != nil {
+			logUnparseable(r.Context(), "headlines", out)
+		}
+		resp := HeadlinesResponse{Headlines: headlineVariants(generated.Headlines, req.Styles, req.Variants)}
+
+		resp.ResultMeta = history.Record(r, "headlines", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// headlineVariants keeps the generated headlines of the given styles, at
+// most variants per style and without duplicates, grouped by style, and
+// fills in their IDs and lengths.
+func headlineVariants(generated []Headline, styles []string, variants int) []Headline {
+	out := []Headline{}
+	seen := map[string]bool{}
+	for _, style := range styles {
+		n := 0
+		for _, h := range generated {
+			text := strings.Trim(strings.TrimSpace(h.Text), `"“”`)
+			if h.Style != style || text == "" || seen[strings.ToLower(text)] || n == variants {
+				continue
+			}
+			seen[strings.ToLower(text)] = true
+			n++
+			chars := utf8.RuneCountInString(text)
+			out = append(out, Headline{
+				ID:         fmt.Sprintf("v%d", len(out)+1),
+				Text:       text,
+				Style:      style,
+				Length:     headlineLength(chars),
+				Characters: chars,
+				Words:      len(strings.Fields(text)),
+			})
+		}
+	}
+	return out
+}
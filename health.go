@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Health ---
+//
+// GET /health is the liveness probe: it answers 200 as long as the server
+// runs, with the build version, the circuit breaker's state and the result
+// cache. With ?upstream=true it also lists the provider's models, a cheap
+// call that checks that the provider is reachable and serves the configured
+// model; the outcome is cached for upstreamCheckTTL, so frequent probes
+// don't turn into a load on the provider.
+
+// version is the build version, set with
+// -ldflags "-X main.version=v1.2.3"; otherwise the VCS revision, if the
+// binary was built from a checkout.
+var version = ""
+
+const (
+	upstreamCheckTTL     = 15 * time.Second
+	upstreamCheckTimeout = 5 * time.Second
+)
+
+// HealthResponse is the liveness probe's answer. It stays 200 while the
+// circuit breaker is open or the provider is unreachable: the server is
+// alive, its upstream is not.
+type HealthResponse struct {
+	Status   string          `json:"status"` // ok, or degraded if the upstream check failed or lacks the model
+	Version  string          `json:"version"`
+	Breaker  BreakerStatus   `json:"breaker"`
+	Cache    CacheStatus     `json:"cache"`
+	Upstream *UpstreamStatus `json:"upstream,omitempty"` // with ?upstream=true
+}
+
+// CacheStatus describes the result cache: the history, whose results are
+// reused for repeated inputs.
+type CacheStatus struct {
+	Entries    int  `json:"entries"`
+	Max        int  `json:"max,omitempty"`    // unbounded if 0
+	Persistent bool `json:"persistent"`       // saved to HISTORY_FILE or the database
+	Shared     bool `json:"shared,omitempty"` // with the other replicas, through Redis
+}
+
+// UpstreamStatus is the outcome of an upstream check.
+type UpstreamStatus struct {
+	Provider  string `json:"provider"`
+	Reachable bool   `json:"reachable"`
+	LatencyMS int64  `json:"latency_ms"`
+	Model     string `json:"model,omitempty"` // the default model, set at runtime or the provider's
+	// ModelAvailable is whether the provider lists the model; absent if it
+	// can't tell (Azure lists models, not deployments).
+	ModelAvailable *bool     `json:"model_available,omitempty"`
+	Models         int       `json:"models"` // listed by the provider
+	Error          string    `json:"error,omitempty"`
+	CheckedAt      time.Time `json:"checked_at"`
+	Cached         bool      `json:"cached"` // the outcome of an earlier check
+}
+
+// UpstreamCheck checks the provider, at most once per upstreamCheckTTL.
+type UpstreamCheck struct {
+	provider texttools.Provider
+	name     string
+	model    string
+
+	mu   sync.Mutex
+	last *UpstreamStatus
+}
+
+func NewUpstreamCheck(provider texttools.Provider) *UpstreamCheck {
+	c := &UpstreamCheck{provider: provider}
+	c.name, c.model = describeProvider(provider)
+	return c
+}
+
+// Status returns the outcome of a fresh check, or of the last one if it is
+// recent enough. Concurrent callers wait for the same check.
+func (c *UpstreamCheck) Status(ctx context.Context) UpstreamStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last != nil && time.Since(c.last.CheckedAt) < upstreamCheckTTL {
+		s := *c.last
+		s.Cached = true
+		return s
+	}
+	s := c.check(ctx)
+	c.last = &s
+	return s
+}
+
+func (c *UpstreamCheck) check(ctx context.Context) UpstreamStatus {
+	s := UpstreamStatus{Provider: c.name, Model: c.model, CheckedAt: time.Now().UTC()}
+	if m := runtimeConfig.Model(); m != "" {
+		s.Model = m
+	}
+	lister, ok := c.provider.(texttools.ModelLister)
+	if !ok {
+		s.Error = "the provider can't be checked"
+		return s
+	}
+	// the outcome is shared: a probe that goes away mustn't cancel it
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), upstreamCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	models, err := lister.ListModels(ctx)
+	s.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		s.Error = truncate(err.Error(), 300)
+		// an answer, even an error one, means the provider is reachable
+		var se *texttools.StatusError
+		s.Reachable = errors.As(err, &se) && !texttools.Unavailable(err)
+		return s
+	}
+	s.Reachable, s.Models = true, len(models)
+	if o, ok := c.provider.(*texttools.OpenAI); !ok || o.Azure == nil {
+		available := slices.Contains(models, s.Model)
+		s.ModelAvailable = &available
+	}
+	return s
+}
+
+// buildVersion returns version, or the VCS revision the binary was built
+// from, or "dev".
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		var rev, modified string
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				rev = s.Value
+			case "vcs.modified":
+				modified = s.Value
+			}
+		}
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		if rev != "" && modified == "true" {
+			return rev + "-dirty"
+		}
+		if rev != "" {
+			return rev
+		}
+	}
+	return "dev"
+}
+
+func healthHandler(breaker *CircuitBreaker, upstream *UpstreamCheck, history *History) http.HandlerFunc {
+	v := buildVersion()
+	return func(w http.ResponseWriter, r *http.Request) {
+		entries, max, persistent := history.CacheStats()
+		resp := HealthResponse{
+			Status:  "ok",
+			Version: v,
+			Breaker: breaker.Status(),
+			Cache:   CacheStatus{Entries: entries, Max: max, Persistent: persistent, Shared: history.Shared != nil},
+		}
+		if r.URL.Query().Get("upstream") == "true" {
+			s := upstream.Status(r.Context())
+			resp.Upstream = &s
+			if s.Error != "" || (s.ModelAvailable != nil && !*s.ModelAvailable) {
+				resp.Status = "degraded"
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// --- Result history ---
+
+// nearDuplicateThreshold is the minimum word-shingle Jaccard similarity for
+// two inputs to count as near-identical.
+const nearDuplicateThreshold = 0.9
+
+// ResultMeta is embedded in operation responses to identify the stored
+// result.
+type ResultMeta struct {
+	ID          string `json:"id,omitempty"`
+	Reused      bool   `json:"reused,omitempty"`       // returned from history without calling the LLM
+	DuplicateOf string `json:"duplicate_of,omitempty"` // an earlier result for (nearly) the same input
+	Degraded    bool   `json:"degraded,omitempty"`     // produced locally because the LLM was unavailable
+	Fallback    string `json:"fallback,omitempty"`     // the local algorithm used when degraded
+
+	// with LLM_FALLBACK set: the model that served the result, and whether
+	// it was a fallback model rather than the primary
+	Model         string `json:"model,omitempty"`
+	ModelFallback bool   `json:"model_fallback,omitempty"`
+}
+
+type HistoryEntry struct {
+	ID        string          `json:"id"`
+	User      string          `json:"user"`
+	Operation string          `json:"operation"`
+	Key       string          `json:"key"` // operation parameters, for dedup
+	Input     string          `json:"input"`
+	Result    json.RawMessage `json:"result"`
+	CreatedAt time.Time       `json:"created_at"`
+
+	Provenance *Provenance `json:"provenance,omitempty"` // how the result was generated
+	Labels                 // folder and tags (see labels.go)
+
+	fingerprint string
+	shingles    map[string]bool
+}
+
+// History keeps the most recent operation results in a HistoryBackend.
+type History struct {
+	store HistoryBackend
+	max   int
+
+	// Provenance stamps new entries; nil records none.
+	Provenance *ProvenanceSource
+
+	// OnRecord, if set, is called with every new entry.
+	OnRecord func(*HistoryEntry)
+
+	// Shared, if set, shares the results of identical inputs with other
+	// replicas.
+	Shared ResultCache
+}
+
+// ResultCache keeps results by operation, parameters and the fingerprint
+// of the input (see redis.go).
+type ResultCache interface {
+	Get(op, key, fingerprint string) (*HistoryEntry, error) // nil if there is none
+	Put(e *HistoryEntry) error
+	Delete(e *HistoryEntry) error
+}
+
+// NewHistory keeps up to max results (all if 0) in store.
+func NewHistory(store HistoryBackend, max int) *History {
+	return &History{store: store, max: max}
+}
+
+func (e *HistoryEntry) index() {
+	e.fingerprint = fingerprint(e.Input)
+	e.shingles = shingles(e.Input)
+}
+
+// historyKey canonicalizes the parameters that affect a result. Reuse itself
+// doesn't change the output, so it is left out.
+func historyKey(extra string, opts Options) string {
+	opts.Reuse, opts.SessionID = false, ""
+	b, _ := json.Marshal(opts)
+	return extra + "|" + string(b)
+}
+
+// FindDuplicate returns the most recent result of the same operation and
+// parameters whose input is identical after normalization (any user) or
+// near-identical (same user).
+func (h *History) FindDuplicate(user, op, key, input string) (*HistoryEntry, bool) {
+	fp := fingerprint(input)
+	if h.Shared != nil {
+		e, err := h.Shared.Get(op, key, fp)
+		if err != nil {
+			log.Println("result cache error:", err)
+		}
+		if e != nil {
+			return e, true
+		}
+	}
+	candidates, err := h.store.DuplicateCandidates(user, op, key, fp)
+	if err != nil {
+		log.Println("history error:", err)
+		return nil, false
+	}
+	var sh map[string]bool
+	for _, e := range candidates {
+		if e.fingerprint == fp {
+			return e, true
+		}
+		if e.User != user {
+			continue
+		}
+		if sh == nil {
+			sh = shingles(input)
+		}
+		if jaccard(sh, e.shingles) >= nearDuplicateThreshold {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// Reusable returns the stored response for a duplicate input, marked as
+// reused, when the request opted in with "reuse": true.
+func (h *History) Reusable(r *http.Request, op, key, input string, opts Options) (map[string]interface{}, bool) {
+	// in a session, the result depends on the conversation so far
+	if !opts.Reuse || debugEcho(r.Context()) || dryRun(r.Context()) || privacyMode(r.Context()) || sessionFrom(r.Context()) != "" {
+		return nil, false
+	}
+	e, ok := h.FindDuplicate(userID(r), op, key, input)
+	if !ok {
+		return nil, false
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(e.Result, &resp); err != nil {
+		return nil, false
+	}
+	resp["id"] = e.ID
+	resp["reused"] = true
+	setETag(r, e.ETag())
+	return resp, true
+}
+
+// CacheStats returns the number of stored results, the most kept (0 for
+// no limit) and whether they are persisted.
+func (h *History) CacheStats() (entries, max int, persistent bool) {
+	n, err := h.store.CountEntries()
+	if err != nil {
+		log.Println("history error:", err)
+	}
+	return n, h.max, h.store.Persistent()
+}
+
+// Record stores a new result and returns the metadata to embed in the
+// response. Debug echoes, dry runs and private requests aren't stored.
+func (h *History) Record(r *http.Request, op, key, input string, opts Options, result interface{}) ResultMeta {
+	if debugEcho(r.Context()) || dryRun(r.Context()) || privacyMode(r.Context()) {
+		return ResultMeta{}
+	}
+	user := userID(r)
+	var meta ResultMeta
+	if prior, ok := h.FindDuplicate(user, op, key, input); ok {
+		meta.DuplicateOf = prior.ID
+	}
+	provider, model, fallback, served := servedModel(r.Context())
+	if served {
+		meta.Model, meta.ModelFallback = model, fallback
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		log.Println("history error:", err)
+		return meta
+	}
+	e := &HistoryEntry{ID: newID(), User: user, Operation: op, Key: key, Input: input, Result: b, CreatedAt: time.Now()}
+	e.Provenance = h.Provenance.For(r.Context(), op, opts)
+	if served && e.Provenance != nil {
+		e.Provenance.Provider, e.Provenance.Model = provider, model
+	}
+	e.index()
+	meta.ID = e.ID
+	if opts.Reuse && sessionFrom(r.Context()) == "" {
+		setETag(r, e.ETag())
+	}
+
+	if err := h.store.AddEntry(e, h.max); err != nil {
+		log.Println("history error:", err)
+		return meta
+	}
+	if h.Shared != nil {
+		if err := h.Shared.Put(e); err != nil {
+			log.Println("result cache error:", err)
+		}
+	}
+	if h.OnRecord != nil {
+		h.OnRecord(e)
+	}
+	return meta
+}
+
+func (h *History) Get(id string) (*HistoryEntry, bool) {
+	e, err := h.store.Entry(id)
+	if err != nil {
+		log.Println("history error:", err)
+	}
+	return e, e != nil
+}
+
+// List returns the user's entries that match f, newest first.
+func (h *History) List(user string, f LabelFilter) []*HistoryEntry {
+	out, err := h.store.Entries(user, f)
+	if err != nil {
+		log.Println("history error:", err)
+	}
+	if out == nil {
+		out = []*HistoryEntry{}
+	}
+	return out
+}
+
+func (h *History) Delete(user, id string) (bool, error) {
+	e, _ := h.Get(id)
+	ok, err := h.store.DeleteEntry(user, id)
+	if ok && e != nil && h.Shared != nil {
+		if err := h.Shared.Delete(e); err != nil {
+			log.Println("result cache error:", err)
+		}
+	}
+	return ok, err
+}
+
+// SetLabels files the user's entry id with l, reporting whether it exists.
+func (h *History) SetLabels(user, id string, l Labels) (bool, error) {
+	return h.store.SetEntryLabels(user, id, l)
+}
+
+// memoryHistory is the default HistoryBackend: entries in memory, saved to
+// a JSON file if it has one.
+type memoryHistory struct {
+	file string
+
+	mu      sync.RWMutex
+	entries []*HistoryEntry // oldest first
+}
+
+func newMemoryHistory(file string) (*memoryHistory, error) {
+	m := &memoryHistory{file: file}
+	if file == "" {
+		return m, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m.entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for _, e := range m.entries {
+		e.index()
+	}
+	return m, nil
+}
+
+func (m *memoryHistory) AddEntry(e *HistoryEntry, max int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, e)
+	if max > 0 && len(m.entries) > max {
+		m.entries = append([]*HistoryEntry(nil), m.entries[len(m.entries)-max:]...)
+	}
+	return m.saveLocked()
+}
+
+func (m *memoryHistory) Entry(id string) (*HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, e := range m.entries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *memoryHistory) Entries(user string, f LabelFilter) ([]*HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []*HistoryEntry
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		if m.entries[i].User == user && f.match(m.entries[i].Labels) {
+			out = append(out, m.entries[i])
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryHistory) DuplicateCandidates(user, op, key, fp string) ([]*HistoryEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []*HistoryEntry
+	for i := len(m.entries) - 1; i >= 0; i-- {
+		e := m.entries[i]
+		if e.Operation == op && e.Key == key && (e.fingerprint == fp || e.User == user) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryHistory) DeleteEntry(user, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.entries {
+		if e.ID == id && e.User == user {
+			m.entries = append(m.entries[:i:i], m.entries[i+1:]...)
+			return true, m.saveLocked()
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryHistory) SetEntryLabels(user, id string, l Labels) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.entries {
+		if e.ID == id && e.User == user {
+			// a copy: readers hold on to the entry and encode it unlocked
+			c := *e
+			c.Labels = l
+			m.entries[i] = &c
+			return true, m.saveLocked()
+		}
+	}
+	return false, nil
+}
+
+func (m *memoryHistory) CountEntries() (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.entries), nil
+}
+
+func (m *memoryHistory) Persistent() bool { return m.file != "" }
+
+func (m *memoryHistory) saveLocked() error {
+	if m.file == "" {
+		return nil
+	}
+	return writeJSONFile(m.file, m.entries)
+}
+
+// fingerprint hashes the input after lowercasing it and dropping
+// punctuation and whitespace differences.
+func fingerprint(s string) string {
+	sum := sha256.Sum256([]byte(strings.Join(normalizedWords(s), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+func normalizedWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// shingles returns the set of 3-word sequences in s.
+func shingles(s string) map[string]bool {
+	words := normalizedWords(s)
+	set := map[string]bool{}
+	if len(words) < 3 {
+		set[strings.Join(words, " ")] = true
+		return set
+	}
+	for i := 0; i+3 <= len(words); i++ {
+		set[strings.Join(words[i:i+3], " ")] = true
+	}
+	return set
+}
+
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	inter := 0
+	for k := range a {
+		if b[k] {
+			inter++
+		}
+	}
+	return float64(inter) / float64(len(a)+len(b)-inter)
+}
+
+// --- History handlers ---
+
+// historyHandler serves GET /history (the caller's results, by ?folder=
+// and ?tag=), GET /history/<id>, GET /history/<id>/provenance, PATCH
+// /history/<id> (its folder and tags) and DELETE /history/<id>.
+func historyHandler(history *History, signer *ProvenanceSigner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/history"), "/")
+
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(id, "/provenance"):
+			provenanceHandler(history, signer, strings.TrimSuffix(id, "/provenance"))(w, r)
+		case r.Method == http.MethodGet && id == "":
+			f, err := labelFilter(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"entries": history.List(userID(r), f)})
+		case r.Method == http.MethodGet:
+			e, ok := history.Get(id)
+			if !ok || e.User != userID(r) {
+				http.Error(w, "result not found", http.StatusNotFound)
+				return
+			}
+			setETag(r, e.ETag())
+			writeJSON(w, http.StatusOK, e)
+		case r.Method == http.MethodPatch && id != "":
+			var u LabelsUpdate
+			if !decodeJSON(w, r, &u) {
+				return
+			}
+			e, ok := history.Get(id)
+			if !ok || e.User != userID(r) {
+				http.Error(w, "result not found", http.StatusNotFound)
+				return
+			}
+			l, err := u.apply(e.Labels)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if ok, err = history.SetLabels(e.User, id, l); err != nil {
+				log.Println("history error:", err)
+				http.Error(w, "failed to save history", http.StatusInternalServerError)
+				return
+			}
+			if ok {
+				e, ok = history.Get(id) // with the new labels
+			}
+			if !ok {
+				http.Error(w, "result not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, e)
+		case r.Method == http.MethodDelete && id != "":
+			ok, err := history.Delete(userID(r), id)
+			if err != nil {
+				log.Println("history error:", err)
+				http.Error(w, "failed to save history", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "result not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
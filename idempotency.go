@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// --- Idempotency keys ---
+//
+// A POST with an Idempotency-Key header is run once: a retry with the same
+// key (from the same caller, to the same path) within IDEMPOTENCY_TTL gets
+// the stored response replayed, with an Idempotent-Replayed: true header,
+// instead of calling the model again. A retry that arrives while the first
+// request is still running waits for it. The first request runs to the end
+// even if its client goes away, so that the retry after a network blip can
+// be answered. Server errors, 429s and 304s are not stored, so those can be
+// retried; a key reused for a different request (body, query string or
+// If-None-Match) gets a 422. The store is bounded: past its caps, in all or
+// for one caller, the oldest responses are forgotten early.
+
+const (
+	maxIdempotencyKey = 255
+
+	// The stored responses are capped in number and size, in all and for
+	// each caller; the oldest ones make room for new ones.
+	maxIdempotencyEntries       = 10000
+	maxIdempotencyBytes         = 64 << 20
+	maxIdempotencyCallerEntries = 1000
+	maxIdempotencyCallerBytes   = 8 << 20
+)
+
+// replayedHeaders are the response headers that are not replayed: they
+// belong to the retry.
+var replayedHeaders = map[string]bool{
+	"X-Request-Id":          true,
+	"X-Ratelimit-Limit":     true,
+	"X-Ratelimit-Remaining": true,
+	"Date":                  true,
+}
+
+// IdempotencyStore keeps the responses of requests with idempotency keys
+// for ttl.
+type IdempotencyStore struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*idempotentResponse
+	stored  *list.List // of the stored entries, oldest first
+	bytes   int
+	callers map[string]*idempotencyCaller
+}
+
+// idempotencyCaller is what a caller has stored.
+type idempotencyCaller struct {
+	stored *list.List // oldest first
+	bytes  int
+}
+
+// idempotentResponse is a stored response, or a pending one until ready
+// is closed.
+type idempotentResponse struct {
+	key, caller string
+	fingerprint string // of the request
+	ready       chan struct{}
+	stored      bool
+	status      int
+	header      http.Header
+	body        []byte
+	size        int
+	expires     time.Time
+
+	elem, callerElem *list.Element
+}
+
+func NewIdempotencyStore(ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{ttl: ttl, entries: map[string]*idempotentResponse{}, stored: list.New(), callers: map[string]*idempotencyCaller{}}
+}
+
+// begin returns the entry of key of caller, and whether it is new: the
+// caller then runs the request and must call finish.
+func (s *IdempotencyStore) begin(caller, key, fingerprint string) (*idempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key = caller + "|" + key
+	if e, ok := s.entries[key]; ok {
+		if !e.stored || time.Now().Before(e.expires) {
+			return e, false
+		}
+		s.removeLocked(e)
+	}
+	e := &idempotentResponse{key: key, caller: caller, fingerprint: fingerprint, ready: make(chan struct{})}
+	s.entries[key] = e
+	return e, true
+}
+
+// finish stores the response of a new entry, or drops the entry if the
+// response isn't worth replaying or too large, and wakes the waiting
+// retries.
+func (s *IdempotencyStore) finish(e *idempotentResponse, status int, header http.Header, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer close(e.ready)
+	size := len(e.key) + len(body)
+	for k, v := range header {
+		for _, v := range v {
+			size += len(k) + len(v)
+		}
+	}
+	if status == 0 || status >= 500 || status == http.StatusTooManyRequests || status == http.StatusNotModified || size > maxIdempotencyCallerBytes {
+		delete(s.entries, e.key)
+		return
+	}
+	e.stored, e.status, e.header, e.body, e.size = true, status, header, body, size
+	e.expires = time.Now().Add(s.ttl)
+
+	c := s.callers[e.caller]
+	if c == nil {
+		c = &idempotencyCaller{stored: list.New()}
+		s.callers[e.caller] = c
+	}
+	for c.stored.Len() >= maxIdempotencyCallerEntries || c.bytes+size > maxIdempotencyCallerBytes {
+		s.removeLocked(c.stored.Front().Value.(*idempotentResponse))
+	}
+	for s.stored.Len() >= maxIdempotencyEntries || s.bytes+size > maxIdempotencyBytes {
+		s.removeLocked(s.stored.Front().Value.(*idempotentResponse))
+	}
+	e.elem, e.callerElem = s.stored.PushBack(e), c.stored.PushBack(e)
+	s.bytes += size
+	c.bytes += size
+	// removeLocked may have dropped c with the last of its entries
+	s.callers[e.caller] = c
+}
+
+// removeLocked drops the stored entry e.
+func (s *IdempotencyStore) removeLocked(e *idempotentResponse) {
+	if s.entries[e.key] == e {
+		delete(s.entries, e.key)
+	}
+	s.stored.Remove(e.elem)
+	s.bytes -= e.size
+	c := s.callers[e.caller]
+	c.stored.Remove(e.callerElem)
+	if c.bytes -= e.size; c.stored.Len() == 0 {
+		delete(s.callers, e.caller)
+	}
+}
+
+// Prune drops the expired responses every interval.
+func (s *IdempotencyStore) Prune(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.prune(time.Now())
+	}
+}
+
+// prune drops the responses expired at now: the oldest ones, as they all
+// live for ttl.
+func (s *IdempotencyStore) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.stored.Len() > 0 {
+		e := s.stored.Front().Value.(*idempotentResponse)
+		if now.Before(e.expires) {
+			return
+		}
+		s.removeLocked(e)
+	}
+}
+
+// withIdempotency runs POST requests with an Idempotency-Key at most once
+// per key.
+func withIdempotency(store *IdempotencyStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		// private responses aren't kept, so there is nothing to replay; nor
+		// are dry runs, which mustn't be replayed for the real request
+		if r.Method != http.MethodPost || key == "" || privacyMode(r.Context()) || dryRunRequested(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency-Key must be at most 255 characters", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
+		if err != nil {
+			writeInputError(w, errTooLarge("request body too large (max %d bytes)", int64(maxUploadSize)))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+		// keys are the caller's: the same key from another caller is another key
+		caller := hashTenantKey(apiKey(r)) + "|" + userID(r)
+
+		for {
+			e, fresh := store.begin(caller, r.URL.Path+"|"+key, fingerprint)
+			if e.fingerprint != fingerprint {
+				http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+				return
+			}
+			if fresh {
+				iw := &idempotencyWriter{ResponseWriter: w}
+				defer func() {
+					store.finish(e, iw.status, iw.header, iw.body.Bytes())
+				}()
+				next.ServeHTTP(iw, r.WithContext(context.WithoutCancel(r.Context())))
+				return
+			}
+			select {
+			case <-e.ready:
+			case <-r.Context().Done():
+				return
+			}
+			if !e.stored {
+				continue // the first attempt failed: run this one
+			}
+			for k, v := range e.header {
+				if !replayedHeaders[k] {
+					w.Header()[k] = v
+				}
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			log.Printf("%s %s: replaying the response to Idempotency-Key %q", r.Method, r.URL.Path, key)
+			w.WriteHeader(e.status)
+			_, _ = w.Write(e.body)
+			return
+		}
+	})
+}
+
+// requestFingerprint identifies what a request with an idempotency key
+// asks for: its query string (e.g. include_meta), If-None-Match and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.URL.RawQuery+"\x00"+r.Header.Get("If-None-Match")+"\x00")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyWriter records the response it passes through.
+type idempotencyWriter struct {
+	http.ResponseWriter
+	status      int
+	header      http.Header
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *idempotencyWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.header, w.wroteHeader = status, w.Header().Clone(), true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *idempotencyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
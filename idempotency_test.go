@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// storeResponse stores a response of size bytes to key of caller.
+func storeResponse(t *testing.T, s *IdempotencyStore, caller, key string, size int) {
+	t.Helper()
+	e, fresh := s.begin(caller, key, "f")
+	if !fresh {
+		t.Fatalf("%s %s: not fresh", caller, key)
+	}
+	s.finish(e, http.StatusOK, nil, make([]byte, size))
+}
+
+func stored(s *IdempotencyStore, caller, key string) bool {
+	e, ok := s.entries[caller+"|"+key]
+	return ok && e.stored
+}
+
+func TestIdempotencyStoreCaps(t *testing.T) {
+	s := NewIdempotencyStore(time.Hour)
+
+	// a caller's entries past its cap push out its own oldest ones
+	for i := 0; i < maxIdempotencyCallerEntries+10; i++ {
+		storeResponse(t, s, "a", fmt.Sprint(i), 10)
+	}
+	if stored(s, "a", "9") || !stored(s, "a", "10") || len(s.entries) != maxIdempotencyCallerEntries {
+		t.Errorf("after %d entries of a caller: %d kept", maxIdempotencyCallerEntries+10, len(s.entries))
+	}
+
+	// and so do its bytes
+	storeResponse(t, s, "b", "small", 10)
+	for i := 0; i < 3; i++ {
+		storeResponse(t, s, "b", fmt.Sprint("large", i), maxIdempotencyCallerBytes/3)
+	}
+	if stored(s, "b", "small") || stored(s, "b", "large0") || !stored(s, "b", "large2") {
+		t.Error("a caller's bytes past its cap didn't push out its oldest responses")
+	}
+	if !stored(s, "a", "10") {
+		t.Error("a caller's bytes past its cap pushed out another caller's responses")
+	}
+
+	// a response larger than a caller may store isn't stored
+	storeResponse(t, s, "c", "huge", maxIdempotencyCallerBytes+1)
+	if _, ok := s.entries["c|huge"]; ok {
+		t.Error("stored a response over the cap of a caller")
+	}
+
+	// many callers push out the oldest responses of all
+	for i := 0; i < maxIdempotencyEntries; i++ {
+		storeResponse(t, s, fmt.Sprint("caller", i), "k", 10)
+	}
+	if len(s.entries) != maxIdempotencyEntries || stored(s, "b", "large2") {
+		t.Errorf("%d entries kept, want %d", len(s.entries), maxIdempotencyEntries)
+	}
+	if total := s.bytes; total > maxIdempotencyBytes {
+		t.Errorf("%d bytes kept", total)
+	}
+}
+
+func TestIdempotencyStorePrune(t *testing.T) {
+	s := NewIdempotencyStore(time.Minute)
+	storeResponse(t, s, "a", "old", 10)
+	s.entries["a|old"].expires = time.Now().Add(-time.Second)
+	storeResponse(t, s, "a", "new", 10)
+
+	s.prune(time.Now())
+	if _, ok := s.entries["a|old"]; ok || !stored(s, "a", "new") {
+		t.Error("prune didn't drop just the expired response")
+	}
+	if s.bytes != s.entries["a|new"].size || s.callers["a"].stored.Len() != 1 {
+		t.Errorf("after prune: %d bytes, %d entries of the caller", s.bytes, s.callers["a"].stored.Len())
+	}
+	s.prune(time.Now().Add(time.Hour))
+	if len(s.entries) != 0 || s.stored.Len() != 0 || len(s.callers) != 0 || s.bytes != 0 {
+		t.Errorf("after everything expired: %d entries, %d bytes", len(s.entries), s.bytes)
+	}
+}
+
+func TestWithIdempotency(t *testing.T) {
+	calls := 0
+	h := withIdempotency(NewIdempotencyStore(time.Hour), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s #%d", b, calls)
+	}))
+	do := func(user, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/summarize", strings.NewReader(body))
+		r.Header.Set("Idempotency-Key", "k1")
+		r.Header.Set("X-User-ID", user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := do("alice", "text"); w.Body.String() != "text #1" {
+		t.Fatalf("first request: %q", w.Body)
+	}
+	if w := do("alice", "text"); w.Body.String() != "text #1" || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry: %q, replayed %q", w.Body, w.Header().Get("Idempotent-Replayed"))
+	}
+	if w := do("alice", "other text"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another request: %d", w.Code)
+	}
+	if w := do("bob", "text"); w.Body.String() != "text #2" {
+		t.Errorf("the same key from another caller: %q", w.Body)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Inbound email ---
+//
+// POST /integrations/email receives emails from SendGrid's Inbound Parse or
+// a Mailgun route: forward an email to the parse address and the summary
+// comes back as a reply, sent through SMTP. Long emails are summarized in
+// parts, like books.
+//
+// Requests must carry INBOUND_EMAIL_TOKEN as ?token= (put it in the webhook
+// URL), or, from Mailgun, a signature made with MAILGUN_SIGNING_KEY.
+// INBOUND_EMAIL_ALLOWED restricts who may send (addresses, or @domain); no
+// replies go to automatic mail or to EMAIL_FROM itself, so two robots can't
+// get into a loop.
+
+const (
+	// inboundTimeout bounds the summary and the reply of an email.
+	inboundTimeout = 5 * time.Minute
+	// mailgunMaxSkew is how old a Mailgun signature may be, against replays.
+	mailgunMaxSkew = 5 * time.Minute
+)
+
+// InboundEmail is an email received by the webhook.
+type InboundEmail struct {
+	From      string // the sender's address
+	Subject   string
+	Text      string
+	MessageID string
+	Auto      bool // sent automatically: a bounce, an auto-reply, ...
+}
+
+// InboundAccepted is the webhook's answer: the email is being summarized.
+type InboundAccepted struct {
+	Status  string `json:"status"` // accepted or ignored
+	ReplyTo string `json:"reply_to,omitempty"`
+	Reason  string `json:"reason,omitempty"` // why it was ignored
+}
+
+// InboundConfig is the configuration of the webhook.
+type InboundConfig struct {
+	Token      string   // INBOUND_EMAIL_TOKEN
+	MailgunKey string   // MAILGUN_SIGNING_KEY
+	Allowed    []string // addresses and @domains; anyone if empty
+	SMTP       SMTPConfig
+}
+
+// SMTPConfig is the server replies are sent through.
+type SMTPConfig struct {
+	Addr     string // host:port
+	Username string // no auth if empty
+	Password string
+	From     string // an address, or "Name <address>"
+}
+
+// address is the address of From.
+func (c SMTPConfig) address() string {
+	if a, err := mail.ParseAddress(c.From); err == nil {
+		return a.Address
+	}
+	return c.From
+}
+
+func InboundConfigFromEnv() InboundConfig {
+	c := InboundConfig{
+		Token:      os.Getenv("INBOUND_EMAIL_TOKEN"),
+		MailgunKey: os.Getenv("MAILGUN_SIGNING_KEY"),
+		SMTP: SMTPConfig{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("EMAIL_FROM"),
+		},
+	}
+	for _, a := range strings.Split(os.Getenv("INBOUND_EMAIL_ALLOWED"), ",") {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			c.Allowed = append(c.Allowed, a)
+		}
+	}
+	return c
+}
+
+func (c InboundConfig) enabled() bool {
+	return (c.Token != "" || c.MailgunKey != "") && c.SMTP.Addr != "" && c.SMTP.From != ""
+}
+
+// authenticate checks the token or the Mailgun signature of a request.
+func (c InboundConfig) authenticate(r *http.Request, now time.Time) error {
+	if sig := r.FormValue("signature"); sig != "" && c.MailgunKey != "" {
+		ts, err := strconv.ParseInt(r.FormValue("timestamp"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid timestamp")
+		}
+		if skew := now.Sub(time.Unix(ts, 0)); skew > mailgunMaxSkew || skew < -mailgunMaxSkew {
+			return fmt.Errorf("request timestamp too far from now")
+		}
+		mac := hmac.New(sha256.New, []byte(c.MailgunKey))
+		mac.Write([]byte(r.FormValue("timestamp") + r.FormValue("token")))
+		if !hmac.Equal([]byte(sig), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	if c.Token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(c.Token)) == 1 {
+		return nil
+	}
+	return fmt.Errorf("missing or invalid token")
+}
+
+// allows returns whether addr may use the webhook.
+func (c InboundConfig) allows(addr string) bool {
+	if len(c.Allowed) == 0 {
+		return true
+	}
+	addr = strings.ToLower(addr)
+	for _, a := range c.Allowed {
+		if addr == a || (strings.HasPrefix(a, "@") && strings.HasSuffix(addr, a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseInboundEmail reads the email of a parsed SendGrid or Mailgun form.
+func parseInboundEmail(r *http.Request) (InboundEmail, error) {
+	var e InboundEmail
+	var headers textproto.MIMEHeader
+	if raw := r.FormValue("headers"); raw != "" {
+		// SendGrid: the raw header block
+		tp := textproto.NewReader(bufio.NewReader(strings.NewReader(raw + "\r\n\r\n")))
+		headers, _ = tp.ReadMIMEHeader()
+	}
+	from := firstNonEmpty(r.FormValue("sender"), r.FormValue("from"), headers.Get("Reply-To"), headers.Get("From"))
+	if reply := firstNonEmpty(r.FormValue("Reply-To"), headers.Get("Reply-To")); reply != "" {
+		from = reply
+	}
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return e, fmt.Errorf("invalid sender %q", from)
+	}
+	e.From = addr.Address
+	e.Subject = firstNonEmpty(r.FormValue("subject"), headers.Get("Subject"))
+	e.MessageID = firstNonEmpty(r.FormValue("Message-Id"), headers.Get("Message-Id"))
+	// the whole body, not Mailgun's stripped-text: that drops the forwarded part
+	e.Text = firstNonEmpty(r.FormValue("body-plain"), r.FormValue("text"))
+	if strings.TrimSpace(e.Text) == "" {
+		html := firstNonEmpty(r.FormValue("body-html"), r.FormValue("html"))
+		e.Text, _, _ = texttools.HTMLText([]byte(html))
+	}
+	e.Text = strings.ReplaceAll(e.Text, "\r\n", "\n")
+
+	auto := firstNonEmpty(r.FormValue("Auto-Submitted"), headers.Get("Auto-Submitted"))
+	local := strings.ToLower(strings.SplitN(e.From, "@", 2)[0])
+	e.Auto = (auto != "" && auto != "no") || local == "mailer-daemon" || local == "postmaster" ||
+		strings.HasPrefix(local, "noreply") || strings.HasPrefix(local, "no-reply")
+	return e, nil
+}
+
+// inboundEmailHandler serves POST /integrations/email.
+func inboundEmailHandler(tools *texttools.Tools, cfg InboundConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.enabled() {
+			http.Error(w, "email ingestion is not configured (INBOUND_EMAIL_TOKEN or MAILGUN_SIGNING_KEY, SMTP_ADDR, EMAIL_FROM)", http.StatusNotFound)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+		if err := r.ParseMultipartForm(maxBodyBytes); err != nil && err != http.ErrNotMultipart {
+			http.Error(w, "invalid form body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := cfg.authenticate(r, time.Now()); err != nil {
+			log.Println("inbound email error:", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		e, err := parseInboundEmail(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// 200 even when ignoring, or the provider retries
+		ignore := func(reason string) {
+			log.Printf("inbound email from %s ignored: %s", e.From, reason)
+			writeJSON(w, http.StatusOK, InboundAccepted{Status: "ignored", Reason: reason})
+		}
+		switch {
+		case e.Auto || strings.EqualFold(e.From, cfg.SMTP.address()):
+			ignore("automatic email")
+			return
+		case !cfg.allows(e.From):
+			ignore("sender not allowed")
+			return
+		case strings.TrimSpace(e.Text) == "":
+			ignore("no text")
+			return
+		}
+		if err := validateText(e.Text); err != nil {
+			ignore(err.Error())
+			return
+		}
+
+		log.Printf("inbound email from %s: %q", e.From, e.Subject)
+		go replyWithSummary(tools, cfg.SMTP, e)
+		writeJSON(w, http.StatusOK, InboundAccepted{Status: "accepted", ReplyTo: e.From})
+	}
+}
+
+// replyWithSummary summarizes an email and replies to its sender.
+func replyWithSummary(tools *texttools.Tools, smtpCfg SMTPConfig, e InboundEmail) {
+	ctx, cancel := context.WithTimeout(context.Background(), inboundTimeout)
+	defer cancel()
+	summary, err := summarizeLong(ctx, tools, e.Text, texttools.Options{OutputFormat: texttools.FormatPlain})
+	body := summary
+	if err != nil {
+		log.Printf("inbound email from %s: summarize error: %v", e.From, err)
+		body = "Sorry, the email could not be summarized. Please try again later."
+	}
+	subject := "Summary: " + strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(e.Subject, "Fwd:"), "FW:"))
+	if err := sendMail(smtpCfg, e.From, subject, e.MessageID, "auto-replied", body); err != nil {
+		log.Printf("inbound email from %s: reply error: %v", e.From, err)
+	}
+}
+
+// sendMail sends a plain-text email, in reply to the message inReplyTo if
+// set. autoSubmitted is its Auto-Submitted header (RFC 3834):
+// "auto-replied" for replies, "auto-generated" for anything else.
+func sendMail(c SMTPConfig, to, subject, inReplyTo, autoSubmitted, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", c.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", newID(), mailDomain(c.address()))
+	if inReplyTo != "" {
+		fmt.Fprintf(&msg, "In-Reply-To: %s\r\nReferences: %s\r\n", inReplyTo, inReplyTo)
+	}
+	fmt.Fprintf(&msg, "Auto-Submitted: %s\r\n", autoSubmitted)
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+
+	var auth smtp.Auth
+	if c.Username != "" {
+		host, _, _ := net.SplitHostPort(c.Addr)
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+	return smtp.SendMail(c.Addr, auth, c.address(), []string{to}, msg.Bytes())
+}
+
+func mailDomain(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return addr[i+1:]
+	}
+	return "localhost"
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Async jobs ---
+//
+// Jobs run in the background on a pool of workers while clients poll GET
+// /jobs/<id>. Jobs of the types registered with Handle (sitemap,
+// book_summary) are described by their type and a JSON payload, so that
+// with a shared JobQueue (JOB_QUEUE=redis, see redisjobs.go) they can be
+// run by the workers of any replica. Other jobs (schedule runs and feed
+// refreshes) use the state of this replica and always run here.
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// jobRetention is how long finished jobs stay queryable.
+const jobRetention = 24 * time.Hour
+
+var errQueueFull = errors.New("job queue is full, try again later")
+
+type Job struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	User       string      `json:"-"`
+	Status     string      `json:"status"`
+	Total      int         `json:"total"`
+	Done       int         `json:"done"`
+	Error      string      `json:"error,omitempty"`
+	Attempts   int         `json:"attempts,omitempty"` // deliveries by a shared queue
+	CreatedAt  time.Time   `json:"created_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+
+	payload json.RawMessage // of a job of a registered type
+	run     func(p *JobProgress) (interface{}, error)
+}
+
+// JobRunner returns the run func of a job of a registered type, from its
+// payload.
+type JobRunner func(user string, payload json.RawMessage) (func(p *JobProgress) (interface{}, error), error)
+
+// JobQueue is a queue of jobs of registered types that replicas share.
+type JobQueue interface {
+	Enqueue(j *Job, capacity int) error // errQueueFull if capacity jobs are waiting
+	Get(id string) (*Job, error)        // nil if there is none
+	List(user string) ([]Job, error)    // newest first, without results
+	Stats() (JobStats, error)
+	// Work runs jobs with workers goroutines, passing each to run.
+	Work(workers int, run func(j *Job, p *JobProgress) (interface{}, error))
+}
+
+// jobTracker records the progress of running jobs.
+type jobTracker interface {
+	setTotal(id string, n int)
+	step(id string)
+}
+
+// JobProgress lets a running job report how far it got.
+type JobProgress struct {
+	tracker jobTracker
+	id      string
+}
+
+func (p *JobProgress) SetTotal(n int) {
+	p.tracker.setTotal(p.id, n)
+}
+
+func (p *JobProgress) Step() {
+	p.tracker.step(p.id)
+}
+
+// JobStore queues jobs for a fixed pool of workers and keeps their state in
+// memory; jobs of registered types go to Shared instead, if set.
+type JobStore struct {
+	queue   chan string
+	runners map[string]JobRunner
+
+	// Shared, if set, queues the jobs of registered types for the workers
+	// of all replicas.
+	Shared JobQueue
+
+	mu   sync.RWMutex
+	jobs map[string]*Job
+}
+
+func NewJobStore(workers, queueSize int) *JobStore {
+	s := &JobStore{queue: make(chan string, queueSize), runners: map[string]JobRunner{}, jobs: map[string]*Job{}}
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// Handle registers the runner of jobs of type typ.
+func (s *JobStore) Handle(typ string, runner JobRunner) {
+	s.runners[typ] = runner
+}
+
+// Enqueue queues a job of a registered type with payload, which the
+// runner receives as JSON.
+func (s *JobStore) Enqueue(typ, user string, payload interface{}) (*Job, error) {
+	runner, ok := s.runners[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown job type %q", typ)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if s.Shared == nil {
+		run, err := runner(user, b)
+		if err != nil {
+			return nil, err
+		}
+		return s.Submit(typ, user, run)
+	}
+	j := &Job{ID: newID(), Type: typ, User: user, Status: jobQueued, CreatedAt: time.Now().UTC(), payload: b}
+	if err := s.Shared.Enqueue(j, cap(s.queue)); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// runShared runs a job delivered by the shared queue.
+func (s *JobStore) runShared(j *Job, p *JobProgress) (interface{}, error) {
+	runner, ok := s.runners[j.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown job type %q", j.Type)
+	}
+	run, err := runner(j.User, j.payload)
+	if err != nil {
+		return nil, err
+	}
+	return run(p)
+}
+
+// Work starts workers goroutines taking jobs from the shared queue.
+func (s *JobStore) Work(workers int) {
+	s.Shared.Work(workers, s.runShared)
+}
+
+// Submit queues run as a new job of the given type owned by user, to run
+// in this process.
+func (s *JobStore) Submit(typ, user string, run func(p *JobProgress) (interface{}, error)) (*Job, error) {
+	j := &Job{ID: newID(), Type: typ, User: user, Status: jobQueued, CreatedAt: time.Now(), run: run}
+
+	s.mu.Lock()
+	s.pruneLocked()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- j.ID:
+	default:
+		s.mu.Lock()
+		delete(s.jobs, j.ID)
+		s.mu.Unlock()
+		return nil, errQueueFull
+	}
+	snapshot, _ := s.Get(j.ID)
+	return snapshot, nil
+}
+
+// Get returns a copy of the job.
+func (s *JobStore) Get(id string) (*Job, bool) {
+	s.mu.RLock()
+	j, ok := s.jobs[id]
+	var cp Job
+	if ok {
+		cp = *j
+	}
+	s.mu.RUnlock()
+	if ok {
+		return &cp, true
+	}
+	if s.Shared == nil {
+		return nil, false
+	}
+	shared, err := s.Shared.Get(id)
+	if err != nil {
+		log.Println("job queue error:", err)
+	}
+	return shared, shared != nil
+}
+
+// List returns copies of the user's jobs, newest first, without results.
+func (s *JobStore) List(user string) []Job {
+	s.mu.RLock()
+	out := []Job{}
+	for _, j := range s.jobs {
+		if j.User != user {
+			continue
+		}
+		cp := *j
+		cp.Result = nil
+		out = append(out, cp)
+	}
+	s.mu.RUnlock()
+
+	if s.Shared != nil {
+		shared, err := s.Shared.List(user)
+		if err != nil {
+			log.Println("job queue error:", err)
+		}
+		out = append(out, shared...)
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].CreatedAt.After(out[k].CreatedAt) })
+	return out
+}
+
+// JobStats counts jobs by status and reports the queue's fill level.
+type JobStats struct {
+	Queued        int `json:"queued"`
+	Running       int `json:"running"`
+	Done          int `json:"done"`
+	Failed        int `json:"failed"`
+	QueueDepth    int `json:"queue_depth"`
+	QueueCapacity int `json:"queue_capacity"`
+
+	Shared *JobStats `json:"shared,omitempty"` // of the shared queue, included in the counts above
+}
+
+func (s *JobStore) Stats() JobStats {
+	s.mu.RLock()
+	st := JobStats{QueueDepth: len(s.queue), QueueCapacity: cap(s.queue)}
+	for _, j := range s.jobs {
+		switch j.Status {
+		case jobQueued:
+			st.Queued++
+		case jobRunning:
+			st.Running++
+		case jobDone:
+			st.Done++
+		case jobFailed:
+			st.Failed++
+		}
+	}
+	s.mu.RUnlock()
+
+	if s.Shared != nil {
+		shared, err := s.Shared.Stats()
+		if err != nil {
+			log.Println("job queue error:", err)
+			return st
+		}
+		st.Queued += shared.Queued
+		st.Running += shared.Running
+		st.Done += shared.Done
+		st.Failed += shared.Failed
+		shared.QueueCapacity = cap(s.queue) // enforced on Enqueue
+		st.Shared = &shared
+	}
+	return st
+}
+
+func (s *JobStore) worker() {
+	for id := range s.queue {
+		s.mu.Lock()
+		j, ok := s.jobs[id]
+		if ok {
+			j.Status = jobRunning
+		}
+		s.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		result, err := j.run(&JobProgress{tracker: s, id: id})
+		now := time.Now()
+		s.update(id, func(j *Job) {
+			j.FinishedAt = &now
+			j.Result = result
+			if err != nil {
+				j.Status = jobFailed
+				j.Error = err.Error()
+				return
+			}
+			j.Status = jobDone
+		})
+	}
+}
+
+func (s *JobStore) setTotal(id string, n int) {
+	s.update(id, func(j *Job) { j.Total = n })
+}
+
+func (s *JobStore) step(id string) {
+	s.update(id, func(j *Job) { j.Done++ })
+}
+
+func (s *JobStore) update(id string, fn func(j *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.jobs[id]; ok {
+		fn(j)
+	}
+}
+
+func (s *JobStore) pruneLocked() {
+	cutoff := time.Now().Add(-jobRetention)
+	for id, j := range s.jobs {
+		if j.FinishedAt != nil && j.FinishedAt.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// newID returns a random 16-character hex identifier.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// --- Job handlers ---
+
+// jobsHandler serves POST /jobs (submit, dispatching on "type"), GET /jobs
+// (the caller's jobs) and GET /jobs/<id>.
+func jobsHandler(prefs *PreferenceStore, jobs *JobStore, books *BookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			writeJSON(w, http.StatusOK, map[string]interface{}{"jobs": jobs.List(userID(r))})
+		case r.Method == http.MethodGet:
+			j, ok := jobs.Get(id)
+			if !ok || j.User != userID(r) {
+				http.Error(w, "job not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, j)
+		case r.Method == http.MethodPost && id == "":
+			body, err := readBody(w, r, maxBodyBytes)
+			if err != nil {
+				writeInputError(w, err)
+				return
+			}
+			var head struct {
+				Type string `json:"type"`
+			}
+			if err := unmarshalJSON(body, &head); err != nil {
+				writeInputError(w, err)
+				return
+			}
+
+			var payload interface{}
+			switch head.Type {
+			case "sitemap":
+				payload, err = parseSitemapJob(prefs.For(r), body)
+			case "book_summary":
+				payload, err = parseBookSummaryJob(prefs.For(r), books, userID(r), body)
+			default:
+				http.Error(w, "unknown job type", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			j, err := jobs.Enqueue(head.Type, userID(r), payload)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, j)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
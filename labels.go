@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- Folders and tags ---
+//
+// Stored documents and history results can be filed in a folder
+// ("billing/refunds", nested with "/") and carry tags, set on creation of a
+// document or later with PATCH /documents/<id> and PATCH /history/<id>.
+// GET /documents and GET /history take ?folder= (the folder and its
+// subfolders) and ?tag= (repeatable; all must match), and GET /labels
+// lists the caller's folders and tags with how much is filed under each.
+
+const (
+	maxTags          = 20
+	maxTagChars      = 40
+	maxFolderDepth   = 8
+	maxFolderSegment = 64 // characters
+)
+
+// Labels organize a stored document or result.
+type Labels struct {
+	Folder string   `json:"folder,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+// LabelsUpdate is the PATCH body: a field left out is kept; "" clears the
+// folder and [] the tags.
+type LabelsUpdate struct {
+	Folder *string   `json:"folder"`
+	Tags   *[]string `json:"tags"`
+}
+
+// normalizeFolder trims a folder path and its segments, and checks them.
+func normalizeFolder(folder string) (string, error) {
+	folder = strings.Trim(strings.TrimSpace(folder), "/")
+	if folder == "" {
+		return "", nil
+	}
+	segments := strings.Split(folder, "/")
+	if len(segments) > maxFolderDepth {
+		return "", fmt.Errorf("folders nest at most %d deep", maxFolderDepth)
+	}
+	for i, s := range segments {
+		s = strings.TrimSpace(s)
+		if s == "" || utf8.RuneCountInString(s) > maxFolderSegment {
+			return "", fmt.Errorf("folder names must have 1 to %d characters", maxFolderSegment)
+		}
+		segments[i] = s
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// normalizeTags lowercases and trims tags, drops duplicates and sorts them.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || utf8.RuneCountInString(t) > maxTagChars || strings.Contains(t, ",") {
+			return nil, fmt.Errorf("tags must have 1 to %d characters and no commas", maxTagChars)
+		}
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("at most %d tags", maxTags)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// normalize checks and canonicalizes l.
+func (l *Labels) normalize() error {
+	var err error
+	if l.Folder, err = normalizeFolder(l.Folder); err != nil {
+		return err
+	}
+	if l.Tags, err = normalizeTags(l.Tags); err != nil {
+		return err
+	}
+	if len(l.Tags) == 0 {
+		l.Tags = nil
+	}
+	return nil
+}
+
+// apply returns l with the update u.
+func (u LabelsUpdate) apply(l Labels) (Labels, error) {
+	if u.Folder == nil && u.Tags == nil {
+		return l, errors.New("`folder` or `tags` is required")
+	}
+	if u.Folder != nil {
+		l.Folder = *u.Folder
+	}
+	if u.Tags != nil {
+		l.Tags = *u.Tags
+	}
+	return l, l.normalize()
+}
+
+// LabelFilter selects by folder and tags.
+type LabelFilter struct {
+	Folder string   // matches the folder and its subfolders
+	Tags   []string // all must be present
+}
+
+// labelFilter reads ?folder= and ?tag= (repeatable, or comma-separated).
+func labelFilter(r *http.Request) (LabelFilter, error) {
+	q := r.URL.Query()
+	folder, err := normalizeFolder(q.Get("folder"))
+	if err != nil {
+		return LabelFilter{}, err
+	}
+	var tags []string
+	for _, t := range q["tag"] {
+		tags = append(tags, strings.Split(t, ",")...)
+	}
+	if tags, err = normalizeTags(tags); err != nil {
+		return LabelFilter{}, err
+	}
+	return LabelFilter{Folder: folder, Tags: tags}, nil
+}
+
+func (f LabelFilter) match(l Labels) bool {
+	if f.Folder != "" && l.Folder != f.Folder && !strings.HasPrefix(l.Folder, f.Folder+"/") {
+		return false
+	}
+	for _, t := range f.Tags {
+		if !slices.Contains(l.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// LabelCount is how many documents and results are filed under a folder
+// (including its subfolders) or carry a tag.
+type LabelCount struct {
+	Folder    string `json:"folder,omitempty"`
+	Tag       string `json:"tag,omitempty"`
+	Documents int    `json:"documents"`
+	Results   int    `json:"results"`
+}
+
+type LabelsResponse struct {
+	Folders []LabelCount `json:"folders"`
+	Tags    []LabelCount `json:"tags"`
+}
+
+// labelsHandler serves GET /labels, the caller's folders and tags.
+func labelsHandler(documents *DocumentStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := userID(r)
+		folders := map[string]*LabelCount{}
+		tags := map[string]*LabelCount{}
+		count := func(l Labels, document bool) {
+			var counts []*LabelCount
+			for f := l.Folder; f != ""; f = f[:max(strings.LastIndex(f, "/"), 0)] {
+				if folders[f] == nil {
+					folders[f] = &LabelCount{Folder: f}
+				}
+				counts = append(counts, folders[f])
+			}
+			for _, t := range l.Tags {
+				if tags[t] == nil {
+					tags[t] = &LabelCount{Tag: t}
+				}
+				counts = append(counts, tags[t])
+			}
+			for _, c := range counts {
+				if document {
+					c.Documents++
+				} else {
+					c.Results++
+				}
+			}
+		}
+		for _, d := range documents.List(user, LabelFilter{}) {
+			count(d.Labels, true)
+		}
+		for _, e := range history.List(user, LabelFilter{}) {
+			count(e.Labels, false)
+		}
+
+		resp := LabelsResponse{Folders: []LabelCount{}, Tags: []LabelCount{}}
+		for _, c := range folders {
+			resp.Folders = append(resp.Folders, *c)
+		}
+		for _, c := range tags {
+			resp.Tags = append(resp.Tags, *c)
+		}
+		sort.Slice(resp.Folders, func(i, j int) bool { return resp.Folders[i].Folder < resp.Folders[j].Folder })
+		sort.Slice(resp.Tags, func(i, j int) bool { return resp.Tags[i].Tag < resp.Tags[j].Tag })
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- LLM error taxonomy ---
+//
+// A failed LLM call answers with the code and status of what went wrong,
+// instead of a generic 500 "LLM error":
+//
+//	upstream_rate_limited    429  the provider rate limits us (with its Retry-After)
+//	context_length_exceeded  413  the input is too long for the model
+//	content_filtered         422  the provider's content filter blocked the input or output
+//	content_flagged          422  our moderation flagged it (see moderation.go)
+//	invalid_input            400  the provider rejected the request otherwise
+//	timeout                  504  the provider didn't answer in time
+//	unavailable              503  turned away by the circuit breaker or admission control
+//	upstream_error           502  the provider failed (5xx, network, bad credentials)
+//	internal_error           500  anything else
+//
+// The code is the "code" of the /api/v1 error envelope; unversioned paths
+// get it in the X-Error-Code header of their plain-text error. Requests the
+// server itself refuses get the same codes (see errorCodes): a too long
+// text is context_length_exceeded, an invalid one invalid_input.
+
+// llmFailure is how a failed LLM call is answered.
+type llmFailure struct {
+	Code       string
+	Status     int
+	Message    string
+	RetryAfter time.Duration
+}
+
+// classifyLLMError returns how to answer err, the error of an LLM call.
+func classifyLLMError(err error) llmFailure {
+	var rejected *rejectedError
+	if errors.As(err, &rejected) {
+		return llmFailure{"unavailable", http.StatusServiceUnavailable, rejected.Reason, rejected.RetryAfter}
+	}
+	var flagged *flaggedError
+	if errors.As(err, &flagged) {
+		return llmFailure{Code: "content_flagged", Status: http.StatusUnprocessableEntity, Message: "content flagged by moderation"}
+	}
+	var ne net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()) {
+		return llmFailure{Code: "timeout", Status: http.StatusGatewayTimeout, Message: "the LLM provider didn't answer in time"}
+	}
+	var se *texttools.StatusError
+	if !errors.As(err, &se) {
+		if ne != nil {
+			return llmFailure{Code: "upstream_error", Status: http.StatusBadGateway, Message: "the LLM provider can't be reached"}
+		}
+		return llmFailure{Code: "internal_error", Status: http.StatusInternalServerError, Message: "LLM error"}
+	}
+
+	msg := strings.ToLower(se.Message)
+	switch {
+	case se.Code == "context_length_exceeded" || se.Status == http.StatusRequestEntityTooLarge ||
+		strings.Contains(msg, "context length") || strings.Contains(msg, "context window") || strings.Contains(msg, "too many tokens"):
+		return llmFailure{Code: "context_length_exceeded", Status: http.StatusRequestEntityTooLarge, Message: "the text is too long for the model's context window"}
+	case se.Code == "content_filter" || se.Code == "content_policy_violation" ||
+		strings.Contains(msg, "content filter") || strings.Contains(msg, "content management policy"):
+		return llmFailure{Code: "content_filtered", Status: http.StatusUnprocessableEntity, Message: "the LLM provider's content filter blocked the text or the result"}
+	case se.Status == http.StatusTooManyRequests:
+		return llmFailure{"upstream_rate_limited", http.StatusTooManyRequests, "the LLM provider is rate limiting requests", se.RetryAfter}
+	case se.Status == http.StatusBadRequest || se.Status == http.StatusUnprocessableEntity:
+		m := "the LLM provider rejected the request"
+		if se.Message != "" {
+			m += ": " + truncate(se.Message, 300)
+		}
+		return llmFailure{Code: "invalid_input", Status: http.StatusBadRequest, Message: m}
+	case se.Status == http.StatusGatewayTimeout || se.Status == http.StatusRequestTimeout:
+		return llmFailure{Code: "timeout", Status: http.StatusGatewayTimeout, Message: "the LLM provider didn't answer in time"}
+	}
+	// 401 and 403 are our credentials, not the client's
+	return llmFailure{Code: "upstream_error", Status: http.StatusBadGateway, Message: "the LLM provider failed"}
+}
+
+// writeLLMError answers a request whose LLM call failed with err.
+func writeLLMError(w http.ResponseWriter, err error) {
+	f := classifyLLMError(err)
+	if f.RetryAfter > 0 {
+		w.Header().Set("Retry-After", retryAfterSeconds(f.RetryAfter))
+	}
+	w.Header().Set("X-Error-Code", f.Code)
+	http.Error(w, f.Message, f.Status)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Local fallbacks ---
+//
+// With LOCAL_FALLBACK=true, operations that can be approximated without a
+// model (summarize, keywords) fall back to local algorithms when the
+// provider is unreachable, overloaded or out of quota. Such responses are
+// flagged "degraded" and are not stored in history. The "local_fallback"
+// flag can also be toggled at runtime (see admin.go).
+
+// degrade reports whether op should fall back to a local algorithm after
+// err, logging the switch.
+func degrade(op string, err error) bool {
+	if !features.Enabled("local_fallback") || !texttools.Unavailable(err) {
+		return false
+	}
+	log.Printf("%s: LLM unavailable, using local fallback: %v", op, err)
+	return true
+}
+
+func degradedMeta(algorithm string) ResultMeta {
+	return ResultMeta{Degraded: true, Fallback: algorithm}
+}
+
+// --- Sentence splitting ---
+
+// abbreviations that end in a period without ending the sentence.
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true, "jr": true, "st": true,
+	"vs": true, "etc": true, "e.g": true, "i.e": true, "approx": true, "no": true, "fig": true,
+	"inc": true, "ltd": true, "co": true, "corp": true, "jan": true, "feb": true, "mar": true,
+	"apr": true, "jun": true, "jul": true, "aug": true, "sep": true, "sept": true, "oct": true,
+	"nov": true, "dec": true,
+}
+
+// splitSentences splits text into sentences at ".", "!" and "?" followed by
+// whitespace, and at line breaks. Abbreviations, initials and decimal
+// numbers don't end a sentence; closing quotes and brackets stay with the
+// sentence they close.
+func splitSentences(s string) []string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		runes := []rune(line)
+		start := 0
+		for i := 0; i < len(runes); i++ {
+			if runes[i] != '.' && runes[i] != '!' && runes[i] != '?' {
+				continue
+			}
+			end := i + 1
+			for end < len(runes) && strings.ContainsRune(".!?\"')]”’", runes[end]) {
+				end++
+			}
+			if end < len(runes) && !unicode.IsSpace(runes[end]) {
+				i = end - 1
+				continue
+			}
+			if runes[i] == '.' && isAbbreviation(runes[start:i]) {
+				i = end - 1
+				continue
+			}
+			if sent := strings.TrimSpace(string(runes[start:end])); sent != "" {
+				out = append(out, sent)
+			}
+			start = end
+			i = end - 1
+		}
+		if rest := strings.TrimSpace(string(runes[start:])); rest != "" {
+			out = append(out, rest)
+		}
+	}
+	return out
+}
+
+// isAbbreviation reports whether the word before a period (the end of
+// text) is an abbreviation or a single-letter initial.
+func isAbbreviation(text []rune) bool {
+	i := len(text)
+	for i > 0 && !unicode.IsSpace(text[i-1]) && text[i-1] != '(' {
+		i--
+	}
+	word := strings.ToLower(string(text[i:]))
+	if len([]rune(word)) == 1 && unicode.IsLetter([]rune(word)[0]) {
+		return true
+	}
+	return abbreviations[word]
+}
+
+// --- Extractive summarizer (TextRank) ---
+
+// localSummary picks the most central sentences of text with TextRank and
+// returns them as Markdown bullets, in their original order.
+func localSummary(text, length string) string {
+	n := 4
+	switch length {
+	case "short":
+		n = 2
+	case "long":
+		n = 8
+	}
+
+	sentences := splitSentences(text)
+	for i := 0; i < len(sentences); i++ {
+		sentences[i] = strings.TrimLeft(sentences[i], "-*•# ")
+	}
+	if len(sentences) <= n {
+		return bullets(sentences)
+	}
+
+	words := make([]map[string]bool, len(sentences))
+	for i, s := range sentences {
+		words[i] = map[string]bool{}
+		for _, w := range normalizedWords(s) {
+			if !stopwords[w] {
+				words[i][w] = true
+			}
+		}
+	}
+	// Edge weight: shared content words, normalized by sentence lengths.
+	weight := make([][]float64, len(sentences))
+	for i := range weight {
+		weight[i] = make([]float64, len(sentences))
+		for j := range sentences {
+			if i == j || len(words[i]) < 2 || len(words[j]) < 2 {
+				continue
+			}
+			common := 0
+			for w := range words[i] {
+				if words[j][w] {
+					common++
+				}
+			}
+			weight[i][j] = float64(common) / (math.Log(float64(len(words[i]))) + math.Log(float64(len(words[j]))))
+		}
+	}
+
+	scores := pageRank(weight, 0.85, 30)
+	order := make([]int, len(sentences))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+	top := order[:n]
+	sort.Ints(top)
+
+	picked := make([]string, n)
+	for i, idx := range top {
+		picked[i] = sentences[idx]
+	}
+	return bullets(picked)
+}
+
+// pageRank runs the weighted PageRank iteration over the adjacency matrix w.
+func pageRank(w [][]float64, damping float64, iterations int) []float64 {
+	n := len(w)
+	out := make([]float64, n)
+	for i := range w {
+		for _, v := range w[i] {
+			out[i] += v
+		}
+	}
+	scores := make([]float64, n)
+	for i := range scores {
+		scores[i] = 1
+	}
+	for it := 0; it < iterations; it++ {
+		next := make([]float64, n)
+		for i := 0; i < n; i++ {
+			sum := 0.0
+			for j := 0; j < n; j++ {
+				if w[j][i] > 0 && out[j] > 0 {
+					sum += w[j][i] / out[j] * scores[j]
+				}
+			}
+			next[i] = (1 - damping) + damping*sum
+		}
+		scores = next
+	}
+	return scores
+}
+
+func bullets(items []string) string {
+	var sb strings.Builder
+	for _, s := range items {
+		sb.WriteString("- " + s + "\n")
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// --- Keyword extraction (RAKE) ---
+
+// localKeywords extracts up to max key phrases with RAKE: candidate phrases
+// are runs of content words between stopwords and punctuation, scored by the
+// sum of their words' degree/frequency ratios.
+func localKeywords(text string, max int) []string {
+	var phrases [][]string
+	var cur []string
+	flush := func() {
+		if len(cur) > 0 && len(cur) <= 4 {
+			phrases = append(phrases, cur)
+		}
+		cur = nil
+	}
+	for _, tok := range strings.FieldsFunc(strings.ToLower(text), unicode.IsSpace) {
+		word := strings.TrimFunc(tok, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsNumber(r) })
+		switch {
+		case word == "" || stopwords[word] || len([]rune(word)) < 2 || isNumber(word):
+			flush()
+		default:
+			cur = append(cur, word)
+		}
+		if last := []rune(tok); len(last) > 0 && strings.ContainsRune(".,;:!?()[]\"", last[len(last)-1]) {
+			flush()
+		}
+	}
+	flush()
+
+	freq := map[string]float64{}
+	degree := map[string]float64{}
+	for _, p := range phrases {
+		for _, w := range p {
+			freq[w]++
+			degree[w] += float64(len(p))
+		}
+	}
+
+	scores := map[string]float64{}
+	for _, p := range phrases {
+		phrase := strings.Join(p, " ")
+		if _, seen := scores[phrase]; seen {
+			continue
+		}
+		for _, w := range p {
+			scores[phrase] += degree[w] / freq[w]
+		}
+	}
+
+	kws := make([]string, 0, len(scores))
+	for p := range scores {
+		kws = append(kws, p)
+	}
+	sort.Slice(kws, func(i, j int) bool {
+		if scores[kws[i]] != scores[kws[j]] {
+			return scores[kws[i]] > scores[kws[j]]
+		}
+		return kws[i] < kws[j]
+	})
+	if len(kws) > max {
+		kws = kws[:max]
+	}
+	return kws
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+var stopwords = func() map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields(`a about above after again against all also am an and any are as at be
+		because been before being below between both but by can could did do does doing down during each
+		few for from further had has have having he her here hers herself him himself his how however i if
+		in into is it its itself just let me more most my myself no nor not now of off on once only or other
+		our ours ourselves out over own same she should so some such than that the their theirs them
+		themselves then there these they this those through to too under until up us very was we were what
+		when where which while who whom why will with within without would you your yours yourself
+		yourselves may might must shall one two new use used using via per`) {
+		m[w] = true
+	}
+	return m
+}()
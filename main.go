@@ -1,98 +1,614 @@
 package main
 
 import (
-	"bytes"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
-)
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-const openAIURL = "https://api.openai.com/v1/chat/completions"
-const model = "gpt-4o-mini" // change to a model you have access to
+	"ai-text-tools/texttools"
+)
 
-// --- OpenAI request/response types ---
+// --- API request/response types ---
 
-type ChatMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
-}
+// Options are optional knobs accepted by every operation. Fields left empty
+// fall back to the caller's saved preferences (see preferences.go).
+type Options struct {
+	texttools.Options
 
-type ChatRequest struct {
-	Model    string        `json:"model"`
-	Messages []ChatMessage `json:"messages"`
+	Reuse     bool   `json:"reuse,omitempty"`      // return a stored result for a (nearly) identical input
+	SessionID string `json:"session_id,omitempty"` // run in this session (see withSession)
 }
 
-type ChatChoice struct {
-	Message ChatMessage `json:"message"`
-}
+// maxOutputTokens caps max_tokens (MAX_OUTPUT_TOKENS).
+var maxOutputTokens = 4096
 
-type ChatResponse struct {
-	Choices []ChatChoice `json:"choices"`
+func (o Options) validate() error {
+	if err := o.Options.Validate(); err != nil {
+		return err
+	}
+	if o.MaxTokens > maxOutputTokens {
+		return fmt.Errorf("max_tokens must be at most %d", maxOutputTokens)
+	}
+	return nil
 }
 
-// --- API request/response types ---
-
 type TextRequest struct {
 	Text string `json:"text"`
+	Options
 }
 
 type RewriteRequest struct {
 	Text string `json:"text"`
 	Tone string `json:"tone"`
+	Options
+}
+
+// SummarizeRequest is a TextRequest that can target a reading time.
+type SummarizeRequest struct {
+	Text        string `json:"text"`
+	ReadingTime string `json:"reading_time"` // e.g. "2 minutes"; see parseReadingTime
+	Verify      bool   `json:"verify"`       // check the summary against the text (see verify.go)
+	Options
 }
 
 type SummarizeResponse struct {
-	Summary string `json:"summary"`
+	Summary      string               `json:"summary"`
+	ReadingTime  *ReadingTimeFit      `json:"reading_time,omitempty"`
+	Verification *SummaryVerification `json:"verification,omitempty"` // with verify
+	ResultMeta
 }
 
 type KeywordsResponse struct {
 	Keywords []string `json:"keywords"`
+	ResultMeta
 }
 
 type RewriteResponse struct {
 	Text string `json:"text"`
+	ResultMeta
 }
 
 type QuestionsResponse struct {
 	Questions []string `json:"questions"`
+	ResultMeta
 }
 
 type TitlesResponse struct {
 	Titles []string `json:"titles"`
+	ResultMeta
 }
 
 type ExpandResponse struct {
 	Text string `json:"text"`
+	ResultMeta
+}
+
+type BulletsResponse struct {
+	Text string `json:"text"`
+	ResultMeta
+}
+
+type ProseResponse struct {
+	Text string `json:"text"`
+	ResultMeta
 }
 
 func main() {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		log.Fatal("OPENAI_API_KEY env var is required")
+	cli, err := parseCommandLine(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch cli.Command {
+	case "install":
+		if err := installService(cli.EnvFile); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case "uninstall":
+		if err := uninstallService(); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	mux := http.NewServeMux()
+	if cli.EnvFile != "" {
+		if err := loadEnvFile(cli.EnvFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	logFile := os.Getenv("LOG_FILE")
+	if logFile == "" && cli.Command == "service" {
+		// Windows services have no console; log next to the binary.
+		if exe, err := os.Executable(); err == nil {
+			logFile = filepath.Join(filepath.Dir(exe), serviceName+".log")
+		}
+	}
+	if err := setupLogging(logFile); err != nil {
+		log.Fatal(err)
+	}
+	removePIDFile, err := writePIDFile(os.Getenv("PID_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer removePIDFile()
 
-	// Web UI
-	mux.HandleFunc("/", uiHandler)
+	httpClient, err := newHTTPClientFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	provider, err := newProvider(os.Getenv("PROVIDER"), httpClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	models, err := newFallbackProviderFromEnv(provider, httpClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	promptsDir := os.Getenv("PROMPTS_DIR")
+	if promptsDir == "" {
+		promptsDir = "prompts"
+	}
+	prompts, err := NewPromptRegistry(promptsDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go prompts.Watch(2 * time.Second)
+	if runtimeConfig, err = NewConfigStoreFromEnv(); err != nil {
+		log.Fatal(err)
+	}
+	tenants, err := NewTenantStoreFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, defaultModel := describeProvider(provider)
 
-	// API endpoints
-	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/summarize", withMethod("POST", summarizeHandler(apiKey)))
-	mux.HandleFunc("/keywords", withMethod("POST", keywordsHandler(apiKey)))
-	mux.HandleFunc("/rewrite", withMethod("POST", rewriteHandler(apiKey)))
-	mux.HandleFunc("/questions", withMethod("POST", questionsHandler(apiKey)))
-	mux.HandleFunc("/titles", withMethod("POST", titlesHandler(apiKey)))
-	mux.HandleFunc("/expand", withMethod("POST", expandHandler(apiKey)))
+	if outputWrappers, err = LoadOutputWrappers(os.Getenv("OUTPUT_TEMPLATES_DIR")); err != nil {
+		log.Fatal(err)
+	}
+	if ops := outputWrappers.Operations(); len(ops) > 0 {
+		log.Printf("Output wrappers: %s", strings.Join(ops, ", "))
+	}
 
-	addr := ":8080"
-	log.Printf("Server listening on %s", addr)
-	if err := http.ListenAndServe(addr, logRequest(mux)); err != nil {
+	styleGuides, err := loadStyleGuides(os.Getenv("STYLE_GUIDE_FILE"), os.Getenv("STYLE_GUIDE_OPERATIONS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	policies, err := NewPoliciesFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	policies.Log()
+
+	reloader := &Reloader{}
+	if cli.EnvFile != "" {
+		reloader.Add("env file", func() error { return loadEnvFile(cli.EnvFile) })
+	}
+	reloader.Add("prompts", prompts.Reload)
+	reloader.Add("config", runtimeConfig.Reload)
+	reloader.Add("tenants", tenants.Reload)
+	reloader.Add("policies", policies.Reload)
+	reloader.OnSignal()
+
+	breaker, err := NewCircuitBreakerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	moderator, err := newModeratorFromEnv(httpClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	guardMode, err := promptGuardFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sessionTTL := 24 * time.Hour
+	if v := os.Getenv("SESSION_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("SESSION_TTL: invalid duration %q", v)
+		}
+		sessionTTL = d
+	}
+	sessions := NewSessionStore(sessionTTL)
+
+	idempotencyTTL := 24 * time.Hour
+	if v := os.Getenv("IDEMPOTENCY_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("IDEMPOTENCY_TTL: invalid duration %q", v)
+		}
+		idempotencyTTL = d
+	}
+	idempotency := NewIdempotencyStore(idempotencyTTL)
+	go idempotency.Prune(min(idempotencyTTL, time.Minute))
+
+	auditConfig, err := AuditConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	audit, err := NewAuditLog(auditConfig, tenants)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tokenizersDir := os.Getenv("TOKENIZERS_DIR")
+	if tokenizersDir == "" {
+		tokenizersDir = "tokenizers"
+	}
+	tokenizers, err := NewTokenizerRegistry(tokenizersDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	prices, err := LoadModelPrices(os.Getenv("MODEL_PRICES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	var llm texttools.StreamProvider = breakerProvider{policyProvider{meteredProvider{models, tokenizers}, policies}, breaker}
+	if !envBool("LLM_DISABLE_COALESCING") {
+		llm = newCoalescingProvider(llm)
+	}
+	echo := echoProvider{llm}
+	base := configProvider{tenantProvider{dryRunProvider{echo, defaultModel, tokenizers, prices}, defaultModel}}
+	if guardMode == guardClassify {
+		classifier := &InjectionClassifier{Tools: &texttools.Tools{Provider: base, Prompts: prompts}}
+		if moderator == nil {
+			moderator = classifier
+		} else {
+			moderator = moderators{moderator, classifier}
+		}
+	}
+	tools := &texttools.Tools{
+		Provider:    redactingProvider{sessionProvider{moderatingProvider{base, moderator}, sessions}},
+		Prompts:     prompts,
+		StyleGuides: styleGuides,
+		Guard:       guardMode != guardNone,
+	}
+
+	prefs, err := NewPreferenceStore(os.Getenv("PREFERENCES_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	customOps, err := NewCustomOperations(os.Getenv("CUSTOM_OPS_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := NewPostgresStoreFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var historyStore HistoryBackend
+	var documentStore DocumentBackend
+	if db != nil {
+		historyStore, documentStore = db, db
+		tenants.usage = db
+		log.Println("Database: history, documents and tenant usage are kept in PostgreSQL")
+	} else {
+		if historyStore, err = newMemoryHistory(os.Getenv("HISTORY_FILE")); err != nil {
+			log.Fatal(err)
+		}
+		if documentStore, err = newMemoryDocuments(os.Getenv("DOCUMENTS_FILE")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	history := NewHistory(historyStore, envInt("HISTORY_MAX", 1000))
+	redis, err := NewRedisFromEnv()
+	if err != nil {
 		log.Fatal(err)
 	}
+	if redis != nil {
+		if history.Shared, err = NewRedisResultCacheFromEnv(redis); err != nil {
+			log.Fatal(err)
+		}
+		runtimeConfig.limiter = redisLimiter{redis}
+		log.Println("Redis: the rate limit and result cache are shared")
+	}
+	history.Provenance = NewProvenanceSource(provider, tools, prompts)
+	upstream := NewUpstreamCheck(provider)
+
+	vectors, err := NewVectorStore(os.Getenv("VECTORS_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	embedder, _ := provider.(texttools.Embedder)
+	if o, ok := provider.(*texttools.OpenAI); ok && o.Azure != nil && o.Azure.EmbeddingDeployment == "" {
+		embedder = nil // Azure embeds only with an embedding deployment
+	}
+	if embedder != nil {
+		history.OnRecord = indexer(embedder, vectors, history)
+	}
+
+	signer, err := NewProvenanceSignerFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	jobs := NewJobStore(envInt("JOB_WORKERS", 2), 100)
+	jobs.Handle("sitemap", sitemapJobRunner(tools))
+	jobs.Handle("book_summary", bookSummaryJobRunner(tools))
+	sharedJobs, err := NewRedisJobQueueFromEnv(redis)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sharedJobs != nil {
+		jobs.Shared = sharedJobs
+		workers := envInt("JOB_QUEUE_WORKERS", envInt("JOB_WORKERS", 2))
+		jobs.Work(workers)
+		log.Printf("Jobs: queued in Redis, %d workers here", workers)
+	}
+	books := NewBookStore()
+
+	tabs, err := NewTabStore(os.Getenv("TABS_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	feedback, err := NewFeedbackStore(os.Getenv("FEEDBACK_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	documents := NewDocumentStore(documentStore)
+	blobs, err := NewBlobStoreFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if v := os.Getenv("SCHEDULE_MIN_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("SCHEDULE_MIN_INTERVAL: invalid duration %q", v)
+		}
+		scheduleMinInterval = d
+	}
+	scheduleStore, err := NewScheduleStore(os.Getenv("SCHEDULES_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	scheduler := &Scheduler{Store: scheduleStore, Jobs: jobs, Tools: tools, Documents: documents, SMTP: InboundConfigFromEnv().SMTP}
+	for _, d := range strings.Split(os.Getenv("SCHEDULE_EMAIL_DOMAINS"), ",") {
+		if d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@")); d != "" {
+			scheduler.EmailDomains = append(scheduler.EmailDomains, d)
+		}
+	}
+	go scheduler.Run()
+	if v := os.Getenv("FEED_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			log.Fatalf("FEED_POLL_INTERVAL: invalid duration %q (at least 1m)", v)
+		}
+		feedPollInterval = d
+	}
+	feedStore, err := NewFeedStore(os.Getenv("FEEDS_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	feeds := &FeedReader{Store: feedStore, Jobs: jobs, Tools: tools}
+	go feeds.Run()
+
+	sso, err = NewSAMLProviderFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	adminAuth := adminToken != "" || sso != nil
+	if !adminAuth {
+		if tenants.file != "" || tenants.require {
+			log.Fatal("TENANTS_FILE and REQUIRE_API_KEY need ADMIN_TOKEN (or SAML login) to manage tenant keys")
+		}
+		log.Println("ADMIN_TOKEN not set: admin endpoints are off")
+	}
+
+	maxOutputTokens = envInt("MAX_OUTPUT_TOKENS", maxOutputTokens)
+	maxBodyBytes = int64(envInt("MAX_BODY_BYTES", int(maxBodyBytes)))
+	maxTextChars = envInt("MAX_TEXT_CHARS", maxTextChars)
+	quickMaxChars = envInt("QUICK_MAX_CHARS", quickMaxChars)
+	readingWPM = envInt("READING_WPM", readingWPM)
+	if readingWPM < 1 {
+		log.Fatal("READING_WPM must be positive")
+	}
+	visionModel = os.Getenv("VISION_MODEL")
+	features.LoadEnv()
+	metrics.SetDailyBudget(envInt("DAILY_TOKEN_BUDGET", 0))
+	privacy := UsagePrivacy{MinUsers: envInt("USAGE_MIN_USERS", 5), Epsilon: envFloat("USAGE_EPSILON", 1)}
+	if privacy.Epsilon <= 0 {
+		log.Fatal("USAGE_EPSILON must be positive")
+	}
+	switch agg := os.Getenv("USAGE_AGGREGATION"); agg {
+	case "", "exact":
+		metrics.SetUsagePrivacy(privacy, false)
+	case "private":
+		metrics.SetUsagePrivacy(privacy, true)
+	default:
+		log.Fatalf("unknown USAGE_AGGREGATION %q (want exact or private)", agg)
+	}
+
+	warmup := NewWarmup()
+	if envBool("WARMUP") {
+		warmup.Start(provider, tools, envInt("WARMUP_CONNECTIONS", 2))
+	}
+
+	// API endpoints, served under /api/v1
+	api := http.NewServeMux()
+	api.HandleFunc("/health", healthHandler(breaker, upstream, history))
+	api.HandleFunc("/readyz", withMethod("GET", readyzHandler(warmup)))
+	spec := withMethod("GET", openAPIHandler())
+	api.HandleFunc("/openapi.json", spec)
+	api.HandleFunc("/summarize", withMethod("POST", summarizeHandler(tools, prefs, history)))
+	api.HandleFunc("/summarize/chat", withMethod("POST", summarizeChatHandler(tools, prefs, history)))
+	api.HandleFunc("/summarize/multi", withMethod("POST", summarizeMultiHandler(tools, prefs, history)))
+	api.HandleFunc("/compare", withMethod("POST", compareHandler(tools, prefs, history)))
+	api.HandleFunc("/compare-models", withMethod("POST", compareModelsHandler(tools, prefs, prices, compareModelsFromEnv())))
+	api.HandleFunc("/explain-edits", withMethod("POST", explainEditsHandler(tools, prefs, history)))
+	api.HandleFunc("/outline", withMethod("POST", outlineHandler(tools, prefs, history)))
+	api.HandleFunc("/draft", withMethod("POST", draftHandler(tools, prefs, history)))
+	api.HandleFunc("/plan", withMethod("POST", planHandler(tools, prefs, history)))
+	api.HandleFunc("/entities", withMethod("POST", entitiesHandler(tools, prefs, history)))
+	api.HandleFunc("/redact", withMethod("POST", redactHandler(tools, prefs)))
+	api.HandleFunc("/keywords", withMethod("POST", keywordsHandler(tools, prefs, history)))
+	api.HandleFunc("/rewrite", withMethod("POST", rewriteHandler(tools, prefs, history)))
+	api.HandleFunc("/questions", withMethod("POST", questionsHandler(tools, prefs, history)))
+	api.HandleFunc("/ask", withMethod("POST", askHandler(tools, prefs, history)))
+	api.HandleFunc("/terminology-report", withMethod("POST", terminologyReportHandler(tools, prefs, history)))
+	api.HandleFunc("/titles", withMethod("POST", titlesHandler(tools, prefs, history)))
+	api.HandleFunc("/headlines", withMethod("POST", headlinesHandler(tools, prefs, history)))
+	api.HandleFunc("/seo", withMethod("POST", seoHandler(tools, prefs, history)))
+	api.HandleFunc("/email", withMethod("POST", emailHandler(tools, prefs, history)))
+	api.HandleFunc("/release-notes", withMethod("POST", releaseNotesHandler(tools, prefs, history)))
+	api.HandleFunc("/meeting", withMethod("POST", meetingHandler(tools, prefs, history)))
+	api.HandleFunc("/explain-code", withMethod("POST", explainCodeHandler(tools, prefs, history)))
+	api.HandleFunc("/citations", withMethod("POST", citationsHandler(tools, prefs, history)))
+	api.HandleFunc("/verify-summary", withMethod("POST", verifySummaryHandler(tools, prefs, history)))
+	api.HandleFunc("/glossary", withMethod("POST", glossaryHandler(tools, prefs, history)))
+	api.HandleFunc("/expand", withMethod("POST", expandHandler(tools, prefs, history)))
+	api.HandleFunc("/to-bullets", withMethod("POST", toBulletsHandler(tools, prefs, history)))
+	api.HandleFunc("/to-prose", withMethod("POST", toProseHandler(tools, prefs, history)))
+	api.HandleFunc("/tokens", withMethod("POST", tokensHandler(tokenizers, prefs)))
+	api.HandleFunc("/stats", withMethod("POST", statsHandler))
+	api.HandleFunc("/similarity", withMethod("POST", similarityHandler(embedder)))
+	if embedder != nil {
+		api.HandleFunc("/embed", withMethod("POST", embedHandler(embedder)))
+	}
+	api.HandleFunc("/search", withMethod("GET", searchHandler(embedder, vectors, history, documents)))
+	api.HandleFunc("/upload", withMethod("POST", uploadHandler(tools, prefs)))
+	api.HandleFunc("/books", booksHandler(tools, prefs, books))
+	api.HandleFunc("/books/", booksHandler(tools, prefs, books))
+	api.HandleFunc("/custom", withMethod("POST", customHandler(tools, prefs, customOps)))
+	api.HandleFunc("/custom/", withMethod("POST", customHandler(tools, prefs, customOps)))
+	api.HandleFunc("/operations", withMethod("GET", operationsHandler(customOps)))
+	api.HandleFunc("/preferences", preferencesHandler(prefs))
+	api.HandleFunc("/sessions", sessionsHandler(sessions, tools, prefs))
+	api.HandleFunc("/sessions/", sessionsHandler(sessions, tools, prefs))
+	api.HandleFunc("/ws", withMethod("GET", wsHandler(tools, prefs, sessions)))
+	api.HandleFunc("/regenerate", withMethod("POST", regenerateHandler(tools, prefs, history)))
+	api.HandleFunc("/documents", documentsHandler(documents, blobs))
+	api.HandleFunc("/documents/", documentsHandler(documents, blobs))
+	api.HandleFunc("/labels", withMethod("GET", labelsHandler(documents, history)))
+	api.HandleFunc("/schedules", schedulesHandler(scheduler, prefs))
+	api.HandleFunc("/schedules/", schedulesHandler(scheduler, prefs))
+	api.HandleFunc("/feeds", feedsHandler(feeds, prefs))
+	api.HandleFunc("/feeds/", feedsHandler(feeds, prefs))
+	api.HandleFunc("/feedback", withMethod("POST", feedbackHandler(feedback, history)))
+	api.HandleFunc("/feedback/stats", withMethod("GET", withAdmin(adminToken, feedbackStatsHandler(feedback))))
+	api.HandleFunc("/history", historyHandler(history, signer))
+	api.HandleFunc("/history/", historyHandler(history, signer))
+	api.HandleFunc("/export", withMethod("POST", exportHandler(history, blobs)))
+	if blobs != nil {
+		api.HandleFunc("/exports/", exportsHandler(blobs))
+	}
+	api.HandleFunc("/provenance/verify", withMethod("POST", provenanceVerifyHandler(signer)))
+	api.HandleFunc("/provenance/key", withMethod("GET", provenanceKeyHandler(signer)))
+	api.HandleFunc("/jobs", jobsHandler(prefs, jobs, books))
+	api.HandleFunc("/jobs/", jobsHandler(prefs, jobs, books))
+	api.HandleFunc("/tabs", tabsHandler(tabs))
+	api.HandleFunc("/me", withMethod("GET", meHandler))
+	api.HandleFunc("/tenant", withMethod("GET", tenantHandler(tenants)))
+	api.HandleFunc("/quick", quickHandler(tools, prefs, extensionOriginsFromEnv()))
+	api.HandleFunc("/integrations/email", withMethod("POST", inboundEmailHandler(tools, InboundConfigFromEnv())))
+	slackAPIKey = os.Getenv("SLACK_API_KEY")
+	api.HandleFunc("/integrations/slack", withMethod("POST", slackHandler(tools, os.Getenv("SLACK_SIGNING_SECRET"))))
+
+	// Opt-in endpoints, 404 unless their flag is on
+	api.HandleFunc("/plain-medical", withFeature("plain_medical", withMethod("POST", plainMedicalHandler(tools, prefs))))
+
+	// Admin endpoints
+	api.HandleFunc("/prompts", withMethod("GET", withAdmin(adminToken, promptsHandler(prompts))))
+	api.HandleFunc("/admin/operations", withAdmin(adminToken, adminOperationsHandler(customOps)))
+	api.HandleFunc("/admin/stats", withMethod("GET", withAdmin(adminToken, adminStatsHandler(jobs, policies))))
+	api.HandleFunc("/admin/flags", withAdmin(adminToken, adminFlagsHandler))
+	api.HandleFunc("/admin/config", withAdmin(adminToken, adminConfigHandler))
+	api.HandleFunc("/admin/config/prompts/", withAdmin(adminToken, adminPromptHandler(prompts)))
+	api.HandleFunc("/admin/config/experiments/", withAdmin(adminToken, adminExperimentHandler(prompts)))
+	api.HandleFunc("/admin/experiments", withMethod("GET", withAdmin(adminToken, adminExperimentsHandler(prompts, feedback))))
+	api.HandleFunc("/admin/config/audit", withMethod("GET", withAdmin(adminToken, adminAuditHandler)))
+	api.HandleFunc("/admin/reload", withMethod("POST", withAdmin(adminToken, adminReloadHandler(reloader))))
+	api.HandleFunc("/admin/tenants", withAdmin(adminToken, adminTenantsHandler(tenants)))
+	api.HandleFunc("/admin/tenants/", withAdmin(adminToken, adminTenantsHandler(tenants)))
+	apiHandler := withPriority(withPrivacyMode(metrics.Middleware(api, withAudit(audit, withMaintenance(withIdempotency(idempotency, withTenants(tenants, withRateLimit(withDryRun(withDebugEcho(withRedactPII(withModeration(withRejection(withServedModel(withPromptExperiments(withResponseMeta(history.Provenance, withDocuments(documents, withEvaluation(tools, withETag(withSession(sessions, api))))))))))))))))))))
+
+	mux := http.NewServeMux()
+	mux.Handle(apiPrefix+"/", http.StripPrefix(apiPrefix, withErrorEnvelope(apiHandler)))
+	// Probes, the spec, the admin dashboard and the playground stay unversioned; "/" serves
+	// the web UI and every other unprefixed path is a deprecated alias.
+	mux.HandleFunc("/health", healthHandler(breaker, upstream, history))
+	mux.HandleFunc("/readyz", withMethod("GET", readyzHandler(warmup)))
+	mux.HandleFunc("/openapi.json", spec)
+	mux.HandleFunc("/admin", withMethod("GET", sso.RequireLogin(adminPageHandler)))
+	mux.HandleFunc("/playground", withMethod("GET", sso.RequireLogin(playgroundHandler)))
+	if sso != nil {
+		mux.HandleFunc("/saml/login", withMethod("GET", sso.loginHandler))
+		mux.HandleFunc("/saml/acs", withMethod("POST", sso.acsHandler))
+		mux.HandleFunc("/saml/logout", withMethod("POST", sso.logoutHandler))
+		mux.HandleFunc("/saml/metadata", withMethod("GET", sso.metadataHandler))
+	}
+	mux.Handle("/", legacyHandler(apiHandler))
+	publishDebugVars(jobs, policies)
+	// heap dumps and the command line are secrets: only behind admin auth
+	if (envBool("DEBUG_ENDPOINTS") || os.Getenv("DEBUG_ADDR") != "") && !adminAuth {
+		log.Println("ADMIN_TOKEN not set: diagnostics (DEBUG_ENDPOINTS, DEBUG_ADDR) are off")
+	}
+	if envBool("DEBUG_ENDPOINTS") && adminAuth {
+		mux.Handle("/debug/", debugHandler(adminToken))
+	}
+
+	tlsConfig, err := newTLSConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	addr := ":8080"
+	if tlsConfig != nil {
+		addr = os.Getenv("TLS_ADDR")
+		if addr == "" {
+			addr = ":443"
+		}
+	}
+	servers := []*http.Server{{Addr: addr, Handler: withRequestID(logRequest(withCompression(mux))), TLSConfig: tlsConfig}}
+	if tlsConfig != nil {
+		log.Printf("Server listening on %s (HTTPS)", addr)
+		if redirectAddr := os.Getenv("HTTP_REDIRECT_ADDR"); redirectAddr != "" {
+			servers = append(servers, newRedirectServer(redirectAddr, addr))
+			log.Printf("Redirecting HTTP on %s to HTTPS", redirectAddr)
+		}
+	} else {
+		log.Printf("Server listening on %s", addr)
+	}
+	if debugAddr := os.Getenv("DEBUG_ADDR"); debugAddr != "" && adminAuth {
+		servers = append(servers, &http.Server{Addr: debugAddr, Handler: debugHandler(adminToken)})
+		log.Printf("Diagnostics (pprof, expvar) listening on %s", debugAddr)
+	}
+	if grpcAddr := os.Getenv("GRPC_ADDR"); grpcAddr != "" {
+		// the API's tenant, rate limit, audit and privacy middleware; the
+		// others work on JSON bodies
+		grpc := withGRPCStatus(withPriority(withPrivacyMode(withAudit(audit, withMaintenance(withTenants(tenants, withRateLimit(grpcHandler(tools, prefs))))))))
+		servers = append(servers, newGRPCServer(grpcAddr, withRequestID(logRequest(grpc))))
+		log.Printf("gRPC listening on %s", grpcAddr)
+	}
+	if cli.Command == "service" {
+		err = runService(servers...)
+	} else {
+		err = serve(stopSignal(), servers...)
+	}
+	audit.Close()
+	if err != nil {
+		log.Println(err)
+		removePIDFile()
+		os.Exit(1)
+	}
 }
 
 // --- UI handler (simple HTML + JS, no framework) ---
@@ -108,254 +624,477 @@ func uiHandler(w http.ResponseWriter, r *http.Request) {
 
 // --- API Handlers ---
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	writeJSON(w, http.StatusOK, map[string]string{
-		"status": "ok",
-	})
-}
-
-func summarizeHandler(apiKey string) http.HandlerFunc {
+func summarizeHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req TextRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		var req SummarizeRequest
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 		if req.Text == "" {
 			http.Error(w, "`text` is required", http.StatusBadRequest)
 			return
 		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		var budget time.Duration
+		if req.ReadingTime != "" {
+			var err error
+			if budget, err = parseReadingTime(req.ReadingTime); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
 
-		prompt := "Summarize the following text in 3–5 bullet points. Be concise and clear.\n\n" + req.Text
-		out, err := callLLM(apiKey, prompt)
+		extra := ""
+		if budget > 0 {
+			extra = budget.String()
+		}
+		if req.Verify {
+			extra += "|verify"
+		}
+		key := historyKey(extra, req.Options)
+		if prior, ok := history.Reusable(r, "summarize", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		text := TextRequest{Text: req.Text, Options: req.Options}
+		var resp SummarizeResponse
+		var err error
+		if budget > 0 {
+			resp, err = summarizeForReadingTime(r.Context(), tools, text, budget)
+		} else {
+			resp, err = summarize(r.Context(), tools, text)
+		}
 		if err != nil {
 			log.Println("summarize error:", err)
-			http.Error(w, "LLM error", http.StatusInternalServerError)
+			writeLLMError(w, err)
 			return
 		}
+		// a degraded summary is extracted from the text: nothing to verify
+		if req.Verify && !resp.Degraded {
+			v, err := verifySummary(r.Context(), tools, req.Text, resp.Summary, req.Options.Options)
+			if err != nil {
+				log.Println("summarize verify error:", err)
+				writeLLMError(w, err)
+				return
+			}
+			resp.Verification = &v
+		}
 
-		resp := SummarizeResponse{Summary: out}
+		resp.Summary = outputWrappers.Wrap("summarize", resp.Summary, req.Options)
+		if !resp.Degraded {
+			resp.ResultMeta = history.Record(r, "summarize", key, req.Text, req.Options, resp)
+		}
 		writeJSON(w, http.StatusOK, resp)
 	}
 }
 
-func keywordsHandler(apiKey string) http.HandlerFunc {
+func keywordsHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req TextRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 		if req.Text == "" {
 			http.Error(w, "`text` is required", http.StatusBadRequest)
 			return
 		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
 
-		prompt := `Extract 5–10 key keywords from the text below.
-Return ONLY a JSON array of strings. Example: ["keyword1","keyword2"].
-
-Text:
-` + req.Text
+		key := historyKey("", req.Options)
+		if prior, ok := history.Reusable(r, "keywords", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
 
-		out, err := callLLM(apiKey, prompt)
+		resp, err := keywords(r.Context(), tools, req)
 		if err != nil {
 			log.Println("keywords error:", err)
-			http.Error(w, "LLM error", http.StatusInternalServerError)
+			writeLLMError(w, err)
 			return
 		}
 
-		var kws []string
-		if err := json.Unmarshal([]byte(out), &kws); err != nil {
-			// fallback – try to be robust
-			kws = []string{out}
+		if !resp.Degraded {
+			resp.ResultMeta = history.Record(r, "keywords", key, req.Text, req.Options, resp)
 		}
-
-		resp := KeywordsResponse{Keywords: kws}
 		writeJSON(w, http.StatusOK, resp)
 	}
 }
 
-func rewriteHandler(apiKey string) http.HandlerFunc {
+func rewriteHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req RewriteRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 		if req.Text == "" {
 			http.Error(w, "`text` is required", http.StatusBadRequest)
 			return
 		}
-		tone := req.Tone
-		if tone == "" {
-			tone = "neutral"
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p := prefs.For(r)
+		p.apply(&req.Options)
+		if req.Tone == "" {
+			req.Tone = p.Tone
 		}
 
-		prompt := fmt.Sprintf(
-			"Rewrite the following text in a %s tone. Preserve the original meaning. Respond with ONLY the rewritten text.\n\n%s",
-			tone, req.Text,
-		)
+		key := historyKey(req.Tone, req.Options)
+		if prior, ok := history.Reusable(r, "rewrite", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
 
-		out, err := callLLM(apiKey, prompt)
+		resp, err := rewrite(r.Context(), tools, req)
 		if err != nil {
 			log.Println("rewrite error:", err)
-			http.Error(w, "LLM error", http.StatusInternalServerError)
+			writeLLMError(w, err)
 			return
 		}
 
-		resp := RewriteResponse{Text: out}
+		resp.Text = outputWrappers.Wrap("rewrite", resp.Text, req.Options)
+		resp.ResultMeta = history.Record(r, "rewrite", key, req.Text, req.Options, resp)
 		writeJSON(w, http.StatusOK, resp)
 	}
 }
 
-func questionsHandler(apiKey string) http.HandlerFunc {
+func questionsHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req TextRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 		if req.Text == "" {
 			http.Error(w, "`text` is required", http.StatusBadRequest)
 			return
 		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
 
-		prompt := `From the text below, generate 5–10 clear, helpful questions.
-Return ONLY a JSON array of strings. Example: ["Question 1?", "Question 2?"].
-
-Text:
-` + req.Text
+		key := historyKey("", req.Options)
+		if prior, ok := history.Reusable(r, "questions", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
 
-		out, err := callLLM(apiKey, prompt)
+		resp, err := questions(r.Context(), tools, req)
 		if err != nil {
 			log.Println("questions error:", err)
-			http.Error(w, "LLM error", http.StatusInternalServerError)
+			writeLLMError(w, err)
 			return
 		}
 
-		var qs []string
-		if err := json.Unmarshal([]byte(out), &qs); err != nil {
-			// fallback – just put the raw output
-			qs = []string{out}
-		}
-
-		resp := QuestionsResponse{Questions: qs}
+		resp.ResultMeta = history.Record(r, "questions", key, req.Text, req.Options, resp)
 		writeJSON(w, http.StatusOK, resp)
 	}
 }
 
-func titlesHandler(apiKey string) http.HandlerFunc {
+func titlesHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req TextRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 		if req.Text == "" {
 			http.Error(w, "`text` is required", http.StatusBadRequest)
 			return
 		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
 
-		prompt := `Generate 5 concise, engaging title ideas for the text below.
-Return ONLY a JSON array of strings. Example: ["Title 1", "Title 2"].
-
-Text:
-` + req.Text
+		key := historyKey("", req.Options)
+		if prior, ok := history.Reusable(r, "titles", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
 
-		out, err := callLLM(apiKey, prompt)
+		resp, err := titles(r.Context(), tools, req)
 		if err != nil {
 			log.Println("titles error:", err)
-			http.Error(w, "LLM error", http.StatusInternalServerError)
+			writeLLMError(w, err)
 			return
 		}
 
-		var titles []string
-		if err := json.Unmarshal([]byte(out), &titles); err != nil {
-			titles = []string{out}
-		}
-
-		resp := TitlesResponse{Titles: titles}
+		resp.ResultMeta = history.Record(r, "titles", key, req.Text, req.Options, resp)
 		writeJSON(w, http.StatusOK, resp)
 	}
 }
 
-func expandHandler(apiKey string) http.HandlerFunc {
+func expandHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req TextRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		if !decodeJSON(w, r, &req) {
 			return
 		}
 		if req.Text == "" {
 			http.Error(w, "`text` is required", http.StatusBadRequest)
 			return
 		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
 
-		prompt := `Expand and elaborate on the following text.
-Add helpful explanations and details but keep it clear and readable.
-Respond with ONLY the expanded text.
-
-Text:
-` + req.Text
+		key := historyKey("", req.Options)
+		if prior, ok := history.Reusable(r, "expand", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
 
-		out, err := callLLM(apiKey, prompt)
+		resp, err := expand(r.Context(), tools, req)
 		if err != nil {
 			log.Println("expand error:", err)
-			http.Error(w, "LLM error", http.StatusInternalServerError)
+			writeLLMError(w, err)
 			return
 		}
 
-		resp := ExpandResponse{Text: out}
+		resp.Text = outputWrappers.Wrap("expand", resp.Text, req.Options)
+		resp.ResultMeta = history.Record(r, "expand", key, req.Text, req.Options, resp)
 		writeJSON(w, http.StatusOK, resp)
 	}
 }
 
-// --- LLM call helper ---
+func toBulletsHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TextRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey("", req.Options)
+		if prior, ok := history.Reusable(r, "to-bullets", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		resp, err := toBullets(r.Context(), tools, req)
+		if err != nil {
+			log.Println("to-bullets error:", err)
+			writeLLMError(w, err)
+			return
+		}
 
-func callLLM(apiKey, prompt string) (string, error) {
-	body := ChatRequest{
-		Model: model,
-		Messages: []ChatMessage{
-			{Role: "system", Content: "You are a helpful text-processing assistant."},
-			{Role: "user", Content: prompt},
-		},
+		resp.Text = outputWrappers.Wrap("to-bullets", resp.Text, req.Options)
+		resp.ResultMeta = history.Record(r, "to-bullets", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
 	}
+}
 
-	data, err := json.Marshal(body)
-	if err != nil {
-		return "", err
+func toProseHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TextRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey("", req.Options)
+		if prior, ok := history.Reusable(r, "to-prose", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		resp, err := toProse(r.Context(), tools, req)
+		if err != nil {
+			log.Println("to-prose error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		resp.Text = outputWrappers.Wrap("to-prose", resp.Text, req.Options)
+		resp.ResultMeta = history.Record(r, "to-prose", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
 	}
+}
 
-	req, err := http.NewRequest("POST", openAIURL, bytes.NewReader(data))
+// --- helpers ---
+
+// writeJSONFile atomically replaces path with the indented JSON encoding of v.
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
-		return "", err
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
 	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
+	return os.Rename(tmp, path)
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
+// newHTTPClientFromEnv builds the HTTP client for the provider from the
+// LLM_HTTP_* settings; unset ones keep texttools.DefaultHTTPClientConfig.
+func newHTTPClientFromEnv() (*http.Client, error) {
+	var c texttools.HTTPClientConfig
+	for _, d := range []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"LLM_HTTP_TIMEOUT", &c.Timeout},
+		{"LLM_HTTP_DIAL_TIMEOUT", &c.DialTimeout},
+		{"LLM_HTTP_TLS_TIMEOUT", &c.TLSHandshakeTimeout},
+		{"LLM_HTTP_HEADER_TIMEOUT", &c.ResponseHeaderTimeout},
+		{"LLM_HTTP_IDLE_TIMEOUT", &c.IdleConnTimeout},
+	} {
+		if v := os.Getenv(d.name); v != "" {
+			dur, err := time.ParseDuration(v)
+			if err != nil || dur <= 0 {
+				return nil, fmt.Errorf("%s: invalid duration %q", d.name, v)
+			}
+			*d.dst = dur
+		}
+	}
+	c.MaxIdleConnsPerHost = max(envInt("LLM_HTTP_MAX_IDLE_CONNS", 0), 0)
+	c.MaxConnsPerHost = max(envInt("LLM_HTTP_MAX_CONNS", 0), 0)
+	c.DisableHTTP2 = envBool("LLM_HTTP_DISABLE_HTTP2")
+	if v := os.Getenv("LLM_HTTP_PROXY"); v != "" {
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("LLM_HTTP_PROXY: invalid URL %q", v)
+		}
+		c.Proxy = u
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 400 {
-		b, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("OpenAI error: status=%d body=%s", resp.StatusCode, string(b))
+	c = c.WithDefaults()
+	proxy := "from environment"
+	if c.Proxy != nil {
+		proxy = c.Proxy.Redacted()
 	}
+	log.Printf("LLM HTTP client: timeout=%v dial=%v tls=%v header=%v idle=%v max_idle_per_host=%d max_conns_per_host=%d http2=%t proxy=%s",
+		c.Timeout, c.DialTimeout, c.TLSHandshakeTimeout, c.ResponseHeaderTimeout, c.IdleConnTimeout, c.MaxIdleConnsPerHost, c.MaxConnsPerHost, !c.DisableHTTP2, proxy)
+	return texttools.NewHTTPClient(c), nil
+}
 
-	var cr ChatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
-		return "", err
+// newProvider returns the LLM backend of a name, as in PROVIDER: openai
+// (the default), azure, or mock, which answers offline with canned
+// responses.
+func newProvider(name string, client *http.Client) (texttools.StreamProvider, error) {
+	switch name {
+	case "", "openai":
+		// OPENAI_BASE_URL points at an OpenAI-compatible server (llama.cpp,
+		// vLLM, LM Studio, ...), which may need no key.
+		apiKey, baseURL := os.Getenv("OPENAI_API_KEY"), os.Getenv("OPENAI_BASE_URL")
+		if apiKey == "" && baseURL == "" {
+			return nil, errors.New("OPENAI_API_KEY env var is required")
+		}
+		o := &texttools.OpenAI{APIKey: apiKey, Model: os.Getenv("OPENAI_MODEL"), Client: client, EmbeddingModel: os.Getenv("EMBEDDING_MODEL")}
+		if baseURL != "" {
+			u, err := url.Parse(baseURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("OPENAI_BASE_URL: invalid URL %q", baseURL)
+			}
+			o.URL = strings.TrimSuffix(baseURL, "/") + "/chat/completions"
+			log.Printf("using the OpenAI-compatible API at %s", baseURL)
+		}
+		return o, nil
+	case "azure":
+		azure := &texttools.AzureConfig{
+			Endpoint:            os.Getenv("AZURE_OPENAI_ENDPOINT"),
+			Deployment:          os.Getenv("AZURE_OPENAI_DEPLOYMENT"),
+			EmbeddingDeployment: os.Getenv("AZURE_OPENAI_EMBEDDING_DEPLOYMENT"),
+			APIVersion:          os.Getenv("AZURE_OPENAI_API_VERSION"),
+		}
+		apiKey := os.Getenv("AZURE_OPENAI_API_KEY")
+		if apiKey == "" || azure.Endpoint == "" || azure.Deployment == "" {
+			return nil, errors.New("AZURE_OPENAI_API_KEY, AZURE_OPENAI_ENDPOINT and AZURE_OPENAI_DEPLOYMENT env vars are required")
+		}
+		log.Printf("using Azure OpenAI deployment %s at %s", azure.Deployment, azure.Endpoint)
+		return &texttools.OpenAI{APIKey: apiKey, Client: client, Azure: azure}, nil
+	case "mock":
+		var latency time.Duration
+		if v := os.Getenv("MOCK_LATENCY"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("MOCK_LATENCY: %w", err)
+			}
+			latency = d
+		}
+		log.Println("using the mock LLM provider")
+		return &texttools.Mock{Latency: latency, ErrorEvery: envInt("MOCK_ERROR_EVERY", 0)}, nil
+	default:
+		return nil, fmt.Errorf("unknown PROVIDER %q (want openai, azure or mock)", name)
 	}
-	if len(cr.Choices) == 0 {
-		return "", fmt.Errorf("no choices from LLM")
+}
+
+// envInt returns the env var as an int, or def if it is unset or invalid.
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return v
 	}
+	return def
+}
 
-	return cr.Choices[0].Message.Content, nil
+// envFloat returns the env var as a float, or def if it is unset or invalid.
+func envFloat(name string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil {
+		return v
+	}
+	return def
 }
 
-// --- helpers ---
+// envBool reports whether the env var is set to a true value ("1", "true", ...).
+func envBool(name string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(name))
+	return v
+}
 
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -375,9 +1114,31 @@ func withMethod(method string, h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// withAdmin requires "Authorization: Bearer <token>" when an admin token is
+// configured, or a SAML login with the admin role. With neither an admin
+// token nor SAML, admin endpoints are off.
+func withAdmin(token string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" && sso == nil {
+			http.Error(w, "admin endpoints are off: ADMIN_TOKEN is not set", http.StatusForbidden)
+			return
+		}
+		if l, ok := sso.Login(r); ok && l.Role == roleAdmin {
+			h(w, r)
+			return
+		}
+		got := r.Header.Get("Authorization")
+		if token != "" && subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) == 1 {
+			h(w, r)
+			return
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}
+}
+
 func logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s", r.Method, r.URL.Path)
+		log.Printf("%s %s [%s]", r.Method, r.URL.Path, r.Header.Get("X-Request-ID"))
 		next.ServeHTTP(w, r)
 	})
 }
@@ -403,6 +1164,12 @@ const indexHTML = `
       text-align: center;
       margin-bottom: 8px;
     }
+    p.account {
+      text-align: right;
+      font-size: 0.85rem;
+      color: #6b7280;
+      margin: 0;
+    }
     p.subtitle {
       text-align: center;
       color: #6b7280;
@@ -452,6 +1219,12 @@ const indexHTML = `
       margin-bottom: 4px;
       display: block;
     }
+    .stats {
+      font-size: 12px;
+      color: #6b7280;
+      margin: 4px 0 0;
+      min-height: 16px;
+    }
     pre {
       background: #111827;
       color: #e5e7eb;
@@ -486,15 +1259,73 @@ const indexHTML = `
       font-size: 13px;
       margin-left: 8px;
     }
+    .tabs {
+      display: flex;
+      flex-wrap: wrap;
+      gap: 4px;
+      margin-bottom: 8px;
+    }
+    .tabs button {
+      margin: 0;
+      background: #e5e7eb;
+      color: #111827;
+      max-width: 180px;
+      overflow: hidden;
+      text-overflow: ellipsis;
+      white-space: nowrap;
+    }
+    .tabs button.active {
+      background: white;
+      box-shadow: 0 0 0 2px #2563eb inset;
+    }
+    .tabs button .close {
+      margin-left: 6px;
+      color: #6b7280;
+    }
+    .shortcuts {
+      font-size: 12px;
+      color: #6b7280;
+      text-align: center;
+    }
+    .refine {
+      display: flex;
+      gap: 6px;
+      margin-top: 8px;
+    }
+    .refine input {
+      flex: 1;
+      padding: 6px 10px;
+      border-radius: 8px;
+      border: 1px solid #ccc;
+      font-size: 13px;
+    }
+    .refine button {
+      margin: 0;
+    }
+    kbd {
+      background: #e5e7eb;
+      border-radius: 4px;
+      padding: 1px 5px;
+      font-family: inherit;
+    }
   </style>
 </head>
 <body>
+  <p class="account" id="account" hidden><span id="account-user"></span> · <a href="#" id="signout">Sign out</a></p>
   <h1>AI Text Tools</h1>
   <p class="subtitle">Summarize, extract keywords, rewrite with tone, generate questions, titles, and expansions.</p>
 
+  <div id="tabs" class="tabs"></div>
+
   <div class="card">
     <label class="label" for="input">Input text</label>
     <textarea id="input" placeholder="Paste or type some text here..."></textarea>
+    <p class="stats" id="stats"></p>
+
+    <div style="margin-top: 10px;">
+      <span class="label" style="display:inline; font-size:13px;">Or load a file (PDF, DOCX, EPUB, TXT):</span>
+      <input type="file" id="file" accept=".pdf,.docx,.epub,.txt,.md" style="margin-left: 8px; font-size: 13px;" />
+    </div>
 
     <div style="margin-top: 10px; margin-bottom: 8px;">
       <span class="label" style="display:inline; font-size:13px;">Rewrite tone:</span>
@@ -517,6 +1348,8 @@ const indexHTML = `
       <button id="btnExpand" class="secondary">Expand</button>
     </div>
 
+    <div id="customButtons" class="buttons"></div>
+
     <div id="status" class="status"></div>
   </div>
 
@@ -550,11 +1383,37 @@ const indexHTML = `
       <div class="label">Expand</div>
       <pre id="expandOutput">–</pre>
     </div>
+
+    <div class="card" id="customCard" style="display:none;">
+      <div class="label" id="customLabel">Custom</div>
+      <pre id="customOutput">–</pre>
+    </div>
+  </div>
+
+  <div class="buttons">
+    <span class="label" style="display:inline; font-size:13px;">Export results as</span>
+    <select id="exportFormat">
+      <option value="markdown">Markdown</option>
+      <option value="pdf">PDF</option>
+      <option value="docx">Word (DOCX)</option>
+    </select>
+    <button id="btnExport" class="secondary">Download</button>
   </div>
 
+  <p class="shortcuts" id="shortcuts">
+    <kbd>Ctrl</kbd>+<kbd>Enter</kbd> summarize ·
+    <kbd>Alt</kbd>+<kbd>1</kbd>–<kbd>6</kbd> run an operation ·
+    <kbd>Alt</kbd>+<kbd>N</kbd> new tab ·
+    <kbd>Alt</kbd>+<kbd>W</kbd> close tab ·
+    <kbd>Alt</kbd>+<kbd>←</kbd>/<kbd>→</kbd> switch tabs ·
+    <kbd>Esc</kbd> focus input
+  </p>
+
   <script>
     const inputEl        = document.getElementById('input');
+    const statsEl        = document.getElementById('stats');
     const toneEl         = document.getElementById('tone');
+    const fileEl         = document.getElementById('file');
     const btnSummarize   = document.getElementById('btnSummarize');
     const btnKeywords    = document.getElementById('btnKeywords');
     const btnRewrite     = document.getElementById('btnRewrite');
@@ -567,6 +1426,10 @@ const indexHTML = `
     const questionsOutput= document.getElementById('questionsOutput');
     const titlesOutput   = document.getElementById('titlesOutput');
     const expandOutput   = document.getElementById('expandOutput');
+    const customButtons  = document.getElementById('customButtons');
+    const customCard     = document.getElementById('customCard');
+    const customLabel    = document.getElementById('customLabel');
+    const customOutput   = document.getElementById('customOutput');
     const statusEl       = document.getElementById('status');
 
     const allButtons = [
@@ -583,9 +1446,25 @@ const indexHTML = `
       statusEl.textContent = isLoading ? (msg || 'Working...') : '';
     }
 
+    const API = '/api/v1';
+
+    // etagCache keeps the results that came with an ETag, so asking again
+    // for one still stored on the server gets a 304 instead of the body.
+    const etagCache = new Map();
+
+    // errorMessage extracts the message from an API error envelope.
+    async function errorMessage(res) {
+      const body = await res.text();
+      try {
+        const data = JSON.parse(body);
+        if (data.error) return data.error.message + ' (request ' + data.error.request_id + ')';
+      } catch (e) {}
+      return body;
+    }
+
     async function callAPI(path, body) {
       const text = (body && body.text) || inputEl.value.trim();
-      if (!text) {
+      if (!text && !(body && body.id)) {
         alert('Please enter some text first.');
         return null;
       }
@@ -593,16 +1472,23 @@ const indexHTML = `
       setLoading(true, 'Calling ' + path + ' ...');
 
       try {
-        const res = await fetch(path, {
-          method: 'POST',
-          headers: { 'Content-Type': 'application/json' },
-          body: JSON.stringify(body || { text }),
-        });
+        const payload = JSON.stringify(body || { text });
+        const cacheKey = path + ' ' + payload;
+        const cached = etagCache.get(cacheKey);
+        const headers = { 'Content-Type': 'application/json' };
+        if (cached) headers['If-None-Match'] = cached.etag;
+        const res = await fetch(API + path, { method: 'POST', headers, body: payload });
+        if (res.status === 304 && cached) {
+          setLoading(false);
+          return cached.data;
+        }
         if (!res.ok) {
-          const errText = await res.text();
+          const errText = await errorMessage(res);
           throw new Error('HTTP ' + res.status + ': ' + errText);
         }
         const data = await res.json();
+        const etag = res.headers.get('ETag');
+        if (etag) etagCache.set(cacheKey, { etag, data });
         setLoading(false);
         return data;
       } catch (err) {
@@ -613,56 +1499,466 @@ const indexHTML = `
       }
     }
 
+    // --- Tabs: each holds an input and its results, saved per session ---
+
+    const tabsEl = document.getElementById('tabs');
+    const outputs = {
+      summary: summaryOutput,
+      keywords: keywordsOutput,
+      rewrite: rewriteOutput,
+      questions: questionsOutput,
+      titles: titlesOutput,
+      expand: expandOutput,
+    };
+    let tabs = [];
+    let activeTab = null;
+    let saveTimer = null;
+
+    function newTab() {
+      return { id: Math.random().toString(36).slice(2, 10), title: '', text: '', tone: '', results: {} };
+    }
+
+    function currentTab() {
+      return tabs.find(t => t.id === activeTab);
+    }
+
+    function tabTitle(tab) {
+      return tab.text.trim().slice(0, 24) || 'Untitled';
+    }
+
+    function renderTabs() {
+      tabsEl.innerHTML = '';
+      tabs.forEach(tab => {
+        const btn = document.createElement('button');
+        btn.className = tab.id === activeTab ? 'active' : '';
+        btn.textContent = tab.title || tabTitle(tab);
+        btn.addEventListener('click', () => switchTab(tab.id));
+        if (tabs.length > 1) {
+          const close = document.createElement('span');
+          close.className = 'close';
+          close.textContent = '×';
+          close.addEventListener('click', e => {
+            e.stopPropagation();
+            closeTab(tab.id);
+          });
+          btn.appendChild(close);
+        }
+        tabsEl.appendChild(btn);
+      });
+      const add = document.createElement('button');
+      add.textContent = '+';
+      add.title = 'New tab (Alt+N)';
+      add.addEventListener('click', addTab);
+      tabsEl.appendChild(add);
+    }
+
+    function renderActive() {
+      const tab = currentTab();
+      inputEl.value = tab.text;
+      if (tab.tone) toneEl.value = tab.tone;
+      for (const [op, el] of Object.entries(outputs)) {
+        el.textContent = tab.results[op] || '–';
+        refineRows[op].style.display = tab.results[op + '_id'] ? '' : 'none';
+      }
+      if (tab.results.custom) {
+        customCard.style.display = '';
+        customLabel.textContent = 'Custom: ' + tab.results.custom_op;
+        customOutput.textContent = tab.results.custom;
+      } else {
+        customCard.style.display = 'none';
+      }
+      renderTabs();
+      updateStats();
+    }
+
+    // Text statistics while typing (no LLM call)
+    let statsTimer = null;
+    function updateStats() {
+      clearTimeout(statsTimer);
+      const text = inputEl.value;
+      if (!text.trim()) {
+        statsEl.textContent = '';
+        return;
+      }
+      statsTimer = setTimeout(async () => {
+        try {
+          const res = await fetch(API + '/stats', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ text }),
+          });
+          if (!res.ok || text !== inputEl.value) return;
+          const s = await res.json();
+          const read = s.reading_time_seconds < 60 ? s.reading_time_seconds + ' s' : Math.round(s.reading_time_seconds / 60) + ' min';
+          statsEl.textContent = [
+            s.words + ' words', s.sentences + ' sentences', read + ' read',
+            'Flesch ' + s.flesch_reading_ease, 'grade ' + s.flesch_kincaid_grade, 'SMOG ' + s.smog_index,
+            'diversity ' + s.lexical_diversity, Math.round(s.passive_voice_ratio * 100) + '% passive',
+          ].join(' · ');
+        } catch (err) {
+          console.error(err);
+        }
+      }, 300);
+    }
+
+    function switchTab(id) {
+      activeTab = id;
+      renderActive();
+      saveTabs();
+    }
+
+    function addTab() {
+      const tab = newTab();
+      tabs.push(tab);
+      switchTab(tab.id);
+      inputEl.focus();
+    }
+
+    function closeTab(id) {
+      if (tabs.length === 1) return;
+      const i = tabs.findIndex(t => t.id === id);
+      tabs.splice(i, 1);
+      if (activeTab === id) activeTab = tabs[Math.min(i, tabs.length - 1)].id;
+      renderActive();
+      saveTabs();
+    }
+
+    function moveTab(delta) {
+      const i = tabs.findIndex(t => t.id === activeTab);
+      switchTab(tabs[(i + delta + tabs.length) % tabs.length].id);
+    }
+
+    // setResult stores an operation's output on the tab that ran it, which
+    // may no longer be the active one, with its history id for refining.
+    function setResult(tab, op, text, id) {
+      tab.results[op] = text;
+      if (id) {
+        tab.results[op + '_id'] = id;
+      } else {
+        delete tab.results[op + '_id'];
+      }
+      if (tab.id === activeTab) renderActive();
+      saveTabs();
+    }
+
+    function saveTabs() {
+      clearTimeout(saveTimer);
+      saveTimer = setTimeout(() => {
+        fetch(API + '/tabs', {
+          method: 'PUT',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ tabs, active: activeTab }),
+        }).catch(err => console.error(err));
+      }, 800);
+    }
+
+    async function loadTabs() {
+      try {
+        const res = await fetch(API + '/tabs');
+        if (res.ok) {
+          const data = await res.json();
+          tabs = (data.tabs || []).map(t => Object.assign({ results: {} }, t));
+          activeTab = data.active;
+        }
+      } catch (err) {
+        console.error(err);
+      }
+      if (!tabs.length) tabs = [newTab()];
+      if (!currentTab()) activeTab = tabs[0].id;
+      renderActive();
+    }
+
+    inputEl.addEventListener('input', () => {
+      const tab = currentTab();
+      const before = tabTitle(tab);
+      tab.text = inputEl.value;
+      if (!tab.title && tabTitle(tab) !== before) renderTabs();
+      saveTabs();
+      updateStats();
+    });
+
+    toneEl.addEventListener('change', () => {
+      currentTab().tone = toneEl.value;
+      saveTabs();
+    });
+
+    // --- Operations ---
+
+    // formatters turn an operation's response into the text shown.
+    const list = items => items.map(x => '- ' + x).join('\n');
+    const formatters = {
+      summary: d => d.summary || '(no summary)',
+      keywords: d => Array.isArray(d.keywords) ? d.keywords.join(', ') : JSON.stringify(d, null, 2),
+      rewrite: d => d.text || '(no rewrite)',
+      questions: d => Array.isArray(d.questions) ? list(d.questions) : JSON.stringify(d, null, 2),
+      titles: d => Array.isArray(d.titles) ? list(d.titles) : JSON.stringify(d, null, 2),
+      expand: d => d.text || '(no expansion)',
+    };
+
     btnSummarize.addEventListener('click', async () => {
+      const tab = currentTab();
       const data = await callAPI('/summarize', { text: inputEl.value.trim() });
       if (!data) return;
-      summaryOutput.textContent = data.summary || '(no summary)';
+      setResult(tab, 'summary', formatters.summary(data), data.id);
     });
 
     btnKeywords.addEventListener('click', async () => {
+      const tab = currentTab();
       const data = await callAPI('/keywords', { text: inputEl.value.trim() });
       if (!data) return;
-      if (Array.isArray(data.keywords)) {
-        keywordsOutput.textContent = data.keywords.join(', ');
-      } else {
-        keywordsOutput.textContent = JSON.stringify(data, null, 2);
-      }
+      setResult(tab, 'keywords', formatters.keywords(data), data.id);
     });
 
     btnRewrite.addEventListener('click', async () => {
+      const tab = currentTab();
       const data = await callAPI('/rewrite', {
         text: inputEl.value.trim(),
         tone: toneEl.value,
       });
       if (!data) return;
-      rewriteOutput.textContent = data.text || '(no rewrite)';
+      setResult(tab, 'rewrite', formatters.rewrite(data), data.id);
     });
 
     btnQuestions.addEventListener('click', async () => {
+      const tab = currentTab();
       const data = await callAPI('/questions', { text: inputEl.value.trim() });
       if (!data) return;
-      if (Array.isArray(data.questions)) {
-        questionsOutput.textContent = data.questions.map(q => '- ' + q).join('\n');
-      } else {
-        questionsOutput.textContent = JSON.stringify(data, null, 2);
-      }
+      setResult(tab, 'questions', formatters.questions(data), data.id);
     });
 
     btnTitles.addEventListener('click', async () => {
+      const tab = currentTab();
       const data = await callAPI('/titles', { text: inputEl.value.trim() });
       if (!data) return;
-      if (Array.isArray(data.titles)) {
-        titlesOutput.textContent = data.titles.map(t => '- ' + t).join('\n');
-      } else {
-        titlesOutput.textContent = JSON.stringify(data, null, 2);
-      }
+      setResult(tab, 'titles', formatters.titles(data), data.id);
     });
 
     btnExpand.addEventListener('click', async () => {
-      const data = await callAPI('/expand', { text: inputEl.value.trim() });
+      const tab = currentTab();
+      // expansions are long: reuse the stored one for the same text
+      const data = await callAPI('/expand', { text: inputEl.value.trim(), reuse: true });
       if (!data) return;
-      expandOutput.textContent = data.text || '(no expansion)';
+      setResult(tab, 'expand', formatters.expand(data), data.id);
+    });
+
+    // Refine: revise a result with feedback (POST /regenerate), as often as
+    // needed, or rate it (POST /feedback). Results that weren't stored in
+    // history can't be refined or rated.
+    const refineRows = {};
+    for (const [op, el] of Object.entries(outputs)) {
+      const row = document.createElement('div');
+      row.className = 'refine';
+      row.style.display = 'none';
+      const feedback = document.createElement('input');
+      feedback.placeholder = 'Feedback, e.g. "too long" or "keep the second point"';
+      const btn = document.createElement('button');
+      btn.className = 'secondary';
+      btn.textContent = 'Refine';
+      const run = async () => {
+        const tab = currentTab();
+        if (!feedback.value.trim()) return;
+        const data = await callAPI('/regenerate', { id: tab.results[op + '_id'], feedback: feedback.value.trim() });
+        if (!data) return;
+        feedback.value = '';
+        setResult(tab, op, formatters[op](data), data.id);
+      };
+      btn.addEventListener('click', run);
+      feedback.addEventListener('keydown', e => {
+        if (e.key === 'Enter') run();
+      });
+      row.appendChild(feedback);
+      row.appendChild(btn);
+      for (const [rating, label] of [['up', '👍'], ['down', '👎']]) {
+        const rate = document.createElement('button');
+        rate.className = 'secondary';
+        rate.textContent = label;
+        rate.title = 'Rate this result (comment: the text in the feedback box)';
+        rate.addEventListener('click', async () => {
+          const tab = currentTab();
+          try {
+            const res = await fetch(API + '/feedback', {
+              method: 'POST',
+              headers: { 'Content-Type': 'application/json' },
+              body: JSON.stringify({ result_id: tab.results[op + '_id'], rating, comment: feedback.value.trim() }),
+            });
+            if (!res.ok) throw new Error('HTTP ' + res.status + ': ' + await errorMessage(res));
+            statusEl.textContent = 'Thanks for the feedback.';
+          } catch (err) {
+            console.error(err);
+            alert('Error: ' + err.message);
+          }
+        });
+        row.appendChild(rate);
+      }
+      el.after(row);
+      refineRows[op] = row;
+      allButtons.push(btn);
+    }
+
+    fileEl.addEventListener('change', async () => {
+      const file = fileEl.files[0];
+      if (!file) return;
+      const tab = currentTab();
+      const form = new FormData();
+      form.append('file', file);
+      setLoading(true, 'Extracting text from ' + file.name + ' ...');
+      try {
+        const res = await fetch(API + '/upload', { method: 'POST', body: form });
+        if (!res.ok) {
+          const errText = await errorMessage(res);
+          throw new Error('HTTP ' + res.status + ': ' + errText);
+        }
+        const data = await res.json();
+        tab.text = data.text || '';
+        tab.title = file.name;
+        if (tab.id === activeTab) renderActive();
+        saveTabs();
+        setLoading(false);
+      } catch (err) {
+        console.error(err);
+        alert('Error: ' + err.message);
+        setLoading(false);
+      }
+      fileEl.value = '';
     });
+
+    async function loadCustomOperations() {
+      try {
+        const res = await fetch(API + '/operations');
+        if (!res.ok) return;
+        const data = await res.json();
+        (data.operations || []).forEach(op => {
+          const btn = document.createElement('button');
+          btn.className = 'secondary';
+          btn.textContent = op.name;
+          if (op.description) btn.title = op.description;
+          btn.addEventListener('click', async () => {
+            const tab = currentTab();
+            const data = await callAPI('/custom/' + op.name, { text: inputEl.value.trim() });
+            if (!data) return;
+            let text;
+            if (typeof data.result === 'string') {
+              text = data.result;
+            } else if (Array.isArray(data.result)) {
+              text = data.result.map(x => '- ' + x).join('\n');
+            } else {
+              text = JSON.stringify(data.result, null, 2);
+            }
+            tab.results.custom_op = op.name;
+            setResult(tab, 'custom', text);
+          });
+          customButtons.appendChild(btn);
+          allButtons.push(btn);
+        });
+      } catch (err) {
+        console.error(err);
+      }
+    }
+    loadCustomOperations();
+
+    // --- Export ---
+
+    const exportLabels = {
+      summary: 'Summary',
+      keywords: 'Keywords',
+      rewrite: 'Rewrite',
+      questions: 'Questions',
+      titles: 'Titles',
+      expand: 'Expand',
+    };
+
+    document.getElementById('btnExport').addEventListener('click', async () => {
+      const tab = currentTab();
+      const results = Object.keys(exportLabels)
+        .filter(op => tab.results[op])
+        .map(op => ({ title: exportLabels[op], result: tab.results[op] }));
+      if (tab.results.custom) {
+        results.push({ title: tab.results.custom_op, result: tab.results.custom });
+      }
+      if (!results.length) {
+        alert('Run an operation first.');
+        return;
+      }
+      setLoading(true, 'Exporting ...');
+      try {
+        const res = await fetch(API + '/export', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ format: document.getElementById('exportFormat').value, title: tab.title || tabTitle(tab), results }),
+        });
+        if (!res.ok) {
+          const errText = await errorMessage(res);
+          throw new Error('HTTP ' + res.status + ': ' + errText);
+        }
+        const name = /filename="?([^"]+)"?/.exec(res.headers.get('Content-Disposition') || '');
+        const a = document.createElement('a');
+        a.href = URL.createObjectURL(await res.blob());
+        a.download = name ? name[1] : 'results';
+        a.click();
+        URL.revokeObjectURL(a.href);
+        setLoading(false);
+      } catch (err) {
+        console.error(err);
+        alert('Error: ' + err.message);
+        setLoading(false);
+      }
+    });
+
+    // --- Keyboard shortcuts ---
+
+    document.addEventListener('keydown', e => {
+      if ((e.ctrlKey || e.metaKey) && e.key === 'Enter') {
+        e.preventDefault();
+        if (!btnSummarize.disabled) btnSummarize.click();
+        return;
+      }
+      if (e.key === 'Escape') {
+        inputEl.focus();
+        return;
+      }
+      if (!e.altKey || e.ctrlKey || e.metaKey) return;
+
+      const digit = /^Digit([1-6])$/.exec(e.code);
+      if (digit) {
+        const btn = allButtons[Number(digit[1]) - 1];
+        if (!btn.disabled) btn.click();
+      } else if (e.code === 'KeyN') {
+        addTab();
+      } else if (e.code === 'KeyW') {
+        closeTab(activeTab);
+      } else if (e.key === 'ArrowRight') {
+        moveTab(1);
+      } else if (e.key === 'ArrowLeft') {
+        moveTab(-1);
+      } else {
+        return;
+      }
+      e.preventDefault();
+    });
+
+    // --- SAML login ---
+
+    fetch(API + '/me')
+      .then(res => res.json())
+      .then(me => {
+        if (!me.sso) return;
+        document.getElementById('account-user').textContent = me.user + (me.role === 'admin' ? ' (admin)' : '');
+        document.getElementById('account').hidden = false;
+      })
+      .catch(err => console.error(err));
+    document.getElementById('signout').addEventListener('click', e => {
+      e.preventDefault();
+      fetch('/saml/logout', { method: 'POST' }).finally(() => location.reload());
+    });
+
+    loadTabs().then(() => fetch(API + '/preferences'))
+      .then(res => res.ok ? res.json() : null)
+      .then(data => {
+        if (data && data.preferences && data.preferences.tone && !currentTab().tone) {
+          toneEl.value = data.preferences.tone;
+        }
+      })
+      .catch(err => console.error(err));
   </script>
 </body>
 </html>
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+func TestWithAdmin(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	tests := []struct {
+		name, token, auth string
+		want              int
+	}{
+		{"no admin credential configured", "", "", http.StatusForbidden},
+		{"no admin credential configured, any bearer", "", "Bearer ", http.StatusForbidden},
+		{"token", "secret", "Bearer secret", http.StatusOK},
+		{"wrong token", "secret", "Bearer guess", http.StatusUnauthorized},
+		{"no token", "secret", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/v1/admin/config", nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		w := httptest.NewRecorder()
+		withAdmin(tt.token, ok)(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestMockProvider(t *testing.T) {
+	t.Setenv("MOCK_LATENCY", "5ms")
+	t.Setenv("MOCK_ERROR_EVERY", "4")
+	p, err := newProvider("mock", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := p.(*texttools.Mock); !ok || m.Latency != 5*time.Millisecond || m.ErrorEvery != 4 {
+		t.Errorf("PROVIDER=mock: %#v", p)
+	}
+
+	t.Setenv("MOCK_LATENCY", "soon")
+	if _, err := newProvider("mock", nil); err == nil || !strings.Contains(err.Error(), "MOCK_LATENCY") {
+		t.Errorf("invalid MOCK_LATENCY: %v", err)
+	}
+}
+
+func TestMockOperationsDeterministic(t *testing.T) {
+	req := RewriteRequest{Text: "The quarterly report shows revenue growth of twelve percent, driven by strong subscription sales.", Tone: "formal"}
+	for _, op := range builtinOperations {
+		var results []interface{}
+		for i := 0; i < 2; i++ {
+			// a new provider each time: the answer mustn't depend on earlier calls
+			result, err := runOperation(context.Background(), texttools.New(&texttools.Mock{}), op, req)
+			if err != nil {
+				t.Fatalf("%s: %v", op, err)
+			}
+			results = append(results, result)
+		}
+		if !reflect.DeepEqual(results[0], results[1]) {
+			t.Errorf("%s: %+v, then %+v", op, results[0], results[1])
+		}
+		if reflect.ValueOf(results[0]).IsZero() {
+			t.Errorf("%s: empty result", op)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Plain-language medical explanations ---
+
+// medicalDisclaimer is appended to every /plain-medical response regardless
+// of what the model returned.
+const medicalDisclaimer = "This explanation is for general information only and is not medical advice. " +
+	"It does not replace a consultation with a doctor, pharmacist or other qualified health professional. " +
+	"Do not start, stop or change any medication or treatment based on it. In an emergency, call your local emergency number."
+
+// mandatoryCaveats are always included, in addition to the model's own.
+var mandatoryCaveats = []string{
+	"Ask a doctor or pharmacist about doses — this tool does not give dosage advice.",
+}
+
+const dosageRemoved = "[Dosage information removed — ask your doctor or pharmacist about dosing.]"
+
+// dosageRe matches amounts that look like doses ("500 mg", "2 tablets", "10ml").
+var dosageRe = regexp.MustCompile(`(?i)\b\d+(?:[.,]\d+)?\s?(?:mg|mcg|µg|g|ml|units?|iu|tablets?|pills?|capsules?|drops?|puffs?)\b`)
+
+type MedicalResponse struct {
+	Explanation   string   `json:"explanation"`
+	Caveats       []string `json:"caveats"`
+	Disclaimer    string   `json:"disclaimer"`
+	DosageRemoved bool     `json:"dosage_removed"`
+}
+
+func plainMedicalHandler(tools *texttools.Tools, prefs *PreferenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TextRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		in := texttools.Input{Text: texttools.PrepareInput(req.Text, req.InputFormat), Options: req.Options.Options}
+		out, err := tools.Prompt(r.Context(), "plain-medical", in, in.Options)
+		if err != nil {
+			log.Println("plain-medical error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var resp MedicalResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			// fallback – treat the whole output as the explanation
+			resp = MedicalResponse{Explanation: out}
+		}
+
+		var removed bool
+		resp.Explanation, removed = stripDosage(resp.Explanation)
+		resp.DosageRemoved = removed
+		caveats := make([]string, 0, len(resp.Caveats)+len(mandatoryCaveats))
+		for _, c := range resp.Caveats {
+			c, removed = stripDosage(c)
+			resp.DosageRemoved = resp.DosageRemoved || removed
+			caveats = append(caveats, c)
+		}
+		resp.Caveats = append(caveats, mandatoryCaveats...)
+		resp.Disclaimer = medicalDisclaimer
+
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// stripDosage replaces every sentence that mentions a dose with a referral
+// to a professional. It reports whether anything was removed.
+func stripDosage(s string) (string, bool) {
+	if !dosageRe.MatchString(s) {
+		return s, false
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if !dosageRe.MatchString(line) {
+			continue
+		}
+		sentences := splitSentences(line)
+		for j, sent := range sentences {
+			if dosageRe.MatchString(sent) {
+				sentences[j] = dosageRemoved
+			}
+		}
+		lines[i] = strings.Join(sentences, " ")
+	}
+	return strings.Join(lines, "\n"), true
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Meeting minutes ---
+//
+// POST /meeting turns a transcript into minutes: a summary, the decisions,
+// the action items with their owners, and the questions left open.
+// Transcripts are "Speaker: text" lines, as exported by most meeting tools,
+// or WebVTT and SRT captions, whose cue numbers and timings are dropped.
+//
+// Transcripts of more than maxSummaryWords words, about 45 minutes of
+// talk, are processed in parts; the minutes of the parts are then merged
+// into one, so decisions revised later in the meeting and questions
+// answered later don't end up in the minutes twice.
+
+type MeetingRequest struct {
+	Text  string `json:"text"`  // the transcript
+	Title string `json:"title"` // of the meeting, if known
+	Options
+}
+
+type MeetingResponse struct {
+	Summary       string       `json:"summary"`
+	Decisions     []string     `json:"decisions"`
+	ActionItems   []ActionItem `json:"action_items"`
+	OpenQuestions []string     `json:"open_questions"`
+	Speakers      []string     `json:"speakers"` // in order of first appearance; [] without speaker labels
+	Parts         int          `json:"parts"`    // how many parts the transcript was processed in
+	ResultMeta
+}
+
+type ActionItem struct {
+	Task  string `json:"task"`
+	Owner string `json:"owner"`         // "" if nobody took it
+	Due   string `json:"due,omitempty"` // as said, e.g. "Friday"
+}
+
+// meetingMinutes is what the model returns for a transcript or a part.
+type meetingMinutes struct {
+	Summary       string       `json:"summary"`
+	Decisions     []string     `json:"decisions"`
+	ActionItems   []ActionItem `json:"action_items"`
+	OpenQuestions []string     `json:"open_questions"`
+}
+
+// meetingPromptInput is the data of the meeting and meeting-merge
+// templates.
+type meetingPromptInput struct {
+	texttools.Input
+	Title    string
+	Speakers string
+	Part     int // 1-based; 0 when the transcript is in one part
+	Parts    int
+}
+
+var (
+	captionTimingRe = regexp.MustCompile(`^\d{1,2}:\d{2}(?::\d{2})?[.,]\d{3}\s+-->\s+`)
+	captionIndexRe  = regexp.MustCompile(`^\d+$`)
+	vttVoiceRe      = regexp.MustCompile(`^<v(?:\.[^ >]*)?\s+([^>]+)>`)
+	vttTagRe        = regexp.MustCompile(`</?[a-z][^>]*>`)
+)
+
+// normalizeTranscript strips WebVTT and SRT captions down to their text,
+// with <v Speaker> voice tags as "Speaker: " labels, and returns the
+// transcript with consecutive lines of a speaker joined, and the speakers.
+func normalizeTranscript(text string) (string, []string) {
+	var lines []string
+	skip := false // in a WebVTT NOTE, STYLE or REGION block
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			skip = false
+			continue
+		case skip:
+			continue
+		case strings.HasPrefix(line, "WEBVTT"):
+			continue
+		case line == "NOTE" || strings.HasPrefix(line, "NOTE ") || line == "STYLE" || line == "REGION":
+			skip = true
+			continue
+		case captionIndexRe.MatchString(line) || captionTimingRe.MatchString(line):
+			continue
+		}
+		line = vttVoiceRe.ReplaceAllString(line, "$1: ")
+		lines = append(lines, strings.TrimSpace(vttTagRe.ReplaceAllString(line, "")))
+	}
+	text = strings.Join(lines, "\n")
+
+	msgs := parseLineChat(text, parsePlainLine)
+	if len(msgs) == 0 {
+		return text, []string{}
+	}
+	// captions split what a speaker says into many cues
+	merged := msgs[:1]
+	for _, m := range msgs[1:] {
+		if last := &merged[len(merged)-1]; last.Author == m.Author {
+			last.Text += " " + m.Text
+			continue
+		}
+		merged = append(merged, m)
+	}
+	return chatTranscript(merged), chatParticipants(merged)
+}
+
+func meetingHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MeetingRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey(req.Title, req.Options)
+		if prior, ok := history.Reusable(r, "meeting", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		transcript, speakers := normalizeTranscript(req.Text)
+		if strings.TrimSpace(transcript) == "" {
+			http.Error(w, "the transcript has no text", http.StatusUnprocessableEntity)
+			return
+		}
+		parts := groupWords(strings.Split(strings.TrimSpace(transcript), "\n"), maxSummaryWords)
+		minutes, err := meetingMinutesOf(r.Context(), tools, parts, meetingPromptInput{
+			Input:    texttools.Input{Options: req.Options.Options},
+			Title:    req.Title,
+			Speakers: strings.Join(speakers, ", "),
+		})
+		if err != nil {
+			log.Println("meeting error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		resp := MeetingResponse{
+			Summary:       texttools.FormatOutput(tools.FitWords(r.Context(), minutes.Summary, req.Options.Options), req.OutputFormat),
+			Decisions:     minutes.Decisions,
+			ActionItems:   minutes.ActionItems,
+			OpenQuestions: minutes.OpenQuestions,
+			Speakers:      speakers,
+			Parts:         len(parts),
+		}
+		if resp.Decisions == nil {
+			resp.Decisions = []string{}
+		}
+		if resp.ActionItems == nil {
+			resp.ActionItems = []ActionItem{}
+		}
+		if resp.OpenQuestions == nil {
+			resp.OpenQuestions = []string{}
+		}
+
+		resp.Summary = outputWrappers.Wrap("meeting", resp.Summary, req.Options)
+		resp.ResultMeta = history.Record(r, "meeting", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// meetingMinutesOf takes the minutes of each part of a transcript, then,
+// if there are several, merges them.
+func meetingMinutesOf(ctx context.Context, tools *texttools.Tools, parts []string, in meetingPromptInput) (meetingMinutes, error) {
+	in.Parts = len(parts)
+	all := make([]meetingMinutes, 0, len(parts))
+	for i, part := range parts {
+		in.Text = part
+		if len(parts) > 1 {
+			in.Part = i + 1
+		}
+		m, err := promptMinutes(ctx, tools, "meeting", in)
+		if err != nil {
+			return meetingMinutes{}, err
+		}
+		all = append(all, m)
+	}
+	if len(all) == 1 {
+		return all[0], nil
+	}
+
+	var sb strings.Builder
+	for i, m := range all {
+		b, _ := json.MarshalIndent(m, "", "  ")
+		fmt.Fprintf(&sb, "### Part %d of %d\n\n%s\n\n", i+1, len(all), b)
+	}
+	in.Text, in.Part = sb.String(), 0
+	return promptMinutes(ctx, tools, "meeting-merge", in)
+}
+
+func promptMinutes(ctx context.Context, tools *texttools.Tools, name string, in meetingPromptInput) (meetingMinutes, error) {
+	out, err := tools.Prompt(ctx, name, in, in.Options)
+	if err != nil {
+		return meetingMinutes{}, err
+	}
+	var m meetingMinutes
+	if err := json.Unmarshal([]byte(out), &m); err != nil {
+		// fallback – treat the whole output as the summary
+		m = meetingMinutes{Summary: out}
+	}
+	return m, nil
+}
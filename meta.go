@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Response metadata ---
+//
+// With ?include_meta=true, JSON responses carry a "meta" object about how
+// they were produced: the model, the tokens of all LLM calls made, the
+// latency, the finish reason and whether the result came from history, so
+// API consumers can log and reconcile costs per call.
+
+// ResponseMeta is the "meta" of a response.
+type ResponseMeta struct {
+	Model            string `json:"model,omitempty"`
+	Provider         string `json:"provider,omitempty"`
+	LLMCalls         int    `json:"llm_calls"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	UsageEstimated   bool   `json:"usage_estimated,omitempty"` // the provider reported no usage for some calls
+	LatencyMS        int64  `json:"latency_ms"`                // of the whole request
+	LLMLatencyMS     int64  `json:"llm_latency_ms"`            // spent in LLM calls
+	FinishReason     string `json:"finish_reason,omitempty"`   // of the last call: stop, length, ...
+	Cache            string `json:"cache"`                     // hit if the result was reused from history, else miss
+}
+
+type metaKey struct{}
+
+// metaSlot collects the LLM calls of a request, or of a part of it (see
+// withMetaSlot).
+type metaSlot struct {
+	parent *metaSlot // also gets the calls, if set
+
+	mu   sync.Mutex
+	meta ResponseMeta
+}
+
+// withMetaSlot returns a context whose LLM calls are collected in a slot of
+// their own as well as in the request's.
+func withMetaSlot(ctx context.Context) (context.Context, *metaSlot) {
+	parent, _ := ctx.Value(metaKey{}).(*metaSlot)
+	slot := &metaSlot{parent: parent}
+	return context.WithValue(ctx, metaKey{}, slot), slot
+}
+
+// recordCall adds an LLM call to the meta of the request of ctx, if it
+// asked for it.
+func recordCall(ctx context.Context, model string, c metaCall) {
+	slot, _ := ctx.Value(metaKey{}).(*metaSlot)
+	for ; slot != nil; slot = slot.parent {
+		slot.record(model, c)
+	}
+}
+
+func (slot *metaSlot) record(model string, c metaCall) {
+	slot.mu.Lock()
+	defer slot.mu.Unlock()
+	m := &slot.meta
+	m.LLMCalls++
+	m.PromptTokens += c.PromptTokens
+	m.CompletionTokens += c.CompletionTokens
+	m.UsageEstimated = m.UsageEstimated || c.Estimated
+	m.LLMLatencyMS += c.Latency.Milliseconds()
+	if model != "" {
+		m.Model = model
+	}
+	if c.FinishReason != "" {
+		m.FinishReason = c.FinishReason
+	}
+}
+
+// metaCall is what meta records of an LLM call.
+type metaCall struct {
+	PromptTokens, CompletionTokens int
+	Estimated                      bool
+	Latency                        time.Duration
+	FinishReason                   string
+}
+
+// withResponseMeta adds the meta to the JSON object responses of requests
+// with ?include_meta=true. The provider and default model come from
+// provenance.
+func withResponseMeta(source *ProvenanceSource, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("include_meta") != "true" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		slot := &metaSlot{}
+		ctx := context.WithValue(r.Context(), metaKey{}, slot)
+		mw := &metaWriter{ResponseWriter: w}
+		next.ServeHTTP(mw, r.WithContext(ctx))
+		if !mw.buffered {
+			return
+		}
+
+		slot.mu.Lock()
+		meta := slot.meta
+		slot.mu.Unlock()
+		if provider, model, _, ok := servedModel(ctx); ok {
+			meta.Provider, meta.Model = provider, model
+		} else if source != nil {
+			meta.Provider = source.Provider
+			if meta.Model == "" {
+				meta.Model = source.DefaultModel
+			}
+		}
+		if meta.LLMCalls == 0 {
+			meta.Model, meta.Provider = "", ""
+		}
+		meta.TotalTokens = meta.PromptTokens + meta.CompletionTokens
+		meta.LatencyMS = time.Since(start).Milliseconds()
+		meta.Cache = "miss"
+		var reused struct {
+			Reused bool `json:"reused"`
+		}
+		if json.Unmarshal(mw.body.Bytes(), &reused) == nil && reused.Reused {
+			meta.Cache = "hit"
+		}
+
+		w.Header().Del("Content-Length")
+		w.WriteHeader(http.StatusOK)
+		w.Write(withJSONField(mw.body.Bytes(), "meta", meta))
+	})
+}
+
+// withJSONField adds the field name with value v to a JSON object, keeping
+// its fields as they are.
+func withJSONField(body []byte, name string, v interface{}) []byte {
+	m, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	trimmed := bytes.TrimRight(body, " \n")
+	obj := bytes.TrimSuffix(trimmed, []byte("}"))
+	if len(obj) == len(trimmed) {
+		return body
+	}
+	var out bytes.Buffer
+	out.Write(obj)
+	if len(bytes.TrimSpace(obj)) > 1 {
+		out.WriteByte(',')
+	}
+	n, _ := json.Marshal(name)
+	out.Write(n)
+	out.WriteByte(':')
+	out.Write(m)
+	out.WriteString("}\n")
+	return out.Bytes()
+}
+
+// metaWriter buffers successful JSON object responses so withResponseMeta
+// can add the meta (and withEvaluation the evaluation); everything else (errors, streams, files) passes straight
+// through.
+type metaWriter struct {
+	http.ResponseWriter
+	decided  bool
+	buffered bool
+	body     bytes.Buffer
+}
+
+func (w *metaWriter) WriteHeader(status int) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	if status == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.buffered = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *metaWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffered {
+		if trimmed := bytes.TrimSpace(b); w.body.Len() == 0 && len(trimmed) > 0 && trimmed[0] != '{' {
+			// not an object after all
+			w.buffered = false
+			w.ResponseWriter.WriteHeader(http.StatusOK)
+			return w.ResponseWriter.Write(b)
+		}
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *metaWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.buffered {
+		f.Flush()
+	}
+}
+
+func (w *metaWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
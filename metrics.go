@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Metrics ---
+//
+// In-memory counters for the admin dashboard: requests per route, LLM calls
+// and token usage (against DAILY_TOKEN_BUDGET, if set), the average
+// evaluation scores per endpoint (see evaluate.go) and the most recent
+// errors. Everything resets on restart.
+
+const maxRecentErrors = 50
+
+var metrics = newMetrics()
+
+type RouteStats struct {
+	Route        string  `json:"route"`
+	Requests     int     `json:"requests"`
+	ClientErrors int     `json:"client_errors"` // 4xx
+	ServerErrors int     `json:"server_errors"` // 5xx
+	AvgMillis    float64 `json:"avg_ms"`
+	Users        int     `json:"users"` // distinct callers
+
+	totalMillis float64
+	users       map[string]bool
+}
+
+type ErrorEntry struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"` // route, or "llm"
+	Status  int       `json:"status,omitempty"`
+	Message string    `json:"message"`
+}
+
+type LLMStats struct {
+	Calls            int `json:"calls"`
+	Errors           int `json:"errors"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	Coalesced        int `json:"coalesced"` // calls answered by an identical one in flight
+}
+
+// QualityStats are the average evaluation scores of an endpoint.
+type QualityStats struct {
+	Endpoint     string  `json:"endpoint"`
+	Evaluations  int     `json:"evaluations"`
+	Faithfulness float64 `json:"faithfulness"`
+	Completeness float64 `json:"completeness"`
+	Fluency      float64 `json:"fluency"`
+	Overall      float64 `json:"overall"`
+}
+
+type BudgetStats struct {
+	Day       string  `json:"day"` // UTC
+	Used      int     `json:"used"`
+	Limit     int     `json:"limit,omitempty"` // 0 = no budget
+	UsedRatio float64 `json:"used_ratio,omitempty"`
+}
+
+type Metrics struct {
+	start time.Time
+
+	// set up before serving
+	privacy     UsagePrivacy
+	privateOnly bool
+	noiseKey    []byte
+
+	mu       sync.Mutex
+	routes   map[string]*RouteStats
+	users    map[string]bool
+	errors   []ErrorEntry // newest last
+	llm      LLMStats
+	quality  map[string]*QualityStats // sums until Snapshot
+	day      string
+	dayUsed  int
+	dayLimit int
+}
+
+func newMetrics() *Metrics {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &Metrics{
+		start:    time.Now(),
+		privacy:  UsagePrivacy{MinUsers: 5, Epsilon: 1},
+		noiseKey: key,
+		routes:   map[string]*RouteStats{},
+		users:    map[string]bool{},
+		quality:  map[string]*QualityStats{},
+	}
+}
+
+// SetDailyBudget sets the daily token budget shown on the dashboard.
+func (m *Metrics) SetDailyBudget(tokens int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dayLimit = tokens
+}
+
+// Middleware counts requests by the routes pattern they match and keeps the
+// message of error responses.
+func (m *Metrics) Middleware(routes *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := routes.Handler(r)
+		if route == "" {
+			route = "(unmatched)"
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		rs, ok := m.routes[route]
+		if !ok {
+			rs = &RouteStats{Route: route, users: map[string]bool{}}
+			m.routes[route] = rs
+		}
+		user := userID(r)
+		m.users[user] = true
+		rs.users[user] = true
+		rs.Requests++
+		rs.totalMillis += float64(time.Since(start).Microseconds()) / 1000
+		switch {
+		case rec.status >= 500:
+			rs.ServerErrors++
+		case rec.status >= 400:
+			rs.ClientErrors++
+		}
+		if rec.status >= 400 && !privacyMode(r.Context()) {
+			m.addErrorLocked(ErrorEntry{Time: start, Source: r.Method + " " + r.URL.Path, Status: rec.status, Message: strings.TrimSpace(rec.body.String())})
+		}
+	})
+}
+
+// RecordLLM counts a provider call and its token usage.
+func (m *Metrics) RecordLLM(usage texttools.Usage, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.llm.Calls++
+	if err != nil {
+		m.llm.Errors++
+		m.addErrorLocked(ErrorEntry{Time: time.Now(), Source: "llm", Message: truncate(err.Error(), 500)})
+		return
+	}
+	m.llm.PromptTokens += usage.PromptTokens
+	m.llm.CompletionTokens += usage.CompletionTokens
+
+	today := time.Now().UTC().Format("2006-01-02")
+	if m.day != today {
+		m.day, m.dayUsed = today, 0
+	}
+	m.dayUsed += usage.PromptTokens + usage.CompletionTokens
+}
+
+// RecordCoalesced counts a call that got the completion of an identical
+// one instead of calling the provider.
+func (m *Metrics) RecordCoalesced() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.llm.Coalesced++
+}
+
+// RecordEvaluation adds the scores of an evaluated result of endpoint.
+func (m *Metrics) RecordEvaluation(endpoint string, e Evaluation) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	q, ok := m.quality[endpoint]
+	if !ok {
+		q = &QualityStats{Endpoint: endpoint}
+		m.quality[endpoint] = q
+	}
+	q.Evaluations++
+	q.Faithfulness += float64(e.Faithfulness.Score)
+	q.Completeness += float64(e.Completeness.Score)
+	q.Fluency += float64(e.Fluency.Score)
+	q.Overall += e.Overall
+}
+
+func (m *Metrics) addErrorLocked(e ErrorEntry) {
+	m.errors = append(m.errors, e)
+	if len(m.errors) > maxRecentErrors {
+		m.errors = append([]ErrorEntry(nil), m.errors[len(m.errors)-maxRecentErrors:]...)
+	}
+}
+
+type MetricsSnapshot struct {
+	Uptime       string         `json:"uptime"`
+	Users        int            `json:"users"` // distinct callers
+	Routes       []RouteStats   `json:"routes"`
+	LLM          LLMStats       `json:"llm"`
+	Budget       BudgetStats    `json:"budget"`
+	Quality      []QualityStats `json:"quality"`           // of evaluated results
+	RecentErrors []ErrorEntry   `json:"recent_errors"`     // newest first
+	Private      *UsagePrivacy  `json:"private,omitempty"` // set when privately aggregated
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := MetricsSnapshot{
+		Uptime:       time.Since(m.start).Round(time.Second).String(),
+		Users:        len(m.users),
+		Routes:       []RouteStats{},
+		LLM:          m.llm,
+		Quality:      []QualityStats{},
+		RecentErrors: []ErrorEntry{},
+	}
+	for _, rs := range m.routes {
+		cp := *rs
+		cp.AvgMillis = rs.totalMillis / float64(rs.Requests)
+		cp.Users = len(rs.users)
+		s.Routes = append(s.Routes, cp)
+	}
+	sort.Slice(s.Routes, func(i, j int) bool { return s.Routes[i].Requests > s.Routes[j].Requests })
+	for _, q := range m.quality {
+		n := float64(q.Evaluations)
+		s.Quality = append(s.Quality, QualityStats{
+			Endpoint:     q.Endpoint,
+			Evaluations:  q.Evaluations,
+			Faithfulness: math.Round(q.Faithfulness/n*100) / 100,
+			Completeness: math.Round(q.Completeness/n*100) / 100,
+			Fluency:      math.Round(q.Fluency/n*100) / 100,
+			Overall:      math.Round(q.Overall/n*100) / 100,
+		})
+	}
+	sort.Slice(s.Quality, func(i, j int) bool { return s.Quality[i].Evaluations > s.Quality[j].Evaluations })
+	for i := len(m.errors) - 1; i >= 0; i-- {
+		s.RecentErrors = append(s.RecentErrors, m.errors[i])
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	s.Budget = BudgetStats{Day: today, Limit: m.dayLimit}
+	if m.day == today {
+		s.Budget.Used = m.dayUsed
+	}
+	if m.dayLimit > 0 {
+		s.Budget.UsedRatio = float64(s.Budget.Used) / float64(m.dayLimit)
+	}
+	return s
+}
+
+// --- Private aggregation ---
+//
+// A private snapshot can be shared org-wide without exposing what
+// individual users did: routes used by fewer than MinUsers distinct users
+// are left out (everything is, below MinUsers users in total), counts get
+// Laplace noise of scale 1/Epsilon and recent errors and evaluation scores
+// are dropped. Token
+// totals get noise scaled by the tokens of an average call. The noise is
+// derived from the true value and a per-process key, so asking again returns
+// the same number instead of averaging it away. This is DP-style, not a
+// formal guarantee: how much one user contributes isn't bounded.
+
+type UsagePrivacy struct {
+	MinUsers int     `json:"min_users"`
+	Epsilon  float64 `json:"epsilon"`
+}
+
+// SetUsagePrivacy configures private snapshots; with always, /admin/stats
+// only serves those.
+func (m *Metrics) SetUsagePrivacy(p UsagePrivacy, always bool) {
+	m.privacy, m.privateOnly = p, always
+}
+
+// PrivateOnly reports whether only private snapshots may be served.
+func (m *Metrics) PrivateOnly() bool { return m.privateOnly }
+
+// Private aggregates s for sharing (see above).
+func (m *Metrics) Private(s MetricsSnapshot) MetricsSnapshot {
+	p := m.privacy
+	out := MetricsSnapshot{
+		Uptime:       s.Uptime,
+		Routes:       []RouteStats{},
+		Budget:       BudgetStats{Day: s.Budget.Day, Limit: s.Budget.Limit},
+		Quality:      []QualityStats{},
+		RecentErrors: []ErrorEntry{},
+		Private:      &p,
+	}
+	if s.Users < p.MinUsers {
+		return out
+	}
+
+	scale := 1 / p.Epsilon
+	out.Users = m.noisy("users", s.Users, scale)
+	for _, rs := range s.Routes {
+		if rs.Users < p.MinUsers {
+			continue
+		}
+		out.Routes = append(out.Routes, RouteStats{
+			Route:        rs.Route,
+			Requests:     m.noisy(rs.Route+" requests", rs.Requests, scale),
+			ClientErrors: m.noisy(rs.Route+" client_errors", rs.ClientErrors, scale),
+			ServerErrors: m.noisy(rs.Route+" server_errors", rs.ServerErrors, scale),
+			AvgMillis:    rs.AvgMillis,
+			Users:        m.noisy(rs.Route+" users", rs.Users, scale),
+		})
+	}
+	sort.Slice(out.Routes, func(i, j int) bool { return out.Routes[i].Requests > out.Routes[j].Requests })
+
+	tokenScale := scale
+	if s.LLM.Calls > 0 {
+		tokenScale = scale * float64(s.LLM.PromptTokens+s.LLM.CompletionTokens) / float64(s.LLM.Calls)
+	}
+	out.LLM = LLMStats{
+		Calls:            m.noisy("llm calls", s.LLM.Calls, scale),
+		Errors:           m.noisy("llm errors", s.LLM.Errors, scale),
+		PromptTokens:     m.noisy("llm prompt_tokens", s.LLM.PromptTokens, tokenScale),
+		CompletionTokens: m.noisy("llm completion_tokens", s.LLM.CompletionTokens, tokenScale),
+		Coalesced:        m.noisy("llm coalesced", s.LLM.Coalesced, scale),
+	}
+	out.Budget.Used = m.noisy("budget "+s.Budget.Day, s.Budget.Used, tokenScale)
+	if out.Budget.Limit > 0 {
+		out.Budget.UsedRatio = float64(out.Budget.Used) / float64(out.Budget.Limit)
+	}
+	return out
+}
+
+// noisy adds Laplace noise of the given scale to value, seeded by the field
+// and value, and clamps the result at 0.
+func (m *Metrics) noisy(field string, value int, scale float64) int {
+	h := hmac.New(sha256.New, m.noiseKey)
+	fmt.Fprintf(h, "%s=%d", field, value)
+	bits := binary.BigEndian.Uint64(h.Sum(nil)) >> 11
+	u := (float64(bits)+0.5)/(1<<53) - 0.5 // uniform in (-0.5, 0.5)
+	noise := -scale * math.Copysign(math.Log(1-2*math.Abs(u)), u)
+	return max(0, int(math.Round(float64(value)+noise)))
+}
+
+// statusRecorder captures the status and, for errors, the body of a
+// response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status >= 400 && r.body.Len() < 500 {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// meteredProvider records every call of the wrapped provider in metrics,
+// and in the response meta, audit record and prompt experiments of the
+// request.
+// OpenAI-compatible servers may leave out the token usage; it is estimated
+// then.
+type meteredProvider struct {
+	texttools.StreamProvider
+	tokenizers *TokenizerRegistry
+}
+
+func (p meteredProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	start := time.Now()
+	llmInFlight.Add(1)
+	defer llmInFlight.Add(-1)
+	c, err := p.StreamProvider.Complete(ctx, req)
+	p.record(ctx, req, c, err, time.Since(start))
+	return c, err
+}
+
+func (p meteredProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	start := time.Now()
+	llmInFlight.Add(1)
+	defer llmInFlight.Add(-1)
+	c, err := p.StreamProvider.Stream(ctx, req, onDelta)
+	p.record(ctx, req, c, err, time.Since(start))
+	return c, err
+}
+
+func (p meteredProvider) record(ctx context.Context, req texttools.Request, c texttools.Completion, err error, latency time.Duration) {
+	usage, estimated := p.usage(req, c)
+	metrics.RecordLLM(usage, err)
+	recordTenantUsage(ctx, usage)
+	recordAuditCall(ctx, req.Model, usage)
+	recordVariantCall(ctx, req.Operation, latency, err)
+	recordCall(ctx, req.Model, metaCall{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Estimated:        estimated,
+		Latency:          latency,
+		FinishReason:     c.FinishReason,
+	})
+}
+
+// usage is the usage of c, or the tokenizer's count if it reports none.
+func (p meteredProvider) usage(req texttools.Request, c texttools.Completion) (texttools.Usage, bool) {
+	if c.Usage != (texttools.Usage{}) || c.Text == "" {
+		return c.Usage, false
+	}
+	prompt := req.System + "\n" + req.Prompt
+	for _, m := range req.History {
+		prompt += "\n" + m.Content
+	}
+	return texttools.Usage{
+		PromptTokens:     p.tokenizers.Count(req.Model, prompt),
+		CompletionTokens: p.tokenizers.Count(req.Model, c.Text),
+	}, true
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "…"
+}
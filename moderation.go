@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Content moderation ---
+//
+// For deployments exposed to untrusted users, MODERATION screens what goes
+// to the model and what comes back: "openai" uses OpenAI's moderation
+// endpoint, "local" a regex classifier configured in MODERATION_RULES.
+// Flagged content fails the request with a 422 carrying the categories and
+// scores, before the prompt is sent (input) or instead of the result
+// (output). Streams are screened on input only, since their output has
+// been delivered by the time it is complete.
+
+// ModerationVerdict is what a 422 reports about flagged content.
+type ModerationVerdict struct {
+	Stage string `json:"stage"` // input or output
+	texttools.Moderation
+}
+
+// ModerationErrorResponse is the body of a 422 for flagged content.
+type ModerationErrorResponse struct {
+	Error      APIErrorBody      `json:"error"`
+	Moderation ModerationVerdict `json:"moderation"`
+}
+
+// flaggedError is returned by moderatingProvider for flagged content.
+type flaggedError struct {
+	Verdict ModerationVerdict
+}
+
+func (e *flaggedError) Error() string {
+	return fmt.Sprintf("%s flagged by moderation: %s", e.Verdict.Stage, strings.Join(flaggedCategories(e.Verdict.Moderation), ", "))
+}
+
+func flaggedCategories(m texttools.Moderation) []string {
+	var cats []string
+	for c := range m.Categories {
+		cats = append(cats, c)
+	}
+	sort.Strings(cats)
+	return cats
+}
+
+// newModeratorFromEnv returns the moderator named by MODERATION: none (the
+// default, nil), openai or local.
+func newModeratorFromEnv(client *http.Client) (texttools.Moderator, error) {
+	switch name := os.Getenv("MODERATION"); name {
+	case "", "none":
+		return nil, nil
+	case "openai":
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		if apiKey == "" {
+			return nil, errors.New("MODERATION=openai needs OPENAI_API_KEY")
+		}
+		return &texttools.OpenAI{APIKey: apiKey, Client: client}, nil
+	case "local":
+		return NewLocalModerator(os.Getenv("MODERATION_RULES"))
+	default:
+		return nil, fmt.Errorf("unknown MODERATION %q (want none, openai or local)", name)
+	}
+}
+
+// LocalModerator flags text that matches any of a category's patterns.
+// Its rules file maps categories to regular expressions:
+//
+//	{"violence": ["(?i)\\bkill (him|her|them)\\b"], "spam": ["(?i)buy now"]}
+type LocalModerator struct {
+	rules map[string][]*regexp.Regexp
+}
+
+func NewLocalModerator(file string) (*LocalModerator, error) {
+	if file == "" {
+		return nil, errors.New("MODERATION=local needs MODERATION_RULES")
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var patterns map[string][]string
+	if err := json.Unmarshal(b, &patterns); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	m := &LocalModerator{rules: map[string][]*regexp.Regexp{}}
+	for cat, list := range patterns {
+		for _, p := range list {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: category %q: %w", file, cat, err)
+			}
+			m.rules[cat] = append(m.rules[cat], re)
+		}
+	}
+	return m, nil
+}
+
+// Moderate scores each category 1 if one of its patterns matches, else 0.
+func (m *LocalModerator) Moderate(ctx context.Context, text string) (texttools.Moderation, error) {
+	res := texttools.Moderation{Categories: map[string]bool{}, Scores: map[string]float64{}}
+	for cat, patterns := range m.rules {
+		res.Scores[cat] = 0
+		for _, re := range patterns {
+			if re.MatchString(text) {
+				res.Flagged = true
+				res.Categories[cat] = true
+				res.Scores[cat] = 1
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// moderatingProvider screens the prompts and outputs of the wrapped
+// provider. Debug echo requests, which don't reach the provider, are not
+// screened.
+type moderatingProvider struct {
+	texttools.StreamProvider
+	moderator texttools.Moderator
+}
+
+func (p moderatingProvider) screen(ctx context.Context, stage, text string) error {
+	if p.moderator == nil || debugEcho(ctx) || dryRun(ctx) {
+		return nil
+	}
+	m, err := p.moderator.Moderate(ctx, text)
+	if err != nil {
+		return fmt.Errorf("moderation: %w", err)
+	}
+	if !m.Flagged {
+		return nil
+	}
+	flagged := &flaggedError{Verdict: ModerationVerdict{Stage: stage, Moderation: m}}
+	if slot, ok := ctx.Value(moderationKey{}).(*moderationSlot); ok {
+		slot.set(flagged)
+	}
+	return flagged
+}
+
+func (p moderatingProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	if err := p.screen(ctx, "input", req.Prompt); err != nil {
+		return texttools.Completion{}, err
+	}
+	c, err := p.StreamProvider.Complete(ctx, req)
+	if err != nil {
+		return c, err
+	}
+	if err := p.screen(ctx, "output", c.Text); err != nil {
+		return texttools.Completion{Usage: c.Usage}, err
+	}
+	return c, nil
+}
+
+func (p moderatingProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	if err := p.screen(ctx, "input", req.Prompt); err != nil {
+		return texttools.Completion{}, err
+	}
+	return p.StreamProvider.Stream(ctx, req, onDelta)
+}
+
+// --- 422 responses ---
+
+type moderationKey struct{}
+
+// moderationSlot receives the flag raised while serving a request.
+type moderationSlot struct {
+	mu  sync.Mutex
+	err *flaggedError
+}
+
+func (s *moderationSlot) set(err *flaggedError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *moderationSlot) get() *flaggedError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// withModeration turns the error response of a request whose content was
+// flagged into a 422 with the moderation verdict. Handlers only see an LLM
+// error; the verdict travels in a slot on the request context.
+func withModeration(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slot := &moderationSlot{}
+		mw := &moderationWriter{ResponseWriter: w, slot: slot}
+		next.ServeHTTP(mw, r.WithContext(context.WithValue(r.Context(), moderationKey{}, slot)))
+		if !mw.replaced {
+			return
+		}
+		flagged := slot.get()
+		resp := ModerationErrorResponse{Moderation: flagged.Verdict}
+		resp.Error.Code = "content_flagged"
+		resp.Error.Message = "content flagged by moderation: " + strings.Join(flaggedCategories(flagged.Verdict.Moderation), ", ")
+		resp.Error.RequestID = r.Header.Get("X-Request-ID")
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, flagged)
+		w.Header().Del("X-Content-Type-Options")
+		writeJSON(w, http.StatusUnprocessableEntity, resp)
+	})
+}
+
+// moderationWriter drops the handler's error response once content has
+// been flagged, so that withModeration can write the 422 instead.
+type moderationWriter struct {
+	http.ResponseWriter
+	slot     *moderationSlot
+	replaced bool
+}
+
+func (w *moderationWriter) WriteHeader(status int) {
+	if status >= 400 && w.slot.get() != nil {
+		w.replaced = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *moderationWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *moderationWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.replaced {
+		f.Flush()
+	}
+}
+
+func (w *moderationWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Multi-document summaries ---
+//
+// POST /summarize/multi synthesizes several texts on one subject, e.g.
+// reports, articles or reviews, into one summary, and lists where the
+// sources agree and where they contradict each other, naming the sources
+// by title.
+
+const maxMultiDocuments = 20
+
+type MultiSummaryRequest struct {
+	Documents []SourceDocument `json:"documents"`
+	Options
+}
+
+type SourceDocument struct {
+	Title string `json:"title"` // "Source 1", ... by default
+	Text  string `json:"text"`
+}
+
+type MultiSummaryResponse struct {
+	Summary        string          `json:"summary"`
+	Agreements     []Agreement     `json:"agreements"`
+	Contradictions []Contradiction `json:"contradictions"`
+	ResultMeta
+}
+
+// Agreement is a point several sources make.
+type Agreement struct {
+	Point   string   `json:"point"`
+	Sources []string `json:"sources"` // titles
+}
+
+// Contradiction is a point on which sources disagree.
+type Contradiction struct {
+	Topic     string     `json:"topic"`
+	Positions []Position `json:"positions"`
+}
+
+// Position is what one source says on a contradicted point.
+type Position struct {
+	Source string `json:"source"` // title
+	Says   string `json:"says"`
+}
+
+// multiSummaryPromptInput is the data of the summarize-multi template.
+type multiSummaryPromptInput struct {
+	texttools.Options
+	Documents []SourceDocument
+}
+
+func summarizeMultiHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req MultiSummaryRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if len(req.Documents) < 2 {
+			http.Error(w, "`documents` needs at least 2 texts (use /summarize for one)", http.StatusBadRequest)
+			return
+		}
+		if len(req.Documents) > maxMultiDocuments {
+			http.Error(w, fmt.Sprintf("at most %d documents per summary", maxMultiDocuments), http.StatusBadRequest)
+			return
+		}
+		titles := map[string]bool{}
+		var all strings.Builder
+		for i := range req.Documents {
+			d := &req.Documents[i]
+			if d.Text == "" {
+				http.Error(w, "documents must not be empty", http.StatusBadRequest)
+				return
+			}
+			if d.Title = strings.TrimSpace(d.Title); d.Title == "" {
+				d.Title = fmt.Sprintf("Source %d", i+1)
+			}
+			if titles[d.Title] {
+				http.Error(w, fmt.Sprintf("duplicate document title %q", d.Title), http.StatusBadRequest)
+				return
+			}
+			titles[d.Title] = true
+			fmt.Fprintf(&all, "%s\n\n%s\n\n---\n\n", d.Title, d.Text)
+		}
+		// the documents go to the model together
+		input := strings.TrimSuffix(all.String(), "\n\n---\n\n")
+		if !checkText(w, input) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey("", req.Options)
+		if prior, ok := history.Reusable(r, "summarize-multi", key, input, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		in := multiSummaryPromptInput{Options: req.Options.Options}
+		for _, d := range req.Documents {
+			in.Documents = append(in.Documents, SourceDocument{Title: d.Title, Text: texttools.PrepareInput(d.Text, req.InputFormat)})
+		}
+		out, err := tools.Prompt(r.Context(), "summarize-multi", in, in.Options)
+		if err != nil {
+			log.Println("summarize-multi error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var resp MultiSummaryResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			// fallback – treat the whole output as the summary
+			resp = MultiSummaryResponse{Summary: out}
+		}
+		resp.Summary = texttools.FormatOutput(tools.FitWords(r.Context(), resp.Summary, req.Options.Options), req.OutputFormat)
+		if resp.Agreements == nil {
+			resp.Agreements = []Agreement{}
+		}
+		for i := range resp.Agreements {
+			if resp.Agreements[i].Sources == nil {
+				resp.Agreements[i].Sources = []string{}
+			}
+		}
+		if resp.Contradictions == nil {
+			resp.Contradictions = []Contradiction{}
+		}
+		for i := range resp.Contradictions {
+			if resp.Contradictions[i].Positions == nil {
+				resp.Contradictions[i].Positions = []Position{}
+			}
+		}
+
+		resp.Summary = outputWrappers.Wrap("summarize-multi", resp.Summary, req.Options)
+		resp.ResultMeta = history.Record(r, "summarize-multi", key, input, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
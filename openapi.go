@@ -0,0 +1,842 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"ai-text-tools/texttools"
+)
+
+// --- OpenAPI specification ---
+//
+// The spec served at /openapi.json is generated from the Go request/response
+// types and the route table below, so the schemas can't drift from what the
+// handlers actually decode and encode.
+
+const openAPIVersion = "3.1.0"
+
+// apiRoute describes one method on one path for the spec.
+type apiRoute struct {
+	Method  string
+	Path    string // "{name}" segments become path parameters
+	ID      string // operationId
+	Summary string
+	Tag     string
+
+	Request   interface{} // JSON request body, nil if none
+	Multipart bool        // request is multipart/form-data (see uploadForm)
+	Query     []string    // query parameters; optional if ending in "?"
+
+	Status   int         // success status, default 200
+	Response interface{} // JSON response body, nil if there is none
+	Produces []string    // media types of a non-JSON response body (or alternatives to Response)
+	Errors   []int
+	Admin    bool
+	Tenant   bool // needs a tenant's API key
+	Echo     bool // calls the LLM, so ?debug=echo applies
+	LLM      bool // calls the LLM after answering; counted like Echo routes
+	Private  bool // accepts ?aggregate=private
+	ETag     bool // honors If-None-Match (also implied by Echo)
+}
+
+// JobRequest is the POST /jobs body: a sitemap audit, or a book summary
+// (which only uses `book` and the options).
+type JobRequest struct {
+	Type string `json:"type"`
+	Book string `json:"book"`
+	SitemapJobRequest
+}
+
+// uploadForm documents the multipart fields of POST /upload.
+type uploadForm struct {
+	File           string `json:"file"`
+	Operation      string `json:"operation"`
+	Tone           string `json:"tone"`
+	DescribeImages bool   `json:"describe_images"`
+	Options
+}
+
+// bookForm documents the multipart fields of POST /books.
+type bookForm struct {
+	File string `json:"file"`
+}
+
+type operationSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Output      string `json:"output"`
+}
+
+// apiRoutes lists every public endpoint. medical adds /plain-medical, which
+// is only served while the plain_medical flag is on.
+func apiRoutes(medical bool) []apiRoute {
+	llmErrors := []int{400, 405, 413, 422, 500, 502, 503, 504}
+	routes := []apiRoute{
+		{Method: "GET", Path: "/health", ID: "health", Summary: "Health check, with the build version, circuit breaker and result cache; ?upstream=true also checks the provider", Tag: "meta",
+			Response: HealthResponse{}},
+		{Method: "GET", Path: "/readyz", ID: "readyz", Summary: "Readiness probe, with the startup warmup status (503 while warming up)", Tag: "meta",
+			Response: WarmupStatus{}, Errors: []int{405, 503}},
+
+		{Method: "POST", Path: "/summarize", ID: "summarize", Summary: "Summarize text as bullet points", Tag: "operations",
+			Request: SummarizeRequest{}, Response: SummarizeResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/summarize/chat", ID: "summarizeChat", Summary: "Summarize a chat export (WhatsApp, Slack JSON or \"name: message\" log) with decisions and open questions", Tag: "operations",
+			Request: ChatSummaryRequest{}, Response: ChatSummaryResponse{}, Errors: []int{400, 405, 413, 422, 500}, Echo: true},
+		{Method: "POST", Path: "/summarize/multi", ID: "summarizeMulti", Summary: "Synthesize several texts into one summary, with where they agree and contradict each other", Tag: "operations",
+			Request: MultiSummaryRequest{}, Response: MultiSummaryResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/compare", ID: "compare", Summary: "Compare two texts: differences, similarities and a combined summary", Tag: "operations",
+			Request: CompareRequest{}, Response: CompareResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/compare-models", ID: "compareModels", Summary: "Run an operation with 2–4 models side by side, with each one's latency, tokens and cost", Tag: "operations",
+			Request: CompareModelsRequest{}, Response: CompareModelsResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/explain-edits", ID: "explainEdits", Summary: "Summarize the changes between an original and an edited text", Tag: "operations",
+			Request: ExplainEditsRequest{}, Response: ExplainEditsResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/outline", ID: "outline", Summary: "Turn prose into a hierarchical outline of sections and points", Tag: "operations",
+			Request: OutlineRequest{}, Response: OutlineResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/draft", ID: "draft", Summary: "Turn an outline back into prose", Tag: "operations",
+			Request: DraftRequest{}, Response: DraftResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/plan", ID: "plan", Summary: "Turn a brief into a writing plan whose sections can be expanded one by one", Tag: "operations",
+			Request: PlanRequest{}, Response: PlanResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/entities", ID: "entities", Summary: "Find people, organizations, places, dates and amounts, with character offsets", Tag: "operations",
+			Request: EntitiesRequest{}, Response: EntitiesResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/redact", ID: "redact", Summary: "Replace emails, phone numbers, names and addresses with placeholders", Tag: "operations",
+			Request: RedactRequest{}, Response: RedactResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/keywords", ID: "keywords", Summary: "Extract keywords", Tag: "operations",
+			Request: TextRequest{}, Response: KeywordsResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/rewrite", ID: "rewrite", Summary: "Rewrite text in a given tone", Tag: "operations",
+			Request: RewriteRequest{}, Response: RewriteResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/questions", ID: "questions", Summary: "Generate questions about the text", Tag: "operations",
+			Request: TextRequest{}, Response: QuestionsResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/ask", ID: "ask", Summary: "Answer a question from the text alone, with supporting quotes", Tag: "operations",
+			Request: AskRequest{}, Response: AskResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/terminology-report", ID: "terminologyReport", Summary: "Find terms written inconsistently across documents, with the form to use", Tag: "operations",
+			Request: TerminologyRequest{}, Response: TerminologyResponse{}, Errors: []int{400, 404, 405, 413, 422, 500}, Echo: true},
+		{Method: "POST", Path: "/titles", ID: "titles", Summary: "Suggest titles", Tag: "operations",
+			Request: TextRequest{}, Response: TitlesResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/headlines", ID: "headlines", Summary: "Headline variants by style (clickbait, neutral, SEO, question) and length, for A/B tests", Tag: "operations",
+			Request: HeadlinesRequest{}, Response: HeadlinesResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/seo", ID: "seo", Summary: "Meta title, meta description, URL slug and Open Graph description of an article, within length limits", Tag: "operations",
+			Request: SEORequest{}, Response: SEOResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/email", ID: "email", Summary: "Draft an email, or a reply to one, from bullet points or a rough message", Tag: "operations",
+			Request: EmailRequest{}, Response: EmailResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/release-notes", ID: "releaseNotes", Summary: "Turn commit messages or a git log into user-facing release notes (breaking changes, features, fixes)", Tag: "operations",
+			Request: ReleaseNotesRequest{}, Response: ReleaseNotesResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/meeting", ID: "meeting", Summary: "Minutes of a meeting transcript: summary, decisions, action items with owners and open questions", Tag: "operations",
+			Request: MeetingRequest{}, Response: MeetingResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/explain-code", ID: "explainCode", Summary: "Explain a code snippet in plain English and suggest a doc comment", Tag: "operations",
+			Request: ExplainCodeRequest{}, Response: ExplainCodeResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/citations", ID: "citations", Summary: "Find factual claims, with character offsets, flag those that need a source and suggest search queries", Tag: "operations",
+			Request: CitationsRequest{}, Response: CitationsResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/verify-summary", ID: "verifySummary", Summary: "Check a summary against its source: a supported or unsupported verdict per claim, with evidence", Tag: "operations",
+			Request: VerifySummaryRequest{}, Response: VerifySummaryResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/glossary", ID: "glossary", Summary: "Glossary of the domain-specific terms of a document: term/definition pairs and a Markdown section", Tag: "operations",
+			Request: GlossaryRequest{}, Response: GlossaryResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/expand", ID: "expand", Summary: "Expand short text into a longer version", Tag: "operations",
+			Request: TextRequest{}, Response: ExpandResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/to-bullets", ID: "toBullets", Summary: "Turn prose into bullet points (length: short for the key points only, long for nested details)", Tag: "operations",
+			Request: TextRequest{}, Response: BulletsResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "POST", Path: "/to-prose", ID: "toProse", Summary: "Turn bullet points or notes into prose (length: short for one paragraph, long for developed paragraphs)", Tag: "operations",
+			Request: TextRequest{}, Response: ProseResponse{}, Errors: llmErrors, Echo: true},
+		{Method: "GET", Path: "/ws", ID: "websocket", Summary: "Upgrade to a WebSocket: run operations, receive streamed output, cancel runs (see the README for the messages)", Tag: "operations",
+			Status: http.StatusSwitchingProtocols, Errors: []int{400, 403, 405, 426}},
+		{Method: "POST", Path: "/tokens", ID: "countTokens", Summary: "Count tokens with the tokenizer of the given model", Tag: "operations",
+			Request: TokensRequest{}, Response: TokensResponse{}, Errors: []int{400, 405, 413, 422}},
+		{Method: "POST", Path: "/stats", ID: "textStats", Summary: "Counts, reading time and readability scores of a text (no LLM call)", Tag: "operations",
+			Request: StatsRequest{}, Response: TextStats{}, Errors: []int{400, 405, 413, 422}},
+		{Method: "POST", Path: "/similarity", ID: "similarity", Summary: "Embedding cosine similarity, n-gram overlap and the most similar passages of a source and its rewrite", Tag: "operations",
+			Request: SimilarityRequest{}, Response: SimilarityResponse{}, Errors: []int{400, 405, 413, 422, 500}},
+		{Method: "POST", Path: "/embed", ID: "embed", Summary: "Embedding vectors for texts", Tag: "operations",
+			Request: EmbedRequest{}, Response: EmbedResponse{}, Errors: []int{400, 405, 413, 422, 500}},
+
+		{Method: "POST", Path: "/upload", ID: "upload", Summary: "Extract text from a PDF, DOCX, EPUB, HTML or TXT file and optionally run an operation on it", Tag: "operations",
+			Request: uploadForm{}, Multipart: true, Response: UploadResponse{}, Errors: []int{400, 405, 413, 415, 422, 500}, Echo: true},
+
+		{Method: "POST", Path: "/books", ID: "uploadBook", Summary: "Upload an EPUB and split it into chapters", Tag: "books",
+			Request: bookForm{}, Multipart: true, Status: http.StatusCreated, Response: StoredBook{}, Errors: []int{400, 405, 413, 415, 422}},
+		{Method: "GET", Path: "/books", ID: "listBooks", Summary: "List the caller's books, newest first", Tag: "books",
+			Response: struct {
+				Books []StoredBook `json:"books"`
+			}{}},
+		{Method: "GET", Path: "/books/{id}", ID: "getBook", Summary: "Get a book's table of contents", Tag: "books",
+			Response: StoredBook{}, Errors: []int{404}},
+		{Method: "DELETE", Path: "/books/{id}", ID: "deleteBook", Summary: "Delete one of the caller's books", Tag: "books",
+			Status: http.StatusNoContent, Errors: []int{404}},
+		{Method: "GET", Path: "/books/{id}/chapters/{n}", ID: "getChapter", Summary: "Get the text of a chapter", Tag: "books",
+			Response: ChapterResponse{}, Errors: []int{404, 405}},
+		{Method: "POST", Path: "/books/{id}/chapters/{n}/{operation}", ID: "runChapterOperation", Summary: "Run a built-in operation on a chapter", Tag: "books",
+			Request: ChapterOperationRequest{}, Response: ChapterOperationResponse{}, Errors: []int{400, 404, 405, 413, 422, 500}, Echo: true},
+
+		{Method: "POST", Path: "/custom", ID: "runCustom", Summary: "Run a custom operation or prompt template", Tag: "custom",
+			Request: CustomRequest{}, Response: CustomResponse{}, Errors: []int{400, 404, 405, 413, 422, 500}, Echo: true},
+		{Method: "POST", Path: "/custom/{name}", ID: "runCustomByName", Summary: "Run a registered custom operation", Tag: "custom",
+			Request: CustomRequest{}, Response: CustomResponse{}, Errors: []int{400, 404, 405, 413, 422, 500}, Echo: true},
+		{Method: "GET", Path: "/operations", ID: "listOperations", Summary: "List registered custom operations", Tag: "custom",
+			Response: struct {
+				Operations []operationSummary `json:"operations"`
+			}{}},
+
+		{Method: "GET", Path: "/preferences", ID: "getPreferences", Summary: "Get the caller's default options", Tag: "preferences",
+			Response: preferencesBody{}},
+		{Method: "PUT", Path: "/preferences", ID: "setPreferences", Summary: "Replace the caller's default options", Tag: "preferences",
+			Request: Preferences{}, Response: preferencesBody{}, Errors: []int{400, 413, 422, 500}},
+		{Method: "DELETE", Path: "/preferences", ID: "deletePreferences", Summary: "Reset the caller's default options", Tag: "preferences",
+			Status: http.StatusNoContent, Errors: []int{500}},
+
+		{Method: "GET", Path: "/tabs", ID: "getTabs", Summary: "Get the UI tabs of the browser session", Tag: "ui",
+			Response: TabState{}},
+		{Method: "PUT", Path: "/tabs", ID: "setTabs", Summary: "Replace the UI tabs of the browser session", Tag: "ui",
+			Request: TabState{}, Response: TabState{}, Errors: []int{400, 413, 422, 500}},
+		{Method: "DELETE", Path: "/tabs", ID: "deleteTabs", Summary: "Clear the UI tabs of the browser session", Tag: "ui",
+			Status: http.StatusNoContent, Errors: []int{500}},
+		{Method: "GET", Path: "/me", ID: "me", Summary: "Who the caller is (their SAML login, if any)", Tag: "ui",
+			Response: MeResponse{}},
+		{Method: "POST", Path: "/quick", ID: "quick", Summary: "Run an operation on selected text and get a short plain-text result (for browser extensions)", Tag: "operations",
+			Request: QuickRequest{}, Response: QuickResponse{}, Errors: []int{400, 413, 422, 500}, Echo: true},
+		{Method: "GET", Path: "/quick", ID: "quickGet", Summary: "Like POST /quick, with the input in the query; with `callback`, a JSONP script", Tag: "operations",
+			Query: []string{"text", "operation?", "tone?", "language?", "callback?"}, Response: QuickResponse{}, Produces: []string{"application/javascript"}, Errors: []int{400, 413, 422, 500}, Echo: true},
+		{Method: "POST", Path: "/integrations/email", ID: "inboundEmail", Summary: "Receive an email from SendGrid Inbound Parse or a Mailgun route; its summary is sent back as a reply", Tag: "integrations",
+			Query: []string{"token"}, Response: InboundAccepted{}, Errors: []int{400, 401, 404, 413}},
+		{Method: "POST", Path: "/integrations/slack", ID: "slackCommand", Summary: "Run a Slack slash command (form body signed by Slack); the result is posted to its response_url", Tag: "integrations",
+			Response: SlackMessage{}, Errors: []int{400, 401, 404, 413, 429}, LLM: true},
+		{Method: "GET", Path: "/tenant", ID: "tenant", Summary: "The caller's tenant: quota, models and usage", Tag: "tenants",
+			Response: TenantReport{}, Tenant: true},
+
+		{Method: "POST", Path: "/sessions", ID: "createSession", Summary: "Start a session; operations called with its session_id get the conversation as context", Tag: "sessions",
+			Status: http.StatusCreated, Response: Session{}, Errors: []int{503}},
+		{Method: "GET", Path: "/sessions/{id}", ID: "getSession", Summary: "Get a session with its turns", Tag: "sessions",
+			Response: Session{}, Errors: []int{404}},
+		{Method: "DELETE", Path: "/sessions/{id}", ID: "deleteSession", Summary: "End a session", Tag: "sessions",
+			Status: http.StatusNoContent, Errors: []int{404}},
+		{Method: "POST", Path: "/sessions/{id}/messages", ID: "sessionMessage", Summary: "Follow up in a session, e.g. \"make it shorter\"", Tag: "sessions",
+			Request: SessionMessageRequest{}, Response: SessionMessageResponse{}, Errors: []int{400, 404, 413, 422, 500}, Echo: true},
+
+		{Method: "GET", Path: "/history", ID: "listHistory", Summary: "List the caller's stored results, newest first, optionally in a folder or with tags", Tag: "history",
+			Query: []string{"folder?", "tag?"}, Errors: []int{400}, Response: struct {
+				Entries []HistoryEntry `json:"entries"`
+			}{}},
+		{Method: "GET", Path: "/search", ID: "searchHistory", Summary: "Find the caller's stored results by meaning (semantic_search flag), or results and documents by keyword", Tag: "history",
+			Query: []string{"q", "mode?", "limit?", "operation?", "type?", "folder?", "tag?"}, Response: SearchResponse{}, Errors: []int{400, 404, 405, 413, 500}},
+		{Method: "GET", Path: "/history/{id}", ID: "getHistoryEntry", Summary: "Get one of the caller's stored results", Tag: "history",
+			Response: HistoryEntry{}, Errors: []int{404}, ETag: true},
+		{Method: "PATCH", Path: "/history/{id}", ID: "labelHistoryEntry", Summary: "File one of the caller's stored results in a folder or change its tags", Tag: "history",
+			Request: LabelsUpdate{}, Response: HistoryEntry{}, Errors: []int{400, 404, 413, 500}},
+		{Method: "DELETE", Path: "/history/{id}", ID: "deleteHistoryEntry", Summary: "Delete one of the caller's stored results", Tag: "history",
+			Status: http.StatusNoContent, Errors: []int{404, 500}},
+		{Method: "GET", Path: "/history/{id}/provenance", ID: "getProvenance", Summary: "Get a signed provenance statement for one of the caller's stored results", Tag: "history",
+			Response: SignedProvenance{}, Errors: []int{404, 409, 501}},
+		{Method: "POST", Path: "/regenerate", ID: "regenerate", Summary: "Revise one of the caller's stored results with feedback, e.g. \"too long\"; the response has the fields of the original result", Tag: "history",
+			Request: RegenerateRequest{}, Response: struct {
+				RegeneratedFrom string `json:"regenerated_from"`
+				ResultMeta
+			}{}, Errors: []int{400, 404, 405, 413, 422, 500}, Echo: true},
+		{Method: "GET", Path: "/documents", ID: "listDocuments", Summary: "List the caller's stored documents, without their text, optionally in a folder or with tags", Tag: "documents",
+			Query: []string{"folder?", "tag?"}, Errors: []int{400}, Response: struct {
+				Documents []DocumentInfo `json:"documents"`
+			}{}},
+		{Method: "POST", Path: "/documents", ID: "createDocument", Summary: "Store a document (JSON, or a multipart `file` and `name`) to reference by ID in any operation", Tag: "documents",
+			Request: DocumentRequest{}, Status: http.StatusCreated, Response: DocumentInfo{}, Errors: []int{400, 403, 409, 413, 415, 422, 500, 502}},
+		{Method: "GET", Path: "/documents/{id}", ID: "getDocument", Summary: "Get a stored document with its text", Tag: "documents",
+			Response: StoredDocument{}, Errors: []int{404}},
+		{Method: "PUT", Path: "/documents/{id}", ID: "updateDocument", Summary: "Rename a stored document or replace its text", Tag: "documents",
+			Request: DocumentRequest{}, Response: DocumentInfo{}, Errors: []int{400, 403, 404, 413, 415, 422, 500, 502}},
+		{Method: "PATCH", Path: "/documents/{id}", ID: "labelDocument", Summary: "File a stored document in a folder or change its tags", Tag: "documents",
+			Request: LabelsUpdate{}, Response: DocumentInfo{}, Errors: []int{400, 404, 413, 500}},
+		{Method: "GET", Path: "/labels", ID: "listLabels", Summary: "List the caller's folders and tags with the documents and results under each", Tag: "documents",
+			Response: LabelsResponse{}, Errors: []int{405}},
+		{Method: "GET", Path: "/documents/{id}/file", ID: "getDocumentFile", Summary: "Download the file a document was uploaded from, if kept (BLOB_STORE)", Tag: "documents",
+			Produces: []string{"application/octet-stream"}, Errors: []int{404, 502}},
+		{Method: "DELETE", Path: "/documents/{id}", ID: "deleteDocument", Summary: "Delete a stored document", Tag: "documents",
+			Status: http.StatusNoContent, Errors: []int{404, 500}},
+		{Method: "POST", Path: "/feedback", ID: "giveFeedback", Summary: "Rate one of the caller's stored results up or down, with an optional comment", Tag: "history",
+			Request: FeedbackRequest{}, Response: Feedback{}, Errors: []int{400, 403, 404, 405, 413, 500}},
+		{Method: "GET", Path: "/feedback/stats", ID: "feedbackStats", Summary: "Feedback per operation, prompt version and model, with the latest comments", Tag: "admin",
+			Query: []string{"operation?"}, Response: FeedbackStatsResponse{}, Errors: []int{401, 405}, Admin: true},
+		{Method: "POST", Path: "/export", ID: "exportResults", Summary: "Download results as a Markdown, PDF or DOCX document", Tag: "history",
+			Request: ExportRequest{}, Produces: []string{"text/markdown", "application/pdf", exportFormats["docx"].contentType}, Errors: []int{400, 403, 404, 413, 422, 501, 502}},
+		{Method: "GET", Path: "/exports/{id}", ID: "getExport", Summary: "Download an export stored with \"store\": true (BLOB_STORE)", Tag: "history",
+			Produces: []string{"text/markdown", "application/pdf", exportFormats["docx"].contentType}, Errors: []int{404, 502}},
+		{Method: "DELETE", Path: "/exports/{id}", ID: "deleteExport", Summary: "Delete a stored export", Tag: "history",
+			Status: http.StatusNoContent, Errors: []int{404, 502}},
+		{Method: "POST", Path: "/provenance/verify", ID: "verifyProvenance", Summary: "Check a provenance statement was signed by this instance", Tag: "history",
+			Request: VerifyProvenanceRequest{}, Response: VerifyProvenanceResponse{}, Errors: []int{400, 413, 422, 501}},
+		{Method: "GET", Path: "/provenance/key", ID: "getProvenanceKey", Summary: "Public key for verifying Ed25519 provenance signatures offline", Tag: "history",
+			Response: ProvenanceKey{}, Errors: []int{404}},
+
+		{Method: "POST", Path: "/jobs", ID: "submitJob", Summary: "Submit an async job", Tag: "jobs",
+			Request: JobRequest{}, Status: http.StatusAccepted, Response: Job{}, Errors: []int{400, 413, 422, 503}},
+		{Method: "GET", Path: "/jobs", ID: "listJobs", Summary: "List the caller's jobs (without results)", Tag: "jobs",
+			Response: struct {
+				Jobs []Job `json:"jobs"`
+			}{}},
+		{Method: "GET", Path: "/jobs/{id}", ID: "getJob", Summary: "Get the status and result of one of the caller's jobs", Tag: "jobs",
+			Response: Job{}, Errors: []int{404}},
+		{Method: "GET", Path: "/schedules", ID: "listSchedules", Summary: "List the caller's scheduled jobs (without the last result)", Tag: "jobs",
+			Response: struct {
+				Schedules []Schedule `json:"schedules"`
+			}{}},
+		{Method: "POST", Path: "/schedules", ID: "createSchedule", Summary: "Schedule an operation on a URL or stored document, its result sent to a webhook or by email", Tag: "jobs",
+			Request: ScheduleSpec{}, Status: http.StatusCreated, Response: Schedule{}, Errors: []int{400, 403, 409, 413, 500}},
+		{Method: "GET", Path: "/schedules/{id}", ID: "getSchedule", Summary: "Get a scheduled job with its last run", Tag: "jobs",
+			Response: Schedule{}, Errors: []int{404}},
+		{Method: "PUT", Path: "/schedules/{id}", ID: "updateSchedule", Summary: "Replace a scheduled job, e.g. to pause it", Tag: "jobs",
+			Request: ScheduleSpec{}, Response: Schedule{}, Errors: []int{400, 403, 404, 413, 500}},
+		{Method: "DELETE", Path: "/schedules/{id}", ID: "deleteSchedule", Summary: "Delete a scheduled job", Tag: "jobs",
+			Status: http.StatusNoContent, Errors: []int{404, 500}},
+		{Method: "POST", Path: "/schedules/{id}/run", ID: "runSchedule", Summary: "Run a scheduled job now", Tag: "jobs",
+			Status: http.StatusAccepted, Response: Job{}, Errors: []int{404, 409, 503}},
+		{Method: "GET", Path: "/feeds", ID: "listFeeds", Summary: "List the caller's RSS and Atom feeds (without their summaries)", Tag: "feeds",
+			Response: struct {
+				Feeds []Feed `json:"feeds"`
+			}{}},
+		{Method: "POST", Path: "/feeds", ID: "addFeed", Summary: "Subscribe to an RSS or Atom feed, whose new items are summarized periodically", Tag: "feeds",
+			Request: FeedRequest{}, Status: http.StatusCreated, Response: Feed{}, Errors: []int{400, 403, 409, 413, 422, 500, 502}},
+		{Method: "GET", Path: "/feeds/digest", ID: "feedDigest", Summary: "Summaries of the new items of the caller's feeds, newest first, as JSON or RSS", Tag: "feeds",
+			Query: []string{"since?", "feed?", "limit?", "format?"}, Response: FeedDigest{}, Produces: []string{"application/rss+xml"}, Errors: []int{400}},
+		{Method: "GET", Path: "/feeds/{id}", ID: "getFeed", Summary: "Get a feed with its latest summaries", Tag: "feeds",
+			Response: Feed{}, Errors: []int{404}},
+		{Method: "DELETE", Path: "/feeds/{id}", ID: "deleteFeed", Summary: "Unsubscribe from a feed", Tag: "feeds",
+			Status: http.StatusNoContent, Errors: []int{404, 500}},
+		{Method: "POST", Path: "/feeds/{id}/refresh", ID: "refreshFeed", Summary: "Fetch a feed and summarize its new items now", Tag: "feeds",
+			Status: http.StatusAccepted, Response: Job{}, Errors: []int{404, 409, 503}},
+
+		{Method: "GET", Path: "/prompts", ID: "listPrompts", Summary: "List the active prompt templates", Tag: "admin",
+			Response: struct {
+				Dir       string           `json:"dir"`
+				Templates []promptTemplate `json:"templates"`
+			}{}, Admin: true},
+		{Method: "GET", Path: "/admin/operations", ID: "adminListOperations", Summary: "List custom operations with their prompts", Tag: "admin",
+			Response: struct {
+				Operations []CustomOperation `json:"operations"`
+			}{}, Admin: true},
+		{Method: "POST", Path: "/admin/operations", ID: "adminPutOperation", Summary: "Register or replace a custom operation", Tag: "admin",
+			Request: CustomOperation{}, Status: http.StatusCreated, Response: CustomOperation{}, Errors: []int{400, 413, 422}, Admin: true},
+		{Method: "DELETE", Path: "/admin/operations", ID: "adminDeleteOperation", Summary: "Delete a custom operation", Tag: "admin",
+			Query: []string{"name"}, Status: http.StatusNoContent, Errors: []int{404, 500}, Admin: true},
+		{Method: "GET", Path: "/admin/stats", ID: "adminStats", Summary: "Request, LLM, budget and job metrics, recent errors and flags", Tag: "admin",
+			Response: AdminStats{}, Admin: true, Private: true},
+		{Method: "GET", Path: "/admin/flags", ID: "adminGetFlags", Summary: "Get maintenance mode and feature flags", Tag: "admin",
+			Response: FeatureState{}, Admin: true},
+		{Method: "PUT", Path: "/admin/flags", ID: "adminSetFlags", Summary: "Toggle maintenance mode and feature flags", Tag: "admin",
+			Request: FeatureState{}, Response: FeatureState{}, Errors: []int{400, 413, 422}, Admin: true},
+		{Method: "GET", Path: "/admin/config", ID: "adminGetConfig", Summary: "Get the runtime settings", Tag: "admin",
+			Response: RuntimeSettings{}, Admin: true},
+		{Method: "PATCH", Path: "/admin/config", ID: "adminUpdateConfig", Summary: "Change runtime settings; null resets one", Tag: "admin",
+			Request: RuntimeSettings{}, Response: RuntimeSettings{}, Errors: []int{400, 413, 500}, Admin: true},
+		{Method: "PUT", Path: "/admin/config/prompts/{name}", ID: "adminSetPrompt", Summary: "Override a prompt template", Tag: "admin",
+			Request: PromptUpdate{}, Response: promptTemplate{}, Errors: []int{400, 413, 422, 500}, Admin: true},
+		{Method: "DELETE", Path: "/admin/config/prompts/{name}", ID: "adminResetPrompt", Summary: "Drop a prompt template override", Tag: "admin",
+			Status: http.StatusNoContent, Errors: []int{400, 404, 500}, Admin: true},
+		{Method: "PUT", Path: "/admin/config/experiments/{operation}", ID: "adminStartExperiment", Summary: "Split an operation's traffic between prompt variants", Tag: "admin",
+			Request: PromptExperiment{}, Response: PromptExperiment{}, Errors: []int{400, 413, 422, 500}, Admin: true},
+		{Method: "DELETE", Path: "/admin/config/experiments/{operation}", ID: "adminEndExperiment", Summary: "End a prompt experiment", Tag: "admin",
+			Status: http.StatusNoContent, Errors: []int{400, 404, 500}, Admin: true},
+		{Method: "GET", Path: "/admin/experiments", ID: "adminExperiments", Summary: "Compare the variants of the prompt experiments", Tag: "admin",
+			Response: struct {
+				Experiments []ExperimentReport `json:"experiments"`
+			}{}, Admin: true},
+		{Method: "GET", Path: "/admin/config/audit", ID: "adminConfigAudit", Summary: "List changes of the runtime settings, newest first", Tag: "admin",
+			Response: struct {
+				Changes []ConfigChange `json:"changes"`
+			}{}, Admin: true},
+		{Method: "POST", Path: "/admin/reload", ID: "adminReload", Summary: "Reload the configuration files, like SIGHUP", Tag: "admin",
+			Response: ReloadResult{}, Errors: []int{500}, Admin: true},
+		{Method: "GET", Path: "/admin/tenants", ID: "adminListTenants", Summary: "List tenants with their usage", Tag: "tenants",
+			Response: struct {
+				Tenants []TenantReport `json:"tenants"`
+			}{}, Admin: true},
+		{Method: "POST", Path: "/admin/tenants", ID: "adminCreateTenant", Summary: "Create a tenant with a first API key", Tag: "tenants",
+			Request: TenantSpec{}, Status: http.StatusCreated, Response: struct {
+				Tenant TenantReport `json:"tenant"`
+				Key    IssuedKey    `json:"key"`
+			}{}, Errors: []int{400, 409, 413, 500}, Admin: true},
+		{Method: "GET", Path: "/admin/tenants/{name}", ID: "adminGetTenant", Summary: "Get a tenant with its usage", Tag: "tenants",
+			Response: TenantReport{}, Errors: []int{404}, Admin: true},
+		{Method: "PATCH", Path: "/admin/tenants/{name}", ID: "adminUpdateTenant", Summary: "Change a tenant's quota or models", Tag: "tenants",
+			Request: TenantSpec{}, Response: TenantReport{}, Errors: []int{400, 404, 413, 500}, Admin: true},
+		{Method: "DELETE", Path: "/admin/tenants/{name}", ID: "adminDeleteTenant", Summary: "Delete a tenant and revoke its keys", Tag: "tenants",
+			Status: http.StatusNoContent, Errors: []int{404, 500}, Admin: true},
+		{Method: "POST", Path: "/admin/tenants/{name}/keys", ID: "adminIssueTenantKey", Summary: "Issue another API key; the key is only shown in this response", Tag: "tenants",
+			Status: http.StatusCreated, Response: IssuedKey{}, Errors: []int{404, 500}, Admin: true},
+		{Method: "DELETE", Path: "/admin/tenants/{name}/keys/{id}", ID: "adminRevokeTenantKey", Summary: "Revoke an API key", Tag: "tenants",
+			Status: http.StatusNoContent, Errors: []int{404, 500}, Admin: true},
+	}
+	if medical {
+		routes = append(routes, apiRoute{Method: "POST", Path: "/plain-medical", ID: "plainMedical",
+			Summary: "Explain medical text in plain language (no dosage advice)", Tag: "operations",
+			Request: TextRequest{}, Response: MedicalResponse{}, Errors: llmErrors, Echo: true})
+	}
+	return routes
+}
+
+type preferencesBody struct {
+	User        string      `json:"user"`
+	Preferences Preferences `json:"preferences"`
+}
+
+// fieldDocs adds descriptions and enums to generated schemas, keyed by
+// "<Go type>.<json field>".
+var fieldDocs = map[string]map[string]interface{}{
+	"TextRequest.text":            {"description": "The input text."},
+	"RewriteRequest.text":         {"description": "The input text."},
+	"RewriteRequest.tone":         {"description": "Target tone, e.g. friendly or formal (default neutral)."},
+	"ChatSummaryRequest.text":     {"description": "The exported chat: a WhatsApp .txt export, a Slack channel export (JSON array of messages) or \"name: message\" lines."},
+	"ChatSummaryRequest.format":   {"enum": chatFormats, "description": "Export format (default auto: detected)."},
+	"ChatSummaryResponse.format":  {"enum": []string{chatWhatsApp, chatSlack, chatPlain}, "description": "The format the chat was parsed as."},
+	"Options.language":            {"description": "Output language, e.g. \"Spanish\"."},
+	"QuickRequest.operation":      {"enum": quickOperations, "description": "The operation (default summarize)."},
+	"QuickRequest.text":           {"description": "The selected text, at most QUICK_MAX_CHARS (default 4000) characters."},
+	"Options.length":              {"enum": []string{"short", "medium", "long"}, "description": "Summary length."},
+	"Options.max_words":           {"minimum": 1, "description": "Cap on the words of the result; text results are shortened or cut to fit."},
+	"Options.model":               {"description": "Overrides the default model."},
+	"Options.session_id":          {"description": "Run in this session (POST /sessions): its earlier turns go to the model as context."},
+	"Options.input_format":        {"enum": []string{texttools.FormatPlain, texttools.FormatMarkdown, texttools.FormatHTML}},
+	"Options.output_format":       {"enum": []string{texttools.FormatPlain, texttools.FormatMarkdown, texttools.FormatHTML}, "description": "Format of text results."},
+	"Params.temperature":          {"minimum": 0, "maximum": 2, "description": "Sampling temperature; 0 for (nearly) deterministic output."},
+	"Params.top_p":                {"minimum": 0, "maximum": 1, "description": "Nucleus sampling: consider only the tokens in this top probability mass."},
+	"Params.max_tokens":           {"minimum": 1, "description": "Upper bound on the output length, in tokens (the server caps it, MAX_OUTPUT_TOKENS)."},
+	"Params.seed":                 {"description": "Seed for best-effort reproducible sampling."},
+	"Options.reuse":               {"description": "Return a stored result for an identical or near-identical input instead of calling the model."},
+	"Preferences.length":          {"enum": []string{"short", "medium", "long"}},
+	"ResultMeta.id":               {"description": "History id of this result."},
+	"ResultMeta.duplicate_of":     {"description": "History id of an earlier result for the same input."},
+	"ResultMeta.model":            {"description": "With LLM_FALLBACK set, the model that served the result."},
+	"ResultMeta.model_fallback":   {"description": "The result was served by a fallback model, not the primary."},
+	"Provenance.prompt_version":   {"description": "Hashes of the prompt templates used, e.g. \"summarize:1a2b3c4d,system:5e6f7a8b\"."},
+	"SignedProvenance.payload":    {"description": "The exact signed bytes: the statement as JSON."},
+	"SignedProvenance.alg":        {"enum": []string{"ed25519", "hmac-sha256"}},
+	"CustomOperation.output":      {"enum": []string{outputText, outputList, outputJSON}},
+	"CustomRequest.output":        {"enum": []string{outputText, outputList, outputJSON}},
+	"CustomRequest.operation":     {"description": "A registered custom operation."},
+	"CustomRequest.template":      {"description": "Or a prompt template, by name: a built-in operation's or one of PROMPTS_DIR."},
+	"CustomRequest.vars":          {"description": "Extra template variables."},
+	"Job.status":                  {"enum": []string{jobQueued, jobRunning, jobDone, jobFailed}},
+	"JobRequest.type":             {"enum": []string{"sitemap", "book_summary"}},
+	"JobRequest.book":             {"description": "book_summary: ID of a book uploaded with POST /books."},
+	"Labels.folder":               {"description": "A folder path, nested with \"/\", e.g. \"billing/refunds\"."},
+	"LabelsUpdate.folder":         {"description": "Left out keeps the folder; \"\" removes it."},
+	"LabelsUpdate.tags":           {"description": "Left out keeps the tags; [] removes them."},
+	"SitemapJobRequest.sitemap":   {"description": "URL of a sitemap.xml; sitemap indexes are followed."},
+	"SitemapJobRequest.operation": {"enum": builtinOperations, "description": "Optional operation to run on each page."},
+	"TokensResponse.exact":        {"description": "False when the count is an estimate (no vocabulary file for this model family). Exact counts can still be a token off on runs of whitespace."},
+	"Tab.results":                 {"description": "Latest rendered output per operation."},
+	"TabState.active":             {"description": "ID of the selected tab."},
+	"EntitiesRequest.types":       {"items": map[string]interface{}{"type": "string", "enum": entityTypes}},
+	"Entity.type":                 {"enum": entityTypes},
+	"Entity.start":                {"description": "Offset of the first character (Unicode code point) in text."},
+	"Entity.end":                  {"description": "Offset after the last character."},
+	"EditChange.kind":             {"enum": editKinds},
+	"RedactRequest.types":         {"items": map[string]interface{}{"type": "string", "enum": piiTypes}},
+	"RedactRequest.mode":          {"enum": []string{"full", "regex"}, "description": "full (default): regex and LLM pass; regex: no model call."},
+	"Redaction.type":              {"enum": piiTypes},
+	"PlanRequest.sections":        {"minimum": 0, "maximum": maxPlanSections, "description": "Number of sections; 0 lets the model decide (by length)."},
+	"PlanSection.brief":           {"description": "The section as text for POST /expand."},
+	"HeadlinesRequest.styles":     {"items": map[string]interface{}{"type": "string", "enum": headlineStyles}},
+	"HeadlinesRequest.variants":   {"minimum": 0, "maximum": maxHeadlineVariants, "description": "Variants per style (default 3)."},
+	"Headline.style":              {"enum": headlineStyles},
+	"Headline.length":             {"enum": []string{"short", "medium", "long"}, "description": "short: up to 40 characters; medium: up to 60; long: more."},
+	"AskRequest.question":         {"maxLength": maxQuestionChars},
+	"TerminologyRequest.mode":     {"enum": []string{"full", "local"}, "description": "full (default): local analysis and LLM judgment; local: no model call."},
+	"TermDocument.text":           {"description": "The document's text."},
+	"TermGroup.source":            {"enum": []string{"local", "model"}},
+	"BreakerStatus.state":         {"enum": []string{breakerClosed, breakerOpen, breakerHalfOpen}},
+	"ExportRequest.format":        {"enum": []string{"markdown", "pdf", "docx"}},
+	"ExportRequest.results":       {"maxItems": maxExportResults},
+	"ExportResult.result":         {"description": "An operation's JSON response, or text."},
+	"ExportRequest.store":         {"description": "Keep the document in the blob store (BLOB_STORE) instead of returning it: the response is 201 with its id, filename, format, size and url."},
+	"DocumentInfo.file":           {"description": "The uploaded file, kept when a blob store (BLOB_STORE) is configured; GET /documents/{id}/file downloads it."},
+	"EmbedRequest.texts":          {"maxItems": maxEmbedTexts},
+	"SearchResult.score":          {"description": "Cosine similarity of the entry's input to the query; in keyword search, the BM25 score."},
+	"SearchResult.type":           {"enum": []string{searchDocument, searchResult}},
+	"SearchResponse.mode":         {"enum": []string{searchSemantic, searchKeyword}},
+	"OutlineRequest.depth":        {"minimum": 1, "maximum": maxOutlineDepth, "description": "Levels of sections (default 3)."},
+	"DraftRequest.outline":        {"description": "An outline as returned by /outline."},
+	"DraftRequest.text":           {"description": "Or a Markdown outline: headings and bullet points."},
+	"uploadForm.file":             {"format": "binary"},
+	"bookForm.file":               {"format": "binary", "description": "An EPUB file."},
+	"uploadForm.operation":        {"enum": builtinOperations},
+	"uploadForm.describe_images":  {"description": "Describe figures with the vision model (VISION_MODEL)."},
+	"Figure.description":          {"description": "From the vision model, with describe_images."},
+
+	"SummarizeRequest.reading_time": {"description": "Target reading time, e.g. \"2 minutes\" or \"90s\" (10s to 1h); the summary is condensed until it fits."},
+	"ReadingTimeFit.seconds":        {"description": "Estimated reading time of the summary, at READING_WPM words per minute."},
+	"MultiSummaryRequest.documents": {"minItems": 2, "maxItems": maxMultiDocuments},
+	"SEOResponse.meta_title":        {"maxLength": maxMetaTitleChars},
+	"SEOResponse.meta_description":  {"maxLength": maxMetaDescriptionChars},
+	"SEOResponse.slug":              {"maxLength": maxSlugChars},
+	"SEOResponse.og_description":    {"maxLength": maxOGDescriptionChars},
+	"EmailRequest.reply_to":         {"description": "The email being answered: the draft is written as a reply, its subject prefixed with \"Re: \"."},
+	"ReleaseNotesRequest.commits":   {"maxItems": maxReleaseCommits},
+	"ReleaseNotesRequest.log":       {"description": "Or the output of git log: the default format, or one commit per line (--oneline, --graph, --decorate)."},
+	"ReleaseNotesRequest.mode":      {"enum": []string{"full", "local"}, "description": "full (default): LLM; local: group by Conventional Commits type, no model call."},
+	"MeetingRequest.text":           {"description": "The transcript: \"Speaker: text\" lines, or WebVTT or SRT captions."},
+	"ActionItem.owner":              {"description": "Who took the task, by name as in the transcript; empty if nobody did."},
+	"ExplainCodeRequest.code":       {"description": "The snippet, sent to the model verbatim."},
+	"Claim.kind":                    {"enum": claimKinds},
+	"Claim.start":                   {"description": "Offset of the first character (Unicode code point) in text."},
+	"Claim.end":                     {"description": "Offset after the last character."},
+	"Claim.queries":                 {"maxItems": maxClaimQueries},
+	"VerifiedClaim.verdict":         {"enum": []string{verdictSupported, verdictUnsupported}},
+	"SummarizeRequest.verify":       {"description": "Also check the summary against the text for unsupported claims (one more model call)."},
+	"GlossaryRequest.max_terms":     {"minimum": 1, "maximum": maxGlossaryTerms},
+	"ScheduleSpec.cron":             {"description": "Five fields (minute hour day-of-month month day-of-week) or @hourly, @daily, @weekly, @monthly, @yearly; runs at most every SCHEDULE_MIN_INTERVAL."},
+	"ScheduleSpec.timezone":         {"description": "IANA time zone of the cron expression, e.g. \"Europe/Berlin\"; UTC if empty."},
+	"ScheduleSpec.url":              {"description": "Fetched on every run; RSS and Atom feeds give their items' titles and summaries, HTML pages their text."},
+	"ScheduleSpec.operation":        {"enum": builtinOperations},
+	"ScheduleSpec.webhook":          {"description": "POSTed a ScheduleDelivery, signed in X-Signature if SCHEDULE_WEBHOOK_SECRET is set."},
+	"ScheduleSpec.email":            {"description": "Needs SMTP_ADDR and EMAIL_FROM."},
+	"ScheduleRun.status":            {"enum": []string{jobDone, jobFailed}},
+	"FeedRequest.fetch_articles":    {"description": "Summarize the page each item links to instead of the item's description."},
+	"Feed.seen":                     {"description": "Kept internally: the IDs of the items already summarized; left out of responses."},
+}
+
+// requiredFields lists request fields the handlers reject when missing.
+var requiredFields = map[string]bool{
+	"TextRequest.text":             true,
+	"SourceDocument.text":          true,
+	"TermDocument.text":            true,
+	"SummarizeRequest.text":        true,
+	"ChatSummaryRequest.text":      true,
+	"RewriteRequest.text":          true,
+	"ExplainEditsRequest.original": true,
+	"AskRequest.text":              true,
+	"AskRequest.question":          true,
+	"EmbedRequest.texts":           true,
+	"ExplainEditsRequest.edited":   true,
+	"OutlineRequest.text":          true,
+	"EntitiesRequest.text":         true,
+	"CompareRequest.text_a":        true,
+	"CompareRequest.text_b":        true,
+	"CustomRequest.text":           true,
+	"CustomOperation.name":         true,
+	"JobRequest.type":              true,
+	"ScheduleSpec.name":            true,
+	"ScheduleSpec.cron":            true,
+	"ScheduleSpec.operation":       true,
+	"FeedRequest.url":              true,
+	"uploadForm.file":              true,
+	"bookForm.file":                true,
+
+	"MultiSummaryRequest.documents": true,
+	"SessionMessageRequest.message": true,
+}
+
+var pathParamRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// buildOpenAPISpec returns the spec for routes as a JSON-encodable value.
+func buildOpenAPISpec(routes []apiRoute) map[string]interface{} {
+	b := &schemaBuilder{components: map[string]interface{}{}}
+	errorSchema := b.schema(reflect.TypeOf(APIError{}))
+	paths := map[string]map[string]interface{}{}
+
+	for _, rt := range routes {
+		op := map[string]interface{}{
+			"operationId": rt.ID,
+			"summary":     rt.Summary,
+			"tags":        []string{rt.Tag},
+		}
+
+		params := []interface{}{map[string]interface{}{"$ref": "#/components/parameters/UserID"}}
+		for _, m := range pathParamRe.FindAllStringSubmatch(rt.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name": m[1], "in": "path", "required": true, "schema": map[string]string{"type": "string"},
+			})
+		}
+		if rt.Echo {
+			params = append(params, map[string]interface{}{"$ref": "#/components/parameters/DebugEcho"},
+				map[string]interface{}{"$ref": "#/components/parameters/IncludeMeta"})
+			if rt.Method == http.MethodPost && !slices.ContainsFunc(dryRunUnsupported, func(p string) bool { return strings.HasPrefix(rt.Path, p) }) {
+				params = append(params, map[string]interface{}{"$ref": "#/components/parameters/DryRun"})
+			}
+			if rt.Method == http.MethodPost && hasTextField(rt.Request) {
+				params = append(params, map[string]interface{}{"$ref": "#/components/parameters/Evaluate"})
+			}
+		}
+		if rt.Private {
+			params = append(params, map[string]interface{}{"$ref": "#/components/parameters/Aggregate"})
+		}
+		if rt.Method == http.MethodPost {
+			params = append(params, map[string]interface{}{"$ref": "#/components/parameters/IdempotencyKey"})
+		}
+		if rt.Echo || rt.ETag {
+			params = append(params, map[string]interface{}{"$ref": "#/components/parameters/IfNoneMatch"})
+		}
+		for _, q := range rt.Query {
+			name, optional := strings.CutSuffix(q, "?")
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "query", "required": !optional, "schema": map[string]string{"type": "string"},
+			})
+		}
+		op["parameters"] = params
+
+		if rt.Request != nil {
+			mediaType := "application/json"
+			if rt.Multipart {
+				mediaType = "multipart/form-data"
+			}
+			body := map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{mediaType: map[string]interface{}{"schema": b.schema(reflect.TypeOf(rt.Request))}},
+			}
+			if rt.Echo && !rt.Multipart && hasTextField(rt.Request) {
+				body["description"] = "Instead of `text`, `document_id` may name a document stored with POST /documents."
+			}
+			op["requestBody"] = body
+		}
+
+		status := rt.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := map[string]interface{}{"description": http.StatusText(status)}
+		if rt.Response != nil {
+			ok["content"] = map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": b.schema(reflect.TypeOf(rt.Response))},
+			}
+		}
+		if len(rt.Produces) > 0 {
+			content, _ := ok["content"].(map[string]interface{})
+			if content == nil {
+				content = map[string]interface{}{}
+			}
+			for _, mt := range rt.Produces {
+				content[mt] = map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}
+			}
+			ok["content"] = content
+		}
+		responses := map[string]interface{}{strconv.Itoa(status): ok}
+		if rt.Echo || rt.ETag {
+			responses["304"] = map[string]interface{}{"description": "Not Modified: the If-None-Match ETag still matches the stored result"}
+		}
+		errs := slices.Clone(rt.Errors)
+		if rt.Admin || rt.Tenant {
+			errs = append(errs, http.StatusUnauthorized)
+		}
+		if rt.Echo {
+			errs = append(errs, http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests)
+		}
+		for _, code := range errs {
+			responses[strconv.Itoa(code)] = map[string]interface{}{
+				"description": http.StatusText(code),
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": errorSchema},
+				},
+			}
+		}
+		op["responses"] = responses
+		switch {
+		case rt.Admin:
+			op["security"] = []map[string][]string{{"adminToken": {}}}
+		case rt.Tenant:
+			op["security"] = []map[string][]string{{"apiKey": {}}}
+		case rt.Echo:
+			// a key is optional unless REQUIRE_API_KEY is set
+			op["security"] = []map[string][]string{{"apiKey": {}}, {}}
+		}
+
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = map[string]interface{}{}
+		}
+		paths[rt.Path][strings.ToLower(rt.Method)] = op
+	}
+
+	b.schema(reflect.TypeOf(ResponseMeta{}))
+	b.schema(reflect.TypeOf(DryRunReport{}))
+	b.schema(reflect.TypeOf(Evaluation{}))
+
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":       "AI Text Tools API",
+			"version":     "1.0.0",
+			"description": "Summarize, extract keywords, rewrite, generate questions and titles, and expand text with an LLM. Errors use the envelope {\"error\": {\"code\", \"message\", \"request_id\"}}; the unversioned paths are deprecated aliases that return plain-text errors.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+			"parameters": map[string]interface{}{
+				"UserID": map[string]interface{}{
+					"name": "X-User-ID", "in": "header", "required": false,
+					"description": "Identifies the caller for preferences, history and jobs. Defaults to \"default\".",
+					"schema":      map[string]interface{}{"type": "string", "maxLength": 128},
+				},
+				"IdempotencyKey": map[string]interface{}{
+					"name": "Idempotency-Key", "in": "header", "required": false,
+					"description": "Run the request at most once: a retry with the same key within IDEMPOTENCY_TTL gets the stored response, with an Idempotent-Replayed: true header. A key reused for a different body gets a 422.",
+					"schema":      map[string]interface{}{"type": "string", "maxLength": maxIdempotencyKey},
+				},
+				"IfNoneMatch": map[string]interface{}{
+					"name": "If-None-Match", "in": "header", "required": false,
+					"description": "The ETag of a result served from the result cache (\"reuse\": true) or the history: while it is still the stored result, the response is a 304 without a body.",
+					"schema":      map[string]interface{}{"type": "string"},
+				},
+				"DebugEcho": map[string]interface{}{
+					"name": "debug", "in": "query", "required": false,
+					"description": "\"echo\" returns the rendered prompts as the model output without calling the model (requires the debug_echo flag).",
+					"schema":      map[string]interface{}{"type": "string", "enum": []string{"echo"}},
+				},
+				"DryRun": map[string]interface{}{
+					"name": "dry_run", "in": "query", "required": false,
+					"description": "\"true\" answers with the model calls the request would make (a DryRunReport: model, prompt tokens, most completion tokens and cost) without calling the model. The prompts are included with the debug_echo flag on.",
+					"schema":      map[string]interface{}{"type": "boolean"},
+				},
+				"Evaluate": map[string]interface{}{
+					"name": "evaluate", "in": "query", "required": false,
+					"description": "\"true\" scores the result in a second LLM pass and adds it to the response as \"evaluation\" (an Evaluation: faithfulness, completeness and fluency, 1–5 with a rationale each), or \"evaluation_error\" if that pass fails.",
+					"schema":      map[string]interface{}{"type": "boolean"},
+				},
+				"IncludeMeta": map[string]interface{}{
+					"name": "include_meta", "in": "query", "required": false,
+					"description": "\"true\" adds a \"meta\" object (ResponseMeta) to the response: model, token counts, latency, finish reason and cache status.",
+					"schema":      map[string]interface{}{"type": "string", "enum": []string{"true"}},
+				},
+				"Aggregate": map[string]interface{}{
+					"name": "aggregate", "in": "query", "required": false,
+					"description": "\"private\" leaves out routes with few users, adds noise to counts and drops recent errors (always on with USAGE_AGGREGATION=private).",
+					"schema":      map[string]interface{}{"type": "string", "enum": []string{"private"}},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]string{"type": "http", "scheme": "bearer", "description": "The server's ADMIN_TOKEN."},
+				"apiKey":     map[string]string{"type": "apiKey", "in": "header", "name": "X-API-Key", "description": "A tenant's API key (tk_...); also accepted as a bearer token."},
+			},
+		},
+	}
+}
+
+// schemaBuilder turns Go types into JSON Schemas, collecting named structs
+// under components/schemas.
+type schemaBuilder struct {
+	components map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.object(t)
+		}
+		name := componentName(t)
+		if _, ok := b.components[name]; !ok {
+			b.components[name] = nil // guards against recursion
+			b.components[name] = b.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default: // interface{}: any JSON value
+		return map[string]interface{}{}
+	}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var required []string
+	b.fields(t, props, &required)
+	obj := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		obj["required"] = required
+	}
+	return obj
+}
+
+// fields adds the JSON-visible fields of t to props, flattening embedded
+// structs the way encoding/json does.
+func (b *schemaBuilder) fields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			b.fields(f.Type, props, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		s := b.schema(f.Type)
+		key := t.Name() + "." + name
+		for k, v := range fieldDocs[key] {
+			s[k] = v
+		}
+		props[name] = s
+		if requiredFields[key] {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// hasTextField reports whether the JSON request body v has a "text" field.
+func hasTextField(v interface{}) bool {
+	if v == nil || reflect.TypeOf(v).Kind() != reflect.Struct {
+		return false
+	}
+	props := map[string]interface{}{}
+	(&schemaBuilder{components: map[string]interface{}{}}).fields(reflect.TypeOf(v), props, new([]string))
+	_, ok := props["text"]
+	return ok
+}
+
+// componentName is the exported form of a Go type name.
+func componentName(t reflect.Type) string {
+	r := []rune(t.Name())
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// openAPIHandler serves the spec, with the server URL (the versioned API
+// root) taken from the request so generated clients and LLM tool
+// integrations can call it directly.
+func openAPIHandler() http.HandlerFunc {
+	specs := map[bool]map[string]interface{}{
+		false: buildOpenAPISpec(apiRoutes(false)),
+		true:  buildOpenAPISpec(apiRoutes(true)),
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		spec := specs[features.Enabled("plain_medical")]
+		scheme := "http"
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		out := make(map[string]interface{}, len(spec)+1)
+		for k, v := range spec {
+			out[k] = v
+		}
+		out["servers"] = []map[string]string{{"url": scheme + "://" + r.Host + apiPrefix}}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		writeJSON(w, http.StatusOK, out)
+	}
+}
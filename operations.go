@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Built-in operations ---
+//
+// The operations themselves live in the texttools package; these wrap them
+// into the API response types and apply the local fallbacks. The HTTP
+// handlers are thin wrappers around these.
+
+func summarize(ctx context.Context, tools *texttools.Tools, req TextRequest) (SummarizeResponse, error) {
+	out, err := tools.Summarize(ctx, req.Text, req.Options.Options)
+	if err != nil {
+		if degrade("summarize", err) {
+			text := texttools.PrepareInput(req.Text, req.InputFormat)
+			summary := texttools.CutWords(localSummary(text, req.Length), req.MaxWords)
+			return SummarizeResponse{Summary: texttools.FormatOutput(summary, req.OutputFormat), ResultMeta: degradedMeta("textrank")}, nil
+		}
+		return SummarizeResponse{}, err
+	}
+	return SummarizeResponse{Summary: out}, nil
+}
+
+func keywords(ctx context.Context, tools *texttools.Tools, req TextRequest) (KeywordsResponse, error) {
+	kws, err := tools.Keywords(ctx, req.Text, req.Options.Options)
+	if err != nil {
+		if degrade("keywords", err) {
+			text := texttools.PrepareInput(req.Text, req.InputFormat)
+			return KeywordsResponse{Keywords: localKeywords(text, 10), ResultMeta: degradedMeta("rake")}, nil
+		}
+		return KeywordsResponse{}, err
+	}
+	return KeywordsResponse{Keywords: kws}, nil
+}
+
+func rewrite(ctx context.Context, tools *texttools.Tools, req RewriteRequest) (RewriteResponse, error) {
+	out, err := tools.Rewrite(ctx, req.Text, req.Tone, req.Options.Options)
+	if err != nil {
+		return RewriteResponse{}, err
+	}
+	return RewriteResponse{Text: out}, nil
+}
+
+func questions(ctx context.Context, tools *texttools.Tools, req TextRequest) (QuestionsResponse, error) {
+	qs, err := tools.Questions(ctx, req.Text, req.Options.Options)
+	if err != nil {
+		return QuestionsResponse{}, err
+	}
+	return QuestionsResponse{Questions: qs}, nil
+}
+
+func titles(ctx context.Context, tools *texttools.Tools, req TextRequest) (TitlesResponse, error) {
+	ts, err := tools.Titles(ctx, req.Text, req.Options.Options)
+	if err != nil {
+		return TitlesResponse{}, err
+	}
+	return TitlesResponse{Titles: ts}, nil
+}
+
+func expand(ctx context.Context, tools *texttools.Tools, req TextRequest) (ExpandResponse, error) {
+	out, err := tools.Expand(ctx, req.Text, req.Options.Options)
+	if err != nil {
+		return ExpandResponse{}, err
+	}
+	return ExpandResponse{Text: out}, nil
+}
+
+func toBullets(ctx context.Context, tools *texttools.Tools, req TextRequest) (BulletsResponse, error) {
+	out, err := tools.ToBullets(ctx, req.Text, req.Options.Options)
+	if err != nil {
+		return BulletsResponse{}, err
+	}
+	return BulletsResponse{Text: out}, nil
+}
+
+func toProse(ctx context.Context, tools *texttools.Tools, req TextRequest) (ProseResponse, error) {
+	out, err := tools.ToProse(ctx, req.Text, req.Options.Options)
+	if err != nil {
+		return ProseResponse{}, err
+	}
+	return ProseResponse{Text: out}, nil
+}
+
+// builtinOperations lists the operations runOperation accepts.
+var builtinOperations = []string{"summarize", "keywords", "rewrite", "questions", "titles", "expand", "to-bullets", "to-prose"}
+
+func isBuiltinOperation(name string) bool {
+	for _, op := range builtinOperations {
+		if op == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runOperation runs a built-in operation chosen by name, for endpoints that
+// take the operation as a parameter. Tone is only used by rewrite. Text
+// results are wrapped by their output wrapper.
+func runOperation(ctx context.Context, tools *texttools.Tools, name string, req RewriteRequest) (interface{}, error) {
+	text := TextRequest{Text: req.Text, Options: req.Options}
+	switch name {
+	case "summarize":
+		resp, err := summarize(ctx, tools, text)
+		if err == nil {
+			resp.Summary = outputWrappers.Wrap(name, resp.Summary, req.Options)
+		}
+		return resp, err
+	case "keywords":
+		return keywords(ctx, tools, text)
+	case "rewrite":
+		resp, err := rewrite(ctx, tools, req)
+		if err == nil {
+			resp.Text = outputWrappers.Wrap(name, resp.Text, req.Options)
+		}
+		return resp, err
+	case "questions":
+		return questions(ctx, tools, text)
+	case "titles":
+		return titles(ctx, tools, text)
+	case "expand":
+		resp, err := expand(ctx, tools, text)
+		if err == nil {
+			resp.Text = outputWrappers.Wrap(name, resp.Text, req.Options)
+		}
+		return resp, err
+	case "to-bullets":
+		resp, err := toBullets(ctx, tools, text)
+		if err == nil {
+			resp.Text = outputWrappers.Wrap(name, resp.Text, req.Options)
+		}
+		return resp, err
+	case "to-prose":
+		resp, err := toProse(ctx, tools, text)
+		if err == nil {
+			resp.Text = outputWrappers.Wrap(name, resp.Text, req.Options)
+		}
+		return resp, err
+	default:
+		return nil, fmt.Errorf("unknown operation %q", name)
+	}
+}
+
+// streamableOperations are the text operations that can stream their output.
+var streamableOperations = texttools.StreamOperations
+
+func isStreamableOperation(name string) bool {
+	for _, op := range streamableOperations {
+		if op == name {
+			return true
+		}
+	}
+	return false
+}
+
+// streamOperation runs a text operation with a streamed LLM response,
+// passing the raw output to onDelta as it arrives, and returns the complete
+// result formatted like the non-streaming operation.
+func streamOperation(ctx context.Context, tools *texttools.Tools, name string, req RewriteRequest, onDelta func(string) error) (string, error) {
+	return tools.Stream(ctx, name, texttools.Input{Text: req.Text, Tone: req.Tone, Options: req.Options.Options}, onDelta)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Outlines and drafts ---
+//
+// POST /outline turns prose into a hierarchical outline of sections and
+// bullet points; POST /draft turns an outline (that JSON, or a Markdown
+// outline) back into prose. Together they support editing a text at the
+// level of its structure.
+
+const (
+	defaultOutlineDepth = 3
+	maxOutlineDepth     = 4
+)
+
+// Outline is a text's structure: nested sections, each with its points.
+type Outline struct {
+	Title    string           `json:"title"`
+	Sections []OutlineSection `json:"sections"`
+}
+
+type OutlineSection struct {
+	Heading  string           `json:"heading"`
+	Points   []string         `json:"points"`
+	Sections []OutlineSection `json:"sections,omitempty"` // subsections
+}
+
+type OutlineRequest struct {
+	Text  string `json:"text"`
+	Depth int    `json:"depth"` // levels of sections, 1–4 (default 3)
+	Options
+}
+
+type OutlineResponse struct {
+	Outline
+	ResultMeta
+}
+
+type DraftRequest struct {
+	Outline *Outline `json:"outline"` // as returned by /outline
+	Text    string   `json:"text"`    // or a Markdown outline
+	Tone    string   `json:"tone"`
+	Options
+}
+
+type DraftResponse struct {
+	Draft string `json:"draft"`
+	ResultMeta
+}
+
+// outlinePromptInput is the data of the outline template.
+type outlinePromptInput struct {
+	texttools.Input
+	Depth int
+}
+
+func outlineHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req OutlineRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if req.Depth == 0 {
+			req.Depth = defaultOutlineDepth
+		}
+		if req.Depth < 1 || req.Depth > maxOutlineDepth {
+			http.Error(w, fmt.Sprintf("`depth` must be between 1 and %d", maxOutlineDepth), http.StatusBadRequest)
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey(fmt.Sprint(req.Depth), req.Options)
+		if prior, ok := history.Reusable(r, "outline", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		in := outlinePromptInput{
+			Input: texttools.Input{Text: texttools.PrepareInput(req.Text, req.InputFormat), Options: req.Options.Options},
+			Depth: req.Depth,
+		}
+		out, err := tools.Prompt(r.Context(), "outline", in, in.Options)
+		if err != nil {
+			log.Println("outline error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var resp OutlineResponse
+		if err := json.Unmarshal([]byte(out), &resp.Outline); err != nil {
+			// fallback – the model answered with a Markdown outline
+			resp.Outline = parseMarkdownOutline(out)
+		}
+		resp.Sections = normalizeSections(resp.Sections, req.Depth)
+
+		resp.ResultMeta = history.Record(r, "outline", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func draftHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req DraftRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		outline := req.Text
+		if req.Outline != nil {
+			if req.Text != "" {
+				http.Error(w, "give either `outline` or `text`, not both", http.StatusBadRequest)
+				return
+			}
+			outline = req.Outline.Markdown()
+		}
+		if strings.TrimSpace(outline) == "" {
+			http.Error(w, "`outline` or `text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, outline) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p := prefs.For(r)
+		p.apply(&req.Options)
+		if req.Tone == "" {
+			req.Tone = p.Tone
+		}
+
+		key := historyKey(req.Tone, req.Options)
+		if prior, ok := history.Reusable(r, "draft", key, outline, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		in := texttools.Input{Text: outline, Tone: req.Tone, Options: req.Options.Options}
+		out, err := tools.Prompt(r.Context(), "draft", in, in.Options)
+		if err != nil {
+			log.Println("draft error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		draft := texttools.FormatOutput(tools.FitWords(r.Context(), out, req.Options.Options), req.OutputFormat)
+		resp := DraftResponse{Draft: outputWrappers.Wrap("draft", draft, req.Options)}
+		resp.ResultMeta = history.Record(r, "draft", key, outline, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// Markdown renders the outline as headings and bullet points.
+func (o *Outline) Markdown() string {
+	var sb strings.Builder
+	if o.Title != "" {
+		fmt.Fprintf(&sb, "# %s\n\n", o.Title)
+	}
+	var write func(sections []OutlineSection, level int)
+	write = func(sections []OutlineSection, level int) {
+		for _, s := range sections {
+			if s.Heading != "" {
+				fmt.Fprintf(&sb, "%s %s\n\n", strings.Repeat("#", min(level, 6)), s.Heading)
+			}
+			for _, p := range s.Points {
+				fmt.Fprintf(&sb, "- %s\n", p)
+			}
+			if len(s.Points) > 0 {
+				sb.WriteByte('\n')
+			}
+			write(s.Sections, level+1)
+		}
+	}
+	write(o.Sections, 2)
+	return strings.TrimSpace(sb.String())
+}
+
+// listItemRe matches the marker of a Markdown list item.
+var listItemRe = regexp.MustCompile(`^(?:[-*+•]|\d+[.)])\s+`)
+
+// parseMarkdownOutline reads "#" headings as (nested) sections and list
+// items as their points.
+func parseMarkdownOutline(md string) Outline {
+	var o Outline
+	var path []*[]OutlineSection // path[i] holds the sections at level i+1
+	current := func() *OutlineSection {
+		if len(path) == 0 {
+			return nil
+		}
+		list := *path[len(path)-1]
+		return &list[len(list)-1]
+	}
+	for _, line := range strings.Split(md, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#"):
+			level := len(line) - len(strings.TrimLeft(line, "#"))
+			heading := strings.TrimSpace(line[level:])
+			if level == 1 && o.Title == "" && len(o.Sections) == 0 {
+				o.Title = heading
+				continue
+			}
+			level = max(level-1, 1) // "##" are the top-level sections
+			if level > len(path)+1 {
+				level = len(path) + 1
+			}
+			path = path[:level-1]
+			list := &o.Sections
+			if parent := current(); parent != nil {
+				list = &parent.Sections
+			}
+			*list = append(*list, OutlineSection{Heading: heading})
+			path = append(path, list)
+		case listItemRe.MatchString(line):
+			if current() == nil {
+				o.Sections = append(o.Sections, OutlineSection{})
+				path = append(path, &o.Sections)
+			}
+			s := current()
+			s.Points = append(s.Points, listItemRe.ReplaceAllString(line, ""))
+		}
+	}
+	return o
+}
+
+// normalizeSections drops sections nested deeper than depth (keeping their
+// points in the parent) and replaces nil point lists with empty ones.
+func normalizeSections(sections []OutlineSection, depth int) []OutlineSection {
+	if sections == nil {
+		return []OutlineSection{}
+	}
+	for i := range sections {
+		s := &sections[i]
+		if s.Points == nil {
+			s.Points = []string{}
+		}
+		if depth <= 1 {
+			for _, sub := range flattenSections(s.Sections) {
+				s.Points = append(s.Points, sub.Points...)
+			}
+			s.Sections = nil
+			continue
+		}
+		if len(s.Sections) > 0 {
+			s.Sections = normalizeSections(s.Sections, depth-1)
+		}
+	}
+	return sections
+}
+
+func flattenSections(sections []OutlineSection) []OutlineSection {
+	var out []OutlineSection
+	for _, s := range sections {
+		out = append(out, s)
+		out = append(out, flattenSections(s.Sections)...)
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Writing plans ---
+//
+// POST /plan turns a short brief or summary into a writing plan: sections
+// with their key points, suggested sources to cite and a target length.
+// Each section comes with a brief, ready to be sent as the text of POST
+// /expand, so a client can write the piece section by section.
+
+const maxPlanSections = 20
+
+type PlanRequest struct {
+	Text     string `json:"text"`     // the brief or summary
+	Sections int    `json:"sections"` // how many sections; the model decides if 0
+	Words    int    `json:"words"`    // target length of the whole piece, if any
+	Audience string `json:"audience"` // e.g. "engineering managers"
+	Options
+}
+
+type PlanResponse struct {
+	Title    string        `json:"title"`
+	Sections []PlanSection `json:"sections"`
+	ResultMeta
+}
+
+type PlanSection struct {
+	Heading   string   `json:"heading"`
+	Purpose   string   `json:"purpose"` // what the section is for
+	KeyPoints []string `json:"key_points"`
+	Sources   []string `json:"sources"` // kinds of sources, or well-known works, worth citing
+	Words     int      `json:"words,omitempty"`
+	Brief     string   `json:"brief"` // the section as text for POST /expand
+}
+
+// planPromptInput is the data of the plan template.
+type planPromptInput struct {
+	texttools.Input
+	Sections int
+	Words    int
+	Audience string
+}
+
+func planHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req PlanRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if req.Sections < 0 || req.Sections > maxPlanSections {
+			http.Error(w, fmt.Sprintf("`sections` must be between 1 and %d (0 lets the model decide)", maxPlanSections), http.StatusBadRequest)
+			return
+		}
+		if req.Words < 0 {
+			http.Error(w, "`words` must be positive", http.StatusBadRequest)
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey(fmt.Sprintf("%d|%d|%s", req.Sections, req.Words, req.Audience), req.Options)
+		if prior, ok := history.Reusable(r, "plan", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		in := planPromptInput{
+			Input:    texttools.Input{Text: texttools.PrepareInput(req.Text, req.InputFormat), Options: req.Options.Options},
+			Sections: req.Sections,
+			Words:    req.Words,
+			Audience: req.Audience,
+		}
+		out, err := tools.Prompt(r.Context(), "plan", in, in.Options)
+		if err != nil {
+			log.Println("plan error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var resp PlanResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			// fallback – read the output as a Markdown outline
+			o := parseMarkdownOutline(out)
+			resp = PlanResponse{Title: o.Title}
+			for _, s := range o.Sections {
+				resp.Sections = append(resp.Sections, PlanSection{Heading: s.Heading, KeyPoints: s.Points})
+			}
+		}
+		if resp.Sections == nil {
+			resp.Sections = []PlanSection{}
+		}
+		for i := range resp.Sections {
+			s := &resp.Sections[i]
+			if s.KeyPoints == nil {
+				s.KeyPoints = []string{}
+			}
+			if s.Sources == nil {
+				s.Sources = []string{}
+			}
+			s.Brief = s.brief(resp.Title, req.Audience)
+		}
+
+		resp.ResultMeta = history.Record(r, "plan", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// brief writes the section up as the input of /expand.
+func (s *PlanSection) brief(title, audience string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "## %s\n\n", s.Heading)
+	if title != "" {
+		fmt.Fprintf(&sb, "Part of: %s\n", title)
+	}
+	if audience != "" {
+		fmt.Fprintf(&sb, "Audience: %s\n", audience)
+	}
+	if s.Purpose != "" {
+		fmt.Fprintf(&sb, "Purpose: %s\n", s.Purpose)
+	}
+	if s.Words > 0 {
+		fmt.Fprintf(&sb, "Length: about %d words\n", s.Words)
+	}
+	for _, p := range s.KeyPoints {
+		fmt.Fprintf(&sb, "\n- %s", p)
+	}
+	if len(s.Sources) > 0 {
+		sb.WriteString("\n\nSources to cite:")
+		for _, src := range s.Sources {
+			fmt.Fprintf(&sb, "\n- %s", src)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Debug echo ---
+//
+// With the debug_echo flag on (DEBUG_ECHO=true, or from the admin
+// dashboard), LLM endpoints accept ?debug=echo and answer with the rendered
+// prompts in place of the model output, without calling the model. The
+// playground uses it to show what a request would send.
+
+type debugEchoKey struct{}
+
+func debugEcho(ctx context.Context) bool {
+	on, _ := ctx.Value(debugEchoKey{}).(bool)
+	return on
+}
+
+// withDebugEcho marks requests with ?debug=echo.
+func withDebugEcho(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("debug") != "echo" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !features.Enabled("debug_echo") {
+			http.Error(w, "debug echo is disabled (turn on the debug_echo flag)", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-Debug-Echo", "true")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), debugEchoKey{}, true)))
+	})
+}
+
+// echoProvider answers debug echo requests itself and passes everything
+// else to the wrapped provider.
+type echoProvider struct {
+	texttools.StreamProvider
+}
+
+func (p echoProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	if debugEcho(ctx) {
+		return texttools.Completion{Text: echoText(req)}, nil
+	}
+	return p.StreamProvider.Complete(ctx, req)
+}
+
+func (p echoProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	if debugEcho(ctx) {
+		text := echoText(req)
+		return texttools.Completion{Text: text}, onDelta(text)
+	}
+	return p.StreamProvider.Stream(ctx, req, onDelta)
+}
+
+func echoText(req texttools.Request) string {
+	var sb strings.Builder
+	if req.Model != "" {
+		sb.WriteString("[model: " + req.Model + "]\n\n")
+	}
+	if p, _ := json.Marshal(req.Params); string(p) != "{}" {
+		sb.WriteString("[params: " + string(p) + "]\n\n")
+	}
+	sb.WriteString("[system]\n" + req.System + "\n\n[user]\n" + req.Prompt)
+	for _, img := range req.Images {
+		fmt.Fprintf(&sb, "\n\n[image: %s, %d bytes]", img.MIMEType, len(img.Data))
+	}
+	return sb.String()
+}
+
+// --- API playground (vanilla, no frameworks) ---
+
+// playgroundHandler serves a request builder for every endpoint in the
+// OpenAPI spec, with curl and Go snippets for the request built.
+func playgroundHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(playgroundHTML))
+}
+
+const playgroundHTML = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8" />
+  <title>AI Text Tools – API Playground</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1" />
+  <style>
+    body { font-family: system-ui, -apple-system, BlinkMacSystemFont, sans-serif; margin: 0; padding: 2rem; background: #f5f5f5; color: #222; }
+    .container { max-width: 1100px; margin: 0 auto; }
+    h1 { margin-top: 0; }
+    .muted { color: #777; font-size: 0.85rem; }
+    .layout { display: grid; grid-template-columns: minmax(0, 1fr) minmax(0, 1fr); gap: 1rem; }
+    @media (max-width: 900px) { .layout { grid-template-columns: 1fr; } }
+    .card { background: #fff; padding: 1rem 1.25rem; border-radius: 8px; box-shadow: 0 2px 8px rgba(0,0,0,0.05); margin-bottom: 1rem; }
+    .card h2 { font-size: 1rem; margin: 0 0 0.75rem; color: #555; }
+    label { display: block; font-size: 0.85rem; font-weight: 600; margin: 0.6rem 0 0.2rem; }
+    label .req { color: #b91c1c; }
+    label .hint { font-weight: normal; color: #777; }
+    input, select, textarea { width: 100%; box-sizing: border-box; padding: 6px 8px; border: 1px solid #ccc; border-radius: 6px; font: inherit; font-size: 0.9rem; }
+    input[type=checkbox] { width: auto; }
+    textarea { min-height: 70px; resize: vertical; }
+    button { border: none; padding: 8px 14px; border-radius: 6px; font-size: 0.9rem; cursor: pointer; background: #2563eb; color: #fff; margin-top: 0.75rem; }
+    button.secondary { background: #e5e7eb; color: #111827; margin-left: 0.5rem; }
+    pre { background: #111827; color: #e5e7eb; padding: 0.75rem; border-radius: 6px; white-space: pre-wrap; word-wrap: break-word; font-size: 0.8rem; max-height: 360px; overflow-y: auto; margin: 0.5rem 0 0; }
+    .tabs { display: flex; gap: 0.25rem; }
+    .tabs button { margin: 0; background: #e5e7eb; color: #111827; padding: 4px 10px; }
+    .tabs button.active { background: #2563eb; color: #fff; }
+    .status { font-weight: 600; }
+    .status.err { color: #b91c1c; }
+    .status.ok { color: #15803d; }
+    .method { display: inline-block; min-width: 3.5rem; font-weight: 600; }
+  </style>
+</head>
+<body>
+  <div class="container">
+    <h1>API Playground</h1>
+    <p class="muted">Requests go to <code id="base"></code>. Fields come from <a href="/openapi.json">/openapi.json</a> · <a href="/">back to the tools</a></p>
+
+    <div class="layout">
+      <div>
+        <div class="card">
+          <h2>Request</h2>
+          <label for="endpoint">Endpoint</label>
+          <select id="endpoint"></select>
+          <p class="muted" id="summary"></p>
+          <div id="fields"></div>
+          <label><input type="checkbox" id="echo" /> Show the rendered prompt instead of calling the model <span class="hint">(?debug=echo, needs the debug_echo flag)</span></label>
+          <button id="send">Send</button>
+        </div>
+      </div>
+
+      <div>
+        <div class="card">
+          <h2>Response</h2>
+          <div><span id="status" class="status">–</span> <span id="timing" class="muted"></span></div>
+          <pre id="response">–</pre>
+        </div>
+        <div class="card">
+          <h2>Code</h2>
+          <div class="tabs">
+            <button data-lang="curl" class="active">curl</button>
+            <button data-lang="go">Go</button>
+            <button class="secondary" id="copy">Copy</button>
+          </div>
+          <pre id="snippet"></pre>
+        </div>
+      </div>
+    </div>
+  </div>
+
+  <script>
+    let spec, base, ops = [], current, lang = 'curl';
+    const $ = id => document.getElementById(id);
+
+    function resolve(schema) {
+      while (schema && schema.$ref) {
+        schema = schema.$ref.split('/').slice(1).reduce((o, k) => o[k], spec);
+      }
+      return schema || {};
+    }
+
+    async function init() {
+      spec = await (await fetch('/openapi.json')).json();
+      base = spec.servers[0].url;
+      $('base').textContent = base;
+      for (const [path, methods] of Object.entries(spec.paths)) {
+        for (const [method, op] of Object.entries(methods)) {
+          ops.push({ path, method: method.toUpperCase(), op });
+        }
+      }
+      ops.sort((a, b) => (a.op.tags[0] + a.path + a.method).localeCompare(b.op.tags[0] + b.path + b.method));
+      let group;
+      ops.forEach((o, i) => {
+        if (!group || group.label !== o.op.tags[0]) {
+          group = document.createElement('optgroup');
+          group.label = o.op.tags[0];
+          $('endpoint').appendChild(group);
+        }
+        const opt = document.createElement('option');
+        opt.value = i;
+        opt.textContent = o.method + ' ' + o.path;
+        group.appendChild(opt);
+      });
+      const wanted = ops.findIndex(o => o.path === '/summarize');
+      $('endpoint').value = wanted >= 0 ? wanted : 0;
+      select();
+    }
+
+    // --- Form ---
+
+    function field(name, schema, required, where) {
+      schema = resolve(schema);
+      const id = 'f-' + where + '-' + name;
+      const wrap = document.createElement('div');
+      const label = document.createElement('label');
+      label.htmlFor = id;
+      label.textContent = name + ' ';
+      if (required) label.innerHTML += '<span class="req">*</span> ';
+      const hint = document.createElement('span');
+      hint.className = 'hint';
+      hint.textContent = schema.description || '';
+      label.appendChild(hint);
+      wrap.appendChild(label);
+
+      let el, kind = 'string';
+      if (schema.enum) {
+        el = document.createElement('select');
+        for (const v of [''].concat(schema.enum)) {
+          const opt = document.createElement('option');
+          opt.value = opt.textContent = v;
+          el.appendChild(opt);
+        }
+      } else if (schema.type === 'boolean') {
+        el = document.createElement('select');
+        for (const v of ['', 'true', 'false']) {
+          const opt = document.createElement('option');
+          opt.value = opt.textContent = v;
+          el.appendChild(opt);
+        }
+        kind = 'boolean';
+      } else if (schema.type === 'integer' || schema.type === 'number') {
+        el = document.createElement('input');
+        el.type = 'number';
+        kind = 'number';
+      } else if (schema.format === 'binary') {
+        el = document.createElement('input');
+        el.type = 'file';
+        kind = 'file';
+      } else if (schema.type === 'array' && resolve(schema.items).type === 'string') {
+        el = document.createElement('textarea');
+        el.placeholder = 'one per line';
+        kind = 'lines';
+      } else if (schema.type === 'object' || schema.type === 'array') {
+        el = document.createElement('textarea');
+        el.placeholder = 'JSON';
+        kind = 'json';
+      } else if (name === 'text' || name === 'prompt') {
+        el = document.createElement('textarea');
+      } else {
+        el = document.createElement('input');
+      }
+      el.id = id;
+      el.dataset.name = name;
+      el.dataset.kind = kind;
+      el.dataset.where = where;
+      el.addEventListener('input', renderSnippet);
+      el.addEventListener('change', renderSnippet);
+      wrap.appendChild(el);
+      $('fields').appendChild(wrap);
+    }
+
+    function select() {
+      current = ops[$('endpoint').value];
+      const op = current.op;
+      $('summary').textContent = op.summary;
+      $('fields').innerHTML = '';
+
+      current.echo = false;
+      for (let p of op.parameters || []) {
+        if (p.$ref === '#/components/parameters/DebugEcho') {
+          current.echo = true;
+          continue;
+        }
+        p = resolve(p);
+        field(p.name, p.schema, p.required, p.in);
+      }
+      if (op.security) field('Authorization', { type: 'string', description: 'Bearer <ADMIN_TOKEN>' }, true, 'auth');
+
+      current.mediaType = null;
+      if (op.requestBody) {
+        const [mediaType, media] = Object.entries(op.requestBody.content)[0];
+        current.mediaType = mediaType;
+        const schema = resolve(media.schema);
+        for (const [name, prop] of Object.entries(schema.properties || {})) {
+          field(name, prop, (schema.required || []).includes(name), 'body');
+        }
+      }
+      $('echo').disabled = !current.echo;
+      if (!current.echo) $('echo').checked = false;
+      renderSnippet();
+    }
+
+    // build returns the request the form describes.
+    function build() {
+      let path = current.path;
+      const query = new URLSearchParams(), headers = {}, body = {}, files = {};
+      for (const el of $('fields').querySelectorAll('[data-name]')) {
+        const name = el.dataset.name, where = el.dataset.where;
+        if (el.dataset.kind === 'file') {
+          if (el.files[0]) files[name] = el.files[0];
+          continue;
+        }
+        const raw = el.value.trim();
+        if (raw === '') continue;
+        if (where === 'path') path = path.replace('{' + name + '}', encodeURIComponent(raw));
+        else if (where === 'query') query.set(name, raw);
+        else if (where === 'header') headers[name] = raw;
+        else if (where === 'auth') headers.Authorization = raw.startsWith('Bearer ') ? raw : 'Bearer ' + raw;
+        else body[name] = value(el, raw);
+      }
+      if ($('echo').checked) query.set('debug', 'echo');
+      const qs = query.toString();
+      return { method: current.method, url: base + path + (qs ? '?' + qs : ''), headers, body, files };
+    }
+
+    function value(el, raw) {
+      switch (el.dataset.kind) {
+        case 'boolean': return raw === 'true';
+        case 'number': return Number(raw);
+        case 'lines': return raw.split('\n').map(s => s.trim()).filter(Boolean);
+        case 'json':
+          try { return JSON.parse(raw); } catch (e) { return raw; }
+        default: return raw;
+      }
+    }
+
+    async function send() {
+      const req = build();
+      const init = { method: req.method, headers: Object.assign({}, req.headers) };
+      if (current.mediaType === 'multipart/form-data') {
+        const form = new FormData();
+        for (const [k, v] of Object.entries(req.body)) form.append(k, typeof v === 'string' ? v : JSON.stringify(v));
+        for (const [k, f] of Object.entries(req.files)) form.append(k, f);
+        init.body = form;
+      } else if (current.mediaType) {
+        init.headers['Content-Type'] = 'application/json';
+        init.body = JSON.stringify(req.body);
+      }
+      $('status').textContent = 'Sending...';
+      $('status').className = 'status';
+      const start = performance.now();
+      try {
+        const res = await fetch(req.url, init);
+        const text = await res.text();
+        $('timing').textContent = Math.round(performance.now() - start) + ' ms · request ' + (res.headers.get('X-Request-ID') || '');
+        $('status').textContent = res.status + ' ' + res.statusText;
+        $('status').className = 'status ' + (res.ok ? 'ok' : 'err');
+        let out = text;
+        try {
+          const data = JSON.parse(text);
+          out = JSON.stringify(data, null, 2);
+          if (res.headers.get('X-Debug-Echo')) out = echoed(data) || out;
+        } catch (e) {}
+        $('response').textContent = out || '(empty)';
+      } catch (err) {
+        $('status').textContent = err.message;
+        $('status').className = 'status err';
+      }
+    }
+
+    // echoed pulls the rendered prompt out of a debug echo response.
+    function echoed(data) {
+      for (const v of Object.values(data)) {
+        if (typeof v === 'string' && v.includes('[system]')) return v;
+        if (Array.isArray(v) && typeof v[0] === 'string' && v[0].includes('[system]')) return v[0];
+        if (v && typeof v === 'object') {
+          const inner = echoed(v);
+          if (inner) return inner;
+        }
+      }
+      return null;
+    }
+
+    // --- Snippets ---
+
+    function shellQuote(s) {
+      return "'" + s.replace(/'/g, "'\\''") + "'";
+    }
+
+    function goQuote(s) {
+      return s.includes('` + "`" + `') ? JSON.stringify(s) : '` + "`" + `' + s + '` + "`" + `';
+    }
+
+    function curlSnippet(req) {
+      const lines = ['curl -X ' + req.method + ' ' + shellQuote(req.url)];
+      for (const [k, v] of Object.entries(req.headers)) lines.push('  -H ' + shellQuote(k + ': ' + v));
+      if (current.mediaType === 'multipart/form-data') {
+        for (const [k, v] of Object.entries(req.body)) lines.push('  -F ' + shellQuote(k + '=' + (typeof v === 'string' ? v : JSON.stringify(v))));
+        for (const [k, f] of Object.entries(req.files)) lines.push('  -F ' + shellQuote(k + '=@' + f.name));
+      } else if (current.mediaType) {
+        lines.push("  -H 'Content-Type: application/json'");
+        lines.push('  -d ' + shellQuote(JSON.stringify(req.body)));
+      }
+      return lines.join(' \\\n');
+    }
+
+    function goSnippet(req) {
+      const multipart = current.mediaType === 'multipart/form-data';
+      const imports = ['"fmt"', '"io"', '"log"', '"net/http"'];
+      if (multipart) imports.push('"bytes"', '"mime/multipart"', '"os"');
+      else if (current.mediaType) imports.push('"strings"');
+      imports.sort();
+
+      const out = ['package main', '', 'import (', ...imports.map(i => '\t' + i), ')', '', 'func main() {'];
+      let body = 'nil';
+      if (multipart) {
+        out.push('\tvar buf bytes.Buffer', '\tw := multipart.NewWriter(&buf)');
+        for (const [k, v] of Object.entries(req.body)) {
+          out.push('\tw.WriteField(' + JSON.stringify(k) + ', ' + goQuote(typeof v === 'string' ? v : JSON.stringify(v)) + ')');
+        }
+        for (const [k, f] of Object.entries(req.files)) {
+          out.push('\tdata, err := os.ReadFile(' + JSON.stringify(f.name) + ')', '\tif err != nil {', '\t\tlog.Fatal(err)', '\t}');
+          out.push('\tpart, _ := w.CreateFormFile(' + JSON.stringify(k) + ', ' + JSON.stringify(f.name) + ')', '\tpart.Write(data)');
+        }
+        out.push('\tw.Close()', '');
+        body = '&buf';
+      } else if (current.mediaType) {
+        out.push('\tbody := strings.NewReader(' + goQuote(JSON.stringify(req.body, null, 2)) + ')', '');
+        body = 'body';
+      }
+      out.push('\treq, err := http.NewRequest(' + JSON.stringify(req.method) + ', ' + JSON.stringify(req.url) + ', ' + body + ')');
+      out.push('\tif err != nil {', '\t\tlog.Fatal(err)', '\t}');
+      if (multipart) out.push('\treq.Header.Set("Content-Type", w.FormDataContentType())');
+      else if (current.mediaType) out.push('\treq.Header.Set("Content-Type", "application/json")');
+      for (const [k, v] of Object.entries(req.headers)) out.push('\treq.Header.Set(' + JSON.stringify(k) + ', ' + JSON.stringify(v) + ')');
+      out.push('', '\tresp, err := http.DefaultClient.Do(req)', '\tif err != nil {', '\t\tlog.Fatal(err)', '\t}', '\tdefer resp.Body.Close()');
+      out.push('\tb, _ := io.ReadAll(resp.Body)', '\tfmt.Println(resp.Status)', '\tfmt.Println(string(b))', '}');
+      return out.join('\n');
+    }
+
+    function renderSnippet() {
+      const req = build();
+      $('snippet').textContent = lang === 'curl' ? curlSnippet(req) : goSnippet(req);
+    }
+
+    document.querySelectorAll('.tabs button[data-lang]').forEach(btn => btn.addEventListener('click', () => {
+      lang = btn.dataset.lang;
+      document.querySelectorAll('.tabs button[data-lang]').forEach(b => b.classList.toggle('active', b === btn));
+      renderSnippet();
+    }));
+    $('copy').addEventListener('click', () => navigator.clipboard.writeText($('snippet').textContent));
+    $('endpoint').addEventListener('change', select);
+    $('echo').addEventListener('change', renderSnippet);
+    $('send').addEventListener('click', send);
+
+    init().catch(err => {
+      $('status').textContent = 'Failed to load the spec: ' + err.message;
+      $('status').className = 'status err';
+    });
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Per-operation policies ---
+//
+// Every LLM call runs under the policy of its operation (the prompt
+// template it was rendered from): a timeout per attempt, how often to
+// retry while the provider is unavailable, and how many calls may run at
+// once (and how many of them may be batch calls, see priority.go), with
+// how many more may wait for a slot. LLM_TIMEOUT, LLM_RETRIES,
+// LLM_CONCURRENCY, LLM_BATCH_CONCURRENCY and LLM_QUEUE set the default
+// policy; built-in overrides give long-running operations (expand, book
+// summaries) more time and keep list operations (keywords, titles) snappy,
+// and POLICIES_FILE overrides any of them per operation:
+//
+//	{"expand": {"timeout": "5m", "batch_concurrency": 1}, "keywords": {"timeout": "10s", "retries": 0, "concurrency": 4, "queue": 20}}
+
+// Policy is how the calls of one operation are run.
+type Policy struct {
+	Timeout     time.Duration // per attempt; none if 0
+	Retries     int           // extra attempts after the provider was unavailable
+	Concurrency int           // calls running at once; unlimited if 0
+	Batch       int           // batch calls running at once; unlimited if 0
+	Queue       int           // interactive calls waiting for a slot; unbounded if 0
+}
+
+func (p Policy) String() string {
+	timeout, concurrency, batch, queue := "none", "unlimited", "unlimited", "unbounded"
+	if p.Timeout > 0 {
+		timeout = p.Timeout.String()
+	}
+	if p.Concurrency > 0 {
+		concurrency = fmt.Sprint(p.Concurrency)
+	}
+	if p.Batch > 0 {
+		batch = fmt.Sprint(p.Batch)
+	}
+	if p.Queue > 0 {
+		queue = fmt.Sprint(p.Queue)
+	}
+	return fmt.Sprintf("timeout=%s retries=%d concurrency=%s batch=%s queue=%s", timeout, p.Retries, concurrency, batch, queue)
+}
+
+// policyOverride sets some fields of a Policy; it is the POLICIES_FILE
+// format.
+type policyOverride struct {
+	Timeout     *string `json:"timeout"` // a Go duration, e.g. "90s"
+	Retries     *int    `json:"retries"`
+	Concurrency *int    `json:"concurrency"`
+	Batch       *int    `json:"batch_concurrency"`
+	Queue       *int    `json:"queue"`
+}
+
+func (o policyOverride) apply(p *Policy) error {
+	if o.Timeout != nil {
+		d, err := time.ParseDuration(*o.Timeout)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid timeout %q", *o.Timeout)
+		}
+		p.Timeout = d
+	}
+	if o.Retries != nil {
+		if *o.Retries < 0 {
+			return fmt.Errorf("retries must not be negative")
+		}
+		p.Retries = *o.Retries
+	}
+	if o.Concurrency != nil {
+		if *o.Concurrency < 0 {
+			return fmt.Errorf("concurrency must not be negative")
+		}
+		p.Concurrency = *o.Concurrency
+	}
+	if o.Batch != nil {
+		if *o.Batch < 0 {
+			return fmt.Errorf("batch_concurrency must not be negative")
+		}
+		p.Batch = *o.Batch
+	}
+	if o.Queue != nil {
+		if *o.Queue < 0 {
+			return fmt.Errorf("queue must not be negative")
+		}
+		p.Queue = *o.Queue
+	}
+	return nil
+}
+
+func durationOverride(s string) policyOverride { return policyOverride{Timeout: &s} }
+
+// builtinPolicies adjust the default policy for operations that are known
+// to be slow or expected to be fast.
+var builtinPolicies = map[string]policyOverride{
+	"expand":         durationOverride("3m"),
+	"summarize-book": durationOverride("3m"),
+	"describe-image": durationOverride("2m"),
+	"keywords":       durationOverride("15s"),
+	"titles":         durationOverride("15s"),
+}
+
+// retryBackoff is the wait before the first retry; it doubles with each
+// further one.
+const retryBackoff = 500 * time.Millisecond
+
+// Policies holds the default policy and the per-operation ones, with the
+// concurrency slots of each operation and those shared by all.
+type Policies struct {
+	mu      sync.Mutex // Reload replaces all of these
+	Default Policy
+	ops     map[string]Policy
+	global  *semaphore // nil if unlimited, for batch calls too
+	slots   map[string]*semaphore
+}
+
+// NewPoliciesFromEnv builds the policies from LLM_TIMEOUT, LLM_RETRIES,
+// LLM_CONCURRENCY, LLM_BATCH_CONCURRENCY, LLM_QUEUE and POLICIES_FILE, with
+// the global limit from LLM_GLOBAL_CONCURRENCY,
+// LLM_GLOBAL_BATCH_CONCURRENCY and LLM_GLOBAL_QUEUE.
+func NewPoliciesFromEnv() (*Policies, error) {
+	def := Policy{Timeout: 60 * time.Second, Retries: 1}
+	if v := os.Getenv("LLM_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("LLM_TIMEOUT: invalid duration %q", v)
+		}
+		def.Timeout = d
+	}
+	def.Retries = max(envInt("LLM_RETRIES", def.Retries), 0)
+	def.Concurrency = max(envInt("LLM_CONCURRENCY", 0), 0)
+	def.Batch = max(envInt("LLM_BATCH_CONCURRENCY", 0), 0)
+	def.Queue = max(envInt("LLM_QUEUE", 0), 0)
+
+	overrides := map[string]policyOverride{}
+	if file := os.Getenv("POLICIES_FILE"); file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &overrides); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	p, err := NewPolicies(def, overrides)
+	if err != nil {
+		return nil, err
+	}
+	n, batch := max(envInt("LLM_GLOBAL_CONCURRENCY", 0), 0), max(envInt("LLM_GLOBAL_BATCH_CONCURRENCY", 0), 0)
+	if n > 0 || batch > 0 {
+		p.global = newSemaphore(n, batch, max(envInt("LLM_GLOBAL_QUEUE", 0), 0))
+	}
+	return p, nil
+}
+
+// NewPolicies returns def for every operation, adjusted by the built-in
+// policies and then by overrides.
+func NewPolicies(def Policy, overrides map[string]policyOverride) (*Policies, error) {
+	p := &Policies{Default: def, ops: map[string]Policy{}, slots: map[string]*semaphore{}}
+	for _, set := range []map[string]policyOverride{builtinPolicies, overrides} {
+		for op, o := range set {
+			policy, ok := p.ops[op]
+			if !ok {
+				policy = def
+			}
+			if err := o.apply(&policy); err != nil {
+				return nil, fmt.Errorf("policy %q: %w", op, err)
+			}
+			p.ops[op] = policy
+		}
+	}
+	return p, nil
+}
+
+// Reload rebuilds the policies from the environment and POLICIES_FILE.
+// Calls already running keep their slots; the new limits apply to the
+// calls that start after it.
+func (p *Policies) Reload() error {
+	fresh, err := NewPoliciesFromEnv()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.Default, p.ops, p.global, p.slots = fresh.Default, fresh.ops, fresh.global, fresh.slots
+	p.mu.Unlock()
+	p.Log()
+	return nil
+}
+
+// For returns the policy of op.
+func (p *Policies) For(op string) Policy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if policy, ok := p.ops[op]; ok {
+		return policy
+	}
+	return p.Default
+}
+
+// Log prints the effective policies.
+func (p *Policies) Log() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.global != nil {
+		log.Printf("LLM concurrency (all operations): %d, batch %d, queue %d", p.global.concurrency, p.global.batch, p.global.queue)
+	}
+	log.Printf("LLM policy (default): %s", p.Default)
+	ops := make([]string, 0, len(p.ops))
+	for op := range p.ops {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		log.Printf("LLM policy %s: %s", op, p.ops[op])
+	}
+}
+
+// acquire takes one of op's concurrency slots and then a global one, at
+// the priority of ctx, and returns the func releasing them. An interactive
+// call waits at most the policy's timeout for them to free up and is
+// rejected when it times out or finds the queue full; a batch call waits
+// until ctx is done.
+func (p *Policies) acquire(ctx context.Context, op string, policy Policy) (func(), error) {
+	var sems []*semaphore
+	p.mu.Lock()
+	if policy.Concurrency > 0 || policy.Batch > 0 {
+		sem, ok := p.slots[op]
+		if !ok {
+			sem = newSemaphore(policy.Concurrency, policy.Batch, policy.Queue)
+			p.slots[op] = sem
+		}
+		sems = append(sems, sem)
+	}
+	global := p.global
+	if global != nil {
+		sems = append(sems, global)
+	}
+	p.mu.Unlock()
+	if len(sems) == 0 {
+		return func() {}, nil
+	}
+
+	prio := priorityOf(ctx)
+	wait := ctx
+	if policy.Timeout > 0 && prio == priorityInteractive {
+		var cancel context.CancelFunc
+		wait, cancel = context.WithTimeout(ctx, policy.Timeout)
+		defer cancel()
+	}
+	var releases []func()
+	release := func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	for i, sem := range sems {
+		r, err := sem.acquire(wait, prio)
+		if err != nil {
+			release()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			what := operationName(op)
+			if i == len(sems)-1 && global != nil {
+				what = "all operations"
+			}
+			log.Printf("%s: no free slot: %v", what, err)
+			return nil, reject(ctx, "the server is at capacity", overloadRetryAfter)
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
+// Status returns the load of the global slots and of the operations with a
+// concurrency limit.
+func (p *Policies) Status() AdmissionStats {
+	st := AdmissionStats{Operations: map[string]SemaphoreStatus{}}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.global != nil {
+		g := p.global.Status()
+		st.Global = &g
+	}
+	for op, sem := range p.slots {
+		st.Operations[op] = sem.Status()
+	}
+	return st
+}
+
+// run calls attempt under op's policy: in one of its slots, each attempt
+// within the timeout, retrying while retry allows it and the provider is
+// unavailable.
+func (p *Policies) run(ctx context.Context, op string, retry func() bool, attempt func(context.Context) error) error {
+	policy := p.For(op)
+	release, err := p.acquire(ctx, op, policy)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	backoff := retryBackoff
+	for i := 0; ; i++ {
+		err = p.attempt(ctx, policy, attempt)
+		if err == nil || i >= policy.Retries || ctx.Err() != nil || !texttools.Unavailable(err) || !retry() {
+			return err
+		}
+		log.Printf("%s: retrying in %v after: %v", operationName(op), backoff, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+func (p *Policies) attempt(ctx context.Context, policy Policy, attempt func(context.Context) error) error {
+	if policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, policy.Timeout)
+		defer cancel()
+	}
+	return attempt(ctx)
+}
+
+func operationName(op string) string {
+	if op == "" {
+		return "LLM call"
+	}
+	return op
+}
+
+// policyProvider runs every call of the wrapped provider under the policy
+// of its operation.
+type policyProvider struct {
+	texttools.StreamProvider
+	policies *Policies
+}
+
+func (p policyProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	var c texttools.Completion
+	err := p.policies.run(ctx, req.Operation, func() bool { return true }, func(ctx context.Context) error {
+		var err error
+		c, err = p.StreamProvider.Complete(ctx, req)
+		return err
+	})
+	return c, err
+}
+
+// Stream retries only as long as nothing has been passed to onDelta yet.
+func (p policyProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	var c texttools.Completion
+	streamed := false
+	delta := func(s string) error {
+		streamed = true
+		return onDelta(s)
+	}
+	err := p.policies.run(ctx, req.Operation, func() bool { return !streamed }, func(ctx context.Context) error {
+		var err error
+		c, err = p.StreamProvider.Stream(ctx, req, delta)
+		return err
+	})
+	return c, err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Per-user default options ---
+
+// Preferences are a user's defaults, applied when a request leaves the
+// corresponding field empty.
+type Preferences struct {
+	Tone     string `json:"tone,omitempty"`
+	Language string `json:"language,omitempty"`
+	Length   string `json:"length,omitempty"`
+	Model    string `json:"model,omitempty"`
+}
+
+// apply fills the empty fields of opts from p.
+func (p Preferences) apply(opts *Options) {
+	if opts.Language == "" {
+		opts.Language = p.Language
+	}
+	if opts.Length == "" {
+		opts.Length = p.Length
+	}
+	if opts.Model == "" {
+		opts.Model = p.Model
+	}
+}
+
+func (p Preferences) validate() error {
+	return texttools.Options{Length: p.Length}.Validate()
+}
+
+// userID identifies the caller for per-user state. Requests without an
+// X-User-ID header share the "default" user. The users of a tenant are
+// its own: their IDs start with "<tenant>/", which an X-User-ID can't
+// (it may not contain "/"), so no data is shared across tenants.
+func userID(r *http.Request) string {
+	id := "default"
+	if l, ok := sso.Login(r); ok {
+		id = l.User
+	} else if h := r.Header.Get("X-User-ID"); h != "" && len(h) <= 128 && !strings.Contains(h, "/") {
+		id = h
+	}
+	if t := tenantName(r); t != "" {
+		return t + "/" + id
+	}
+	return id
+}
+
+// PreferenceStore keeps preferences per user, optionally persisted to a
+// JSON file.
+type PreferenceStore struct {
+	file string
+
+	mu    sync.RWMutex
+	users map[string]Preferences
+}
+
+func NewPreferenceStore(file string) (*PreferenceStore, error) {
+	s := &PreferenceStore{file: file, users: map[string]Preferences{}}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.users); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return s, nil
+}
+
+// For returns the preferences of the user making the request.
+func (s *PreferenceStore) For(r *http.Request) Preferences {
+	return s.Get(userID(r))
+}
+
+func (s *PreferenceStore) Get(user string) Preferences {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.users[user]
+}
+
+func (s *PreferenceStore) Set(user string, p Preferences) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user] = p
+	return s.saveLocked()
+}
+
+func (s *PreferenceStore) Delete(user string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, user)
+	return s.saveLocked()
+}
+
+func (s *PreferenceStore) saveLocked() error {
+	if s.file == "" {
+		return nil
+	}
+	return writeJSONFile(s.file, s.users)
+}
+
+// preferencesHandler serves the caller's preferences: GET reads them, PUT
+// replaces them and DELETE resets them.
+func preferencesHandler(prefs *PreferenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := userID(r)
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"user": user, "preferences": prefs.Get(user)})
+		case http.MethodPut:
+			var p Preferences
+			if !decodeJSON(w, r, &p) {
+				return
+			}
+			if err := p.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := prefs.Set(user, p); err != nil {
+				log.Println("preferences error:", err)
+				http.Error(w, "failed to save preferences", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]interface{}{"user": user, "preferences": p})
+		case http.MethodDelete:
+			if err := prefs.Delete(user); err != nil {
+				log.Println("preferences error:", err)
+				http.Error(w, "failed to save preferences", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// --- Priorities ---
+//
+// LLM calls are interactive, made for someone waiting on a response, or
+// batch: async jobs, scheduled jobs, feed summaries and requests with an
+// "X-Priority: batch" header (e.g. a bulk import). Interactive calls get
+// free concurrency slots first, and batch calls only a share of them (the
+// batch concurrency of the policies and LLM_GLOBAL_BATCH_CONCURRENCY, see
+// admission.go), so a large batch doesn't starve the users of the UI.
+
+// Priority is the class of an LLM call.
+type Priority int
+
+const (
+	priorityInteractive Priority = iota
+	priorityBatch
+)
+
+func (p Priority) String() string {
+	if p == priorityBatch {
+		return "batch"
+	}
+	return "interactive"
+}
+
+type priorityKey struct{}
+
+// batchContext returns ctx with its LLM calls run as batch work.
+func batchContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priorityBatch)
+}
+
+// priorityOf returns the priority of the calls made with ctx.
+func priorityOf(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// withPriority runs the requests with an "X-Priority: batch" header as
+// batch work; all others are interactive.
+func withPriority(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("X-Priority"), "batch") {
+			r = r.WithContext(batchContext(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
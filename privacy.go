@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// --- Privacy mode ---
+//
+// With the privacy_mode flag on (PRIVACY_MODE=true), or for requests with
+// an "X-Privacy-Mode: true" header, inputs and outputs are kept only for as
+// long as the request runs: results aren't stored in history (nor indexed
+// for /search) and aren't served from or saved to the result cache,
+// Idempotency-Key responses aren't kept for replay, the audit log records
+// the request without its input or input hash, and neither the error
+// messages on the dashboard nor the server log get any of the text; the
+// counters still count the request. Features that exist to keep text
+// between requests (sessions and saved UI tabs) answer 403. Responses to
+// private requests carry "X-Privacy-Mode: on".
+
+type privacyKey struct{}
+
+// withPrivacyMode marks the requests that are private.
+func withPrivacyMode(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		on, _ := strconv.ParseBool(r.Header.Get("X-Privacy-Mode"))
+		if on || features.Enabled("privacy_mode") {
+			w.Header().Set("X-Privacy-Mode", "on")
+			r = r.WithContext(context.WithValue(r.Context(), privacyKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// privacyMode reports whether the request of ctx is private.
+func privacyMode(ctx context.Context) bool {
+	on, _ := ctx.Value(privacyKey{}).(bool)
+	return on
+}
+
+// refusePrivate answers 403 to a private request for a feature that keeps
+// text, and reports whether it did.
+func refusePrivate(w http.ResponseWriter, r *http.Request, feature string) bool {
+	if !privacyMode(r.Context()) {
+		return false
+	}
+	http.Error(w, feature+" are not available in privacy mode", http.StatusForbidden)
+	return true
+}
+
+// logUnparseable logs model output of op that couldn't be parsed; for
+// private requests, without the output.
+func logUnparseable(ctx context.Context, op, out string) {
+	if privacyMode(ctx) {
+		log.Printf("%s: unparseable model output (%d bytes, not shown in privacy mode)", op, len(out))
+		return
+	}
+	log.Println(op+": unparseable model output:", truncate(out, 200))
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Result provenance ---
+//
+// Every stored result records how it was produced: model, provider, prompt
+// template versions, instance and time. With a signing key configured, GET
+// /history/<id>/provenance returns a signed statement about the result, so
+// downstream consumers can check that this instance produced that text with
+// those parameters: offline with the Ed25519 public key, or by asking
+// POST /provenance/verify (the only way with an HMAC secret).
+
+// Provenance describes how a result was generated.
+type Provenance struct {
+	Model         string    `json:"model"`
+	Provider      string    `json:"provider"`
+	PromptVersion string    `json:"prompt_version"`           // "<template>:<hash>" of the templates and style guide used
+	PromptVariant string    `json:"prompt_variant,omitempty"` // in a prompt experiment (see experiments.go)
+	Instance      string    `json:"instance"`
+	GeneratedAt   time.Time `json:"generated_at"`
+}
+
+// ProvenanceSource stamps new results.
+type ProvenanceSource struct {
+	Provider     string
+	DefaultModel string // used when a request names no model
+	Prompts      *PromptRegistry
+	StyleGuides  map[string]string
+	Instance     string
+}
+
+// NewProvenanceSource describes results of tools. The instance is
+// INSTANCE_ID, or the host name.
+func NewProvenanceSource(p texttools.Provider, tools *texttools.Tools, prompts *PromptRegistry) *ProvenanceSource {
+	s := &ProvenanceSource{Prompts: prompts, StyleGuides: tools.StyleGuides, Instance: os.Getenv("INSTANCE_ID")}
+	if s.Instance == "" {
+		s.Instance, _ = os.Hostname()
+	}
+	s.Provider, s.DefaultModel = describeProvider(p)
+	return s
+}
+
+// describeProvider returns the name of a provider, as recorded in
+// provenance, and the model it uses for requests that name none.
+func describeProvider(p texttools.Provider) (name, defaultModel string) {
+	switch p := p.(type) {
+	case *texttools.OpenAI:
+		if p.Azure != nil {
+			return "azure-openai", p.Azure.Deployment
+		}
+		if p.Model == "" {
+			return "openai", texttools.DefaultModel
+		}
+		return "openai", p.Model
+	case *texttools.Mock:
+		return "mock", "mock"
+	default:
+		return fmt.Sprintf("%T", p), ""
+	}
+}
+
+func (s *ProvenanceSource) For(ctx context.Context, op string, opts Options) *Provenance {
+	if s == nil {
+		return nil
+	}
+	model := opts.Model
+	if model == "" {
+		model = runtimeConfig.Model()
+	}
+	if model == "" {
+		model = s.DefaultModel
+	}
+	variant := promptVariant(ctx, op)
+	tmpl := op
+	if variant != "" {
+		tmpl = variantTemplate(op, variant)
+	}
+	version := s.Prompts.Version(tmpl, texttools.SystemTemplate(s.Prompts, op))
+	if guide, ok := s.StyleGuides[op]; ok {
+		version += ",style_guide:" + promptVersion(guide)
+	}
+	return &Provenance{
+		Model:         model,
+		Provider:      s.Provider,
+		PromptVersion: version,
+		PromptVariant: variant,
+		Instance:      s.Instance,
+		GeneratedAt:   time.Now().UTC(),
+	}
+}
+
+// ProvenanceStatement is what gets signed about a stored result.
+type ProvenanceStatement struct {
+	ResultID    string          `json:"result_id"`
+	Operation   string          `json:"operation"`
+	Params      string          `json:"params"` // the operation parameters
+	InputSHA256 string          `json:"input_sha256"`
+	Result      json.RawMessage `json:"result"`
+	Provenance
+}
+
+// SignedProvenance is a statement with its signature. Payload holds the
+// exact bytes signed (the statement as JSON), so verifiers needn't
+// re-serialize it.
+type SignedProvenance struct {
+	Statement ProvenanceStatement `json:"statement"`
+	Payload   string              `json:"payload"`
+	Signature string              `json:"signature"` // base64
+	Alg       string              `json:"alg"`       // ed25519 or hmac-sha256
+	KeyID     string              `json:"key_id"`
+}
+
+// --- Signing ---
+
+// ProvenanceSigner signs statements with an Ed25519 key
+// (PROVENANCE_ED25519_KEY, a PKCS#8 PEM file) or an HMAC-SHA256 secret
+// (PROVENANCE_HMAC_SECRET).
+type ProvenanceSigner struct {
+	Alg   string
+	KeyID string
+
+	priv   ed25519.PrivateKey
+	secret []byte
+}
+
+// NewProvenanceSignerFromEnv returns nil when neither key is configured.
+func NewProvenanceSignerFromEnv() (*ProvenanceSigner, error) {
+	if path := os.Getenv("PROVENANCE_ED25519_KEY"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("%s: no PEM data", path)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		priv, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+		}
+		sum := sha256.Sum256(priv.Public().(ed25519.PublicKey))
+		return &ProvenanceSigner{Alg: "ed25519", KeyID: hex.EncodeToString(sum[:8]), priv: priv}, nil
+	}
+	if secret := os.Getenv("PROVENANCE_HMAC_SECRET"); secret != "" {
+		s := &ProvenanceSigner{Alg: "hmac-sha256", secret: []byte(secret)}
+		s.KeyID = hex.EncodeToString(s.sign([]byte("key-id"))[:8])
+		return s, nil
+	}
+	return nil, nil
+}
+
+func (s *ProvenanceSigner) sign(payload []byte) []byte {
+	if s.priv != nil {
+		return ed25519.Sign(s.priv, payload)
+	}
+	m := hmac.New(sha256.New, s.secret)
+	m.Write(payload)
+	return m.Sum(nil)
+}
+
+func (s *ProvenanceSigner) verify(payload, sig []byte) bool {
+	if s.priv != nil {
+		return ed25519.Verify(s.priv.Public().(ed25519.PublicKey), payload, sig)
+	}
+	return hmac.Equal(s.sign(payload), sig)
+}
+
+// Sign builds and signs the statement for a stored result.
+func (s *ProvenanceSigner) Sign(e *HistoryEntry) (SignedProvenance, error) {
+	if e.Provenance == nil {
+		return SignedProvenance{}, errors.New("result was stored without provenance")
+	}
+	in := sha256.Sum256([]byte(e.Input))
+	st := ProvenanceStatement{
+		ResultID:    e.ID,
+		Operation:   e.Operation,
+		Params:      e.Key,
+		InputSHA256: hex.EncodeToString(in[:]),
+		Result:      e.Result,
+		Provenance:  *e.Provenance,
+	}
+	payload, err := json.Marshal(st)
+	if err != nil {
+		return SignedProvenance{}, err
+	}
+	return SignedProvenance{
+		Statement: st,
+		Payload:   string(payload),
+		Signature: base64.StdEncoding.EncodeToString(s.sign(payload)),
+		Alg:       s.Alg,
+		KeyID:     s.KeyID,
+	}, nil
+}
+
+// --- Handlers ---
+
+// provenanceHandler serves GET /history/<id>/provenance.
+func provenanceHandler(history *History, signer *ProvenanceSigner, id string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if signer == nil {
+			http.Error(w, "provenance signing is not configured", http.StatusNotImplemented)
+			return
+		}
+		e, ok := history.Get(id)
+		if !ok || e.User != userID(r) {
+			http.Error(w, "result not found", http.StatusNotFound)
+			return
+		}
+		sp, err := signer.Sign(e)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusOK, sp)
+	}
+}
+
+type VerifyProvenanceRequest struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"` // base64
+}
+
+type VerifyProvenanceResponse struct {
+	Valid     bool                 `json:"valid"`
+	Statement *ProvenanceStatement `json:"statement,omitempty"` // the verified statement
+}
+
+// provenanceVerifyHandler serves POST /provenance/verify.
+func provenanceVerifyHandler(signer *ProvenanceSigner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if signer == nil {
+			http.Error(w, "provenance signing is not configured", http.StatusNotImplemented)
+			return
+		}
+		var req VerifyProvenanceRequest
+		if !decodeJSONLimit(w, r, &req, 10<<20) {
+			return
+		}
+		sig, err := base64.StdEncoding.DecodeString(req.Signature)
+		if err != nil || req.Payload == "" {
+			http.Error(w, "`payload` and a base64 `signature` are required", http.StatusBadRequest)
+			return
+		}
+		var resp VerifyProvenanceResponse
+		if signer.verify([]byte(req.Payload), sig) {
+			var st ProvenanceStatement
+			if err := json.Unmarshal([]byte(req.Payload), &st); err != nil {
+				log.Println("provenance verify error:", err)
+			} else {
+				resp.Valid, resp.Statement = true, &st
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+type ProvenanceKey struct {
+	Alg       string `json:"alg"`
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"` // base64 raw Ed25519 public key
+}
+
+// provenanceKeyHandler serves GET /provenance/key, the public key for
+// offline verification (Ed25519 only).
+func provenanceKeyHandler(signer *ProvenanceSigner) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if signer == nil || signer.priv == nil {
+			http.Error(w, "no public key: provenance is not signed with Ed25519", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, ProvenanceKey{
+			Alg:       signer.Alg,
+			KeyID:     signer.KeyID,
+			PublicKey: base64.StdEncoding.EncodeToString(signer.priv.Public().(ed25519.PublicKey)),
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Quick ---
+//
+// /quick is for browser extensions: it runs an operation on the text the
+// user selected and returns a short plain-text result.
+//
+//	POST /quick {"text": "...", "operation": "summarize"}
+//	GET  /quick?text=...&operation=summarize&callback=fn   (JSONP)
+//
+// Requests from chrome-extension:// and moz-extension:// origins get CORS
+// headers (QUICK_EXTENSION_IDS restricts which extensions). The text is
+// capped at QUICK_MAX_CHARS, far below the other endpoints, and results
+// are asked to be short. They aren't kept in history: selections are
+// throwaway.
+
+// quickMaxBody caps the body of POST /quick.
+const quickMaxBody = 64 << 10
+
+// quickMaxChars caps the selected text (QUICK_MAX_CHARS).
+var quickMaxChars = 4000
+
+// quickOperations are the operations /quick runs.
+var quickOperations = []string{"summarize", "keywords", "rewrite", "questions", "titles", "expand", "to-bullets", "to-prose"}
+
+// QuickRequest is the POST /quick body.
+type QuickRequest struct {
+	Text      string `json:"text"`
+	Operation string `json:"operation"` // summarize if empty
+	Tone      string `json:"tone"`      // for rewrite
+	Language  string `json:"language"`
+}
+
+// QuickResponse is the result of /quick, as plain text.
+type QuickResponse struct {
+	Operation string `json:"operation"`
+	Result    string `json:"result"`
+}
+
+// jsonpCallbackRe is what a JSONP callback may be: a JavaScript name, with
+// dots (e.g. myExt.onResult).
+var jsonpCallbackRe = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*(\.[A-Za-z_$][A-Za-z0-9_$]*)*$`)
+
+// extensionOrigins are the extension IDs allowed to call /quick from the
+// browser; any if empty.
+type extensionOrigins []string
+
+func extensionOriginsFromEnv() extensionOrigins {
+	var ids extensionOrigins
+	for _, id := range strings.Split(os.Getenv("QUICK_EXTENSION_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// allows returns whether origin is that of an allowed extension.
+func (ids extensionOrigins) allows(origin string) bool {
+	var id string
+	for _, scheme := range []string{"chrome-extension://", "moz-extension://"} {
+		if rest, ok := strings.CutPrefix(origin, scheme); ok {
+			id = rest
+		}
+	}
+	if id == "" || strings.Contains(id, "/") {
+		return false
+	}
+	if len(ids) == 0 {
+		return true
+	}
+	for _, allowed := range ids {
+		if id == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// quickHandler serves GET, POST and OPTIONS (CORS preflight) /quick.
+func quickHandler(tools *texttools.Tools, prefs *PreferenceStore, origins extensionOrigins) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origins.allows(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-User-ID, X-API-Key")
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+
+		var req QuickRequest
+		callback := ""
+		switch r.Method {
+		case http.MethodOptions:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case http.MethodGet:
+			q := r.URL.Query()
+			req = QuickRequest{Text: q.Get("text"), Operation: q.Get("operation"), Tone: q.Get("tone"), Language: q.Get("language")}
+			if callback = q.Get("callback"); callback != "" && !jsonpCallbackRe.MatchString(callback) {
+				http.Error(w, "invalid `callback`", http.StatusBadRequest)
+				return
+			}
+		case http.MethodPost:
+			r.Body = http.MaxBytesReader(w, r.Body, quickMaxBody)
+			if !decodeJSON(w, r, &req) {
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// JSONP can't read statuses: errors go to the callback as well
+		fail := func(status int, msg string) {
+			if callback == "" {
+				http.Error(w, msg, status)
+				return
+			}
+			writeJSONP(w, callback, APIError{Error: APIErrorBody{Code: errorCode(status), Message: msg, RequestID: r.Header.Get("X-Request-ID")}})
+		}
+		if req.Operation == "" {
+			req.Operation = "summarize"
+		}
+		known := false
+		for _, op := range quickOperations {
+			known = known || op == req.Operation
+		}
+		text := strings.TrimSpace(req.Text)
+		switch {
+		case !known:
+			fail(http.StatusBadRequest, fmt.Sprintf("unknown `operation` %q (one of %s)", req.Operation, strings.Join(quickOperations, ", ")))
+			return
+		case text == "":
+			fail(http.StatusBadRequest, "`text` is required")
+			return
+		case len([]rune(text)) > quickMaxChars:
+			fail(http.StatusRequestEntityTooLarge, fmt.Sprintf("`text` is too long: %d characters (max %d)", len([]rune(text)), quickMaxChars))
+			return
+		}
+		if err := validateText(text); err != nil {
+			fail(http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+
+		opts := Options{Options: texttools.Options{Language: req.Language, Length: "short", OutputFormat: texttools.FormatPlain}}
+		prefs.For(r).apply(&opts)
+		opts.Length, opts.OutputFormat = "short", texttools.FormatPlain
+		result, err := runOperation(r.Context(), tools, req.Operation, RewriteRequest{Text: text, Tone: req.Tone, Options: opts})
+		if err != nil {
+			log.Println("quick error:", err)
+			if callback == "" {
+				writeLLMError(w, err)
+				return
+			}
+			f := classifyLLMError(err)
+			writeJSONP(w, callback, APIError{Error: APIErrorBody{Code: f.Code, Message: f.Message, RequestID: r.Header.Get("X-Request-ID")}})
+			return
+		}
+		resp := QuickResponse{Operation: req.Operation, Result: plainResult(result)}
+		if callback != "" {
+			writeJSONP(w, callback, resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// plainResult is the result of runOperation as plain text, lists one item
+// per line.
+func plainResult(result interface{}) string {
+	switch res := result.(type) {
+	case SummarizeResponse:
+		return res.Summary
+	case KeywordsResponse:
+		return strings.Join(res.Keywords, ", ")
+	case RewriteResponse:
+		return res.Text
+	case QuestionsResponse:
+		return strings.Join(res.Questions, "\n")
+	case TitlesResponse:
+		return strings.Join(res.Titles, "\n")
+	case ExpandResponse:
+		return res.Text
+	case BulletsResponse:
+		return res.Text
+	case ProseResponse:
+		return res.Text
+	}
+	return ""
+}
+
+// writeJSONP writes v as a call of callback. The leading comment defuses
+// content-sniffing attacks on the callback name (Rosetta Flash).
+func writeJSONP(w http.ResponseWriter, callback string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "encoding error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	fmt.Fprintf(w, "/**/ typeof %s === 'function' && %s(%s);\n", callback, callback, b)
+}
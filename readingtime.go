@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Reading time ---
+//
+// "reading_time": "2 minutes" on /summarize asks for a summary that can be
+// read in that time. Reading time is estimated from the word count at
+// readingWPM words per minute; the server summarizes, then condenses the
+// summary until it fits the budget or maxCondensePasses is reached.
+
+// readingWPM is the reading speed that reading times assume (READING_WPM).
+var readingWPM = 230
+
+const (
+	maxCondensePasses = 3
+
+	minReadingTime = 10 * time.Second
+	maxReadingTime = time.Hour
+)
+
+// ReadingTimeFit reports how a result fits the requested reading time.
+type ReadingTimeFit struct {
+	TargetSeconds int  `json:"target_seconds"`
+	Seconds       int  `json:"seconds"` // estimated reading time of the result
+	Words         int  `json:"words"`
+	Passes        int  `json:"passes"` // condensing passes after the first result
+	Fits          bool `json:"fits"`
+}
+
+var readingTimeRe = regexp.MustCompile(`(?i)^(\d+(?:\.\d+)?)\s*(s|secs?|seconds?|m|mins?|minutes?)$`)
+
+// parseReadingTime reads a reading time such as "2 minutes", "90 seconds",
+// "1.5 min" or a Go duration ("1m30s").
+func parseReadingTime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		m := readingTimeRe.FindStringSubmatch(s)
+		if m == nil {
+			return 0, fmt.Errorf("`reading_time` must be a duration such as \"2 minutes\" or \"90s\", got %q", s)
+		}
+		n, _ := strconv.ParseFloat(m[1], 64)
+		unit := time.Second
+		if strings.HasPrefix(strings.ToLower(m[2]), "m") {
+			unit = time.Minute
+		}
+		d = time.Duration(n * float64(unit))
+	}
+	if d < minReadingTime || d > maxReadingTime {
+		return 0, fmt.Errorf("`reading_time` must be between %v and %v", minReadingTime, maxReadingTime)
+	}
+	return d, nil
+}
+
+// readingSeconds estimates how long words take to read.
+func readingSeconds(words int) int {
+	return int(math.Ceil(float64(words) * 60 / float64(readingWPM)))
+}
+
+// condensePromptInput is the data of the condense template.
+type condensePromptInput struct {
+	texttools.Input
+	Words   int
+	Seconds int
+}
+
+// summarizeForReadingTime summarizes req.Text and condenses the summary until
+// it can be read in budget.
+func summarizeForReadingTime(ctx context.Context, tools *texttools.Tools, req TextRequest, budget time.Duration) (SummarizeResponse, error) {
+	target := int(budget.Seconds() * float64(readingWPM) / 60)
+	if req.Length == "" && target < 60 {
+		req.Length = "short"
+	}
+	resp, err := summarize(ctx, tools, req)
+	if err != nil {
+		return resp, err
+	}
+	fit := &ReadingTimeFit{TargetSeconds: int(budget.Seconds())}
+	// the local fallback summary can't be condensed further
+	for !resp.Degraded && fit.Passes < maxCondensePasses && texttools.CountWords(resp.Summary, req.OutputFormat) > target {
+		opts := req.Options.Options
+		if req.OutputFormat == texttools.FormatHTML {
+			opts.InputFormat = texttools.FormatHTML
+		}
+		in := condensePromptInput{
+			Input:   texttools.Input{Text: texttools.PrepareInput(resp.Summary, opts.InputFormat), Options: opts},
+			Words:   target,
+			Seconds: fit.TargetSeconds,
+		}
+		out, err := tools.Prompt(ctx, "condense", in, in.Options)
+		if err != nil {
+			return SummarizeResponse{}, err
+		}
+		resp.Summary = texttools.FormatOutput(strings.TrimSpace(out), req.OutputFormat)
+		fit.Passes++
+	}
+	fit.Words = texttools.CountWords(resp.Summary, req.OutputFormat)
+	fit.Seconds = readingSeconds(fit.Words)
+	fit.Fits = fit.Words <= target
+	resp.ReadingTime = fit
+	return resp, nil
+}
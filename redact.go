@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- PII redaction ---
+//
+// POST /redact replaces the emails, phone numbers, names and addresses in a
+// text with placeholders ("[EMAIL_1]", "[NAME_2]", ...) and returns the
+// mapping back to the originals. A regex pass finds the well-formed ones;
+// an LLM pass (skipped with "mode": "regex") finds the names and addresses
+// the regexes can't.
+//
+// With the redact_pii flag on (REDACT_PII=true), or an "X-Redact-PII: true"
+// request header, every operation's prompt goes through the regex pass
+// before it is sent to the provider, and the placeholders in the output are
+// replaced back, so the user still sees the original values. Only the regex
+// pass runs there: the LLM pass would send the text to the provider.
+// Images attached for a vision model are not redacted.
+
+// PII types.
+var piiTypes = []string{"email", "phone", "name", "address"}
+
+type RedactRequest struct {
+	Text  string   `json:"text"`
+	Types []string `json:"types"` // only these PII types; all by default
+	Mode  string   `json:"mode"`  // full (regex and LLM, the default) or regex
+	Options
+}
+
+type RedactResponse struct {
+	Text       string            `json:"text"`
+	Mapping    map[string]string `json:"mapping"` // placeholder → original
+	Redactions []Redaction       `json:"redactions"`
+}
+
+// Redaction is one redacted span; Start and End are offsets in Unicode
+// characters into the original text, End exclusive.
+type Redaction struct {
+	Placeholder string `json:"placeholder"`
+	Type        string `json:"type"`
+	Start       int    `json:"start"`
+	End         int    `json:"end"`
+}
+
+var (
+	emailRe   = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	phoneRe   = regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{1,4}\)[ .-]?)?\d{2,4}(?:[ .-]?\d{2,4}){1,4}`)
+	isoDateRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	nameRe    = regexp.MustCompile(`\b(?:Mr|Mrs|Ms|Miss|Dr|Prof)\.? [A-Z][\pL'-]+(?: [A-Z][\pL'-]+)?`)
+	addressRe = regexp.MustCompile(`\b\d{1,5}[A-Za-z]? (?:[A-Z][\pL'-]+ ){1,3}(?:Street|St|Avenue|Ave|Road|Rd|Boulevard|Blvd|Lane|Ln|Drive|Dr|Way|Court|Ct|Place|Pl|Square|Sq)\b\.?`)
+)
+
+// regexPII finds the PII of types in text that the regexes recognize.
+func regexPII(text string, types []string) []Entity {
+	var found []Entity
+	add := func(typ string, re *regexp.Regexp, keep func(string) bool) {
+		if !slices.Contains(types, typ) {
+			return
+		}
+		for _, m := range re.FindAllStringIndex(text, -1) {
+			s := text[m[0]:m[1]]
+			if keep != nil && !keep(s) {
+				continue
+			}
+			start := utf8.RuneCountInString(text[:m[0]])
+			found = append(found, Entity{Text: s, Type: typ, Start: start, End: start + utf8.RuneCountInString(s)})
+		}
+	}
+	add("email", emailRe, nil)
+	add("address", addressRe, nil)
+	add("name", nameRe, nil)
+	add("phone", phoneRe, func(s string) bool {
+		digits := 0
+		for _, r := range s {
+			if r >= '0' && r <= '9' {
+				digits++
+			}
+		}
+		return digits >= 7 && digits <= 15 && !isoDateRe.MatchString(s)
+	})
+	return dropOverlaps(found)
+}
+
+// dropOverlaps keeps the earlier of overlapping spans (the first found on
+// ties), returning the rest in text order.
+func dropOverlaps(spans []Entity) []Entity {
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	out := []Entity{}
+	for _, s := range spans {
+		if len(out) > 0 && s.Start < out[len(out)-1].End {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// redactSpans replaces spans (in text order, rune offsets) with
+// placeholders; the same value of the same type gets the same placeholder.
+func redactSpans(text string, spans []Entity) RedactResponse {
+	resp := RedactResponse{Mapping: map[string]string{}, Redactions: []Redaction{}}
+	runes := []rune(text)
+	placeholders := map[string]string{}
+	counts := map[string]int{}
+	var sb strings.Builder
+	pos := 0
+	for _, s := range spans {
+		key := s.Type + "\x00" + s.Text
+		ph, ok := placeholders[key]
+		if !ok {
+			counts[s.Type]++
+			ph = fmt.Sprintf("[%s_%d]", strings.ToUpper(s.Type), counts[s.Type])
+			placeholders[key] = ph
+			resp.Mapping[ph] = s.Text
+		}
+		sb.WriteString(string(runes[pos:s.Start]))
+		sb.WriteString(ph)
+		pos = s.End
+		resp.Redactions = append(resp.Redactions, Redaction{Placeholder: ph, Type: s.Type, Start: s.Start, End: s.End})
+	}
+	sb.WriteString(string(runes[pos:]))
+	resp.Text = sb.String()
+	return resp
+}
+
+func redactHandler(tools *texttools.Tools, prefs *PreferenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RedactRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		for _, t := range req.Types {
+			if !slices.Contains(piiTypes, t) {
+				http.Error(w, fmt.Sprintf("unknown PII type %q (want %s)", t, strings.Join(piiTypes, ", ")), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(req.Types) == 0 {
+			req.Types = piiTypes
+		}
+		switch req.Mode {
+		case "":
+			req.Mode = "full"
+		case "full", "regex":
+		default:
+			http.Error(w, "mode must be one of full, regex", http.StatusBadRequest)
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		// Redactions are not kept in history: it would store the PII.
+		spans := regexPII(req.Text, req.Types)
+		if req.Mode == "full" {
+			in := entitiesPromptInput{
+				Input: texttools.Input{Text: req.Text, Options: req.Options.Options},
+				Types: req.Types,
+			}
+			out, err := tools.Prompt(r.Context(), "pii", in, in.Options)
+			if err != nil {
+				log.Println("redact error:", err)
+				writeLLMError(w, err)
+				return
+			}
+			var named struct {
+				PII []Entity `json:"pii"`
+			}
+			if err := json.Unmarshal([]byte(out), &named); err != nil {
+				logUnparseable(r.Context(), "redact", out)
+			}
+			// regex matches go first, so they win over mentions the model
+			// found at the same place
+			spans = dropOverlaps(append(spans, locateEntities(req.Text, named.PII, req.Types)...))
+		}
+
+		writeJSON(w, http.StatusOK, redactSpans(req.Text, spans))
+	}
+}
+
+// --- Automatic redaction ---
+
+type redactPIIKey struct{}
+
+// withRedactPII marks requests with an "X-Redact-PII: true" header.
+func withRedactPII(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if on, _ := strconv.ParseBool(r.Header.Get("X-Redact-PII")); on {
+			r = r.WithContext(context.WithValue(r.Context(), redactPIIKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redactPII reports whether prompts sent for ctx are to be redacted.
+func redactPII(ctx context.Context) bool {
+	on, _ := ctx.Value(redactPIIKey{}).(bool)
+	return on || features.Enabled("redact_pii")
+}
+
+// redactingProvider redacts the prompts of the wrapped provider when
+// redactPII says so, and restores the placeholders in its output.
+type redactingProvider struct {
+	texttools.StreamProvider
+}
+
+func (p redactingProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	if !redactPII(ctx) {
+		return p.StreamProvider.Complete(ctx, req)
+	}
+	mapping := redactPrompt(ctx, &req)
+	c, err := p.StreamProvider.Complete(ctx, req)
+	c.Text = restorePII(c.Text, mapping)
+	return c, err
+}
+
+func (p redactingProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	if !redactPII(ctx) {
+		return p.StreamProvider.Stream(ctx, req, onDelta)
+	}
+	mapping := redactPrompt(ctx, &req)
+
+	// A placeholder can arrive split across deltas: hold back anything
+	// from an unclosed "[" until it is complete.
+	var pending string
+	c, err := p.StreamProvider.Stream(ctx, req, func(delta string) error {
+		pending += delta
+		i := strings.LastIndex(pending, "[")
+		if i < 0 || strings.Contains(pending[i:], "]") || len(pending)-i > 20 {
+			i = len(pending)
+		}
+		out := restorePII(pending[:i], mapping)
+		pending = pending[i:]
+		if out == "" {
+			return nil
+		}
+		return onDelta(out)
+	})
+	if err == nil && pending != "" {
+		err = onDelta(restorePII(pending, mapping))
+	}
+	c.Text = restorePII(c.Text, mapping)
+	return c, err
+}
+
+// redactPrompt runs the regex pass over req's prompt and returns the
+// mapping to restore the output with; none for debug echo requests, which
+// are to show the prompt as it would be sent.
+func redactPrompt(ctx context.Context, req *texttools.Request) map[string]string {
+	redacted := redactSpans(req.Prompt, regexPII(req.Prompt, piiTypes))
+	req.Prompt = redacted.Text
+	if debugEcho(ctx) {
+		return nil
+	}
+	return redacted.Mapping
+}
+
+// restorePII replaces the placeholders of mapping in text by the originals.
+func restorePII(text string, mapping map[string]string) string {
+	if len(mapping) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(mapping))
+	for ph, orig := range mapping {
+		pairs = append(pairs, ph, orig)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Redis ---
+//
+// With REDIS_URL (redis://[user:password@]host:6379/db, or rediss:// for
+// TLS), replicas behind a load balancer share the rate limit of the LLM
+// endpoints and the result cache through Redis:
+//
+//   - the token bucket of each user is kept in Redis and taken from by a
+//     script, atomically and with the clock of the Redis server, so a user
+//     gets RATE_LIMIT requests per minute in all, whichever replicas serve
+//     them;
+//   - results are shared by the operation, its parameters and the
+//     fingerprint of the input, for REDIS_CACHE_TTL (24h by default), so
+//     "reuse": true finds a result made by another replica. Near-duplicate
+//     matching stays with the history (shared too with DATABASE_URL).
+//
+// Keys start with REDIS_PREFIX ("ai-text-tools:" by default). If Redis is
+// unreachable, requests aren't rate limited and results aren't shared
+// until it is back; the errors are logged. The client (RESP2) is
+// implemented here, to keep the build dependency-free.
+
+// redisError is an error reply.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+type redisConfig struct {
+	addr, host         string
+	user, password, db string
+	tls                bool
+}
+
+func parseRedisURL(s string) (redisConfig, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return redisConfig{}, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return redisConfig{}, fmt.Errorf("want a redis:// or rediss:// URL, got %q", u.Scheme)
+	}
+	c := redisConfig{host: u.Hostname(), tls: u.Scheme == "rediss"}
+	if c.host == "" {
+		c.host = "localhost"
+	}
+	port := u.Port()
+	if port == "" {
+		port = "6379"
+	}
+	c.addr = net.JoinHostPort(c.host, port)
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			return redisConfig{}, fmt.Errorf("invalid database %q", db)
+		}
+		c.db = db
+	}
+	return c, nil
+}
+
+// redisConn is a connection, used by one goroutine at a time.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRedis(ctx context.Context, c redisConfig) (*redisConn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.tls {
+		tc := tls.Client(nc, &tls.Config{ServerName: c.host})
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		nc = tc
+	}
+	rc := &redisConn{conn: nc, r: bufio.NewReader(nc)}
+	if c.password != "" {
+		args := []string{"AUTH", c.password}
+		if c.user != "" {
+			args = []string{"AUTH", c.user, c.password}
+		}
+		if _, err := rc.do(ctx, args...); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if c.db != "" {
+		if _, err := rc.do(ctx, "SELECT", c.db); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return rc, nil
+}
+
+// do sends a command and reads its reply: a string, int64, []byte (nil
+// for a null bulk string), []interface{} or redisError.
+func (c *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+	} else {
+		c.conn.SetDeadline(time.Time{})
+	}
+	var b []byte
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, "\r\n"...)
+	for _, a := range args {
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(len(a)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, a...)
+		b = append(b, "\r\n"...)
+	}
+	if _, err := c.conn.Write(b); err != nil {
+		return nil, err
+	}
+	v, err := c.reply()
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := v.(redisError); ok {
+		return nil, e
+	}
+	return v, nil
+}
+
+func (c *redisConn) reply() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, errors.New("redis: invalid reply")
+	}
+	typ, rest := line[0], line[1:len(line)-2]
+	switch typ {
+	case '+':
+		return rest, nil
+	case '-':
+		return redisError(rest), nil
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return []byte(nil), nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return []interface{}(nil), nil
+		}
+		out := make([]interface{}, n)
+		for i := range out {
+			if out[i], err = c.reply(); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", typ)
+}
+
+// Redis is a pool of connections to REDIS_URL.
+type Redis struct {
+	cfg    redisConfig
+	prefix string
+	idle   chan *redisConn
+}
+
+// NewRedisFromEnv connects to REDIS_URL, or returns nil if it is not set.
+func NewRedisFromEnv() (*Redis, error) {
+	v := os.Getenv("REDIS_URL")
+	if v == "" {
+		return nil, nil
+	}
+	cfg, err := parseRedisURL(v)
+	if err != nil {
+		return nil, fmt.Errorf("REDIS_URL: %w", err)
+	}
+	prefix := os.Getenv("REDIS_PREFIX")
+	if prefix == "" {
+		prefix = "ai-text-tools:"
+	}
+	r := &Redis{cfg: cfg, prefix: prefix, idle: make(chan *redisConn, 16)}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := r.Do(ctx, "PING"); err != nil {
+		return nil, fmt.Errorf("REDIS_URL: %w", err)
+	}
+	return r, nil
+}
+
+// Do runs a command on a pooled connection. A connection that fails other
+// than with an error reply is dropped.
+func (r *Redis) Do(ctx context.Context, args ...string) (interface{}, error) {
+	var c *redisConn
+	select {
+	case c = <-r.idle:
+	default:
+		var err error
+		if c, err = dialRedis(ctx, r.cfg); err != nil {
+			return nil, err
+		}
+	}
+	v, err := c.do(ctx, args...)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		c.conn.Close()
+		return nil, err
+	}
+	select {
+	case r.idle <- c:
+	default:
+		c.conn.Close()
+	}
+	return v, err
+}
+
+// eval runs script by its SHA1, loading it first if Redis doesn't have it.
+func (r *Redis) eval(ctx context.Context, script string, keys []string, args ...string) (interface{}, error) {
+	sum := sha1.Sum([]byte(script))
+	cmd := append([]string{"EVALSHA", hex.EncodeToString(sum[:]), strconv.Itoa(len(keys))}, keys...)
+	v, err := r.Do(ctx, append(cmd, args...)...)
+	if rerr, ok := err.(redisError); ok && strings.HasPrefix(string(rerr), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", script
+		v, err = r.Do(ctx, append(cmd, args...)...)
+	}
+	return v, err
+}
+
+// redisTimeout bounds a command of the limiter and the cache.
+const redisTimeout = 2 * time.Second
+
+// --- Rate limit ---
+
+// redisBucketScript takes a token of the bucket KEYS[1], which holds
+// ARGV[2] tokens and refills at ARGV[1] per millisecond. It returns
+// {1, tokens left, 0} or {0, 0, milliseconds until there is a token}.
+const redisBucketScript = `
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(b[1]) or burst
+local last = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(now - last, 0) * rate)
+local ok = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  ok = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate) + 1000)
+if ok == 1 then
+  return {1, math.floor(tokens), 0}
+end
+return {0, 0, math.ceil((1 - tokens) / rate)}
+`
+
+// redisLimiter is the rate limiter of the replicas, a token bucket per
+// user in Redis.
+type redisLimiter struct {
+	redis *Redis
+}
+
+func (l redisLimiter) allow(user string, perMinute, burst int) (remaining int, wait time.Duration, ok bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	rate := float64(perMinute) / 60000 // per millisecond
+	v, err := l.redis.eval(ctx, redisBucketScript, []string{l.redis.prefix + "ratelimit:" + user},
+		strconv.FormatFloat(rate, 'g', -1, 64), strconv.Itoa(burst))
+	res, _ := v.([]interface{})
+	if err == nil && len(res) != 3 {
+		err = fmt.Errorf("redis: unexpected reply %v", v)
+	}
+	if err != nil {
+		log.Println("rate limit error:", err)
+		return burst, 0, true
+	}
+	allowed, _ := res[0].(int64)
+	left, _ := res[1].(int64)
+	ms, _ := res[2].(int64)
+	return int(left), time.Duration(ms) * time.Millisecond, allowed == 1
+}
+
+// --- Result cache ---
+
+// redisResultCache shares results by operation, parameters and input
+// fingerprint.
+type redisResultCache struct {
+	redis *Redis
+	ttl   time.Duration
+}
+
+// NewRedisResultCacheFromEnv keeps results for REDIS_CACHE_TTL.
+func NewRedisResultCacheFromEnv(r *Redis) (*redisResultCache, error) {
+	c := &redisResultCache{redis: r, ttl: 24 * time.Hour}
+	if v := os.Getenv("REDIS_CACHE_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("REDIS_CACHE_TTL: invalid duration %q", v)
+		}
+		c.ttl = d
+	}
+	return c, nil
+}
+
+func (c *redisResultCache) key(op, key, fp string) string {
+	sum := sha256.Sum256([]byte(op + "\x00" + key + "\x00" + fp))
+	return c.redis.prefix + "result:" + hex.EncodeToString(sum[:])
+}
+
+func (c *redisResultCache) Get(op, key, fp string) (*HistoryEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	v, err := c.redis.Do(ctx, "GET", c.key(op, key, fp))
+	if err != nil {
+		return nil, err
+	}
+	b, _ := v.([]byte)
+	if b == nil {
+		return nil, nil
+	}
+	var e HistoryEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	e.index()
+	return &e, nil
+}
+
+func (c *redisResultCache) Put(e *HistoryEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	_, err = c.redis.Do(ctx, "SET", c.key(e.Operation, e.Key, e.fingerprint), string(b), "PX", strconv.FormatInt(c.ttl.Milliseconds(), 10))
+	return err
+}
+
+func (c *redisResultCache) Delete(e *HistoryEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	_, err := c.redis.Do(ctx, "DEL", c.key(e.Operation, e.Key, e.fingerprint))
+	return err
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// --- Redis job queue ---
+//
+// With JOB_QUEUE=redis (and REDIS_URL), jobs of registered types are
+// queued in Redis, so API frontends and workers can be scaled apart: a
+// frontend with JOB_QUEUE_WORKERS=0 only queues jobs and answers for their
+// state, the workers of other replicas run them. Delivery is at least
+// once:
+//
+//   - a worker takes a job from the queue together with a lease of
+//     JOB_VISIBILITY_TIMEOUT (5m by default), which it renews while the
+//     job runs;
+//   - if the lease runs out (the worker died or lost Redis), the job goes
+//     back to the front of the queue for another worker, up to
+//     JOB_MAX_ATTEMPTS deliveries (3 by default), after which it fails.
+//
+// So a job may run more than once, e.g. when a worker stalls past its
+// lease; the last run to finish sets the result.
+//
+// Keys (after REDIS_PREFIX):
+//
+//	job:<id>               a hash: the job, its progress and result
+//	jobs:queue             a list of waiting job IDs, taken from the right
+//	jobs:leases            a sorted set of running job IDs by lease deadline (ms)
+//	jobs:user:<user>       a sorted set of the user's job IDs by creation (ms)
+//	jobs:done, jobs:failed sorted sets of finished job IDs by finishing time, for Stats
+
+// redisClaimScript takes the next job ID of the queue KEYS[1] and leases
+// it for ARGV[1] ms in KEYS[2].
+const redisClaimScript = `
+redis.replicate_commands()
+local id = redis.call('RPOP', KEYS[1])
+if not id then
+  return false
+end
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call('ZADD', KEYS[2], now + tonumber(ARGV[1]), id)
+return id
+`
+
+// redisRenewScript extends the lease of job ARGV[2] in KEYS[1] to ARGV[1]
+// ms from now, if it still has one.
+const redisRenewScript = `
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+return redis.call('ZADD', KEYS[1], 'XX', 'CH', now + tonumber(ARGV[1]), ARGV[2])
+`
+
+// redisExpiredScript removes and returns up to 100 job IDs whose lease in
+// KEYS[1] ran out, so that only one replica requeues each.
+const redisExpiredScript = `
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now, 'LIMIT', 0, 100)
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+end
+return ids
+`
+
+// redisEnqueueScript stores the job ARGV[2] (field-value pairs from
+// ARGV[5]) in KEYS[2], indexes it in KEYS[3] at ARGV[3], keeping the index
+// for ARGV[4] ms, and queues it in KEYS[1], unless ARGV[1] jobs are
+// waiting already.
+const redisEnqueueScript = `
+if redis.call('LLEN', KEYS[1]) >= tonumber(ARGV[1]) then
+  return 0
+end
+redis.call('HSET', KEYS[2], unpack(ARGV, 5))
+redis.call('ZADD', KEYS[3], ARGV[3], ARGV[2])
+redis.call('PEXPIRE', KEYS[3], ARGV[4])
+redis.call('LPUSH', KEYS[1], ARGV[2])
+return 1
+`
+
+// redisJobQueue is a JobQueue in Redis.
+type redisJobQueue struct {
+	redis       *Redis
+	visibility  time.Duration
+	maxAttempts int
+}
+
+// NewRedisJobQueueFromEnv returns the queue JOB_QUEUE names: nil for
+// "memory" (the default), or the Redis queue.
+func NewRedisJobQueueFromEnv(r *Redis) (*redisJobQueue, error) {
+	switch v := os.Getenv("JOB_QUEUE"); v {
+	case "", "memory":
+		return nil, nil
+	case "redis":
+	default:
+		return nil, fmt.Errorf("JOB_QUEUE: unknown queue %q (want memory or redis)", v)
+	}
+	if r == nil {
+		return nil, fmt.Errorf("JOB_QUEUE: redis needs REDIS_URL")
+	}
+	q := &redisJobQueue{redis: r, visibility: 5 * time.Minute, maxAttempts: envInt("JOB_MAX_ATTEMPTS", 3)}
+	if v := os.Getenv("JOB_VISIBILITY_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 10*time.Second {
+			return nil, fmt.Errorf("JOB_VISIBILITY_TIMEOUT: invalid duration %q (at least 10s)", v)
+		}
+		q.visibility = d
+	}
+	if q.maxAttempts < 1 {
+		return nil, fmt.Errorf("JOB_MAX_ATTEMPTS: must be at least 1")
+	}
+	return q, nil
+}
+
+func (q *redisJobQueue) key(parts ...string) string {
+	k := q.redis.prefix
+	for i, p := range parts {
+		if i > 0 {
+			k += ":"
+		}
+		k += p
+	}
+	return k
+}
+
+func (q *redisJobQueue) do(args ...string) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return q.redis.Do(ctx, args...)
+}
+
+func (q *redisJobQueue) eval(script string, keys []string, args ...string) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	return q.redis.eval(ctx, script, keys, args...)
+}
+
+func (q *redisJobQueue) Enqueue(j *Job, capacity int) error {
+	created := strconv.FormatInt(j.CreatedAt.UnixMilli(), 10)
+	// the index outlives the jobs it lists, which are dropped as they expire
+	indexTTL := strconv.FormatInt(2*jobRetention.Milliseconds(), 10)
+	args := []string{strconv.Itoa(capacity), j.ID, created, indexTTL,
+		"type", j.Type,
+		"user", j.User,
+		"status", jobQueued,
+		"total", "0",
+		"done", "0",
+		"attempts", "0",
+		"created_at", j.CreatedAt.Format(time.RFC3339Nano),
+		"payload", string(j.payload),
+	}
+	v, err := q.eval(redisEnqueueScript, []string{q.key("jobs", "queue"), q.key("job", j.ID), q.key("jobs", "user", j.User)}, args...)
+	if err != nil {
+		return err
+	}
+	if n, _ := v.(int64); n == 0 {
+		return errQueueFull
+	}
+	return nil
+}
+
+// jobFields are the fields of a job hash read by Get and List.
+var jobFields = []string{"type", "user", "status", "total", "done", "error", "attempts", "created_at", "finished_at", "result", "payload"}
+
+// load reads the job id, with its result and payload if full; nil if there
+// is none.
+func (q *redisJobQueue) load(id string, full bool) (*Job, error) {
+	fields := jobFields
+	if !full {
+		fields = fields[:len(fields)-2]
+	}
+	v, err := q.do(append([]string{"HMGET", q.key("job", id)}, fields...)...)
+	if err != nil {
+		return nil, err
+	}
+	values, _ := v.([]interface{})
+	get := func(name string) string {
+		for i, f := range fields {
+			if f == name && i < len(values) {
+				b, _ := values[i].([]byte)
+				return string(b)
+			}
+		}
+		return ""
+	}
+	if get("type") == "" {
+		return nil, nil
+	}
+	j := &Job{ID: id, Type: get("type"), User: get("user"), Status: get("status"), Error: get("error")}
+	j.Total, _ = strconv.Atoi(get("total"))
+	j.Done, _ = strconv.Atoi(get("done"))
+	j.Attempts, _ = strconv.Atoi(get("attempts"))
+	j.CreatedAt, _ = time.Parse(time.RFC3339Nano, get("created_at"))
+	if f := get("finished_at"); f != "" {
+		if t, err := time.Parse(time.RFC3339Nano, f); err == nil {
+			j.FinishedAt = &t
+		}
+	}
+	if full {
+		if r := get("result"); r != "" {
+			j.Result = json.RawMessage(r)
+		}
+		j.payload = json.RawMessage(get("payload"))
+	}
+	return j, nil
+}
+
+func (q *redisJobQueue) Get(id string) (*Job, error) {
+	return q.load(id, true)
+}
+
+func (q *redisJobQueue) List(user string) ([]Job, error) {
+	index := q.key("jobs", "user", user)
+	v, err := q.do("ZREVRANGE", index, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	ids, _ := v.([]interface{})
+	var out []Job
+	for _, id := range ids {
+		b, _ := id.([]byte)
+		j, err := q.load(string(b), false)
+		if err != nil {
+			return nil, err
+		}
+		if j == nil { // expired
+			q.do("ZREM", index, string(b))
+			continue
+		}
+		out = append(out, *j)
+	}
+	return out, nil
+}
+
+func (q *redisJobQueue) Stats() (JobStats, error) {
+	var st JobStats
+	cutoff := strconv.FormatInt(time.Now().Add(-jobRetention).UnixMilli(), 10)
+	counts := []struct {
+		args []string
+		n    *int
+	}{
+		{[]string{"LLEN", q.key("jobs", "queue")}, &st.Queued},
+		{[]string{"ZCARD", q.key("jobs", "leases")}, &st.Running},
+		{[]string{"ZREMRANGEBYSCORE", q.key("jobs", jobDone), "-inf", "(" + cutoff}, nil},
+		{[]string{"ZCARD", q.key("jobs", jobDone)}, &st.Done},
+		{[]string{"ZREMRANGEBYSCORE", q.key("jobs", jobFailed), "-inf", "(" + cutoff}, nil},
+		{[]string{"ZCARD", q.key("jobs", jobFailed)}, &st.Failed},
+	}
+	for _, c := range counts {
+		v, err := q.do(c.args...)
+		if err != nil {
+			return st, err
+		}
+		if c.n != nil {
+			n, _ := v.(int64)
+			*c.n = int(n)
+		}
+	}
+	st.QueueDepth = st.Queued
+	return st, nil
+}
+
+func (q *redisJobQueue) setTotal(id string, n int) {
+	if _, err := q.do("HSET", q.key("job", id), "total", strconv.Itoa(n)); err != nil {
+		log.Println("job queue error:", err)
+	}
+}
+
+func (q *redisJobQueue) step(id string) {
+	if _, err := q.do("HINCRBY", q.key("job", id), "done", "1"); err != nil {
+		log.Println("job queue error:", err)
+	}
+}
+
+func (q *redisJobQueue) Work(workers int, run func(j *Job, p *JobProgress) (interface{}, error)) {
+	for i := 0; i < workers; i++ {
+		go q.worker(run)
+	}
+	go q.requeueExpired()
+}
+
+func (q *redisJobQueue) worker(run func(j *Job, p *JobProgress) (interface{}, error)) {
+	for {
+		v, err := q.eval(redisClaimScript, []string{q.key("jobs", "queue"), q.key("jobs", "leases")},
+			strconv.FormatInt(q.visibility.Milliseconds(), 10))
+		if err != nil {
+			log.Println("job queue error:", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		id, _ := v.([]byte)
+		if id == nil {
+			time.Sleep(time.Second)
+			continue
+		}
+		q.process(string(id), run)
+	}
+}
+
+// process runs the leased job id, renewing the lease meanwhile.
+func (q *redisJobQueue) process(id string, run func(j *Job, p *JobProgress) (interface{}, error)) {
+	j, err := q.Get(id)
+	if err == nil && j != nil {
+		_, err = q.do("HSET", q.key("job", id), "status", jobRunning, "done", "0")
+	}
+	if err == nil && j != nil {
+		var v interface{}
+		v, err = q.do("HINCRBY", q.key("job", id), "attempts", "1")
+		n, _ := v.(int64)
+		j.Attempts = int(n)
+	}
+	if err != nil || j == nil {
+		if err != nil {
+			log.Println("job queue error:", err)
+		}
+		q.do("ZREM", q.key("jobs", "leases"), id)
+		return
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		t := time.NewTicker(q.visibility / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				if _, err := q.eval(redisRenewScript, []string{q.key("jobs", "leases")},
+					strconv.FormatInt(q.visibility.Milliseconds(), 10), id); err != nil {
+					log.Println("job queue error:", err)
+				}
+			}
+		}
+	}()
+	result, runErr := run(j, &JobProgress{tracker: q, id: id})
+	close(stop)
+	q.finish(id, result, runErr)
+}
+
+// finish records the outcome of job id and releases its lease; the job
+// hash and its ID in the stats are kept for jobRetention.
+func (q *redisJobQueue) finish(id string, result interface{}, runErr error) {
+	now := time.Now().UTC()
+	fields := []string{"HSET", q.key("job", id), "finished_at", now.Format(time.RFC3339Nano)}
+	status := jobDone
+	if runErr != nil {
+		status = jobFailed
+		fields = append(fields, "status", jobFailed, "error", runErr.Error())
+	} else {
+		b, err := json.Marshal(result)
+		if err != nil {
+			status = jobFailed
+			fields = append(fields, "status", jobFailed, "error", err.Error())
+		} else {
+			fields = append(fields, "status", jobDone, "result", string(b))
+		}
+	}
+	cmds := [][]string{
+		fields,
+		{"PEXPIRE", q.key("job", id), strconv.FormatInt(jobRetention.Milliseconds(), 10)},
+		{"ZREM", q.key("jobs", "leases"), id},
+		// the lease may have run out and the job been queued again
+		{"LREM", q.key("jobs", "queue"), "0", id},
+		{"ZADD", q.key("jobs", status), strconv.FormatInt(now.UnixMilli(), 10), id},
+	}
+	for _, c := range cmds {
+		if _, err := q.do(c...); err != nil {
+			log.Println("job queue error:", err)
+			return
+		}
+	}
+}
+
+// requeueExpired puts the jobs whose lease ran out back at the front of
+// the queue, or fails those delivered JOB_MAX_ATTEMPTS times.
+func (q *redisJobQueue) requeueExpired() {
+	every := min(q.visibility/2, 30*time.Second)
+	for range time.Tick(every) {
+		v, err := q.eval(redisExpiredScript, []string{q.key("jobs", "leases")})
+		if err != nil {
+			log.Println("job queue error:", err)
+			continue
+		}
+		ids, _ := v.([]interface{})
+		for _, b := range ids {
+			id := string(b.([]byte))
+			j, err := q.load(id, false)
+			if err != nil {
+				log.Println("job queue error:", err)
+				continue
+			}
+			if j == nil {
+				continue
+			}
+			if j.Attempts >= q.maxAttempts {
+				log.Printf("Job %s (%s): lease expired, giving up after %d attempts", id, j.Type, j.Attempts)
+				q.finish(id, nil, fmt.Errorf("the job was not finished after %d attempts", j.Attempts))
+				continue
+			}
+			log.Printf("Job %s (%s): lease expired, queued again", id, j.Type)
+			if _, err := q.do("HSET", q.key("job", id), "status", jobQueued); err != nil {
+				log.Println("job queue error:", err)
+			}
+			if _, err := q.do("RPUSH", q.key("jobs", "queue"), id); err != nil {
+				log.Println("job queue error:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Regeneration with feedback ---
+//
+// POST /regenerate revises a result from the caller's history according to
+// their feedback ("too long", "keep the second bullet"): the model gets the
+// original input, the result and the feedback, and returns the result
+// revised, in the same fields. The revision is stored in history like the
+// original (with regenerated_from pointing at it), so it can be revised in
+// turn, one round of feedback at a time. Options left empty fall back to
+// those of the original request.
+
+// maxFeedbackChars caps the feedback of a regeneration.
+const maxFeedbackChars = 2000
+
+// regeneratedKey marks the history key of revised results, so they aren't
+// reused for a plain request with the same input.
+const regeneratedKey = "regenerated|"
+
+type RegenerateRequest struct {
+	ID       string `json:"id"`       // of the result to revise, from history
+	Feedback string `json:"feedback"` // what to change
+	Options
+}
+
+// regeneratePromptInput is the data of the regenerate template. Field is
+// set when the result is a single text field, which the model returns as
+// plain text; otherwise it returns the JSON object.
+type regeneratePromptInput struct {
+	texttools.Input
+	Operation string
+	Result    string
+	Field     string
+	Feedback  string
+}
+
+func regenerateHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RegenerateRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		req.Feedback = strings.TrimSpace(req.Feedback)
+		if req.ID == "" || req.Feedback == "" {
+			http.Error(w, "`id` and `feedback` are required", http.StatusBadRequest)
+			return
+		}
+		if n := utf8.RuneCountInString(req.Feedback); n > maxFeedbackChars {
+			writeInputError(w, errTooLarge("`feedback` is too long: %d characters (max %d)", n, maxFeedbackChars))
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e, ok := history.Get(req.ID)
+		if !ok || e.User != userID(r) {
+			http.Error(w, "result not found", http.StatusNotFound)
+			return
+		}
+		var prior map[string]interface{}
+		if err := json.Unmarshal(e.Result, &prior); err != nil {
+			http.Error(w, "result can't be regenerated", http.StatusUnprocessableEntity)
+			return
+		}
+		for f := range resultMetaFields {
+			delete(prior, f)
+		}
+		delete(prior, "regenerated_from")
+		opts := req.Options
+		opts.fallBackTo(originalOptions(e.Key))
+		prefs.For(r).apply(&opts)
+
+		in := regeneratePromptInput{
+			Input:     texttools.Input{Text: e.Input, Options: opts.Options},
+			Operation: e.Operation,
+			Feedback:  req.Feedback,
+		}
+		if field, ok := textField(prior); ok {
+			in.Field, in.Result = field, prior[field].(string)
+		} else {
+			b, _ := json.MarshalIndent(prior, "", "  ")
+			in.Result = string(b)
+		}
+		out, err := tools.Prompt(r.Context(), "regenerate", in, opts.Options)
+		if err != nil {
+			log.Println("regenerate error:", err)
+			writeLLMError(w, err)
+			return
+		}
+		resp, err := revisedResult(prior, in.Field, out)
+		if err != nil {
+			logUnparseable(r.Context(), "regenerate", out)
+			writeLLMError(w, err)
+			return
+		}
+		resp["regenerated_from"] = e.ID
+
+		key := regeneratedKey + strings.TrimPrefix(e.Key, regeneratedKey)
+		meta := history.Record(r, e.Operation, key, e.Input, opts, resp)
+		b, _ := json.Marshal(meta)
+		json.Unmarshal(b, &resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// textField returns the field of a result that is its only one and a
+// string.
+func textField(result map[string]interface{}) (string, bool) {
+	if len(result) != 1 {
+		return "", false
+	}
+	for f, v := range result {
+		if _, ok := v.(string); ok {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// revisedResult parses the model output for a result like prior: the text
+// of its field, or a JSON object with all of prior's fields (others are
+// dropped).
+func revisedResult(prior map[string]interface{}, field, out string) (map[string]interface{}, error) {
+	if field != "" {
+		text := strings.TrimSpace(out)
+		if text == "" {
+			return nil, fmt.Errorf("empty output")
+		}
+		return map[string]interface{}{field: text}, nil
+	}
+	var revised map[string]interface{}
+	if err := json.Unmarshal([]byte(out), &revised); err != nil {
+		return nil, err
+	}
+	resp := map[string]interface{}{}
+	for f := range prior {
+		v, ok := revised[f]
+		if !ok {
+			return nil, fmt.Errorf("field %q missing", f)
+		}
+		resp[f] = v
+	}
+	return resp, nil
+}
+
+// originalOptions recovers the options of a result from its history key
+// (see historyKey).
+func originalOptions(key string) Options {
+	var opts Options
+	if i := strings.Index(key, "|{"); i >= 0 {
+		json.Unmarshal([]byte(key[i+1:]), &opts)
+	}
+	return opts
+}
+
+// fallBackTo fills the options o leaves empty from orig.
+func (o *Options) fallBackTo(orig Options) {
+	if o.Language == "" {
+		o.Language = orig.Language
+	}
+	if o.Length == "" {
+		o.Length = orig.Length
+	}
+	if o.Model == "" {
+		o.Model = orig.Model
+	}
+	if o.MaxWords == 0 {
+		o.MaxWords = orig.MaxWords
+	}
+	if o.OutputFormat == "" {
+		o.OutputFormat = orig.OutputFormat
+	}
+}
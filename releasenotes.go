@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Release notes ---
+//
+// POST /release-notes turns commit messages, as a list or as the output of
+// git log, into user-facing release notes grouped under Breaking changes,
+// Features and Fixes. Commits are read here first: merge commits are
+// dropped and Conventional Commits (feat:, fix!:, BREAKING CHANGE: ...)
+// are classified, so the model starts from their type. "mode": "local"
+// skips the model and lists the classified subjects as they are.
+
+const maxReleaseCommits = 1000
+
+type ReleaseNotesRequest struct {
+	Commits []string `json:"commits"` // commit messages, one per item
+	Log     string   `json:"log"`     // or the output of git log
+	Version string   `json:"version"` // e.g. "v1.4.0", for the heading
+	Mode    string   `json:"mode"`    // full (LLM, the default) or local
+	Options
+}
+
+type ReleaseNotesResponse struct {
+	Version  string   `json:"version,omitempty"`
+	Breaking []string `json:"breaking"`
+	Features []string `json:"features"`
+	Fixes    []string `json:"fixes"`
+	Markdown string   `json:"markdown"`
+	Commits  int      `json:"commits"` // commits read, merges included
+	ResultMeta
+}
+
+// releaseCommit is a commit message, classified.
+type releaseCommit struct {
+	Subject  string
+	Body     string
+	Type     string // the Conventional Commits type, if any
+	Scope    string
+	Breaking bool
+	Merge    bool
+}
+
+// releaseNotesPromptInput is the data of the release-notes template.
+type releaseNotesPromptInput struct {
+	texttools.Options
+	Version string
+	Commits []releaseCommit
+}
+
+// gitGraphChars are the characters git log --graph draws.
+const gitGraphChars = "*|\\/ "
+
+var (
+	gitCommitLineRe  = regexp.MustCompile(`^commit [0-9a-f]{7,40}\b`)
+	gitHeaderRe      = regexp.MustCompile(`^(Merge|Author|AuthorDate|Date|Commit|CommitDate):`)
+	gitOnelineHashRe = regexp.MustCompile(`^[0-9a-f]{7,40}\s+(?:\([^)]*\)\s+)?`)
+	releaseBulletRe  = regexp.MustCompile(`^[-*]\s+`)
+	conventionalRe   = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+	breakingFooterRe = regexp.MustCompile(`(?m)^BREAKING[ -]CHANGE:`)
+	mergeSubjectRe   = regexp.MustCompile(`^Merge (pull request|branch|remote-tracking branch|tag) `)
+	fixSubjectRe     = regexp.MustCompile(`(?i)^(fix|fixe[sd]|bug|hotfix|patch|resolve[sd]?|correct)\b`)
+)
+
+// releaseInternalTypes are the Conventional Commits types of changes users
+// don't see.
+var releaseInternalTypes = map[string]bool{"chore": true, "ci": true, "test": true, "tests": true, "build": true, "style": true, "refactor": true, "docs": true}
+
+// parseGitLog splits the output of git log into commit messages: the
+// default format (commit <hash>, headers, indented message) or one commit
+// per line (--oneline, or any format printing the subject alone), with or
+// without --graph and --decorate.
+func parseGitLog(s string) []string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(strings.TrimLeft(l, gitGraphChars))
+	}
+	full := false
+	for _, l := range lines {
+		full = full || gitCommitLineRe.MatchString(l)
+	}
+
+	var out []string
+	if !full {
+		for _, l := range lines {
+			if l = releaseBulletRe.ReplaceAllString(gitOnelineHashRe.ReplaceAllString(l, ""), ""); l != "" {
+				out = append(out, l)
+			}
+		}
+		return out
+	}
+	var msg []string
+	flush := func() {
+		if m := strings.TrimSpace(strings.Join(msg, "\n")); m != "" {
+			out = append(out, m)
+		}
+		msg = nil
+	}
+	for _, l := range lines {
+		switch {
+		case gitCommitLineRe.MatchString(l):
+			flush()
+		case len(msg) == 0 && gitHeaderRe.MatchString(l):
+		default:
+			msg = append(msg, l)
+		}
+	}
+	flush()
+	return out
+}
+
+// classifyCommit reads the subject, body and Conventional Commits type of a
+// commit message.
+func classifyCommit(msg string) releaseCommit {
+	subject, body, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+	c := releaseCommit{Subject: strings.TrimSpace(subject), Body: strings.TrimSpace(body)}
+	c.Merge = mergeSubjectRe.MatchString(c.Subject)
+	if m := conventionalRe.FindStringSubmatch(c.Subject); m != nil {
+		c.Type, c.Scope, c.Breaking, c.Subject = strings.ToLower(m[1]), m[2], m[3] == "!", m[4]
+	}
+	c.Breaking = c.Breaking || breakingFooterRe.MatchString(c.Body)
+	return c
+}
+
+// localReleaseNotes groups the commits by their type alone: breaking
+// changes, then feat (and commits without a type, unless they read like a
+// fix), then fix and perf. Merges and internal types (chore, ci, docs, ...)
+// are left out.
+func localReleaseNotes(commits []releaseCommit) (breaking, features, fixes []string) {
+	for _, c := range commits {
+		note := capitalize(c.Subject)
+		if c.Scope != "" {
+			note = "**" + c.Scope + ":** " + note
+		}
+		switch {
+		case c.Merge:
+		case c.Breaking:
+			breaking = append(breaking, note)
+		case releaseInternalTypes[c.Type]:
+		case c.Type == "fix" || c.Type == "perf" || (c.Type == "" && fixSubjectRe.MatchString(c.Subject)):
+			fixes = append(fixes, note)
+		default:
+			features = append(features, note)
+		}
+	}
+	return breaking, features, fixes
+}
+
+func capitalize(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
+
+// releaseNotesMarkdown renders the notes, leaving out empty sections.
+func releaseNotesMarkdown(n ReleaseNotesResponse) string {
+	var sb strings.Builder
+	if n.Version != "" {
+		fmt.Fprintf(&sb, "## %s\n\n", n.Version)
+	}
+	for _, section := range []struct {
+		heading string
+		notes   []string
+	}{{"Breaking changes", n.Breaking}, {"Features", n.Features}, {"Fixes", n.Fixes}} {
+		if len(section.notes) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "### %s\n\n", section.heading)
+		for _, note := range section.notes {
+			fmt.Fprintf(&sb, "- %s\n", note)
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func releaseNotesHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req ReleaseNotesRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		messages := req.Commits
+		switch {
+		case len(req.Commits) > 0 && req.Log != "":
+			http.Error(w, "give either `commits` or `log`, not both", http.StatusBadRequest)
+			return
+		case req.Log != "":
+			messages = parseGitLog(req.Log)
+		}
+		if len(messages) == 0 {
+			http.Error(w, "`commits` or `log` is required", http.StatusBadRequest)
+			return
+		}
+		if len(messages) > maxReleaseCommits {
+			http.Error(w, fmt.Sprintf("at most %d commits per request", maxReleaseCommits), http.StatusBadRequest)
+			return
+		}
+		input := strings.Join(messages, "\n\n---\n\n")
+		if !checkText(w, input) {
+			return
+		}
+		switch req.Mode {
+		case "":
+			req.Mode = "full"
+		case "full", "local":
+		default:
+			http.Error(w, "mode must be one of full, local", http.StatusBadRequest)
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey(req.Mode+"|"+req.Version, req.Options)
+		if prior, ok := history.Reusable(r, "release-notes", key, input, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		var commits []releaseCommit
+		for _, m := range messages {
+			if c := classifyCommit(m); c.Subject != "" {
+				commits = append(commits, c)
+			}
+		}
+		resp := ReleaseNotesResponse{Version: req.Version, Commits: len(commits)}
+		if req.Mode == "full" {
+			in := releaseNotesPromptInput{Options: req.Options.Options, Version: req.Version}
+			for _, c := range commits {
+				if !c.Merge {
+					in.Commits = append(in.Commits, c)
+				}
+			}
+			out, err := tools.Prompt(r.Context(), "release-notes", in, in.Options)
+			if err != nil {
+				log.Println("release-notes error:", err)
+				writeLLMError(w, err)
+				return
+			}
+			var notes struct {
+				Breaking []string `json:"breaking"`
+				Features []string `json:"features"`
+				Fixes    []string `json:"fixes"`
+			}
+			if err := json.Unmarshal([]byte(out), &notes); err != nil {
+				// fallback – the commits' own classification
+				logUnparseable(r.Context(), "release-notes", out)
+				req.Mode = "local"
+			}
+			resp.Breaking, resp.Features, resp.Fixes = notes.Breaking, notes.Features, notes.Fixes
+		}
+		if req.Mode == "local" {
+			resp.Breaking, resp.Features, resp.Fixes = localReleaseNotes(commits)
+		}
+		for _, notes := range []*[]string{&resp.Breaking, &resp.Features, &resp.Fixes} {
+			if *notes == nil {
+				*notes = []string{}
+			}
+		}
+		resp.Markdown = releaseNotesMarkdown(resp)
+
+		resp.ResultMeta = history.Record(r, "release-notes", key, input, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// --- Configuration reload ---
+//
+// On SIGHUP, or POST /admin/reload, the server loads its configuration
+// files again without a restart, so requests in flight are not dropped:
+//
+//   - the -env-file, whose variables replace those it set before
+//     (variables of the real environment still win);
+//   - the prompt templates in PROMPTS_DIR;
+//   - CONFIG_FILE: the default model and temperature, the rate limit and
+//     the prompt experiments, over LLM_TEMPERATURE, RATE_LIMIT and
+//     RATE_BURST;
+//   - TENANTS_FILE: the tenants, their quotas, models and API keys;
+//   - the LLM policies: LLM_TIMEOUT, LLM_RETRIES, the concurrency limits
+//     and POLICIES_FILE.
+//
+// A part that fails to load keeps its previous configuration; the others
+// are reloaded anyway. Everything else, e.g. the provider and its keys,
+// still needs a restart.
+
+// ReloadResult is the response of POST /admin/reload.
+type ReloadResult struct {
+	Reloaded []string          `json:"reloaded"`
+	Errors   map[string]string `json:"errors,omitempty"` // by part
+}
+
+// Reloader reloads the parts of the configuration, one reload at a time.
+type Reloader struct {
+	mu    sync.Mutex
+	parts []reloadPart
+}
+
+type reloadPart struct {
+	name   string
+	reload func() error
+}
+
+// Add registers a part, reloaded in the order of the calls.
+func (r *Reloader) Add(name string, reload func() error) {
+	r.parts = append(r.parts, reloadPart{name, reload})
+}
+
+// Reload reloads every part and logs the outcome.
+func (r *Reloader) Reload() ReloadResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := ReloadResult{Reloaded: []string{}}
+	for _, p := range r.parts {
+		if err := p.reload(); err != nil {
+			log.Printf("reload error: %s: %v", p.name, err)
+			if res.Errors == nil {
+				res.Errors = map[string]string{}
+			}
+			res.Errors[p.name] = err.Error()
+			continue
+		}
+		res.Reloaded = append(res.Reloaded, p.name)
+	}
+	log.Printf("Reloaded configuration: %s", strings.Join(res.Reloaded, ", "))
+	return res
+}
+
+// OnSignal reloads on every SIGHUP.
+func (r *Reloader) OnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	go func() {
+		for range sig {
+			r.Reload()
+		}
+	}()
+}
+
+// adminReloadHandler serves POST /admin/reload: 200 if every part was
+// reloaded, else 500 with the errors.
+func adminReloadHandler(reloader *Reloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := reloader.Reload()
+		status := http.StatusOK
+		if len(res.Errors) > 0 {
+			status = http.StatusInternalServerError
+		}
+		writeJSON(w, status, res)
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- SAML single sign-on ---
+//
+// With SAML_IDP_METADATA set, the web UI, the playground and the admin
+// dashboard require a login at a SAML 2.0 identity provider. The service
+// provider sends AuthnRequests with the HTTP-Redirect binding and accepts
+// signed responses at /saml/acs (HTTP-POST). The logged-in user becomes the
+// caller's user ID, and an attribute of the assertion decides whether they
+// are an admin. API clients can keep using X-User-ID and ADMIN_TOKEN.
+
+const (
+	nsSAMLP = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsSAML  = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsMD    = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	bindingRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	bindingPOST     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	statusSuccess   = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	methodBearer    = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	loginCookie      = "tt_login"
+	loginMaxAge      = 8 * time.Hour
+	samlRequestTTL   = 10 * time.Minute
+	samlClockSkew    = 2 * time.Minute
+	maxSAMLResponse  = 1 << 20 // bytes
+	maxPendingLogins = 10000
+	roleAdmin        = "admin"
+	roleUser         = "user"
+	defaultRoleClaim = "role"
+)
+
+// sso is the SAML service provider, nil when SAML is off.
+var sso *SAMLProvider
+
+// Login is a signed-in user, kept in a signed cookie.
+type Login struct {
+	User    string `json:"user"`
+	Role    string `json:"role"` // admin or user
+	Expires int64  `json:"exp"`  // unix seconds
+}
+
+// SAMLProvider is the service provider side of SAML 2.0 web SSO.
+type SAMLProvider struct {
+	IdPEntityID string
+	IdPSSOURL   string
+	IdPCerts    []*x509.Certificate
+
+	EntityID      string
+	ACSURL        string
+	RoleAttribute string   // attribute holding the user's roles or groups
+	AdminValues   []string // values of RoleAttribute that make an admin
+
+	secret []byte // signs login cookies
+	secure bool   // set Secure on cookies
+
+	mu      sync.Mutex
+	pending map[string]time.Time // AuthnRequest ID → expiry; taken once, so responses can't be replayed
+}
+
+// NewSAMLProviderFromEnv configures SAML from the environment; it returns
+// nil if SAML_IDP_METADATA is unset.
+func NewSAMLProviderFromEnv() (*SAMLProvider, error) {
+	metadata := os.Getenv("SAML_IDP_METADATA")
+	if metadata == "" {
+		return nil, nil
+	}
+	base := strings.TrimRight(os.Getenv("SAML_BASE_URL"), "/")
+	if base == "" {
+		return nil, errors.New("SAML_BASE_URL is required with SAML_IDP_METADATA")
+	}
+	data, err := os.ReadFile(metadata)
+	if err != nil {
+		return nil, err
+	}
+	p := &SAMLProvider{
+		EntityID:      os.Getenv("SAML_ENTITY_ID"),
+		ACSURL:        base + "/saml/acs",
+		RoleAttribute: os.Getenv("SAML_ROLE_ATTRIBUTE"),
+		secure:        strings.HasPrefix(base, "https://"),
+		pending:       map[string]time.Time{},
+	}
+	if err := p.loadIdPMetadata(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", metadata, err)
+	}
+	if p.EntityID == "" {
+		p.EntityID = base + "/saml/metadata"
+	}
+	if p.RoleAttribute == "" {
+		p.RoleAttribute = defaultRoleClaim
+	}
+	for _, v := range strings.Split(os.Getenv("SAML_ADMIN_VALUES"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			p.AdminValues = append(p.AdminValues, v)
+		}
+	}
+
+	if s := os.Getenv("SESSION_SECRET"); s != "" {
+		p.secret = []byte(s)
+	} else {
+		p.secret = make([]byte, 32)
+		rand.Read(p.secret)
+		log.Println("SESSION_SECRET not set: logins end when the server restarts")
+	}
+	return p, nil
+}
+
+// loadIdPMetadata reads the IdP's entity ID, redirect SSO endpoint and
+// signing certificates from its SAML metadata.
+func (p *SAMLProvider) loadIdPMetadata(data []byte) error {
+	root, err := parseXML(data)
+	if err != nil {
+		return err
+	}
+	entities := []*xmlNode{root}
+	if root.is(nsMD, "EntitiesDescriptor") {
+		entities = root.childrenNamed(nsMD, "EntityDescriptor")
+	}
+	for _, ed := range entities {
+		idp := ed.child(nsMD, "IDPSSODescriptor")
+		if !ed.is(nsMD, "EntityDescriptor") || idp == nil {
+			continue
+		}
+		p.IdPEntityID = ed.attr("entityID")
+		for _, s := range idp.childrenNamed(nsMD, "SingleSignOnService") {
+			if s.attr("Binding") == bindingRedirect {
+				p.IdPSSOURL = s.attr("Location")
+			}
+		}
+		for _, kd := range idp.childrenNamed(nsMD, "KeyDescriptor") {
+			if use := kd.attr("use"); use != "" && use != "signing" {
+				continue
+			}
+			for _, xd := range kd.child(nsDSig, "KeyInfo").childrenNamed(nsDSig, "X509Data") {
+				for _, c := range xd.childrenNamed(nsDSig, "X509Certificate") {
+					der, err := decodeBase64XML(c.text())
+					if err != nil {
+						return fmt.Errorf("signing certificate: %w", err)
+					}
+					cert, err := x509.ParseCertificate(der)
+					if err != nil {
+						return fmt.Errorf("signing certificate: %w", err)
+					}
+					p.IdPCerts = append(p.IdPCerts, cert)
+				}
+			}
+		}
+		break
+	}
+	switch {
+	case p.IdPEntityID == "":
+		return errors.New("no IDPSSODescriptor")
+	case p.IdPSSOURL == "":
+		return errors.New("no SingleSignOnService with the HTTP-Redirect binding")
+	case len(p.IdPCerts) == 0:
+		return errors.New("no signing certificate")
+	}
+	return nil
+}
+
+// --- Login sessions ---
+
+// Login returns the caller's login, if they have a valid one. It is safe to
+// call on a nil provider.
+func (p *SAMLProvider) Login(r *http.Request) (Login, bool) {
+	if p == nil {
+		return Login{}, false
+	}
+	c, err := r.Cookie(loginCookie)
+	if err != nil {
+		return Login{}, false
+	}
+	payload, mac, ok := strings.Cut(c.Value, ".")
+	if !ok {
+		return Login{}, false
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil || !hmac.Equal(sum, p.sign(payload)) {
+		return Login{}, false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Login{}, false
+	}
+	var l Login
+	if err := json.Unmarshal(b, &l); err != nil || time.Now().Unix() >= l.Expires {
+		return Login{}, false
+	}
+	return l, true
+}
+
+func (p *SAMLProvider) sign(payload string) []byte {
+	m := hmac.New(sha256.New, p.secret)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+func (p *SAMLProvider) setLogin(w http.ResponseWriter, l Login) {
+	b, _ := json.Marshal(l)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    payload + "." + base64.RawURLEncoding.EncodeToString(p.sign(payload)),
+		Path:     "/",
+		Expires:  time.Unix(l.Expires, 0),
+		HttpOnly: true,
+		Secure:   p.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// RequireLogin sends browsers without a login to the IdP, then back to the
+// page they asked for. Without SAML it is a no-op.
+func (p *SAMLProvider) RequireLogin(h http.HandlerFunc) http.HandlerFunc {
+	if p == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := p.Login(r); !ok {
+			http.Redirect(w, r, "/saml/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// --- Endpoints ---
+
+// loginHandler starts a login: GET /saml/login?next=/path.
+func (p *SAMLProvider) loginHandler(w http.ResponseWriter, r *http.Request) {
+	id := "_" + newID() + newID()
+	now := time.Now().UTC()
+	p.mu.Lock()
+	p.expireLocked(now)
+	full := len(p.pending) >= maxPendingLogins
+	if !full {
+		p.pending[id] = now.Add(samlRequestTTL)
+	}
+	p.mu.Unlock()
+	if full {
+		http.Error(w, "too many logins in progress, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req bytes.Buffer
+	fmt.Fprintf(&req, `<samlp:AuthnRequest xmlns:samlp="%s" xmlns:saml="%s" ID="%s" Version="2.0" IssueInstant="%s" Destination="%s" AssertionConsumerServiceURL="%s" ProtocolBinding="%s">`,
+		nsSAMLP, nsSAML, id, now.Format(time.RFC3339), xmlEscape(p.IdPSSOURL), xmlEscape(p.ACSURL), bindingPOST)
+	fmt.Fprintf(&req, `<saml:Issuer>%s</saml:Issuer><samlp:NameIDPolicy AllowCreate="true"/></samlp:AuthnRequest>`, xmlEscape(p.EntityID))
+
+	var deflated bytes.Buffer
+	fw, _ := flate.NewWriter(&deflated, flate.DefaultCompression)
+	fw.Write(req.Bytes())
+	fw.Close()
+
+	u, err := url.Parse(p.IdPSSOURL)
+	if err != nil {
+		log.Println("saml error:", err)
+		http.Error(w, "SAML is misconfigured", http.StatusInternalServerError)
+		return
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	if next := r.URL.Query().Get("next"); localPath(next) {
+		q.Set("RelayState", next)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// acsHandler receives the IdP's response: POST /saml/acs.
+func (p *SAMLProvider) acsHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxSAMLResponse)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(r.PostForm.Get("SAMLResponse"))
+	if err != nil || len(data) == 0 {
+		http.Error(w, "missing or invalid SAMLResponse", http.StatusBadRequest)
+		return
+	}
+	l, err := p.parseResponse(data, time.Now())
+	if err != nil {
+		log.Println("saml error:", err)
+		http.Error(w, "SAML login failed", http.StatusForbidden)
+		return
+	}
+	p.setLogin(w, l)
+	log.Printf("saml login: %s (%s)", l.User, l.Role)
+
+	next := r.PostForm.Get("RelayState")
+	if !localPath(next) {
+		next = "/"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// logoutHandler ends the local login (not the IdP session).
+func (p *SAMLProvider) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: loginCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, Secure: p.secure})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// metadataHandler serves the service provider metadata to register with
+// the IdP.
+func (p *SAMLProvider) metadataHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<md:EntityDescriptor xmlns:md="%s" entityID="%s">
+  <md:SPSSODescriptor AuthnRequestsSigned="false" WantAssertionsSigned="true" protocolSupportEnumeration="%s">
+    <md:AssertionConsumerService Binding="%s" Location="%s" index="0" isDefault="true"/>
+  </md:SPSSODescriptor>
+</md:EntityDescriptor>
+`, nsMD, xmlEscape(p.EntityID), nsSAMLP, bindingPOST, xmlEscape(p.ACSURL))
+}
+
+// --- Response validation ---
+
+// parseResponse checks a SAML response and returns the login it grants.
+// The response or its assertion must be signed by the IdP, answer one of
+// our pending requests, and be meant for this service provider, now.
+func (p *SAMLProvider) parseResponse(data []byte, now time.Time) (Login, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return Login{}, err
+	}
+	if !root.is(nsSAMLP, "Response") {
+		return Login{}, errors.New("not a SAML response")
+	}
+	if err := checkUniqueIDs(root); err != nil {
+		return Login{}, err
+	}
+	if d := root.attr("Destination"); d != "" && d != p.ACSURL {
+		return Login{}, fmt.Errorf("response is for %q", d)
+	}
+	if iss := root.child(nsSAML, "Issuer"); iss != nil && iss.text() != p.IdPEntityID {
+		return Login{}, fmt.Errorf("response issued by %q", iss.text())
+	}
+	if code := root.child(nsSAMLP, "Status").child(nsSAMLP, "StatusCode").attr("Value"); code != statusSuccess {
+		return Login{}, fmt.Errorf("IdP returned status %q", code)
+	}
+	if root.child(nsSAML, "EncryptedAssertion") != nil {
+		return Login{}, errors.New("encrypted assertions are not supported")
+	}
+	assertions := root.childrenNamed(nsSAML, "Assertion")
+	if len(assertions) != 1 {
+		return Login{}, fmt.Errorf("expected one assertion, got %d", len(assertions))
+	}
+	a := assertions[0]
+
+	// Either signature will do, but a signature that is there must be valid.
+	signed := false
+	for _, el := range []*xmlNode{root, a} {
+		switch err := verifyEnveloped(el, p.IdPCerts); {
+		case err == nil:
+			signed = true
+		case !errors.Is(err, errNotSigned):
+			return Login{}, fmt.Errorf("%s signature: %w", el.local, err)
+		}
+	}
+	if !signed {
+		return Login{}, errors.New("neither the response nor the assertion is signed")
+	}
+
+	reqID := root.attr("InResponseTo")
+	if !p.takePending(reqID, now) {
+		return Login{}, errors.New("response does not answer a pending login (IdP-initiated logins are not supported)")
+	}
+	if a.child(nsSAML, "Issuer").text() != p.IdPEntityID {
+		return Login{}, fmt.Errorf("assertion issued by %q", a.child(nsSAML, "Issuer").text())
+	}
+
+	cond := a.child(nsSAML, "Conditions")
+	if cond == nil {
+		return Login{}, errors.New("assertion has no conditions")
+	}
+	if err := checkWindow(cond, now); err != nil {
+		return Login{}, err
+	}
+	audienceOK := false
+	for _, ar := range cond.childrenNamed(nsSAML, "AudienceRestriction") {
+		for _, aud := range ar.childrenNamed(nsSAML, "Audience") {
+			audienceOK = audienceOK || aud.text() == p.EntityID
+		}
+	}
+	if !audienceOK {
+		return Login{}, errors.New("assertion is not meant for this service provider")
+	}
+
+	subject := a.child(nsSAML, "Subject")
+	user := subject.child(nsSAML, "NameID").text()
+	if user == "" {
+		return Login{}, errors.New("assertion has no NameID")
+	}
+	confirmed := false
+	for _, sc := range subject.childrenNamed(nsSAML, "SubjectConfirmation") {
+		scd := sc.child(nsSAML, "SubjectConfirmationData")
+		if sc.attr("Method") != methodBearer || scd == nil || scd.attr("Recipient") != p.ACSURL {
+			continue
+		}
+		if irt := scd.attr("InResponseTo"); irt != "" && irt != reqID {
+			continue
+		}
+		if scd.attr("NotOnOrAfter") == "" || checkWindow(scd, now) != nil {
+			continue
+		}
+		confirmed = true
+	}
+	if !confirmed {
+		return Login{}, errors.New("no valid bearer subject confirmation")
+	}
+
+	expires := now.Add(loginMaxAge)
+	if t, err := time.Parse(time.RFC3339, a.child(nsSAML, "AuthnStatement").attr("SessionNotOnOrAfter")); err == nil && t.Before(expires) {
+		expires = t
+	}
+
+	role := roleUser
+	for _, st := range a.childrenNamed(nsSAML, "AttributeStatement") {
+		for _, attr := range st.childrenNamed(nsSAML, "Attribute") {
+			if attr.attr("Name") != p.RoleAttribute && attr.attr("FriendlyName") != p.RoleAttribute {
+				continue
+			}
+			for _, v := range attr.childrenNamed(nsSAML, "AttributeValue") {
+				if slices.Contains(p.AdminValues, v.text()) {
+					role = roleAdmin
+				}
+			}
+		}
+	}
+	return Login{User: user, Role: role, Expires: expires.Unix()}, nil
+}
+
+// checkWindow checks the NotBefore/NotOnOrAfter attributes of el, allowing
+// for some clock skew.
+func checkWindow(el *xmlNode, now time.Time) error {
+	if v := el.attr("NotBefore"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return fmt.Errorf("NotBefore: %w", err)
+		}
+		if now.Add(samlClockSkew).Before(t) {
+			return errors.New("assertion is not valid yet")
+		}
+	}
+	if v := el.attr("NotOnOrAfter"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return fmt.Errorf("NotOnOrAfter: %w", err)
+		}
+		if !now.Add(-samlClockSkew).Before(t) {
+			return errors.New("assertion has expired")
+		}
+	}
+	return nil
+}
+
+// takePending consumes the AuthnRequest ID a response answers.
+func (p *SAMLProvider) takePending(id string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expireLocked(now)
+	if _, ok := p.pending[id]; !ok || id == "" {
+		return false
+	}
+	delete(p.pending, id)
+	return true
+}
+
+func (p *SAMLProvider) expireLocked(now time.Time) {
+	for id, exp := range p.pending {
+		if now.After(exp) {
+			delete(p.pending, id)
+		}
+	}
+}
+
+// localPath reports whether s is a path on this server, safe to redirect to.
+func localPath(s string) bool {
+	return strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") && !strings.HasPrefix(s, "/\\")
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// meHandler tells the UI who is signed in: GET /me.
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	resp := MeResponse{User: userID(r), SSO: sso != nil}
+	if l, ok := sso.Login(r); ok {
+		resp.Role = l.Role
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type MeResponse struct {
+	User string `json:"user"`
+	Role string `json:"role,omitempty"` // admin or user, for SAML logins
+	SSO  bool   `json:"sso"`            // SAML login is enabled
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+)
+
+var samlNow = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+const samlResponse = `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="r1" Version="2.0" IssueInstant="2026-01-01T12:00:00Z" Destination="https://sp.example/saml/acs" InResponseTo="req1">` +
+	`<saml:Issuer>https://idp.example/</saml:Issuer><!--SIG:r1-->` +
+	`<samlp:Status><samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/></samlp:Status>` +
+	`<saml:Assertion ID="a1" Version="2.0" IssueInstant="2026-01-01T12:00:00Z">` +
+	`<saml:Issuer>https://idp.example/</saml:Issuer><!--SIG:a1-->` +
+	`<saml:Subject><saml:NameID>alice@example.com</saml:NameID>` +
+	`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` +
+	`<saml:SubjectConfirmationData InResponseTo="req1" Recipient="https://sp.example/saml/acs" NotOnOrAfter="2026-01-01T12:05:00Z"/>` +
+	`</saml:SubjectConfirmation></saml:Subject>` +
+	`<saml:Conditions NotBefore="2026-01-01T11:59:00Z" NotOnOrAfter="2026-01-01T12:05:00Z">` +
+	`<saml:AudienceRestriction><saml:Audience>https://sp.example/saml/metadata</saml:Audience></saml:AudienceRestriction></saml:Conditions>` +
+	`<saml:AuthnStatement AuthnInstant="2026-01-01T12:00:00Z" SessionNotOnOrAfter="2026-01-01T14:00:00Z"/>` +
+	`<saml:AttributeStatement><saml:Attribute Name="role"><saml:AttributeValue>staff</saml:AttributeValue><saml:AttributeValue>admins</saml:AttributeValue></saml:Attribute></saml:AttributeStatement>` +
+	`</saml:Assertion></samlp:Response>`
+
+func testSAMLProvider(key *rsa.PrivateKey) *SAMLProvider {
+	return &SAMLProvider{
+		IdPEntityID:   "https://idp.example/",
+		IdPCerts:      []*x509.Certificate{{PublicKey: &key.PublicKey}},
+		EntityID:      "https://sp.example/saml/metadata",
+		ACSURL:        "https://sp.example/saml/acs",
+		RoleAttribute: "role",
+		AdminValues:   []string{"admins"},
+		pending:       map[string]time.Time{"req1": samlNow.Add(samlRequestTTL)},
+	}
+}
+
+// assertion returns the first signed or unsigned assertion element of doc.
+func assertion(doc string) string {
+	i := strings.Index(doc, "<saml:Assertion ")
+	return doc[i : strings.Index(doc, "</saml:Assertion>")+len("</saml:Assertion>")]
+}
+
+func TestParseResponse(t *testing.T) {
+	key, other := testKey(t, 0), testKey(t, 1)
+	replace := func(old, new string) func(string) string {
+		return func(doc string) string {
+			if !strings.Contains(doc, old) {
+				t.Fatalf("%q not in the response", old)
+			}
+			return strings.Replace(doc, old, new, 1)
+		}
+	}
+	// extensions puts s in a samlp:Extensions element after the response issuer.
+	extensions := func(doc, s string) string {
+		const at = `<samlp:Status>`
+		return strings.Replace(doc, at, "<samlp:Extensions>"+s+"</samlp:Extensions>"+at, 1)
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(string) string // before signing
+		sign    map[string]*rsa.PrivateKey
+		after   func(string) string // after signing
+		want    Login
+		wantErr string
+	}{
+		{
+			name: "signed assertion",
+			sign: map[string]*rsa.PrivateKey{"a1": key},
+			want: Login{User: "alice@example.com", Role: roleAdmin, Expires: samlNow.Add(2 * time.Hour).Unix()},
+		},
+		{
+			name: "signed response",
+			sign: map[string]*rsa.PrivateKey{"r1": key},
+			want: Login{User: "alice@example.com", Role: roleAdmin, Expires: samlNow.Add(2 * time.Hour).Unix()},
+		},
+		{
+			name: "both signed, no admin value, login capped at the session",
+			edit: func(doc string) string {
+				doc = replace(">admins<", ">users<")(doc)
+				return replace(`SessionNotOnOrAfter="2026-01-01T14:00:00Z"`, `SessionNotOnOrAfter="2026-01-02T14:00:00Z"`)(doc)
+			},
+			sign: map[string]*rsa.PrivateKey{"a1": key, "r1": key},
+			want: Login{User: "alice@example.com", Role: roleUser, Expires: samlNow.Add(loginMaxAge).Unix()},
+		},
+		{
+			name:    "unsigned",
+			wantErr: "neither the response nor the assertion is signed",
+		},
+		{
+			name:    "signed by another key",
+			sign:    map[string]*rsa.PrivateKey{"a1": other},
+			wantErr: "does not match",
+		},
+		{
+			name:    "valid response signature, bad assertion signature",
+			sign:    map[string]*rsa.PrivateKey{"a1": other, "r1": key},
+			wantErr: "Assertion signature",
+		},
+		{
+			name:    "NameID changed after signing",
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			after:   replace("alice@", "mallory@"),
+			wantErr: "digest mismatch",
+		},
+		{
+			name:    "role changed after signing the response",
+			sign:    map[string]*rsa.PrivateKey{"r1": key},
+			after:   replace(">staff<", ">admins<"),
+			wantErr: "digest mismatch",
+		},
+		{
+			name:    "expired",
+			edit:    replace(`NotBefore="2026-01-01T11:59:00Z" NotOnOrAfter="2026-01-01T12:05:00Z"`, `NotBefore="2026-01-01T11:00:00Z" NotOnOrAfter="2026-01-01T11:55:00Z"`),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "expired",
+		},
+		{
+			name: "expired within the clock skew",
+			edit: replace(`NotOnOrAfter="2026-01-01T12:05:00Z">`, `NotOnOrAfter="2026-01-01T11:59:00Z">`),
+			sign: map[string]*rsa.PrivateKey{"a1": key},
+			want: Login{User: "alice@example.com", Role: roleAdmin, Expires: samlNow.Add(2 * time.Hour).Unix()},
+		},
+		{
+			name:    "not valid yet",
+			edit:    replace(`NotBefore="2026-01-01T11:59:00Z"`, `NotBefore="2026-01-01T12:10:00Z"`),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "not valid yet",
+		},
+		{
+			name:    "wrong audience",
+			edit:    replace("<saml:Audience>https://sp.example/saml/metadata<", "<saml:Audience>https://other.example/<"),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "not meant for this service provider",
+		},
+		{
+			name: "no conditions",
+			edit: func(doc string) string {
+				return doc[:strings.Index(doc, "<saml:Conditions")] + doc[strings.Index(doc, "</saml:Conditions>")+len("</saml:Conditions>"):]
+			},
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "no conditions",
+		},
+		{
+			name:    "unknown InResponseTo",
+			edit:    replace(`Destination="https://sp.example/saml/acs" InResponseTo="req1"`, `Destination="https://sp.example/saml/acs" InResponseTo="req2"`),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "pending login",
+		},
+		{
+			name:    "IdP-initiated",
+			edit:    replace(` InResponseTo="req1">`, `>`),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "pending login",
+		},
+		{
+			name:    "confirmation for another request",
+			edit:    replace(`InResponseTo="req1" Recipient`, `InResponseTo="req2" Recipient`),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "no valid bearer subject confirmation",
+		},
+		{
+			name:    "confirmation for another recipient",
+			edit:    replace(`Recipient="https://sp.example/saml/acs"`, `Recipient="https://other.example/acs"`),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "no valid bearer subject confirmation",
+		},
+		{
+			name:    "confirmation without expiry",
+			edit:    replace(` NotOnOrAfter="2026-01-01T12:05:00Z"/>`, `/>`),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "no valid bearer subject confirmation",
+		},
+		{
+			name:    "holder-of-key confirmation",
+			edit:    replace("cm:bearer", "cm:holder-of-key"),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "no valid bearer subject confirmation",
+		},
+		{
+			name:    "response from another issuer",
+			edit:    replace(`https://idp.example/</saml:Issuer><!--SIG:r1-->`, `https://evil.example/</saml:Issuer><!--SIG:r1-->`),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "response issued by",
+		},
+		{
+			name:    "assertion from another issuer",
+			edit:    replace(`https://idp.example/</saml:Issuer><!--SIG:a1-->`, `https://evil.example/</saml:Issuer><!--SIG:a1-->`),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "assertion issued by",
+		},
+		{
+			name:    "other destination",
+			edit:    replace(`Destination="https://sp.example/saml/acs"`, `Destination="https://other.example/acs"`),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "response is for",
+		},
+		{
+			name:    "failed status",
+			edit:    replace("status:Success", "status:Requester"),
+			wantErr: "IdP returned status",
+		},
+		{
+			name:    "encrypted assertion",
+			edit:    replace(`<saml:Assertion `, `<saml:EncryptedAssertion/><saml:Assertion `),
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "encrypted assertions are not supported",
+		},
+		{
+			name: "two assertions",
+			edit: func(doc string) string {
+				a := assertion(doc)
+				return strings.Replace(doc, a, a+strings.Replace(stripMarkers(a), `ID="a1"`, `ID="a2"`, 1), 1)
+			},
+			sign:    map[string]*rsa.PrivateKey{"a1": key},
+			wantErr: "expected one assertion, got 2",
+		},
+		{
+			name:    "not a response",
+			edit:    func(doc string) string { return assertion(doc) },
+			wantErr: "not a SAML response",
+		},
+		{
+			// XSW: the signed assertion is kept where signature lookup by ID
+			// would find it, and a forged one with the same ID takes its place.
+			name: "wrapping with a duplicate ID",
+			sign: map[string]*rsa.PrivateKey{"a1": key},
+			after: func(doc string) string {
+				a := assertion(doc)
+				forged := strings.Replace(a, "alice@", "mallory@", 1)
+				return extensions(strings.Replace(doc, a, forged, 1), a)
+			},
+			wantErr: `duplicate ID "a1"`,
+		},
+		{
+			// XSW: the forged assertion carries the original signature,
+			// which still points at the moved assertion.
+			name: "wrapping with a signature of another element",
+			sign: map[string]*rsa.PrivateKey{"a1": key},
+			after: func(doc string) string {
+				a := assertion(doc)
+				forged := strings.Replace(strings.Replace(a, "alice@", "mallory@", 1), `ID="a1"`, `ID="a2"`, 1)
+				return extensions(strings.Replace(doc, a, forged, 1), a)
+			},
+			wantErr: "does not reference the signed element",
+		},
+		{
+			// XSW: the forged assertion is unsigned and the signed one is
+			// moved out of the way.
+			name: "wrapping with an unsigned assertion",
+			sign: map[string]*rsa.PrivateKey{"a1": key},
+			after: func(doc string) string {
+				a := assertion(doc)
+				forged := strings.Replace(a, "alice@", "mallory@", 1)
+				forged = forged[:strings.Index(forged, "<ds:Signature")] + forged[strings.Index(forged, "</ds:Signature>")+len("</ds:Signature>"):]
+				forged = strings.Replace(forged, `ID="a1"`, `ID="a2"`, 1)
+				return extensions(strings.Replace(doc, a, forged, 1), a)
+			},
+			wantErr: "neither the response nor the assertion is signed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := samlResponse
+			if tt.edit != nil {
+				doc = tt.edit(doc)
+			}
+			for _, id := range []string{"a1", "r1"} {
+				if k := tt.sign[id]; k != nil {
+					doc = signXML(t, doc, id, k)
+				}
+			}
+			doc = stripMarkers(doc)
+			if tt.after != nil {
+				doc = tt.after(doc)
+			}
+
+			got, err := testSAMLProvider(key).parseResponse([]byte(doc), samlNow)
+			switch {
+			case tt.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error %v, want %q", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case got != tt.want:
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseResponseReplay(t *testing.T) {
+	key := testKey(t, 0)
+	doc := []byte(stripMarkers(signXML(t, samlResponse, "a1", key)))
+
+	p := testSAMLProvider(key)
+	if _, err := p.parseResponse(doc, samlNow); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.parseResponse(doc, samlNow); err == nil || !strings.Contains(err.Error(), "pending login") {
+		t.Errorf("replayed response: error %v", err)
+	}
+
+	p = testSAMLProvider(key)
+	if _, err := p.parseResponse(doc, samlNow.Add(samlRequestTTL+time.Second)); err == nil || !strings.Contains(err.Error(), "pending login") {
+		t.Errorf("response to an expired request: error %v", err)
+	}
+}
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Scheduled jobs ---
+//
+// Users register recurring jobs: a cron expression (see cron.go), a source
+// (a URL, fetched afresh on every run, or a stored document), a built-in
+// operation and where the result goes (a webhook, an email, or both), e.g.
+// "summarize this RSS feed every morning". Due schedules run on the job
+// workers, as "schedule" jobs of their user. A run missed while the server
+// was down happens once when it is back. Schedules are persisted to
+// SCHEDULES_FILE.
+//
+// Results are only mailed to the domains in SCHEDULE_EMAIL_DOMAINS, so the
+// server can't be used to send mail to anyone, and each tenant (or client
+// IP address without one) can register a limited number of schedules,
+// whatever X-User-ID it sends.
+
+const (
+	maxSchedulesPerClient = 50
+	scheduleTimeout       = 10 * time.Minute
+)
+
+// scheduleMinInterval is the shortest time allowed between the runs of a
+// schedule (SCHEDULE_MIN_INTERVAL).
+var scheduleMinInterval = 15 * time.Minute
+
+// scheduleWebhookSecret signs webhook deliveries (SCHEDULE_WEBHOOK_SECRET).
+var scheduleWebhookSecret = os.Getenv("SCHEDULE_WEBHOOK_SECRET")
+
+// ScheduleSpec is what a user registers.
+type ScheduleSpec struct {
+	Name     string `json:"name"`
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone,omitempty"` // IANA name the cron expression is in; UTC if empty
+
+	URL        string `json:"url,omitempty"`         // a page or feed to fetch on each run
+	DocumentID string `json:"document_id,omitempty"` // or a stored document
+
+	Operation string `json:"operation"`
+	Tone      string `json:"tone,omitempty"`
+	Options
+
+	Webhook string `json:"webhook,omitempty"` // URL the result is POSTed to
+	Email   string `json:"email,omitempty"`   // address the result is mailed to
+
+	Paused bool `json:"paused,omitempty"`
+}
+
+// Schedule is a registered recurring job.
+type Schedule struct {
+	ID     string `json:"id"`
+	User   string `json:"user"`
+	Client string `json:"client,omitempty"` // the tenant or IP address that registered it
+	ScheduleSpec
+	CreatedAt time.Time    `json:"created_at"`
+	NextRun   *time.Time   `json:"next_run,omitempty"` // none while paused
+	LastRun   *ScheduleRun `json:"last_run,omitempty"`
+	Runs      int          `json:"runs"`
+
+	running bool
+}
+
+// ScheduleRun is the outcome of a run.
+type ScheduleRun struct {
+	At     time.Time   `json:"at"`
+	JobID  string      `json:"job_id,omitempty"`
+	Status string      `json:"status"` // done or failed
+	Error  string      `json:"error,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// ScheduleDelivery is the body POSTed to a schedule's webhook.
+type ScheduleDelivery struct {
+	ScheduleID string      `json:"schedule_id"`
+	Name       string      `json:"name"`
+	Operation  string      `json:"operation"`
+	Source     string      `json:"source"` // the URL, or document:<id>
+	RanAt      time.Time   `json:"ran_at"`
+	Result     interface{} `json:"result"`
+}
+
+// validate checks s; smtp is needed for email delivery, which only goes to
+// emailDomains.
+func (s *ScheduleSpec) validate(smtp SMTPConfig, emailDomains []string) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" || len([]rune(s.Name)) > 200 {
+		return errors.New("`name` is required (at most 200 characters)")
+	}
+	cron, err := parseCron(s.Cron)
+	if err != nil {
+		return err
+	}
+	loc := time.UTC
+	if s.Timezone != "" {
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", s.Timezone)
+		}
+	}
+	if gap := cronMinGap(cron, time.Now().In(loc)); gap < scheduleMinInterval {
+		return fmt.Errorf("schedules may run at most every %s", scheduleMinInterval)
+	}
+	if (s.URL == "") == (s.DocumentID == "") {
+		return errors.New("exactly one of `url` and `document_id` is required")
+	}
+	if s.URL != "" && !isHTTPURL(s.URL) {
+		return errors.New("`url` must be an http(s) URL")
+	}
+	if !isBuiltinOperation(s.Operation) {
+		return errors.New("unknown operation")
+	}
+	if err := s.Options.validate(); err != nil {
+		return err
+	}
+	if s.Webhook == "" && s.Email == "" {
+		return errors.New("`webhook` or `email` is required")
+	}
+	if s.Webhook != "" && !isHTTPURL(s.Webhook) {
+		return errors.New("`webhook` must be an http(s) URL")
+	}
+	if s.Email != "" {
+		a, err := mail.ParseAddress(s.Email)
+		if err != nil {
+			return errors.New("`email` must be an email address")
+		}
+		s.Email = a.Address
+		if smtp.Addr == "" || smtp.From == "" {
+			return errors.New("email delivery needs SMTP_ADDR and EMAIL_FROM")
+		}
+		if len(emailDomains) == 0 {
+			return errors.New("email delivery is off: SCHEDULE_EMAIL_DOMAINS is not set")
+		}
+		domain := strings.ToLower(mailDomain(s.Email))
+		if !slices.Contains(emailDomains, domain) {
+			return errors.New("`email` must be at one of the domains in SCHEDULE_EMAIL_DOMAINS")
+		}
+	}
+	return nil
+}
+
+// cronMinGap is the shortest time between the next runs of cron.
+func cronMinGap(cron *cronSpec, from time.Time) time.Duration {
+	gap := time.Duration(1<<63 - 1)
+	t := cron.Next(from)
+	for i := 0; i < 50 && !t.IsZero(); i++ {
+		next := cron.Next(t)
+		if next.IsZero() {
+			break
+		}
+		if d := next.Sub(t); d < gap {
+			gap = d
+		}
+		t = next
+	}
+	return gap
+}
+
+// nextRun sets when s runs next, after t.
+func (s *Schedule) nextRun(t time.Time) {
+	s.NextRun = nil
+	if s.Paused {
+		return
+	}
+	cron, err := parseCron(s.Cron)
+	if err != nil {
+		return
+	}
+	loc := time.UTC
+	if s.Timezone != "" {
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return
+		}
+	}
+	if next := cron.Next(t.In(loc)); !next.IsZero() {
+		next = next.UTC()
+		s.NextRun = &next
+	}
+}
+
+// source names where s takes its text from.
+func (s *Schedule) source() string {
+	if s.URL != "" {
+		return s.URL
+	}
+	return "document:" + s.DocumentID
+}
+
+// ScheduleStore keeps the schedules, optionally persisted to a JSON file,
+// and runs them when due.
+type ScheduleStore struct {
+	file string
+	wake chan struct{}
+
+	mu        sync.Mutex
+	schedules map[string]*Schedule
+}
+
+func NewScheduleStore(file string) (*ScheduleStore, error) {
+	s := &ScheduleStore{file: file, wake: make(chan struct{}, 1), schedules: map[string]*Schedule{}}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.schedules); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return s, nil
+}
+
+// List returns the schedules of user, oldest first, without their last
+// result.
+func (s *ScheduleStore) List(user string) []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []Schedule{}
+	for _, sc := range s.schedules {
+		if sc.User != user {
+			continue
+		}
+		cp := *sc
+		if cp.LastRun != nil {
+			run := *cp.LastRun
+			run.Result = nil
+			cp.LastRun = &run
+		}
+		out = append(out, cp)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Get returns a copy of the schedule id of user.
+func (s *ScheduleStore) Get(user, id string) (Schedule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.schedules[id]
+	if !ok || sc.User != user {
+		return Schedule{}, false
+	}
+	return *sc, true
+}
+
+var errTooManySchedules = fmt.Errorf("at most %d schedules per tenant or IP address", maxSchedulesPerClient)
+
+// Put stores sc, replacing the schedule with its ID but keeping its runs
+// and client. A new schedule counts against the cap of its client.
+func (s *ScheduleStore) Put(sc Schedule) (Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.schedules[sc.ID]; ok {
+		sc.Client, sc.LastRun, sc.Runs, sc.running = old.Client, old.LastRun, old.Runs, old.running
+	} else {
+		n := 0
+		for _, e := range s.schedules {
+			if e.Client == sc.Client {
+				n++
+			}
+		}
+		if n >= maxSchedulesPerClient {
+			return Schedule{}, errTooManySchedules
+		}
+	}
+	sc.nextRun(time.Now())
+	s.schedules[sc.ID] = &sc
+	s.poke()
+	return sc, s.saveLocked()
+}
+
+// Delete removes the schedule id of user, reporting whether it existed.
+func (s *ScheduleStore) Delete(user, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.schedules[id]
+	if !ok || sc.User != user {
+		return false, nil
+	}
+	delete(s.schedules, id)
+	return true, s.saveLocked()
+}
+
+// begin marks the schedule id as running, unless it already is, and moves
+// its next run past now.
+func (s *ScheduleStore) begin(id string, now time.Time) (Schedule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.schedules[id]
+	if !ok || sc.running {
+		return Schedule{}, false
+	}
+	sc.running = true
+	sc.nextRun(now)
+	if err := s.saveLocked(); err != nil {
+		log.Println("schedules error:", err)
+	}
+	return *sc, true
+}
+
+// finish records a run of the schedule id.
+func (s *ScheduleStore) finish(id string, run ScheduleRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.schedules[id]
+	if !ok {
+		return
+	}
+	sc.running = false
+	sc.LastRun = &run
+	sc.Runs++
+	if err := s.saveLocked(); err != nil {
+		log.Println("schedules error:", err)
+	}
+}
+
+// due returns the IDs of the schedules to run at now, and how long until
+// the next one is due after them.
+func (s *ScheduleStore) due(now time.Time) ([]string, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	wait := time.Minute
+	for id, sc := range s.schedules {
+		if sc.NextRun == nil || sc.running {
+			continue
+		}
+		if !sc.NextRun.After(now) {
+			ids = append(ids, id)
+		} else if d := sc.NextRun.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	return ids, wait
+}
+
+// poke wakes the scheduler to look at a changed schedule.
+func (s *ScheduleStore) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *ScheduleStore) saveLocked() error {
+	if s.file == "" {
+		return nil
+	}
+	return writeJSONFile(s.file, s.schedules)
+}
+
+// Scheduler runs schedules as jobs.
+type Scheduler struct {
+	Store     *ScheduleStore
+	Jobs      *JobStore
+	Tools     *texttools.Tools
+	Documents *DocumentStore
+	SMTP      SMTPConfig
+
+	// EmailDomains are the domains results may be mailed to
+	// (SCHEDULE_EMAIL_DOMAINS); none if empty.
+	EmailDomains []string
+}
+
+// Run starts the due schedules, checking at least every minute.
+func (sr *Scheduler) Run() {
+	for {
+		ids, wait := sr.Store.due(time.Now())
+		for _, id := range ids {
+			if _, _, err := sr.Start(id); err != nil {
+				log.Printf("schedule %s: %v", id, err)
+			}
+		}
+		select {
+		case <-sr.Store.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Start runs the schedule id now, as a job of its user. It returns false
+// if the schedule is already running.
+func (sr *Scheduler) Start(id string) (*Job, bool, error) {
+	sc, ok := sr.Store.begin(id, time.Now())
+	if !ok {
+		return nil, false, nil
+	}
+	started := time.Now().UTC()
+	j, err := sr.Jobs.Submit("schedule", sc.User, func(p *JobProgress) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(batchContext(context.Background()), scheduleTimeout)
+		defer cancel()
+		result, err := sr.run(ctx, sc, started)
+		run := ScheduleRun{At: started, JobID: p.id, Status: jobDone, Result: result}
+		if err != nil {
+			log.Printf("schedule %s: %v", sc.ID, err)
+			run.Status, run.Error = jobFailed, err.Error()
+		}
+		sr.Store.finish(sc.ID, run)
+		return result, err
+	})
+	if err != nil {
+		sr.Store.finish(sc.ID, ScheduleRun{At: started, Status: jobFailed, Error: err.Error()})
+		return nil, true, err
+	}
+	return j, true, nil
+}
+
+// run fetches the source of sc, runs its operation and delivers the
+// result.
+func (sr *Scheduler) run(ctx context.Context, sc Schedule, at time.Time) (interface{}, error) {
+	text, err := sr.sourceText(sc)
+	if err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	if err := validateText(text); err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	result, err := runOperation(ctx, sr.Tools, sc.Operation, RewriteRequest{Text: text, Tone: sc.Tone, Options: sc.Options})
+	if err != nil {
+		return nil, fmt.Errorf("LLM error: %w", err)
+	}
+
+	var errs []error
+	if sc.Webhook != "" {
+		d := ScheduleDelivery{ScheduleID: sc.ID, Name: sc.Name, Operation: sc.Operation, Source: sc.source(), RanAt: at, Result: result}
+		if err := postWebhook(ctx, sc.Webhook, d); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if sc.Email != "" {
+		body := plainResult(result)
+		if body == "" {
+			b, _ := json.MarshalIndent(result, "", "  ")
+			body = string(b)
+		}
+		body += "\n\n--\nSource: " + sc.source()
+		if err := sendMail(sr.SMTP, sc.Email, sc.Name, "", "auto-generated", body); err != nil {
+			errs = append(errs, fmt.Errorf("email: %w", err))
+		}
+	}
+	return result, errors.Join(errs...)
+}
+
+// sourceText is the text a run of sc works on.
+func (sr *Scheduler) sourceText(sc Schedule) (string, error) {
+	if sc.DocumentID != "" {
+		d, ok := sr.Documents.Get(sc.User, sc.DocumentID)
+		if !ok {
+			return "", errors.New("document not found")
+		}
+		return d.Text, nil
+	}
+	data, err := fetchURL(sc.URL)
+	if err != nil {
+		return "", err
+	}
+	return fetchedText(data), nil
+}
+
+// fetchedText is the text of a fetched page: the items of an RSS or Atom
+// feed, the text of an HTML page, or the body as it is.
+func fetchedText(data []byte) string {
+	head := bytes.ToLower(data[:min(len(data), 1024)])
+	switch {
+	case bytes.Contains(head, []byte("<rss")) || bytes.Contains(head, []byte("<feed")):
+		if text, err := feedText(data); err == nil {
+			return text
+		}
+	case bytes.Contains(head, []byte("<html")) || bytes.Contains(head, []byte("<!doctype html")):
+		text, _, _ := texttools.HTMLText(data)
+		return text
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// postWebhook POSTs v as JSON to u, signed with scheduleWebhookSecret if
+// set: X-Signature is "sha256=" and the hex HMAC-SHA256 of the body.
+func postWebhook(ctx context.Context, u string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ai-text-tools/1.0")
+	if scheduleWebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(scheduleWebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// --- Schedule handlers ---
+
+// scheduleClient names who registers a schedule for the cap on schedules:
+// the tenant of r, or its IP address without one.
+func scheduleClient(r *http.Request) string {
+	if t := tenantName(r); t != "" {
+		return "tenant:" + t
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// schedulesHandler serves /schedules (GET lists the caller's schedules,
+// POST registers one), /schedules/<id> (GET, PUT replaces it, DELETE) and
+// POST /schedules/<id>/run, which runs it now.
+func schedulesHandler(sr *Scheduler, prefs *PreferenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/schedules"), "/")
+		id, action, _ := strings.Cut(id, "/")
+		user := userID(r)
+
+		switch {
+		case action == "run" && r.Method == http.MethodPost:
+			if _, ok := sr.Store.Get(user, id); !ok {
+				http.Error(w, "schedule not found", http.StatusNotFound)
+				return
+			}
+			j, started, err := sr.Start(id)
+			if !started {
+				http.Error(w, "the schedule is already running", http.StatusConflict)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, j)
+		case action != "":
+			http.NotFound(w, r)
+		case r.Method == http.MethodGet && id == "":
+			writeJSON(w, http.StatusOK, map[string]interface{}{"schedules": sr.Store.List(user)})
+		case r.Method == http.MethodGet:
+			sc, ok := sr.Store.Get(user, id)
+			if !ok {
+				http.Error(w, "schedule not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, sc)
+		case r.Method == http.MethodPost && id == "", r.Method == http.MethodPut && id != "":
+			if refusePrivate(w, r, "schedules") {
+				return
+			}
+			sc := Schedule{ID: newID(), User: user, Client: scheduleClient(r), CreatedAt: time.Now().UTC()}
+			if r.Method == http.MethodPut {
+				var ok bool
+				if sc, ok = sr.Store.Get(user, id); !ok {
+					http.Error(w, "schedule not found", http.StatusNotFound)
+					return
+				}
+			}
+			var spec ScheduleSpec
+			if !decodeJSON(w, r, &spec) {
+				return
+			}
+			if err := spec.validate(sr.SMTP, sr.EmailDomains); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if spec.DocumentID != "" {
+				if _, ok := sr.Documents.Get(user, spec.DocumentID); !ok {
+					http.Error(w, "document not found", http.StatusBadRequest)
+					return
+				}
+			}
+			p := prefs.For(r)
+			p.apply(&spec.Options)
+			if spec.Tone == "" {
+				spec.Tone = p.Tone
+			}
+			sc.ScheduleSpec = spec
+			sc, err := sr.Store.Put(sc)
+			if errors.Is(err, errTooManySchedules) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			if err != nil {
+				log.Println("schedules error:", err)
+				http.Error(w, "failed to save the schedule", http.StatusInternalServerError)
+				return
+			}
+			status := http.StatusOK
+			if r.Method == http.MethodPost {
+				status = http.StatusCreated
+			}
+			writeJSON(w, status, sc)
+		case r.Method == http.MethodDelete && id != "":
+			ok, err := sr.Store.Delete(user, id)
+			if err != nil {
+				log.Println("schedules error:", err)
+				http.Error(w, "failed to save schedules", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				http.Error(w, "schedule not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScheduleEmailDomains(t *testing.T) {
+	smtp := SMTPConfig{Addr: "mail.example.com:25", From: "bot@example.com"}
+	domains := []string{"example.com"}
+	tests := []struct {
+		name    string
+		smtp    SMTPConfig
+		domains []string
+		email   string
+		wantErr string
+	}{
+		{"allowed", smtp, domains, "Me <me@Example.COM>", ""},
+		{"other domain", smtp, domains, "victim@elsewhere.org", "SCHEDULE_EMAIL_DOMAINS"},
+		{"subdomain", smtp, domains, "me@mail.example.com", "SCHEDULE_EMAIL_DOMAINS"},
+		{"no domains", smtp, nil, "me@example.com", "SCHEDULE_EMAIL_DOMAINS is not set"},
+		{"no SMTP", SMTPConfig{}, domains, "me@example.com", "SMTP_ADDR"},
+		{"not an address", smtp, domains, "me at example.com", "email address"},
+	}
+	for _, tt := range tests {
+		s := ScheduleSpec{Name: "n", Cron: "@daily", URL: "https://example.com/rss", Operation: "summarize", Email: tt.email}
+		err := s.validate(tt.smtp, tt.domains)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestScheduleCapPerClient(t *testing.T) {
+	store, _ := NewScheduleStore("")
+	spec := ScheduleSpec{Name: "n", Cron: "@daily", URL: "https://example.com/rss", Operation: "summarize", Webhook: "https://example.com/hook"}
+
+	// varying X-User-ID doesn't get around the cap of a client
+	put := func(user, addr string) error {
+		r := httptest.NewRequest("POST", "/schedules", nil)
+		r.RemoteAddr = addr
+		_, err := store.Put(Schedule{ID: newID(), User: user, Client: scheduleClient(r), ScheduleSpec: spec})
+		return err
+	}
+	for i := 0; i < maxSchedulesPerClient; i++ {
+		if err := put(newID(), "192.0.2.1:1234"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := put(newID(), "192.0.2.1:5678"); !errors.Is(err, errTooManySchedules) {
+		t.Errorf("schedule %d of a client: %v", maxSchedulesPerClient+1, err)
+	}
+	if err := put("default", "192.0.2.2:1234"); err != nil {
+		t.Errorf("another client: %v", err)
+	}
+
+	// replacing a schedule keeps its client
+	sc := store.List("default")[0]
+	sc.Client = "ip:192.0.2.9"
+	if sc, _ = store.Put(sc); sc.Client != "ip:192.0.2.2" {
+		t.Errorf("replaced schedule has client %q", sc.Client)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Embeddings and semantic search ---
+//
+// POST /embed returns embedding vectors for texts. With the semantic_search
+// flag on (SEMANTIC_SEARCH=true), the input of every result stored in
+// history is embedded too, in the background, and GET /search?q=... finds
+// the caller's earlier results by meaning rather than by wording. The
+// vectors live in memory, persisted to VECTORS_FILE (JSON) if set. Without
+// the flag, or with ?mode=keyword, GET /search searches by wording (see
+// fulltext.go).
+
+const (
+	// maxEmbedChars caps the text embedded per input, to stay within the
+	// embedding model's context.
+	maxEmbedChars = 20000
+
+	maxEmbedTexts      = 100
+	defaultSearchLimit = 10
+	maxSearchLimit     = 50
+)
+
+type EmbedRequest struct {
+	Texts []string `json:"texts"`
+}
+
+type EmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+	Dimensions int         `json:"dimensions"`
+}
+
+// Search modes.
+const (
+	searchSemantic = "semantic"
+	searchKeyword  = "keyword"
+)
+
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Mode    string         `json:"mode"` // semantic or keyword
+	Results []SearchResult `json:"results"`
+}
+
+// SearchResult is a history entry (or, in keyword search, a stored
+// document) matching a search, best first.
+type SearchResult struct {
+	Type      string          `json:"type,omitempty"` // keyword search: document or result
+	ID        string          `json:"id"`
+	Name      string          `json:"name,omitempty"`      // of a document
+	Operation string          `json:"operation,omitempty"` // of a result
+	Score     float64         `json:"score"`               // cosine similarity to the query, up to 1; or BM25 in keyword search
+	Field     string          `json:"field,omitempty"`     // keyword search: where the snippet is from
+	Snippet   string          `json:"snippet"`             // keyword search: HTML-escaped, matches in <mark>
+	Result    json.RawMessage `json:"result,omitempty"`
+	Labels
+	CreatedAt time.Time `json:"created_at"` // or when a document was last updated
+}
+
+// StoredVector is the embedding of a history entry's input.
+type StoredVector struct {
+	ID     string    `json:"id"` // the history entry
+	User   string    `json:"user"`
+	Vector []float32 `json:"vector"`
+}
+
+// VectorStore keeps the embeddings of history entries, optionally persisted
+// to a JSON file.
+type VectorStore struct {
+	file string
+
+	mu      sync.RWMutex
+	vectors map[string]*StoredVector // by history entry ID
+}
+
+func NewVectorStore(file string) (*VectorStore, error) {
+	s := &VectorStore{file: file, vectors: map[string]*StoredVector{}}
+	if file == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*StoredVector
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for _, v := range list {
+		s.vectors[v.ID] = v
+	}
+	return s, nil
+}
+
+// Add stores v and drops the vectors of entries that are no longer in
+// history (evicted or deleted).
+func (s *VectorStore) Add(v *StoredVector, history *History) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vectors[v.ID] = v
+	for id := range s.vectors {
+		if _, ok := history.Get(id); !ok {
+			delete(s.vectors, id)
+		}
+	}
+	if err := s.saveLocked(); err != nil {
+		log.Println("vectors error:", err)
+	}
+}
+
+func (s *VectorStore) saveLocked() error {
+	if s.file == "" {
+		return nil
+	}
+	list := make([]*StoredVector, 0, len(s.vectors))
+	for _, v := range s.vectors {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return writeJSONFile(s.file, list)
+}
+
+// scored is a stored vector with its similarity to a query.
+type scored struct {
+	id    string
+	score float64
+}
+
+// Nearest returns the user's vectors by descending cosine similarity to q.
+func (s *VectorStore) Nearest(user string, q []float32) []scored {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []scored
+	for _, v := range s.vectors {
+		if v.User == user {
+			out = append(out, scored{v.ID, cosine(q, v.Vector)})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].score > out[j].score })
+	return out
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// embed calls the embedder, recording the call in metrics.
+func embed(ctx context.Context, embedder texttools.Embedder, texts []string) ([][]float32, error) {
+	for i, t := range texts {
+		texts[i] = truncateRunes(t, maxEmbedChars)
+	}
+	vectors, usage, err := embedder.Embed(ctx, texts)
+	metrics.RecordLLM(usage, err)
+	return vectors, err
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// indexer returns the History.OnRecord hook that embeds new entries while
+// the semantic_search flag is on.
+func indexer(embedder texttools.Embedder, vectors *VectorStore, history *History) func(*HistoryEntry) {
+	return func(e *HistoryEntry) {
+		if !features.Enabled("semantic_search") {
+			return
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			v, err := embed(ctx, embedder, []string{e.Input})
+			if err != nil {
+				log.Println("index error:", err)
+				return
+			}
+			vectors.Add(&StoredVector{ID: e.ID, User: e.User, Vector: v[0]}, history)
+		}()
+	}
+}
+
+func embedHandler(embedder texttools.Embedder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req EmbedRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if len(req.Texts) == 0 {
+			http.Error(w, "`texts` is required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Texts) > maxEmbedTexts {
+			http.Error(w, fmt.Sprintf("at most %d texts per request", maxEmbedTexts), http.StatusBadRequest)
+			return
+		}
+		for _, t := range req.Texts {
+			if t == "" {
+				http.Error(w, "`texts` must not contain empty texts", http.StatusBadRequest)
+				return
+			}
+			if !checkText(w, t) {
+				return
+			}
+		}
+
+		vectors, err := embed(r.Context(), embedder, req.Texts)
+		if err != nil {
+			log.Println("embed error:", err)
+			writeLLMError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, EmbedResponse{Embeddings: vectors, Dimensions: len(vectors[0])})
+	}
+}
+
+// searchHandler serves GET /search?q=...&limit=...&operation=..., by
+// meaning with the semantic_search flag on and an embedder, otherwise (or
+// with ?mode=keyword) by wording.
+func searchHandler(embedder texttools.Embedder, vectors *VectorStore, history *History, documents *DocumentStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		if q == "" {
+			http.Error(w, "`q` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, q) {
+			return
+		}
+		limit := defaultSearchLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				http.Error(w, fmt.Sprintf("`limit` must be between 1 and %d", maxSearchLimit), http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		semantic := embedder != nil && features.Enabled("semantic_search")
+		switch r.URL.Query().Get("mode") {
+		case "":
+			if !semantic {
+				keywordSearchHandler(documents, history, q, limit)(w, r)
+				return
+			}
+		case searchKeyword:
+			keywordSearchHandler(documents, history, q, limit)(w, r)
+			return
+		case searchSemantic:
+			if !semantic {
+				http.Error(w, "semantic search is off (turn on the semantic_search flag)", http.StatusNotFound)
+				return
+			}
+		default:
+			http.Error(w, "`mode` must be semantic or keyword", http.StatusBadRequest)
+			return
+		}
+		op := r.URL.Query().Get("operation")
+
+		qv, err := embed(r.Context(), embedder, []string{q})
+		if err != nil {
+			log.Println("search error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		resp := SearchResponse{Query: q, Mode: searchSemantic, Results: []SearchResult{}}
+		for _, s := range vectors.Nearest(userID(r), qv[0]) {
+			e, ok := history.Get(s.id)
+			if !ok || (op != "" && e.Operation != op) {
+				continue
+			}
+			snippet := truncateRunes(e.Input, 200)
+			if snippet != e.Input {
+				snippet += "…"
+			}
+			resp.Results = append(resp.Results, SearchResult{
+				ID:        e.ID,
+				Operation: e.Operation,
+				Score:     math.Round(s.score*1000) / 1000,
+				Snippet:   snippet,
+				Result:    e.Result,
+				CreatedAt: e.CreatedAt,
+			})
+			if len(resp.Results) == limit {
+				break
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- SEO metadata ---
+//
+// POST /seo writes the metadata of an article: meta title, meta
+// description, URL slug and Open Graph description. Models don't count
+// characters reliably, so the limits are enforced here: fields that are too
+// long are cut at a word boundary and listed in "truncated", and the slug
+// is normalized whatever the model returns.
+
+const (
+	maxMetaTitleChars       = 60
+	maxMetaDescriptionChars = 160
+	maxOGDescriptionChars   = 200
+	maxSlugChars            = 60
+)
+
+type SEORequest struct {
+	Text    string `json:"text"`
+	Keyword string `json:"keyword"` // the search term to target, if any
+	Options
+}
+
+type SEOResponse struct {
+	MetaTitle       string   `json:"meta_title"`
+	MetaDescription string   `json:"meta_description"`
+	Slug            string   `json:"slug"`
+	OGDescription   string   `json:"og_description"`
+	Truncated       []string `json:"truncated"` // the fields cut to their limit
+	ResultMeta
+}
+
+// seoPromptInput is the data of the seo template.
+type seoPromptInput struct {
+	texttools.Input
+	Keyword string
+}
+
+func seoHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SEORequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Text == "" {
+			http.Error(w, "`text` is required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Text) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey(req.Keyword, req.Options)
+		if prior, ok := history.Reusable(r, "seo", key, req.Text, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		in := seoPromptInput{
+			Input:   texttools.Input{Text: texttools.PrepareInput(req.Text, req.InputFormat), Options: req.Options.Options},
+			Keyword: req.Keyword,
+		}
+		out, err := tools.Prompt(r.Context(), "seo", in, in.Options)
+		if err != nil {
+			log.Println("seo error:", err)
+			writeLLMError(w, err)
+			return
+		}
+
+		var resp SEOResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			logUnparseable(r.Context(), "seo", out)
+		}
+		resp.Truncated = []string{}
+		fit := func(field string, s *string, limit int) {
+			var cut bool
+			if *s, cut = fitWords(strings.Join(strings.Fields(*s), " "), limit); cut {
+				resp.Truncated = append(resp.Truncated, field)
+			}
+		}
+		fit("meta_title", &resp.MetaTitle, maxMetaTitleChars)
+		fit("meta_description", &resp.MetaDescription, maxMetaDescriptionChars)
+		fit("og_description", &resp.OGDescription, maxOGDescriptionChars)
+		resp.Slug = slugify(firstNonEmpty(resp.Slug, resp.MetaTitle), maxSlugChars)
+
+		resp.ResultMeta = history.Record(r, "seo", key, req.Text, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// fitWords cuts s to at most limit characters, ellipsis included, at a word
+// boundary if there is one, and reports whether it did.
+func fitWords(s string, limit int) (string, bool) {
+	if utf8.RuneCountInString(s) <= limit {
+		return s, false
+	}
+	runes := []rune(s)[:limit-1]
+	if i := strings.LastIndexFunc(string(runes), unicode.IsSpace); i > 0 {
+		runes = []rune(string(runes)[:i])
+	}
+	return strings.TrimRightFunc(string(runes), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) + "…", true
+}
+
+// slugify turns s into a URL slug of at most limit characters: lowercase
+// letters and digits separated by single hyphens, cut at a hyphen. Letters
+// outside ASCII are kept (browsers show them; links percent-encode them).
+func slugify(s string, limit int) string {
+	s = strings.NewReplacer("'", "", "’", "").Replace(strings.ToLower(s))
+	slug := ""
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		next := w
+		if slug != "" {
+			next = slug + "-" + w
+		}
+		if utf8.RuneCountInString(next) > limit {
+			if slug == "" {
+				slug = string([]rune(w)[:limit])
+			}
+			break
+		}
+		slug = next
+	}
+	return slug
+}
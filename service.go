@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// --- Service management ---
+//
+//	ai-text-tools [-env-file path]            run in the foreground
+//	ai-text-tools install [-env-file path]    install as a system service
+//	ai-text-tools uninstall                   remove the system service
+//
+// The service is a Windows service, a systemd unit (Linux) or a launchd
+// daemon (macOS); see service_windows.go and service_unix.go. Windows starts
+// the binary with the "service" command.
+
+const (
+	serviceName        = "ai-text-tools"
+	serviceDisplayName = "AI Text Tools"
+	serviceDescription = "AI-powered text tools web server"
+)
+
+// shutdownTimeout bounds how long in-flight requests get to finish on stop.
+const shutdownTimeout = 30 * time.Second
+
+type commandLine struct {
+	Command string // "", install, uninstall or service
+	EnvFile string
+}
+
+func parseCommandLine(args []string) (commandLine, error) {
+	var c commandLine
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		c.Command, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet(serviceName, flag.ContinueOnError)
+	fs.StringVar(&c.EnvFile, "env-file", "", "load environment variables from `path` (KEY=VALUE lines)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [install|uninstall] [-env-file path]\n", serviceName)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+
+	switch c.Command {
+	case "", "install", "uninstall", "service":
+	default:
+		fs.Usage()
+		return c, fmt.Errorf("unknown command %q", c.Command)
+	}
+	if c.EnvFile != "" {
+		abs, err := filepath.Abs(c.EnvFile)
+		if err != nil {
+			return c, err
+		}
+		c.EnvFile = abs
+	}
+	return c, nil
+}
+
+// envFileVars are the variables set by loadEnvFile, which a reload of the
+// file may change.
+var envFileVars = map[string]bool{}
+
+// loadEnvFile sets the variables in path that aren't already set, so the
+// real environment takes precedence. Lines are KEY=VALUE, optionally
+// prefixed with "export" and with the value in quotes; # starts a comment.
+// Loading the file again updates the variables it set.
+func loadEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if _, set := os.LookupEnv(key); !set || envFileVars[key] {
+			os.Setenv(key, value)
+			envFileVars[key] = true
+		}
+	}
+	return sc.Err()
+}
+
+// setupLogging appends the log to path, if set.
+func setupLogging(path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(f)
+	return nil
+}
+
+// writePIDFile writes the process ID to path, if set, refusing to start when
+// the file names another running instance. The returned func removes it.
+func writePIDFile(path string) (func(), error) {
+	if path == "" {
+		return func() {}, nil
+	}
+	if b, err := os.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && pid != os.Getpid() && processRunning(pid) {
+			return nil, fmt.Errorf("%s: already running as pid %d", path, pid)
+		}
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		return nil, err
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// stopSignal is closed when the process is asked to stop (Ctrl+C, SIGTERM).
+func stopSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		log.Printf("received %s", s)
+		close(stop)
+	}()
+	return stop
+}
+
+// serve runs the servers until one fails or stop is closed, then shuts them
+// all down gracefully, giving in-flight requests shutdownTimeout to finish.
+func serve(stop <-chan struct{}, servers ...*http.Server) error {
+	errc := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			if srv.TLSConfig != nil {
+				errc <- srv.ListenAndServeTLS("", "")
+			} else {
+				errc <- srv.ListenAndServe()
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-stop:
+		log.Println("shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if e := srv.Shutdown(ctx); e != nil && err == nil {
+			err = e
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
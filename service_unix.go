@@ -0,0 +1,196 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	systemdUnitPath = "/etc/systemd/system/" + serviceName + ".service"
+	launchdLabel    = "com." + serviceName
+	launchdPath     = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+	launchdLogPath  = "/usr/local/var/log/" + serviceName + ".log"
+)
+
+// installService installs a systemd unit (Linux) or a launchd daemon (macOS)
+// that runs this binary, and enables it at boot. On Linux the service runs
+// as the system user serviceName, created if need be, which is given read
+// access to the env file.
+func installService(envFile string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	switch runtime.GOOS {
+	case "linux":
+		if err := systemdUser(envFile); err != nil {
+			return err
+		}
+		if err := os.WriteFile(systemdUnitPath, []byte(systemdUnit(exe, envFile)), 0o644); err != nil {
+			return err
+		}
+		if err := run("systemctl", "daemon-reload"); err != nil {
+			return err
+		}
+		if err := run("systemctl", "enable", serviceName); err != nil {
+			return err
+		}
+		log.Printf("installed %s; start it with: systemctl start %s", systemdUnitPath, serviceName)
+	case "darwin":
+		if err := os.MkdirAll(filepath.Dir(launchdLogPath), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(launchdPath, []byte(launchdPlist(exe, envFile)), 0o644); err != nil {
+			return err
+		}
+		if err := run("launchctl", "load", "-w", launchdPath); err != nil {
+			return err
+		}
+		log.Printf("installed and started %s (log: %s)", launchdPath, launchdLogPath)
+	default:
+		return fmt.Errorf("install is not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+func uninstallService() error {
+	switch runtime.GOOS {
+	case "linux":
+		_ = run("systemctl", "disable", "--now", serviceName)
+		if err := os.Remove(systemdUnitPath); err != nil {
+			return err
+		}
+		return run("systemctl", "daemon-reload")
+	case "darwin":
+		_ = run("launchctl", "unload", "-w", launchdPath)
+		return os.Remove(launchdPath)
+	default:
+		return fmt.Errorf("uninstall is not supported on %s", runtime.GOOS)
+	}
+}
+
+// runService is only meaningful under the Windows service manager; systemd
+// and launchd run the binary in the foreground.
+func runService(servers ...*http.Server) error {
+	return errors.New(`the "service" command is only used on Windows`)
+}
+
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// systemdUser creates the system user and group the unit runs as, unless
+// they exist, and lets the group read envFile, which holds secrets.
+func systemdUser(envFile string) error {
+	if _, err := user.Lookup(serviceName); err != nil {
+		if err := run("useradd", "--system", "--user-group", "--no-create-home", "--home-dir", "/var/lib/"+serviceName, "--shell", "/usr/sbin/nologin", serviceName); err != nil {
+			return err
+		}
+	}
+	if envFile == "" {
+		return nil
+	}
+	g, err := user.LookupGroup(serviceName)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(envFile, 0, gid); err != nil {
+		return err
+	}
+	return os.Chmod(envFile, 0o640)
+}
+
+// systemdUnit runs exe as the system user serviceName, without a way to
+// gain privileges but allowed to bind ports below 1024, in its state
+// directory /var/lib/<serviceName>, where relative paths (e.g.
+// SCHEDULES_FILE) end up. The env file is passed with -env-file rather
+// than as EnvironmentFile, so that SIGHUP reloads it.
+func systemdUnit(exe, envFile string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[Unit]\nDescription=%s\nAfter=network-online.target\nWants=network-online.target\n\n", serviceDescription)
+	sb.WriteString("[Service]\n")
+	fmt.Fprintf(&sb, "ExecStart=%s", systemdQuote(exe))
+	if envFile != "" {
+		fmt.Fprintf(&sb, " -env-file %s", systemdQuote(envFile))
+	}
+	sb.WriteString("\nExecReload=/bin/kill -HUP $MAINPID\n")
+	fmt.Fprintf(&sb, "User=%s\nGroup=%s\nNoNewPrivileges=yes\nAmbientCapabilities=CAP_NET_BIND_SERVICE\n", serviceName, serviceName)
+	fmt.Fprintf(&sb, "StateDirectory=%s\nWorkingDirectory=/var/lib/%s\n", serviceName, serviceName)
+	sb.WriteString("Restart=on-failure\nKillSignal=SIGTERM\n")
+	fmt.Fprintf(&sb, "TimeoutStopSec=%d\n\n", int(shutdownTimeout.Seconds())+5)
+	sb.WriteString("[Install]\nWantedBy=multi-user.target\n")
+	return sb.String()
+}
+
+// systemdQuote quotes s as a word of a systemd command line.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if !strings.ContainsAny(s, " \t\"'\\$;") {
+		return s
+	}
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", "$$").Replace(s)
+	return `"` + s + `"`
+}
+
+func launchdPlist(exe, envFile string) string {
+	args := []string{exe}
+	if envFile != "" {
+		args = append(args, "-env-file", envFile)
+	}
+	var argXML strings.Builder
+	for _, a := range args {
+		argXML.WriteString("\t\t<string>" + html.EscapeString(a) + "</string>\n")
+	}
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + launchdLabel + `</string>
+	<key>ProgramArguments</key>
+	<array>
+` + argXML.String() + `	</array>
+	<key>WorkingDirectory</key>
+	<string>` + html.EscapeString(filepath.Dir(exe)) + `</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>` + launchdLogPath + `</string>
+	<key>StandardErrorPath</key>
+	<string>` + launchdLogPath + `</string>
+</dict>
+</plist>
+`
+}
+
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
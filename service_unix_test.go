@@ -0,0 +1,41 @@
+//go:build !windows
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSystemdUnit(t *testing.T) {
+	unit := systemdUnit("/opt/ai text/ai-text-tools", "/etc/ai-text-tools.env")
+	for _, want := range []string{
+		"ExecStart=\"/opt/ai text/ai-text-tools\" -env-file /etc/ai-text-tools.env\n",
+		"User=ai-text-tools\n",
+		"NoNewPrivileges=yes\n",
+		"WorkingDirectory=/var/lib/ai-text-tools\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("unit has no %q:\n%s", want, unit)
+		}
+	}
+	if strings.Contains(unit, "EnvironmentFile=") {
+		t.Errorf("the env file is loaded by systemd, so SIGHUP can't reload it:\n%s", unit)
+	}
+	if unit := systemdUnit("/usr/bin/ai-text-tools", ""); !strings.Contains(unit, "ExecStart=/usr/bin/ai-text-tools\n") {
+		t.Errorf("unit without an env file:\n%s", unit)
+	}
+}
+
+func TestSystemdQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"/usr/bin/ai-text-tools": "/usr/bin/ai-text-tools",
+		"/etc/50%.env":           "/etc/50%%.env",
+		`/srv/my "app"`:          `"/srv/my \"app\""`,
+		`/srv/a\b $HOME`:         `"/srv/a\\b $$HOME"`,
+	} {
+		if got := systemdQuote(in); got != want {
+			t.Errorf("systemdQuote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// The service control manager API is called through advapi32 directly to
+// keep the build dependency-free.
+
+var (
+	advapi32 = syscall.NewLazyDLL("advapi32.dll")
+
+	procOpenSCManagerW                = advapi32.NewProc("OpenSCManagerW")
+	procCreateServiceW                = advapi32.NewProc("CreateServiceW")
+	procOpenServiceW                  = advapi32.NewProc("OpenServiceW")
+	procDeleteService                 = advapi32.NewProc("DeleteService")
+	procControlService                = advapi32.NewProc("ControlService")
+	procCloseServiceHandle            = advapi32.NewProc("CloseServiceHandle")
+	procChangeServiceConfig2W         = advapi32.NewProc("ChangeServiceConfig2W")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+const (
+	scManagerAllAccess       = 0xF003F
+	serviceAllAccess         = 0xF01FF
+	serviceWin32OwnProcess   = 0x10
+	serviceAutoStart         = 2
+	serviceErrorNormal       = 1
+	serviceConfigDescription = 1
+
+	serviceStopped     = 1
+	serviceStopPending = 3
+	serviceRunning     = 4
+
+	serviceAcceptStop     = 1
+	serviceAcceptShutdown = 4
+
+	serviceControlStop     = 1
+	serviceControlShutdown = 5
+
+	errorServiceSpecific = 1066
+)
+
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+type serviceTableEntry struct {
+	ServiceName *uint16
+	ServiceProc uintptr
+}
+
+// installService registers this binary as an auto-start Windows service
+// running as LocalSystem.
+func installService(envFile string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmdLine := syscall.EscapeArg(exe) + " service"
+	if envFile != "" {
+		cmdLine += " -env-file " + syscall.EscapeArg(envFile)
+	}
+
+	scm, err := openSCManager()
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(scm)
+
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	display, _ := syscall.UTF16PtrFromString(serviceDisplayName)
+	binPath, _ := syscall.UTF16PtrFromString(cmdLine)
+	h, _, err := procCreateServiceW.Call(scm,
+		uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(display)),
+		serviceAllAccess, serviceWin32OwnProcess, serviceAutoStart, serviceErrorNormal,
+		uintptr(unsafe.Pointer(binPath)), 0, 0, 0, 0, 0)
+	if h == 0 {
+		return fmt.Errorf("create service: %w", err)
+	}
+	defer procCloseServiceHandle.Call(h)
+
+	desc, _ := syscall.UTF16PtrFromString(serviceDescription)
+	info := struct{ Description *uint16 }{desc}
+	if r, _, err := procChangeServiceConfig2W.Call(h, serviceConfigDescription, uintptr(unsafe.Pointer(&info))); r == 0 {
+		return fmt.Errorf("set service description: %w", err)
+	}
+	log.Printf("installed service %q; start it with: sc start %s", serviceName, serviceName)
+	return nil
+}
+
+func uninstallService() error {
+	scm, err := openSCManager()
+	if err != nil {
+		return err
+	}
+	defer procCloseServiceHandle.Call(scm)
+
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	h, _, err := procOpenServiceW.Call(scm, uintptr(unsafe.Pointer(name)), serviceAllAccess)
+	if h == 0 {
+		return fmt.Errorf("open service: %w", err)
+	}
+	defer procCloseServiceHandle.Call(h)
+
+	var st serviceStatus
+	procControlService.Call(h, serviceControlStop, uintptr(unsafe.Pointer(&st))) // fails if not running
+	if r, _, err := procDeleteService.Call(h); r == 0 {
+		return fmt.Errorf("delete service: %w", err)
+	}
+	return nil
+}
+
+func openSCManager() (uintptr, error) {
+	h, _, err := procOpenSCManagerW.Call(0, 0, scManagerAllAccess)
+	if h == 0 {
+		return 0, fmt.Errorf("open service manager (run as Administrator): %w", err)
+	}
+	return h, nil
+}
+
+// runService runs the servers under the service control manager until it
+// sends stop or shutdown.
+func runService(servers ...*http.Server) error {
+	name, _ := syscall.UTF16PtrFromString(serviceName)
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	done := make(chan error, 1)
+	var statusHandle uintptr
+
+	setStatus := func(state, accepts uint32, err error) {
+		st := serviceStatus{ServiceType: serviceWin32OwnProcess, CurrentState: state, ControlsAccepted: accepts}
+		if err != nil {
+			st.Win32ExitCode, st.ServiceSpecificExitCode = errorServiceSpecific, 1
+		}
+		procSetServiceStatus.Call(statusHandle, uintptr(unsafe.Pointer(&st)))
+	}
+
+	handler := syscall.NewCallback(func(control, eventType uint32, eventData, context uintptr) uintptr {
+		switch control {
+		case serviceControlStop, serviceControlShutdown:
+			setStatus(serviceStopPending, 0, nil)
+			stopOnce.Do(func() { close(stop) })
+		}
+		return 0
+	})
+	serviceMain := syscall.NewCallback(func(argc uint32, argv uintptr) uintptr {
+		statusHandle, _, _ = procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(name)), handler, 0)
+		setStatus(serviceRunning, serviceAcceptStop|serviceAcceptShutdown, nil)
+		err := serve(stop, servers...)
+		setStatus(serviceStopped, 0, err)
+		done <- err
+		return 0
+	})
+
+	table := []serviceTableEntry{{name, serviceMain}, {nil, 0}}
+	if r, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); r == 0 {
+		return fmt.Errorf("service dispatcher (the \"service\" command is for the service manager only): %w", err)
+	}
+	return <-done
+}
+
+func processRunning(pid int) bool {
+	const processQueryLimitedInformation = 0x1000
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	const stillActive = 259
+	return syscall.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Sessions ---
+//
+// POST /sessions starts a conversation. Operations called with its
+// "session_id" send the earlier turns of the session to the model as chat
+// context and add their own, so that POST /sessions/<id>/messages can
+// follow up with "make it shorter" or "now in Spanish" instead of starting
+// over. Sessions are kept in memory, for SESSION_TTL (default 24h) after
+// their last use.
+
+const (
+	// maxSessionTurns caps the turns sent as context, oldest dropped
+	// first; each turn is a prompt and its output.
+	maxSessionTurns = 10
+
+	maxSessions = 10000
+)
+
+// Session is a conversation with the model.
+type Session struct {
+	ID        string        `json:"id"`
+	User      string        `json:"-"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	Turns     []SessionTurn `json:"turns"`
+}
+
+// SessionTurn is one exchange with the model.
+type SessionTurn struct {
+	Operation string    `json:"operation,omitempty"` // the prompt template, empty for messages
+	Prompt    string    `json:"prompt"`
+	Output    string    `json:"output"`
+	At        time.Time `json:"at"`
+}
+
+// SessionStore holds the live sessions.
+type SessionStore struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	return &SessionStore{ttl: ttl, sessions: map[string]*Session{}}
+}
+
+// Create starts a session for user.
+func (s *SessionStore) Create(user string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	if len(s.sessions) >= maxSessions {
+		return nil, false
+	}
+	now := time.Now().UTC()
+	sess := &Session{ID: newID(), User: user, CreatedAt: now, UpdatedAt: now, Turns: []SessionTurn{}}
+	s.sessions[sess.ID] = sess
+	return sess, true
+}
+
+// Get returns a copy of user's session id.
+func (s *SessionStore) Get(user, id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.liveLocked(user, id)
+	if !ok {
+		return Session{}, false
+	}
+	c := *sess
+	c.Turns = append([]SessionTurn{}, sess.Turns...)
+	return c, true
+}
+
+func (s *SessionStore) Delete(user, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.liveLocked(user, id); !ok {
+		return false
+	}
+	delete(s.sessions, id)
+	return true
+}
+
+// context returns the last turns of a session as chat messages.
+func (s *SessionStore) context(id string) []texttools.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	turns := sess.Turns
+	if len(turns) > maxSessionTurns {
+		turns = turns[len(turns)-maxSessionTurns:]
+	}
+	var msgs []texttools.Message
+	for _, t := range turns {
+		msgs = append(msgs, texttools.Message{Role: "user", Content: t.Prompt}, texttools.Message{Role: "assistant", Content: t.Output})
+	}
+	return msgs
+}
+
+func (s *SessionStore) add(id string, t SessionTurn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.sessions[id]; ok {
+		sess.Turns = append(sess.Turns, t)
+		sess.UpdatedAt = t.At
+	}
+}
+
+func (s *SessionStore) liveLocked(user, id string) (*Session, bool) {
+	sess, ok := s.sessions[id]
+	if !ok || sess.User != user {
+		return nil, false
+	}
+	if time.Since(sess.UpdatedAt) > s.ttl {
+		delete(s.sessions, id)
+		return nil, false
+	}
+	return sess, true
+}
+
+func (s *SessionStore) pruneLocked() {
+	for id, sess := range s.sessions {
+		if time.Since(sess.UpdatedAt) > s.ttl {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// --- Sessions on the request context ---
+
+type sessionKey struct{}
+
+// sessionFrom returns the ID of the session a request runs in, if any.
+func sessionFrom(ctx context.Context) string {
+	id, _ := ctx.Value(sessionKey{}).(string)
+	return id
+}
+
+// withSession runs JSON requests with a "session_id" in that session,
+// rejecting unknown (or expired) sessions with a 404. The body is read
+// ahead here and handed on unchanged.
+func withSession(sessions *SessionStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Body == nil || strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// a body over the limit is left for the handler to reject
+		b, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+		if err != nil || int64(len(b)) > maxBodyBytes {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var ref struct {
+			SessionID string `json:"session_id"`
+		}
+		if json.Unmarshal(b, &ref) != nil || ref.SessionID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if refusePrivate(w, r, "sessions") {
+			return
+		}
+		if _, ok := sessions.Get(userID(r), ref.SessionID); !ok {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, ref.SessionID)))
+	})
+}
+
+// sessionProvider sends the earlier turns of the request's session along
+// with each prompt and adds the exchange to the session. Debug echo
+// requests, which don't reach the model, are not added.
+type sessionProvider struct {
+	texttools.StreamProvider
+	sessions *SessionStore
+}
+
+func (p sessionProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	id := sessionFrom(ctx)
+	if id == "" {
+		return p.StreamProvider.Complete(ctx, req)
+	}
+	req.History = append(p.sessions.context(id), req.History...)
+	c, err := p.StreamProvider.Complete(ctx, req)
+	if err == nil && !debugEcho(ctx) && !dryRun(ctx) {
+		p.sessions.add(id, SessionTurn{Operation: req.Operation, Prompt: req.Prompt, Output: c.Text, At: time.Now().UTC()})
+	}
+	return c, err
+}
+
+func (p sessionProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	id := sessionFrom(ctx)
+	if id == "" {
+		return p.StreamProvider.Stream(ctx, req, onDelta)
+	}
+	req.History = append(p.sessions.context(id), req.History...)
+	c, err := p.StreamProvider.Stream(ctx, req, onDelta)
+	if err == nil && !debugEcho(ctx) && !dryRun(ctx) {
+		p.sessions.add(id, SessionTurn{Operation: req.Operation, Prompt: req.Prompt, Output: c.Text, At: time.Now().UTC()})
+	}
+	return c, err
+}
+
+// --- Session handlers ---
+
+// SessionMessageRequest is a follow-up in a session.
+type SessionMessageRequest struct {
+	Message string `json:"message"` // e.g. "make it shorter"
+	Options
+}
+
+type SessionMessageResponse struct {
+	Text string `json:"text"`
+}
+
+// sessionsHandler serves POST /sessions, GET and DELETE /sessions/<id> and
+// POST /sessions/<id>/messages.
+func sessionsHandler(sessions *SessionStore, tools *texttools.Tools, prefs *PreferenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/sessions"), "/")
+		if r.Method == http.MethodPost && refusePrivate(w, r, "sessions") {
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodPost && id == "":
+			sess, ok := sessions.Create(userID(r))
+			if !ok {
+				http.Error(w, "too many sessions", http.StatusServiceUnavailable)
+				return
+			}
+			writeJSON(w, http.StatusCreated, sess)
+		case r.Method == http.MethodPost && strings.HasSuffix(id, "/messages"):
+			id = strings.TrimSuffix(id, "/messages")
+			if _, ok := sessions.Get(userID(r), id); !ok {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			sessionMessage(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, id)), tools, prefs)
+		case r.Method == http.MethodGet && id != "":
+			sess, ok := sessions.Get(userID(r), id)
+			if !ok {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, sess)
+		case r.Method == http.MethodDelete && id != "":
+			if !sessions.Delete(userID(r), id) {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// sessionMessage sends a follow-up to the model, with the session as context.
+func sessionMessage(w http.ResponseWriter, r *http.Request, tools *texttools.Tools, prefs *PreferenceStore) {
+	var req SessionMessageRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		http.Error(w, "`message` is required", http.StatusBadRequest)
+		return
+	}
+	if !checkText(w, req.Message) {
+		return
+	}
+	if err := req.Options.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	prefs.For(r).apply(&req.Options)
+
+	out, err := tools.Complete(r.Context(), req.Message, req.Options.Options)
+	if err != nil {
+		log.Println("session error:", err)
+		writeLLMError(w, err)
+		return
+	}
+	out = tools.FitWords(r.Context(), strings.TrimSpace(out), req.Options.Options)
+	writeJSON(w, http.StatusOK, SessionMessageResponse{Text: texttools.FormatOutput(out, req.OutputFormat)})
+}
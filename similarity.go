@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Similarity ---
+//
+// POST /similarity compares a source with its rewrite, for editors checking
+// whether the rewrite diverged enough: the cosine similarity of their
+// embeddings, the overlap of their word n-grams, and the pairs of sentences
+// that are the most alike. Without an embedding model, only the n-gram
+// measures are computed and the passages are ranked by overlap.
+
+const (
+	defaultSimilarityNGram    = 3
+	maxSimilarityNGram        = 8
+	defaultSimilarityPassages = 5
+	maxSimilarityPassages     = 50
+	// maxSimilaritySentences caps the sentences embedded per text; past it
+	// the passages are ranked by n-gram overlap only.
+	maxSimilaritySentences = 200
+)
+
+// SimilarityRequest is the POST /similarity body.
+type SimilarityRequest struct {
+	Source   string `json:"source"`
+	Rewrite  string `json:"rewrite"`
+	NGram    int    `json:"ngram,omitempty"`    // words per n-gram, default 3
+	Passages int    `json:"passages,omitempty"` // how many passages to return, default 5
+}
+
+// SimilarityResponse is the result of /similarity. Scores are from 0 (no
+// similarity) to 1 (identical).
+type SimilarityResponse struct {
+	Cosine       *float64         `json:"cosine,omitempty"` // of the embeddings; absent without an embedding model
+	NGram        int              `json:"ngram"`
+	NGramOverlap float64          `json:"ngram_overlap"` // Jaccard index of the n-gram sets
+	Containment  float64          `json:"containment"`   // share of the rewrite's n-grams found in the source
+	Passages     []SimilarPassage `json:"passages"`      // most similar first
+}
+
+// SimilarPassage pairs a sentence of the rewrite with the most similar
+// sentence of the source. Indexes count sentences from 0.
+type SimilarPassage struct {
+	Source       string   `json:"source"`
+	SourceIndex  int      `json:"source_index"`
+	Rewrite      string   `json:"rewrite"`
+	RewriteIndex int      `json:"rewrite_index"`
+	Cosine       *float64 `json:"cosine,omitempty"`
+	NGramOverlap float64  `json:"ngram_overlap"`
+}
+
+// ngrams returns the set of word n-grams of text, lowercased and without
+// punctuation. A text shorter than n words is a single n-gram.
+func ngrams(text string, n int) map[string]bool {
+	words := statsWords(strings.ToLower(text))
+	set := map[string]bool{}
+	if len(words) == 0 {
+		return set
+	}
+	if len(words) < n {
+		set[strings.Join(words, " ")] = true
+		return set
+	}
+	for i := 0; i+n <= len(words); i++ {
+		set[strings.Join(words[i:i+n], " ")] = true
+	}
+	return set
+}
+
+// overlap returns the Jaccard index of a and b, and the share of b in a.
+func overlap(a, b map[string]bool) (jaccard, containment float64) {
+	shared := 0
+	for g := range b {
+		if a[g] {
+			shared++
+		}
+	}
+	if union := len(a) + len(b) - shared; union > 0 {
+		jaccard = float64(shared) / float64(union)
+	}
+	if len(b) > 0 {
+		containment = float64(shared) / float64(len(b))
+	}
+	return jaccard, containment
+}
+
+func round3(x float64) float64 {
+	return math.Round(x*1000) / 1000
+}
+
+// similarPassages pairs each sentence of rewrite with the most similar one
+// of source, by cosine if vectors are given (one per sentence, source's
+// first) and by n-gram overlap otherwise, and returns the limit best pairs.
+func similarPassages(source, rewrite []string, vectors [][]float32, n, limit int) []SimilarPassage {
+	if len(source) == 0 {
+		return []SimilarPassage{}
+	}
+	srcGrams := make([]map[string]bool, len(source))
+	for i, s := range source {
+		srcGrams[i] = ngrams(s, n)
+	}
+	var out []SimilarPassage
+	var scores []float64
+	for j, r := range rewrite {
+		grams := ngrams(r, n)
+		best, bestScore := SimilarPassage{}, -1.0
+		for i, s := range source {
+			p := SimilarPassage{Source: s, SourceIndex: i, Rewrite: r, RewriteIndex: j}
+			p.NGramOverlap, _ = overlap(srcGrams[i], grams)
+			score := p.NGramOverlap
+			if vectors != nil {
+				c := cosine(vectors[i], vectors[len(source)+j])
+				score = c
+				c = round3(c)
+				p.Cosine = &c
+			}
+			if score > bestScore {
+				best, bestScore = p, score
+			}
+		}
+		best.NGramOverlap = round3(best.NGramOverlap)
+		out = append(out, best)
+		scores = append(scores, bestScore)
+	}
+	idx := make([]int, len(out))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool { return scores[idx[a]] > scores[idx[b]] })
+	passages := make([]SimilarPassage, 0, min(limit, len(idx)))
+	for _, i := range idx[:min(limit, len(idx))] {
+		passages = append(passages, out[i])
+	}
+	return passages
+}
+
+// similarityHandler serves POST /similarity. embedder may be nil.
+func similarityHandler(embedder texttools.Embedder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req SimilarityRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if strings.TrimSpace(req.Source) == "" || strings.TrimSpace(req.Rewrite) == "" {
+			http.Error(w, "`source` and `rewrite` are required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Source) || !checkText(w, req.Rewrite) {
+			return
+		}
+		if req.NGram == 0 {
+			req.NGram = defaultSimilarityNGram
+		}
+		if req.NGram < 1 || req.NGram > maxSimilarityNGram {
+			http.Error(w, fmt.Sprintf("`ngram` must be between 1 and %d", maxSimilarityNGram), http.StatusBadRequest)
+			return
+		}
+		if req.Passages == 0 {
+			req.Passages = defaultSimilarityPassages
+		}
+		if req.Passages < 1 || req.Passages > maxSimilarityPassages {
+			http.Error(w, fmt.Sprintf("`passages` must be between 1 and %d", maxSimilarityPassages), http.StatusBadRequest)
+			return
+		}
+
+		resp := SimilarityResponse{NGram: req.NGram}
+		j, c := overlap(ngrams(req.Source, req.NGram), ngrams(req.Rewrite, req.NGram))
+		resp.NGramOverlap, resp.Containment = round3(j), round3(c)
+
+		source, rewrite := splitSentences(req.Source), splitSentences(req.Rewrite)
+		var sentenceVectors [][]float32
+		if embedder != nil {
+			texts := []string{req.Source, req.Rewrite}
+			perSentence := len(source) <= maxSimilaritySentences && len(rewrite) <= maxSimilaritySentences
+			if perSentence {
+				texts = append(append(texts, source...), rewrite...)
+			}
+			vectors, err := embed(r.Context(), embedder, texts)
+			if err != nil {
+				log.Println("similarity error:", err)
+				writeLLMError(w, err)
+				return
+			}
+			cos := round3(cosine(vectors[0], vectors[1]))
+			resp.Cosine = &cos
+			if perSentence {
+				sentenceVectors = vectors[2:]
+			}
+		}
+		resp.Passages = similarPassages(source, rewrite, sentenceVectors, req.NGram, req.Passages)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
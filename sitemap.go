@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Sitemap jobs ---
+//
+// A "sitemap" job fetches every page listed in a sitemap.xml (or an explicit
+// list of URLs), extracts its text and metadata, optionally runs an
+// operation on it, and produces a consolidated audit report.
+
+const (
+	maxSitemapURLs = 500
+	maxPageSize    = 5 << 20 // 5 MB
+)
+
+// fetchClient fetches the pages of sitemaps, feeds, schedules and Slack
+// links. Only public addresses are dialed (see fetchControl), redirects
+// included, unless FETCH_ALLOW_PRIVATE=true; it doesn't use a proxy, which
+// would dial for it.
+var fetchClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 10 * time.Second, Control: fetchControl}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	},
+}
+
+// nonPublicPrefixes are ranges that netip doesn't count as private but
+// that aren't public either.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT, incl. Alibaba Cloud's metadata endpoint
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+}
+
+// fetchControl refuses to connect to loopback, private, link-local
+// (169.254.169.254 and other metadata endpoints), multicast and unspecified
+// addresses. It runs on the resolved address, so a public name that
+// resolves to a private address is refused too.
+func fetchControl(network, address string, _ syscall.RawConn) error {
+	if envBool("FETCH_ALLOW_PRIVATE") {
+		return nil
+	}
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	ip := ap.Addr().Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("fetching from %s is not allowed", ip)
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(ip) {
+			return fmt.Errorf("fetching from %s is not allowed", ip)
+		}
+	}
+	return nil
+}
+
+type SitemapJobRequest struct {
+	Sitemap   string   `json:"sitemap"` // URL of a sitemap.xml (sitemap indexes are followed)
+	URLs      []string `json:"urls"`
+	Operation string   `json:"operation"` // optional built-in operation to run on each page
+	Tone      string   `json:"tone"`
+	Options
+}
+
+type PageReport struct {
+	URL         string      `json:"url"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	Words       int         `json:"words"`
+	Result      interface{} `json:"result,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+type SitemapReport struct {
+	Operation string       `json:"operation,omitempty"`
+	Pages     []PageReport `json:"pages"`
+	Summary   struct {
+		Total              int                 `json:"total"`
+		Succeeded          int                 `json:"succeeded"`
+		Failed             int                 `json:"failed"`
+		MissingTitle       []string            `json:"missing_title"`
+		MissingDescription []string            `json:"missing_description"`
+		DuplicateTitles    map[string][]string `json:"duplicate_titles"`
+	} `json:"summary"`
+}
+
+// parseSitemapJob validates the request body and returns it with the
+// user's preferences applied, as the job's payload.
+func parseSitemapJob(prefs Preferences, body []byte) (SitemapJobRequest, error) {
+	var req SitemapJobRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return req, errors.New("invalid JSON body")
+	}
+	if req.Sitemap == "" && len(req.URLs) == 0 {
+		return req, errors.New("`sitemap` or `urls` is required")
+	}
+	if req.Operation != "" && !isBuiltinOperation(req.Operation) {
+		return req, errors.New("unknown operation")
+	}
+	if err := req.Options.validate(); err != nil {
+		return req, err
+	}
+	if len(req.URLs) > maxSitemapURLs {
+		return req, fmt.Errorf("at most %d urls per job", maxSitemapURLs)
+	}
+	for _, u := range append([]string{req.Sitemap}, req.URLs...) {
+		if u != "" && !isHTTPURL(u) {
+			return req, fmt.Errorf("invalid URL %q", u)
+		}
+	}
+	prefs.apply(&req.Options)
+	if req.Tone == "" {
+		req.Tone = prefs.Tone
+	}
+	return req, nil
+}
+
+// sitemapJobRunner runs sitemap jobs.
+func sitemapJobRunner(tools *texttools.Tools) JobRunner {
+	return func(_ string, payload json.RawMessage) (func(p *JobProgress) (interface{}, error), error) {
+		var req SitemapJobRequest
+		if err := json.Unmarshal(payload, &req); err != nil {
+			return nil, err
+		}
+		return sitemapJob(tools, req), nil
+	}
+}
+
+func sitemapJob(tools *texttools.Tools, req SitemapJobRequest) func(p *JobProgress) (interface{}, error) {
+	return func(p *JobProgress) (interface{}, error) {
+		urls := req.URLs
+		if req.Sitemap != "" {
+			found, err := fetchSitemap(req.Sitemap, 0)
+			if err != nil {
+				return nil, fmt.Errorf("sitemap: %w", err)
+			}
+			urls = append(urls, found...)
+		}
+		urls = dedupe(urls)
+		if len(urls) > maxSitemapURLs {
+			urls = urls[:maxSitemapURLs]
+		}
+		p.SetTotal(len(urls))
+
+		report := &SitemapReport{Operation: req.Operation}
+		for _, u := range urls {
+			report.Pages = append(report.Pages, processPage(tools, req, u))
+			p.Step()
+		}
+		report.summarize()
+		return report, nil
+	}
+}
+
+func processPage(tools *texttools.Tools, req SitemapJobRequest, u string) PageReport {
+	page := PageReport{URL: u}
+	data, err := fetchURL(u)
+	if err != nil {
+		page.Error = err.Error()
+		return page
+	}
+	text, title, desc := texttools.HTMLText(data)
+	page.Title, page.Description, page.Words = title, desc, len(strings.Fields(text))
+	if req.Operation == "" || text == "" {
+		return page
+	}
+	if err := validateText(text); err != nil {
+		page.Error = err.Error()
+		return page
+	}
+
+	result, err := runOperation(batchContext(context.Background()), tools, req.Operation, RewriteRequest{Text: text, Tone: req.Tone, Options: req.Options})
+	if err != nil {
+		page.Error = "LLM error: " + err.Error()
+		return page
+	}
+	page.Result = result
+	return page
+}
+
+func (r *SitemapReport) summarize() {
+	s := &r.Summary
+	s.Total = len(r.Pages)
+	s.MissingTitle = []string{}
+	s.MissingDescription = []string{}
+	s.DuplicateTitles = map[string][]string{}
+
+	byTitle := map[string][]string{}
+	for _, p := range r.Pages {
+		if p.Error != "" {
+			s.Failed++
+		} else {
+			s.Succeeded++
+		}
+		if p.Error != "" && p.Words == 0 {
+			continue // page never loaded; nothing to audit
+		}
+		if p.Title == "" {
+			s.MissingTitle = append(s.MissingTitle, p.URL)
+		} else {
+			byTitle[p.Title] = append(byTitle[p.Title], p.URL)
+		}
+		if p.Description == "" {
+			s.MissingDescription = append(s.MissingDescription, p.URL)
+		}
+	}
+	for title, urls := range byTitle {
+		if len(urls) > 1 {
+			s.DuplicateTitles[title] = urls
+		}
+	}
+}
+
+// fetchSitemap returns the page URLs of a sitemap, following sitemap index
+// files up to two levels deep.
+func fetchSitemap(u string, depth int) ([]string, error) {
+	data, err := fetchURL(u)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(io.LimitReader(zr, maxPageSize)); err != nil {
+			return nil, err
+		}
+	}
+
+	var doc struct {
+		XMLName  xml.Name
+		URLs     []string `xml:"url>loc"`
+		Sitemaps []string `xml:"sitemap>loc"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid sitemap XML: %w", err)
+	}
+
+	urls := trimAll(doc.URLs)
+	if depth < 2 {
+		for _, child := range trimAll(doc.Sitemaps) {
+			if len(urls) >= maxSitemapURLs {
+				break
+			}
+			found, err := fetchSitemap(child, depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", child, err)
+			}
+			urls = append(urls, found...)
+		}
+	}
+	return urls, nil
+}
+
+func fetchURL(u string) ([]byte, error) {
+	if !isHTTPURL(u) {
+		return nil, fmt.Errorf("invalid URL %q", u)
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "ai-text-tools/1.0")
+
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func trimAll(ss []string) []string {
+	out := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func dedupe(ss []string) []string {
+	seen := make(map[string]bool, len(ss))
+	out := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
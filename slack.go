@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Slack ---
+//
+// POST /integrations/slack serves Slack slash commands: create a Slack app
+// with a command such as /summarize pointing at
+// https://<host>/api/v1/integrations/slack, and set SLACK_SIGNING_SECRET to
+// the app's signing secret. The command's name is the operation (summarize,
+// keywords, rewrite, questions, titles, expand, to-bullets or to-prose) and
+// its text the input; a link alone is fetched and its page's text used
+// instead.
+//
+// Slack wants an answer within 3 seconds, so the command is acknowledged at
+// once (visible to the caller only) and the result is posted in the
+// channel through the command's response_url when it's ready.
+
+const (
+	// slackMaxSkew is how old a request's timestamp may be, against replays.
+	slackMaxSkew = 5 * time.Minute
+	// slackTimeout bounds the operation and the fetch of a link.
+	slackTimeout = 2 * time.Minute
+)
+
+// slackOperations are the operations that can be slash commands.
+var slackOperations = []string{"summarize", "keywords", "rewrite", "questions", "titles", "expand", "to-bullets", "to-prose"}
+
+// slackLinkOnlyRe matches a text that is just a link, as Slack may send it:
+// <https://example.com> or <https://example.com|example.com>.
+var slackLinkOnlyRe = regexp.MustCompile(`^<(https?://[^|>]+)(?:\|[^>]*)?>$`)
+
+// SlackMessage is a message posted to Slack.
+type SlackMessage struct {
+	ResponseType string `json:"response_type"` // in_channel or ephemeral (the caller only)
+	Text         string `json:"text"`
+}
+
+var slackClient = &http.Client{Timeout: 10 * time.Second}
+
+// slackAPIKey is the tenant key of slash commands (SLACK_API_KEY), set in
+// main.
+var slackAPIKey string
+
+// verifySlackRequest checks the signature of a request from Slack:
+// X-Slack-Signature is v0= and the hex HMAC-SHA256, keyed with the signing
+// secret, of "v0:<X-Slack-Request-Timestamp>:<body>".
+func verifySlackRequest(secret string, h http.Header, body []byte, now time.Time) error {
+	ts, err := strconv.ParseInt(h.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid X-Slack-Request-Timestamp")
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		return fmt.Errorf("request timestamp too far from now")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%d:", ts)
+	mac.Write(body)
+	want := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(h.Get("X-Slack-Signature")), []byte(want)) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// slackHandler serves POST /integrations/slack.
+func slackHandler(tools *texttools.Tools, secret string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" {
+			http.Error(w, "the Slack integration is not configured (SLACK_SIGNING_SECRET)", http.StatusNotFound)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err := verifySlackRequest(secret, r.Header, body, time.Now()); err != nil {
+			log.Println("slack error:", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			http.Error(w, "invalid form body", http.StatusBadRequest)
+			return
+		}
+
+		command := form.Get("command")
+		op := strings.TrimPrefix(command, "/")
+		text := strings.TrimSpace(form.Get("text"))
+		var known bool
+		for _, o := range slackOperations {
+			known = known || o == op
+		}
+		switch {
+		case !known:
+			writeJSON(w, http.StatusOK, SlackMessage{ResponseType: "ephemeral",
+				Text: fmt.Sprintf("Unknown command %s. Name the command after an operation: %s.", command, strings.Join(slackOperations, ", "))})
+			return
+		case text == "":
+			writeJSON(w, http.StatusOK, SlackMessage{ResponseType: "ephemeral", Text: fmt.Sprintf("Usage: %s <text or link>", command)})
+			return
+		case !isHTTPURL(form.Get("response_url")):
+			http.Error(w, "missing response_url", http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("slack: %s from %s in %s", command, form.Get("user_name"), form.Get("channel_name"))
+		// The command outlives the request, but keeps its tenant, privacy
+		// mode and priority, so it's admitted and counted like any other.
+		go runSlackCommand(context.WithoutCancel(r.Context()), tools, op, text, form.Get("user_id"), form.Get("response_url"))
+		writeJSON(w, http.StatusOK, SlackMessage{ResponseType: "ephemeral", Text: fmt.Sprintf("Working on it: %s…", command)})
+	}
+}
+
+// runSlackCommand runs an operation and posts its result, or the error, to
+// responseURL.
+func runSlackCommand(ctx context.Context, tools *texttools.Tools, op, text, user, responseURL string) {
+	ctx, cancel := context.WithTimeout(ctx, slackTimeout)
+	defer cancel()
+	msg, err := slackResult(ctx, tools, op, text)
+	if slot, ok := tenantFrom(ctx); ok && errors.Is(err, errTenantDenied) {
+		if denied := slot.get(); denied != nil {
+			err = errors.New(denied.Message)
+		}
+	}
+	if err != nil {
+		log.Printf("slack: %s error: %v", op, err)
+		msg = SlackMessage{ResponseType: "ephemeral", Text: fmt.Sprintf("Sorry, %s failed: %v", op, err)}
+	} else if user != "" {
+		msg.Text = fmt.Sprintf("<@%s> /%s\n%s", user, op, msg.Text)
+	}
+	if err := postSlackMessage(ctx, responseURL, msg); err != nil {
+		log.Println("slack error:", err)
+	}
+}
+
+func slackResult(ctx context.Context, tools *texttools.Tools, op, text string) (SlackMessage, error) {
+	source := ""
+	if m := slackLinkOnlyRe.FindStringSubmatch(text); m != nil {
+		text = m[1]
+	}
+	if isHTTPURL(text) {
+		source = text
+		data, err := fetchURL(text)
+		if err != nil {
+			return SlackMessage{}, fmt.Errorf("fetching %s: %w", source, err)
+		}
+		var title string
+		text, title, _ = texttools.HTMLText(data)
+		if title != "" {
+			source = fmt.Sprintf("<%s|%s>", source, title)
+		}
+		if strings.TrimSpace(text) == "" {
+			return SlackMessage{}, fmt.Errorf("no text found at %s", source)
+		}
+	}
+	if err := validateText(text); err != nil {
+		return SlackMessage{}, err
+	}
+
+	result, err := runOperation(ctx, tools, op, RewriteRequest{Text: text})
+	if err != nil {
+		return SlackMessage{}, err
+	}
+	out := plainResult(result)
+	if op == "questions" || op == "titles" {
+		out = "• " + strings.ReplaceAll(out, "\n", "\n• ")
+	}
+	if source != "" {
+		out = source + "\n" + out
+	}
+	return SlackMessage{ResponseType: "in_channel", Text: slackMarkdown(out)}, nil
+}
+
+var (
+	mdBoldRe   = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	mdBulletRe = regexp.MustCompile(`(?m)^(\s*)[-*] `)
+	mdLinkRe   = regexp.MustCompile(`\[([^\]\n]+)\]\((https?://[^)\s]+)\)`)
+)
+
+// slackMarkdown converts the model's Markdown to Slack's mrkdwn.
+func slackMarkdown(s string) string {
+	s = mdBulletRe.ReplaceAllString(s, "$1• ")
+	s = mdBoldRe.ReplaceAllString(s, "*$1*")
+	return mdLinkRe.ReplaceAllString(s, "<$2|$1>")
+}
+
+func postSlackMessage(ctx context.Context, responseURL string, msg SlackMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responseURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := slackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("posting to response_url: status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
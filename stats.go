@@ -0,0 +1,202 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Text statistics ---
+//
+// POST /stats computes counts and readability scores of a text on the
+// server, without calling the LLM, so the UI can show them while the user
+// types. Syllables and passive voice are estimated with English heuristics:
+// the scores are meaningful for English text only.
+
+// longSentenceWords is the length from which a sentence counts as long.
+const longSentenceWords = 25
+
+// StatsRequest is the POST /stats body.
+type StatsRequest struct {
+	Text        string `json:"text"`
+	InputFormat string `json:"input_format,omitempty"` // plain, markdown or html
+}
+
+// TextStats are the statistics of a text.
+type TextStats struct {
+	Characters         int     `json:"characters"`
+	CharactersNoSpaces int     `json:"characters_no_spaces"`
+	Words              int     `json:"words"`
+	Sentences          int     `json:"sentences"`
+	Paragraphs         int     `json:"paragraphs"`
+	Syllables          int     `json:"syllables"`
+	PolysyllabicWords  int     `json:"polysyllabic_words"` // of 3+ syllables
+	LongSentences      int     `json:"long_sentences"`     // of longSentenceWords words or more
+	WordsPerSentence   float64 `json:"words_per_sentence"`
+	SyllablesPerWord   float64 `json:"syllables_per_word"`
+	ReadingTimeSeconds int     `json:"reading_time_seconds"`
+	ReadingTimeWPM     int     `json:"reading_time_wpm"`     // the reading speed assumed (READING_WPM)
+	FleschReadingEase  float64 `json:"flesch_reading_ease"`  // 0–100, higher is easier
+	FleschKincaidGrade float64 `json:"flesch_kincaid_grade"` // US school grade
+	SMOGIndex          float64 `json:"smog_index"`           // US school grade, from the polysyllabic words
+	LexicalDiversity   float64 `json:"lexical_diversity"`    // distinct words / words
+	PassiveSentences   int     `json:"passive_sentences"`    // estimated
+	PassiveVoiceRatio  float64 `json:"passive_voice_ratio"`  // passive sentences / sentences
+}
+
+var (
+	silentEndingRe = regexp.MustCompile(`(?:[^laeiouy]es|ed|[^laeiouy]e)$`)
+	vowelGroupRe   = regexp.MustCompile(`[aeiouy]{1,2}`)
+)
+
+// syllables estimates the syllables of an English word.
+func syllables(word string) int {
+	word = strings.ToLower(word)
+	if len(word) <= 3 {
+		return 1
+	}
+	word = silentEndingRe.ReplaceAllString(word, "")
+	word = strings.TrimPrefix(word, "y")
+	return max(len(vowelGroupRe.FindAllString(word, -1)), 1)
+}
+
+// statsWords returns the words of a sentence, without surrounding
+// punctuation; tokens without letters or digits aren't words.
+func statsWords(s string) []string {
+	var words []string
+	for _, f := range strings.Fields(s) {
+		w := strings.TrimFunc(f, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// beVerbs are the forms of "to be" (and "to get") that make the passive.
+var beVerbs = map[string]bool{
+	"am": true, "is": true, "are": true, "was": true, "were": true, "be": true, "been": true, "being": true,
+	"get": true, "gets": true, "got": true, "gotten": true, "getting": true,
+	"isn't": true, "aren't": true, "wasn't": true, "weren't": true,
+}
+
+// irregularParticiples are common past participles not ending in -ed.
+var irregularParticiples = map[string]bool{
+	"awoken": true, "been": true, "beaten": true, "become": true, "begun": true, "bent": true, "bound": true,
+	"bitten": true, "blown": true, "broken": true, "brought": true, "built": true, "bought": true,
+	"caught": true, "chosen": true, "done": true, "drawn": true, "driven": true, "eaten": true,
+	"fallen": true, "fed": true, "felt": true, "found": true, "forbidden": true, "forgotten": true,
+	"forgiven": true, "frozen": true, "given": true, "gone": true, "grown": true, "heard": true,
+	"held": true, "hidden": true, "hit": true, "hurt": true, "kept": true, "known": true, "laid": true,
+	"led": true, "left": true, "lent": true, "lost": true, "made": true, "meant": true, "met": true,
+	"paid": true, "put": true, "read": true, "ridden": true, "rung": true, "run": true, "said": true,
+	"seen": true, "sold": true, "sent": true, "set": true, "shaken": true, "shot": true, "shown": true,
+	"shut": true, "sung": true, "spent": true, "spoken": true, "spun": true, "stolen": true,
+	"struck": true, "sworn": true, "swept": true, "taken": true, "taught": true, "thought": true,
+	"thrown": true, "told": true, "torn": true, "understood": true, "won": true, "woken": true,
+	"worn": true, "written": true,
+}
+
+// isPassive estimates whether a sentence is in the passive voice: a form
+// of "to be" followed, after at most two adverbs or "not", by a past
+// participle ("was written", "is being carefully reviewed").
+func isPassive(words []string) bool {
+	for i, w := range words {
+		if !beVerbs[strings.ToLower(w)] {
+			continue
+		}
+		for j := i + 1; j < len(words) && j <= i+3; j++ {
+			next := strings.ToLower(words[j])
+			if next == "being" || next == "not" || strings.HasSuffix(next, "ly") {
+				continue
+			}
+			if (strings.HasSuffix(next, "ed") && len(next) > 3) || irregularParticiples[next] {
+				return true
+			}
+			break
+		}
+	}
+	return false
+}
+
+// textStats computes the statistics of text.
+func textStats(text string) TextStats {
+	s := TextStats{Characters: utf8.RuneCountInString(text), ReadingTimeWPM: readingWPM}
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			s.CharactersNoSpaces++
+		}
+	}
+	for _, p := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		if strings.TrimSpace(p) != "" {
+			s.Paragraphs++
+		}
+	}
+
+	distinct := map[string]bool{}
+	for _, sentence := range splitSentences(text) {
+		words := statsWords(sentence)
+		if len(words) == 0 {
+			continue
+		}
+		s.Sentences++
+		s.Words += len(words)
+		if len(words) >= longSentenceWords {
+			s.LongSentences++
+		}
+		if isPassive(words) {
+			s.PassiveSentences++
+		}
+		for _, w := range words {
+			distinct[strings.ToLower(w)] = true
+			n := syllables(w)
+			s.Syllables += n
+			if n >= 3 {
+				s.PolysyllabicWords++
+			}
+		}
+	}
+	if s.Words == 0 {
+		return s
+	}
+
+	words, sentences := float64(s.Words), float64(s.Sentences)
+	s.WordsPerSentence = round1(words / sentences)
+	s.SyllablesPerWord = round1(float64(s.Syllables) / words)
+	s.ReadingTimeSeconds = int(math.Ceil(words / float64(readingWPM) * 60))
+	s.FleschReadingEase = round1(206.835 - 1.015*words/sentences - 84.6*float64(s.Syllables)/words)
+	s.FleschKincaidGrade = round1(0.39*words/sentences + 11.8*float64(s.Syllables)/words - 15.59)
+	s.SMOGIndex = round1(1.043*math.Sqrt(float64(s.PolysyllabicWords)*30/sentences) + 3.1291)
+	s.LexicalDiversity = math.Round(float64(len(distinct))/words*1000) / 1000
+	s.PassiveVoiceRatio = math.Round(float64(s.PassiveSentences)/sentences*1000) / 1000
+	return s
+}
+
+func round1(x float64) float64 {
+	return math.Round(x*10) / 10
+}
+
+// statsHandler serves POST /stats.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	var req StatsRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if !checkText(w, req.Text) {
+		return
+	}
+	if req.InputFormat != "" && req.InputFormat != texttools.FormatPlain && req.InputFormat != texttools.FormatMarkdown && req.InputFormat != texttools.FormatHTML {
+		http.Error(w, "`input_format` must be plain, markdown or html", http.StatusBadRequest)
+		return
+	}
+	text := texttools.PrepareInput(req.Text, req.InputFormat)
+	if req.InputFormat == texttools.FormatMarkdown {
+		text = texttools.FormatOutput(text, texttools.FormatPlain)
+	}
+	writeJSON(w, http.StatusOK, textStats(text))
+}
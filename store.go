@@ -0,0 +1,54 @@
+package main
+
+// --- Storage backends ---
+//
+// The result history, the document library and the usage of tenants keep
+// their data in a backend: by default in memory, saved to HISTORY_FILE,
+// DOCUMENTS_FILE and TENANTS_FILE, or, with DATABASE_URL, in PostgreSQL
+// (postgres.go), which several replicas of the server can share. History,
+// DocumentStore and TenantStore put their logic (duplicate detection,
+// limits, reports) on top of a backend.
+
+// Store is a backend for all three, e.g. a database.
+type Store interface {
+	HistoryBackend
+	DocumentBackend
+	UsageBackend
+	Close() error
+}
+
+// HistoryBackend keeps history entries.
+type HistoryBackend interface {
+	// AddEntry stores e, keeping only the newest max entries (all if 0).
+	AddEntry(e *HistoryEntry, max int) error
+	Entry(id string) (*HistoryEntry, error) // nil if there is none
+	// Entries returns the user's entries that match f, newest first.
+	Entries(user string, f LabelFilter) ([]*HistoryEntry, error)
+	// DuplicateCandidates returns, newest first, the entries of op and key
+	// with the input fingerprint or of user.
+	DuplicateCandidates(user, op, key, fingerprint string) ([]*HistoryEntry, error)
+	DeleteEntry(user, id string) (bool, error)
+	SetEntryLabels(user, id string, l Labels) (bool, error)
+	CountEntries() (int, error)
+	Persistent() bool
+}
+
+// DocumentBackend keeps library documents.
+type DocumentBackend interface {
+	Document(id string) (*StoredDocument, error) // nil if there is none
+	// Documents returns the user's documents that match f, most recently
+	// updated first.
+	Documents(user string, f LabelFilter) ([]DocumentInfo, error)
+	// PutDocument stores d, replacing the document with its ID; a new one
+	// gets errTooManyDocuments if its user has maxPerUser already.
+	PutDocument(d StoredDocument, maxPerUser int) error
+	SetDocumentLabels(user, id string, l Labels) (DocumentInfo, bool, error)
+	DeleteDocument(user, id string) (bool, error)
+}
+
+// UsageBackend keeps the usage of tenants, by month.
+type UsageBackend interface {
+	AddUsage(tenant, month string, u TenantUsage) error // adds to the month's counts
+	Usage(tenant string) (map[string]TenantUsage, error)
+	DeleteUsage(tenant string) error
+}
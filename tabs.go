@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// --- UI tabs ---
+//
+// The web UI keeps several inputs open as tabs, each with its own results.
+// The tab state is saved here per browser session (a cookie), so it survives
+// reloads and restarts when TABS_FILE is set.
+
+const (
+	sessionCookie    = "tt_session"
+	maxTabs          = 20
+	maxTabsBody      = 4 << 20 // bytes
+	tabSessionMaxAge = 30 * 24 * time.Hour
+)
+
+// Tab is one input of the UI with the latest result of each operation.
+type Tab struct {
+	ID      string            `json:"id"`
+	Title   string            `json:"title"`
+	Text    string            `json:"text"`
+	Tone    string            `json:"tone,omitempty"`
+	Results map[string]string `json:"results,omitempty"` // operation → rendered output
+}
+
+// TabState is everything the UI restores.
+type TabState struct {
+	Tabs      []Tab     `json:"tabs"`
+	Active    string    `json:"active"` // ID of the selected tab
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (t TabState) validate() error {
+	if len(t.Tabs) > maxTabs {
+		return fmt.Errorf("at most %d tabs", maxTabs)
+	}
+	seen := map[string]bool{}
+	for _, tab := range t.Tabs {
+		if tab.ID == "" || seen[tab.ID] {
+			return errors.New("every tab needs a unique id")
+		}
+		seen[tab.ID] = true
+	}
+	return nil
+}
+
+// sessionID returns the caller's browser session, starting one (and
+// setting the cookie) if needed.
+func sessionID(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" && len(c.Value) <= 64 {
+		return c.Value
+	}
+	id := newID() + newID()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(tabSessionMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// TabStore keeps the tab state per session, optionally persisted to a JSON
+// file. Sessions untouched for tabSessionMaxAge are dropped.
+type TabStore struct {
+	file string
+
+	mu       sync.RWMutex
+	sessions map[string]TabState
+}
+
+func NewTabStore(file string) (*TabStore, error) {
+	s := &TabStore{file: file, sessions: map[string]TabState{}}
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.sessions); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return s, nil
+}
+
+func (s *TabStore) Get(session string) (TabState, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.sessions[session]
+	return t, ok
+}
+
+func (s *TabStore) Set(session string, t TabState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pruneLocked()
+	s.sessions[session] = t
+	return s.saveLocked()
+}
+
+func (s *TabStore) Delete(session string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, session)
+	return s.saveLocked()
+}
+
+func (s *TabStore) pruneLocked() {
+	cutoff := time.Now().Add(-tabSessionMaxAge)
+	for id, t := range s.sessions {
+		if t.UpdatedAt.Before(cutoff) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (s *TabStore) saveLocked() error {
+	if s.file == "" {
+		return nil
+	}
+	return writeJSONFile(s.file, s.sessions)
+}
+
+// tabsHandler serves the session's tabs: GET reads them (empty for a new
+// session), PUT replaces them and DELETE clears them.
+func tabsHandler(tabs *TabStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := sessionID(w, r)
+		switch r.Method {
+		case http.MethodGet:
+			t, ok := tabs.Get(session)
+			if !ok {
+				t.Tabs = []Tab{}
+			}
+			writeJSON(w, http.StatusOK, t)
+		case http.MethodPut:
+			if refusePrivate(w, r, "saved tabs") {
+				return
+			}
+			var t TabState
+			if !decodeJSONLimit(w, r, &t, maxTabsBody) {
+				return
+			}
+			if err := t.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			t.UpdatedAt = time.Now().UTC()
+			if err := tabs.Set(session, t); err != nil {
+				log.Println("tabs error:", err)
+				http.Error(w, "failed to save tabs", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, t)
+		case http.MethodDelete:
+			if err := tabs.Delete(session); err != nil {
+				log.Println("tabs error:", err)
+				http.Error(w, "failed to save tabs", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,725 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Tenants ---
+//
+// Tenants let several teams share one deployment: each gets named API keys,
+// a monthly token quota and a list of the models it may use. A request that
+// sends a key (X-API-Key, or Authorization: Bearer tk_...) is the tenant's:
+//
+//   - an unknown or revoked key gets 401;
+//   - once the tenant's tokens of the month (UTC) reach its quota, its LLM
+//     requests get 429 until the next month. The quota is checked before
+//     each LLM call, so the request that crosses it still completes;
+//   - an LLM call with a model outside its list gets 403.
+//
+// The users of a tenant are its own (see userID), so no history, documents
+// or other per-user data are shared across tenants.
+//
+// With REQUIRE_API_KEY=true, the LLM endpoints also turn away requests
+// without a key, except those of SSO logins. Tenants, their key hashes (the
+// keys themselves are shown once, when issued) and their usage per month
+// are saved to TENANTS_FILE; with DATABASE_URL, the usage is kept in
+// PostgreSQL instead, shared by all replicas.
+
+// tenantKeyPrefix starts every key, so keys can be told apart from the
+// admin token.
+const tenantKeyPrefix = "tk_"
+
+// Tenant is a team sharing the deployment.
+type Tenant struct {
+	Name          string                 `json:"name"`
+	MonthlyTokens int                    `json:"monthly_tokens"`   // quota; unlimited if 0
+	Models        []string               `json:"models,omitempty"` // the models it may use; all if empty
+	Keys          []TenantKey            `json:"keys"`
+	Usage         map[string]TenantUsage `json:"usage"` // by month, e.g. "2026-10"
+	CreatedAt     time.Time              `json:"created_at"`
+}
+
+// TenantKey is an API key of a tenant.
+type TenantKey struct {
+	ID        string    `json:"id"`
+	Prefix    string    `json:"prefix"`         // the start of the key, to tell keys apart
+	Hash      string    `json:"hash,omitempty"` // SHA-256 of the key; not served
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TenantUsage is what a tenant used in a month.
+type TenantUsage struct {
+	Requests         int `json:"requests"`  // to the LLM endpoints
+	LLMCalls         int `json:"llm_calls"` // a request may make several
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// TenantSpec is the body of POST /admin/tenants and PATCH
+// /admin/tenants/<name>.
+type TenantSpec struct {
+	Name          string    `json:"name"` // on creation only
+	MonthlyTokens *int      `json:"monthly_tokens"`
+	Models        *[]string `json:"models"`
+}
+
+// IssuedKey is a new key, which is only ever shown in this response.
+type IssuedKey struct {
+	TenantKey
+	Key string `json:"key"`
+}
+
+// TenantReport is a tenant as served by the API: its usage of the month
+// and none of its key hashes.
+type TenantReport struct {
+	Tenant
+	Month           string      `json:"month"`
+	MonthUsage      TenantUsage `json:"month_usage"`
+	RemainingTokens *int        `json:"remaining_tokens,omitempty"` // absent if unlimited
+}
+
+var (
+	errUnknownTenant    = errors.New("unknown tenant")
+	errUnknownTenantKey = errors.New("unknown key")
+	tenantNameRe        = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+)
+
+// TenantStore holds the tenants.
+type TenantStore struct {
+	file    string
+	require bool // keys are required on the LLM endpoints
+
+	// usage, if set, keeps the usage of tenants instead of TENANTS_FILE,
+	// so that replicas count against the same quotas
+	usage UsageBackend
+
+	mu      sync.RWMutex
+	tenants map[string]*Tenant
+	keys    map[string]string // key hash -> tenant
+}
+
+func NewTenantStoreFromEnv() (*TenantStore, error) {
+	s := &TenantStore{
+		file:    os.Getenv("TENANTS_FILE"),
+		require: envBool("REQUIRE_API_KEY"),
+		tenants: map[string]*Tenant{},
+		keys:    map[string]string{},
+	}
+	if s.file == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.tenants); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.file, err)
+	}
+	for name, t := range s.tenants {
+		for _, k := range t.Keys {
+			s.keys[k.Hash] = name
+		}
+	}
+	return s, nil
+}
+
+// Reload loads the tenants, their quotas, models and keys again from
+// TENANTS_FILE.
+func (s *TenantStore) Reload() error {
+	fresh, err := NewTenantStoreFromEnv()
+	if err != nil {
+		return err
+	}
+	if fresh.file != s.file {
+		return fmt.Errorf("TENANTS_FILE can't change without a restart")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tenants, s.keys = fresh.tenants, fresh.keys
+	return nil
+}
+
+func hashTenantKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// usageMonth is the month usage of t counts towards.
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// Authenticate returns the tenant of key.
+func (s *TenantStore) Authenticate(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	name, ok := s.keys[hashTenantKey(key)]
+	return name, ok
+}
+
+func (s *TenantStore) List() []TenantReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]TenantReport, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		out = append(out, s.reportLocked(t))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (s *TenantStore) Get(name string) (TenantReport, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tenants[name]
+	if !ok {
+		return TenantReport{}, false
+	}
+	return s.reportLocked(t), true
+}
+
+func (s *TenantStore) reportLocked(t *Tenant) TenantReport {
+	r := TenantReport{Tenant: *t, Month: usageMonth(time.Now())}
+	usage := s.usageOf(t)
+	r.Keys = make([]TenantKey, len(t.Keys))
+	for i, k := range t.Keys {
+		k.Hash = ""
+		r.Keys[i] = k
+	}
+	r.Models = slices.Clone(t.Models)
+	r.Usage = make(map[string]TenantUsage, len(usage))
+	for m, u := range usage {
+		r.Usage[m] = u
+	}
+	r.MonthUsage = usage[r.Month]
+	if t.MonthlyTokens > 0 {
+		remaining := max(t.MonthlyTokens-r.MonthUsage.TotalTokens, 0)
+		r.RemainingTokens = &remaining
+	}
+	return r
+}
+
+// Create adds a tenant with a first key.
+func (s *TenantStore) Create(spec TenantSpec) (TenantReport, IssuedKey, error) {
+	if !tenantNameRe.MatchString(spec.Name) {
+		return TenantReport{}, IssuedKey{}, &inputError{Status: http.StatusBadRequest, Message: "`name` must be lowercase letters, digits, - and _"}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tenants[spec.Name]; ok {
+		return TenantReport{}, IssuedKey{}, &inputError{Status: http.StatusConflict, Message: fmt.Sprintf("tenant %q exists", spec.Name)}
+	}
+	t := &Tenant{Name: spec.Name, Usage: map[string]TenantUsage{}, CreatedAt: time.Now().UTC()}
+	if err := t.apply(spec); err != nil {
+		return TenantReport{}, IssuedKey{}, err
+	}
+	s.tenants[t.Name] = t
+	key := s.issueLocked(t)
+	return s.reportLocked(t), key, s.saveLocked()
+}
+
+// Update changes the quota or models of a tenant.
+func (s *TenantStore) Update(name string, spec TenantSpec) (TenantReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[name]
+	if !ok {
+		return TenantReport{}, errUnknownTenant
+	}
+	updated := *t
+	if err := updated.apply(spec); err != nil {
+		return TenantReport{}, err
+	}
+	*t = updated
+	return s.reportLocked(t), s.saveLocked()
+}
+
+func (t *Tenant) apply(spec TenantSpec) error {
+	if spec.MonthlyTokens != nil {
+		if *spec.MonthlyTokens < 0 {
+			return &inputError{Status: http.StatusBadRequest, Message: "`monthly_tokens` must not be negative"}
+		}
+		t.MonthlyTokens = *spec.MonthlyTokens
+	}
+	if spec.Models != nil {
+		t.Models = nil
+		for _, m := range *spec.Models {
+			if m = strings.TrimSpace(m); m != "" && !slices.Contains(t.Models, m) {
+				t.Models = append(t.Models, m)
+			}
+		}
+	}
+	return nil
+}
+
+// Delete removes a tenant and revokes its keys.
+func (s *TenantStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[name]
+	if !ok {
+		return errUnknownTenant
+	}
+	for _, k := range t.Keys {
+		delete(s.keys, k.Hash)
+	}
+	delete(s.tenants, name)
+	if s.usage != nil {
+		if err := s.usage.DeleteUsage(name); err != nil {
+			return err
+		}
+	}
+	return s.saveLocked()
+}
+
+// IssueKey adds a key to a tenant.
+func (s *TenantStore) IssueKey(name string) (IssuedKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[name]
+	if !ok {
+		return IssuedKey{}, errUnknownTenant
+	}
+	key := s.issueLocked(t)
+	return key, s.saveLocked()
+}
+
+func (s *TenantStore) issueLocked(t *Tenant) IssuedKey {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	key := tenantKeyPrefix + hex.EncodeToString(b)
+	k := TenantKey{ID: newID(), Prefix: key[:len(tenantKeyPrefix)+6], Hash: hashTenantKey(key), CreatedAt: time.Now().UTC()}
+	t.Keys = append(t.Keys, k)
+	s.keys[k.Hash] = t.Name
+	k.Hash = ""
+	return IssuedKey{TenantKey: k, Key: key}
+}
+
+// RevokeKey removes a key of a tenant.
+func (s *TenantStore) RevokeKey(name, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[name]
+	if !ok {
+		return errUnknownTenant
+	}
+	i := slices.IndexFunc(t.Keys, func(k TenantKey) bool { return k.ID == id })
+	if i < 0 {
+		return errUnknownTenantKey
+	}
+	delete(s.keys, t.Keys[i].Hash)
+	t.Keys = slices.Delete(t.Keys, i, i+1)
+	return s.saveLocked()
+}
+
+// quotaLeft returns whether the tenant has tokens left this month, and its
+// quota.
+func (s *TenantStore) quotaLeft(name string) (bool, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tenants[name]
+	if !ok || t.MonthlyTokens == 0 {
+		return true, 0
+	}
+	return s.usageOf(t)[usageMonth(time.Now())].TotalTokens < t.MonthlyTokens, t.MonthlyTokens
+}
+
+// usageOf returns the usage of t by month.
+func (s *TenantStore) usageOf(t *Tenant) map[string]TenantUsage {
+	if s.usage == nil {
+		return t.Usage
+	}
+	usage, err := s.usage.Usage(t.Name)
+	if err != nil {
+		log.Println("tenant usage error:", err)
+	}
+	return usage
+}
+
+// allowsModel returns whether the tenant may use model.
+func (s *TenantStore) allowsModel(name, model string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tenants[name]
+	return !ok || len(t.Models) == 0 || slices.Contains(t.Models, model)
+}
+
+// addUsage adds to the tenant's usage of the month and saves.
+func (s *TenantStore) addUsage(name string, u TenantUsage) {
+	if s.usage != nil {
+		s.mu.RLock()
+		_, ok := s.tenants[name]
+		s.mu.RUnlock()
+		if !ok {
+			return
+		}
+		u.TotalTokens = u.PromptTokens + u.CompletionTokens
+		if err := s.usage.AddUsage(name, usageMonth(time.Now()), u); err != nil {
+			log.Println("tenant usage error:", err)
+		}
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[name]
+	if !ok {
+		return
+	}
+	if t.Usage == nil {
+		t.Usage = map[string]TenantUsage{}
+	}
+	month := usageMonth(time.Now())
+	m := t.Usage[month]
+	m.Requests += u.Requests
+	m.LLMCalls += u.LLMCalls
+	m.PromptTokens += u.PromptTokens
+	m.CompletionTokens += u.CompletionTokens
+	m.TotalTokens += u.PromptTokens + u.CompletionTokens
+	t.Usage[month] = m
+	if err := s.saveLocked(); err != nil {
+		log.Println("tenant usage error:", err)
+	}
+}
+
+func (s *TenantStore) saveLocked() error {
+	if s.file == "" {
+		return nil
+	}
+	return writeJSONFile(s.file, s.tenants)
+}
+
+// untilNextMonth is how long until the quotas start over.
+func untilNextMonth() time.Duration {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC).Sub(now)
+}
+
+// --- Enforcement ---
+
+type tenantKey struct{}
+
+// tenantSlot is the tenant of a request, and the denial of one of its LLM
+// calls.
+type tenantSlot struct {
+	store *TenantStore
+	name  string
+
+	mu     sync.Mutex
+	denied *inputError
+}
+
+func (s *tenantSlot) deny(err *inputError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.denied == nil {
+		s.denied = err
+	}
+}
+
+func (s *tenantSlot) get() *inputError {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.denied
+}
+
+func tenantFrom(ctx context.Context) (*tenantSlot, bool) {
+	slot, ok := ctx.Value(tenantKey{}).(*tenantSlot)
+	return slot, ok
+}
+
+// tenantName returns the tenant of the request, if it sent a key.
+func tenantName(r *http.Request) string {
+	if slot, ok := tenantFrom(r.Context()); ok {
+		return slot.name
+	}
+	return ""
+}
+
+// apiKey returns the tenant key a request sent, if any. Slack can't send
+// one, so its commands use SLACK_API_KEY.
+func apiKey(r *http.Request) string {
+	if k := r.Header.Get("X-API-Key"); k != "" {
+		return k
+	}
+	if r.URL.Path == "/integrations/slack" && slackAPIKey != "" {
+		return slackAPIKey
+	}
+	if k, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(k, tenantKeyPrefix) {
+		return k
+	}
+	return ""
+}
+
+// withTenants authenticates tenant keys and enforces the quotas and model
+// lists of their requests.
+func withTenants(store *TenantStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		llm := isLLMRequest(r)
+		key := apiKey(r)
+		if key == "" {
+			if _, sso := sso.Login(r); store.require && llm && !sso {
+				http.Error(w, "an API key is required (X-API-Key)", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		name, ok := store.Authenticate(key)
+		if !ok {
+			http.Error(w, "invalid API key", http.StatusUnauthorized)
+			return
+		}
+		slot := &tenantSlot{store: store, name: name}
+		if llm {
+			if ok, quota := store.quotaLeft(name); !ok {
+				writeQuotaExceeded(w, r, name, quota)
+				return
+			}
+			store.addUsage(name, TenantUsage{Requests: 1})
+		}
+		tw := &tenantWriter{ResponseWriter: w, slot: slot}
+		next.ServeHTTP(tw, r.WithContext(context.WithValue(r.Context(), tenantKey{}, slot)))
+		if !tw.replaced {
+			return
+		}
+		denied := slot.get()
+		if denied.Status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", retryAfterSeconds(untilNextMonth()))
+		}
+		w.Header().Del("X-Content-Type-Options")
+		writeJSON(w, denied.Status, APIError{Error: APIErrorBody{
+			Code:      tenantErrorCode(denied.Status),
+			Message:   denied.Message,
+			RequestID: r.Header.Get("X-Request-ID"),
+		}})
+	})
+}
+
+func writeQuotaExceeded(w http.ResponseWriter, r *http.Request, name string, quota int) {
+	w.Header().Set("Retry-After", retryAfterSeconds(untilNextMonth()))
+	writeJSON(w, http.StatusTooManyRequests, APIError{Error: APIErrorBody{
+		Code:      "quota_exceeded",
+		Message:   fmt.Sprintf("tenant %q has used its monthly quota of %d tokens", name, quota),
+		RequestID: r.Header.Get("X-Request-ID"),
+	}})
+}
+
+func tenantErrorCode(status int) string {
+	switch status {
+	case http.StatusTooManyRequests:
+		return "quota_exceeded"
+	case http.StatusForbidden:
+		return "model_not_allowed"
+	}
+	return errorCode(status)
+}
+
+// tenantWriter drops the handler's error response once an LLM call was
+// denied, so that withTenants can write the denial instead.
+type tenantWriter struct {
+	http.ResponseWriter
+	slot     *tenantSlot
+	replaced bool
+}
+
+func (w *tenantWriter) WriteHeader(status int) {
+	if status >= 400 && w.slot.get() != nil {
+		w.replaced = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *tenantWriter) Write(b []byte) (int, error) {
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *tenantWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.replaced {
+		f.Flush()
+	}
+}
+
+func (w *tenantWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// errTenantDenied is returned by LLM calls a tenant may not make.
+var errTenantDenied = errors.New("denied for the tenant")
+
+// tenantProvider checks the quota and model list of the tenant of each
+// call; defaultModel is the model of calls that name none.
+type tenantProvider struct {
+	texttools.StreamProvider
+	defaultModel string
+}
+
+func (p tenantProvider) check(ctx context.Context, req texttools.Request) error {
+	slot, ok := tenantFrom(ctx)
+	if !ok {
+		return nil
+	}
+	model := req.Model
+	if model == "" {
+		model = p.defaultModel
+	}
+	if !slot.store.allowsModel(slot.name, model) {
+		slot.deny(&inputError{Status: http.StatusForbidden, Message: fmt.Sprintf("tenant %q may not use model %q", slot.name, model)})
+		return fmt.Errorf("model %s: %w", model, errTenantDenied)
+	}
+	if ok, quota := slot.store.quotaLeft(slot.name); !ok {
+		slot.deny(&inputError{Status: http.StatusTooManyRequests, Message: fmt.Sprintf("tenant %q has used its monthly quota of %d tokens", slot.name, quota)})
+		return fmt.Errorf("quota: %w", errTenantDenied)
+	}
+	return nil
+}
+
+func (p tenantProvider) Complete(ctx context.Context, req texttools.Request) (texttools.Completion, error) {
+	if err := p.check(ctx, req); err != nil {
+		return texttools.Completion{}, err
+	}
+	return p.StreamProvider.Complete(ctx, req)
+}
+
+func (p tenantProvider) Stream(ctx context.Context, req texttools.Request, onDelta func(string) error) (texttools.Completion, error) {
+	if err := p.check(ctx, req); err != nil {
+		return texttools.Completion{}, err
+	}
+	return p.StreamProvider.Stream(ctx, req, onDelta)
+}
+
+// recordTenantUsage adds the tokens of an LLM call to the usage of the
+// tenant of ctx, if any.
+func recordTenantUsage(ctx context.Context, usage texttools.Usage) {
+	if slot, ok := tenantFrom(ctx); ok {
+		slot.store.addUsage(slot.name, TenantUsage{LLMCalls: 1, PromptTokens: usage.PromptTokens, CompletionTokens: usage.CompletionTokens})
+	}
+}
+
+// --- Handlers ---
+
+// adminTenantsHandler serves the tenant admin API:
+//
+//	GET    /admin/tenants                  all tenants, with their usage
+//	POST   /admin/tenants                  create one, with a first key
+//	GET    /admin/tenants/<name>
+//	PATCH  /admin/tenants/<name>           change its quota or models
+//	DELETE /admin/tenants/<name>
+//	POST   /admin/tenants/<name>/keys      issue another key
+//	DELETE /admin/tenants/<name>/keys/<id> revoke a key
+func adminTenantsHandler(store *TenantStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/tenants"), "/")
+		parts := strings.Split(rest, "/")
+		var err error
+		switch {
+		case rest == "" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, map[string]interface{}{"tenants": store.List()})
+			return
+		case rest == "" && r.Method == http.MethodPost:
+			var spec TenantSpec
+			if !decodeJSON(w, r, &spec) {
+				return
+			}
+			var t TenantReport
+			var key IssuedKey
+			if t, key, err = store.Create(spec); err == nil {
+				log.Printf("tenant %s created by %s", t.Name, userID(r))
+				writeJSON(w, http.StatusCreated, map[string]interface{}{"tenant": t, "key": key})
+				return
+			}
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			t, ok := store.Get(parts[0])
+			if !ok {
+				http.Error(w, errUnknownTenant.Error(), http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, t)
+			return
+		case len(parts) == 1 && r.Method == http.MethodPatch:
+			var spec TenantSpec
+			if !decodeJSON(w, r, &spec) {
+				return
+			}
+			var t TenantReport
+			if t, err = store.Update(parts[0], spec); err == nil {
+				writeJSON(w, http.StatusOK, t)
+				return
+			}
+		case len(parts) == 1 && r.Method == http.MethodDelete:
+			if err = store.Delete(parts[0]); err == nil {
+				log.Printf("tenant %s deleted by %s", parts[0], userID(r))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		case len(parts) == 2 && parts[1] == "keys" && r.Method == http.MethodPost:
+			var key IssuedKey
+			if key, err = store.IssueKey(parts[0]); err == nil {
+				log.Printf("tenant %s: key %s issued by %s", parts[0], key.ID, userID(r))
+				writeJSON(w, http.StatusCreated, key)
+				return
+			}
+		case len(parts) == 3 && parts[1] == "keys" && r.Method == http.MethodDelete:
+			if err = store.RevokeKey(parts[0], parts[2]); err == nil {
+				log.Printf("tenant %s: key %s revoked by %s", parts[0], parts[2], userID(r))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		case len(parts) <= 3:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		var ie *inputError
+		switch {
+		case errors.As(err, &ie):
+			http.Error(w, ie.Message, ie.Status)
+		case errors.Is(err, errUnknownTenant), errors.Is(err, errUnknownTenantKey):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			log.Println("tenant error:", err)
+			http.Error(w, "failed to save tenants", http.StatusInternalServerError)
+		}
+	}
+}
+
+// tenantHandler serves GET /tenant: the caller's tenant, by its key.
+func tenantHandler(store *TenantStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, ok := store.Get(tenantName(r))
+		if !ok {
+			http.Error(w, "an API key is required (X-API-Key)", http.StatusUnauthorized)
+			return
+		}
+		writeJSON(w, http.StatusOK, t)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	store := &TenantStore{tenants: map[string]*Tenant{}, keys: map[string]string{}}
+	_, keyA, err := store.Create(TenantSpec{Name: "team-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, keyB, err := store.Create(TenantSpec{Name: "team-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend, _ := newMemoryHistory("")
+	history := NewHistory(backend, 100)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/record", func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		meta := history.Record(r, "summarize", "", string(b), Options{}, map[string]string{"summary": "s"})
+		io.WriteString(w, meta.ID)
+	})
+	mux.HandleFunc("/history", historyHandler(history, nil))
+	mux.HandleFunc("/history/", historyHandler(history, nil))
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, userID(r)) })
+	h := withTenants(store, mux)
+
+	do := func(method, path, key, user, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		if user != "" {
+			r.Header.Set("X-User-ID", user)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	inputs := func(key, user string) []string {
+		var resp struct{ Entries []HistoryEntry }
+		if err := json.Unmarshal(do("GET", "/history", key, user, "").Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, e := range resp.Entries {
+			out = append(out, e.Input)
+		}
+		return out
+	}
+
+	idA := do("POST", "/record", keyA.Key, "", "team-a secret").Body.String()
+	do("POST", "/record", keyB.Key, "", "team-b secret")
+	do("POST", "/record", "", "", "no tenant")
+
+	for _, tt := range []struct {
+		name, key, user string
+		want            string
+	}{
+		{"team-a", keyA.Key, "", "team-a secret"},
+		{"team-b", keyB.Key, "", "team-b secret"},
+		{"no key", "", "", "no tenant"},
+		{"no key, tenant user ID", "", "team-a/default", "no tenant"},
+		{"team-b, tenant user ID", keyB.Key, "team-a/default", "team-b secret"},
+	} {
+		if got := inputs(tt.key, tt.user); len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s: GET /history has %q, want only %q", tt.name, got, tt.want)
+		}
+	}
+
+	if w := do("GET", "/history/"+idA, keyA.Key, "", ""); w.Code != http.StatusOK {
+		t.Errorf("team-a reading its result: %d", w.Code)
+	}
+	for name, key := range map[string]string{"team-b": keyB.Key, "no key": ""} {
+		if w := do("GET", "/history/"+idA, key, "", ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s reading team-a's result: %d", name, w.Code)
+		}
+		if w := do("DELETE", "/history/"+idA, key, "", ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s deleting team-a's result: %d", name, w.Code)
+		}
+	}
+
+	for _, tt := range []struct{ key, user, want string }{
+		{"", "", "default"},
+		{"", "alice", "alice"},
+		{"", "team-a/alice", "default"},
+		{keyA.Key, "alice", "team-a/alice"},
+		{keyB.Key, "", "team-b/default"},
+	} {
+		if got := do("GET", "/whoami", tt.key, tt.user, "").Body.String(); got != tt.want {
+			t.Errorf("X-User-ID %q with key %.6s: user %q, want %q", tt.user, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Terminology consistency ---
+//
+// POST /terminology-report checks a set of documents (texts, stored results
+// or both) for terms written in several ways: "e-mail" and "email", "log in"
+// and "sign in". A local pass finds the candidates: forms that differ only
+// in hyphens and spaces, and the known variant pairs in termSynonyms. The
+// model then judges them, drops those that aren't inconsistencies ("set up"
+// the verb and "setup" the noun), adds any the local pass can't see and
+// picks the canonical form. "mode": "local" skips the model and suggests the
+// most frequent form.
+
+const (
+	maxTermDocuments  = 20
+	maxTermCandidates = 50
+)
+
+// termSynonyms are variant forms the spelling can't tell are the same term.
+var termSynonyms = [][]string{
+	{"sign in", "log in", "login"},
+	{"sign out", "log out", "logout"},
+	{"canceled", "cancelled"},
+	{"color", "colour"},
+	{"organization", "organisation"},
+	{"ok", "okay"},
+}
+
+type TerminologyRequest struct {
+	Documents  []TermDocument `json:"documents"`
+	HistoryIDs []string       `json:"history_ids"` // stored results whose input to include
+	Mode       string         `json:"mode"`        // full (local analysis and LLM, the default) or local
+	Options
+}
+
+type TermDocument struct {
+	Name string `json:"name"` // "Document 1", ... by default
+	Text string `json:"text"`
+}
+
+type TerminologyResponse struct {
+	Terms []TermGroup `json:"terms"`
+	ResultMeta
+}
+
+// TermGroup is a term used in several forms.
+type TermGroup struct {
+	Canonical string        `json:"canonical"`
+	Variants  []TermVariant `json:"variants"` // most used first
+	Reason    string        `json:"reason,omitempty"`
+	Source    string        `json:"source"` // local (found by the local pass) or model
+}
+
+type TermVariant struct {
+	Form      string   `json:"form"`
+	Count     int      `json:"count"`
+	Documents []string `json:"documents"` // names of the documents using it
+}
+
+// terminologyPromptInput is the data of the terminology template.
+type terminologyPromptInput struct {
+	texttools.Options
+	Documents  []TermDocument
+	Candidates [][]string
+}
+
+func terminologyReportHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TerminologyRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		docs := req.Documents
+		for _, id := range req.HistoryIDs {
+			e, ok := history.Get(id)
+			if !ok || e.User != userID(r) {
+				http.Error(w, fmt.Sprintf("result %q not found", id), http.StatusNotFound)
+				return
+			}
+			docs = append(docs, TermDocument{Name: e.Operation + " " + e.ID, Text: e.Input})
+		}
+		if len(docs) == 0 {
+			http.Error(w, "`documents` or `history_ids` is required", http.StatusBadRequest)
+			return
+		}
+		if len(docs) > maxTermDocuments {
+			http.Error(w, fmt.Sprintf("at most %d documents per report", maxTermDocuments), http.StatusBadRequest)
+			return
+		}
+		var all strings.Builder
+		for i := range docs {
+			if docs[i].Text == "" {
+				http.Error(w, "documents must not be empty", http.StatusBadRequest)
+				return
+			}
+			if docs[i].Name == "" {
+				docs[i].Name = fmt.Sprintf("Document %d", i+1)
+			}
+			fmt.Fprintf(&all, "%s\n\n%s\n\n---\n\n", docs[i].Name, docs[i].Text)
+		}
+		// the documents go to the model together
+		input := strings.TrimSuffix(all.String(), "\n\n---\n\n")
+		if !checkText(w, input) {
+			return
+		}
+		switch req.Mode {
+		case "":
+			req.Mode = "full"
+		case "full", "local":
+		default:
+			http.Error(w, "mode must be one of full, local", http.StatusBadRequest)
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		key := historyKey(req.Mode, req.Options)
+		if prior, ok := history.Reusable(r, "terminology-report", key, input, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		candidates := termCandidates(docs)
+		resp := TerminologyResponse{Terms: []TermGroup{}}
+		if req.Mode == "local" {
+			for _, forms := range candidates {
+				if g, ok := termGroup(docs, forms, "", "", "local"); ok {
+					resp.Terms = append(resp.Terms, g)
+				}
+			}
+		} else {
+			in := terminologyPromptInput{Options: req.Options.Options, Documents: docs, Candidates: candidates}
+			out, err := tools.Prompt(r.Context(), "terminology", in, in.Options)
+			if err != nil {
+				log.Println("terminology-report error:", err)
+				writeLLMError(w, err)
+				return
+			}
+			var judged struct {
+				Terms []struct {
+					Variants  []string `json:"variants"`
+					Canonical string   `json:"canonical"`
+					Reason    string   `json:"reason"`
+				} `json:"terms"`
+			}
+			if err := json.Unmarshal([]byte(out), &judged); err != nil {
+				logUnparseable(r.Context(), "terminology-report", out)
+			}
+			local := map[string]bool{}
+			for _, forms := range candidates {
+				for _, f := range forms {
+					local[f] = true
+				}
+			}
+			for _, t := range judged.Terms {
+				source := "model"
+				for _, f := range t.Variants {
+					if local[strings.ToLower(f)] {
+						source = "local"
+					}
+				}
+				if g, ok := termGroup(docs, t.Variants, t.Canonical, t.Reason, source); ok {
+					resp.Terms = append(resp.Terms, g)
+				}
+			}
+		}
+
+		resp.ResultMeta = history.Record(r, "terminology-report", key, input, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+var termWordRe = regexp.MustCompile(`[\pL\pN]+(?:[-'’][\pL\pN]+)*`)
+
+// termCandidates finds the forms of the documents (lowercased) that may be
+// the same term: single and hyphenated words and two-word phrases that
+// differ only in hyphens and spaces, and the termSynonyms that occur in
+// more than one form. The most used groups come first.
+func termCandidates(docs []TermDocument) [][]string {
+	counts := map[string]int{}
+	var phrases []map[string]int
+	for _, d := range docs {
+		text := strings.ToLower(d.Text)
+		words := termWordRe.FindAllStringIndex(text, -1)
+		p := map[string]int{}
+		for i, m := range words {
+			counts[text[m[0]:m[1]]]++
+			// two words with only spaces between them
+			if i+1 < len(words) && strings.TrimSpace(text[m[1]:words[i+1][0]]) == "" {
+				p[text[m[0]:m[1]]+" "+text[words[i+1][0]:words[i+1][1]]]++
+			}
+		}
+		phrases = append(phrases, p)
+	}
+	squeeze := strings.NewReplacer("-", "", " ", "")
+	byKey := map[string]map[string]int{}
+	add := func(key, form string, n int) {
+		if byKey[key] == nil {
+			byKey[key] = map[string]int{}
+		}
+		byKey[key][form] += n
+	}
+	for form, n := range counts {
+		add(squeeze.Replace(form), form, n)
+	}
+	// phrases only count where they might be a spelling of a word
+	for _, p := range phrases {
+		for form, n := range p {
+			if key := squeeze.Replace(form); byKey[key] != nil {
+				add(key, form, n)
+			}
+		}
+	}
+	for i, syns := range termSynonyms {
+		for _, s := range syns {
+			if n := counts[s]; n > 0 && !strings.Contains(s, " ") {
+				add(fmt.Sprint("\x00", i), s, n)
+			}
+			for _, p := range phrases {
+				if n := p[s]; n > 0 {
+					add(fmt.Sprint("\x00", i), s, n)
+				}
+			}
+		}
+	}
+
+	type group struct {
+		forms []string
+		total int
+	}
+	var groups []group
+	for _, forms := range byKey {
+		if len(forms) < 2 {
+			continue
+		}
+		g := group{}
+		for f, n := range forms {
+			g.forms = append(g.forms, f)
+			g.total += n
+		}
+		sort.Slice(g.forms, func(i, j int) bool {
+			if forms[g.forms[i]] != forms[g.forms[j]] {
+				return forms[g.forms[i]] > forms[g.forms[j]]
+			}
+			return g.forms[i] < g.forms[j]
+		})
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].total != groups[j].total {
+			return groups[i].total > groups[j].total
+		}
+		return groups[i].forms[0] < groups[j].forms[0]
+	})
+	// a group within another one (e.g. "login" and "log in" within
+	// "sign in", "log in" and "login") is left out
+	within := func(a, b []string) bool {
+		if len(a) >= len(b) {
+			return false
+		}
+		for _, f := range a {
+			if !slices.Contains(b, f) {
+				return false
+			}
+		}
+		return true
+	}
+	out := [][]string{}
+	for _, g := range groups {
+		if len(out) == maxTermCandidates {
+			break
+		}
+		if !slices.ContainsFunc(groups, func(o group) bool { return within(g.forms, o.forms) }) {
+			out = append(out, g.forms)
+		}
+	}
+	return out
+}
+
+// termGroup counts the forms in the documents, as whole words and
+// regardless of case, and reports them as a group if more than one is
+// used. Without a canonical form, the most used one is suggested.
+func termGroup(docs []TermDocument, forms []string, canonical, reason, source string) (TermGroup, bool) {
+	g := TermGroup{Canonical: strings.TrimSpace(canonical), Reason: reason, Source: source, Variants: []TermVariant{}}
+	seen := map[string]bool{}
+	for _, f := range forms {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" || seen[f] {
+			continue
+		}
+		seen[f] = true
+		re, err := regexp.Compile(`(?i)(^|[^\pL\pN-])` + strings.ReplaceAll(regexp.QuoteMeta(f), " ", `\s+`) + `($|[^\pL\pN-])`)
+		if err != nil {
+			continue
+		}
+		v := TermVariant{Form: f, Documents: []string{}}
+		for _, d := range docs {
+			// the boundaries are matched too, so count overlapping
+			// matches by hand
+			n := 0
+			for rest := d.Text; ; {
+				m := re.FindStringSubmatchIndex(rest)
+				if m == nil {
+					break
+				}
+				n++
+				rest = rest[m[4]:]
+			}
+			if n > 0 {
+				v.Count += n
+				v.Documents = append(v.Documents, d.Name)
+			}
+		}
+		if v.Count > 0 {
+			g.Variants = append(g.Variants, v)
+		}
+	}
+	if len(g.Variants) < 2 {
+		return TermGroup{}, false
+	}
+	sort.SliceStable(g.Variants, func(i, j int) bool { return g.Variants[i].Count > g.Variants[j].Count })
+	if g.Canonical == "" {
+		g.Canonical = g.Variants[0].Form
+		g.Reason = "most used form"
+	}
+	return g, true
+}
@@ -0,0 +1,39 @@
+package texttools
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DefaultAzureAPIVersion is the Azure OpenAI API version used if none is
+// configured.
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// AzureConfig points an OpenAI provider at Azure OpenAI. Azure serves each
+// model under a deployment of the resource, named by its owner, and
+// authenticates with an api-key header instead of a bearer token. The
+// model of a request, if any, names the deployment.
+type AzureConfig struct {
+	Endpoint            string // the resource's endpoint, e.g. https://myresource.openai.azure.com
+	Deployment          string // chat deployment of requests that name no model
+	EmbeddingDeployment string // for Embed; none if empty
+	APIVersion          string // DefaultAzureAPIVersion if empty
+}
+
+// url returns the URL of an API path ("/chat/completions") of a deployment.
+func (a *AzureConfig) url(deployment, path string) string {
+	return strings.TrimSuffix(a.Endpoint, "/") + "/openai/deployments/" + url.PathEscape(deployment) + path + a.query()
+}
+
+// modelsURL returns the URL listing the models of the resource.
+func (a *AzureConfig) modelsURL() string {
+	return strings.TrimSuffix(a.Endpoint, "/") + "/openai/models" + a.query()
+}
+
+func (a *AzureConfig) query() string {
+	version := a.APIVersion
+	if version == "" {
+		version = DefaultAzureAPIVersion
+	}
+	return "?api-version=" + url.QueryEscape(version)
+}
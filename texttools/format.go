@@ -0,0 +1,255 @@
+package texttools
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// --- Input / output formats ---
+
+// Text formats, for Options.InputFormat and Options.OutputFormat.
+const (
+	FormatPlain    = "plain"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+func validFormat(f string) bool {
+	return f == "" || f == FormatPlain || f == FormatMarkdown || f == FormatHTML
+}
+
+// PrepareInput converts the submitted text to what gets sent to the model:
+// HTML is reduced to its visible text, plain text and Markdown pass through.
+func PrepareInput(text, format string) string {
+	if format == FormatHTML {
+		body, _, _ := HTMLText([]byte(text))
+		return body
+	}
+	return text
+}
+
+// FormatOutput enforces the requested output format on a text result. The
+// model is always asked for Markdown (or plain text); HTML is rendered here
+// so no model-written markup reaches the caller.
+func FormatOutput(s, format string) string {
+	switch format {
+	case FormatPlain:
+		return stripMarkdown(s)
+	case FormatHTML:
+		return markdownToHTML(s)
+	default:
+		return s
+	}
+}
+
+var (
+	mdFenceRe    = regexp.MustCompile("^\\s*```")
+	mdHeadingRe  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdBulletRe   = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdOrderedRe  = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdCodeRe     = regexp.MustCompile("`([^`]+)`")
+	mdBoldRe     = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	mdStarEmRe   = regexp.MustCompile(`(^|[^*\w])\*([^*\s][^*]*?)\*`)
+	mdUnderEmRe  = regexp.MustCompile(`(^|[^_\w])_([^_\s][^_]*?)_`)
+	mdLinkRe     = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	mdHTMLTagRe  = regexp.MustCompile(`</?[a-zA-Z][^>]*>`)
+	mdHeadingPfx = regexp.MustCompile(`^#{1,6}\s+`)
+)
+
+// stripMarkdown turns Markdown into readable plain text: markers are
+// removed, bullets normalized to "- " and links become "text (url)".
+func stripMarkdown(s string) string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		if mdFenceRe.MatchString(line) {
+			continue
+		}
+		line = mdHeadingPfx.ReplaceAllString(line, "")
+		if m := mdBulletRe.FindStringSubmatch(line); m != nil {
+			line = "- " + m[1]
+		}
+		line = mdLinkRe.ReplaceAllString(line, "$1 ($2)")
+		line = mdBoldRe.ReplaceAllString(line, "$1$2")
+		line = mdStarEmRe.ReplaceAllString(line, "$1$2")
+		line = mdUnderEmRe.ReplaceAllString(line, "$1$2")
+		line = mdCodeRe.ReplaceAllString(line, "$1")
+		line = mdHTMLTagRe.ReplaceAllString(line, "")
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// markdownToHTML renders the common subset of Markdown models produce
+// (headings, lists, paragraphs, code, emphasis, links). All text is escaped.
+func markdownToHTML(s string) string {
+	var (
+		sb     strings.Builder
+		para   []string
+		list   string // "ul", "ol" or ""
+		inCode bool
+	)
+	flushPara := func() {
+		if len(para) > 0 {
+			sb.WriteString("<p>" + strings.Join(para, "<br>\n") + "</p>\n")
+			para = nil
+		}
+	}
+	closeList := func() {
+		if list != "" {
+			sb.WriteString("</" + list + ">\n")
+			list = ""
+		}
+	}
+	openList := func(kind string) {
+		if list != kind {
+			closeList()
+			sb.WriteString("<" + kind + ">\n")
+			list = kind
+		}
+	}
+
+	for _, line := range strings.Split(s, "\n") {
+		if mdFenceRe.MatchString(line) {
+			flushPara()
+			closeList()
+			if inCode {
+				sb.WriteString("</code></pre>\n")
+			} else {
+				sb.WriteString("<pre><code>")
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			sb.WriteString(html.EscapeString(line) + "\n")
+			continue
+		}
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			flushPara()
+			closeList()
+		case mdHeadingRe.MatchString(line):
+			flushPara()
+			closeList()
+			m := mdHeadingRe.FindStringSubmatch(line)
+			n := len(m[1])
+			fmt.Fprintf(&sb, "<h%d>%s</h%d>\n", n, inlineMarkdown(m[2]), n)
+		case mdBulletRe.MatchString(line):
+			flushPara()
+			openList("ul")
+			sb.WriteString("<li>" + inlineMarkdown(mdBulletRe.FindStringSubmatch(line)[1]) + "</li>\n")
+		case mdOrderedRe.MatchString(line):
+			flushPara()
+			openList("ol")
+			sb.WriteString("<li>" + inlineMarkdown(mdOrderedRe.FindStringSubmatch(line)[1]) + "</li>\n")
+		default:
+			closeList()
+			para = append(para, inlineMarkdown(strings.TrimSpace(line)))
+		}
+	}
+	flushPara()
+	closeList()
+	if inCode {
+		sb.WriteString("</code></pre>\n")
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func inlineMarkdown(s string) string {
+	s = html.EscapeString(s)
+	s = mdCodeRe.ReplaceAllString(s, "<code>$1</code>")
+	s = mdLinkRe.ReplaceAllStringFunc(s, func(m string) string {
+		parts := mdLinkRe.FindStringSubmatch(m)
+		if !isHTTPURL(html.UnescapeString(parts[2])) {
+			return parts[1]
+		}
+		return `<a href="` + parts[2] + `">` + parts[1] + `</a>`
+	})
+	s = mdBoldRe.ReplaceAllString(s, "<strong>$1$2</strong>")
+	s = mdStarEmRe.ReplaceAllString(s, "$1<em>$2</em>")
+	s = mdUnderEmRe.ReplaceAllString(s, "$1<em>$2</em>")
+	return s
+}
+
+// --- HTML ---
+
+var (
+	htmlDropRes = []*regexp.Regexp{
+		regexp.MustCompile(`(?is)<!--.*?-->`),
+		regexp.MustCompile(`(?is)<script\b.*?</script\s*>`),
+		regexp.MustCompile(`(?is)<style\b.*?</style\s*>`),
+		regexp.MustCompile(`(?is)<noscript\b.*?</noscript\s*>`),
+		regexp.MustCompile(`(?is)<template\b.*?</template\s*>`),
+		regexp.MustCompile(`(?is)<svg\b.*?</svg\s*>`),
+	}
+	htmlTitleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title\s*>`)
+	htmlMetaRe  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	htmlAttrRe  = regexp.MustCompile(`(?is)([a-z:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	htmlMainRe  = regexp.MustCompile(`(?is)<main\b[^>]*>(.*?)</main\s*>`)
+	htmlBlockRe = regexp.MustCompile(`(?i)</?(?:p|div|br|li|h[1-6]|tr|section|article|header|footer|nav|ul|ol|table|blockquote|pre|hr)\b[^>]*>`)
+	htmlTagRe   = regexp.MustCompile(`(?s)<[^>]*>`)
+	spacesRe    = regexp.MustCompile(`[ \t\f\v\r]+`)
+)
+
+// HTMLText returns the visible text of an HTML page (preferring its <main>
+// element when present) together with its title and meta description.
+func HTMLText(data []byte) (text, title, description string) {
+	s := string(data)
+	for _, re := range htmlDropRes {
+		s = re.ReplaceAllString(s, " ")
+	}
+
+	if m := htmlTitleRe.FindStringSubmatch(s); m != nil {
+		title = collapseSpaces(html.UnescapeString(m[1]))
+	}
+	for _, tag := range htmlMetaRe.FindAllString(s, -1) {
+		attrs := map[string]string{}
+		for _, a := range htmlAttrRe.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(a[1])] = a[2] + a[3]
+		}
+		if strings.EqualFold(attrs["name"], "description") {
+			description = collapseSpaces(html.UnescapeString(attrs["content"]))
+			break
+		}
+	}
+
+	body := s
+	if m := htmlMainRe.FindStringSubmatch(s); m != nil {
+		body = m[1]
+	} else {
+		body = htmlTitleRe.ReplaceAllString(body, " ")
+	}
+	body = htmlBlockRe.ReplaceAllString(body, "\n")
+	body = htmlTagRe.ReplaceAllString(body, "")
+	body = html.UnescapeString(body)
+
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spacesRe.ReplaceAllString(line, " "))
+	}
+	return cleanText(strings.Join(lines, "\n")), title, description
+}
+
+func collapseSpaces(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+var blankLinesRe = regexp.MustCompile(`\n{3,}`)
+
+// cleanText trims trailing spaces and collapses runs of blank lines.
+func cleanText(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.TrimSpace(blankLinesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
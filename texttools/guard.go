@@ -0,0 +1,83 @@
+package texttools
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// --- Prompt injection guard ---
+//
+// User text reaches the model in the same prompt as the instructions, so a
+// text saying "ignore the previous instructions and ..." can take them over.
+// With Tools.Guard set, the Text of every prompt is sanitized before the
+// template is rendered: phrases matching InjectionPatterns are replaced by
+// "[removed]" and the text is wrapped in <user_text> tags (tags inside it
+// are dropped), which the system prompt tells the model to treat as data.
+
+// The delimiters of the user's text in guarded prompts.
+const (
+	UserTextOpen  = "<user_text>"
+	UserTextClose = "</user_text>"
+)
+
+// InjectionPatterns match instruction-like phrases aimed at the model.
+var InjectionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(?:ignore|disregard|forget|override|skip)\s+(?:all\s+|any\s+)?(?:of\s+)?(?:the\s+|your\s+|these\s+)?(?:previous|prior|above|earlier|preceding|original|system)\s+(?:instructions?|prompts?|rules|directions|guidelines|messages?)`),
+	regexp.MustCompile(`(?i)\b(?:reveal|print|show|repeat|output)\s+(?:me\s+)?(?:your|the)\s+(?:system\s+)?(?:prompt|instructions)\b`),
+	regexp.MustCompile(`(?i)\b(?:new|updated|real)\s+(?:system\s+)?instructions?\s*:`),
+	regexp.MustCompile(`(?i)\b(?:enter|enable|switch to)\s+(?:developer|jailbreak|dan|god)\s+mode\b`),
+	regexp.MustCompile(`(?i)<\|?(?:im_start|im_end|system|endoftext)\|?>`),
+}
+
+var userTextTagRe = regexp.MustCompile(`(?i)</?\s*user_text\s*>`)
+
+// SanitizeUserText removes the phrases matching InjectionPatterns from
+// text and wraps it in the user text delimiters. It also returns how many
+// phrases were removed.
+func SanitizeUserText(text string) (string, int) {
+	text = userTextTagRe.ReplaceAllString(text, "")
+	removed := 0
+	for _, re := range InjectionPatterns {
+		text = re.ReplaceAllStringFunc(text, func(string) string {
+			removed++
+			return "[removed]"
+		})
+	}
+	return UserTextOpen + "\n" + text + "\n" + UserTextClose, removed
+}
+
+// UserTexts returns the delimited user texts of a guarded prompt.
+func UserTexts(prompt string) []string {
+	var texts []string
+	for {
+		_, rest, ok := strings.Cut(prompt, UserTextOpen+"\n")
+		if !ok {
+			return texts
+		}
+		text, after, ok := strings.Cut(rest, "\n"+UserTextClose)
+		if !ok {
+			return texts
+		}
+		texts = append(texts, text)
+		prompt = after
+	}
+}
+
+// guard returns a copy of template data whose Text field, if it has one
+// (prompt inputs embed Input), is sanitized.
+func guard(data interface{}) interface{} {
+	v := reflect.ValueOf(data)
+	if v.Kind() != reflect.Struct {
+		return data
+	}
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	f := c.FieldByName("Text")
+	if !f.IsValid() || f.Kind() != reflect.String || !f.CanSet() || f.String() == "" {
+		return data
+	}
+	text, _ := SanitizeUserText(f.String())
+	f.SetString(text)
+	return c.Interface()
+}
@@ -0,0 +1,95 @@
+package texttools
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPClientConfig tunes the HTTP client of a provider. Zero fields take
+// the defaults of DefaultHTTPClientConfig.
+type HTTPClientConfig struct {
+	// Timeout bounds a whole request, reading the response (or stream)
+	// included. It is a backstop for hung connections: callers should
+	// bound their calls with contexts.
+	Timeout               time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration // from sending the request to the response headers
+	IdleConnTimeout       time.Duration // how long idle connections are kept for reuse
+	MaxIdleConnsPerHost   int           // idle connections kept per host
+	MaxConnsPerHost       int           // connections per host; unlimited if 0
+	DisableHTTP2          bool
+	// Proxy is the proxy URL for all requests; if nil, HTTPS_PROXY,
+	// HTTP_PROXY and NO_PROXY apply.
+	Proxy *url.URL
+}
+
+// DefaultHTTPClientConfig are the defaults of HTTPClientConfig. Responses
+// of chat completions only start once the model is done (or, streamed,
+// once it starts), so the header timeout is generous.
+var DefaultHTTPClientConfig = HTTPClientConfig{
+	Timeout:               10 * time.Minute,
+	DialTimeout:           10 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ResponseHeaderTimeout: 5 * time.Minute,
+	IdleConnTimeout:       90 * time.Second,
+	MaxIdleConnsPerHost:   16,
+}
+
+// defaultClient is the client of providers that have none.
+var defaultClient = NewHTTPClient(HTTPClientConfig{})
+
+// WithDefaults returns c with its zero fields set to the defaults.
+func (c HTTPClientConfig) WithDefaults() HTTPClientConfig {
+	d := DefaultHTTPClientConfig
+	if c.Timeout == 0 {
+		c.Timeout = d.Timeout
+	}
+	if c.DialTimeout == 0 {
+		c.DialTimeout = d.DialTimeout
+	}
+	if c.TLSHandshakeTimeout == 0 {
+		c.TLSHandshakeTimeout = d.TLSHandshakeTimeout
+	}
+	if c.ResponseHeaderTimeout == 0 {
+		c.ResponseHeaderTimeout = d.ResponseHeaderTimeout
+	}
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = d.IdleConnTimeout
+	}
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = d.MaxIdleConnsPerHost
+	}
+	return c
+}
+
+// NewHTTPClient returns a client with a keep-alive connection pool, HTTP/2
+// (unless disabled) and the timeouts of c.
+func NewHTTPClient(c HTTPClientConfig) *http.Client {
+	c = c.WithDefaults()
+	proxy := http.ProxyFromEnvironment
+	if c.Proxy != nil {
+		proxy = http.ProxyURL(c.Proxy)
+	}
+	dialer := &net.Dialer{Timeout: c.DialTimeout, KeepAlive: 30 * time.Second}
+	t := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     !c.DisableHTTP2,
+		TLSHandshakeTimeout:   c.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		MaxIdleConns:          max(100, c.MaxIdleConnsPerHost),
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+	}
+	if c.DisableHTTP2 {
+		// a non-nil, empty map turns HTTP/2 off
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return &http.Client{Transport: t, Timeout: c.Timeout}
+}
@@ -0,0 +1,132 @@
+package texttools
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// --- Word limits ---
+//
+// Options.MaxWords caps the length of results. The system prompt asks the
+// model to keep to it, but models often run over, so text results are
+// checked afterwards (FitWords): one that is too long is shortened by the
+// model, up to maxShortenPasses times, and whatever is still too long is
+// cut after the last sentence that fits.
+
+const maxShortenPasses = 2
+
+// CountWords counts the words of a result in the given output format;
+// Markdown bullets and other punctuation-only tokens are not words.
+func CountWords(text, format string) int {
+	if format == FormatHTML {
+		text, _, _ = HTMLText([]byte(text))
+	}
+	n := 0
+	for _, f := range strings.Fields(text) {
+		if isWord(f) {
+			n++
+		}
+	}
+	return n
+}
+
+func isWord(f string) bool {
+	return strings.IndexFunc(f, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) >= 0
+}
+
+// FitWords returns a Markdown or plain text result within opts.MaxWords
+// words (unchanged if MaxWords is 0 or it fits). The model shortens it
+// first; if that fails or doesn't get it short enough, it is cut with
+// CutWords.
+func (t *Tools) FitWords(ctx context.Context, text string, opts Options) string {
+	if opts.MaxWords <= 0 {
+		return text
+	}
+	opts.InputFormat = FormatMarkdown
+	for i := 0; i < maxShortenPasses && CountWords(text, "") > opts.MaxWords; i++ {
+		out, err := t.Prompt(ctx, "shorten", Input{Text: text, Options: opts}, opts)
+		if err != nil || strings.TrimSpace(out) == "" {
+			break
+		}
+		text = strings.TrimSpace(out)
+	}
+	return CutWords(text, opts.MaxWords)
+}
+
+// sentenceEndRe matches the end of a sentence inside a line.
+var sentenceEndRe = regexp.MustCompile(`[.!?…]["'”’)\]]*\s+`)
+
+// CutWords cuts a Markdown or plain text to at most max words: whole lines
+// while they fit, then the sentences of the next line that fit. When not
+// even the first sentence fits, it is cut after max words and ends in "…".
+// Headings left without content at the end are dropped.
+func CutWords(text string, max int) string {
+	if max <= 0 || CountWords(text, "") <= max {
+		return text
+	}
+	var kept []string
+	left := max
+	for _, line := range strings.Split(text, "\n") {
+		n := CountWords(line, "")
+		if n <= left {
+			kept = append(kept, line)
+			left -= n
+			continue
+		}
+		if part := cutLine(line, left, !hasContent(kept)); part != "" {
+			kept = append(kept, part)
+		}
+		break
+	}
+	for len(kept) > 0 && !hasContent(kept[len(kept)-1:]) {
+		kept = kept[:len(kept)-1]
+	}
+	return strings.Join(kept, "\n")
+}
+
+// cutLine keeps the sentences of line that fit in max words; with force,
+// it cuts mid-sentence rather than return nothing.
+func cutLine(line string, max int, force bool) string {
+	if max <= 0 {
+		return ""
+	}
+	end, words := 0, 0
+	for _, loc := range sentenceEndRe.FindAllStringIndex(line+" ", -1) {
+		n := CountWords(line[end:min(loc[1], len(line))], "")
+		if words+n > max {
+			break
+		}
+		end, words = min(loc[1], len(line)), words+n
+	}
+	if end > 0 {
+		return strings.TrimRight(line[:end], " ")
+	}
+	if !force {
+		return ""
+	}
+	// the first sentence alone is too long: cut it after max words
+	var b strings.Builder
+	words = 0
+	for _, f := range strings.SplitAfter(line, " ") {
+		if isWord(f) {
+			if words == max {
+				break
+			}
+			words++
+		}
+		b.WriteString(f)
+	}
+	return strings.TrimRight(b.String(), " ,;:—–-") + "…"
+}
+
+// hasContent reports whether lines have text other than headings.
+func hasContent(lines []string) bool {
+	for _, l := range lines {
+		if l = strings.TrimSpace(l); l != "" && !mdHeadingPfx.MatchString(l) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package texttools
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Mock is an offline StreamProvider for tests and demos. Its output is
+// derived from the prompt alone, so the same request always gets the same
+// answer: prompts asking for a JSON array get an array of words from the
+// input, others a short text quoting it.
+type Mock struct {
+	Latency    time.Duration // delay before each answer
+	ErrorEvery int           // fail every nth call with a 503 StatusError; never if 0
+
+	calls atomic.Int64
+}
+
+func (m *Mock) Complete(ctx context.Context, req Request) (Completion, error) {
+	if err := m.wait(ctx); err != nil {
+		return Completion{}, err
+	}
+	text := mockAnswer(req.Prompt)
+	return Completion{Text: text, Usage: mockUsage(req, text), FinishReason: "stop"}, nil
+}
+
+// Stream delivers the answer word by word.
+func (m *Mock) Stream(ctx context.Context, req Request, onDelta func(string) error) (Completion, error) {
+	if err := m.wait(ctx); err != nil {
+		return Completion{}, err
+	}
+	text := mockAnswer(req.Prompt)
+	for _, piece := range strings.SplitAfter(text, " ") {
+		if err := onDelta(piece); err != nil {
+			return Completion{}, err
+		}
+	}
+	return Completion{Text: text, Usage: mockUsage(req, text), FinishReason: "stop"}, nil
+}
+
+func (m *Mock) wait(ctx context.Context) error {
+	n := m.calls.Add(1)
+	if m.Latency > 0 {
+		t := time.NewTimer(m.Latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if m.ErrorEvery > 0 && n%int64(m.ErrorEvery) == 0 {
+		return &StatusError{Status: http.StatusServiceUnavailable, Body: "mock: simulated error"}
+	}
+	return nil
+}
+
+// mockAnswer builds the canned answer. The input is taken to be the last
+// paragraph of the prompt, which is where the templates put it.
+func mockAnswer(prompt string) string {
+	input := strings.TrimSpace(prompt)
+	if i := strings.LastIndex(input, "\n\n"); i >= 0 {
+		input = input[i+2:]
+	}
+	input = strings.TrimPrefix(strings.TrimSpace(input), "Text:")
+	words := strings.Fields(input)
+
+	if strings.Contains(prompt, "JSON array") {
+		var items []string
+		seen := map[string]bool{}
+		for _, w := range words {
+			w = strings.ToLower(strings.TrimFunc(w, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }))
+			if len([]rune(w)) < 4 || seen[w] {
+				continue
+			}
+			seen[w] = true
+			items = append(items, fmt.Sprintf("%q", w))
+			if len(items) == 5 {
+				break
+			}
+		}
+		if len(items) == 0 {
+			items = []string{`"mock"`}
+		}
+		return "[" + strings.Join(items, ",") + "]"
+	}
+
+	quote := words
+	if len(quote) > 12 {
+		quote = append(quote[:12:12], "...")
+	}
+	return fmt.Sprintf("Mock response to %d words of input: %s", len(words), strings.Join(quote, " "))
+}
+
+// mockDimensions is the size of the mock's embedding vectors.
+const mockDimensions = 64
+
+// Embed hashes the words of each text into a bag-of-words vector, so that
+// texts sharing words come out similar.
+func (m *Mock) Embed(ctx context.Context, texts []string) ([][]float32, Usage, error) {
+	if err := m.wait(ctx); err != nil {
+		return nil, Usage{}, err
+	}
+	var usage Usage
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, mockDimensions)
+		for _, w := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.TrimFunc(w, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })))
+			v[h.Sum32()%mockDimensions]++
+			usage.PromptTokens++
+		}
+		vectors[i] = v
+	}
+	return vectors, usage, nil
+}
+
+// ListModels lists the mock's one model.
+func (m *Mock) ListModels(ctx context.Context) ([]string, error) {
+	return []string{"mock"}, nil
+}
+
+// mockUsage counts words as tokens.
+func mockUsage(req Request, text string) Usage {
+	return Usage{
+		PromptTokens:     len(strings.Fields(req.System)) + len(strings.Fields(req.Prompt)),
+		CompletionTokens: len(strings.Fields(text)),
+	}
+}
@@ -0,0 +1,109 @@
+package texttools
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mockInput = "Go is an open source programming language. Go makes it simple to build secure, scalable systems."
+
+func TestMockOperations(t *testing.T) {
+	ctx := context.Background()
+	text := "Mock response to 16 words of input: Go is an open source programming language. Go makes it simple to ..."
+	texts := map[string]func(*Tools) (string, error){
+		"summarize":  func(t *Tools) (string, error) { return t.Summarize(ctx, mockInput, Options{}) },
+		"rewrite":    func(t *Tools) (string, error) { return t.Rewrite(ctx, mockInput, "formal", Options{}) },
+		"expand":     func(t *Tools) (string, error) { return t.Expand(ctx, mockInput, Options{}) },
+		"to-bullets": func(t *Tools) (string, error) { return t.ToBullets(ctx, mockInput, Options{}) },
+		"to-prose":   func(t *Tools) (string, error) { return t.ToProse(ctx, mockInput, Options{}) },
+	}
+	for op, run := range texts {
+		for i := 0; i < 2; i++ {
+			if got, err := run(New(&Mock{})); err != nil || got != text {
+				t.Errorf("%s, run %d: %q, %v", op, i+1, got, err)
+			}
+		}
+	}
+
+	words := []string{"open", "source", "programming", "language", "makes"}
+	lists := map[string]func(*Tools) ([]string, error){
+		"keywords":  func(t *Tools) ([]string, error) { return t.Keywords(ctx, mockInput, Options{}) },
+		"questions": func(t *Tools) ([]string, error) { return t.Questions(ctx, mockInput, Options{}) },
+		"titles":    func(t *Tools) ([]string, error) { return t.Titles(ctx, mockInput, Options{}) },
+	}
+	for op, run := range lists {
+		for i := 0; i < 2; i++ {
+			if got, err := run(New(&Mock{})); err != nil || !reflect.DeepEqual(got, words) {
+				t.Errorf("%s, run %d: %q, %v", op, i+1, got, err)
+			}
+		}
+	}
+
+	if got, _ := Keywords(ctx, &Mock{}, "a b c", Options{}); !reflect.DeepEqual(got, []string{"mock"}) {
+		t.Errorf("keywords of short words: %q", got)
+	}
+}
+
+func TestMockStream(t *testing.T) {
+	m := &Mock{}
+	req := Request{System: "be brief", Prompt: "Summarize this.\n\nOne two three"}
+	want, err := m.Complete(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deltas []string
+	got, err := m.Stream(context.Background(), req, func(d string) error {
+		deltas = append(deltas, d)
+		return nil
+	})
+	if err != nil || got != want || strings.Join(deltas, "") != want.Text || len(deltas) != len(strings.Fields(want.Text)) {
+		t.Errorf("streamed %q as %q, %v; complete %+v", got, deltas, err, want)
+	}
+	if want.Usage != (Usage{PromptTokens: 7, CompletionTokens: 10}) || want.FinishReason != "stop" {
+		t.Errorf("usage %+v, finish reason %q", want.Usage, want.FinishReason)
+	}
+
+	stop := errors.New("stop")
+	if _, err := m.Stream(context.Background(), req, func(string) error { return stop }); err != stop {
+		t.Errorf("onDelta error: %v", err)
+	}
+}
+
+func TestMockErrors(t *testing.T) {
+	m := &Mock{ErrorEvery: 3}
+	for i := 1; i <= 6; i++ {
+		_, err := m.Complete(context.Background(), Request{Prompt: "x"})
+		if fail := i%3 == 0; fail != (err != nil) {
+			t.Errorf("call %d: %v", i, err)
+		}
+		if err != nil && !Unavailable(err) {
+			t.Errorf("call %d: %v is not Unavailable", i, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err := (&Mock{Latency: time.Minute}).Complete(ctx, Request{}); err != context.DeadlineExceeded {
+		t.Errorf("with latency past the deadline: %v", err)
+	}
+}
+
+func TestMockEmbed(t *testing.T) {
+	m := &Mock{}
+	texts := []string{"Go is fun.", "go IS fun", "Rust is fast"}
+	a, usage, err := m.Embed(context.Background(), texts)
+	if err != nil || usage.PromptTokens != 9 {
+		t.Fatalf("usage %+v, %v", usage, err)
+	}
+	b, _, _ := m.Embed(context.Background(), texts)
+	if !reflect.DeepEqual(a, b) {
+		t.Error("embeddings differ between calls")
+	}
+	if len(a[0]) != mockDimensions || !reflect.DeepEqual(a[0], a[1]) || reflect.DeepEqual(a[0], a[2]) {
+		t.Errorf("vectors %v", a)
+	}
+}
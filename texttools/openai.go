@@ -0,0 +1,522 @@
+package texttools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	OpenAIURL    = "https://api.openai.com/v1/chat/completions"
+	DefaultModel = "gpt-4o-mini"
+
+	OpenAIModerationURL    = "https://api.openai.com/v1/moderations"
+	DefaultModerationModel = "omni-moderation-latest"
+
+	OpenAIEmbeddingsURL   = "https://api.openai.com/v1/embeddings"
+	DefaultEmbeddingModel = "text-embedding-3-small"
+
+	OpenAIModelsURL = "https://api.openai.com/v1/models"
+)
+
+// OpenAI is a StreamProvider for the OpenAI chat completions API (or a
+// compatible one, such as llama.cpp, vLLM or LM Studio).
+type OpenAI struct {
+	APIKey string       // no Authorization header if empty, for local servers
+	URL    string       // OpenAIURL if empty
+	Model  string       // used when a request names none; DefaultModel if empty
+	Client *http.Client // NewHTTPClient's defaults if nil
+
+	EmbeddingModel string // DefaultEmbeddingModel if empty
+
+	// Azure, if set, sends the requests to Azure OpenAI instead; URL and
+	// Model are not used then.
+	Azure *AzureConfig
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []interface{} `json:"messages"` // chatMessage, or imageMessage with images
+	Stream   bool          `json:"stream,omitempty"`
+
+	Params // same JSON names as the API's
+
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+// imageMessage is a user message with images, as content parts.
+type imageMessage struct {
+	Role    string        `json:"role"`
+	Content []contentPart `json:"content"`
+}
+
+type contentPart struct {
+	Type     string    `json:"type"` // text or image_url
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"` // a data: URL
+}
+
+// streamOptions asks for token usage in the last chunk of a stream.
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message      chatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage Usage           `json:"usage"` // zero if the server leaves it out
+	Error json.RawMessage `json:"error"` // some compatible servers fail with a 200
+}
+
+// chatStreamChunk is one server-sent event of a streamed completion.
+type chatStreamChunk struct {
+	Choices []struct {
+		Delta        chatMessage `json:"delta"`
+		FinishReason *string     `json:"finish_reason"` // in the last chunk with choices
+	} `json:"choices"`
+	Usage *Usage          `json:"usage"`
+	Error json.RawMessage `json:"error"` // vLLM and llama.cpp report errors mid-stream
+}
+
+func (o *OpenAI) Complete(ctx context.Context, req Request) (Completion, error) {
+	resp, err := o.do(ctx, req, false)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	var cr chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
+		return Completion{}, err
+	}
+	if len(cr.Choices) == 0 && hasError(cr.Error) {
+		return Completion{}, embeddedError(cr.Error)
+	}
+	if len(cr.Choices) == 0 {
+		return Completion{Usage: cr.Usage}, errors.New("no choices from LLM")
+	}
+	choice := cr.Choices[0]
+	if choice.FinishReason == "content_filter" && choice.Message.Content == "" {
+		return Completion{Usage: cr.Usage}, errContentFiltered
+	}
+	return Completion{Text: choice.Message.Content, Usage: cr.Usage, FinishReason: choice.FinishReason}, nil
+}
+
+// errContentFiltered is a completion the provider's content filter
+// withheld (Azure OpenAI), reported like a filtered prompt.
+var errContentFiltered = &StatusError{Status: http.StatusBadRequest, Message: "the output was blocked by the provider's content filter", Code: "content_filter"}
+
+func (o *OpenAI) Stream(ctx context.Context, req Request, onDelta func(string) error) (Completion, error) {
+	resp, err := o.do(ctx, req, true)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	var (
+		full   strings.Builder
+		usage  Usage
+		finish string
+	)
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimPrefix(data, " ")
+		if data == "[DONE]" {
+			break
+		}
+		var chunk chatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return Completion{Text: full.String(), Usage: usage}, err
+		}
+		if hasError(chunk.Error) {
+			return Completion{Text: full.String(), Usage: usage}, embeddedError(chunk.Error)
+		}
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+		for _, c := range chunk.Choices {
+			if c.FinishReason != nil {
+				finish = *c.FinishReason
+			}
+			if c.Delta.Content == "" {
+				continue
+			}
+			full.WriteString(c.Delta.Content)
+			if err := onDelta(c.Delta.Content); err != nil {
+				return Completion{Text: full.String(), Usage: usage}, err
+			}
+		}
+	}
+	return Completion{Text: full.String(), Usage: usage, FinishReason: finish}, sc.Err()
+}
+
+type moderationRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+type moderationResponse struct {
+	Results []struct {
+		Flagged        bool               `json:"flagged"`
+		Categories     map[string]bool    `json:"categories"`
+		CategoryScores map[string]float64 `json:"category_scores"`
+	} `json:"results"`
+}
+
+type embeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage Usage `json:"usage"`
+}
+
+// Embed embeds texts with the embeddings endpoint next to the chat
+// completions URL (OpenAIEmbeddingsURL by default).
+func (o *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, Usage, error) {
+	model := o.EmbeddingModel
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+	url := o.endpoint(OpenAIEmbeddingsURL, "/embeddings")
+	if o.Azure != nil {
+		if o.Azure.EmbeddingDeployment == "" {
+			return nil, Usage{}, errors.New("no Azure OpenAI embedding deployment configured")
+		}
+		url = o.Azure.url(o.Azure.EmbeddingDeployment, "/embeddings")
+	}
+	var er embeddingResponse
+	if err := o.send(ctx, "POST", url, embeddingRequest{Model: model, Input: texts}, &er); err != nil {
+		return nil, Usage{}, err
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range er.Data {
+		if d.Index >= 0 && d.Index < len(vectors) {
+			vectors[d.Index] = d.Embedding
+		}
+	}
+	for _, v := range vectors {
+		if v == nil {
+			return nil, er.Usage, errors.New("missing embeddings in response")
+		}
+	}
+	return vectors, er.Usage, nil
+}
+
+// chatURL returns the chat completions URL; with Azure, that of the
+// deployment named by model, or the default one.
+func (o *OpenAI) chatURL(model string) string {
+	if o.Azure != nil {
+		if model == "" {
+			model = o.Azure.Deployment
+		}
+		return o.Azure.url(model, "/chat/completions")
+	}
+	if o.URL == "" {
+		return OpenAIURL
+	}
+	return o.URL
+}
+
+// authorize sets the API key on hr: a bearer token, or Azure's api-key
+// header.
+func (o *OpenAI) authorize(hr *http.Request) {
+	switch {
+	case o.Azure != nil:
+		hr.Header.Set("api-key", o.APIKey)
+	case o.APIKey != "":
+		hr.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+}
+
+// endpoint returns the URL of another API endpoint than chat completions:
+// def, or path next to the configured URL.
+func (o *OpenAI) endpoint(def, path string) string {
+	if o.URL == "" {
+		return def
+	}
+	return strings.TrimSuffix(o.URL, "/chat/completions") + path
+}
+
+// send sends body (if not nil) as JSON to url and decodes the JSON response
+// into v.
+func (o *OpenAI) send(ctx context.Context, method, url string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	hr, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return err
+	}
+	o.authorize(hr)
+	if body != nil {
+		hr.Header.Set("Content-Type", "application/json")
+	}
+
+	client := o.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(hr)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		b, _ := io.ReadAll(resp.Body)
+		se := statusError(resp.StatusCode, b)
+		se.RetryAfter = retryAfter(resp.Header)
+		return se
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Moderate classifies text with the moderation endpoint next to the chat
+// completions URL (OpenAIModerationURL by default).
+func (o *OpenAI) Moderate(ctx context.Context, text string) (Moderation, error) {
+	if o.Azure != nil {
+		return Moderation{}, errors.New("Azure OpenAI has no moderation endpoint")
+	}
+	var mr moderationResponse
+	if err := o.send(ctx, "POST", o.endpoint(OpenAIModerationURL, "/moderations"), moderationRequest{Model: DefaultModerationModel, Input: text}, &mr); err != nil {
+		return Moderation{}, err
+	}
+	if len(mr.Results) == 0 {
+		return Moderation{}, errors.New("no moderation results")
+	}
+	res := mr.Results[0]
+	m := Moderation{Flagged: res.Flagged, Categories: map[string]bool{}, Scores: res.CategoryScores}
+	for c, on := range res.Categories {
+		if on {
+			m.Categories[c] = true
+		}
+	}
+	return m, nil
+}
+
+type modelsResponse struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+// ListModels lists the models of the models endpoint next to the chat
+// completions URL (OpenAIModelsURL by default). On Azure, these are the
+// models the resource can deploy, not its deployments.
+func (o *OpenAI) ListModels(ctx context.Context) ([]string, error) {
+	url := o.endpoint(OpenAIModelsURL, "/models")
+	if o.Azure != nil {
+		url = o.Azure.modelsURL()
+	}
+	var mr modelsResponse
+	if err := o.send(ctx, "GET", url, nil, &mr); err != nil {
+		return nil, err
+	}
+	models := make([]string, len(mr.Data))
+	for i, m := range mr.Data {
+		models[i] = m.ID
+	}
+	return models, nil
+}
+
+// Connect opens n connections to the API with HEAD requests; they stay in
+// the client's idle pool (up to its MaxIdleConnsPerHost).
+// Any HTTP response counts as connected.
+func (o *OpenAI) Connect(ctx context.Context, n int) error {
+	url := o.chatURL("")
+	client := o.Client
+	if client == nil {
+		client = defaultClient
+	}
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			hr, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			resp, err := client.Do(hr)
+			if err == nil {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+			errs <- err
+		}()
+	}
+	var first error
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (o *OpenAI) do(ctx context.Context, req Request, stream bool) (*http.Response, error) {
+	model := req.Model
+	if model == "" {
+		model = o.Model
+	}
+	if model == "" {
+		model = DefaultModel
+	}
+
+	var user interface{} = chatMessage{Role: "user", Content: req.Prompt}
+	if len(req.Images) > 0 {
+		m := imageMessage{Role: "user", Content: []contentPart{{Type: "text", Text: req.Prompt}}}
+		for _, img := range req.Images {
+			url := "data:" + img.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+			m.Content = append(m.Content, contentPart{Type: "image_url", ImageURL: &imageURL{URL: url}})
+		}
+		user = m
+	}
+	messages := []interface{}{chatMessage{Role: "system", Content: req.System}}
+	for _, m := range req.History {
+		messages = append(messages, chatMessage{Role: m.Role, Content: m.Content})
+	}
+	body := chatRequest{
+		Model:    model,
+		Messages: append(messages, user),
+		Stream:   stream,
+		Params:   req.Params,
+	}
+	if stream {
+		body.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	hr, err := http.NewRequestWithContext(ctx, "POST", o.chatURL(req.Model), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	o.authorize(hr)
+	hr.Header.Set("Content-Type", "application/json")
+
+	client := o.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(hr)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		se := statusError(resp.StatusCode, b)
+		se.RetryAfter = retryAfter(resp.Header)
+		return nil, se
+	}
+	return resp, nil
+}
+
+// errorBody is the error response of OpenAI and compatible servers, whose
+// shapes differ: OpenAI and llama.cpp nest an object under "error" (with a
+// numeric code on llama.cpp), vLLM puts the message at the top level, LM
+// Studio and others send "error" as a string, and FastAPI-based servers
+// send "detail".
+type errorBody struct {
+	Error   json.RawMessage `json:"error"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail"`
+}
+
+type errorObject struct {
+	Message string          `json:"message"`
+	Code    json.RawMessage `json:"code"` // a string on OpenAI, the HTTP status on llama.cpp
+}
+
+// statusError returns the error of a non-2xx response.
+func statusError(status int, body []byte) *StatusError {
+	message, code := errorMessage(body)
+	return &StatusError{Status: status, Body: string(body), Message: message, Code: code}
+}
+
+// errorMessage returns the message and the (string) code of an error body,
+// or "" if it has none of the known shapes.
+func errorMessage(body []byte) (message, code string) {
+	var eb errorBody
+	if json.Unmarshal(body, &eb) != nil {
+		return "", ""
+	}
+	for _, raw := range []json.RawMessage{eb.Error, eb.Detail} {
+		var s string
+		if json.Unmarshal(raw, &s) == nil && s != "" {
+			return s, ""
+		}
+		var obj errorObject
+		if json.Unmarshal(raw, &obj) == nil && obj.Message != "" {
+			json.Unmarshal(obj.Code, &code)
+			return obj.Message, code
+		}
+	}
+	return eb.Message, ""
+}
+
+// retryAfter returns the Retry-After of h in seconds, or 0.
+func retryAfter(h http.Header) time.Duration {
+	n, err := strconv.Atoi(h.Get("Retry-After"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+// hasError reports whether an "error" field is set.
+func hasError(raw json.RawMessage) bool {
+	return len(raw) > 0 && string(raw) != "null"
+}
+
+// embeddedError returns the error of an "error" field sent in place of a
+// completion. Its status is the code if it is an HTTP status (llama.cpp),
+// else 502.
+func embeddedError(raw json.RawMessage) *StatusError {
+	status := http.StatusBadGateway
+	var obj errorObject
+	if json.Unmarshal(raw, &obj) == nil {
+		var code int
+		if json.Unmarshal(obj.Code, &code) == nil && code >= 400 && code < 600 {
+			status = code
+		}
+	}
+	body := []byte(`{"error":` + string(raw) + `}`)
+	return statusError(status, body)
+}
@@ -0,0 +1,117 @@
+package texttools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// --- LLM providers ---
+
+// Request is one chat completion: a system prompt and a user prompt.
+type Request struct {
+	Operation string // the template the prompt was rendered from; empty for raw prompts
+	Model     string // the provider's default if empty
+	System    string
+	Prompt    string
+	Images    []Image   // attached to the prompt; needs a vision model
+	History   []Message // earlier turns of the conversation, oldest first
+	Params
+}
+
+// Message is a turn of a conversation.
+type Message struct {
+	Role    string `json:"role"` // user or assistant
+	Content string `json:"content"`
+}
+
+// Image is an image for a vision model.
+type Image struct {
+	MIMEType string // image/png, image/jpeg, image/gif or image/webp
+	Data     []byte
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type Completion struct {
+	Text         string
+	Usage        Usage
+	FinishReason string // e.g. stop, or length if cut off at max_tokens; empty if unknown
+}
+
+// Provider is an LLM backend.
+type Provider interface {
+	Complete(ctx context.Context, req Request) (Completion, error)
+}
+
+// StreamProvider is a Provider that can also stream its output, calling
+// onDelta with each piece as it arrives; an error from onDelta aborts the
+// stream.
+type StreamProvider interface {
+	Provider
+	Stream(ctx context.Context, req Request, onDelta func(string) error) (Completion, error)
+}
+
+// Connector is a Provider that can open its connections ahead of the first
+// request, so that request doesn't pay for the TCP and TLS handshakes.
+type Connector interface {
+	Connect(ctx context.Context, n int) error
+}
+
+// ModelLister is a Provider that can list the models it serves, as a cheap
+// check that it is reachable.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+// Embedder turns texts into embedding vectors, one per text, for semantic
+// similarity: the closer two vectors (by cosine), the closer the meaning.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, Usage, error)
+}
+
+// Moderation is a content classifier's verdict on a text.
+type Moderation struct {
+	Flagged    bool               `json:"flagged"`
+	Categories map[string]bool    `json:"categories"` // flagged categories, e.g. "violence"
+	Scores     map[string]float64 `json:"scores"`     // 0–1 per category
+}
+
+// Moderator screens text for harmful content.
+type Moderator interface {
+	Moderate(ctx context.Context, text string) (Moderation, error)
+}
+
+// StatusError is a non-2xx response from the provider.
+type StatusError struct {
+	Status     int
+	Body       string
+	Message    string        // the error message in Body, if found
+	Code       string        // the provider's error code in Body, e.g. context_length_exceeded, if found
+	RetryAfter time.Duration // the Retry-After of a 429 or 503, if sent
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("LLM provider error: status=%d: %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("LLM provider error: status=%d body=%s", e.Status, e.Body)
+}
+
+// Unavailable reports whether err means the provider can't serve requests
+// right now (network failure, rate limit or exhausted quota, server error),
+// as opposed to a problem with the request itself.
+func Unavailable(err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Status == http.StatusTooManyRequests || se.Status >= 500
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
@@ -0,0 +1,331 @@
+// Package texttools implements the text operations of ai-text-tools
+// (summarize, keywords, rewrite, questions, titles, expand, to-bullets,
+// to-prose) independently of HTTP: it renders the prompt templates, calls an
+// LLM Provider and shapes the output.
+//
+//	p := &texttools.OpenAI{APIKey: os.Getenv("OPENAI_API_KEY")}
+//	summary, err := texttools.Summarize(ctx, p, text, texttools.Options{Length: "short"})
+//
+// Use a Tools value to swap in other prompt templates.
+package texttools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Options are the optional knobs of every operation.
+type Options struct {
+	Language string `json:"language,omitempty"`  // output language, e.g. "Spanish"
+	Length   string `json:"length,omitempty"`    // short, medium or long
+	MaxWords int    `json:"max_words,omitempty"` // cap on the words of a result (see FitWords)
+	Model    string `json:"model,omitempty"`
+
+	InputFormat  string `json:"input_format,omitempty"`  // plain, markdown or html
+	OutputFormat string `json:"output_format,omitempty"` // plain, markdown or html (text results)
+
+	Params
+}
+
+// Params are generation parameters passed through to the model; unset
+// fields keep the provider's defaults.
+type Params struct {
+	Temperature *float64 `json:"temperature,omitempty"` // 0–2; 0 for (nearly) deterministic output
+	TopP        *float64 `json:"top_p,omitempty"`       // 0–1, nucleus sampling
+	MaxTokens   int      `json:"max_tokens,omitempty"`  // cap on the output length
+	Seed        *int64   `json:"seed,omitempty"`        // best-effort reproducible sampling
+}
+
+func (p Params) Validate() error {
+	if p.Temperature != nil && (*p.Temperature < 0 || *p.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if p.TopP != nil && (*p.TopP < 0 || *p.TopP > 1) {
+		return fmt.Errorf("top_p must be between 0 and 1")
+	}
+	if p.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must be positive")
+	}
+	return nil
+}
+
+func (o Options) Validate() error {
+	switch o.Length {
+	case "", "short", "medium", "long":
+	default:
+		return fmt.Errorf("length must be one of short, medium, long")
+	}
+	if o.MaxWords < 0 {
+		return fmt.Errorf("max_words must be positive")
+	}
+	if !validFormat(o.InputFormat) {
+		return fmt.Errorf("input_format must be one of plain, markdown, html")
+	}
+	if !validFormat(o.OutputFormat) {
+		return fmt.Errorf("output_format must be one of plain, markdown, html")
+	}
+	return o.Params.Validate()
+}
+
+// Input is the data prompt templates are rendered with: {{.Text}},
+// {{.Tone}} and the option fields ({{.Length}}, ...).
+type Input struct {
+	Text string `json:"text"`
+	Tone string `json:"tone"`
+	Options
+}
+
+// Tools runs the operations with a provider and a set of prompt templates.
+type Tools struct {
+	Provider Provider
+	Prompts  Renderer // the built-in DefaultPrompts if nil
+
+	// StyleGuides are extra instructions per operation, e.g. an
+	// organization's style guide for "rewrite"; the default system prompt
+	// appends them.
+	StyleGuides map[string]string
+
+	// Guard sanitizes the text of every prompt against prompt injection
+	// (see SanitizeUserText).
+	Guard bool
+}
+
+// New returns Tools using p and the built-in prompts.
+func New(p Provider) *Tools {
+	return &Tools{Provider: p}
+}
+
+// Summarize returns text summarized as bullet points.
+func Summarize(ctx context.Context, p Provider, text string, opts Options) (string, error) {
+	return New(p).Summarize(ctx, text, opts)
+}
+
+// Keywords returns the key keywords of text.
+func Keywords(ctx context.Context, p Provider, text string, opts Options) ([]string, error) {
+	return New(p).Keywords(ctx, text, opts)
+}
+
+// Rewrite returns text rewritten in tone ("neutral" if empty).
+func Rewrite(ctx context.Context, p Provider, text, tone string, opts Options) (string, error) {
+	return New(p).Rewrite(ctx, text, tone, opts)
+}
+
+// Questions returns questions about text.
+func Questions(ctx context.Context, p Provider, text string, opts Options) ([]string, error) {
+	return New(p).Questions(ctx, text, opts)
+}
+
+// Titles returns title ideas for text.
+func Titles(ctx context.Context, p Provider, text string, opts Options) ([]string, error) {
+	return New(p).Titles(ctx, text, opts)
+}
+
+// Expand returns text elaborated with explanations and details.
+func Expand(ctx context.Context, p Provider, text string, opts Options) (string, error) {
+	return New(p).Expand(ctx, text, opts)
+}
+
+// ToBullets returns prose turned into a bulleted list.
+func ToBullets(ctx context.Context, p Provider, text string, opts Options) (string, error) {
+	return New(p).ToBullets(ctx, text, opts)
+}
+
+// ToProse returns bullet points or notes turned into prose.
+func ToProse(ctx context.Context, p Provider, text string, opts Options) (string, error) {
+	return New(p).ToProse(ctx, text, opts)
+}
+
+func (t *Tools) Summarize(ctx context.Context, text string, opts Options) (string, error) {
+	return t.text(ctx, "summarize", Input{Text: text, Options: opts})
+}
+
+func (t *Tools) Keywords(ctx context.Context, text string, opts Options) ([]string, error) {
+	return t.list(ctx, "keywords", Input{Text: text, Options: opts})
+}
+
+func (t *Tools) Rewrite(ctx context.Context, text, tone string, opts Options) (string, error) {
+	return t.text(ctx, "rewrite", Input{Text: text, Tone: tone, Options: opts})
+}
+
+func (t *Tools) Questions(ctx context.Context, text string, opts Options) ([]string, error) {
+	return t.list(ctx, "questions", Input{Text: text, Options: opts})
+}
+
+func (t *Tools) Titles(ctx context.Context, text string, opts Options) ([]string, error) {
+	return t.list(ctx, "titles", Input{Text: text, Options: opts})
+}
+
+func (t *Tools) Expand(ctx context.Context, text string, opts Options) (string, error) {
+	return t.text(ctx, "expand", Input{Text: text, Options: opts})
+}
+
+func (t *Tools) ToBullets(ctx context.Context, text string, opts Options) (string, error) {
+	return t.text(ctx, "to-bullets", Input{Text: text, Options: opts})
+}
+
+func (t *Tools) ToProse(ctx context.Context, text string, opts Options) (string, error) {
+	return t.text(ctx, "to-prose", Input{Text: text, Options: opts})
+}
+
+// DescribeImage asks a vision model to describe an image from a document.
+// in.Text may hold the document's alt text or caption for it.
+func (t *Tools) DescribeImage(ctx context.Context, img Image, in Input) (string, error) {
+	req, err := t.RequestContext(ctx, "describe-image", in, in.Options)
+	if err != nil {
+		return "", err
+	}
+	req.Images = []Image{img}
+	out, err := t.complete(ctx, req)
+	return strings.TrimSpace(out), err
+}
+
+// StreamOperations are the text operations Stream accepts.
+var StreamOperations = []string{"summarize", "rewrite", "expand", "to-bullets", "to-prose"}
+
+// Stream runs a text operation (see StreamOperations), passing the raw model
+// output to onDelta as it arrives, and returns the complete result
+// formatted like the non-streaming operation. Providers that can't stream
+// deliver the whole output as a single delta.
+func (t *Tools) Stream(ctx context.Context, op string, in Input, onDelta func(string) error) (string, error) {
+	switch op {
+	case "summarize", "rewrite", "expand", "to-bullets", "to-prose":
+	default:
+		return "", fmt.Errorf("operation %q can't be streamed", op)
+	}
+	in = prepare(op, in)
+	req, err := t.RequestContext(ctx, op, in, in.Options)
+	if err != nil {
+		return "", err
+	}
+
+	var c Completion
+	if sp, ok := t.Provider.(StreamProvider); ok {
+		c, err = sp.Stream(ctx, req, onDelta)
+	} else if c, err = t.Provider.Complete(ctx, req); err == nil {
+		err = onDelta(c.Text)
+	}
+	if err != nil {
+		return "", err
+	}
+	// the deltas are out already: the complete text is only cut
+	return FormatOutput(CutWords(c.Text, in.MaxWords), in.OutputFormat), nil
+}
+
+// Prompt renders the named template with data and returns the raw model
+// output.
+func (t *Tools) Prompt(ctx context.Context, name string, data interface{}, opts Options) (string, error) {
+	req, err := t.RequestContext(ctx, name, data, opts)
+	if err != nil {
+		return "", err
+	}
+	return t.complete(ctx, req)
+}
+
+// Complete sends an already rendered prompt, with the system prompt for
+// opts, and returns the raw model output.
+func (t *Tools) Complete(ctx context.Context, prompt string, opts Options) (string, error) {
+	system, err := t.System("", opts)
+	if err != nil {
+		return "", err
+	}
+	return t.complete(ctx, Request{Model: opts.Model, System: system, Prompt: prompt, Params: opts.Params})
+}
+
+// Request renders the system prompt and the named template into a provider
+// request without sending it.
+func (t *Tools) Request(name string, data interface{}, opts Options) (Request, error) {
+	return t.RequestContext(context.Background(), name, data, opts)
+}
+
+// RequestContext is Request for a request with the context ctx, which a
+// ContextRenderer may render by.
+func (t *Tools) RequestContext(ctx context.Context, name string, data interface{}, opts Options) (Request, error) {
+	system, err := t.System(name, opts)
+	if err != nil {
+		return Request{}, err
+	}
+	if t.Guard {
+		data = guard(data)
+	}
+	var prompt string
+	if cr, ok := t.renderer().(ContextRenderer); ok {
+		prompt, err = cr.RenderContext(ctx, name, data)
+	} else {
+		prompt, err = t.render(name, data)
+	}
+	if err != nil {
+		return Request{}, err
+	}
+	return Request{Operation: name, Model: opts.Model, System: system, Prompt: prompt, Params: opts.Params}, nil
+}
+
+// System renders the system prompt for op: the "system.<op>" template if
+// there is one, else "system".
+func (t *Tools) System(op string, opts Options) (string, error) {
+	in := SystemInput{Options: opts, Operation: op, StyleGuide: t.StyleGuides[op], Guarded: t.Guard && op != ""}
+	return t.render(SystemTemplate(t.renderer(), op), in)
+}
+
+// SystemTemplate returns the name of the system prompt template r uses
+// for op.
+func SystemTemplate(r Renderer, op string) string {
+	if h, ok := r.(interface{ Has(string) bool }); ok && op != "" && h.Has("system."+op) {
+		return "system." + op
+	}
+	return "system"
+}
+
+func (t *Tools) complete(ctx context.Context, req Request) (string, error) {
+	c, err := t.Provider.Complete(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	return c.Text, nil
+}
+
+func (t *Tools) render(name string, data interface{}) (string, error) {
+	return t.renderer().Render(name, data)
+}
+
+func (t *Tools) renderer() Renderer {
+	if t.Prompts == nil {
+		return builtinTemplates()
+	}
+	return t.Prompts
+}
+
+// prepare applies the input format and per-operation defaults.
+func prepare(op string, in Input) Input {
+	in.Text = PrepareInput(in.Text, in.InputFormat)
+	if op == "rewrite" && in.Tone == "" {
+		in.Tone = "neutral"
+	}
+	return in
+}
+
+// text runs an operation whose result is text in the requested format.
+func (t *Tools) text(ctx context.Context, op string, in Input) (string, error) {
+	in = prepare(op, in)
+	out, err := t.Prompt(ctx, op, in, in.Options)
+	if err != nil {
+		return "", err
+	}
+	return FormatOutput(t.FitWords(ctx, out, in.Options), in.OutputFormat), nil
+}
+
+// list runs an operation whose prompt asks for a JSON array of strings.
+func (t *Tools) list(ctx context.Context, op string, in Input) ([]string, error) {
+	in = prepare(op, in)
+	out, err := t.Prompt(ctx, op, in, in.Options)
+	if err != nil {
+		return nil, err
+	}
+	var items []string
+	if err := json.Unmarshal([]byte(out), &items); err != nil {
+		// fallback – return the raw output
+		items = []string{out}
+	}
+	return items, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- HTTPS ---
+//
+// With TLS_CERT_FILE and TLS_KEY_FILE, or TLS_AUTOCERT_HOSTS for
+// certificates from Let's Encrypt (see acme.go), the server speaks HTTPS on
+// TLS_ADDR instead of plain HTTP on :8080. HTTP_REDIRECT_ADDR optionally
+// redirects plain HTTP there.
+
+// newTLSConfigFromEnv returns nil when TLS isn't configured.
+func newTLSConfigFromEnv() (*tls.Config, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	var hosts []string
+	for _, h := range strings.Split(os.Getenv("TLS_AUTOCERT_HOSTS"), ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+
+	switch {
+	case (certFile != "" || keyFile != "") && len(hosts) > 0:
+		return nil, errors.New("set either TLS_CERT_FILE/TLS_KEY_FILE or TLS_AUTOCERT_HOSTS, not both")
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+		}
+		kp := &keyPairReloader{certFile: certFile, keyFile: keyFile}
+		if err := kp.load(); err != nil {
+			return nil, err
+		}
+		return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: kp.GetCertificate}, nil
+	case len(hosts) > 0:
+		cacheDir := os.Getenv("TLS_AUTOCERT_CACHE")
+		if cacheDir == "" {
+			cacheDir = "certs"
+		}
+		m, err := NewACMEManager(hosts, os.Getenv("TLS_AUTOCERT_EMAIL"), cacheDir, os.Getenv("TLS_AUTOCERT_DIRECTORY"))
+		if err != nil {
+			return nil, err
+		}
+		// GetCertificate, and NextProtos with acme-tls/1 for the challenges
+		c := m.TLSConfig()
+		c.MinVersion = tls.VersionTLS12
+		return c, nil
+	}
+	return nil, nil
+}
+
+// keyPairReloader serves a certificate from files, reloading it when they
+// change (checked at most once a minute), so renewed certificates are picked
+// up without a restart.
+type keyPairReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func (k *keyPairReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(k.certFile, k.keyFile)
+	if err != nil {
+		return err
+	}
+	k.cert, k.modTime, k.checked = &cert, k.latestModTime(), time.Now()
+	return nil
+}
+
+func (k *keyPairReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, f := range []string{k.certFile, k.keyFile} {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (k *keyPairReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if time.Since(k.checked) > time.Minute {
+		k.checked = time.Now()
+		if k.latestModTime().After(k.modTime) {
+			if err := k.load(); err != nil {
+				// keep serving the previous certificate
+				log.Println("tls reload error:", err)
+			} else {
+				log.Printf("Reloaded TLS certificate from %s", k.certFile)
+			}
+		}
+	}
+	return k.cert, nil
+}
+
+// newRedirectServer redirects plain HTTP requests to the same URL on the
+// HTTPS listener.
+func newRedirectServer(addr, tlsAddr string) *http.Server {
+	_, tlsPort, _ := net.SplitHostPort(tlsAddr)
+	return &http.Server{Addr: addr, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if tlsPort != "" && tlsPort != "443" {
+			host = net.JoinHostPort(host, tlsPort)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})}
+}
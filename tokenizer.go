@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Tokenizers ---
+//
+// Token counts depend on the model family. The registry maps model name
+// prefixes to tokenizers so every feature that counts tokens agrees on the
+// number. OpenAI encodings use the real BPE ranks when the .tiktoken file is
+// available in TOKENIZERS_DIR and fall back to a calibrated estimate
+// otherwise; SentencePiece models (Llama, Mistral, ...) are always estimated.
+
+type Tokenizer interface {
+	Name() string
+	Count(text string) int
+	// Exact is false for estimates. A vocabulary-based count is exact but
+	// for pre-tokenization, which only approximates tiktoken's (see
+	// preTokenRe): on runs of whitespace it can be a token off.
+	Exact() bool
+}
+
+// preTokenRe approximates tiktoken's pre-tokenization pattern. RE2 has no
+// lookahead, so runs of whitespace before a word aren't split the way
+// tiktoken splits them; counts can differ by a token on such input.
+var preTokenRe = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// o200kPreTokenRe is the same for o200k_base, whose pattern keeps
+// contractions with their word and splits words at case changes.
+var o200kPreTokenRe = regexp.MustCompile(`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+`)
+
+// bpeTokenizer is a byte-pair encoder loaded from a tiktoken rank file.
+type bpeTokenizer struct {
+	name      string
+	ranks     map[string]int
+	preTokens *regexp.Regexp
+}
+
+func (t *bpeTokenizer) Name() string { return t.name }
+func (t *bpeTokenizer) Exact() bool  { return true }
+
+func (t *bpeTokenizer) Count(text string) int {
+	n := 0
+	for _, piece := range t.preTokens.FindAllString(text, -1) {
+		if _, ok := t.ranks[piece]; ok {
+			n++
+			continue
+		}
+		n += t.merge([]byte(piece))
+	}
+	return n
+}
+
+// merge repeatedly joins the adjacent pair with the lowest rank (the
+// leftmost of equal ranks) until no pair is in the vocabulary, and returns
+// the number of resulting tokens. The candidate pairs are kept in a heap, so
+// a long piece without whitespace takes O(n log n), not O(n²).
+func (t *bpeTokenizer) merge(piece []byte) int {
+	n := len(piece)
+	// the parts are a linked list of the offsets where they start
+	next := make([]int, n)
+	prev := make([]int, n)
+	dead := make([]bool, n)
+	for i := range next {
+		next[i], prev[i] = i+1, i-1
+	}
+	pairs := &bpePairs{}
+	push := func(i int) {
+		if i < 0 || next[i] >= n {
+			return
+		}
+		end := next[next[i]]
+		if r, ok := t.ranks[string(piece[i:end])]; ok {
+			heap.Push(pairs, bpePair{rank: r, start: i, end: end})
+		}
+	}
+	for i := 0; i+1 < n; i++ {
+		push(i)
+	}
+
+	parts := n
+	for pairs.Len() > 0 {
+		p := heap.Pop(pairs).(bpePair)
+		j := next[p.start]
+		if dead[p.start] || j >= n || next[j] != p.end {
+			continue // a neighbour was merged since
+		}
+		dead[j] = true
+		next[p.start] = p.end
+		if p.end < n {
+			prev[p.end] = p.start
+		}
+		parts--
+		push(p.start)
+		push(prev[p.start])
+	}
+	return parts
+}
+
+// bpePair is a candidate merge of the parts starting at start and ending
+// at end.
+type bpePair struct {
+	rank, start, end int
+}
+
+// bpePairs is a min-heap of candidate merges by rank, then position.
+type bpePairs []bpePair
+
+func (h bpePairs) Len() int { return len(h) }
+func (h bpePairs) Less(i, j int) bool {
+	return h[i].rank < h[j].rank || h[i].rank == h[j].rank && h[i].start < h[j].start
+}
+func (h bpePairs) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *bpePairs) Push(x interface{}) { *h = append(*h, x.(bpePair)) }
+func (h *bpePairs) Pop() (x interface{}) {
+	old := *h
+	*h, x = old[:len(old)-1], old[len(old)-1]
+	return x
+}
+
+// loadTiktoken reads a .tiktoken file: one "<base64 token> <rank>" per line.
+func loadTiktoken(name, path string) (*bpeTokenizer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &bpeTokenizer{name: name, ranks: map[string]int{}, preTokens: preTokenRe}
+	if name == "o200k_base" {
+		t.preTokens = o200kPreTokenRe
+	}
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<token> <rank>\"", path, line)
+		}
+		tok, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		t.ranks[string(tok)] = rank
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// estimateTokenizer approximates a tokenizer from the average number of
+// characters per token, counting each pre-token separately so punctuation
+// and numbers aren't under-counted. Ideographs count as a token each.
+type estimateTokenizer struct {
+	name          string
+	charsPerToken float64
+}
+
+func (t *estimateTokenizer) Name() string { return t.name }
+func (t *estimateTokenizer) Exact() bool  { return false }
+
+func (t *estimateTokenizer) Count(text string) int {
+	n := 0
+	for _, piece := range preTokenRe.FindAllString(text, -1) {
+		chars := 0
+		for _, r := range piece {
+			if unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r) {
+				n++
+				continue
+			}
+			chars++
+		}
+		if chars > 0 {
+			n += int(math.Ceil(float64(chars) / t.charsPerToken))
+		}
+	}
+	return n
+}
+
+// TokenizerRegistry maps model name prefixes to tokenizers.
+type TokenizerRegistry struct {
+	mu       sync.RWMutex
+	prefixes map[string]Tokenizer
+	fallback Tokenizer
+}
+
+// NewTokenizerRegistry registers the built-in model families, using the
+// encodings found in dir (e.g. dir/o200k_base.tiktoken) where present.
+func NewTokenizerRegistry(dir string) (*TokenizerRegistry, error) {
+	encodings := map[string]Tokenizer{}
+	for name, cpt := range map[string]float64{"cl100k_base": 4, "o200k_base": 4.2} {
+		encodings[name] = &estimateTokenizer{name: name + " (estimate)", charsPerToken: cpt}
+		if dir == "" {
+			continue
+		}
+		path := filepath.Join(dir, name+".tiktoken")
+		t, err := loadTiktoken(name, path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		encodings[name] = t
+		log.Printf("loaded tokenizer %s (%d tokens)", name, len(t.ranks))
+	}
+	sentencepiece := &estimateTokenizer{name: "sentencepiece (estimate)", charsPerToken: 3.6}
+
+	r := &TokenizerRegistry{prefixes: map[string]Tokenizer{}, fallback: encodings["cl100k_base"]}
+	for _, p := range []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4"} {
+		r.Register(p, encodings["o200k_base"])
+	}
+	for _, p := range []string{"gpt-4", "gpt-3.5", "text-embedding-3", "text-embedding-ada"} {
+		r.Register(p, encodings["cl100k_base"])
+	}
+	for _, p := range []string{"llama", "mistral", "mixtral", "gemma", "phi", "qwen"} {
+		r.Register(p, sentencepiece)
+	}
+	return r, nil
+}
+
+// Register makes t the tokenizer for models whose name starts with prefix.
+func (r *TokenizerRegistry) Register(prefix string, t Tokenizer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prefixes[strings.ToLower(prefix)] = t
+}
+
+// For returns the tokenizer of the longest registered prefix of model.
+func (r *TokenizerRegistry) For(model string) Tokenizer {
+	if model == "" {
+		model = texttools.DefaultModel
+	}
+	model = strings.ToLower(model)
+	// Provider-qualified names such as "meta-llama/Llama-3-8B" are matched
+	// on their last segment.
+	if i := strings.LastIndexByte(model, '/'); i >= 0 {
+		model = model[i+1:]
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	best, bestLen := r.fallback, -1
+	for p, t := range r.prefixes {
+		if strings.HasPrefix(model, p) && len(p) > bestLen {
+			best, bestLen = t, len(p)
+		}
+	}
+	return best
+}
+
+// Count counts text's tokens for model.
+func (r *TokenizerRegistry) Count(model, text string) int {
+	return r.For(model).Count(text)
+}
+
+// --- Token count handler ---
+
+type TokensRequest struct {
+	Text  string `json:"text"`
+	Model string `json:"model"`
+}
+
+type TokensResponse struct {
+	Model     string `json:"model"`
+	Tokenizer string `json:"tokenizer"`
+	Tokens    int    `json:"tokens"`
+	Exact     bool   `json:"exact"`
+	Chars     int    `json:"chars"`
+}
+
+func tokensHandler(tokenizers *TokenizerRegistry, prefs *PreferenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TokensRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Model == "" {
+			req.Model = prefs.For(r).Model
+		}
+		if req.Model == "" {
+			req.Model = texttools.DefaultModel
+		}
+
+		t := tokenizers.For(req.Model)
+		writeJSON(w, http.StatusOK, TokensResponse{
+			Model:     req.Model,
+			Tokenizer: t.Name(),
+			Tokens:    t.Count(req.Text),
+			Exact:     t.Exact(),
+			Chars:     utf8.RuneCountInString(req.Text),
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "testing"
+
+// testdata/tokenizers holds the entries of the real cl100k_base and
+// o200k_base rank files that occur within the pre-tokens of the strings
+// below, which is all BPE ever merges, so the counts are those of tiktoken.
+var tokenizerGolden = []struct {
+	text          string
+	cl100k, o200k int
+}{
+	{"hello world", 2, 2},
+	{"tiktoken is great!", 6, 6},
+	{"The quick brown fox jumps over the lazy dog.", 10, 10},
+	{"Don't stop: it's 2024, we're 100% sure!", 17, 14},
+	{"naïve café résumé", 7, 5},
+	{"日本語のテキストを数える", 12, 9},
+	{"func main() {\n\tfmt.Println(\"hi\")\n}", 10, 10},
+	{"1234567890", 4, 4},
+	{"Привет, мир!", 7, 5},
+	{"<p>Hello</p>\n\n- item one\n- item two", 12, 12},
+}
+
+func TestBPETokenizer(t *testing.T) {
+	r, err := NewTokenizerRegistry("testdata/tokenizers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, enc := range []struct{ model, name string }{{"gpt-4", "cl100k_base"}, {"gpt-4o", "o200k_base"}} {
+		if tok := r.For(enc.model); tok.Name() != enc.name || !tok.Exact() {
+			t.Fatalf("%s: tokenizer %s, exact %v", enc.model, tok.Name(), tok.Exact())
+		}
+	}
+	for _, tt := range tokenizerGolden {
+		if got := r.Count("gpt-4", tt.text); got != tt.cl100k {
+			t.Errorf("cl100k_base: %q is %d tokens, want %d", tt.text, got, tt.cl100k)
+		}
+		if got := r.Count("gpt-4o", tt.text); got != tt.o200k {
+			t.Errorf("o200k_base: %q is %d tokens, want %d", tt.text, got, tt.o200k)
+		}
+	}
+}
+
+func TestEstimateTokenizer(t *testing.T) {
+	r, err := NewTokenizerRegistry("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		model, text string
+		want        int
+	}{
+		{"gpt-4", "hello world", 4},
+		{"gpt-4o", "The quick brown fox jumps over the lazy dog.", 15},
+		{"meta-llama/Llama-3-8B", "The quick brown fox jumps over the lazy dog.", 18},
+		{"mistral-large", "日本語のテキストを数える", 12},
+		{"unknown-model", "1234567890", 4},
+	}
+	for _, tt := range tests {
+		tok := r.For(tt.model)
+		if got := tok.Count(tt.text); got != tt.want || tok.Exact() {
+			t.Errorf("%s (%s): %q is %d tokens, want %d", tt.model, tok.Name(), tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"ai-text-tools/texttools"
+)
+
+// --- File upload ---
+
+const maxUploadSize = 20 << 20 // 20 MB
+
+// visionModel is the model that describes figures (VISION_MODEL); the
+// request's model if empty.
+var visionModel string
+
+// maxDescribedFigures caps the vision calls of one upload.
+const maxDescribedFigures = 20
+
+type UploadResponse struct {
+	Filename  string      `json:"filename"`
+	Format    string      `json:"format"`
+	Text      string      `json:"text"`
+	Figures   []Figure    `json:"figures,omitempty"`
+	Operation string      `json:"operation,omitempty"`
+	Result    interface{} `json:"result,omitempty"`
+}
+
+// uploadHandler accepts a multipart form with a `file` (PDF, DOCX, EPUB or
+// TXT) and an optional `operation` (plus `tone`, `language`, `length`,
+// `model`) to run on the extracted text. Without an operation it only
+// returns the text. With `describe_images=true`, figures are described by
+// the vision model before the operation runs.
+func uploadHandler(tools *texttools.Tools, prefs *PreferenceStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+		if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "file too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid multipart body", http.StatusBadRequest)
+			return
+		}
+
+		op := r.FormValue("operation")
+		if op != "" && !isBuiltinOperation(op) {
+			http.Error(w, "unknown operation", http.StatusBadRequest)
+			return
+		}
+		describe := false
+		if v := r.FormValue("describe_images"); v != "" {
+			var err error
+			if describe, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, "`describe_images` must be true or false", http.StatusBadRequest)
+				return
+			}
+		}
+		req := RewriteRequest{
+			Tone: r.FormValue("tone"),
+			Options: Options{Options: texttools.Options{
+				Language: r.FormValue("language"),
+				Length:   r.FormValue("length"),
+				Model:    r.FormValue("model"),
+
+				InputFormat:  r.FormValue("input_format"),
+				OutputFormat: r.FormValue("output_format"),
+			}},
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p := prefs.For(r)
+		p.apply(&req.Options)
+		if req.Tone == "" {
+			req.Tone = p.Tone
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "`file` is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "failed to read file", http.StatusBadRequest)
+			return
+		}
+
+		doc, err := extractDocument(header.Filename, data)
+		if errors.Is(err, errUnsupportedFormat) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		if describe {
+			describeFigures(r.Context(), tools, doc, req.Options.Options)
+		}
+
+		req.Text = doc.LabeledText()
+		resp := UploadResponse{Filename: header.Filename, Format: doc.Format, Text: req.Text, Figures: doc.Figures, Operation: op}
+		if op != "" {
+			if !checkText(w, req.Text) {
+				return
+			}
+			result, err := runOperation(r.Context(), tools, op, req)
+			if err != nil {
+				log.Println("upload error:", err)
+				writeLLMError(w, err)
+				return
+			}
+			resp.Result = result
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// describeFigures has the vision model describe the document's figures.
+// Figures it can't read, or fails on, keep just their alt text.
+func describeFigures(ctx context.Context, tools *texttools.Tools, doc *Document, opts texttools.Options) {
+	if visionModel != "" {
+		opts.Model = visionModel
+	}
+	described := 0
+	for i := range doc.Figures {
+		f := &doc.Figures[i]
+		if f.image == nil || described == maxDescribedFigures || ctx.Err() != nil {
+			continue
+		}
+		described++
+		desc, err := tools.DescribeImage(ctx, *f.image, texttools.Input{Text: f.Alt, Options: opts})
+		if err != nil {
+			log.Println("describe image error:", err)
+			continue
+		}
+		f.Description = desc
+	}
+}
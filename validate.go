@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"unicode/utf8"
+)
+
+// --- Input validation ---
+//
+// JSON bodies are read through decodeJSON, which caps their size
+// (MAX_BODY_BYTES) and rejects invalid UTF-8, and text bound for the model
+// goes through validateText, which caps its length (MAX_TEXT_CHARS). Too
+// much input gets a 413 and malformed input a 422 here, instead of an
+// opaque failure at the provider.
+
+var (
+	// maxBodyBytes caps JSON request bodies (MAX_BODY_BYTES).
+	maxBodyBytes int64 = 2 << 20
+
+	// maxTextChars caps the text of one operation, in characters
+	// (MAX_TEXT_CHARS).
+	maxTextChars = 100000
+)
+
+// inputError is a rejected input with the status to report it with.
+type inputError struct {
+	Status  int
+	Message string
+}
+
+func (e *inputError) Error() string { return e.Message }
+
+func errTooLarge(format string, args ...interface{}) error {
+	return &inputError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf(format, args...)}
+}
+
+func errMalformed(format string, args ...interface{}) error {
+	return &inputError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf(format, args...)}
+}
+
+// writeInputError reports err with its inputError status, 400 otherwise.
+func writeInputError(w http.ResponseWriter, err error) {
+	var ie *inputError
+	if errors.As(err, &ie) {
+		http.Error(w, ie.Message, ie.Status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+// readBody reads a request body of at most limit bytes.
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, errTooLarge("request body too large (max %d bytes)", limit)
+	}
+	if err != nil {
+		return nil, errors.New("invalid body")
+	}
+	return b, nil
+}
+
+// decodeJSON decodes a JSON body of at most maxBodyBytes into v. On failure
+// it writes the error response and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	return decodeJSONLimit(w, r, v, maxBodyBytes)
+}
+
+func decodeJSONLimit(w http.ResponseWriter, r *http.Request, v interface{}, limit int64) bool {
+	b, err := readBody(w, r, limit)
+	if err == nil {
+		err = unmarshalJSON(b, v)
+	}
+	if err != nil {
+		writeInputError(w, err)
+		return false
+	}
+	return true
+}
+
+// unmarshalJSON is json.Unmarshal, except that invalid UTF-8 (which
+// encoding/json would silently replace) is rejected.
+func unmarshalJSON(b []byte, v interface{}) error {
+	if !utf8.Valid(b) {
+		return errMalformed("request body is not valid UTF-8")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.New("invalid JSON body")
+	}
+	return nil
+}
+
+// validateText checks text that will be sent to the model.
+func validateText(text string) error {
+	if !utf8.ValidString(text) {
+		return errMalformed("`text` is not valid UTF-8")
+	}
+	if n := utf8.RuneCountInString(text); n > maxTextChars {
+		return errTooLarge("`text` is too long: %d characters (max %d)", n, maxTextChars)
+	}
+	return nil
+}
+
+// checkText is validateText for handlers: it writes the error response and
+// returns false if text is rejected.
+func checkText(w http.ResponseWriter, text string) bool {
+	if err := validateText(text); err != nil {
+		writeInputError(w, err)
+		return false
+	}
+	return true
+}
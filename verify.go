@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Summary verification ---
+//
+// POST /verify-summary checks a summary against its source for
+// hallucinations: the model breaks the summary into claims and judges each
+// supported or unsupported by the source, quoting the passages it relies
+// on, which are located in the source here (see locateQuotes). POST
+// /summarize with "verify": true runs the same check on its own result.
+
+// Verdicts.
+const (
+	verdictSupported   = "supported"
+	verdictUnsupported = "unsupported"
+)
+
+type VerifySummaryRequest struct {
+	Source  string `json:"source"`
+	Summary string `json:"summary"`
+	Options
+}
+
+type VerifySummaryResponse struct {
+	SummaryVerification
+	ResultMeta
+}
+
+// SummaryVerification is the verdict on a summary.
+type SummaryVerification struct {
+	Consistent  bool            `json:"consistent"` // every claim is supported
+	Supported   int             `json:"supported"`
+	Unsupported int             `json:"unsupported"`
+	Claims      []VerifiedClaim `json:"claims"`
+}
+
+// VerifiedClaim is a claim of the summary with its verdict. Evidence are
+// the passages of the source it rests on, with offsets into the source.
+type VerifiedClaim struct {
+	Claim       string  `json:"claim"`
+	Verdict     string  `json:"verdict"` // supported or unsupported
+	Explanation string  `json:"explanation"`
+	Evidence    []Quote `json:"evidence"`
+}
+
+// verifyPromptInput is the data of the verify-summary template.
+type verifyPromptInput struct {
+	texttools.Input
+	Summary string
+}
+
+// verifySummary judges the claims of summary against source.
+func verifySummary(ctx context.Context, tools *texttools.Tools, source, summary string, opts texttools.Options) (SummaryVerification, error) {
+	in := verifyPromptInput{Input: texttools.Input{Text: source, Options: opts}, Summary: summary}
+	out, err := tools.Prompt(ctx, "verify-summary", in, opts)
+	if err != nil {
+		return SummaryVerification{}, err
+	}
+	var judged struct {
+		Claims []struct {
+			Claim       string   `json:"claim"`
+			Verdict     string   `json:"verdict"`
+			Explanation string   `json:"explanation"`
+			Evidence    []string `json:"evidence"`
+		} `json:"claims"`
+	}
+	if err := json.Unmarshal([]byte(out), &judged); err != nil {
+		logUnparseable(ctx, "verify-summary", out)
+	}
+
+	v := SummaryVerification{Claims: []VerifiedClaim{}}
+	for _, c := range judged.Claims {
+		claim := VerifiedClaim{
+			Claim:       strings.TrimSpace(c.Claim),
+			Verdict:     verdictUnsupported,
+			Explanation: strings.TrimSpace(c.Explanation),
+			Evidence:    locateQuotes(source, c.Evidence),
+		}
+		if claim.Claim == "" {
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(c.Verdict), verdictSupported) {
+			claim.Verdict = verdictSupported
+			v.Supported++
+		} else {
+			v.Unsupported++
+		}
+		v.Claims = append(v.Claims, claim)
+	}
+	v.Consistent = v.Unsupported == 0
+	return v, nil
+}
+
+func verifySummaryHandler(tools *texttools.Tools, prefs *PreferenceStore, history *History) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req VerifySummaryRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if strings.TrimSpace(req.Source) == "" || strings.TrimSpace(req.Summary) == "" {
+			http.Error(w, "`source` and `summary` are required", http.StatusBadRequest)
+			return
+		}
+		if !checkText(w, req.Source) || !checkText(w, req.Summary) {
+			return
+		}
+		if err := req.Options.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		prefs.For(r).apply(&req.Options)
+
+		input := req.Source + "\n\n---\n\n" + req.Summary
+		key := historyKey("", req.Options)
+		if prior, ok := history.Reusable(r, "verify-summary", key, input, req.Options); ok {
+			writeJSON(w, http.StatusOK, prior)
+			return
+		}
+
+		// The source goes to the model as submitted (no input_format
+		// conversion), so that the evidence can be found in it.
+		v, err := verifySummary(r.Context(), tools, req.Source, req.Summary, req.Options.Options)
+		if err != nil {
+			log.Println("verify-summary error:", err)
+			writeLLMError(w, err)
+			return
+		}
+		resp := VerifySummaryResponse{SummaryVerification: v}
+
+		resp.ResultMeta = history.Record(r, "verify-summary", key, input, req.Options, resp)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Cold-start warmup ---
+//
+// With WARMUP=true the server opens its connections to the provider
+// (WARMUP_CONNECTIONS, default 2) and sends it one tiny request at
+// startup, so the first user request doesn't pay for the handshakes and
+// the provider's cold start. GET /readyz reports the warmup and answers
+// 503 until it has finished; a failed warmup is reported but doesn't keep
+// the server from being ready.
+
+const warmupTimeout = 30 * time.Second
+
+// Warmup states.
+const (
+	warmupDisabled = "disabled"
+	warmupRunning  = "running"
+	warmupDone     = "done"
+	warmupFailed   = "failed"
+)
+
+// WarmupStatus is what /readyz reports.
+type WarmupStatus struct {
+	Ready       bool       `json:"ready"`
+	Warmup      string     `json:"warmup"`                // disabled, running, done or failed
+	Connections int        `json:"connections,omitempty"` // opened ahead of time
+	LatencyMS   int64      `json:"latency_ms,omitempty"`  // of the warmup request
+	Error       string     `json:"error,omitempty"`
+	Started     *time.Time `json:"started,omitempty"`
+	Finished    *time.Time `json:"finished,omitempty"`
+}
+
+// Warmup tracks the startup warmup.
+type Warmup struct {
+	mu     sync.Mutex
+	status WarmupStatus
+}
+
+func NewWarmup() *Warmup {
+	return &Warmup{status: WarmupStatus{Ready: true, Warmup: warmupDisabled}}
+}
+
+// Start warms up in the background: it connects provider if it is a
+// texttools.Connector, then sends a one-token request through tools.
+func (wu *Warmup) Start(provider texttools.Provider, tools *texttools.Tools, connections int) {
+	now := time.Now()
+	wu.mu.Lock()
+	wu.status = WarmupStatus{Warmup: warmupRunning, Started: &now}
+	wu.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+		defer cancel()
+
+		var err error
+		opened := 0
+		if c, ok := provider.(texttools.Connector); ok && connections > 0 {
+			if err = c.Connect(ctx, connections); err == nil {
+				opened = connections
+			}
+		}
+		var latency time.Duration
+		if err == nil {
+			start := time.Now()
+			_, err = tools.Provider.Complete(ctx, texttools.Request{
+				Operation: "warmup",
+				System:    "Answer in one word.",
+				Prompt:    "Reply with OK.",
+				Params:    texttools.Params{MaxTokens: 1},
+			})
+			latency = time.Since(start)
+		}
+
+		finished := time.Now()
+		wu.mu.Lock()
+		defer wu.mu.Unlock()
+		wu.status.Ready = true
+		wu.status.Connections = opened
+		wu.status.Finished = &finished
+		if err != nil {
+			wu.status.Warmup = warmupFailed
+			wu.status.Error = err.Error()
+			log.Println("warmup error:", err)
+			return
+		}
+		wu.status.Warmup = warmupDone
+		wu.status.LatencyMS = latency.Milliseconds()
+		log.Printf("warmup done in %v (%d connections, request %v)", finished.Sub(now).Round(time.Millisecond), opened, latency.Round(time.Millisecond))
+	}()
+}
+
+func (wu *Warmup) Status() WarmupStatus {
+	wu.mu.Lock()
+	defer wu.mu.Unlock()
+	return wu.status
+}
+
+// readyzHandler is the readiness probe: 200 once the server is ready to
+// take traffic, 503 while it is still warming up.
+func readyzHandler(wu *Warmup) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := wu.Status()
+		status := http.StatusOK
+		if !s.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, s)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- Output wrappers ---
+//
+// Operators can wrap the text results of an operation in a house template,
+// e.g. a standard header and footer, so downstream systems receive
+// ready-to-publish text: a <operation>.tmpl file in OUTPUT_TEMPLATES_DIR
+// is executed with the result (see OutputData) and its output replaces the
+// result, before it is stored in history and returned. Operations without a
+// file are left as they are. Streamed results, delivered as they are
+// generated, are not wrapped.
+
+// wrappableOperations are the operations whose text result can be wrapped,
+// and that result's field.
+var wrappableOperations = map[string]string{
+	"summarize":       "summary",
+	"summarize-chat":  "summary",
+	"summarize-multi": "summary",
+	"rewrite":         "text",
+	"expand":          "text",
+	"to-bullets":      "text",
+	"to-prose":        "text",
+	"compare":         "summary",
+	"draft":           "draft",
+}
+
+// OutputData is the data of an output wrapper.
+type OutputData struct {
+	texttools.Options           // Language, Length, Model, OutputFormat, ...
+	Operation         string    // e.g. "summarize"
+	Text              string    // the result
+	Date              time.Time // when it was generated
+}
+
+// OutputWrappers holds the output wrappers by operation.
+type OutputWrappers struct {
+	templates map[string]*template.Template
+}
+
+// outputWrappers are the wrappers loaded from OUTPUT_TEMPLATES_DIR.
+var outputWrappers = &OutputWrappers{}
+
+// LoadOutputWrappers parses the *.tmpl files of dir; an empty dir loads
+// none.
+func LoadOutputWrappers(dir string) (*OutputWrappers, error) {
+	w := &OutputWrappers{templates: map[string]*template.Template{}}
+	if dir == "" {
+		return w, nil
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range files {
+		op := strings.TrimSuffix(filepath.Base(path), ".tmpl")
+		if _, ok := wrappableOperations[op]; !ok {
+			log.Printf("Output wrapper %s ignored: %q has no text result to wrap", path, op)
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		t, err := template.New(op).Parse(string(b))
+		if err != nil {
+			return nil, err
+		}
+		w.templates[op] = t
+	}
+	return w, nil
+}
+
+// Operations returns the operations that have a wrapper, sorted.
+func (w *OutputWrappers) Operations() []string {
+	ops := make([]string, 0, len(w.templates))
+	for op := range w.templates {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// Wrap applies the wrapper of op, if any, to text. If the wrapper fails
+// the text is returned as it is.
+func (w *OutputWrappers) Wrap(op, text string, opts Options) string {
+	t, ok := w.templates[op]
+	if !ok {
+		return text
+	}
+	var buf bytes.Buffer
+	data := OutputData{Options: opts.Options, Operation: op, Text: text, Date: time.Now().UTC()}
+	if err := t.Execute(&buf, data); err != nil {
+		log.Printf("output wrapper %s error: %v", op, err)
+		return text
+	}
+	return buf.String()
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ai-text-tools/texttools"
+)
+
+// --- WebSocket API ---
+//
+// GET /ws upgrades to a WebSocket carrying JSON messages both ways, for
+// interactive clients and for proxies that buffer server-sent events. A
+// client submits operations ("run"), receives the output of streamable
+// ones as it is generated ("delta") and the result ("result"), and can
+// cancel a run ("cancel"). Several runs can be in flight on one connection;
+// the client's id ties the messages of a run together. The WebSocket
+// protocol (RFC 6455) is implemented here, like the gRPC framing, to keep
+// the build dependency-free.
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// maxWSRuns caps the runs in flight on one connection.
+	maxWSRuns = 4
+
+	wsPingInterval = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+)
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// WSRequest is a client message: "run" an operation, or "cancel" the run
+// with the given id.
+type WSRequest struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Operation string `json:"operation"` // for run: a built-in operation
+	RewriteRequest
+}
+
+// WSEvent is a server message: a "delta" of streamed output, the "result"
+// of a run, an "error" or the confirmation that a run was "cancelled".
+type WSEvent struct {
+	Type   string        `json:"type"`
+	ID     string        `json:"id,omitempty"`
+	Text   string        `json:"text,omitempty"`   // delta
+	Result interface{}   `json:"result,omitempty"` // the operation's response; {"text": ...} for streamed runs
+	Error  *APIErrorBody `json:"error,omitempty"`
+}
+
+func wsHandler(tools *texttools.Tools, prefs *PreferenceStore, sessions *SessionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+			http.Error(w, "WebSocket upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Version") != "13" {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+			return
+		}
+		key := r.Header.Get("Sec-WebSocket-Key")
+		if key == "" {
+			http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+			return
+		}
+		// browsers send cookies (SAML logins) with cross-site WebSocket
+		// requests too: only accept pages of this host
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				http.Error(w, "cross-origin WebSocket requests are not allowed", http.StatusForbidden)
+				return
+			}
+		}
+
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			log.Println("ws error:", err)
+			http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		sum := sha1.Sum([]byte(key + wsGUID))
+		fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+		if err := brw.Flush(); err != nil {
+			return
+		}
+		conn.SetDeadline(time.Time{})
+
+		ws := &wsConn{conn: conn, br: brw.Reader, requestID: r.Header.Get("X-Request-ID")}
+		ws.serve(r, tools, prefs, sessions)
+	}
+}
+
+// headerContains reports whether a comma-separated header has token.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsConn is the server side of a WebSocket connection.
+type wsConn struct {
+	conn      net.Conn
+	br        *bufio.Reader
+	requestID string
+
+	wmu sync.Mutex // serializes writes
+
+	mu   sync.Mutex
+	runs map[string]context.CancelFunc
+}
+
+func (c *wsConn) serve(r *http.Request, tools *texttools.Tools, prefs *PreferenceStore, sessions *SessionStore) {
+	// on return, the runs are cancelled and then waited for
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	c.runs = map[string]context.CancelFunc{}
+
+	go c.keepAlive(ctx)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+		op, msg, err := c.readMessage()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				c.close(1002, "protocol error")
+			}
+			return
+		}
+		if op == wsClose {
+			c.close(1000, "")
+			return
+		}
+		if op != wsText {
+			c.close(1003, "text messages only")
+			return
+		}
+
+		var req WSRequest
+		if err := unmarshalJSON(msg, &req); err != nil {
+			c.sendError("", http.StatusBadRequest, err.Error())
+			continue
+		}
+		switch req.Type {
+		case "run":
+			if req.ID == "" {
+				c.sendError("", http.StatusBadRequest, "`id` is required")
+				continue
+			}
+			runCtx, ok := c.start(ctx, req.ID)
+			if !ok {
+				c.sendError(req.ID, http.StatusTooManyRequests, fmt.Sprintf("run %q is in flight, or too many runs (max %d)", req.ID, maxWSRuns))
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer c.finish(req.ID)
+				c.run(runCtx, r, tools, prefs, sessions, req)
+			}()
+		case "cancel":
+			c.mu.Lock()
+			stop, ok := c.runs[req.ID]
+			c.mu.Unlock()
+			if ok {
+				stop()
+			}
+		default:
+			c.sendError(req.ID, http.StatusBadRequest, "`type` must be run or cancel")
+		}
+	}
+}
+
+// start registers a run; false if the id is taken or too many are in
+// flight.
+func (c *wsConn) start(ctx context.Context, id string) (context.Context, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, taken := c.runs[id]; taken || len(c.runs) >= maxWSRuns {
+		return nil, false
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.runs[id] = cancel
+	return ctx, true
+}
+
+func (c *wsConn) finish(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cancel, ok := c.runs[id]; ok {
+		cancel()
+		delete(c.runs, id)
+	}
+}
+
+// run validates and runs one operation, like the HTTP handlers and gRPC.
+func (c *wsConn) run(ctx context.Context, r *http.Request, tools *texttools.Tools, prefs *PreferenceStore, sessions *SessionStore, req WSRequest) {
+	if req.Text == "" {
+		c.sendError(req.ID, http.StatusBadRequest, "`text` is required")
+		return
+	}
+	if err := validateText(req.Text); err != nil {
+		status := http.StatusBadRequest
+		var ie *inputError
+		if errors.As(err, &ie) {
+			status = ie.Status
+		}
+		c.sendError(req.ID, status, err.Error())
+		return
+	}
+	if err := req.Options.validate(); err != nil {
+		c.sendError(req.ID, http.StatusBadRequest, err.Error())
+		return
+	}
+	p := prefs.For(r)
+	p.apply(&req.Options)
+	if req.Tone == "" {
+		req.Tone = p.Tone
+	}
+	if req.SessionID != "" {
+		if privacyMode(ctx) {
+			c.sendError(req.ID, http.StatusForbidden, "sessions are not available in privacy mode")
+			return
+		}
+		if _, ok := sessions.Get(userID(r), req.SessionID); !ok {
+			c.sendError(req.ID, http.StatusNotFound, "session not found")
+			return
+		}
+		ctx = context.WithValue(ctx, sessionKey{}, req.SessionID)
+	}
+
+	var result interface{}
+	var err error
+	switch {
+	case isStreamableOperation(req.Operation):
+		var full string
+		full, err = streamOperation(ctx, tools, req.Operation, req.RewriteRequest, func(delta string) error {
+			return c.send(WSEvent{Type: "delta", ID: req.ID, Text: delta})
+		})
+		result = map[string]string{"text": full}
+	case isBuiltinOperation(req.Operation):
+		result, err = runOperation(ctx, tools, req.Operation, req.RewriteRequest)
+	default:
+		c.sendError(req.ID, http.StatusBadRequest, "operation must be one of "+strings.Join(builtinOperations, ", "))
+		return
+	}
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			c.send(WSEvent{Type: "cancelled", ID: req.ID})
+			return
+		}
+		log.Printf("ws %s error: %v", req.Operation, err)
+		var flagged *flaggedError
+		switch {
+		case errors.As(err, &flagged):
+			c.send(WSEvent{Type: "error", ID: req.ID, Error: &APIErrorBody{Code: "content_flagged", Message: flagged.Error(), RequestID: c.requestID}})
+		default:
+			f := classifyLLMError(err)
+			c.send(WSEvent{Type: "error", ID: req.ID, Error: &APIErrorBody{Code: f.Code, Message: f.Message, RequestID: c.requestID}})
+		}
+		return
+	}
+	c.send(WSEvent{Type: "result", ID: req.ID, Result: result})
+}
+
+func (c *wsConn) sendError(id string, status int, msg string) {
+	c.send(WSEvent{Type: "error", ID: id, Error: &APIErrorBody{Code: errorCode(status), Message: msg, RequestID: c.requestID}})
+}
+
+func (c *wsConn) send(ev WSEvent) error {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(wsText, b)
+}
+
+// keepAlive pings the client, so that proxies keep the connection open and
+// dead clients are noticed (by the read deadline).
+func (c *wsConn) keepAlive(ctx context.Context) {
+	t := time.NewTicker(wsPingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := c.writeFrame(wsPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *wsConn) close(code int, reason string) {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.writeFrame(wsClose, append(payload, reason...))
+}
+
+// readMessage reads the next data message, answering pings on the way.
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var op byte
+	var msg []byte
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.br, head[:]); err != nil {
+			return 0, nil, err
+		}
+		fin, frameOp := head[0]&0x80 != 0, head[0]&0x0f
+		if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+			return 0, nil, errors.New("ws: reserved bits set or unmasked frame")
+		}
+		n := uint64(head[1] & 0x7f)
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return 0, nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(c.br, b[:]); err != nil {
+				return 0, nil, err
+			}
+			n = binary.BigEndian.Uint64(b[:])
+		}
+		if frameOp >= wsClose && (n > 125 || !fin) {
+			return 0, nil, errors.New("ws: invalid control frame")
+		}
+		if uint64(len(msg))+n > uint64(maxBodyBytes) {
+			return 0, nil, errors.New("ws: message too large")
+		}
+		var mask [4]byte
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return 0, nil, err
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return 0, nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch frameOp {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return wsClose, payload, nil
+		case wsText, wsBinary:
+			if op != 0 {
+				return 0, nil, errors.New("ws: expected a continuation frame")
+			}
+			op = frameOp
+		case wsContinuation:
+			if op == 0 {
+				return 0, nil, errors.New("ws: unexpected continuation frame")
+			}
+		default:
+			return 0, nil, fmt.Errorf("ws: unknown opcode %d", frameOp)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+// writeFrame writes an unfragmented, unmasked frame.
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	frame := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 127), uint64(n))
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(append(frame, payload...))
+	return err
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// wsClientFrame encodes a masked client frame.
+func wsClientFrame(fin bool, op byte, payload []byte) []byte {
+	head := op
+	if fin {
+		head |= 0x80
+	}
+	frame := []byte{head}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, 0x80|byte(n))
+	case n <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 0x80|126), uint16(n))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 0x80|127), uint64(n))
+	}
+	mask := [4]byte{0x37, 0xfa, 0x21, 0x3d}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// wsReadFrame decodes an unmasked server frame.
+func wsReadFrame(r io.Reader) (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	if head[1]&0x80 != 0 {
+		return false, 0, nil, io.ErrUnexpectedEOF
+	}
+	n := uint64(head[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	payload = make([]byte, n)
+	_, err = io.ReadFull(r, payload)
+	return head[0]&0x80 != 0, head[0] & 0x0f, payload, err
+}
+
+// wsServer returns a connection reading the given client frames; the
+// frames it writes come out of the returned channel.
+func wsServer(t *testing.T, frames ...[]byte) (*wsConn, <-chan []byte) {
+	a, b := net.Pipe()
+	t.Cleanup(func() { a.Close(); b.Close() })
+	out := make(chan []byte, 16)
+	go func() {
+		defer close(out)
+		for {
+			fin, op, payload, err := wsReadFrame(b)
+			if err != nil {
+				return
+			}
+			if !fin {
+				t.Error("fragmented server frame")
+			}
+			out <- append([]byte{op}, payload...)
+		}
+	}()
+	return &wsConn{conn: a, br: bufio.NewReader(bytes.NewReader(bytes.Join(frames, nil)))}, out
+}
+
+func TestWSFrameRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 125, 126, 0xffff, 0x10000, 300000} {
+		payload := bytes.Repeat([]byte("0123456789abcdef"), n/16+1)[:n]
+
+		// server to client
+		c, out := wsServer(t)
+		if err := c.writeFrame(wsText, payload); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-out; got[0] != wsText || !bytes.Equal(got[1:], payload) {
+			t.Errorf("%d bytes: server frame decoded as op %d with %d bytes", n, got[0], len(got)-1)
+		}
+
+		// client to server
+		c, _ = wsServer(t, wsClientFrame(true, wsBinary, payload))
+		op, msg, err := c.readMessage()
+		if err != nil || op != wsBinary || !bytes.Equal(msg, payload) {
+			t.Errorf("%d bytes: client frame read as op %d with %d bytes, %v", n, op, len(msg), err)
+		}
+	}
+}
+
+func TestWSReadMessage(t *testing.T) {
+	c, out := wsServer(t,
+		wsClientFrame(false, wsText, []byte(`{"type":`)),
+		wsClientFrame(true, wsPing, []byte("are you there")),
+		wsClientFrame(false, wsContinuation, []byte(`"run"`)),
+		wsClientFrame(true, wsPong, nil),
+		wsClientFrame(true, wsContinuation, []byte(`}`)),
+		wsClientFrame(true, wsClose, []byte{0x03, 0xe8, 'b', 'y', 'e'}),
+	)
+
+	op, msg, err := c.readMessage()
+	if err != nil || op != wsText || string(msg) != `{"type":"run"}` {
+		t.Fatalf("got op %d %q, %v", op, msg, err)
+	}
+	if pong := <-out; pong[0] != wsPong || string(pong[1:]) != "are you there" {
+		t.Errorf("ping answered with op %d %q", pong[0], pong[1:])
+	}
+	op, msg, err = c.readMessage()
+	if err != nil || op != wsClose || !bytes.Equal(msg, []byte{0x03, 0xe8, 'b', 'y', 'e'}) {
+		t.Errorf("got op %d %q, %v, want the close frame", op, msg, err)
+	}
+	if _, _, err = c.readMessage(); err != io.EOF {
+		t.Errorf("after the last frame: %v", err)
+	}
+
+	c.close(1000, "done")
+	if got := <-out; got[0] != wsClose || !bytes.Equal(got[1:], []byte{0x03, 0xe8, 'd', 'o', 'n', 'e'}) {
+		t.Errorf("close frame op %d %q", got[0], got[1:])
+	}
+}
+
+func TestWSReadMessageInvalid(t *testing.T) {
+	unmasked := wsClientFrame(true, wsText, []byte("hi"))
+	unmasked[1] &^= 0x80
+	reserved := wsClientFrame(true, wsText, []byte("hi"))
+	reserved[0] |= 0x40
+	huge := binary.BigEndian.AppendUint64([]byte{0x80 | wsBinary, 0x80 | 127}, uint64(maxBodyBytes)+1)
+
+	tests := []struct {
+		name    string
+		frames  [][]byte
+		wantErr string
+	}{
+		{"unmasked", [][]byte{unmasked}, "unmasked frame"},
+		{"reserved bits", [][]byte{reserved}, "reserved bits"},
+		{"long ping", [][]byte{wsClientFrame(true, wsPing, make([]byte, 126))}, "invalid control frame"},
+		{"fragmented close", [][]byte{wsClientFrame(false, wsClose, nil)}, "invalid control frame"},
+		{"continuation first", [][]byte{wsClientFrame(true, wsContinuation, []byte("x"))}, "unexpected continuation"},
+		{"interleaved message", [][]byte{wsClientFrame(false, wsText, []byte("a")), wsClientFrame(true, wsText, []byte("b"))}, "expected a continuation"},
+		{"unknown opcode", [][]byte{wsClientFrame(true, 0x3, []byte("x"))}, "unknown opcode"},
+		{"too large", [][]byte{huge}, "message too large"},
+		{"too large in fragments", [][]byte{
+			wsClientFrame(false, wsText, make([]byte, maxBodyBytes/2+1)),
+			wsClientFrame(true, wsContinuation, make([]byte, maxBodyBytes/2+1)),
+		}, "message too large"},
+		{"truncated", [][]byte{wsClientFrame(true, wsText, []byte("hello"))[:8]}, "EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := wsServer(t, tt.frames...)
+			if _, _, err := c.readMessage(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
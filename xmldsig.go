@@ -0,0 +1,446 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers crypto.SHA256
+	_ "crypto/sha512" // registers crypto.SHA512
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// --- XML signatures ---
+//
+// Just enough XML-DSig to check the signatures of a SAML identity provider:
+// an enveloped signature over its parent element, referenced by ID, with
+// exclusive canonicalization (without comments), RSA and SHA-256/SHA-512.
+// The document is parsed once into a small tree that keeps the namespace
+// prefixes, so the canonical form can be rebuilt and the checked element is
+// the one read afterwards.
+
+const (
+	nsXML     = "http://www.w3.org/XML/1998/namespace"
+	nsDSig    = "http://www.w3.org/2000/09/xmldsig#"
+	nsExcC14N = "http://www.w3.org/2001/10/xml-exc-c14n#"
+
+	algEnveloped = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+)
+
+var signatureHashes = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmldsig-more#rsa-sha512": crypto.SHA512,
+}
+
+var digestHashes = map[string]crypto.Hash{
+	"http://www.w3.org/2001/04/xmlenc#sha256": crypto.SHA256,
+	"http://www.w3.org/2001/04/xmlenc#sha512": crypto.SHA512,
+}
+
+// xmlNode is an element with its prefixes as written.
+type xmlNode struct {
+	parent   *xmlNode
+	prefix   string
+	local    string
+	attrs    []xmlAttr // including namespace declarations
+	children []xmlChild
+}
+
+type xmlAttr struct {
+	prefix, local, value string
+}
+
+// xmlChild is either an element or text.
+type xmlChild struct {
+	elem *xmlNode
+	text string
+}
+
+// parseXML parses a document into an xmlNode tree. DTDs are rejected;
+// comments and processing instructions are dropped.
+func parseXML(data []byte) (*xmlNode, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var root, cur *xmlNode
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if cur == nil && root != nil {
+				return nil, errors.New("xml: more than one root element")
+			}
+			n := &xmlNode{parent: cur, prefix: t.Name.Space, local: t.Name.Local}
+			for _, a := range t.Attr {
+				n.attrs = append(n.attrs, xmlAttr{a.Name.Space, a.Name.Local, a.Value})
+			}
+			if cur == nil {
+				root = n
+			} else {
+				cur.children = append(cur.children, xmlChild{elem: n})
+			}
+			cur = n
+		case xml.EndElement:
+			if cur == nil || t.Name.Space != cur.prefix || t.Name.Local != cur.local {
+				return nil, fmt.Errorf("xml: unexpected </%s>", t.Name.Local)
+			}
+			cur = cur.parent
+		case xml.CharData:
+			if cur != nil {
+				cur.children = append(cur.children, xmlChild{text: string(t)})
+			}
+		case xml.Directive:
+			return nil, errors.New("xml: DTDs are not allowed")
+		}
+	}
+	if root == nil || cur != nil {
+		return nil, errors.New("xml: incomplete document")
+	}
+	return root, nil
+}
+
+// lookupNS resolves a prefix ("" for the default namespace) at n.
+func (n *xmlNode) lookupNS(prefix string) string {
+	if prefix == "xml" {
+		return nsXML
+	}
+	for e := n; e != nil; e = e.parent {
+		for _, a := range e.attrs {
+			if (prefix == "" && a.prefix == "" && a.local == "xmlns") || (prefix != "" && a.prefix == "xmlns" && a.local == prefix) {
+				return a.value
+			}
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) is(space, local string) bool {
+	return n != nil && n.local == local && n.lookupNS(n.prefix) == space
+}
+
+// child returns the first child element named space:local, or nil.
+func (n *xmlNode) child(space, local string) *xmlNode {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.children {
+		if c.elem.is(space, local) {
+			return c.elem
+		}
+	}
+	return nil
+}
+
+func (n *xmlNode) childrenNamed(space, local string) []*xmlNode {
+	var out []*xmlNode
+	if n == nil {
+		return out
+	}
+	for _, c := range n.children {
+		if c.elem.is(space, local) {
+			out = append(out, c.elem)
+		}
+	}
+	return out
+}
+
+// attr returns an unprefixed attribute.
+func (n *xmlNode) attr(local string) string {
+	if n == nil {
+		return ""
+	}
+	for _, a := range n.attrs {
+		if a.prefix == "" && a.local == local {
+			return a.value
+		}
+	}
+	return ""
+}
+
+// text returns the element's own text, trimmed.
+func (n *xmlNode) text() string {
+	if n == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, c := range n.children {
+		if c.elem == nil {
+			sb.WriteString(c.text)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// checkUniqueIDs rejects documents that reuse an ID attribute, so a
+// reference can't be pointed at a different element than the one read.
+func checkUniqueIDs(root *xmlNode) error {
+	seen := map[string]bool{}
+	var walk func(n *xmlNode) error
+	walk = func(n *xmlNode) error {
+		if id := n.attr("ID"); id != "" {
+			if seen[id] {
+				return fmt.Errorf("duplicate ID %q", id)
+			}
+			seen[id] = true
+		}
+		for _, c := range n.children {
+			if c.elem != nil {
+				if err := walk(c.elem); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(root)
+}
+
+// --- Exclusive canonicalization ---
+
+// canonicalize writes n in exclusive XML canonical form, leaving out skip
+// (the enveloped signature). inclusive lists the prefixes of the
+// InclusiveNamespaces PrefixList ("#default" for the default namespace).
+func canonicalize(n *xmlNode, inclusive []string, skip *xmlNode) []byte {
+	scope := map[string]string{}
+	var chain []*xmlNode
+	for e := n.parent; e != nil; e = e.parent {
+		chain = append(chain, e)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		declare(scope, chain[i])
+	}
+	incl := map[string]bool{}
+	for _, p := range inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		incl[p] = true
+	}
+	var buf bytes.Buffer
+	c14nElement(&buf, n, scope, map[string]string{"": ""}, incl, skip)
+	return buf.Bytes()
+}
+
+func declare(scope map[string]string, n *xmlNode) {
+	for _, a := range n.attrs {
+		if a.prefix == "" && a.local == "xmlns" {
+			scope[""] = a.value
+		} else if a.prefix == "xmlns" {
+			scope[a.local] = a.value
+		}
+	}
+}
+
+func c14nElement(buf *bytes.Buffer, n *xmlNode, parentScope, rendered map[string]string, incl map[string]bool, skip *xmlNode) {
+	scope := make(map[string]string, len(parentScope))
+	for k, v := range parentScope {
+		scope[k] = v
+	}
+	declare(scope, n)
+
+	// Namespaces visibly used here (or listed as inclusive) that the output
+	// ancestors haven't already declared with the same value.
+	used := map[string]bool{n.prefix: true}
+	for _, a := range n.attrs {
+		if a.prefix != "" && a.prefix != "xmlns" {
+			used[a.prefix] = true
+		}
+	}
+	for p := range incl {
+		if _, ok := scope[p]; ok {
+			used[p] = true
+		}
+	}
+	var prefixes []string
+	for p := range used {
+		if p == "xml" {
+			continue
+		}
+		if v, ok := rendered[p]; !ok || v != scope[p] {
+			prefixes = append(prefixes, p)
+		}
+	}
+	sort.Strings(prefixes)
+	if len(prefixes) > 0 {
+		next := make(map[string]string, len(rendered)+len(prefixes))
+		for k, v := range rendered {
+			next[k] = v
+		}
+		for _, p := range prefixes {
+			next[p] = scope[p]
+		}
+		rendered = next
+	}
+
+	type attr struct{ space, name, value string }
+	var attrs []attr
+	for _, a := range n.attrs {
+		if a.prefix == "xmlns" || (a.prefix == "" && a.local == "xmlns") {
+			continue
+		}
+		name, space := a.local, ""
+		if a.prefix != "" {
+			name = a.prefix + ":" + a.local
+			if a.prefix == "xml" {
+				space = nsXML
+			} else {
+				space = scope[a.prefix]
+			}
+		}
+		attrs = append(attrs, attr{space, name, a.value})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return localName(attrs[i].name) < localName(attrs[j].name)
+	})
+
+	qname := n.local
+	if n.prefix != "" {
+		qname = n.prefix + ":" + n.local
+	}
+	buf.WriteString("<" + qname)
+	for _, p := range prefixes {
+		if p == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(" xmlns:" + p + `="`)
+		}
+		buf.WriteString(escapeC14NAttr(scope[p]) + `"`)
+	}
+	for _, a := range attrs {
+		buf.WriteString(" " + a.name + `="` + escapeC14NAttr(a.value) + `"`)
+	}
+	buf.WriteString(">")
+	for _, c := range n.children {
+		switch {
+		case c.elem == nil:
+			buf.WriteString(escapeC14NText(c.text))
+		case c.elem != skip:
+			c14nElement(buf, c.elem, scope, rendered, incl, skip)
+		}
+	}
+	buf.WriteString("</" + qname + ">")
+}
+
+func localName(qname string) string {
+	if i := strings.IndexByte(qname, ':'); i >= 0 {
+		return qname[i+1:]
+	}
+	return qname
+}
+
+var (
+	c14nTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	c14nAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeC14NText(s string) string { return c14nTextEscaper.Replace(s) }
+func escapeC14NAttr(s string) string { return c14nAttrEscaper.Replace(s) }
+
+// --- Verification ---
+
+var errNotSigned = errors.New("not signed")
+
+// verifyEnveloped checks the ds:Signature child of el, which must sign el
+// itself (by its ID), with one of certs. It returns errNotSigned if el has
+// no signature.
+func verifyEnveloped(el *xmlNode, certs []*x509.Certificate) error {
+	sigs := el.childrenNamed(nsDSig, "Signature")
+	if len(sigs) == 0 {
+		return errNotSigned
+	}
+	if len(sigs) > 1 {
+		return errors.New("more than one signature")
+	}
+	sig := sigs[0]
+	si := sig.child(nsDSig, "SignedInfo")
+	if si == nil {
+		return errors.New("signature has no SignedInfo")
+	}
+
+	cm := si.child(nsDSig, "CanonicalizationMethod")
+	if cm.attr("Algorithm") != nsExcC14N {
+		return fmt.Errorf("unsupported canonicalization %q", cm.attr("Algorithm"))
+	}
+	sigHash, ok := signatureHashes[si.child(nsDSig, "SignatureMethod").attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("unsupported signature method %q", si.child(nsDSig, "SignatureMethod").attr("Algorithm"))
+	}
+
+	refs := si.childrenNamed(nsDSig, "Reference")
+	if len(refs) != 1 {
+		return errors.New("signature must have exactly one reference")
+	}
+	ref := refs[0]
+	if id := el.attr("ID"); id == "" || ref.attr("URI") != "#"+id {
+		return errors.New("signature does not reference the signed element")
+	}
+	var (
+		enveloped, c14n bool
+		inclusive       []string
+	)
+	for _, t := range ref.child(nsDSig, "Transforms").childrenNamed(nsDSig, "Transform") {
+		switch t.attr("Algorithm") {
+		case algEnveloped:
+			enveloped = true
+		case nsExcC14N:
+			c14n = true
+			inclusive = inclusivePrefixes(t)
+		default:
+			return fmt.Errorf("unsupported transform %q", t.attr("Algorithm"))
+		}
+	}
+	if !enveloped || !c14n {
+		return errors.New("signature must be enveloped with exclusive canonicalization")
+	}
+	digestHash, ok := digestHashes[ref.child(nsDSig, "DigestMethod").attr("Algorithm")]
+	if !ok {
+		return fmt.Errorf("unsupported digest method %q", ref.child(nsDSig, "DigestMethod").attr("Algorithm"))
+	}
+
+	want, err := decodeBase64XML(ref.child(nsDSig, "DigestValue").text())
+	if err != nil {
+		return fmt.Errorf("digest value: %w", err)
+	}
+	h := digestHash.New()
+	h.Write(canonicalize(el, inclusive, sig))
+	if subtle.ConstantTimeCompare(h.Sum(nil), want) != 1 {
+		return errors.New("digest mismatch")
+	}
+
+	value, err := decodeBase64XML(sig.child(nsDSig, "SignatureValue").text())
+	if err != nil {
+		return fmt.Errorf("signature value: %w", err)
+	}
+	h = sigHash.New()
+	h.Write(canonicalize(si, inclusivePrefixes(cm), nil))
+	hashed := h.Sum(nil)
+	for _, cert := range certs {
+		if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(pub, sigHash, hashed, value) == nil {
+			return nil
+		}
+	}
+	return errors.New("signature does not match the identity provider's certificate")
+}
+
+func inclusivePrefixes(transform *xmlNode) []string {
+	return strings.Fields(transform.child(nsExcC14N, "InclusiveNamespaces").attr("PrefixList"))
+}
+
+// decodeBase64XML decodes base64 content that may be wrapped over lines.
+func decodeBase64XML(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
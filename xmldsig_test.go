@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		doc       string
+		id        string // the element to canonicalize, the root if empty
+		inclusive []string
+		want      string
+	}{
+		{
+			// the example of the Exclusive XML Canonicalization spec, §2.2
+			name: "spec example, unused ancestor namespaces",
+			doc: `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 ID="e" xmlns:n1="http://example.net" xml:lang="en">
+    <n3:stuff xmlns:n3="ftp://example.org"/>
+  </n1:elem2></n0:local>`,
+			id: "e",
+			want: `<n1:elem2 xmlns:n1="http://example.net" ID="e" xml:lang="en">
+    <n3:stuff xmlns:n3="ftp://example.org"></n3:stuff>
+  </n1:elem2>`,
+		},
+		{
+			name: "spec example, xml attributes of ancestors aren't inherited",
+			doc: `<n2:pdu xmlns:n1="http://example.com" xmlns:n2="http://foo.example" xml:lang="fr" xml:space="retain"><n1:elem2 ID="e" xmlns:n1="http://example.net" xml:lang="en">
+    <n3:stuff xmlns:n3="ftp://example.org"/>
+  </n1:elem2></n2:pdu>`,
+			id: "e",
+			want: `<n1:elem2 xmlns:n1="http://example.net" ID="e" xml:lang="en">
+    <n3:stuff xmlns:n3="ftp://example.org"></n3:stuff>
+  </n1:elem2>`,
+		},
+		{
+			name: "namespaces by prefix, attributes by namespace then name",
+			doc:  `<a xmlns="urn:d" xmlns:z="urn:z" xmlns:b="urn:b" z:attr="1" b:attr="2" plain="3"/>`,
+			want: `<a xmlns="urn:d" xmlns:b="urn:b" xmlns:z="urn:z" plain="3" b:attr="2" z:attr="1"></a>`,
+		},
+		{
+			name: "unused namespace dropped",
+			doc:  `<r xmlns:u="urn:u"><c/></r>`,
+			want: `<r><c></c></r>`,
+		},
+		{
+			name:      "inclusive prefix kept",
+			doc:       `<r xmlns:u="urn:u"><c/></r>`,
+			inclusive: []string{"u"},
+			want:      `<r xmlns:u="urn:u"><c></c></r>`,
+		},
+		{
+			name: "namespace declared once where first used",
+			doc:  `<a xmlns:p="urn:p"><p:b><p:c xmlns:p="urn:p"/></p:b></a>`,
+			want: `<a><p:b xmlns:p="urn:p"><p:c></p:c></p:b></a>`,
+		},
+		{
+			name: "redeclared prefix rendered again",
+			doc:  `<p:a xmlns:p="urn:1"><p:b xmlns:p="urn:2"/></p:a>`,
+			want: `<p:a xmlns:p="urn:1"><p:b xmlns:p="urn:2"></p:b></p:a>`,
+		},
+		{
+			name: "default namespace undeclared",
+			doc:  `<a xmlns="urn:a"><b xmlns=""/></a>`,
+			want: `<a xmlns="urn:a"><b xmlns=""></b></a>`,
+		},
+		{
+			name: "empty default namespace not rendered at the top",
+			doc:  `<a xmlns=""><b/></a>`,
+			want: `<a><b></b></a>`,
+		},
+		{
+			name: "comments and processing instructions removed",
+			doc:  `<a><!-- note -->x<?pi data?>y</a>`,
+			want: `<a>xy</a>`,
+		},
+		{
+			name: "text and attribute escaping",
+			doc:  `<a v="x&#9;&quot;y&lt;&amp;&#10;">1 &lt; 2 &amp;&amp; 3 &gt; 2&#13;</a>`,
+			want: `<a v="x&#x9;&quot;y&lt;&amp;&#xA;">1 &lt; 2 &amp;&amp; 3 &gt; 2&#xD;</a>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseXML([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := root
+			if tt.id != "" {
+				n = findID(root, tt.id)
+			}
+			if got := string(canonicalize(n, tt.inclusive, nil)); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseXMLRejects(t *testing.T) {
+	for name, doc := range map[string]string{
+		"DTD":          `<!DOCTYPE a [<!ENTITY x "y">]><a>&x;</a>`,
+		"two roots":    `<a/><b/>`,
+		"unclosed":     `<a><b></a>`,
+		"empty":        ``,
+		"mismatched":   `<a></b>`,
+		"trailing end": `<a></a></a>`,
+	} {
+		if _, err := parseXML([]byte(doc)); err == nil {
+			t.Errorf("%s: parsed %q", name, doc)
+		}
+	}
+}
+
+func TestCheckUniqueIDs(t *testing.T) {
+	root, _ := parseXML([]byte(`<a ID="1"><b ID="2"/><c><d ID="1"/></c></a>`))
+	if err := checkUniqueIDs(root); err == nil {
+		t.Error("duplicate ID accepted")
+	}
+	root, _ = parseXML([]byte(`<a ID="1"><b ID="2"/><c><d ID="3"/></c></a>`))
+	if err := checkUniqueIDs(root); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyEnveloped(t *testing.T) {
+	key, other := testKey(t, 0), testKey(t, 1)
+	certs := []*x509.Certificate{{PublicKey: &key.PublicKey}}
+	const doc = `<r:Root xmlns:r="urn:r"><r:Signed ID="s1"><!--SIG:s1--><r:Value a="1">text</r:Value></r:Signed></r:Root>`
+
+	tests := []struct {
+		name    string
+		doc     func() string
+		wantErr string // "" if valid
+	}{
+		{"valid", func() string { return signXML(t, doc, "s1", key) }, ""},
+		{"not signed", func() string { return stripMarkers(doc) }, "not signed"},
+		{"other key", func() string { return signXML(t, doc, "s1", other) }, "does not match"},
+		{"content changed", func() string {
+			return strings.Replace(signXML(t, doc, "s1", key), ">text<", ">forged<", 1)
+		}, "digest mismatch"},
+		{"attribute changed", func() string {
+			return strings.Replace(signXML(t, doc, "s1", key), `a="1"`, `a="2"`, 1)
+		}, "digest mismatch"},
+		{"signed info changed", func() string {
+			return strings.Replace(signXML(t, doc, "s1", key), "xmlenc#sha256", "xmlenc#sha512", 1)
+		}, "digest mismatch"},
+		{"reference to another element", func() string {
+			return strings.Replace(signXML(t, doc, "s1", key), `URI="#s1"`, `URI="#other"`, 1)
+		}, "does not reference"},
+		{"unsupported transform", func() string {
+			return strings.Replace(signXML(t, doc, "s1", key), "xmldsig#enveloped-signature", "xmldsig#base64", 1)
+		}, "unsupported transform"},
+		{"inclusive canonicalization", func() string {
+			return strings.Replace(signXML(t, doc, "s1", key), `<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>`,
+				`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/TR/2001/REC-xml-c14n-20010315"/>`, 1)
+		}, "unsupported canonicalization"},
+		{"two signatures", func() string {
+			signed := signXML(t, doc, "s1", key)
+			i, j := strings.Index(signed, "<ds:Signature "), strings.Index(signed, "</ds:Signature>")+len("</ds:Signature>")
+			return signed[:j] + signed[i:j] + signed[j:]
+		}, "more than one signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := parseXML([]byte(tt.doc()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = verifyEnveloped(findID(root, "s1"), certs)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// --- helpers ---
+
+var (
+	testKeysOnce sync.Once
+	testKeys     [2]*rsa.PrivateKey
+)
+
+// testKey returns one of two RSA keys made once per test run.
+func testKey(t *testing.T, i int) *rsa.PrivateKey {
+	t.Helper()
+	testKeysOnce.Do(func() {
+		for i := range testKeys {
+			k, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				panic(err)
+			}
+			testKeys[i] = k
+		}
+	})
+	return testKeys[i]
+}
+
+// findID returns the element with the ID attribute id, or nil.
+func findID(n *xmlNode, id string) *xmlNode {
+	if n.attr("ID") == id {
+		return n
+	}
+	for _, c := range n.children {
+		if c.elem != nil {
+			if f := findID(c.elem, id); f != nil {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+const testSignature = `<ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo>` +
+	`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>` +
+	`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"/>` +
+	`<ds:Reference URI="#ID"><ds:Transforms>` +
+	`<ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"/>` +
+	`<ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/></ds:Transforms>` +
+	`<ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"/>` +
+	`<ds:DigestValue>DIGEST</ds:DigestValue></ds:Reference></ds:SignedInfo>` +
+	`<ds:SignatureValue>SIGVALUE</ds:SignatureValue></ds:Signature>`
+
+// signXML puts an enveloped signature by key of the element with ID id in
+// place of its <!--SIG:id--> marker. Sign inner elements first.
+func signXML(t *testing.T, doc, id string, key *rsa.PrivateKey) string {
+	t.Helper()
+	doc = strings.Replace(doc, "<!--SIG:"+id+"-->", strings.ReplaceAll(testSignature, "#ID", "#"+id), 1)
+
+	root, err := parseXML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	el := findID(root, id)
+	sig := el.child(nsDSig, "Signature")
+	digest := sha256.Sum256(canonicalize(el, nil, sig))
+	doc = strings.Replace(doc, "DIGEST", base64.StdEncoding.EncodeToString(digest[:]), 1)
+
+	root, err = parseXML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	si := findID(root, id).child(nsDSig, "Signature").child(nsDSig, "SignedInfo")
+	hashed := sha256.Sum256(canonicalize(si, nil, nil))
+	value, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Replace(doc, "SIGVALUE", base64.StdEncoding.EncodeToString(value), 1)
+}
+
+// stripMarkers removes the signature markers left in doc.
+func stripMarkers(doc string) string {
+	for {
+		i := strings.Index(doc, "<!--SIG:")
+		if i < 0 {
+			return doc
+		}
+		doc = doc[:i] + doc[i+strings.Index(doc[i:], "-->")+3:]
+	}
+}